@@ -7,9 +7,22 @@ package aes256
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"errors"
 	"io"
 )
 
+// ErrKeyLength is returned by GCMEncrypt and GCMDecrypt, if the supplied key
+// is not 32 bytes long.
+var ErrKeyLength = errors.New("aes256: AES-256 key is not 32 bytes long")
+
+// ErrCiphertextTooShort is returned by GCMDecrypt, if the ciphertext cannot
+// contain a nonce and an authentication tag.
+var ErrCiphertextTooShort = errors.New("aes256: ciphertext too short")
+
+// ErrAuthentication is returned by GCMDecrypt, if the ciphertext (or the
+// additional data) could not be authenticated.
+var ErrAuthentication = errors.New("aes256: message authentication failed")
+
 // CBCEncrypt encrypts the given plaintext with AES-256 in CBC mode.
 // The supplied key must be 32 bytes long.
 // The returned ciphertext is prepended by a randomly generated IV.
@@ -133,3 +146,56 @@ func CTRStream(key, iv []byte) cipher.Stream {
 	block, _ := aes.NewCipher(key) // correct key length was enforced above
 	return cipher.NewCTR(block, iv)
 }
+
+// newGCM returns AES-256 in GCM mode for the given key, which must be 32
+// bytes long.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrKeyLength
+	}
+	block, _ := aes.NewCipher(key) // correct key length was enforced above
+	return cipher.NewGCM(block)
+}
+
+// GCMEncrypt encrypts and authenticates the given plaintext and authenticates
+// the additionalData with AES-256 in GCM mode. The supplied key must be 32
+// bytes long. The returned ciphertext is prepended by a randomly generated
+// 12-byte nonce.
+func GCMEncrypt(
+	key, plaintext, additionalData []byte,
+	rand io.Reader,
+) (ciphertext []byte, err error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	// The nonce must never be reused with the same key. With random nonces
+	// of 12 bytes, that holds for up to 2^32 messages per key.
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// GCMDecrypt decrypts and authenticates the given ciphertext and authenticates
+// the additionalData with AES-256 in GCM mode and returns the resulting
+// plaintext. The supplied key must be 32 bytes long and the ciphertext must be
+// prepended by the corresponding nonce. If the authentication fails,
+// ErrAuthentication is returned.
+func GCMDecrypt(key, ciphertext, additionalData []byte) (plaintext []byte, err error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCiphertextTooShort
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	plaintext, err = aead.Open(nil, nonce, ciphertext[aead.NonceSize():],
+		additionalData)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plaintext, nil
+}
@@ -122,3 +122,46 @@ func TestAESCTRStreamShortIV(t *testing.T) {
 	defer shouldPanic(t)
 	_ = CTRStream(key, shortIV)
 }
+
+func TestAESGCM(t *testing.T) {
+	ad := []byte("additional data")
+	ciphertext, err := GCMEncrypt(key, []byte(secret), ad, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ciphertext) != 12+len(secret)+16 {
+		t.Errorf("len(ciphertext) == %d", len(ciphertext))
+	}
+	plaintext, err := GCMDecrypt(key, ciphertext, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != secret {
+		t.Error("GCM: plaintext != secret")
+	}
+	// tampered ciphertext
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := GCMDecrypt(key, tampered, ad); err != ErrAuthentication {
+		t.Error("GCM: tampered ciphertext should fail authentication")
+	}
+	// wrong additional data
+	if _, err := GCMDecrypt(key, ciphertext, nil); err != ErrAuthentication {
+		t.Error("GCM: wrong additional data should fail authentication")
+	}
+}
+
+func TestAESGCMFailures(t *testing.T) {
+	if _, err := GCMEncrypt(shortKey, []byte(secret), nil, rand.Reader); err != ErrKeyLength {
+		t.Error("GCMEncrypt: short key should fail")
+	}
+	if _, err := GCMEncrypt(key, []byte(secret), nil, cipher.RandFail); err == nil {
+		t.Error("GCMEncrypt: rand failure should fail")
+	}
+	if _, err := GCMDecrypt(shortKey, []byte(secret), nil); err != ErrKeyLength {
+		t.Error("GCMDecrypt: short key should fail")
+	}
+	if _, err := GCMDecrypt(key, []byte(shortSecret), nil); err != ErrCiphertextTooShort {
+		t.Error("GCMDecrypt: short ciphertext should fail")
+	}
+}
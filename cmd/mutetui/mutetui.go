@@ -9,7 +9,6 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/frankbraun/codechain/util/home"
 	"github.com/mutecomm/mute/def/version"
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/release"
@@ -18,7 +17,7 @@ import (
 )
 
 var (
-	defaultHomeDir = home.AppDataDir("mute", false)
+	defaultHomeDir = util.AppDataDir("mute")
 	defaultLogDir  = filepath.Join(defaultHomeDir, "log")
 )
 
@@ -7,17 +7,20 @@ package configclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/mutecomm/mute/configclient/cahash"
 	"github.com/mutecomm/mute/configclient/roundrobin"
 	"github.com/mutecomm/mute/configclient/sortedmap"
+	"github.com/mutecomm/mute/util/dialer"
 	"github.com/mutecomm/mute/util/times"
 )
 
@@ -59,6 +62,7 @@ func (c *Config) Update() error {
 	ts := roundrobin.ParseServers(c.URLList)
 	sort.Sort(ts)
 	c.servers = ts.Order()
+	c.curServer = 0
 	if len(c.servers) < 1 {
 		return ErrNoServers
 	}
@@ -109,6 +113,50 @@ GetConfigLoop:
 	return nil
 }
 
+// httpClient is the HTTP client shared by all configuration fetches, it is
+// created only once (see getHTTPClient).
+var httpClient struct {
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+// getHTTPClient returns the HTTP client shared by all configuration fetches,
+// which reuses the connections of previous calls (see
+// dialer.PooledTransport). Timeouts are set per request.
+func getHTTPClient() (*http.Client, error) {
+	httpClient.once.Do(func() {
+		tr, err := dialer.PooledTransport(nil)
+		if err != nil {
+			httpClient.err = err
+			return
+		}
+		httpClient.client = &http.Client{Transport: tr}
+	})
+	return httpClient.client, httpClient.err
+}
+
+// get returns the body of getURL fetched with the shared HTTP client. Timeout
+// is in seconds.
+func get(getURL string, timeout int64) ([]byte, error) {
+	c, err := getHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Second*time.Duration(timeout))
+	defer cancel()
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return readBody(resp.Body)
+}
+
 func readBody(rc io.ReadCloser) ([]byte, error) {
 	defer rc.Close()
 	p, err := ioutil.ReadAll(&io.LimitedReader{R: rc, N: MaxReadBody})
@@ -130,12 +178,7 @@ func fixURL(URL string) string {
 // consideration. Timeout is in seconds. Configuration can be accessed via
 // cert.Config (map[string]string).
 func getConfig(configURL string, publicKey []byte, lastSignDate uint64, timeout int64) (cert *sortedmap.SignedMap, err error) {
-	c := &http.Client{Timeout: time.Second * time.Duration(timeout)}
-	resp, err := c.Get(fixURL(configURL) + "config")
-	if err != nil {
-		return nil, err
-	}
-	p, err := readBody(resp.Body)
+	p, err := get(fixURL(configURL)+"config", timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -145,12 +188,7 @@ func getConfig(configURL string, publicKey []byte, lastSignDate uint64, timeout
 // getCACert returns the ca certificate (verified). certHash is from
 // GetConfig().Config["CACertHash"]
 func getCACert(configURL string, certHash string, timeout int64) ([]byte, error) {
-	c := &http.Client{Timeout: time.Second * time.Duration(timeout)}
-	resp, err := c.Get(fixURL(configURL) + "cacert")
-	if err != nil {
-		return nil, err
-	}
-	p, err := readBody(resp.Body)
+	p, err := get(fixURL(configURL)+"cacert", timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -7,7 +7,10 @@ package configclient
 import (
 	"encoding/hex"
 	"flag"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 var pubkeyStr = "f6b5289bbe4bfc678b1f670b3b2a4bc837f052108092ca926d09f7afca9f485f"
@@ -16,7 +19,6 @@ var server bool
 
 func init() {
 	flag.BoolVar(&server, "server", false, "run server tests")
-	flag.Parse()
 }
 
 func TestClient(t *testing.T) {
@@ -69,3 +71,42 @@ func TestUpdate(t *testing.T) {
 		t.Fatal("Map not set")
 	}
 }
+
+func TestGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+	for i := 0; i < 2; i++ {
+		body, err := get(srv.URL+"/config", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "body" {
+			t.Errorf("body == %q", body)
+		}
+	}
+	// the HTTP client is shared between calls
+	c1, err := getHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := getHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Error("HTTP client should be created only once")
+	}
+	// the timeout is enforced per request
+	if _, err := get(srv.URL+"/slow", 1); err == nil {
+		t.Error("get should time out")
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/uid"
+)
+
+func (ce *CryptEngine) cacheClear() {
+	ce.cache.Clear()
+	log.Info("cryptengine: cache cleared")
+}
+
+func (ce *CryptEngine) cacheStats(w io.Writer) {
+	stats := ce.cache.Stats()
+	fmt.Fprintf(w, "entries: %d\n", stats.Entries)
+	fmt.Fprintf(w, "hits:    %d\n", stats.Hits)
+	fmt.Fprintf(w, "misses:  %d\n", stats.Misses)
+}
+
+// invalidateCache invalidates the cache entry which depends on the changed
+// UID message msg. That is, if the key of a key server changed, the cached
+// client and capabilities of the key server are removed.
+func (ce *CryptEngine) invalidateCache(msg *uid.Message) {
+	if msg.Localpart() == "keyserver" {
+		log.Infof("cryptengine: key of key server '%s' changed, invalidate cache",
+			msg.Domain())
+		ce.cache.Remove(msg.Domain())
+	}
+}
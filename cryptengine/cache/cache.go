@@ -7,8 +7,10 @@
 package cache
 
 import (
+	"container/list"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/keyserver/capabilities"
@@ -18,17 +20,44 @@ import (
 )
 
 // A Cache caches key server capabilities and clients used for mutecrypt's
-// cryptengine.
+// cryptengine. The number of cached entries can be bounded, in which case the
+// least recently used entry is evicted, and entries can expire after a TTL.
 type Cache struct {
-	clients      map[string]*jsonclient.URLClient      // maps domain to JSON-RPC client
-	capabilities map[string]*capabilities.Capabilities // maps domain to
+	entries    map[string]*list.Element // maps domain to entry in lru
+	lru        *list.List               // most recently used entry at front
+	maxEntries int                      // maximum number of entries (0: unbounded)
+	ttl        time.Duration            // time to live of entries (0: no expiry)
+	now        func() time.Time         // returns current time
+	hits       int                      // number of cache hits
+	misses     int                      // number of cache misses
 }
 
-// New returns a new cache.
-func New() *Cache {
+// entry is a single cache entry.
+type entry struct {
+	domain       string
+	client       *jsonclient.URLClient // JSON-RPC client
+	capabilities *capabilities.Capabilities
+	expires      time.Time // zero, if entry doesn't expire
+}
+
+// Stats contains statistics about a Cache.
+type Stats struct {
+	Entries int // number of cached domains
+	Hits    int // number of cache hits
+	Misses  int // number of cache misses
+}
+
+// New returns a new cache which holds at most maxEntries many entries (the
+// least recently used entry is evicted, if necessary). Entries expire after
+// the given ttl. A maxEntries or ttl of 0 means unbounded or no expiry,
+// respectively.
+func New(maxEntries int, ttl time.Duration) *Cache {
 	return &Cache{
-		clients:      make(map[string]*jsonclient.URLClient),
-		capabilities: make(map[string]*capabilities.Capabilities),
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
 	}
 }
 
@@ -87,11 +116,74 @@ func (c *Cache) Set(domain, port, altHost, homedir string) error {
 		return err
 	}
 	// cache client and capabilities
-	c.clients[domain] = client
-	c.capabilities[domain] = &caps
+	c.Put(domain, client, &caps)
 	return nil
 }
 
+// Put caches the given JSON-RPC client and capabilities for domain.
+// If the cache is full, the least recently used entry is evicted.
+func (c *Cache) Put(
+	domain string,
+	client *jsonclient.URLClient,
+	caps *capabilities.Capabilities,
+) {
+	e := &entry{domain: domain, client: client, capabilities: caps}
+	if c.ttl > 0 {
+		e.expires = c.now().Add(c.ttl)
+	}
+	if elem, ok := c.entries[domain]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[domain] = c.lru.PushFront(e)
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		log.Debugf("cache: evict entry for domain %s", oldest.Value.(*entry).domain)
+		c.removeElement(oldest)
+	}
+}
+
+// get returns the cache entry for domain, or nil if no (unexpired) entry
+// exists. The entry is marked as most recently used.
+func (c *Cache) get(domain string) *entry {
+	elem, ok := c.entries[domain]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*entry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		log.Debugf("cache: entry for domain %s expired", domain)
+		c.removeElement(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return e
+}
+
+// removeElement removes the given element from the cache.
+func (c *Cache) removeElement(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).domain)
+}
+
+// lookup makes sure the capabilities for the given domain are cached and
+// updates the cache statistics. If no capabilities have been cached, the
+// cache is filled using the Set method.
+func (c *Cache) lookup(
+	domain, port, altHost, homedir string,
+) (*entry, error) {
+	if e := c.get(domain); e != nil {
+		c.hits++
+		return e, nil
+	}
+	c.misses++
+	if err := c.Set(domain, port, altHost, homedir); err != nil {
+		return nil, err
+	}
+	return c.get(domain), nil
+}
+
 // Get returns the cached JSON-RPC client and capabilities for the given
 // domain and makes sure that the requiredMethod is supported. If no client
 // has been cached, the cache is filled using the Set method with the given
@@ -100,20 +192,18 @@ func (c *Cache) Get(
 	domain, port, altHost, homedir, requiredMethod string,
 ) (*jsonclient.URLClient, *capabilities.Capabilities, error) {
 	// check/set cache
-	caps := c.capabilities[domain]
-	if caps == nil {
-		if err := c.Set(domain, port, altHost, homedir); err != nil {
-			return nil, nil, err
-		}
+	e, err := c.lookup(domain, port, altHost, homedir)
+	if err != nil {
+		return nil, nil, err
 	}
-	caps = c.capabilities[domain]
+	caps := e.capabilities
 	// check requiredMethod
 	if !util.ContainsString(caps.METHODS, requiredMethod) {
 		return nil, nil, log.Errorf("cache: key server %s does not support %s method", domain, requiredMethod)
 
 	}
 	// return client and capabilities from cache
-	client := c.clients[domain]
+	client := e.client
 	if client == nil {
 		panic(log.Criticalf("cache: key server client for domain %s undefined", domain))
 	}
@@ -126,13 +216,11 @@ func (c *Cache) Get(
 // parameters.
 func (c *Cache) ShowCapabilities(domain, port, altHost, homedir string) error {
 	// check/set cache
-	caps := c.capabilities[domain]
-	if caps == nil {
-		if err := c.Set(domain, port, altHost, homedir); err != nil {
-			return err
-		}
+	e, err := c.lookup(domain, port, altHost, homedir)
+	if err != nil {
+		return err
 	}
-	caps = c.capabilities[domain]
+	caps := e.capabilities
 	// pretty-print capabilities
 	jsn, err := json.MarshalIndent(caps, "", "  ")
 	if err != nil {
@@ -141,3 +229,28 @@ func (c *Cache) ShowCapabilities(domain, port, altHost, homedir string) error {
 	fmt.Println(string(jsn))
 	return nil
 }
+
+// Remove removes the cached JSON-RPC client and capabilities for the given
+// domain from the cache.
+func (c *Cache) Remove(domain string) {
+	if elem, ok := c.entries[domain]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Clear removes all entries from the cache and resets the statistics.
+func (c *Cache) Clear() {
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.hits = 0
+	c.misses = 0
+}
+
+// Stats returns statistics about the cache.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Entries: c.lru.Len(),
+		Hits:    c.hits,
+		Misses:  c.misses,
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/keyserver/capabilities"
+	"github.com/mutecomm/mute/util/jsonclient"
+)
+
+const method = "KeyRepository.FetchUID"
+
+func TestClear(t *testing.T) {
+	c := New(0, 0)
+	domain := "mute.berlin"
+	c.Put(domain, &jsonclient.URLClient{}, &capabilities.Capabilities{
+		METHODS: []string{method},
+	})
+	if _, _, err := c.Get(domain, "", "", "", method); err != nil {
+		t.Fatal(err)
+	}
+	stats := c.Stats()
+	if stats.Entries != 1 || stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("wrong stats after hit: %+v", stats)
+	}
+	c.Clear()
+	if stats := c.Stats(); stats.Entries != 0 || stats.Hits != 0 {
+		t.Errorf("wrong stats after clear: %+v", stats)
+	}
+	// lookup misses and tries to fill the cache (which fails, because the
+	// domain is not configured)
+	if _, _, err := c.Get(domain, "", "", "", method); err == nil {
+		t.Error("lookup after clear should miss")
+	}
+	if stats := c.Stats(); stats.Misses != 1 {
+		t.Errorf("wrong stats after miss: %+v", stats)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := New(0, 0)
+	caps := &capabilities.Capabilities{METHODS: []string{method}}
+	c.Put("a.mute.berlin", &jsonclient.URLClient{}, caps)
+	c.Put("b.mute.berlin", &jsonclient.URLClient{}, caps)
+	c.Remove("a.mute.berlin")
+	if _, _, err := c.Get("a.mute.berlin", "", "", "", method); err == nil {
+		t.Error("lookup of removed domain should miss")
+	}
+	if _, _, err := c.Get("b.mute.berlin", "", "", "", method); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	c := New(2, 0)
+	caps := &capabilities.Capabilities{METHODS: []string{method}}
+	c.Put("a.mute.berlin", &jsonclient.URLClient{}, caps)
+	c.Put("b.mute.berlin", &jsonclient.URLClient{}, caps)
+	// use a -> b is least recently used
+	if _, _, err := c.Get("a.mute.berlin", "", "", "", method); err != nil {
+		t.Fatal(err)
+	}
+	c.Put("c.mute.berlin", &jsonclient.URLClient{}, caps)
+	if stats := c.Stats(); stats.Entries != 2 {
+		t.Errorf("cache should be bounded to 2 entries: %+v", stats)
+	}
+	if _, _, err := c.Get("b.mute.berlin", "", "", "", method); err == nil {
+		t.Error("least recently used entry should have been evicted")
+	}
+	for _, domain := range []string{"a.mute.berlin", "c.mute.berlin"} {
+		if _, _, err := c.Get(domain, "", "", "", method); err != nil {
+			t.Errorf("entry for %s should be cached: %s", domain, err)
+		}
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	c := New(0, time.Hour)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	caps := &capabilities.Capabilities{METHODS: []string{method}}
+	c.Put("mute.berlin", &jsonclient.URLClient{}, caps)
+	now = now.Add(59 * time.Minute)
+	if _, _, err := c.Get("mute.berlin", "", "", "", method); err != nil {
+		t.Fatalf("entry should not have expired: %s", err)
+	}
+	now = now.Add(time.Minute)
+	if _, _, err := c.Get("mute.berlin", "", "", "", method); err == nil {
+		t.Error("entry should have expired")
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Misses != 1 {
+		t.Errorf("wrong stats after expiry: %+v", stats)
+	}
+}
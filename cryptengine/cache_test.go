@@ -0,0 +1,35 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/keyserver/capabilities"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/jsonclient"
+)
+
+func TestInvalidateCache(t *testing.T) {
+	ce := New()
+	caps := &capabilities.Capabilities{METHODS: []string{"KeyRepository.FetchUID"}}
+	ce.cache.Put("mute.berlin", &jsonclient.URLClient{}, caps)
+	ce.cache.Put("mute.one", &jsonclient.URLClient{}, caps)
+	msg, err := uid.Create("keyserver@mute.berlin", false, "", "", uid.Strict,
+		"", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce.invalidateCache(msg)
+	if stats := ce.cache.Stats(); stats.Entries != 1 {
+		t.Errorf("key change of key server should invalidate one entry: %+v",
+			stats)
+	}
+	_, _, err = ce.cache.Get("mute.berlin", "", "", "", "KeyRepository.FetchUID")
+	if err == nil {
+		t.Error("lookup of invalidated key server should miss")
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mutecomm/mute/uid"
+)
+
+// listCiphersuites writes all supported ciphersuites to w, one per line. The
+// default ciphersuite is marked as such.
+func listCiphersuites(w io.Writer) error {
+	for _, suite := range uid.Ciphersuites() {
+		if suite == uid.DefaultCiphersuite {
+			fmt.Fprintf(w, "%s (default)\n", suite)
+		} else {
+			fmt.Fprintln(w, suite)
+		}
+	}
+	return nil
+}
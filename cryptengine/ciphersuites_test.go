@@ -0,0 +1,27 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/uid"
+)
+
+func TestListCiphersuites(t *testing.T) {
+	var out bytes.Buffer
+	if err := listCiphersuites(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(uid.Ciphersuites()) {
+		t.Errorf("wrong number of ciphersuites: %s", out.String())
+	}
+	if lines[0] != uid.DefaultCiphersuite+" (default)" {
+		t.Errorf("default ciphersuite not listed first: %s", lines[0])
+	}
+}
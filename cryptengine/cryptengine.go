@@ -9,19 +9,27 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/frankbraun/codechain/util/bzero"
-	"github.com/frankbraun/codechain/util/home"
 	"github.com/mutecomm/mute/cryptengine/cache"
 	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/def/version"
 	"github.com/mutecomm/mute/encdb"
 	"github.com/mutecomm/mute/keydb"
 	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/uid"
 	"github.com/mutecomm/mute/util"
 	"github.com/mutecomm/mute/util/descriptors"
+	"github.com/mutecomm/mute/util/dialer"
+	"github.com/mutecomm/mute/util/engerr"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/mutecomm/mute/util/times"
 	"github.com/urfave/cli"
 )
 
@@ -32,13 +40,14 @@ const (
 )
 
 var (
-	defaultHomeDir = home.AppDataDir("mute", false)
+	defaultHomeDir = util.AppDataDir("mute")
 	defaultLogDir  = filepath.Join(defaultHomeDir, "log")
 	errExit        = errors.New("cryptengine: requests exit")
 )
 
 // CryptEngine abstracts a mutecrypt command engine.
 type CryptEngine struct {
+	mutex     sync.Mutex // serializes Start and Close
 	prepared  bool
 	fileTable *descriptors.Table
 	keydHost  string
@@ -46,6 +55,10 @@ type CryptEngine struct {
 	homedir   string
 	keyDB     *keydb.KeyDB
 	cache     *cache.Cache
+	keyWindow uint64       // see msg.DecryptArgs.KeyWindow
+	kiMaxAge  uint64       // maximum validity of generated KeyInits (in seconds)
+	tFormat   times.Format // format of printed times (see --time-format)
+	readOnly  bool         // keyDB is opened read-only (see --read-only)
 	app       *cli.App
 	err       error
 }
@@ -55,19 +68,56 @@ func (ce *CryptEngine) prepare(c *cli.Context, openKeyDB bool) error {
 		ce.keydHost = c.GlobalString("keyhost")
 		ce.keydPort = c.GlobalString("keyport")
 		ce.homedir = c.GlobalString("homedir")
+		if c.GlobalInt("cache-size") < 0 || c.GlobalDuration("cache-ttl") < 0 {
+			return log.Error("--cache-size and --cache-ttl must not be negative")
+		}
+		ce.cache = cache.New(c.GlobalInt("cache-size"), c.GlobalDuration("cache-ttl"))
+		if c.GlobalInt("key-window") < 1 {
+			return log.Error("--key-window must be positive")
+		}
+		ce.keyWindow = uint64(c.GlobalInt("key-window"))
+		if c.GlobalDuration("keyinit-validity") < time.Second {
+			return log.Error("--keyinit-validity must be at least one second")
+		}
+		ce.kiMaxAge = uint64(c.GlobalDuration("keyinit-validity") / time.Second)
+		tFormat, err := times.ParseFormat(c.GlobalString("time-format"))
+		if err != nil {
+			return log.Error(err)
+		}
+		ce.tFormat = tFormat
+		ce.readOnly = c.GlobalBool("read-only")
 
 		// create the necessary directories if they don't already exist
-		err := util.CreateDirs(c.GlobalString("homedir"), c.GlobalString("logdir"))
+		err = util.CreateDirs(c.GlobalString("homedir"), c.GlobalString("logdir"))
 		if err != nil {
 			return err
 		}
 
 		// initialize logging framework
+		log.SetPrivate(c.GlobalBool("private-logs"))
 		err = log.Init(c.GlobalString("loglevel"), "crypt",
 			c.GlobalString("logdir"), c.GlobalBool("logconsole"))
 		if err != nil {
 			return err
 		}
+		if err := i18n.SetLang(c.GlobalString("lang")); err != nil {
+			log.Warnf("cryptengine: %s (using English)", err)
+		}
+
+		// route all connections through proxy, if necessary
+		if err := dialer.SetProxy(c.GlobalString("proxy")); err != nil {
+			return err
+		}
+
+		// reuse connections across server calls
+		dialer.SetMaxIdle(c.GlobalInt("max-idle"))
+
+		// account network usage of the session (see mutectrl)
+		if c.GlobalInt64("data-cap") < 0 {
+			return log.Error("--data-cap must not be negative")
+		}
+		dialer.SetDataCap(c.GlobalInt64("data-cap"))
+		dialer.SetStatsFile(os.Getenv("MUTE_NETSTATS"))
 
 		// initialize file descriptors
 		ce.fileTable, err = descriptors.NewTable(c)
@@ -75,6 +125,13 @@ func (ce *CryptEngine) prepare(c *cli.Context, openKeyDB bool) error {
 			return err
 		}
 
+		// slow down brute-force attempts against the passphrase
+		delays, err := encdb.ParseDelays(c.GlobalString("passphrase-delays"))
+		if err != nil {
+			return log.Error(err)
+		}
+		encdb.SetLimiter(encdb.NewLimiter(delays))
+
 		// configure
 		if !c.GlobalBool("keyserver") {
 			if err := def.InitMuteFromFile(ce.homedir); err != nil {
@@ -160,21 +217,24 @@ func New() *CryptEngine {
 	ce.app.Version = version.Number
 	ce.app.Flags = []cli.Flag{
 		cli.StringFlag{
-			Name:  "homedir",
-			Value: defaultHomeDir,
-			Usage: "set home directory",
+			Name:   "homedir",
+			Value:  defaultHomeDir,
+			EnvVar: "MUTE_HOMEDIR",
+			Usage:  "set home directory",
 		},
 		cli.BoolFlag{
 			Name:  "keyserver",
 			Usage: "create key for key server",
 		},
 		cli.StringFlag{
-			Name:  "keyhost",
-			Usage: "alternative hostname for key server",
+			Name:   "keyhost",
+			EnvVar: "MUTE_KEYHOST",
+			Usage:  "alternative hostname for key server",
 		},
 		cli.StringFlag{
-			Name:  "keyport",
-			Usage: "alternative port for key server",
+			Name:   "keyport",
+			EnvVar: "MUTE_KEYPORT",
+			Usage:  "alternative port for key server",
 		},
 		descriptors.InputFDFlag,
 		descriptors.OutputFDFlag,
@@ -182,18 +242,88 @@ func New() *CryptEngine {
 		descriptors.PassphraseFDFlag,
 		descriptors.CommandFDFlag,
 		cli.StringFlag{
-			Name:  "loglevel",
-			Value: "info",
-			Usage: "logging level {trace, debug, info, warn, error, critical}",
+			Name:   "loglevel",
+			Value:  "info",
+			EnvVar: "MUTE_LOGLEVEL",
+			Usage:  "logging level {trace, debug, info, warn, error, critical}",
 		},
 		cli.StringFlag{
-			Name:  "logdir",
-			Value: defaultLogDir,
-			Usage: "directory to log output",
+			Name:   "logdir",
+			Value:  defaultLogDir,
+			EnvVar: "MUTE_LOGDIR",
+			Usage:  "directory to log output",
 		},
 		cli.BoolFlag{
-			Name:  "logconsole",
-			Usage: "enable logging to console",
+			Name:   "logconsole",
+			EnvVar: "MUTE_LOGCONSOLE",
+			Usage:  "enable logging to console",
+		},
+		cli.IntFlag{
+			Name:   "cache-size",
+			Value:  def.KeyServerCacheSize,
+			EnvVar: "MUTE_CACHE_SIZE",
+			Usage:  "maximum number of cached key servers (0: unbounded)",
+		},
+		cli.DurationFlag{
+			Name:   "cache-ttl",
+			Value:  def.KeyServerCacheTTL,
+			EnvVar: "MUTE_CACHE_TTL",
+			Usage:  "time to live of cached key server capabilities (0: no expiry)",
+		},
+		cli.IntFlag{
+			Name:   "key-window",
+			Value:  msg.MessageKeyWindow,
+			EnvVar: "MUTE_KEY_WINDOW",
+			Usage:  "number of old message keys retained for late messages",
+		},
+		cli.DurationFlag{
+			Name:   "keyinit-validity",
+			Value:  time.Duration(uid.MaxNotAfter) * time.Second,
+			EnvVar: "MUTE_KEYINIT_VALIDITY",
+			Usage:  "maximum validity of generated KeyInit messages",
+		},
+		cli.StringFlag{
+			Name:   "time-format",
+			Value:  string(times.RFC3339),
+			EnvVar: "MUTE_TIME_FORMAT",
+			Usage:  "format of printed times {rfc3339, unix, relative}",
+		},
+		cli.StringFlag{
+			Name:   "lang",
+			EnvVar: "MUTE_LANG",
+			Usage:  "language of user-facing messages {" + strings.Join(i18n.Languages(), ", ") + "}",
+		},
+		cli.StringFlag{
+			Name:   "passphrase-delays",
+			Value:  encdb.FormatDelays(encdb.DefaultDelays),
+			EnvVar: "MUTE_PASSPHRASE_DELAYS",
+			Usage:  "comma-separated delays before passphrase attempts after consecutive wrong passphrases (empty disables them)",
+		},
+		cli.BoolFlag{
+			Name:   "read-only",
+			EnvVar: "MUTE_READ_ONLY",
+			Usage:  "open keyDB read-only, all commands which modify it fail",
+		},
+		cli.BoolFlag{
+			Name:   "private-logs",
+			EnvVar: "MUTE_PRIVATE_LOGS",
+			Usage:  "mask identities in log output",
+		},
+		cli.StringFlag{
+			Name:   "proxy",
+			EnvVar: "MUTE_PROXY",
+			Usage:  "SOCKS5 proxy for all connections (e.g., socks5://127.0.0.1:9050 for Tor)",
+		},
+		cli.IntFlag{
+			Name:   "max-idle",
+			Value:  dialer.DefaultMaxIdle,
+			EnvVar: "MUTE_MAX_IDLE",
+			Usage:  "maximum number of idle connections per server kept for reuse (0 disables reuse)",
+		},
+		cli.Int64Flag{
+			Name:   "data-cap",
+			EnvVar: "MUTE_DATA_CAP",
+			Usage:  "maximum number of bytes transferred per session (0 means no cap)",
 		},
 	}
 	ce.app.Before = func(c *cli.Context) error {
@@ -206,6 +336,10 @@ func New() *CryptEngine {
 		Name:  "domain",
 		Usage: "key server domain",
 	}
+	dryRunFlag := cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only report what would be deleted",
+	}
 	ce.app.Commands = []cli.Command{
 		{
 			Name:  "db",
@@ -220,6 +354,11 @@ func New() *CryptEngine {
 							Value: encdb.KDFIterations,
 							Usage: "number of KDF iterations used for KeyDB creation",
 						},
+						cli.StringFlag{
+							Name:  "kdf",
+							Value: encdb.KDFPBKDF2,
+							Usage: "KDF algorithm used for KeyDB creation {pbkdf2, argon2id}",
+						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
@@ -228,8 +367,12 @@ func New() *CryptEngine {
 						return ce.prepare(c, false)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.dbCreate(c.GlobalString("homedir"),
-							c.Int("iterations"))
+						kdf, err := encdb.NewKDF(c.String("kdf"), c.Int("iterations"))
+						if err != nil {
+							ce.err = log.Error(err)
+							return
+						}
+						ce.err = ce.dbCreate(c.GlobalString("homedir"), kdf)
 					},
 				},
 				{
@@ -239,7 +382,7 @@ func New() *CryptEngine {
 						cli.IntFlag{
 							Name:  "iterations",
 							Value: encdb.KDFIterations,
-							Usage: "number of KDF iterations used for KeyDB rekeying",
+							Usage: "number of KDF iterations used for KeyDB rekeying (PBKDF2 only, Argon2id keeps its parameters)",
 						},
 					},
 					Before: func(c *cli.Context) error {
@@ -253,25 +396,69 @@ func New() *CryptEngine {
 							c.Int("iterations"))
 					},
 				},
-				/*
-					{
-						Name:  "status",
-						Usage: "Show DB status",
-						Before: func(c *cli.Context) error {
-							if len(c.Args()) > 0 {
-								return log.Errorf("superfluous argument(s): %s",
-									strings.Join(c.Args(), " "))
-							}
-							if err := ce.prepare(c, true); err != nil {
-								return err
-							}
-							return nil
-						},
-						Action: func(c *cli.Context) {
-							ce.err = ce.dbStatus(ce.fileTable.OutputFP)
+				{
+					Name:  "reiterate",
+					Usage: "Change number of KDF iterations of KeyDB (keeps passphrase)",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "iterations",
+							Value: encdb.KDFIterations,
+							Usage: "new number of KDF iterations (passes for Argon2id)",
 						},
 					},
-				*/
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.dbReiterate(c.GlobalString("homedir"),
+							c.Int("iterations"))
+					},
+				},
+				{
+					Name:  "test-passphrase",
+					Usage: "Check passphrase of KeyDB without opening it for writes",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.dbTestPassphrase(ce.fileTable.OutputFP,
+							c.GlobalString("homedir"))
+					},
+				},
+				{
+					Name:  "status",
+					Usage: "Show DB status",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.dbStatus(ce.fileTable.OutputFP)
+					},
+				},
+				{
+					Name:  "integrity",
+					Usage: "Check integrity of KeyDB",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.dbIntegrity(ce.fileTable.OutputFP)
+					},
+				},
 				{
 					Name:  "vacuum",
 					Usage: "Do full DB rebuild (VACUUM)",
@@ -319,6 +506,35 @@ func New() *CryptEngine {
 						},
 					},
 				*/
+				{
+					Name:  "gc",
+					Usage: "Remove keys no longer referenced by any user ID",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "force removal (do not prompt, requires --i-understand)",
+						},
+						cli.BoolFlag{
+							Name:  "i-understand",
+							Usage: "confirm that --force removes records without prompt",
+						},
+						dryRunFlag,
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if c.Bool("force") && !c.Bool("i-understand") {
+							return log.Error("option --force requires --i-understand")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.dbGC(ce.fileTable.StatusFP, os.Stdin,
+							c.Bool("force"), c.Bool("dry-run"))
+					},
+				},
 				{
 					Name:  "version",
 					Usage: "Show DB version",
@@ -387,6 +603,172 @@ func New() *CryptEngine {
 				},
 			},
 		},
+		{
+			Name:  "cache",
+			Usage: "commands for key server cache",
+			Subcommands: []cli.Command{
+				{
+					Name:  "clear",
+					Usage: "clear key server cache",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.cacheClear()
+					},
+				},
+				{
+					Name:  "stats",
+					Usage: "show key server cache statistics",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.cacheStats(ce.fileTable.OutputFP)
+					},
+				},
+			},
+		},
+		{
+			Name:  "session",
+			Usage: "commands for sessions",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "list sessions with their last activity",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.sessionList(ce.fileTable.OutputFP)
+					},
+				},
+				{
+					Name:  "prune",
+					Usage: "delete sessions without recent activity",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "older-than",
+							Usage: "minimum inactivity (e.g., 90d or 2160h)",
+						},
+						dryRunFlag,
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("older-than") {
+							return log.Error("option --older-than is mandatory")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.sessionPrune(ce.fileTable.StatusFP,
+							c.String("older-than"), c.Bool("dry-run"))
+					},
+				},
+				{
+					Name:  "check",
+					Usage: "verify (and repair) message key counts of sessions",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "repair",
+							Usage: "remove inconsistent message keys",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.sessionCheck(ce.fileTable.StatusFP,
+							c.Bool("repair"))
+					},
+				},
+				{
+					Name:  "ratchet",
+					Usage: "refresh session keys on next message",
+					Description: `
+Forces a session ratchet step: A new sender session key is generated and sent
+with the next message to the contact. As soon as the contact has seen it, it
+replaces the current session key. This limits the damage of a key compromise.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "from",
+							Usage: "own user ID of session",
+						},
+						cli.StringFlag{
+							Name:  "to",
+							Usage: "contact user ID of session",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("from") {
+							return log.Error("option --from is mandatory")
+						}
+						if !c.IsSet("to") {
+							return log.Error("option --to is mandatory")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.sessionRatchet(ce.fileTable.StatusFP,
+							c.String("from"), c.String("to"))
+					},
+				},
+				{
+					Name:  "prewarm",
+					Usage: "establish sessions with contacts in advance",
+					Description: `
+Fetches the KeyInit messages of the given contacts (unless cached ones can be
+reused) and establishes the initial session states right away. Afterwards the
+first message to each contact can be encrypted without contacting the key
+server. Existing sessions are left untouched.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "from",
+							Usage: "own user ID of sessions",
+						},
+						cli.StringFlag{
+							Name:  "contacts",
+							Usage: "comma-separated contact user IDs",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("from") {
+							return log.Error("option --from is mandatory")
+						}
+						if !c.IsSet("contacts") {
+							return log.Error("option --contacts is mandatory")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.sessionPrewarm(ce.fileTable.StatusFP,
+							c.String("from"), c.String("contacts"))
+					},
+				},
+			},
+		},
 		{
 			Name:  "uid",
 			Usage: "commands for user IDs",
@@ -516,6 +898,7 @@ Delete a user ID (registered or unregistered).
 							Name:  "force",
 							Usage: "force deletion (do not prompt)",
 						},
+						dryRunFlag,
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
@@ -527,7 +910,8 @@ Delete a user ID (registered or unregistered).
 						return ce.prepare(c, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.deleteUID(c.String("id"), c.Bool("force"))
+						ce.err = ce.deleteUID(ce.fileTable.StatusFP, c.String("id"),
+							c.Bool("force"), c.Bool("dry-run"))
 					},
 				},
 				{
@@ -543,6 +927,42 @@ Delete a user ID (registered or unregistered).
 						ce.err = ce.listUIDs(ce.fileTable.OutputFP)
 					},
 				},
+				{
+					Name:  "status",
+					Usage: "show state of own (mapped) user IDs as JSON",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.uidStatus(ce.fileTable.OutputFP)
+					},
+				},
+				{
+					Name:  "fingerprint",
+					Usage: "show fingerprint (SIGKEYHASH) of user ID and if it is verified with the hash chain",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "user ID",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.uidFingerprint(ce.fileTable.OutputFP,
+							c.String("id"))
+					},
+				},
 			},
 		},
 		{
@@ -552,6 +972,12 @@ Delete a user ID (registered or unregistered).
 				{
 					Name:  "add",
 					Usage: "add new KeyInit message",
+					Description: `
+Adds a new KeyInit message for the given user ID to the key server. The nym
+address (and the corresponding mix address) can be omitted, for example for
+one-shot contacts without a persistent nym. Then the KeyInit message contains
+no nym address and the first message has to be delivered by other means.
+`,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:  "id",
@@ -559,11 +985,11 @@ Delete a user ID (registered or unregistered).
 						},
 						cli.StringFlag{
 							Name:  "mixaddress",
-							Usage: "mix address for KeyInit message",
+							Usage: "mix address for KeyInit message (requires --nymaddress)",
 						},
 						cli.StringFlag{
 							Name:  "nymaddress",
-							Usage: "nym address for KeyInit message",
+							Usage: "nym address for KeyInit message (optional)",
 						},
 						cli.StringFlag{
 							Name:  "token",
@@ -577,11 +1003,11 @@ Delete a user ID (registered or unregistered).
 						if !c.IsSet("id") {
 							return log.Error("option --id is mandatory")
 						}
-						if !c.IsSet("mixaddress") {
-							return log.Error("option --mixaddress is mandatory")
+						if c.IsSet("nymaddress") && !c.IsSet("mixaddress") {
+							return log.Error("option --nymaddress requires --mixaddress")
 						}
-						if !c.IsSet("nymaddress") {
-							return log.Error("option --nymaddress is mandatory")
+						if c.IsSet("mixaddress") && !c.IsSet("nymaddress") {
+							return log.Error("option --mixaddress requires --nymaddress")
 						}
 						if !c.IsSet("token") {
 							return log.Error("option --token is mandatory")
@@ -597,11 +1023,92 @@ Delete a user ID (registered or unregistered).
 				{
 					Name:  "fetch",
 					Usage: "fetch a KeyInit message",
+					Description: `
+Fetches a KeyInit message for the given user ID from the key server. A
+previously fetched KeyInit message is reused (without contacting the key
+server), unless it expires within the --stale window or --force is given.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "user ID",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "always fetch from key server (bypass cache)",
+						},
+						cli.DurationFlag{
+							Name:  "stale",
+							Value: defaultKeyInitStaleness,
+							Usage: "refetch cached KeyInit if it expires within this window",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.fetchKeyInit(c.String("id"), c.Bool("force"),
+							c.Duration("stale"))
+					},
+				},
+				{
+					Name:  "verify",
+					Usage: "verify a KeyInit message",
+					Description: `
+Fetches a KeyInit message for the given user ID (or reuses a cached one, see
+keyinit fetch) and verifies both its self-signature and the signature of the key
+server against the published signing key(s) of the key server. The key server
+does not return its signature with fetched KeyInit messages, it is only known
+for own KeyInit messages. Otherwise the server signature is reported as
+unavailable.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "user ID",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "always fetch from key server (bypass cache)",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.verifyKeyInit(ce.fileTable.OutputFP,
+							c.String("id"), c.Bool("force"))
+					},
+				},
+				{
+					Name:  "show",
+					Usage: "show a KeyInit message",
+					Description: `
+Fetches a KeyInit message for the given user ID (or reuses a cached one, see
+keyinit fetch) and shows its decoded contents (including the session anchor
+with mix address, nym address, and keys) as JSON.
+`,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:  "id",
 							Usage: "user ID",
 						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "always fetch from key server (bypass cache)",
+						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
@@ -613,7 +1120,8 @@ Delete a user ID (registered or unregistered).
 						return ce.prepare(c, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.fetchKeyInit(c.String("id"))
+						ce.err = ce.showKeyInit(ce.fileTable.OutputFP,
+							c.String("id"), c.Bool("force"))
 					},
 				},
 				{
@@ -750,6 +1258,26 @@ Delete a user ID (registered or unregistered).
 						ce.err = ce.showHashChain(c.String("domain"))
 					},
 				},
+				{
+					Name:  "diff",
+					Usage: "compare hash chain exported with 'show' (read from input-fd) with local copy",
+					Flags: []cli.Flag{
+						domainFlag,
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("domain") {
+							return log.Error("option --domain is mandatory")
+						}
+						return ce.prepare(c, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.diffHashChain(ce.fileTable.OutputFP,
+							ce.fileTable.InputFP, c.String("domain"))
+					},
+				},
 				{
 					Name:  "delete",
 					Usage: "delete local hash chain copy",
@@ -771,6 +1299,19 @@ Delete a user ID (registered or unregistered).
 				},
 			},
 		},
+		{
+			Name:  "ciphersuites",
+			Usage: "list supported ciphersuites",
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				return ce.prepare(c, false)
+			},
+			Action: func(c *cli.Context) {
+				ce.err = listCiphersuites(ce.fileTable.OutputFP)
+			},
+		},
 		{
 			Name:  "encrypt",
 			Usage: "encrypt message",
@@ -816,6 +1357,16 @@ Delete a user ID (registered or unregistered).
 		{
 			Name:  "decrypt",
 			Usage: "decrypt message",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "defer-signature-check",
+					Usage: "do not verify the signature of a signed message (see verify)",
+				},
+				cli.BoolFlag{
+					Name:  "batch",
+					Usage: "decrypt several messages (one per line) and verify their signatures concurrently",
+				},
+			},
 			Before: func(c *cli.Context) error {
 				if len(c.Args()) > 0 {
 					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
@@ -823,8 +1374,236 @@ Delete a user ID (registered or unregistered).
 				return ce.prepare(c, true)
 			},
 			Action: func(c *cli.Context) {
+				if c.Bool("batch") {
+					ce.err = ce.decryptBatch(ce.fileTable.OutputFP,
+						ce.fileTable.InputFP, ce.fileTable.StatusFP,
+						c.Bool("defer-signature-check"))
+					return
+				}
 				ce.err = ce.decrypt(ce.fileTable.OutputFP, ce.fileTable.InputFP,
+					ce.fileTable.StatusFP, c.Bool("defer-signature-check"))
+			},
+		},
+		{
+			Name:  "encrypt-file",
+			Usage: "encrypt file",
+			Description: `
+Encrypt a file (or input-fd) of arbitrary size for a contact. The key agreement
+is the same as for messages (session keys or KeyInit), but instead of the
+fixed size message envelope the file content is encrypted in a streaming
+framed format. See 'decrypt-file'.
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "user ID to send from",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "user ID to send to",
+				},
+				cli.BoolFlag{
+					Name:  "sign",
+					Usage: "sign file key with permanent signature",
+				},
+				cli.StringFlag{
+					Name:  "nymaddress",
+					Usage: "nymaddress to receive future messages at",
+				},
+				cli.StringFlag{
+					Name:  "in",
+					Usage: "file to encrypt (default: input-fd)",
+				},
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "file to write encrypted file to (default: output-fd)",
+				},
+				cli.BoolFlag{
+					Name:  "progress",
+					Usage: "write progress to status-fd",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				if !c.IsSet("from") {
+					return log.Error("option --from is mandatory")
+				}
+				if !c.IsSet("to") {
+					return log.Error("option --to is mandatory")
+				}
+				if !c.IsSet("nymaddress") {
+					return log.Error("option --nymaddress is mandatory")
+				}
+				return ce.prepare(c, true)
+			},
+			Action: func(c *cli.Context) {
+				r, err := openInput(c.String("in"), ce.fileTable.InputFP)
+				if err != nil {
+					ce.err = err
+					return
+				}
+				defer r.Close()
+				w, err := createOutput(c.String("out"), ce.fileTable.OutputFP)
+				if err != nil {
+					ce.err = err
+					return
+				}
+				ce.err = ce.encryptFile(w, c.String("from"), c.String("to"),
+					c.Bool("sign"), c.String("nymaddress"), r,
+					ce.statusProgress(c), ce.fileTable.StatusFP)
+				if err := w.Close(); err != nil && ce.err == nil {
+					ce.err = log.Error(err)
+				}
+			},
+		},
+		{
+			Name:  "decrypt-file",
+			Usage: "decrypt file",
+			Description: `
+Decrypt a file (or input-fd) encrypted with 'encrypt-file'.
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "in",
+					Usage: "file to decrypt (default: input-fd)",
+				},
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "file to write decrypted file to (default: output-fd)",
+				},
+				cli.BoolFlag{
+					Name:  "progress",
+					Usage: "write progress to status-fd",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				return ce.prepare(c, true)
+			},
+			Action: func(c *cli.Context) {
+				r, err := openInput(c.String("in"), ce.fileTable.InputFP)
+				if err != nil {
+					ce.err = err
+					return
+				}
+				defer r.Close()
+				w, err := createOutput(c.String("out"), ce.fileTable.OutputFP)
+				if err != nil {
+					ce.err = err
+					return
+				}
+				ce.err = ce.decryptFile(w, r, ce.statusProgress(c),
 					ce.fileTable.StatusFP)
+				if err := w.Close(); err != nil && ce.err == nil {
+					ce.err = log.Error(err)
+				}
+			},
+		},
+		{
+			Name:  "sign",
+			Usage: "make detached signature of file",
+			Description: `
+Make a detached signature of a file (or input-fd) with the signature key of a
+user ID. The base64 encoded signature is written to the file given with --out
+(or output-fd). The signature is made over the file content prefixed with
+"MUTE-FILE-SIGNATURE\n", so it is no valid permanent message signature (and
+vice versa). See 'verify'.
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "user ID to sign with",
+				},
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "file to sign (default: input-fd)",
+				},
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "file to write signature to (default: output-fd)",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				if !c.IsSet("id") {
+					return log.Error("option --id is mandatory")
+				}
+				return ce.prepare(c, true)
+			},
+			Action: func(c *cli.Context) {
+				r, err := openInput(c.String("file"), ce.fileTable.InputFP)
+				if err != nil {
+					ce.err = err
+					return
+				}
+				defer r.Close()
+				ce.err = ce.sign(ce.fileTable.OutputFP, c.String("id"),
+					c.String("out"), r)
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "verify signature of message or file",
+			Description: `
+Verify the signature of a message or file (or input-fd) against the current
+signature key of the signer, which has been verified with the hash chain.
+The signature is either given directly with --signature (permanent message
+signatures) or as a detached signature file with --sig (see 'sign').
+Writes VALID or INVALID and the fingerprint (SIGKEYHASH) of the signature key
+to output-fd.
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "from, id",
+					Usage: "user ID of signer",
+				},
+				cli.StringFlag{
+					Name:  "file",
+					Usage: "file to verify (default: input-fd)",
+				},
+				cli.StringFlag{
+					Name:  "sig",
+					Usage: "file with detached signature",
+				},
+				cli.StringFlag{
+					Name:  "signature",
+					Usage: "base64 encoded signature",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				if !c.IsSet("from") {
+					return log.Error("option --from is mandatory")
+				}
+				if c.IsSet("sig") == c.IsSet("signature") {
+					return log.Error("either option --sig or --signature is mandatory")
+				}
+				return ce.prepare(c, true)
+			},
+			Action: func(c *cli.Context) {
+				sig := c.String("signature")
+				if c.IsSet("sig") {
+					sig, ce.err = readDetachedSig(c.String("sig"))
+					if ce.err != nil {
+						return
+					}
+				}
+				r, err := openInput(c.String("file"), ce.fileTable.InputFP)
+				if err != nil {
+					ce.err = err
+					return
+				}
+				defer r.Close()
+				ce.err = ce.verify(ce.fileTable.OutputFP, c.String("from"), sig, r,
+					c.IsSet("sig"))
 			},
 		},
 		{
@@ -841,20 +1620,31 @@ Delete a user ID (registered or unregistered).
 			},
 		},
 	}
-	ce.cache = cache.New()
+	ce.cache = cache.New(def.KeyServerCacheSize, def.KeyServerCacheTTL)
+	ce.kiMaxAge = uid.MaxNotAfter
 	return &ce
 }
 
 // Start starts the crypt engine with the given args.
+// Start is safe for concurrent use, the calls are serialized.
+// Errors are returned as *engerr.Error, test for the error classes with
+// errors.Is.
 func (ce *CryptEngine) Start(args []string) error {
-	defer ce.Close()
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	defer ce.closeKeyDB()
+	defer dialer.FlushStats()
+	ce.err = nil
 	ce.app.Name = args[0]
 	if err := ce.app.Run(args); err != nil {
-		return err
+		return engerr.Classify(err)
 	}
-	if ce.err != nil {
+	if ce.err == errExit {
 		return ce.err
 	}
+	if ce.err != nil {
+		return engerr.Classify(ce.err)
+	}
 	return nil
 }
 
@@ -870,15 +1660,36 @@ func (ce *CryptEngine) openKeyDB() error {
 	// open keyDB
 	keydbname := filepath.Join(ce.homedir, "keys")
 	log.Infof("open keyDB %s", keydbname)
-	ce.keyDB, err = keydb.Open(keydbname, passphrase)
+	if ce.readOnly {
+		ce.keyDB, err = keydb.OpenReadOnly(keydbname, passphrase)
+	} else {
+		ce.keyDB, err = keydb.Open(keydbname, passphrase)
+	}
 	if err != nil {
-		return err
+		switch err {
+		case encdb.ErrWrongPassphrase:
+			i18n.Fprintln(ce.fileTable.StatusFP,
+				"wrong passphrase, your data is intact: please try again")
+		case encdb.ErrCorruptDB:
+			i18n.Fprintln(ce.fileTable.StatusFP,
+				"passphrase correct, but database is damaged: restore from backup")
+		case encdb.ErrNotMuteDB:
+			i18n.Fprintf(ce.fileTable.StatusFP,
+				"%s is not a Mute database: check --homedir\n", keydbname)
+		}
+		return log.Error(err)
 	}
 	return nil
 }
 
 // Close the underlying database of the crypt engine.
 func (ce *CryptEngine) Close() error {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	return ce.closeKeyDB()
+}
+
+func (ce *CryptEngine) closeKeyDB() error {
 	if ce.keyDB != nil {
 		err := ce.keyDB.Close()
 		ce.keyDB = nil
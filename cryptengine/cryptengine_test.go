@@ -0,0 +1,84 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentStart(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "cryptengine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	// only use the standard descriptors: the CryptEngine wraps every other
+	// descriptor in an *os.File which closes it when garbage collected (and
+	// later tests might already reuse the descriptor number by then)
+	ce := New()
+	defer ce.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- ce.Start([]string{
+				"mutecrypt",
+				"--homedir", tmpdir,
+				"--logdir", tmpdir,
+				"--keyserver",
+				"--input-fd", "stdin",
+				"--output-fd", "stderr",
+				"--status-fd", "stderr",
+				"--passphrase-fd", "stdin",
+				"--command-fd", "stdin",
+				"cache", "clear",
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReadOnlyEnv(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "cryptengine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer os.Unsetenv("MUTE_READ_ONLY")
+	if err := os.Setenv("MUTE_READ_ONLY", "true"); err != nil {
+		t.Fatal(err)
+	}
+	ce := New()
+	defer ce.Close()
+	err = ce.Start([]string{
+		"mutecrypt",
+		"--homedir", tmpdir,
+		"--logdir", tmpdir,
+		"--keyserver",
+		"--input-fd", "stdin",
+		"--output-fd", "stderr",
+		"--status-fd", "stderr",
+		"--passphrase-fd", "stdin",
+		"--command-fd", "stdin",
+		"cache", "clear",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ce.readOnly {
+		t.Error("MUTE_READ_ONLY not honored")
+	}
+}
@@ -10,14 +10,18 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"strings"
 
 	"github.com/frankbraun/codechain/util/bzero"
+	"github.com/mutecomm/mute/encdb"
 	"github.com/mutecomm/mute/keydb"
 	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/util"
+	"github.com/mutecomm/mute/util/i18n"
 )
 
 // create a new KeyDB.
-func (ce *CryptEngine) dbCreate(homedir string, iterations int) error {
+func (ce *CryptEngine) dbCreate(homedir string, kdf *encdb.KDF) error {
 	keydbname := filepath.Join(homedir, "keys")
 	// read passphrase
 	log.Infof("read passphrase from fd %d", ce.fileTable.PassphraseFD)
@@ -44,7 +48,7 @@ func (ce *CryptEngine) dbCreate(homedir string, iterations int) error {
 	}
 	// create keyDB
 	log.Infof("create keyDB '%s'", keydbname)
-	return keydb.Create(keydbname, passphrase, iterations)
+	return keydb.CreateKDF(keydbname, passphrase, kdf)
 }
 
 // rekey a KeyDB.
@@ -87,6 +91,52 @@ func (ce *CryptEngine) dbRekey(homedir string, iterations int) error {
 	return keydb.Rekey(keydbname, oldPassphrase, newPassphrase, iterations)
 }
 
+// reiterate a KeyDB (change the number of KDF iterations, but not the
+// passphrase).
+func (ce *CryptEngine) dbReiterate(homedir string, iterations int) error {
+	keydbname := filepath.Join(homedir, "keys")
+	// read passphrase
+	log.Infof("read passphrase from fd %d", ce.fileTable.PassphraseFD)
+	scanner := bufio.NewScanner(ce.fileTable.PassphraseFP)
+	var passphrase []byte
+	defer bzero.Bytes(passphrase)
+	if scanner.Scan() {
+		passphrase = scanner.Bytes()
+	} else if err := scanner.Err(); err != nil {
+		return log.Error(err)
+	}
+	// reiterate keyDB
+	log.Infof("reiterate keyDB '%s' with %d iterations", keydbname, iterations)
+	return keydb.Reiterate(keydbname, passphrase, iterations)
+}
+
+// dbTestPassphrase checks the passphrase of the KeyDB without opening it for
+// writes and writes the result (OK or WRONG) to w.
+func (ce *CryptEngine) dbTestPassphrase(w io.Writer, homedir string) error {
+	keydbname := filepath.Join(homedir, "keys")
+	// read passphrase
+	log.Infof("read passphrase from fd %d", ce.fileTable.PassphraseFD)
+	scanner := bufio.NewScanner(ce.fileTable.PassphraseFP)
+	var passphrase []byte
+	defer bzero.Bytes(passphrase)
+	if scanner.Scan() {
+		passphrase = scanner.Bytes()
+	} else if err := scanner.Err(); err != nil {
+		return log.Error(err)
+	}
+	// check passphrase
+	log.Infof("check passphrase of keyDB '%s'", keydbname)
+	err := keydb.CheckPassphrase(keydbname, passphrase)
+	if err == encdb.ErrWrongPassphrase {
+		fmt.Fprintln(w, "WRONG")
+		return log.Error(err)
+	} else if err != nil {
+		return log.Error(err)
+	}
+	fmt.Fprintln(w, "OK")
+	return nil
+}
+
 func (ce *CryptEngine) dbStatus(w io.Writer) error {
 	autoVacuum, freelistCount, err := ce.keyDB.Status()
 	if err != nil {
@@ -98,6 +148,16 @@ func (ce *CryptEngine) dbStatus(w io.Writer) error {
 	return nil
 }
 
+func (ce *CryptEngine) dbIntegrity(w io.Writer) error {
+	integrity, err := ce.keyDB.Integrity()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "keydb:\n")
+	fmt.Fprintf(w, "integrity=%s\n", strings.Replace(integrity, "\n", "; ", -1))
+	return nil
+}
+
 func (ce *CryptEngine) dbVacuum(autoVacuumMode string) error {
 	return ce.keyDB.Vacuum(autoVacuumMode)
 }
@@ -115,3 +175,55 @@ func (ce *CryptEngine) dbVersion(w io.Writer) error {
 	fmt.Fprintf(w, "version=%s\n", version)
 	return nil
 }
+
+// dbGC removes all records from the KeyDB which are not referenced by any
+// UID anymore (see keydb.FindOrphans), after the confirmation phrase has been
+// read from confirmfp (unless force is true). If dryRun is true, the orphaned
+// records are only reported.
+func (ce *CryptEngine) dbGC(
+	statusfp io.Writer,
+	confirmfp io.Reader,
+	force, dryRun bool,
+) error {
+	orphans, err := ce.keyDB.FindOrphans()
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		util.WouldDelete(statusfp, "orphaned KeyInit(s)",
+			int64(len(orphans.KeyInits)), orphans.KeyInits...)
+		util.WouldDelete(statusfp, "orphaned session state(s)",
+			int64(len(orphans.SessionStates)), orphans.SessionStates...)
+		util.WouldDelete(statusfp, "orphaned session key(s)",
+			int64(len(orphans.SessionKeys)), orphans.SessionKeys...)
+		util.WouldDelete(statusfp, "orphaned message key(s)",
+			orphans.MessageKeys)
+		return nil
+	}
+	i18n.Fprintf(statusfp, "orphaned KeyInits: %d\n", len(orphans.KeyInits))
+	i18n.Fprintf(statusfp, "orphaned session states: %d\n",
+		len(orphans.SessionStates))
+	i18n.Fprintf(statusfp, "orphaned session keys: %d\n",
+		len(orphans.SessionKeys))
+	i18n.Fprintf(statusfp, "orphaned message keys: %d\n", orphans.MessageKeys)
+	if orphans.Count() == 0 {
+		return nil
+	}
+	// ask for manual confirmation (echoing the scope)
+	if !force {
+		phrase := fmt.Sprintf("delete %d records", orphans.Count())
+		ok, err := util.ConfirmPhrase(confirmfp, statusfp, phrase)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return log.Error("cryptengine: garbage collection aborted")
+		}
+	}
+	if err := ce.keyDB.DelOrphans(orphans); err != nil {
+		return err
+	}
+	log.Infof("removed %d orphaned record(s)", orphans.Count())
+	i18n.Fprintf(statusfp, "removed %d orphaned record(s)\n", orphans.Count())
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keydb"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/uid"
+)
+
+// newTestKeyDB creates a new KeyDB in a temporary directory. It returns the
+// KeyDB and a function to remove it.
+func newTestKeyDB(t *testing.T) (*keydb.KeyDB, func()) {
+	tmpdir, err := ioutil.TempDir("", "cryptengine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbname := filepath.Join(tmpdir, "keys")
+	passphrase := []byte("passphrase")
+	if err := keydb.Create(dbname, passphrase, 4096); err != nil {
+		os.RemoveAll(tmpdir)
+		t.Fatal(err)
+	}
+	keyDB, err := keydb.Open(dbname, passphrase)
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		t.Fatal(err)
+	}
+	return keyDB, func() {
+		keyDB.Close()
+		os.RemoveAll(tmpdir)
+	}
+}
+
+// newOrphanKeyDB creates a new KeyDB which contains an orphaned session state
+// (there are no UIDs). It returns the KeyDB, the session state key, and a
+// function to remove the KeyDB.
+func newOrphanKeyDB(t *testing.T) (*keydb.KeyDB, string, func()) {
+	keyDB, cleanup := newTestKeyDB(t)
+	var rt, ssp uid.KeyEntry
+	if err := rt.InitDHKey(cipher.RandReader); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err := ssp.InitDHKey(cipher.RandReader); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	key := base64.Encode(cipher.SHA512([]byte("orphan")))
+	ss := &session.State{
+		RecipientTemp:    rt,
+		SenderSessionPub: ssp,
+		NymAddress:       "NYMADDRESS",
+	}
+	if err := keyDB.SetSessionState(key, ss); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return keyDB, key, cleanup
+}
+
+func TestDBIntegrity(t *testing.T) {
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	var buf bytes.Buffer
+	if err := ce.dbIntegrity(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "keydb:\nintegrity=ok\n" {
+		t.Errorf("unexpected integrity report: %q", buf.String())
+	}
+}
+
+func TestDBGCDryRun(t *testing.T) {
+	keyDB, key, cleanup := newOrphanKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	var buf bytes.Buffer
+	if err := ce.dbGC(&buf, nil, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "would delete 1 orphaned session state(s)\n\t"+key+"\n") {
+		t.Errorf("unexpected dry-run report: %s", buf.String())
+	}
+	// nothing has been removed
+	if ss, err := keyDB.GetSessionState(key); err != nil || ss == nil {
+		t.Error("dry-run must not remove session state")
+	}
+}
+
+func TestDBGCConfirmation(t *testing.T) {
+	keyDB, key, cleanup := newOrphanKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	// mismatched confirmation phrase aborts
+	for _, answer := range []string{"y\n", "delete 2 records\n", ""} {
+		var buf bytes.Buffer
+		if err := ce.dbGC(&buf, strings.NewReader(answer), false, false); err == nil {
+			t.Errorf("answer %q should abort garbage collection", answer)
+		}
+		if !strings.Contains(buf.String(), "type 'delete 1 records' to confirm: ") {
+			t.Errorf("unexpected prompt: %s", buf.String())
+		}
+		if ss, err := keyDB.GetSessionState(key); err != nil || ss == nil {
+			t.Fatal("aborted garbage collection must not remove session state")
+		}
+	}
+	// matching confirmation phrase removes
+	var buf bytes.Buffer
+	err := ce.dbGC(&buf, strings.NewReader("delete 1 records\n"), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss, err := keyDB.GetSessionState(key); err != nil || ss != nil {
+		t.Error("confirmed garbage collection should remove session state")
+	}
+}
@@ -5,14 +5,17 @@
 package cryptengine
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
-	"os"
+	"strings"
 
 	"github.com/mutecomm/mute/cipher"
 	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msg/session/sqlstore"
 	"github.com/mutecomm/mute/uid"
 )
 
@@ -36,40 +39,60 @@ func (ce *CryptEngine) getRecipientIdentities() ([]*uid.Message, error) {
 	return uidMsgs, nil
 }
 
-func (ce *CryptEngine) decrypt(w io.Writer, r io.Reader, statusfp *os.File) error {
-	// retrieve all possible recipient identities from keyDB
-	identities, err := ce.getRecipientIdentities()
-	if err != nil {
-		return err
-	}
-
+// decryptArgs reads the pre-header of the base64 encoded message from r and
+// returns the arguments to decrypt it for the given identities to w.
+func (ce *CryptEngine) decryptArgs(
+	w io.Writer,
+	r io.Reader,
+	identities []*uid.Message,
+	deferSignatureCheck bool,
+) (*msg.DecryptArgs, error) {
 	// read pre-header
 	r = base64.NewDecoder(r)
 	version, preHeader, err := msg.ReadFirstOuterHeader(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// check version
 	if version > msg.Version {
-		return log.Errorf("cryptengine: newer message version, please update software")
+		return nil, log.Errorf("cryptengine: newer message version, please update software")
 	}
 	if version < msg.Version {
-		return log.Errorf("cryptengine: outdated message version, cannot process")
+		return nil, log.Errorf("cryptengine: outdated message version, cannot process")
 	}
 
-	// decrypt message
-	var senderID string
-	var sig string
-	args := &msg.DecryptArgs{
+	return &msg.DecryptArgs{
 		Writer:     w,
 		Identities: identities,
 		PreHeader:  preHeader,
 		Reader:     r,
+		KeyWindow:  ce.keyWindow,
 		Rand:       cipher.RandReader,
-		KeyStore:   ce,
+		KeyStore:   sqlstore.New(ce.keyDB),
+
+		DeferSignatureCheck: deferSignatureCheck,
+	}, nil
+}
+
+func (ce *CryptEngine) decrypt(
+	w io.Writer,
+	r io.Reader,
+	statusfp io.Writer,
+	deferSignatureCheck bool,
+) error {
+	// retrieve all possible recipient identities from keyDB
+	identities, err := ce.getRecipientIdentities()
+	if err != nil {
+		return err
+	}
+
+	// decrypt message
+	args, err := ce.decryptArgs(w, r, identities, deferSignatureCheck)
+	if err != nil {
+		return err
 	}
-	senderID, sig, err = msg.Decrypt(args)
+	senderID, sig, err := msg.Decrypt(args)
 	if err != nil {
 		// TODO: handle msg.ErrStatusError, should trigger a subsequent
 		// encrypted message with StatusError
@@ -78,6 +101,78 @@ func (ce *CryptEngine) decrypt(w io.Writer, r io.Reader, statusfp *os.File) erro
 	fmt.Fprintf(statusfp, "SENDERIDENTITY:\t%s\n", senderID)
 	if sig != "" {
 		fmt.Fprintf(statusfp, "SIGNATURE:\t%s\n", sig)
+		if deferSignatureCheck {
+			log.Info("signature check deferred")
+		}
+	}
+	return nil
+}
+
+// decryptBatch decrypts the base64 encoded messages read from r (one per
+// line). The messages are decrypted one after another, but their signatures
+// are verified concurrently (see msg.DecryptBatch). For every message a line
+// with the base64 encoded plaintext is written to w (empty, if the message
+// could not be decrypted) and a MESSAGE line followed by SENDERIDENTITY and
+// SIGNATURE lines or an ERROR line is written to statusfp.
+func (ce *CryptEngine) decryptBatch(
+	w io.Writer,
+	r io.Reader,
+	statusfp io.Writer,
+	deferSignatureCheck bool,
+) error {
+	// retrieve all possible recipient identities from keyDB
+	identities, err := ce.getRecipientIdentities()
+	if err != nil {
+		return err
+	}
+
+	// read messages
+	var encs []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, msg.EncodedMsgSize+1)
+	for scanner.Scan() {
+		encs = append(encs, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return log.Error(err)
+	}
+
+	// decrypt messages
+	var (
+		args    []*msg.DecryptArgs
+		indices []int
+		plains  = make([]bytes.Buffer, len(encs))
+		errs    = make([]error, len(encs))
+		results = make([]msg.DecryptResult, len(encs))
+	)
+	for i, enc := range encs {
+		arg, err := ce.decryptArgs(&plains[i], strings.NewReader(enc),
+			identities, deferSignatureCheck)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		args = append(args, arg)
+		indices = append(indices, i)
+	}
+	for i, res := range msg.DecryptBatch(args, 0) {
+		results[indices[i]] = res
+		errs[indices[i]] = res.Err
+	}
+
+	// write results
+	for i := range encs {
+		fmt.Fprintf(statusfp, "MESSAGE:\t%d\n", i)
+		if errs[i] != nil {
+			fmt.Fprintln(w)
+			fmt.Fprintf(statusfp, "ERROR:\t%s\n", errs[i])
+			continue
+		}
+		fmt.Fprintln(w, base64.Encode(plains[i].Bytes()))
+		fmt.Fprintf(statusfp, "SENDERIDENTITY:\t%s\n", results[i].SenderID)
+		if results[i].Sig != "" {
+			fmt.Fprintf(statusfp, "SIGNATURE:\t%s\n", results[i].Sig)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,118 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msg/session/memstore"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func TestDecryptBatch(t *testing.T) {
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+
+	alice, err := uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := uid.Create("bob@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPrivateUID(bob); err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	ki, _, privateKey, err := bob.KeyInit(1, now+times.Day, now-times.Day,
+		false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobTemp, err := ki.KeyEntryECDHE25519(bob.SigPubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyDB.AddPrivateKeyInit(ki, bobTemp.HASH, bob.SigPubKey(),
+		privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := memstore.New()
+	ms.AddPublicKeyEntry(bob.Identity(), bobTemp)
+
+	// alice sends signed and unsigned messages, the last one is garbage
+	var input bytes.Buffer
+	for i := 0; i < 3; i++ {
+		args := &msg.EncryptArgs{
+			Writer:                 &input,
+			From:                   alice,
+			To:                     bob,
+			NymAddress:             "nymaddress",
+			SenderLastKeychainHash: hashchain.TestEntry,
+			Reader:                 strings.NewReader(fmt.Sprintf("msg %d", i)),
+			Rand:                   cipher.RandReader,
+			KeyStore:               ms,
+		}
+		if i != 1 {
+			args.PrivateSigKey = alice.PrivateSigKey64()
+		}
+		if _, err := msg.Encrypt(args); err != nil {
+			t.Fatal(err)
+		}
+		input.WriteString("\n")
+	}
+	input.WriteString("garbage\n")
+
+	var output, status bytes.Buffer
+	if err := ce.decryptBatch(&output, &input, &status, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("%d output lines != 4", len(lines))
+	}
+	for i := 0; i < 3; i++ {
+		plain, err := base64.Decode(lines[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plain) != fmt.Sprintf("msg %d", i) {
+			t.Errorf("message %d decrypted to %q", i, plain)
+		}
+	}
+	if lines[3] != "" {
+		t.Errorf("garbage decrypted to %q", lines[3])
+	}
+	results := strings.Split(status.String(), "MESSAGE:\t")[1:]
+	if len(results) != 4 {
+		t.Fatalf("%d status results != 4:\n%s", len(results), status.String())
+	}
+	for i, res := range results {
+		switch {
+		case i == 3:
+			if !strings.Contains(res, "ERROR:\t") {
+				t.Errorf("no error for garbage:\n%s", res)
+			}
+		case !strings.Contains(res, "SENDERIDENTITY:\talice@mute.berlin\n"):
+			t.Errorf("wrong sender for message %d:\n%s", i, res)
+		case strings.Contains(res, "SIGNATURE:\t") != (i != 1):
+			t.Errorf("wrong signature status for message %d:\n%s", i, res)
+		}
+	}
+}
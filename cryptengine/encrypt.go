@@ -13,6 +13,7 @@ import (
 	"github.com/mutecomm/mute/cipher"
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msg/session/sqlstore"
 	"github.com/mutecomm/mute/uid/identity"
 )
 
@@ -66,7 +67,7 @@ func (ce *CryptEngine) encrypt(
 		PrivateSigKey:          privateSigKey,
 		Reader:                 r,
 		Rand:                   cipher.RandReader,
-		KeyStore:               ce,
+		KeyStore:               sqlstore.New(ce.keyDB),
 	}
 	nymAddress, err = msg.Encrypt(args)
 	if err != nil {
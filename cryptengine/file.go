@@ -0,0 +1,304 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/cipher/aes256"
+	"github.com/mutecomm/mute/log"
+)
+
+// fileMagic starts every encrypted file.
+const fileMagic = "MUTEFILE1\n"
+
+// fileChunkSize is the maximum size of the plaintext in a single frame.
+const fileChunkSize = 64 * 1024
+
+// maxFileHeaderSize is the maximum size of the encrypted header message.
+const maxFileHeaderSize = 1024 * 1024
+
+// fileKeySize is the size of the file key transported in the header message
+// (AES-256 key, CTR IV, and HMAC-SHA512 key).
+const fileKeySize = 32 + 16 + 64
+
+// Frame flags.
+const (
+	frameData  byte = 0
+	frameFinal byte = 1
+)
+
+// An encrypted file has the following streaming framed format:
+//
+//   fileMagic
+//   uint32   length of header message
+//   []byte   header message (a normal base64 encoded Mute message containing
+//            the random file key, encrypted with the session keys)
+//   frames   (see writeFrame)
+//
+// The file content is encrypted with AES-256 in CTR mode in frames of at most
+// fileChunkSize bytes, every frame is authenticated with HMAC-SHA512. The last
+// frame is marked with frameFinal, which prevents truncation.
+
+// fileKey holds the keys for the encryption of the file content.
+type fileKey struct {
+	aesKey  []byte
+	iv      []byte
+	hmacKey []byte
+}
+
+// newFileKey returns a fileKey from the raw key material buf.
+func newFileKey(buf []byte) (*fileKey, error) {
+	if len(buf) != fileKeySize {
+		return nil, log.Errorf("cryptengine: file key has wrong length %d",
+			len(buf))
+	}
+	return &fileKey{
+		aesKey:  buf[:32],
+		iv:      buf[32:48],
+		hmacKey: buf[48:],
+	}, nil
+}
+
+// frameMAC computes the HMAC of frame number n with the given flag and
+// ciphertext.
+func frameMAC(key []byte, n uint64, flag byte, ciphertext []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, n)
+	buf.WriteByte(flag)
+	binary.Write(&buf, binary.BigEndian, uint32(len(ciphertext)))
+	buf.Write(ciphertext)
+	return cipher.HMAC(key, buf.Bytes())
+}
+
+// writeFrame writes a single frame:
+//
+//	byte     flag (frameData or frameFinal)
+//	uint32   length of ciphertext
+//	[]byte   ciphertext
+//	[64]byte HMAC-SHA512 of frame number, flag, length, and ciphertext
+func writeFrame(w io.Writer, key []byte, n uint64, flag byte, ciphertext []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(flag)
+	binary.Write(&buf, binary.BigEndian, uint32(len(ciphertext)))
+	buf.Write(ciphertext)
+	buf.Write(frameMAC(key, n, flag, ciphertext))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// writeFileStream encrypts the content read from r with key and writes it as
+// a sequence of frames to w.
+func writeFileStream(w io.Writer, key *fileKey, r io.Reader) error {
+	stream := aes256.CTRStream(key.aesKey, key.iv)
+	chunk := make([]byte, fileChunkSize)
+	var n uint64
+	for {
+		l, err := io.ReadFull(r, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return log.Error(err)
+		}
+		flag := frameData
+		if l < len(chunk) {
+			flag = frameFinal
+		}
+		ciphertext := make([]byte, l)
+		stream.XORKeyStream(ciphertext, chunk[:l])
+		if err := writeFrame(w, key.hmacKey, n, flag, ciphertext); err != nil {
+			return err
+		}
+		if flag == frameFinal {
+			return nil
+		}
+		n++
+	}
+}
+
+// readFileStream reads a sequence of frames from r, authenticates and
+// decrypts them with key, and writes the content to w.
+func readFileStream(w io.Writer, key *fileKey, r io.Reader) error {
+	stream := aes256.CTRStream(key.aesKey, key.iv)
+	var n uint64
+	for {
+		var hdr [5]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return log.Error("cryptengine: encrypted file is truncated")
+			}
+			return log.Error(err)
+		}
+		flag := hdr[0]
+		if flag != frameData && flag != frameFinal {
+			return log.Errorf("cryptengine: unknown frame flag %d", flag)
+		}
+		l := binary.BigEndian.Uint32(hdr[1:])
+		if l > fileChunkSize {
+			return log.Errorf("cryptengine: frame too large (%d bytes)", l)
+		}
+		ciphertext := make([]byte, l)
+		if _, err := io.ReadFull(r, ciphertext); err != nil {
+			return log.Error("cryptengine: encrypted file is truncated")
+		}
+		mac := make([]byte, sha512.Size)
+		if _, err := io.ReadFull(r, mac); err != nil {
+			return log.Error("cryptengine: encrypted file is truncated")
+		}
+		if !hmac.Equal(mac, frameMAC(key.hmacKey, n, flag, ciphertext)) {
+			return log.Errorf("cryptengine: HMAC of frame %d does not verify", n)
+		}
+		stream.XORKeyStream(ciphertext, ciphertext)
+		if _, err := w.Write(ciphertext); err != nil {
+			return log.Error(err)
+		}
+		if flag == frameFinal {
+			return nil
+		}
+		n++
+	}
+}
+
+// writeFileHeader writes fileMagic and the encrypted header message hdr to w.
+func writeFileHeader(w io.Writer, hdr []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString(fileMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(len(hdr)))
+	buf.Write(hdr)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// readFileHeader reads fileMagic and the encrypted header message from r.
+func readFileHeader(r io.Reader) ([]byte, error) {
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, log.Error("cryptengine: not an encrypted file")
+	}
+	if string(magic) != fileMagic {
+		return nil, log.Error("cryptengine: not an encrypted file")
+	}
+	var l uint32
+	if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+		return nil, log.Error("cryptengine: encrypted file is truncated")
+	}
+	if l > maxFileHeaderSize {
+		return nil, log.Errorf("cryptengine: header too large (%d bytes)", l)
+	}
+	hdr := make([]byte, l)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, log.Error("cryptengine: encrypted file is truncated")
+	}
+	return hdr, nil
+}
+
+// encryptFile reads the file content from r, encrypts it for identity to
+// (with identity from as sender), and writes it to w. The random file key is
+// sent in a normal message (using the session keys or the KeyInit of to),
+// the content itself is streamed in frames and therefore not limited by the
+// message size. If progress is not nil, it is called periodically with the
+// number of bytes of the file read so far.
+func (ce *CryptEngine) encryptFile(
+	w io.Writer,
+	from, to string,
+	sign bool,
+	nymAddress string,
+	r io.Reader,
+	progress ProgressFunc,
+	statusfp *os.File,
+) error {
+	rawKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(cipher.RandReader, rawKey); err != nil {
+		return log.Error(err)
+	}
+	key, err := newFileKey(rawKey)
+	if err != nil {
+		return err
+	}
+	var hdr bytes.Buffer
+	err = ce.encrypt(&hdr, from, to, sign, nymAddress, bytes.NewBuffer(rawKey),
+		statusfp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := writeFileHeader(bw, hdr.Bytes()); err != nil {
+		return err
+	}
+	if err := writeFileStream(bw, key, newProgressReader(r, progress)); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// decryptFile reads an encrypted file from r (see encryptFile), decrypts it,
+// and writes the content to w. If progress is not nil, it is called
+// periodically with the number of bytes of the encrypted file read so far.
+func (ce *CryptEngine) decryptFile(
+	w io.Writer,
+	r io.Reader,
+	progress ProgressFunc,
+	statusfp *os.File,
+) error {
+	br := bufio.NewReader(newProgressReader(r, progress))
+	hdr, err := readFileHeader(br)
+	if err != nil {
+		return err
+	}
+	var rawKey bytes.Buffer
+	var status bytes.Buffer
+	if err := ce.decrypt(&rawKey, bytes.NewBuffer(hdr), &status, false); err != nil {
+		return err
+	}
+	key, err := newFileKey(rawKey.Bytes())
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := readFileStream(bw, key, br); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return log.Error(err)
+	}
+	// only report the sender after the whole file has been authenticated
+	if _, err := fmt.Fprint(statusfp, status.String()); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// createOutput creates the file filename for writing, or returns w if
+// filename is empty.
+func createOutput(filename string, w io.Writer) (io.WriteCloser, error) {
+	if filename == "" {
+		return nopWriteCloser{w}, nil
+	}
+	fp, err := os.Create(filename)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	return fp, nil
+}
+
+// nopWriteCloser wraps an io.Writer with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
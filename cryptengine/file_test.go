@@ -0,0 +1,89 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+)
+
+func TestFileStream(t *testing.T) {
+	rawKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(cipher.RandReader, rawKey); err != nil {
+		t.Fatal(err)
+	}
+	key, err := newFileKey(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// multi-megabyte file, not a multiple of the chunk size
+	content := make([]byte, 5*1024*1024+123)
+	if _, err := io.ReadFull(cipher.RandReader, content); err != nil {
+		t.Fatal(err)
+	}
+	hdr := []byte("header message")
+	var enc bytes.Buffer
+	if err := writeFileHeader(&enc, hdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileStream(&enc, key, bytes.NewBuffer(content)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := enc.Bytes()
+	// round trip
+	r := bytes.NewBuffer(encrypted)
+	h, err := readFileHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, hdr) {
+		t.Error("header differs")
+	}
+	var dec bytes.Buffer
+	if err := readFileStream(&dec, key, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec.Bytes(), content) {
+		t.Error("decrypted file differs")
+	}
+	if bytes.Contains(encrypted, content[:1024]) {
+		t.Error("file content not encrypted")
+	}
+	// tampered frame
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)/2] ^= 1
+	r = bytes.NewBuffer(tampered)
+	if _, err := readFileHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := readFileStream(&dec, key, r); err == nil {
+		t.Error("tampered file should not decrypt")
+	}
+	// truncated file (at frame boundary)
+	frameSize := 5 + fileChunkSize + 64
+	offset := len(fileMagic) + 4 + len(hdr)
+	r = bytes.NewBuffer(encrypted[:offset+2*frameSize])
+	if _, err := readFileHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := readFileStream(&dec, key, r); err == nil {
+		t.Error("truncated file should not decrypt")
+	}
+	// empty file
+	enc.Reset()
+	if err := writeFileStream(&enc, key, bytes.NewBuffer(nil)); err != nil {
+		t.Fatal(err)
+	}
+	dec.Reset()
+	if err := readFileStream(&dec, key, &enc); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Len() != 0 {
+		t.Error("decrypted empty file not empty")
+	}
+}
@@ -7,7 +7,11 @@ package cryptengine
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/mutecomm/mute/cipher"
 	"github.com/mutecomm/mute/cipher/aes256"
@@ -263,6 +267,57 @@ func (ce *CryptEngine) verifyServerSig(
 	return nil
 }
 
+// matchHashChainEntry checks whether the key hash chain entry hcEntry belongs
+// to the mapped identity mappedID, see
+// doc/keyserver.md#key-hashchain-operation. If it does, it returns the UIDHash
+// the entry commits to and its UIDIndex. Otherwise, match is false.
+func matchHashChainEntry(hcEntry, mappedID string) (
+	match bool,
+	UIDHash, UIDIndex []byte,
+	err error,
+) {
+	_, TYPE, NONCE, HashID, CrUID, UIDIndex, err := hashchain.SplitEntry(hcEntry)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	if !bytes.Equal(TYPE, hashchain.Type) {
+		return false, nil, nil,
+			log.Error("cryptengine: invalid hash chain entry type")
+	}
+
+	// Compute k1, k2 = CKDF(NONCE)
+	k1, k2 := cipher.CKDF(NONCE)
+
+	// Compute: HashIDTest = HASH(k1 | Identity)
+	tmp := make([]byte, len(k1)+len(mappedID))
+	copy(tmp, k1)
+	copy(tmp[len(k1):], mappedID)
+	HashIDTest := cipher.SHA256(tmp)
+
+	// If NOT: HashID == HashIDTest: no match
+	if !bytes.Equal(HashID, HashIDTest) {
+		return false, nil, nil, nil
+	}
+	log.Debugf("cryptengine: UIDIndex=%s", base64.Encode(UIDIndex))
+
+	// Compute: IDKEY = HASH(k2 | Identity)
+	tmp = make([]byte, len(k2)+len(mappedID))
+	copy(tmp, k2)
+	copy(tmp[len(k2):], mappedID)
+	IDKEY := cipher.SHA256(tmp)
+
+	// Decrypt UIDHash = AES_256_CBC_Decrypt( IDKEY, CrUID)
+	UIDHash = aes256.CBCDecrypt(IDKEY, CrUID)
+	log.Debugf("cryptengine: UIDHash=%s", base64.Encode(UIDHash))
+
+	// Check UIDIndex = HASH(UIDHash)
+	if !bytes.Equal(UIDIndex, cipher.SHA256(UIDHash)) {
+		return false, nil, nil,
+			log.Error("cryptengine: UIDIndex != HASH(UIDHash)")
+	}
+	return true, UIDHash, UIDIndex, nil
+}
+
 // searchHashChain searches the local hash chain corresponding to the given id
 // for the id. It talks to the corresponding key server to retrieve necessary
 // UIDMessageReplys and stores found UIDMessages in the local keyDB.
@@ -281,7 +336,6 @@ func (ce *CryptEngine) searchHashChain(id string, searchOnly bool) error {
 		return log.Errorf("no hash chain entries found for domain '%s'", domain)
 	}
 
-	var TYPE, NONCE, HashID, CrUID, UIDIndex []byte
 	var matchFound bool
 	for i := uint64(0); i <= max; i++ {
 		hcEntry, err := ce.keyDB.GetHashChainEntry(domain, i)
@@ -290,31 +344,16 @@ func (ce *CryptEngine) searchHashChain(id string, searchOnly bool) error {
 		}
 		log.Debugf("cryptengine: search hash chain entry %d: %s", i, hcEntry)
 
-		_, TYPE, NONCE, HashID, CrUID, UIDIndex, err = hashchain.SplitEntry(hcEntry)
+		match, UIDHash, UIDIndex, err := matchHashChainEntry(hcEntry, mappedID)
 		if err != nil {
 			return err
 		}
-		if !bytes.Equal(TYPE, hashchain.Type) {
-			return log.Error("cryptengine: invalid hash chain entry type")
-		}
-
-		// Compute k1, k2 = CKDF(NONCE)
-		k1, k2 := cipher.CKDF(NONCE)
-
-		// Compute: HashIDTest = HASH(k1 | Identity)
-		tmp := make([]byte, len(k1)+len(mappedID))
-		copy(tmp, k1)
-		copy(tmp[len(k1):], mappedID)
-		HashIDTest := cipher.SHA256(tmp)
-
-		// If NOT: HashID == HashIDTest: Continue
-		if !bytes.Equal(HashID, HashIDTest) {
+		if !match {
 			continue
 		}
 		if searchOnly {
 			return nil
 		}
-		log.Debugf("cryptengine: UIDIndex=%s", base64.Encode(UIDIndex))
 
 		// Check UID already exists in keyDB
 		_, pos, found, err := ce.keyDB.GetPublicUID(mappedID, i)
@@ -327,22 +366,12 @@ func (ce *CryptEngine) searchHashChain(id string, searchOnly bool) error {
 			continue
 		}
 
-		// Compute: IDKEY = HASH(k2 | Identity)
-		tmp = make([]byte, len(k2)+len(mappedID))
-		copy(tmp, k2)
-		copy(tmp[len(k2):], mappedID)
-		IDKEY := cipher.SHA256(tmp)
-
 		// Fetch from Key Repository: UIDMessageReply = GET(UIDIndex)
 		msgReply, err := ce.fetchUID(domain, UIDIndex)
 		if err != nil {
 			return err
 		}
 
-		// Decrypt UIDHash = AES_256_CBC_Decrypt( IDKEY, CrUID)
-		UIDHash := aes256.CBCDecrypt(IDKEY, CrUID)
-		log.Debugf("cryptengine: UIDHash=%s", base64.Encode(UIDHash))
-
 		// Decrypt UIDMessageReply.UIDMessage with UIDHash
 		index, uid, err := msgReply.Decrypt(UIDHash)
 		if err != nil {
@@ -371,6 +400,10 @@ func (ce *CryptEngine) searchHashChain(id string, searchOnly bool) error {
 		if err := ce.keyDB.AddPublicUID(uid, i); err != nil {
 			return err
 		}
+		if found {
+			// key changed
+			ce.invalidateCache(uid)
+		}
 		matchFound = true
 
 		// If no further entry can be found, the latest UIDMessage entry has been found
@@ -409,7 +442,6 @@ func (ce *CryptEngine) lookupHashChain(id string) error {
 		}
 		return log.Error("cryptengine: lookup ID reply has the wrong type")
 	}
-	var TYPE, NONCE, HashID, CrUID, UIDIndex []byte
 	var matchFound bool
 	for k, v := range hcPositions {
 		hcPosFloat, ok := v.(float64)
@@ -421,28 +453,13 @@ func (ce *CryptEngine) lookupHashChain(id string) error {
 		if err != nil {
 			return err
 		}
-		_, TYPE, NONCE, HashID, CrUID, UIDIndex, err = hashchain.SplitEntry(hcEntry)
+		match, UIDHash, UIDIndex, err := matchHashChainEntry(hcEntry, mappedID)
 		if err != nil {
 			return err
 		}
-		if !bytes.Equal(TYPE, hashchain.Type) {
-			return log.Error("cryptengine: invalid entry type")
-		}
-
-		// Compute k1, k2 = CKDF(NONCE)
-		k1, k2 := cipher.CKDF(NONCE)
-
-		// Compute: HashIDTest = HASH(k1 | Identity)
-		tmp := make([]byte, len(k1)+len(mappedID))
-		copy(tmp, k1)
-		copy(tmp[len(k1):], mappedID)
-		HashIDTest := cipher.SHA256(tmp)
-
-		// If NOT: HashID == HashIDTest: Continue
-		if !bytes.Equal(HashID, HashIDTest) {
+		if !match {
 			return log.Error("cryptengine: lookup ID returned bogus position")
 		}
-		log.Debugf("cryptengine: UIDIndex=%s", base64.Encode(UIDIndex))
 
 		// Check UID already exists in keyDB
 		_, pos, found, err := ce.keyDB.GetPublicUID(mappedID, hcPos)
@@ -455,22 +472,12 @@ func (ce *CryptEngine) lookupHashChain(id string) error {
 			continue
 		}
 
-		// Compute: IDKEY = HASH(k2 | Identity)
-		tmp = make([]byte, len(k2)+len(mappedID))
-		copy(tmp, k2)
-		copy(tmp[len(k2):], mappedID)
-		IDKEY := cipher.SHA256(tmp)
-
 		// Fetch from Key Repository: UIDMessageReply = GET(UIDIndex)
 		msgReply, err := ce.fetchUID(domain, UIDIndex)
 		if err != nil {
 			return err
 		}
 
-		// Decrypt UIDHash = AES_256_CBC_Decrypt( IDKEY, CrUID)
-		UIDHash := aes256.CBCDecrypt(IDKEY, CrUID)
-		log.Debugf("cryptengine: UIDHash=%s", base64.Encode(UIDHash))
-
 		// Decrypt UIDMessageReply.UIDMessage with UIDHash
 		index, uid, err := msgReply.Decrypt(UIDHash)
 		if err != nil {
@@ -511,26 +518,120 @@ func (ce *CryptEngine) lookupHashChain(id string) error {
 	return log.Errorf("lookup found no entry of id '%s'", id)
 }
 
-// showHashChain shows the hash chain of the given domain on output-fd.
-func (ce *CryptEngine) showHashChain(domain string) error {
+// hashChainExportVersion is the current version of hash chain exports.
+const hashChainExportVersion = 1
+
+// hashChainExport is the envelope of an exported hash chain.
+type hashChainExport struct {
+	Version int      `json:"version"`
+	Domain  string   `json:"domain"`
+	Entries []string `json:"entries"`
+}
+
+// writeHashChain writes the hash chain entries of the given domain as export
+// envelope to w.
+func writeHashChain(w io.Writer, domain string, entries []string) error {
+	jsn, err := json.Marshal(&hashChainExport{
+		Version: hashChainExportVersion,
+		Domain:  domain,
+		Entries: entries,
+	})
+	if err != nil {
+		return log.Error(err)
+	}
+	if _, err := fmt.Fprintln(w, string(jsn)); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// readHashChain reads an exported hash chain from r. Legacy exports (one
+// entry per line without envelope) are read with an empty domain.
+func readHashChain(r io.Reader) (*hashChainExport, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		var export hashChainExport
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, log.Error(err)
+		}
+		if export.Version != hashChainExportVersion {
+			return nil, log.Errorf("unsupported hash chain export version %d",
+				export.Version)
+		}
+		return &export, nil
+	}
+	// legacy export
+	var export hashChainExport
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			export.Entries = append(export.Entries, line)
+		}
+	}
+	return &export, nil
+}
+
+// getHashChain returns all local hash chain entries of the given domain.
+func (ce *CryptEngine) getHashChain(domain string) ([]string, error) {
 	// make sure we have a hashchain for the given domain
 	max, found, err := ce.keyDB.GetLastHashChainPos(domain)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !found {
-		return log.Errorf("no hash chain entries found for domain '%s'", domain)
+		return nil, log.Errorf("no hash chain entries found for domain '%s'", domain)
 	}
-
-	// show hash chain
+	var entries []string
 	for i := uint64(0); i <= max; i++ {
 		entry, err := ce.keyDB.GetHashChainEntry(domain, i)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		fmt.Fprintln(ce.fileTable.OutputFP, entry)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// showHashChain shows the hash chain of the given domain on output-fd.
+func (ce *CryptEngine) showHashChain(domain string) error {
+	entries, err := ce.getHashChain(domain)
+	if err != nil {
+		return err
 	}
+	return writeHashChain(ce.fileTable.OutputFP, domain, entries)
+}
 
+// diffHashChain compares the local hash chain of the given domain with the
+// exported hash chain read from r and writes the result to w.
+func (ce *CryptEngine) diffHashChain(w io.Writer, r io.Reader, domain string) error {
+	export, err := readHashChain(r)
+	if err != nil {
+		return err
+	}
+	if export.Domain != "" &&
+		identity.MapDomain(export.Domain) != identity.MapDomain(domain) {
+		return log.Errorf("exported hash chain is for domain '%s', not '%s'",
+			export.Domain, domain)
+	}
+	entries, err := ce.getHashChain(domain)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(entries) && i < len(export.Entries); i++ {
+		if entries[i] != export.Entries[i] {
+			fmt.Fprintf(w, "hash chains differ at position %d\n", i)
+			return nil
+		}
+	}
+	if len(entries) != len(export.Entries) {
+		fmt.Fprintf(w, "local hash chain has %d entries, exported hash chain %d\n",
+			len(entries), len(export.Entries))
+		return nil
+	}
+	fmt.Fprintf(w, "hash chains are identical\n")
 	return nil
 }
 
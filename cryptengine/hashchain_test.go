@@ -0,0 +1,126 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/cipher/aes256"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/uid"
+)
+
+var testHashChainEntries = []string{
+	"entry0",
+	"entry1",
+	"entry2",
+}
+
+func TestHashChainExport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeHashChain(&buf, "mute.berlin", testHashChainEntries); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), `{"version":1,"domain":"mute.berlin",`) {
+		t.Errorf("unexpected export: %s", buf.String())
+	}
+	export, err := readHashChain(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Version != hashChainExportVersion {
+		t.Errorf("export.Version == %d", export.Version)
+	}
+	if export.Domain != "mute.berlin" {
+		t.Errorf("export.Domain == %s", export.Domain)
+	}
+	if !reflect.DeepEqual(export.Entries, testHashChainEntries) {
+		t.Errorf("export.Entries == %v", export.Entries)
+	}
+}
+
+func TestHashChainLegacyExport(t *testing.T) {
+	legacy := strings.Join(testHashChainEntries, "\n") + "\n"
+	export, err := readHashChain(strings.NewReader(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Domain != "" {
+		t.Errorf("export.Domain == %s", export.Domain)
+	}
+	if !reflect.DeepEqual(export.Entries, testHashChainEntries) {
+		t.Errorf("export.Entries == %v", export.Entries)
+	}
+}
+
+func TestHashChainExportVersion(t *testing.T) {
+	r := strings.NewReader(`{"version":2,"domain":"mute.berlin","entries":[]}`)
+	if _, err := readHashChain(r); err == nil {
+		t.Error("readHashChain should fail for unknown version")
+	}
+}
+
+// testHashChainEntry returns a key hash chain entry which commits to the UID
+// message msg with the given UIDIndex (see
+// doc/keyserver.md#key-hashchain-operation).
+func testHashChainEntry(t *testing.T, msg *uid.Message, UIDIndex []byte) string {
+	id := msg.Identity()
+	UIDHash, _, _ := msg.Encrypt()
+	nonce := make([]byte, 8)
+	if _, err := cipher.RandReader.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	k1, k2 := cipher.CKDF(nonce)
+	hashID := cipher.SHA256(append(append([]byte{}, k1...), id...))
+	idKey := cipher.SHA256(append(append([]byte{}, k2...), id...))
+	crUID := aes256.CBCEncrypt(idKey, UIDHash, cipher.RandReader)
+	var entry []byte
+	entry = append(entry, hashchain.Type...)
+	entry = append(entry, nonce...)
+	entry = append(entry, hashID...)
+	entry = append(entry, crUID...)
+	entry = append(entry, UIDIndex...)
+	hash := cipher.SHA256(entry)
+	return base64.Encode(append(hash, entry...))
+}
+
+func TestMatchHashChainEntry(t *testing.T) {
+	a := "alice@mute.berlin"
+	msg, err := uid.Create(a, false, "", "", uid.Strict, hashchain.TestEntry,
+		cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UIDHash, UIDIndex, _ := msg.Encrypt()
+	entry := testHashChainEntry(t, msg, UIDIndex)
+	match, hash, index, err := matchHashChainEntry(entry, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Fatal("entry does not match its identity")
+	}
+	if !bytes.Equal(hash, UIDHash) || !bytes.Equal(index, UIDIndex) {
+		t.Error("wrong UIDHash or UIDIndex")
+	}
+	// entry of another identity
+	match, _, _, err = matchHashChainEntry(entry, "bob@mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match {
+		t.Error("entry matches another identity")
+	}
+	// UIDIndex does not correspond to UIDHash
+	entry = testHashChainEntry(t, msg, make([]byte, len(UIDIndex)))
+	if _, _, _, err := matchHashChainEntry(entry, a); err == nil {
+		t.Error("entry with wrong UIDIndex should fail")
+	}
+}
@@ -5,7 +5,12 @@
 package cryptengine
 
 import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
 	"math"
+	"time"
 
 	"github.com/mutecomm/mute/cipher"
 	"github.com/mutecomm/mute/log"
@@ -14,6 +19,10 @@ import (
 	"github.com/mutecomm/mute/util/times"
 )
 
+// addKeyInit generates a new KeyInit message for pseudonym, adds it to the key
+// server (paid with token), and stores it in keyDB. The nymaddress (and its
+// mixaddress) can be empty, then the KeyInit message contains no nym address.
+// The KeyInit message is valid for the configured maximum (--keyinit-validity).
 func (ce *CryptEngine) addKeyInit(pseudonym, mixaddress, nymaddress, token string) error {
 	// map pseudonym
 	id, domain, err := identity.MapPlus(pseudonym)
@@ -27,9 +36,9 @@ func (ce *CryptEngine) addKeyInit(pseudonym, mixaddress, nymaddress, token strin
 		return err
 	}
 	// TODO: fix parameter!
-	ki, pubKeyHash, privateKey, err := msg.KeyInit(0,
-		uint64(times.NinetyDaysLater()), 0, true, domain, mixaddress,
-		nymaddress, cipher.RandReader)
+	ki, pubKeyHash, privateKey, err := msg.KeyInitMax(0,
+		uint64(times.Now())+ce.kiMaxAge, 0, ce.kiMaxAge, true, domain,
+		mixaddress, nymaddress, cipher.RandReader)
 	if err != nil {
 		return err
 	}
@@ -86,7 +95,19 @@ func (ce *CryptEngine) addKeyInit(pseudonym, mixaddress, nymaddress, token strin
 	return nil
 }
 
-func (ce *CryptEngine) fetchKeyInit(pseudonym string) error {
+// defaultKeyInitStaleness is the default staleness window for cached KeyInit
+// messages: a cached KeyInit which expires within this window is refetched.
+const defaultKeyInitStaleness = 24 * time.Hour
+
+// fetchKeyInit fetches a KeyInit message for pseudonym from the key server and
+// stores it in keyDB. A previously fetched KeyInit is reused (and the key
+// server is not contacted), unless it expires within the given staleness
+// window or force is true.
+func (ce *CryptEngine) fetchKeyInit(
+	pseudonym string,
+	force bool,
+	staleness time.Duration,
+) error {
 	// map pseudonym
 	id, domain, err := identity.MapPlus(pseudonym)
 	if err != nil {
@@ -105,6 +126,18 @@ func (ce *CryptEngine) fetchKeyInit(pseudonym string) error {
 	if err != nil {
 		return err
 	}
+	// reuse cached KeyInit, if it is not stale
+	if !force {
+		ki, err := ce.keyDB.GetPublicKeyInit(sigKeyHash)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		stale := uint64(times.Now() + int64(staleness/time.Second))
+		if ki != nil && ki.Contents.NOTAFTER > stale {
+			log.Infof("cryptengine: reuse cached KeyInit for '%s'", id)
+			return nil
+		}
+	}
 	// get JSON-RPC client and capabilities
 	client, _, err := ce.cache.Get(domain, ce.keydPort, ce.keydHost,
 		ce.homedir, "KeyInitRepository.FetchKeyInit")
@@ -130,6 +163,164 @@ func (ce *CryptEngine) fetchKeyInit(pseudonym string) error {
 	return ce.keyDB.AddPublicKeyInit(ki)
 }
 
+// getKeyInit fetches a KeyInit message for pseudonym (or reuses a cached one,
+// see fetchKeyInit) and returns it together with the corresponding UID
+// message.
+func (ce *CryptEngine) getKeyInit(pseudonym string, force bool) (
+	msg *uid.Message,
+	ki *uid.KeyInit,
+	err error,
+) {
+	if err := ce.fetchKeyInit(pseudonym, force, defaultKeyInitStaleness); err != nil {
+		return nil, nil, err
+	}
+	// map pseudonym
+	id, _, err := identity.MapPlus(pseudonym)
+	if err != nil {
+		return nil, nil, err
+	}
+	// get corresponding public ID
+	msg, _, found, err := ce.keyDB.GetPublicUID(id, math.MaxInt64) // TODO: use simpler API
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		return nil, nil, log.Errorf("not UID for '%s' found", id)
+	}
+	sigKeyHash, err := msg.SigKeyHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	ki, err = ce.keyDB.GetPublicKeyInit(sigKeyHash)
+	if err != nil {
+		return nil, nil, log.Error(err)
+	}
+	return msg, ki, nil
+}
+
+// verifyKeyInit fetches a KeyInit message for pseudonym (or reuses a cached
+// one, see fetchKeyInit) and verifies its self-signature and the signature of
+// the key server. Both results are written to w, an error is returned if one
+// of the verifications fails. The key server does not return its signature
+// with fetched KeyInit messages, it is only known for our own KeyInit
+// messages (stored by addKeyInit). Otherwise the server signature is reported
+// as unavailable, which is not a failure.
+func (ce *CryptEngine) verifyKeyInit(w io.Writer, pseudonym string, force bool) error {
+	msg, ki, err := ce.getKeyInit(pseudonym, force)
+	if err != nil {
+		return err
+	}
+	id, domain, err := identity.MapPlus(pseudonym)
+	if err != nil {
+		return err
+	}
+	// get capabilities with signature key(s) of key server
+	_, caps, err := ce.cache.Get(domain, ce.keydPort, ce.keydHost,
+		ce.homedir, "KeyInitRepository.FetchKeyInit")
+	if err != nil {
+		return err
+	}
+	var failed bool
+	// verify self-signature
+	if err := ki.Verify([]string{domain}, msg.SigPubKey()); err != nil {
+		fmt.Fprintf(w, "self-signature: invalid (%s)\n", err)
+		failed = true
+	} else {
+		fmt.Fprintln(w, "self-signature: valid")
+	}
+	// verify server signature (if known)
+	var sig string
+	ke, err := ki.KeyEntryECDHE25519(msg.SigPubKey())
+	if err == nil {
+		sig, err = ce.keyDB.GetPrivateKeyInitSig(ke.HASH)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	switch {
+	case sig == "":
+		fmt.Fprintln(w, "server signature: unavailable")
+	case len(caps.SIGPUBKEYS) == 0:
+		fmt.Fprintln(w, "server signature: no server signature key")
+		failed = true
+	default:
+		err = uid.ErrInvalidSrvSig
+		for _, srvPubKey := range caps.SIGPUBKEYS {
+			if err = ki.VerifySrvSig(sig, srvPubKey); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(w, "server signature: invalid (%s)\n", err)
+			failed = true
+		} else {
+			fmt.Fprintln(w, "server signature: valid")
+		}
+	}
+	if failed {
+		return log.Errorf("cryptengine: verification of KeyInit for '%s' failed",
+			id)
+	}
+	return nil
+}
+
+// keyInitKey is the human-readable form of a key entry in a KeyInit message.
+type keyInitKey struct {
+	Function    string
+	Ciphersuite string
+	Hash        string
+}
+
+// keyInitInfo is the human-readable form of a KeyInit message.
+type keyInitInfo struct {
+	Version    string
+	MsgCount   uint64
+	NotBefore  string
+	NotAfter   string
+	Fallback   bool
+	RepoURI    string
+	MixAddress string
+	NymAddress string
+	Keys       []keyInitKey
+}
+
+// showKeyInit fetches a KeyInit message for pseudonym (or reuses a cached one,
+// see fetchKeyInit) and writes its decoded contents (including the decrypted
+// session anchor) as indented JSON to w.
+func (ce *CryptEngine) showKeyInit(w io.Writer, pseudonym string, force bool) error {
+	msg, ki, err := ce.getKeyInit(pseudonym, force)
+	if err != nil {
+		return err
+	}
+	sa, err := ki.SessionAnchor(msg.SigPubKey())
+	if err != nil {
+		return err
+	}
+	info := keyInitInfo{
+		Version:    ki.Contents.VERSION,
+		MsgCount:   ki.Contents.MSGCOUNT,
+		NotBefore:  ce.tFormat.Sprint(int64(ki.Contents.NOTBEFORE), times.Now()),
+		NotAfter:   ce.tFormat.Sprint(int64(ki.Contents.NOTAFTER), times.Now()),
+		Fallback:   ki.Contents.FALLBACK,
+		RepoURI:    ki.Contents.REPOURI,
+		MixAddress: sa.MIXADDRESS,
+		NymAddress: sa.NYMADDRESS,
+	}
+	for _, ke := range sa.PFKEYS {
+		info.Keys = append(info.Keys, keyInitKey{
+			Function:    ke.FUNCTION,
+			Ciphersuite: ke.CIPHERSUITE,
+			Hash:        ke.HASH,
+		})
+	}
+	jsn, err := json.MarshalIndent(&info, "", "  ")
+	if err != nil {
+		return log.Error(err)
+	}
+	fmt.Fprintln(w, string(jsn))
+	return nil
+}
+
 func (ce *CryptEngine) flushKeyInit(pseudonym string) error {
 	// map pseudonym
 	id, domain, err := identity.MapPlus(pseudonym)
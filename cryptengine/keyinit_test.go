@@ -0,0 +1,361 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/keyserver/capabilities"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/jsonclient"
+	"github.com/mutecomm/mute/util/testutil"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func TestFetchKeyInitCache(t *testing.T) {
+	ks, err := testutil.NewKeyServer("mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	client, err := jsonclient.New(ks.URL(), testutil.CACert())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	caps := &capabilities.Capabilities{
+		METHODS: []string{"KeyInitRepository.FetchKeyInit"},
+	}
+	ce.cache.Put("mute.berlin", client, caps)
+
+	// Bob publishes two KeyInit messages (each can be fetched only once)
+	b := "bob@mute.berlin"
+	msg, err := uid.Create(b, false, "", "", uid.Strict, hashchain.TestEntry,
+		cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPublicUID(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	var kis []*uid.KeyInit
+	for i := uint64(1); i <= 2; i++ {
+		ki, _, _, err := msg.KeyInit(i, now+7*times.Day, now-times.Day, false,
+			"mute.berlin", "", "", cipher.RandReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kis = append(kis, ki)
+	}
+	_, err = client.JSONRPCRequest("KeyInitRepository.AddKeyInit",
+		map[string]interface{}{
+			"SigPubKey": msg.SigPubKey(),
+			"KeyInits":  kis,
+			"Tokens":    []string{"", ""},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigKeyHash, err := msg.SigKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := func() *uid.KeyInit {
+		ki, err := keyDB.GetPublicKeyInit(sigKeyHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ki
+	}
+
+	// first fetch contacts the key server
+	if err := ce.fetchKeyInit(b, false, defaultKeyInitStaleness); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached().JSON(), kis[0].JSON()) {
+		t.Fatal("first fetch should store first KeyInit")
+	}
+	// second fetch reuses the cached KeyInit
+	if err := ce.fetchKeyInit(b, false, defaultKeyInitStaleness); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached().JSON(), kis[0].JSON()) {
+		t.Error("second fetch should reuse cached KeyInit")
+	}
+	// --force refetches (and gets the second KeyInit, which is still there)
+	if err := ce.fetchKeyInit(b, true, defaultKeyInitStaleness); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached().JSON(), kis[1].JSON()) {
+		t.Error("forced fetch should store second KeyInit")
+	}
+	// a cached KeyInit which expires within the staleness window is refetched
+	// (which fails, because the key server has no KeyInits left)
+	if err := ce.fetchKeyInit(b, false, 8*24*time.Hour); err == nil {
+		t.Error("stale KeyInit should be refetched")
+	}
+}
+
+func TestAddKeyInitWithoutNym(t *testing.T) {
+	ks, err := testutil.NewKeyServer("mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	client, err := jsonclient.New(ks.URL(), testutil.CACert())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := client.JSONRPCRequest("KeyRepository.Capabilities", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsn, err := json.Marshal(reply["CAPABILITIES"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caps capabilities.Capabilities
+	if err := json.Unmarshal(jsn, &caps); err != nil {
+		t.Fatal(err)
+	}
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	ce.cache.Put("mute.berlin", client, &caps)
+
+	a := "alice@mute.berlin"
+	msg, err := uid.Create(a, false, "", "", uid.Strict, hashchain.TestEntry,
+		cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPrivateUID(msg); err != nil {
+		t.Fatal(err)
+	}
+	ce.kiMaxAge = 7 * times.Day // shorter validity than default
+	if err := ce.addKeyInit(a, "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	sigKeyHash, err := msg.SigKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kis, err := keyDB.GetPrivateKeyInits(sigKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kis) != 1 {
+		t.Fatalf("len(kis) == %d != 1", len(kis))
+	}
+	if err := kis[0].Verify([]string{"mute.berlin"}, msg.SigPubKey()); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := kis[0].SessionAnchor(msg.SigPubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa.NymAddress() != "" {
+		t.Errorf("KeyInit has nym address %q", sa.NymAddress())
+	}
+	if kis[0].Contents.NOTAFTER > uint64(times.Now())+7*times.Day {
+		t.Error("KeyInit is valid longer than configured")
+	}
+}
+
+func TestVerifyKeyInit(t *testing.T) {
+	ks, err := testutil.NewKeyServer("mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	client, err := jsonclient.New(ks.URL(), testutil.CACert())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := client.JSONRPCRequest("KeyRepository.Capabilities", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsn, err := json.Marshal(reply["CAPABILITIES"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caps capabilities.Capabilities
+	if err := json.Unmarshal(jsn, &caps); err != nil {
+		t.Fatal(err)
+	}
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	ce.cache.Put("mute.berlin", client, &caps)
+
+	// Bob publishes a fallback KeyInit message (which can be fetched again)
+	b := "bob@mute.berlin"
+	msg, err := uid.Create(b, false, "", "", uid.Strict, hashchain.TestEntry,
+		cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPublicUID(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	ki, pubKeyHash, privateKey, err := msg.KeyInit(1, now+7*times.Day,
+		now-times.Day, true,
+		"mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err = client.JSONRPCRequest("KeyInitRepository.AddKeyInit",
+		map[string]interface{}{
+			"SigPubKey": msg.SigPubKey(),
+			"KeyInits":  []*uid.KeyInit{ki},
+			"Tokens":    []string{""},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigs, ok := reply["Signatures"].([]interface{})
+	if !ok || len(sigs) != 1 {
+		t.Fatalf("unexpected AddKeyInit reply: %v", reply)
+	}
+	srvSig, ok := sigs[0].(string)
+	if !ok {
+		t.Fatalf("unexpected AddKeyInit reply: %v", reply)
+	}
+
+	// the server signature of a fetched KeyInit is unknown
+	var out bytes.Buffer
+	if err := ce.verifyKeyInit(&out, b, false); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "self-signature: valid\nserver signature: unavailable\n" {
+		t.Errorf("output == %q", out.String())
+	}
+
+	// own KeyInit with the server signature returned by AddKeyInit
+	err = keyDB.AddPrivateKeyInit(ki, pubKeyHash, msg.SigPubKey(), privateKey,
+		srvSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := ce.verifyKeyInit(&out, b, false); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "self-signature: valid\nserver signature: valid\n" {
+		t.Errorf("output == %q", out.String())
+	}
+
+	// tampered server signature (made with a different key)
+	otherKey, err := cipher.Ed25519Generate(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDB, otherCleanup := newTestKeyDB(t)
+	defer otherCleanup()
+	ce.keyDB = otherDB
+	if err := otherDB.AddPublicUID(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	err = otherDB.AddPrivateKeyInit(ki, pubKeyHash, msg.SigPubKey(),
+		privateKey, ki.Sign(otherKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := ce.verifyKeyInit(&out, b, false); err == nil {
+		t.Error("tampered server signature should fail")
+	}
+	if out.String() != "self-signature: valid\nserver signature: invalid ("+
+		uid.ErrInvalidSrvSig.Error()+")\n" {
+		t.Errorf("output == %q", out.String())
+	}
+}
+
+func TestShowKeyInit(t *testing.T) {
+	ks, err := testutil.NewKeyServer("mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	client, err := jsonclient.New(ks.URL(), testutil.CACert())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	caps := &capabilities.Capabilities{
+		METHODS: []string{"KeyInitRepository.FetchKeyInit"},
+	}
+	ce.cache.Put("mute.berlin", client, caps)
+
+	b := "bob@mute.berlin"
+	msg, err := uid.Create(b, false, "", "", uid.Strict, hashchain.TestEntry,
+		cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPublicUID(msg, 0); err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	notAfter := now + 7*times.Day
+	notBefore := now - times.Day
+	ki, _, _, err := msg.KeyInit(3, notAfter, notBefore, true,
+		"mute.berlin", "mix.mute.berlin", "nymaddress", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPublicKeyInit(ki); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := ki.SessionAnchor(msg.SigPubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// show the cached KeyInit (the key server is not contacted)
+	var out bytes.Buffer
+	if err := ce.showKeyInit(&out, b, false); err != nil {
+		t.Fatal(err)
+	}
+	var info keyInitInfo
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != ki.Contents.VERSION || info.MsgCount != 3 ||
+		info.NotBefore != time.Unix(int64(notBefore), 0).UTC().Format(time.RFC3339) ||
+		info.NotAfter != time.Unix(int64(notAfter), 0).UTC().Format(time.RFC3339) ||
+		!info.Fallback ||
+		info.RepoURI != "mute.berlin" {
+		t.Errorf("contents differ: %+v", info)
+	}
+	if info.MixAddress != "mix.mute.berlin" || info.NymAddress != "nymaddress" {
+		t.Errorf("session anchor differs: %+v", info)
+	}
+	if len(info.Keys) != len(sa.PFKEYS) {
+		t.Fatalf("len(info.Keys) == %d != %d", len(info.Keys), len(sa.PFKEYS))
+	}
+	for i, ke := range sa.PFKEYS {
+		if info.Keys[i].Function != ke.FUNCTION ||
+			info.Keys[i].Ciphersuite != ke.CIPHERSUITE ||
+			info.Keys[i].Hash != ke.HASH {
+			t.Errorf("key %d differs: %+v", i, info.Keys[i])
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/urfave/cli"
+)
+
+// ProgressFunc is called periodically during long-running operations with
+// the number of bytes done so far and the total number of bytes (-1, if the
+// total is unknown).
+type ProgressFunc func(done, total int64)
+
+// progressReader reports the progress of reading from r to progress.
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+// newProgressReader returns a reader which reads from r and reports the
+// progress to progress. If progress is nil, r is returned unchanged.
+func newProgressReader(r io.Reader, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &progressReader{
+		r:        r,
+		total:    inputSize(r),
+		progress: progress,
+	}
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.done += int64(n)
+		pr.progress(pr.done, pr.total)
+	}
+	return n, err
+}
+
+// inputSize returns the number of bytes remaining to be read from r, or -1 if
+// it cannot be determined.
+func inputSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface {
+		Len() int
+	}:
+		return int64(v.Len())
+	case interface {
+		Stat() (os.FileInfo, error)
+		Seek(int64, int) (int64, error)
+	}:
+		fi, err := v.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return fi.Size() - offset
+	}
+	return -1
+}
+
+// statusProgress returns a ProgressFunc which writes the progress to the
+// status-fd, if the --progress option is set. Otherwise nil is returned.
+func (ce *CryptEngine) statusProgress(c *cli.Context) ProgressFunc {
+	if !c.Bool("progress") {
+		return nil
+	}
+	return func(done, total int64) {
+		fmt.Fprintf(ce.fileTable.StatusFP, "PROGRESS:\t%d\t%d\n", done, total)
+	}
+}
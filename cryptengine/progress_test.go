@@ -0,0 +1,68 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+)
+
+func TestProgress(t *testing.T) {
+	rawKey := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(cipher.RandReader, rawKey); err != nil {
+		t.Fatal(err)
+	}
+	key, err := newFileKey(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 3*1024*1024+17)
+	if _, err := io.ReadFull(cipher.RandReader, content); err != nil {
+		t.Fatal(err)
+	}
+	// nil progress is allowed
+	r := bytes.NewReader(content)
+	if newProgressReader(r, nil) != r {
+		t.Error("nil progress should not wrap reader")
+	}
+	check := func(name string, r io.Reader, process func(io.Reader) error) {
+		var calls int
+		var last, deltas int64
+		total := inputSize(r)
+		progress := func(done, tot int64) {
+			calls++
+			if done <= last {
+				t.Errorf("%s: done not increasing: %d <= %d", name, done, last)
+			}
+			if tot != total {
+				t.Errorf("%s: total == %d, should be %d", name, tot, total)
+			}
+			deltas += done - last
+			last = done
+		}
+		if err := process(newProgressReader(r, progress)); err != nil {
+			t.Fatal(err)
+		}
+		if calls < 2 {
+			t.Errorf("%s: progress called %d times", name, calls)
+		}
+		if last != total || deltas != total {
+			t.Errorf("%s: done == %d, should be %d", name, last, total)
+		}
+	}
+	// encryption
+	var enc bytes.Buffer
+	check("encrypt", bytes.NewReader(content), func(r io.Reader) error {
+		return writeFileStream(&enc, key, r)
+	})
+	// decryption
+	check("decrypt", bytes.NewReader(enc.Bytes()), func(r io.Reader) error {
+		return readFileStream(ioutil.Discard, key, r)
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/msg/session/sqlstore"
+	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// parseAge parses a duration like time.ParseDuration, but additionally
+// supports the unit "d" for days (e.g., "90d").
+func parseAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(age, "d"), 10, 32)
+		if err != nil {
+			return 0, log.Errorf("cryptengine: cannot parse age '%s'", age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	if d < 0 {
+		return 0, log.Errorf("cryptengine: negative age '%s'", age)
+	}
+	return d, nil
+}
+
+// sessionPairs maps all session state keys which can be computed from the
+// identities in keyDB to the corresponding pair of identities (mine first).
+func (ce *CryptEngine) sessionPairs() (map[string][2]string, error) {
+	pairs := make(map[string][2]string)
+	myIDs, err := ce.keyDB.GetPrivateIdentities()
+	if err != nil {
+		return nil, err
+	}
+	contactIDs, err := ce.keyDB.GetPublicIdentities()
+	if err != nil {
+		return nil, err
+	}
+	for _, myID := range myIDs {
+		myUID, _, err := ce.keyDB.GetPrivateUID(myID, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, contactID := range contactIDs {
+			contactUID, _, found, err := ce.keyDB.GetPublicUID(contactID,
+				math.MaxInt64)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			key := session.CalcStateKey(myUID.PubKey().PublicKey32(),
+				contactUID.PubKey().PublicKey32())
+			pairs[key] = [2]string{myID, contactID}
+		}
+	}
+	return pairs, nil
+}
+
+// sessionList writes all sessions with their pair of identities and the time
+// of the last activity to w. Sessions whose identities are not known anymore
+// are shown with their session state key (instead of the pair of identities)
+// and a "-" as second column.
+func (ce *CryptEngine) sessionList(w io.Writer) error {
+	infos, err := ce.keyDB.GetSessionStates()
+	if err != nil {
+		return err
+	}
+	pairs, err := ce.sessionPairs()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		lastActivity := ce.tFormat.Sprint(info.LastActivity, times.Now())
+		if pair, ok := pairs[info.SessionStateKey]; ok {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", pair[0], pair[1], lastActivity)
+		} else {
+			fmt.Fprintf(w, "%s\t-\t%s\n", info.SessionStateKey, lastActivity)
+		}
+	}
+	return nil
+}
+
+// sessionPrune deletes all sessions (including their keys) which have not
+// been active for longer than olderThan. If dryRun is true, the session states
+// which would be deleted are only reported.
+func (ce *CryptEngine) sessionPrune(
+	statusfp io.Writer,
+	olderThan string,
+	dryRun bool,
+) error {
+	age, err := parseAge(olderThan)
+	if err != nil {
+		return err
+	}
+	t := times.Now() - int64(age/time.Second)
+	if dryRun {
+		infos, err := ce.keyDB.GetSessionStates()
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for _, info := range infos {
+			if info.LastActivity < t {
+				keys = append(keys, info.SessionStateKey)
+			}
+		}
+		util.WouldDelete(statusfp, "session(s)", int64(len(keys)), keys...)
+		return nil
+	}
+	n, err := ce.keyDB.PruneSessions(t)
+	if err != nil {
+		return err
+	}
+	log.Infof("pruned %d session(s)", n)
+	i18n.Fprintf(statusfp, "pruned %d session(s)\n", n)
+	return nil
+}
+
+// sessionCheck verifies that the message keys of all sessions match their
+// declared number of keys and reports the inconsistent sessions to statusfp.
+// If repair is true, the inconsistencies are fixed.
+func (ce *CryptEngine) sessionCheck(statusfp io.Writer, repair bool) error {
+	checks, err := ce.keyDB.CheckSessions(repair)
+	if err != nil {
+		return err
+	}
+	for _, check := range checks {
+		fmt.Fprintf(statusfp, "%s\tkeys=%d\texcess=%d\tduplicates=%d\n",
+			check.SessionKey, check.NumOfKeys, check.Excess, check.Duplicates)
+	}
+	if repair && len(checks) > 0 {
+		log.Infof("repaired %d session(s)", len(checks))
+		i18n.Fprintf(statusfp, "repaired %d session(s)\n", len(checks))
+	} else {
+		i18n.Fprintf(statusfp, "%d inconsistent session(s)\n", len(checks))
+	}
+	return nil
+}
+
+// sessionRatchet forces a session ratchet step for the session from -> to
+// (see msg.RatchetSession) and writes the hash of the new session key to
+// statusfp.
+func (ce *CryptEngine) sessionRatchet(statusfp io.Writer, from, to string) error {
+	fromID, err := identity.Map(from)
+	if err != nil {
+		return err
+	}
+	toID, err := identity.Map(to)
+	if err != nil {
+		return err
+	}
+	fromUID, _, err := ce.keyDB.GetPrivateUID(fromID, false)
+	if err != nil {
+		return err
+	}
+	toUID, _, found, err := ce.keyDB.GetPublicUID(toID, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return log.Errorf("cryptengine: no UID for '%s' found", to)
+	}
+	key := session.CalcStateKey(fromUID.PubKey().PublicKey32(),
+		toUID.PubKey().PublicKey32())
+	next, err := msg.RatchetSession(sqlstore.New(ce.keyDB), key, cipher.RandReader)
+	if err != nil {
+		if err == msg.ErrNoSession {
+			return log.Errorf("cryptengine: no session %s -> %s found", from, to)
+		}
+		return err
+	}
+	log.Infof("session %s -> %s marked for refresh", from, to)
+	fmt.Fprintf(statusfp, "NEXTSESSIONPUB:\t%s\n", next.HASH)
+	return nil
+}
+
+// sessionPrewarm fetches the KeyInits of the given comma-separated contacts
+// (if necessary) and establishes the initial sessions from -> contact, so that
+// the first messages to the contacts can be encrypted without contacting the
+// key server.
+func (ce *CryptEngine) sessionPrewarm(statusfp io.Writer, from, contacts string) error {
+	fromID, err := identity.Map(from)
+	if err != nil {
+		return err
+	}
+	fromUID, _, err := ce.keyDB.GetPrivateUID(fromID, true)
+	if err != nil {
+		return err
+	}
+	for _, contact := range strings.Split(contacts, ",") {
+		contact = strings.TrimSpace(contact)
+		if contact == "" {
+			continue
+		}
+		toID, err := identity.Map(contact)
+		if err != nil {
+			return err
+		}
+		err = ce.fetchKeyInit(toID, false, defaultKeyInitStaleness)
+		if err != nil {
+			return err
+		}
+		toUID, _, found, err := ce.keyDB.GetPublicUID(toID, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return log.Errorf("cryptengine: no UID for '%s' found", contact)
+		}
+		created, err := msg.PrewarmSession(fromUID, toUID, 0,
+			sqlstore.New(ce.keyDB), cipher.RandReader)
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Infof("session %s -> %s established", fromID, toID)
+			i18n.Fprintf(statusfp, "session %s -> %s established\n", fromID, toID)
+		} else {
+			i18n.Fprintf(statusfp, "session %s -> %s exists\n", fromID, toID)
+		}
+	}
+	return nil
+}
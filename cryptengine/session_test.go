@@ -0,0 +1,459 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/keyserver/capabilities"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/msg/session/sqlstore"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/jsonclient"
+	"github.com/mutecomm/mute/util/testutil"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func TestParseAge(t *testing.T) {
+	testCases := []struct {
+		age string
+		d   time.Duration
+	}{
+		{"90d", 90 * 24 * time.Hour},
+		{"0d", 0},
+		{"36h", 36 * time.Hour},
+	}
+	for _, tc := range testCases {
+		d, err := parseAge(tc.age)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != tc.d {
+			t.Errorf("parseAge(%q) = %s != %s", tc.age, d, tc.d)
+		}
+	}
+	for _, age := range []string{"", "d", "-1d", "-1h", "1w"} {
+		if _, err := parseAge(age); err == nil {
+			t.Errorf("parseAge(%q) should fail", age)
+		}
+	}
+}
+
+func TestSessionList(t *testing.T) {
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	alice, err := uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPrivateUID(alice); err != nil {
+		t.Fatal(err)
+	}
+	bob, err := uid.Create("bob@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPublicUID(bob, 0); err != nil {
+		t.Fatal(err)
+	}
+	key := session.CalcStateKey(alice.PubKey().PublicKey32(),
+		bob.PubKey().PublicKey32())
+	for _, k := range []string{key, "unknown"} {
+		if err := keyDB.SetSessionState(k, &session.State{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var w bytes.Buffer
+	if err := ce.sessionList(&w); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("session list has %d lines != 2:\n%s", len(lines), w.String())
+	}
+	// all lines have the same columns
+	for _, line := range lines {
+		if n := len(strings.Split(line, "\t")); n != 3 {
+			t.Errorf("session list line has %d columns != 3: %q", n, line)
+		}
+	}
+	if !strings.Contains(w.String(), "alice@mute.berlin\tbob@mute.berlin\t") {
+		t.Errorf("session list misses known session:\n%s", w.String())
+	}
+	if !strings.Contains(w.String(), "unknown\t-\t") {
+		t.Errorf("session list misses unknown session:\n%s", w.String())
+	}
+}
+
+// testEngine is a party of a conversation whose keys and sessions are stored
+// in the keyDB of its CryptEngine.
+type testEngine struct {
+	ce  *CryptEngine
+	uid *uid.Message
+}
+
+func (p *testEngine) encrypt(t *testing.T, to *testEngine, content string) string {
+	var w bytes.Buffer
+	args := &msg.EncryptArgs{
+		Writer:                 &w,
+		From:                   p.uid,
+		To:                     to.uid,
+		NymAddress:             "nymaddress",
+		SenderLastKeychainHash: hashchain.TestEntry,
+		Reader:                 bytes.NewBufferString(content),
+		Rand:                   cipher.RandReader,
+		KeyStore:               sqlstore.New(p.ce.keyDB),
+	}
+	if _, err := msg.Encrypt(args); err != nil {
+		t.Fatal(err)
+	}
+	return w.String()
+}
+
+func (p *testEngine) tryDecrypt(enc string) (string, error) {
+	var res, status bytes.Buffer
+	err := p.ce.decrypt(&res, bytes.NewBufferString(enc), &status, false)
+	if err != nil {
+		return "", err
+	}
+	return res.String(), nil
+}
+
+func (p *testEngine) decrypt(t *testing.T, enc string) string {
+	res, err := p.tryDecrypt(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func (p *testEngine) stateKey(other *testEngine) string {
+	return session.CalcStateKey(p.uid.PubKey().PublicKey32(),
+		other.uid.PubKey().PublicKey32())
+}
+
+func (p *testEngine) state(t *testing.T, other *testEngine) *session.State {
+	ss, err := p.ce.keyDB.GetSessionState(p.stateKey(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss == nil {
+		t.Fatal("no session state")
+	}
+	return ss
+}
+
+// newTestEngines returns the CryptEngines of Alice and Bob, Alice knows the
+// KeyInit message of Bob. The returned function removes their keyDBs.
+func newTestEngines(t *testing.T) (alice, bob *testEngine, cleanup func()) {
+	aliceKeyDB, aliceCleanup := newTestKeyDB(t)
+	bobKeyDB, bobCleanup := newTestKeyDB(t)
+	cleanup = func() {
+		aliceCleanup()
+		bobCleanup()
+	}
+	alice = &testEngine{ce: New()}
+	alice.ce.keyDB = aliceKeyDB
+	bob = &testEngine{ce: New()}
+	bob.ce.keyDB = bobKeyDB
+	var err error
+	alice.uid, err = uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	bob.uid, err = uid.Create("bob@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	bobKI, _, privateKey, err := bob.uid.KeyInit(1, now+times.Day,
+		now-times.Day, false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	bobTemp, err := bobKI.KeyEntryECDHE25519(bob.uid.SigPubKey())
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		aliceKeyDB.AddPrivateUID(alice.uid),
+		aliceKeyDB.AddPublicUID(bob.uid, 0),
+		aliceKeyDB.AddPublicKeyInit(bobKI),
+		bobKeyDB.AddPrivateUID(bob.uid),
+		bobKeyDB.AddPrivateKeyInit(bobKI, bobTemp.HASH, bob.uid.SigPubKey(),
+			privateKey, ""),
+	} {
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func TestSessionPrune(t *testing.T) {
+	alice, bob, cleanup := newTestEngines(t)
+	defer cleanup()
+	ce := alice.ce
+	bob.decrypt(t, alice.encrypt(t, bob, "hello bob"))
+	ss := alice.state(t, bob)
+	sessionKey := session.CalcKey(alice.uid.PubKey().HASH,
+		bob.uid.PubKey().HASH, ss.SenderSessionPub.HASH, ss.RecipientTemp.HASH)
+	if err := ce.keyDB.SetSessionState("unknown", &session.State{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// recent sessions are kept
+	var status bytes.Buffer
+	if err := ce.sessionPrune(&status, "1d", false); err != nil {
+		t.Fatal(err)
+	}
+	if status.String() != "pruned 0 session(s)\n" {
+		t.Errorf("unexpected status: %q", status.String())
+	}
+
+	// wait until the sessions are inactive for at least a second
+	time.Sleep(time.Until(time.Unix(times.Now()+1, 0)))
+	status.Reset()
+	if err := ce.sessionPrune(&status, "0d", true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(status.String(), "would delete 2 session(s)\n") ||
+		!strings.Contains(status.String(), "\t"+alice.stateKey(bob)+"\n") ||
+		!strings.Contains(status.String(), "\tunknown\n") {
+		t.Errorf("unexpected status: %q", status.String())
+	}
+	infos, err := ce.keyDB.GetSessionStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("dry run deleted session states: %d != 2", len(infos))
+	}
+
+	status.Reset()
+	if err := ce.sessionPrune(&status, "0d", false); err != nil {
+		t.Fatal(err)
+	}
+	if status.String() != "pruned 2 session(s)\n" {
+		t.Errorf("unexpected status: %q", status.String())
+	}
+	var w bytes.Buffer
+	if err := ce.sessionList(&w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Len() > 0 {
+		t.Errorf("session list after prune:\n%s", w.String())
+	}
+	if sqlstore.New(ce.keyDB).HasSession(sessionKey) {
+		t.Error("session keys have not been pruned")
+	}
+	if err := ce.sessionPrune(&status, "90x", false); err == nil {
+		t.Error("sessionPrune should fail with invalid age")
+	}
+}
+
+func TestSessionRatchet(t *testing.T) {
+	alice, bob, cleanup := newTestEngines(t)
+	defer cleanup()
+	a := alice.uid.Identity()
+	b := bob.uid.Identity()
+	// ratchet without session
+	var status bytes.Buffer
+	if err := alice.ce.sessionRatchet(&status, a, b); err == nil {
+		t.Error("sessionRatchet without session should fail")
+	}
+	// establish session (until no refresh is pending anymore)
+	for i := 0; i < 10; i++ {
+		bob.decrypt(t, alice.encrypt(t, bob, "hello bob"))
+		alice.decrypt(t, bob.encrypt(t, alice, "hello alice"))
+		if alice.state(t, bob).NextSenderSessionPub == nil {
+			break
+		}
+	}
+	old := alice.state(t, bob)
+	if old.NextSenderSessionPub != nil {
+		t.Fatal("session refresh still pending")
+	}
+	// ratchet
+	status.Reset()
+	if err := alice.ce.sessionRatchet(&status, a, b); err != nil {
+		t.Fatal(err)
+	}
+	next := alice.state(t, bob).NextSenderSessionPub
+	if next == nil || next.HASH == old.SenderSessionPub.HASH {
+		t.Fatal("ratchet didn't generate a new session key")
+	}
+	if status.String() != "NEXTSESSIONPUB:\t"+next.HASH+"\n" {
+		t.Errorf("unexpected status: %q", status.String())
+	}
+	status.Reset()
+	if err := alice.ce.sessionRatchet(&status, a, b); err != nil {
+		t.Fatal(err)
+	}
+	if status.String() != "NEXTSESSIONPUB:\t"+next.HASH+"\n" {
+		t.Error("pending ratchet step should be reused")
+	}
+	// after bob confirmed the new session key alice uses it
+	bob.decrypt(t, alice.encrypt(t, bob, "ratchet"))
+	alice.decrypt(t, bob.encrypt(t, alice, "ack"))
+	if s := bob.decrypt(t, alice.encrypt(t, bob, "new session")); s != "new session" {
+		t.Errorf("decrypted %q", s)
+	}
+	if alice.state(t, bob).SenderSessionPub.HASH != next.HASH {
+		t.Error("alice doesn't use new session key")
+	}
+}
+
+func TestKeyWindow(t *testing.T) {
+	alice, bob, cleanup := newTestEngines(t)
+	defer cleanup()
+	bob.ce.keyWindow = 3
+	bob.decrypt(t, alice.encrypt(t, bob, "msg 0"))
+	var msgs []string
+	for i := 1; i < 10; i++ {
+		msgs = append(msgs, alice.encrypt(t, bob, "msg"))
+	}
+	// receive the last message first
+	bob.decrypt(t, msgs[8])
+	if n := bob.state(t, alice).MaxRecipientCount; n != 9 {
+		t.Errorf("MaxRecipientCount == %d, should be 9", n)
+	}
+	// late messages within the window can still be decrypted
+	bob.decrypt(t, msgs[7])
+	bob.decrypt(t, msgs[5])
+	// the keys of older messages have been pruned from the keyDB
+	for _, i := range []int{0, 3, 4} {
+		if _, err := bob.tryDecrypt(msgs[i]); err == nil {
+			t.Errorf("message %d decrypted after its key was pruned", i+1)
+		}
+	}
+}
+
+func TestSessionPrewarm(t *testing.T) {
+	ks, err := testutil.NewKeyServer("mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	client, err := jsonclient.New(ks.URL(), testutil.CACert())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDB, cleanup := newTestKeyDB(t)
+	defer cleanup()
+	ce := New()
+	ce.keyDB = keyDB
+	caps := &capabilities.Capabilities{
+		METHODS: []string{"KeyInitRepository.FetchKeyInit"},
+	}
+	ce.cache.Put("mute.berlin", client, caps)
+
+	alice, err := uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPrivateUID(alice); err != nil {
+		t.Fatal(err)
+	}
+	// Bob and Carol publish a KeyInit message each
+	now := uint64(times.Now())
+	var contacts []*uid.Message
+	for _, id := range []string{"bob@mute.berlin", "carol@mute.berlin"} {
+		contact, err := uid.Create(id, false, "", "", uid.Strict,
+			hashchain.TestEntry, cipher.RandReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyDB.AddPublicUID(contact, 0); err != nil {
+			t.Fatal(err)
+		}
+		ki, _, _, err := contact.KeyInit(1, now+7*times.Day, now-times.Day,
+			false, "mute.berlin", "", "", cipher.RandReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.JSONRPCRequest("KeyInitRepository.AddKeyInit",
+			map[string]interface{}{
+				"SigPubKey": contact.SigPubKey(),
+				"KeyInits":  []*uid.KeyInit{ki},
+				"Tokens":    []string{""},
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		contacts = append(contacts, contact)
+	}
+
+	var status bytes.Buffer
+	err = ce.sessionPrewarm(&status, "alice@mute.berlin",
+		"bob@mute.berlin,carol@mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(status.String(), "established"); n != 2 {
+		t.Errorf("%d sessions established != 2:\n%s", n, status.String())
+	}
+
+	// no further network calls are necessary
+	ks.Close()
+	for _, contact := range contacts {
+		key := session.CalcStateKey(alice.PubKey().PublicKey32(),
+			contact.PubKey().PublicKey32())
+		ss, err := keyDB.GetSessionState(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ss == nil {
+			t.Fatalf("no session state for %s", contact.Identity())
+		}
+		sessionKey := session.CalcKey(alice.PubKey().HASH,
+			contact.PubKey().HASH, ss.SenderSessionPub.HASH,
+			ss.RecipientTemp.HASH)
+		if !sqlstore.New(ce.keyDB).HasSession(sessionKey) {
+			t.Errorf("no session for %s", contact.Identity())
+		}
+		var w bytes.Buffer
+		args := &msg.EncryptArgs{
+			Writer:                 &w,
+			From:                   alice,
+			To:                     contact,
+			NymAddress:             "nymaddress",
+			SenderLastKeychainHash: hashchain.TestEntry,
+			Reader:                 bytes.NewBufferString("hello"),
+			Rand:                   cipher.RandReader,
+			KeyStore:               sqlstore.New(ce.keyDB),
+		}
+		if _, err := msg.Encrypt(args); err != nil {
+			t.Errorf("encrypt to %s: %s", contact.Identity(), err)
+		}
+	}
+	// prewarming again keeps the existing sessions
+	status.Reset()
+	err = ce.sessionPrewarm(&status, "alice@mute.berlin", "bob@mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status.String(), "exists") {
+		t.Errorf("unexpected status: %s", status.String())
+	}
+}
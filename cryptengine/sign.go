@@ -0,0 +1,113 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/uid/identity"
+)
+
+// fileSigPrefix is prepended to the content of files before they are signed
+// (see sign), which separates detached file signatures from permanent message
+// signatures.
+const fileSigPrefix = "MUTE-FILE-SIGNATURE\n"
+
+// fileSigContent returns the content of a file as it is signed (see
+// fileSigPrefix).
+func fileSigContent(content []byte) []byte {
+	return append([]byte(fileSigPrefix), content...)
+}
+
+// signContent returns the base64 encoded detached signature of content made
+// with the signature key of the private UID message uidMsg. The signature is
+// made over the SHA512 hash of content, like permanent message signatures.
+func signContent(uidMsg *uid.Message, content []byte) (string, error) {
+	var key cipher.Ed25519Key
+	if err := key.SetPrivateKey(uidMsg.PrivateSigKey64()[:]); err != nil {
+		return "", err
+	}
+	return base64.Encode(key.Sign(cipher.SHA512(content))), nil
+}
+
+// writeDetachedSig writes the detached signature sig to the file filename,
+// or w if filename is empty.
+func writeDetachedSig(w io.Writer, filename, sig string) error {
+	if filename == "" {
+		if _, err := fmt.Fprintln(w, sig); err != nil {
+			return log.Error(err)
+		}
+		return nil
+	}
+	if err := ioutil.WriteFile(filename, []byte(sig+"\n"), 0644); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// readDetachedSig reads a detached signature from the file filename (see
+// writeDetachedSig).
+func readDetachedSig(filename string) (string, error) {
+	sig, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", log.Error(err)
+	}
+	return strings.TrimSpace(string(sig)), nil
+}
+
+// openInput opens the file filename for reading, or returns r if filename is
+// empty.
+func openInput(filename string, r io.Reader) (io.ReadCloser, error) {
+	if filename == "" {
+		if fp, ok := r.(*os.File); ok {
+			// keep Stat for progress reporting
+			return nopCloseFile{fp}, nil
+		}
+		return ioutil.NopCloser(r), nil
+	}
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	return fp, nil
+}
+
+// sign makes a detached signature of the content read from r (prefixed with
+// fileSigPrefix) with the signature key of id and writes it to the file
+// outfile, or w if outfile is empty.
+func (ce *CryptEngine) sign(w io.Writer, id, outfile string, r io.Reader) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	uidMsg, _, err := ce.keyDB.GetPrivateUID(idMapped, true)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return log.Error(err)
+	}
+	sig, err := signContent(uidMsg, fileSigContent(content))
+	if err != nil {
+		return err
+	}
+	return writeDetachedSig(w, outfile, sig)
+}
+
+// nopCloseFile wraps an *os.File with a no-op Close method.
+type nopCloseFile struct {
+	*os.File
+}
+
+func (nopCloseFile) Close() error { return nil }
@@ -8,6 +8,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"strings"
 
@@ -15,6 +17,8 @@ import (
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/uid"
 	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util"
+	"github.com/mutecomm/mute/util/times"
 )
 
 // generate a new nym and store it in keydb.
@@ -156,8 +160,13 @@ func (ce *CryptEngine) update(pseudonym, tokenString string) error {
 	return ce.registerOrUpdate(pseudonym, tokenString, "UpdateUID", "updated")
 }
 
-// deleteUID deletes a nym.
-func (ce *CryptEngine) deleteUID(pseudonym string, force bool) error {
+// deleteUID deletes a nym. If dryRun is true, the nym which would be deleted
+// is only reported on statusfp.
+func (ce *CryptEngine) deleteUID(
+	statusfp io.Writer,
+	pseudonym string,
+	force, dryRun bool,
+) error {
 	// map pseudonym
 	id, err := identity.Map(pseudonym)
 	if err != nil {
@@ -170,6 +179,11 @@ func (ce *CryptEngine) deleteUID(pseudonym string, force bool) error {
 		return err
 	}
 
+	if dryRun {
+		util.WouldDelete(statusfp, "user ID(s)", 1, id)
+		return nil
+	}
+
 	// ask for manual confirmation
 	if !force {
 		fmt.Fprintf(os.Stderr, "cryptengine: delete user ID %s and all it's key material? ",
@@ -199,3 +213,121 @@ func (ce *CryptEngine) listUIDs(outfp *os.File) error {
 	}
 	return nil
 }
+
+// uidState is the state of an own user ID in the keyDB.
+type uidState struct {
+	ID         string // the mapped user ID
+	Registered bool   // the current UID message has been registered
+	KeyInits   int    // number of currently valid KeyInit messages
+}
+
+// uidStatus writes the state of all own (mapped) user IDs as JSON to w.
+func (ce *CryptEngine) uidStatus(w io.Writer) error {
+	ids, err := ce.keyDB.GetPrivateIdentities()
+	if err != nil {
+		return err
+	}
+	now := uint64(times.Now())
+	states := make([]uidState, 0, len(ids))
+	for _, id := range ids {
+		msg, msgReply, err := ce.keyDB.GetPrivateUID(id, false)
+		if err != nil {
+			return err
+		}
+		sigKeyHash, err := msg.SigKeyHash()
+		if err != nil {
+			return log.Error(err)
+		}
+		kis, err := ce.keyDB.GetPrivateKeyInits(sigKeyHash)
+		if err != nil {
+			return err
+		}
+		state := uidState{ID: id, Registered: msgReply != nil}
+		for _, ki := range kis {
+			if ki.Contents.NOTBEFORE <= now && now < ki.Contents.NOTAFTER {
+				state.KeyInits++
+			}
+		}
+		states = append(states, state)
+	}
+	jsn, err := json.Marshal(states)
+	if err != nil {
+		return log.Error(err)
+	}
+	if _, err := fmt.Fprintln(w, string(jsn)); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// verifyUID verifies the public UID message uidMsg of mappedID, which has
+// been stored for the hash chain position pos, against the local copy of the
+// key hash chain of domain. It returns false, if the UID message is not
+// covered by the local hash chain.
+func (ce *CryptEngine) verifyUID(
+	uidMsg *uid.Message,
+	mappedID, domain string,
+	pos uint64,
+) (bool, error) {
+	lastPos, found, err := ce.keyDB.GetLastHashChainPos(domain)
+	if err != nil {
+		return false, err
+	}
+	if !found || pos > lastPos {
+		return false, nil
+	}
+	entry, err := ce.keyDB.GetHashChainEntry(domain, pos)
+	if err != nil {
+		return false, err
+	}
+	match, uidHash, _, err := matchHashChainEntry(entry, mappedID)
+	if err != nil {
+		return false, err
+	}
+	// the entry belongs to mappedID and commits to uidMsg
+	if !match || !bytes.Equal(uidHash, cipher.SHA256(uidMsg.JSON())) {
+		return false, nil
+	}
+	if err := uidMsg.VerifySelfSig(); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// uidFingerprint writes the fingerprint (SIGKEYHASH) of the current
+// signature key of the user ID id to w, preceded by VERIFIED, if the UID
+// message is verified with the local copy of the key hash chain, and
+// UNVERIFIED otherwise. If no UID message is known for id, UNKNOWN is
+// written without a fingerprint.
+func (ce *CryptEngine) uidFingerprint(w io.Writer, id string) error {
+	idMapped, domain, err := identity.MapPlus(id)
+	if err != nil {
+		return err
+	}
+	uidMsg, pos, found, err := ce.keyDB.GetPublicUID(idMapped, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if _, err := fmt.Fprintln(w, "UNKNOWN\t"); err != nil {
+			return log.Error(err)
+		}
+		return nil
+	}
+	fingerprint, err := uidMsg.SigKeyHash()
+	if err != nil {
+		return log.Error(err)
+	}
+	verified, err := ce.verifyUID(uidMsg, idMapped, domain, pos)
+	if err != nil {
+		return err
+	}
+	result := "UNVERIFIED"
+	if verified {
+		result = "VERIFIED"
+	}
+	if _, err := fmt.Fprintf(w, "%s\t%s\n", result, fingerprint); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/uid/identity"
+)
+
+// verifySignature verifies the base64 encoded permanent signature sig of the
+// message content with the signature key of the UID message uidMsg. It
+// returns the SIGKEYHASH of the signature key as fingerprint.
+func verifySignature(
+	uidMsg *uid.Message,
+	content []byte,
+	sig string,
+) (valid bool, fingerprint string, err error) {
+	sigBuf, err := base64.Decode(sig)
+	if err != nil {
+		return false, "", err
+	}
+	if len(sigBuf) != ed25519.SignatureSize {
+		return false, "", log.Errorf("cryptengine: signature has wrong length %d",
+			len(sigBuf))
+	}
+	fingerprint, err = uidMsg.SigKeyHash()
+	if err != nil {
+		return false, "", log.Error(err)
+	}
+	var key cipher.Ed25519Key
+	if err := key.SetPublicKey(uidMsg.PublicSigKey32()[:]); err != nil {
+		return false, "", err
+	}
+	valid = key.Verify(cipher.SHA512(content), sigBuf)
+	return valid, fingerprint, nil
+}
+
+// verify verifies the signature sig of the message content or file read
+// from r against the signature key (SIGKEY) of the latest stored UID message
+// of the signer id. The UID message is not checked against the hash chain
+// (see uidFingerprint for that). If file is true, sig is a detached file
+// signature (see sign). It writes VALID or INVALID together with the key
+// fingerprint to w.
+func (ce *CryptEngine) verify(
+	w io.Writer,
+	id, sig string,
+	r io.Reader,
+	file bool,
+) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	uidMsg, _, found, err := ce.keyDB.GetPublicUID(idMapped, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return log.Errorf("cryptengine: no UID for '%s' found", id)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return log.Error(err)
+	}
+	if file {
+		content = fileSigContent(content)
+	}
+	valid, fingerprint, err := verifySignature(uidMsg, content, sig)
+	if err != nil {
+		return err
+	}
+	result := "INVALID"
+	if valid {
+		result = "VALID"
+	}
+	if _, err := fmt.Fprintf(w, "%s\t%s\n", result, fingerprint); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cryptengine
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/uid"
+)
+
+func TestVerifySignature(t *testing.T) {
+	alice, err := uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("subject\nsigned body")
+	privKey := ed25519.PrivateKey(alice.PrivateSigKey64()[:])
+	sig := base64.Encode(ed25519.Sign(privKey, cipher.SHA512(content)))
+	fingerprint, err := alice.SigKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// valid signature
+	valid, fp, err := verifySignature(alice, content, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Error("signature should be valid")
+	}
+	if fp != fingerprint {
+		t.Errorf("fingerprint == %s != %s", fp, fingerprint)
+	}
+	// tampered body
+	valid, _, err = verifySignature(alice, []byte("subject\ntampered body"), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Error("signature of tampered body should be invalid")
+	}
+	// malformed signature
+	if _, _, err := verifySignature(alice, content, base64.Encode([]byte("sig"))); err == nil {
+		t.Error("malformed signature should fail")
+	}
+}
+
+func TestDetachedSignature(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "cryptengine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	alice, err := uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// round trip
+	file := filepath.Join(tmpdir, "doc.pdf")
+	if err := ioutil.WriteFile(file, []byte("%PDF-1.4 document"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signContent(alice, fileSigContent(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigFile := file + ".sig"
+	if err := writeDetachedSig(nil, sigFile, sig); err != nil {
+		t.Fatal(err)
+	}
+	detached, err := readDetachedSig(sigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, _, err := verifySignature(alice, fileSigContent(content), detached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid {
+		t.Error("detached signature should be valid")
+	}
+	// file signatures are no valid message signatures
+	valid, _, err = verifySignature(alice, content, detached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Error("detached signature should be invalid as message signature")
+	}
+	// tamper detection
+	content[0] ^= 1
+	valid, _, err = verifySignature(alice, fileSigContent(content), detached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Error("detached signature of tampered file should be invalid")
+	}
+	// signature of another identity
+	bob, err := uid.Create("bob@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[0] ^= 1
+	valid, _, err = verifySignature(bob, fileSigContent(content), detached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Error("detached signature should be invalid for other signer")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msgdb"
+)
+
+// getAliases returns the command aliases stored in msgDB.
+func (ce *CtrlEngine) getAliases() (map[string]string, error) {
+	jsn, err := ce.msgDB.GetValue(msgdb.Aliases)
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string)
+	if jsn != "" {
+		if err := json.Unmarshal([]byte(jsn), &aliases); err != nil {
+			return nil, log.Error(err)
+		}
+	}
+	return aliases, nil
+}
+
+func (ce *CtrlEngine) setAliases(aliases map[string]string) error {
+	jsn, err := json.Marshal(aliases)
+	if err != nil {
+		return log.Error(err)
+	}
+	return ce.msgDB.AddValue(msgdb.Aliases, string(jsn))
+}
+
+// aliasSet defines the alias name for the command line given in args.
+// The command line can also be given as a single (quoted) argument.
+func (ce *CtrlEngine) aliasSet(name string, args []string) error {
+	if ce.app.Command(name) != nil {
+		return log.Errorf("ctrlengine: alias '%s' would shadow command", name)
+	}
+	command := strings.Trim(strings.Join(args, " "), `"'`)
+	if strings.TrimSpace(command) == "" {
+		return log.Errorf("ctrlengine: alias '%s' without command", name)
+	}
+	aliases, err := ce.getAliases()
+	if err != nil {
+		return err
+	}
+	aliases[name] = command
+	// make sure the new alias can be expanded
+	if _, err := expandAlias(aliases, []string{name}); err != nil {
+		return err
+	}
+	return ce.setAliases(aliases)
+}
+
+// aliasRemove removes the alias name.
+func (ce *CtrlEngine) aliasRemove(name string) error {
+	aliases, err := ce.getAliases()
+	if err != nil {
+		return err
+	}
+	if _, ok := aliases[name]; !ok {
+		return log.Errorf("ctrlengine: unknown alias '%s'", name)
+	}
+	delete(aliases, name)
+	return ce.setAliases(aliases)
+}
+
+// aliasList lists all aliases sorted by name.
+func (ce *CtrlEngine) aliasList(w io.Writer) error {
+	aliases, err := ce.getAliases()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, aliases[name])
+	}
+	return nil
+}
+
+// expandAlias replaces the first field of the command line given in fields
+// with its alias expansion, repeatedly (aliases can refer to other aliases).
+// Recursive aliases result in ErrRecursiveAlias.
+func expandAlias(aliases map[string]string, fields []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for len(fields) > 0 {
+		command, ok := aliases[fields[0]]
+		if !ok {
+			break
+		}
+		if seen[fields[0]] {
+			return nil, log.Error(ErrRecursiveAlias)
+		}
+		seen[fields[0]] = true
+		fields = append(strings.Fields(command), fields[1:]...)
+	}
+	return fields, nil
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	aliases := map[string]string{
+		"send-bob": "msg add --to bob@mute.berlin",
+		"bob":      "send-bob --from alice@mute.berlin",
+		"loop1":    "loop2 --id a",
+		"loop2":    "loop1",
+	}
+	fields, err := expandAlias(aliases, []string{"send-bob", "--file", "f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"msg", "add", "--to", "bob@mute.berlin", "--file", "f"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("wrong expansion: %v", fields)
+	}
+	// alias referring to another alias
+	fields, err = expandAlias(aliases, []string{"bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"msg", "add", "--to", "bob@mute.berlin",
+		"--from", "alice@mute.berlin"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("wrong expansion: %v", fields)
+	}
+	// no alias
+	fields, err = expandAlias(aliases, []string{"msg", "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, []string{"msg", "list"}) {
+		t.Errorf("wrong expansion: %v", fields)
+	}
+	// recursion
+	if _, err := expandAlias(aliases, []string{"loop1"}); err != ErrRecursiveAlias {
+		t.Errorf("expandAlias() should fail with ErrRecursiveAlias, got: %v", err)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	err := te.run(`alias set send-bob "msg add --to bob@mute.berlin"`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("alias set b send-bob --ttl 1h", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("alias list", 0); err != nil {
+		t.Fatal(err)
+	}
+	expected := "b\tsend-bob --ttl 1h\nsend-bob\tmsg add --to bob@mute.berlin\n"
+	if out := te.output(); out != expected {
+		t.Errorf("wrong alias list: %q", out)
+	}
+	if err := te.run("alias set send-bob b", 0); err != ErrRecursiveAlias {
+		t.Errorf("recursive alias should fail, got: %v", err)
+	}
+	if err := te.run("alias set msg uid list", 0); err == nil {
+		t.Error("alias shadowing a command should fail")
+	}
+	if err := te.run("alias remove b", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("alias remove b", 0); err == nil {
+		t.Error("removing an unknown alias should fail")
+	}
+}
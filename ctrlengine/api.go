@@ -0,0 +1,319 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mutecomm/mute/def"
+	mimeMsg "github.com/mutecomm/mute/msg/mime"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util/times"
+	"github.com/urfave/cli"
+)
+
+/*
+The JSON REST API of the app mode. All requests must be authenticated with the
+session token of the app mode (see checkAuth) and carry the parameter id (the
+user ID to act for). POST requests must send the token in the X-Mute-Token
+header (or the parameter token), the session cookie alone is not sufficient
+(CSRF protection). Results are returned as JSON, errors as plain text with
+the corresponding HTTP status.
+
+  GET  /api/contacts               list contacts of id
+  GET  /api/messages               list messages of id
+  GET  /api/message?msgnum=N       read message N of id
+  POST /api/messages?to=contact    add message (request body) for contact to
+                                   the out queue of id (optional parameter
+                                   ttl sets time to live, e.g., ttl=24h,
+                                   burn=true deletes it after first read,
+                                   content_type sets the content type, e.g.,
+                                   content_type=text/markdown)
+  POST /api/send                   send messages in the out queue of id (held
+                                   until the next scheduled send, if app mode
+                                   runs with --send-interval, unless parameter
+                                   now=true forces an immediate send)
+  POST /api/fetch                  fetch new messages for id
+*/
+
+// tokenHeader is the HTTP header which contains the session token.
+const tokenHeader = "X-Mute-Token"
+
+// tokenCookie is the cookie which contains the session token.
+const tokenCookie = "mute-token"
+
+var errNotAuthenticated = errors.New("ctrlengine: not authenticated")
+
+// checkAuth checks that request r carries the session token (either in the
+// tokenHeader, the query parameter token, or the tokenCookie). Requests which
+// modify state (all but GET and HEAD) are not authenticated by the
+// tokenCookie, because browsers send it along with cross-site requests.
+func checkAuth(r *http.Request) error {
+	token := r.Header.Get(tokenHeader)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" && r.Method != "GET" && r.Method != "HEAD" {
+		return errNotAuthenticated
+	}
+	if token == "" {
+		cookie, err := r.Cookie(tokenCookie)
+		if err != nil {
+			return errNotAuthenticated
+		}
+		token = cookie.Value
+	}
+	auth.RLock()
+	secret := auth.secret
+	auth.RUnlock()
+	if secret == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return errNotAuthenticated
+	}
+	return nil
+}
+
+// authHandler only passes authenticated requests to handler. A session token
+// given as query parameter is stored in the tokenCookie, for subsequent
+// requests of the browser.
+type authHandler struct {
+	handler http.Handler
+}
+
+func (ah *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := checkAuth(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("token") != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookie,
+			Value:    r.URL.Query().Get("token"),
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	ah.handler.ServeHTTP(w, r)
+}
+
+// apiHandler implements the JSON REST API.
+type apiHandler struct {
+	mutex     sync.Mutex // serializes access to ce
+	ce        *CtrlEngine
+	c         *cli.Context
+	muxer     *http.ServeMux
+	scheduler *sendScheduler // nil: messages are sent immediately
+	resender  *sendScheduler // nil: no automatic resend of failed messages
+}
+
+func newAPIHandler(ce *CtrlEngine, c *cli.Context) *apiHandler {
+	ah := &apiHandler{ce: ce, c: c, muxer: http.NewServeMux()}
+	ah.muxer.HandleFunc("/api/contacts", ah.method("GET", ah.contacts))
+	ah.muxer.HandleFunc("/api/messages", ah.messages)
+	ah.muxer.HandleFunc("/api/message", ah.method("GET", ah.message))
+	ah.muxer.HandleFunc("/api/send", ah.method("POST", ah.send))
+	ah.muxer.HandleFunc("/api/fetch", ah.method("POST", ah.fetch))
+	return ah
+}
+
+func (ah *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := checkAuth(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("id") == "" {
+		http.Error(w, "parameter id is mandatory", http.StatusBadRequest)
+		return
+	}
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+	if ah.ce.msgDB == nil {
+		http.Error(w, "message DB locked", http.StatusServiceUnavailable)
+		return
+	}
+	if err := ah.ce.msgSweep(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ah.muxer.ServeHTTP(w, r)
+}
+
+// method only passes requests with the given HTTP method to f.
+func (ah *apiHandler) method(method string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		f(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	jsn, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsn)
+}
+
+func (ah *apiHandler) contacts(w http.ResponseWriter, r *http.Request) {
+	idMapped, err := identity.Map(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contacts, err := ah.ce.msgDB.GetContactList(idMapped, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if contacts == nil {
+		contacts = []*msgdb.Contact{}
+	}
+	writeJSON(w, contacts)
+}
+
+func (ah *apiHandler) messages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		idMapped, err := identity.Map(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ids, err := ah.ce.msgDB.GetMsgIDs(idMapped)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if ids == nil {
+			ids = []*msgdb.MsgID{}
+		}
+		writeJSON(w, ids)
+	case "POST":
+		to := r.URL.Query().Get("to")
+		if to == "" {
+			http.Error(w, "parameter to is mandatory", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if s := r.URL.Query().Get("ttl"); s != "" {
+			var err error
+			ttl, err = time.ParseDuration(s)
+			if err != nil || ttl < 0 {
+				http.Error(w, "parameter ttl is invalid", http.StatusBadRequest)
+				return
+			}
+		}
+		contentType := r.URL.Query().Get("content_type")
+		if contentType != "" {
+			if err := mimeMsg.CheckContentType(contentType); err != nil {
+				http.Error(w, "parameter content_type is invalid",
+					http.StatusBadRequest)
+				return
+			}
+		}
+		opts := &mimeMsg.Options{
+			TTL:         ttl,
+			Burn:        r.URL.Query().Get("burn") == "true",
+			ContentType: contentType,
+		}
+		err := ah.ce.msgAdd(ah.c, r.URL.Query().Get("id"), to, "", "", false,
+			false, nil, def.MinDelay, def.MaxDelay, msgdb.NormalPriority, opts, nil,
+			r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiMessage is the result of GET /api/message.
+type apiMessage struct {
+	MsgNum  int64
+	Message string // message in MIME format (see 'msg read')
+}
+
+func (ah *apiHandler) message(w http.ResponseWriter, r *http.Request) {
+	msgNum, err := strconv.ParseInt(r.URL.Query().Get("msgnum"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err := ah.ce.msgRead(&buf, r.URL.Query().Get("id"), msgNum); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, &apiMessage{MsgNum: msgNum, Message: buf.String()})
+}
+
+// flushAll sends the messages in the out queue of all user IDs, if the
+// message DB is unlocked. It is called by the scheduler.
+func (ah *apiHandler) flushAll() error {
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+	if ah.ce.msgDB == nil {
+		return nil
+	}
+	return ah.ce.msgSend(ah.c, "", true, false, nil)
+}
+
+// resendAll resends the messages of all user IDs whose delivery failed and
+// whose automatic resend is due (see autoResend).
+func (ah *apiHandler) resendAll(policy *resendPolicy) error {
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+	if ah.ce.msgDB == nil {
+		return nil
+	}
+	nyms, err := ah.ce.getNyms("", true)
+	if err != nil {
+		return err
+	}
+	now := times.Now()
+	for _, nym := range nyms {
+		if err := ah.ce.autoResend(ah.c, nym, policy, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ah *apiHandler) send(w http.ResponseWriter, r *http.Request) {
+	if ah.scheduler != nil && r.URL.Query().Get("now") != "true" {
+		// messages are held until the next scheduled send
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err := ah.ce.msgSend(ah.c, r.URL.Query().Get("id"), false, false, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ah *apiHandler) fetch(w http.ResponseWriter, r *http.Request) {
+	if err := ah.ce.msgFetch(ah.c, r.URL.Query().Get("id"), false, ""); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
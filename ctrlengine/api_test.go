@@ -0,0 +1,141 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/msgdb"
+)
+
+// setAuthSecret sets the secret token of the app mode for testing.
+func setAuthSecret(secret string) {
+	auth.Lock()
+	auth.secret = secret
+	auth.Unlock()
+}
+
+// apiRequest executes an authenticated request against the API handler ah.
+func apiRequest(ah http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	r.Header.Set(tokenHeader, "secret")
+	w := httptest.NewRecorder()
+	ah.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIMessages(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	setAuthSecret("secret")
+	defer setAuthSecret("")
+	ah := newAPIHandler(te.ce, nil)
+	// empty message list
+	w := apiRequest(ah, "GET", "/api/messages?id="+a, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/messages: %d %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); body != "[]" {
+		t.Errorf("GET /api/messages: unexpected body: %s", body)
+	}
+	// send message
+	w = apiRequest(ah, "POST", "/api/messages?id="+a+"&to="+b, "hello\nbody\n")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/messages: %d %s", w.Code, w.Body.String())
+	}
+	// sending to unknown contact fails
+	w = apiRequest(ah, "POST", "/api/messages?id="+a+"&to=carol@mute.berlin", "hi")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/messages to unknown contact: %d", w.Code)
+	}
+	// message list contains sent message
+	w = apiRequest(ah, "GET", "/api/messages?id="+a, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/messages: %d %s", w.Code, w.Body.String())
+	}
+	var ids []*msgdb.MsgID
+	if err := json.Unmarshal(w.Body.Bytes(), &ids); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("len(ids) == %d != 1", len(ids))
+	}
+	if ids[0].From != a || ids[0].To != b || ids[0].Subject != "hello" ||
+		ids[0].Incoming || ids[0].Sent {
+		t.Errorf("unexpected message: %+v", ids[0])
+	}
+	// read message
+	w = apiRequest(ah, "GET", "/api/message?id="+a+"&msgnum=1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/message: %d %s", w.Code, w.Body.String())
+	}
+	var msg apiMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgNum != 1 || !strings.HasSuffix(msg.Message, "\r\nbody\n") {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	setAuthSecret("")
+	ah := newAPIHandler(nil, nil)
+	// no secret set (not logged in)
+	w := apiRequest(ah, "GET", "/api/messages?id=alice@mute.berlin", "")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("request without login should be forbidden: %d", w.Code)
+	}
+	// wrong token
+	setAuthSecret("other")
+	defer setAuthSecret("")
+	w = apiRequest(ah, "GET", "/api/messages?id=alice@mute.berlin", "")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("request with wrong token should be forbidden: %d", w.Code)
+	}
+}
+
+func TestAPICSRF(t *testing.T) {
+	setAuthSecret("secret")
+	defer setAuthSecret("")
+	ah := newAPIHandler(nil, nil)
+	// the session cookie alone does not authenticate POST requests
+	for _, method := range []string{"GET", "POST"} {
+		r := httptest.NewRequest(method, "/api/messages", nil)
+		r.AddCookie(&http.Cookie{Name: tokenCookie, Value: "secret"})
+		w := httptest.NewRecorder()
+		ah.ServeHTTP(w, r)
+		if method == "POST" && w.Code != http.StatusForbidden {
+			t.Errorf("POST with cookie only should be forbidden: %d", w.Code)
+		}
+		if method == "GET" && w.Code == http.StatusForbidden {
+			t.Error("GET with cookie should be allowed")
+		}
+	}
+	// an explicit token authenticates POST requests
+	r := httptest.NewRequest("POST", "/api/messages?token=secret", nil)
+	w := httptest.NewRecorder()
+	ah.ServeHTTP(w, r)
+	if w.Code == http.StatusForbidden {
+		t.Error("POST with token parameter should be allowed")
+	}
+	// the cookie set for the token is restricted to same-site requests
+	authed := &authHandler{handler: http.NotFoundHandler()}
+	r = httptest.NewRequest("GET", "/?token=secret", nil)
+	w = httptest.NewRecorder()
+	authed.ServeHTTP(w, r)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Errorf("token cookie should be SameSite=Strict: %v", cookies)
+	}
+}
@@ -8,13 +8,19 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/util/browser"
+	"github.com/mutecomm/mute/util/i18n"
 	"github.com/urfave/cli"
 )
 
@@ -26,7 +32,7 @@ var loginTemplate = `<!doctype html>
 </head>
 <body>
 <h2>Unlock Mute DB</h2>
-<form action="/login" method="post">
+<form action="/login?token={{.}}" method="post">
     Passphrase:<input autofocus type="password" name="passphrase">
     <input type="submit" value="unlock">
 </form>
@@ -36,20 +42,32 @@ var loginTemplate = `<!doctype html>
 
 var t = template.Must(template.New("login").Parse(loginTemplate))
 
+// auth contains the session token of the app mode.
 var auth struct {
 	sync.RWMutex
 	secret string
 }
 
+// tokenFile is the file in homedir which contains the session token while
+// the app mode is running.
+const tokenFile = "app.token"
+
 type loginHandler struct {
+	mutex    *sync.Mutex // serializes access to ce (shared with apiHandler)
 	ce       *CtrlEngine
 	c        *cli.Context
 	statusfp io.Writer
+	loggedIn func() // called after successful login (optional)
 }
 
 func (lh *loginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		if err := t.Execute(w, nil); err != nil {
+		// the form has to carry the token, the cookie doesn't authenticate
+		// POST requests (see checkAuth)
+		auth.RLock()
+		token := auth.secret
+		auth.RUnlock()
+		if err := t.Execute(w, token); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -59,6 +77,8 @@ func (lh *loginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		passphrase := r.Form["passphrase"][0]
+		lh.mutex.Lock()
+		defer lh.mutex.Unlock()
 		lh.ce.passphrase = []byte(passphrase)
 		if err := lh.ce.prepare(lh.c, true, true); err != nil {
 			// TODO: allow to input passphrase again
@@ -66,92 +86,170 @@ func (lh *loginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		fmt.Fprintln(lh.statusfp, "successful login")
-
-		// set cookie
-		secret := cipher.RandPass(cipher.RandReader)
-		auth.Lock()
-		auth.secret = secret
-		auth.Unlock()
-		cookie := &http.Cookie{
-			Name:    "mute",
-			Value:   secret,
-			Path:    "/",
-			Expires: time.Now().UTC().AddDate(0, 0, 30),
+		if lh.loggedIn != nil {
+			lh.loggedIn()
 		}
-		http.SetCookie(w, cookie)
 
 		// redirect to SPA
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
-type staticHandler struct {
-	handler http.Handler
+// checkBindAddress makes sure that httpAddress is a loopback address, unless
+// allowRemote is true.
+func checkBindAddress(httpAddress string, allowRemote bool) error {
+	if allowRemote {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(httpAddress)
+	if err != nil {
+		return log.Error(err)
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else if host != "" {
+		ips, err = net.LookupIP(host)
+		if err != nil {
+			return log.Error(err)
+		}
+	}
+	if len(ips) == 0 {
+		return log.Errorf("ctrlengine: binding to all interfaces (%s) requires --allow-remote",
+			httpAddress)
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return log.Errorf("ctrlengine: binding to non-loopback address %s requires --allow-remote",
+				httpAddress)
+		}
+	}
+	return nil
 }
 
-func (sh *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// check authentication via cookie
-	//
-	// TODO: transform this into a generic authentication wrapper which can be
-	// reused for other handlers
-	cookie, err := r.Cookie("mute")
+// writeTokenFile writes the session token to the tokenFile in homedir, which
+// is only readable by the user.
+func writeTokenFile(homedir, token string) error {
+	filename := filepath.Join(homedir, tokenFile)
+	os.Remove(filename) // ignore error
+	err := ioutil.WriteFile(filename, []byte(token+"\n"), 0600)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusForbidden)
-		return
-	}
-	var secret string
-	auth.RLock()
-	secret = auth.secret
-	auth.RUnlock()
-	// check that cookie has been set and equals random secret
-	if cookie.Value != "" && cookie.Value != secret {
-		http.Error(w, "invalid cookie value", http.StatusForbidden)
-		return
+		return log.Error(err)
 	}
-
-	// serve static page
-	sh.handler.ServeHTTP(w, r)
+	return nil
 }
 
-func (ce *CtrlEngine) appStart(
+// appServer creates the listener and HTTP server for the app mode and
+// returns the address to open in the browser. Every request requires the
+// session token, which is generated randomly, written to the tokenFile in
+// homedir, and contained in the returned address.
+// If sendInterval is greater than zero, the out queue is flushed in this
+// interval until the server is shut down (and immediately after the login,
+// if sendNow is true). If resend is not nil, messages
+// whose delivery failed are resent automatically according to it.
+func (ce *CtrlEngine) appServer(
 	c *cli.Context,
 	statusfp io.Writer,
+	homedir string,
 	docroot string,
 	httpAddress string,
-) error {
+	allowRemote bool,
+	sendInterval time.Duration,
+	sendNow bool,
+	resend *resendPolicy,
+) (net.Listener, *http.Server, string, error) {
+	if err := checkBindAddress(httpAddress, allowRemote); err != nil {
+		return nil, nil, "", err
+	}
+	// generate session token
+	token := cipher.RandPass(cipher.RandReader)
+	if err := writeTokenFile(homedir, token); err != nil {
+		return nil, nil, "", err
+	}
+	auth.Lock()
+	auth.secret = token
+	auth.Unlock()
 	// create listener for a free port
 	l, err := net.Listen("tcp", httpAddress)
 	if err != nil {
-		return err
+		return nil, nil, "", err
+	}
+	if allowRemote {
+		log.Warnf("ctrlengine: remote access to app mode allowed on %s",
+			l.Addr().String())
+		i18n.Fprintf(statusfp, "remote access allowed, requests require token\n")
 	}
 	// create muxer
 	muxer := http.NewServeMux()
 	// register handlers
-	muxer.Handle("/", &staticHandler{
-		handler: http.FileServer(http.Dir(docroot)),
-	})
-	muxer.Handle("/login", &loginHandler{
+	muxer.Handle("/", http.FileServer(http.Dir(docroot)))
+	api := newAPIHandler(ce, c)
+	muxer.Handle("/api/", api)
+	muxer.Handle("/events", newEventsHandler(ce))
+	login := &loginHandler{
+		mutex:    &api.mutex,
 		ce:       ce,
 		c:        c,
 		statusfp: statusfp,
-	})
+	}
+	muxer.Handle("/login", login)
 	// create HTTP server
 	srv := &http.Server{
-		Handler:        muxer,
+		Handler:        &authHandler{handler: muxer},
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
+	if sendInterval > 0 {
+		api.scheduler = newSendScheduler(sendInterval, api.flushAll)
+		api.scheduler.start()
+		srv.RegisterOnShutdown(api.scheduler.shutdown)
+		if sendNow {
+			// the out queue can only be flushed after the DBs are unlocked
+			login.loggedIn = api.scheduler.flushNow
+		}
+		i18n.Fprintf(statusfp, "sending messages every %s\n", sendInterval)
+	}
+	if resend != nil {
+		api.resender = newSendScheduler(resend.backoff, func() error {
+			return api.resendAll(resend)
+		})
+		api.resender.start()
+		srv.RegisterOnShutdown(api.resender.shutdown)
+		i18n.Fprintf(statusfp, "failed messages are resent automatically "+
+			"(%d delivery attempt(s) at most)\n", resend.attempts)
+	}
+	addr := "http://" + l.Addr().String() + "/login?" +
+		url.Values{"token": {token}}.Encode()
+	return l, srv, addr, nil
+}
+
+func (ce *CtrlEngine) appStart(
+	c *cli.Context,
+	statusfp io.Writer,
+	homedir string,
+	docroot string,
+	httpAddress string,
+	allowRemote bool,
+	sendInterval time.Duration,
+	sendNow bool,
+	resend *resendPolicy,
+) error {
+	l, srv, addr, err := ce.appServer(c, statusfp, homedir, docroot,
+		httpAddress, allowRemote, sendInterval, sendNow, resend)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filepath.Join(homedir, tokenFile))
 	// start HTTP server
 	ch := make(chan error)
 	go func() {
 		ch <- srv.Serve(l)
 	}()
 	// try to open browser
-	addr := "http://" + l.Addr().String() + "/login"
-	fmt.Fprintf(statusfp, "open browser for address: %s\n", addr)
+	i18n.Fprintf(statusfp, "open browser for address: %s\n", addr)
 	if !browser.Open(addr) {
-		fmt.Fprintf(statusfp, "could not open browser for address: %s\n", addr)
+		i18n.Fprintf(statusfp, "could not open browser for address: %s\n", addr)
 	}
 	return <-ch
 }
@@ -0,0 +1,147 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckBindAddress(t *testing.T) {
+	for _, addr := range []string{"localhost:0", "127.0.0.1:0", "[::1]:0"} {
+		if err := checkBindAddress(addr, false); err != nil {
+			t.Errorf("checkBindAddress(%s): %s", addr, err)
+		}
+	}
+	for _, addr := range []string{"0.0.0.0:0", ":0", "[::]:0", "192.0.2.1:0"} {
+		if err := checkBindAddress(addr, false); err == nil {
+			t.Errorf("checkBindAddress(%s) should fail", addr)
+		}
+		if err := checkBindAddress(addr, true); err != nil {
+			t.Errorf("checkBindAddress(%s) with --allow-remote: %s", addr, err)
+		}
+	}
+}
+
+// startAppServer starts the app mode server for ce on httpAddress with
+// homedir and returns its base URL and session token.
+func startAppServer(
+	t *testing.T,
+	ce *CtrlEngine,
+	homedir, httpAddress string,
+	allowRemote bool,
+) (net.Listener, string, string) {
+	l, srv, addr, err := ce.appServer(nil, ioutil.Discard, homedir, ".",
+		httpAddress, allowRemote, 0, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	u, err := url.Parse(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := u.Query().Get("token")
+	if token == "" {
+		t.Fatal("token missing in address")
+	}
+	return l, "http://" + l.Addr().String(), token
+}
+
+// httpGet requests url and returns the HTTP status code.
+func httpGet(t *testing.T, url, token string) int {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set(tokenHeader, token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAppServerRemote(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "app_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer setAuthSecret("")
+	var ce CtrlEngine
+	_, _, _, err = ce.appServer(nil, ioutil.Discard, tmpdir, ".", "0.0.0.0:0",
+		false, 0, false, nil)
+	if err == nil {
+		t.Fatal("binding 0.0.0.0 without --allow-remote should fail")
+	}
+	l, base, token := startAppServer(t, &ce, tmpdir, "0.0.0.0:0", true)
+	defer l.Close()
+	if code := httpGet(t, base+"/login", ""); code != http.StatusForbidden {
+		t.Errorf("request without token: %d", code)
+	}
+	if code := httpGet(t, base+"/login", token); code != http.StatusOK {
+		t.Errorf("request with token: %d", code)
+	}
+}
+
+func TestAppServerToken(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "app_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer setAuthSecret("")
+	var ce CtrlEngine
+	l, base, token := startAppServer(t, &ce, tmpdir, "localhost:0", false)
+	defer l.Close()
+	// check token file
+	filename := filepath.Join(tmpdir, tokenFile)
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("token file has mode %o", fi.Mode().Perm())
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(content)) != token {
+		t.Error("token file does not contain token")
+	}
+	// requests without (correct) token are rejected
+	for _, path := range []string{"/login", "/api/messages?id=a@mute.berlin", "/events"} {
+		if code := httpGet(t, base+path, ""); code != http.StatusForbidden {
+			t.Errorf("%s without token: %d", path, code)
+		}
+		if code := httpGet(t, base+path, "wrong"); code != http.StatusForbidden {
+			t.Errorf("%s with wrong token: %d", path, code)
+		}
+	}
+	// requests with token succeed
+	if code := httpGet(t, base+"/login", token); code != http.StatusOK {
+		t.Errorf("/login with token: %d", code)
+	}
+	code := httpGet(t, base+"/login?"+url.Values{"token": {token}}.Encode(), "")
+	if code != http.StatusOK {
+		t.Errorf("/login with token in URL: %d", code)
+	}
+	// message DB is still locked
+	code = httpGet(t, base+"/api/messages?id=a@mute.berlin", token)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("/api/messages with token: %d", code)
+	}
+}
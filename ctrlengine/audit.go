@@ -0,0 +1,316 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// auditFilename is the name of the audit log in the home directory.
+const auditFilename = "audit.log"
+
+// auditHeadKey is the key of the audit log anchor in the msgDB: the number of
+// entries and the hash of the last one ("n\thash"). The anchor is stored
+// outside of the audit log, so truncating or rewriting the audit log (which
+// leaves a consistent hash chain) is detected by verify.
+const auditHeadKey = "AuditHead"
+
+// Audit entry types.
+const (
+	AuditUIDRegistered = "uid-registered" // a new user ID has been registered
+	AuditKeySeen       = "key-seen"       // first key of a contact verified
+	AuditKeyChange     = "key-change"     // the key of a contact changed
+	AuditVerification  = "verification"   // verification status of a contact changed
+	AuditDecryptFailed = "decrypt-failed" // a received message could not be decrypted
+	AuditDelivered     = "delivered"      // a message has been delivered to the mix
+)
+
+// AuditEntry is a single entry of the audit log.
+type AuditEntry struct {
+	Date        int64  // the time the event occurred
+	Type        string // the entry type (AuditUIDRegistered, ...)
+	MyID        string `json:",omitempty"` // the affected user ID
+	Peer        string `json:",omitempty"` // the affected contact
+	Fingerprint string `json:",omitempty"` // SIGKEYHASH of the contact's key
+	Detail      string `json:",omitempty"` // additional information
+	Hash        string // chains the entry to the previous one (see chainHash)
+}
+
+// chainHash returns the hash of entry e chained to the hash prev of the
+// previous entry (empty for the first entry):
+//
+//	Hash = SHA512(prev | JSON(e without Hash))
+func (e *AuditEntry) chainHash(prev string) (string, error) {
+	entry := *e
+	entry.Hash = ""
+	jsn, err := json.Marshal(&entry)
+	if err != nil {
+		return "", log.Error(err)
+	}
+	return base64.Encode(cipher.SHA512(append([]byte(prev), jsn...))), nil
+}
+
+// auditLog is an append-only log of security-relevant events, stored as one
+// JSON object per line. It is separate from the debug log and is never
+// rotated or truncated by Mute. The entries are hash chained, which makes
+// after-the-fact modifications detectable (see verify).
+type auditLog struct {
+	mutex    sync.Mutex
+	filename string // empty: audit log is disabled
+}
+
+// append adds entry to the audit log and chains it to the last entry. It
+// returns the new number of entries and the hash of entry (the new head of
+// the chain).
+func (a *auditLog) append(entry *AuditEntry) (int, string, error) {
+	if entry.Date == 0 {
+		entry.Date = times.Now()
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	entries, err := a.read()
+	if err != nil {
+		return 0, "", err
+	}
+	var prev string
+	if len(entries) > 0 {
+		prev = entries[len(entries)-1].Hash
+	}
+	entry.Hash, err = entry.chainHash(prev)
+	if err != nil {
+		return 0, "", err
+	}
+	jsn, err := json.Marshal(entry)
+	if err != nil {
+		return 0, "", log.Error(err)
+	}
+	fp, err := os.OpenFile(a.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0600)
+	if err != nil {
+		return 0, "", log.Error(err)
+	}
+	defer fp.Close()
+	if _, err := fp.Write(append(jsn, '\n')); err != nil {
+		return 0, "", log.Error(err)
+	}
+	return len(entries) + 1, entry.Hash, nil
+}
+
+// entries returns all entries of the audit log (in the order they have been
+// appended).
+func (a *auditLog) entries() ([]*AuditEntry, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.read()
+}
+
+// read reads all entries of the audit log. The caller must hold a.mutex.
+func (a *auditLog) read() ([]*AuditEntry, error) {
+	fp, err := os.Open(a.filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, log.Error(err)
+	}
+	defer fp.Close()
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, log.Errorf("ctrlengine: audit log line %d: %s", n, err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return entries, nil
+}
+
+// verify checks the hash chain of the audit log and returns the number of
+// entries. The first entry which does not match the chain is reported as
+// error. If anchor is not empty (see auditHeadKey), the log must contain the
+// anchored entry, otherwise it has been truncated or rewritten.
+func (a *auditLog) verify(anchor string) (int, error) {
+	entries, err := a.entries()
+	if err != nil {
+		return 0, err
+	}
+	var prev string
+	for i, e := range entries {
+		hash, err := e.chainHash(prev)
+		if err != nil {
+			return 0, err
+		}
+		if e.Hash != hash {
+			return 0, log.Errorf("ctrlengine: audit log entry %d does not match hash chain",
+				i+1)
+		}
+		prev = e.Hash
+	}
+	if anchor != "" {
+		parts := strings.Split(anchor, "\t")
+		if len(parts) != 2 {
+			return 0, log.Errorf("ctrlengine: audit log anchor not parsable: %s",
+				anchor)
+		}
+		n, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return 0, log.Errorf("ctrlengine: audit log anchor not parsable: %s",
+				anchor)
+		}
+		if len(entries) < n {
+			return 0, log.Errorf("ctrlengine: audit log truncated (%d entries, %d anchored)",
+				len(entries), n)
+		}
+		if n > 0 && entries[n-1].Hash != parts[1] {
+			return 0, log.Errorf("ctrlengine: audit log entry %d does not match anchor",
+				n)
+		}
+	}
+	return len(entries), nil
+}
+
+// audit records entry in the audit log of ce and anchors the new head of the
+// hash chain in the msgDB (if it is open). Failures are logged, but do not
+// abort the operation which caused the event. Nothing is recorded in
+// --read-only mode.
+func (ce *CtrlEngine) audit(entry *AuditEntry) {
+	if ce.auditLog.filename == "" || ce.readOnly {
+		return
+	}
+	n, hash, err := ce.auditLog.append(entry)
+	if err != nil {
+		log.Warnf("ctrlengine: cannot write audit log: %s", err)
+		return
+	}
+	if ce.msgDB != nil {
+		err := ce.msgDB.AddValue(auditHeadKey, fmt.Sprintf("%d\t%s", n, hash))
+		if err != nil {
+			log.Warnf("ctrlengine: cannot anchor audit log: %s", err)
+		}
+	}
+}
+
+// auditKey compares the verification status and the key fingerprint of
+// contact (as seen by myID) with the ones last recorded in the audit log and
+// records the differences (a first verified key, a changed key, or a changed
+// verification status).
+func (ce *CtrlEngine) auditKey(myID, contact string, verified bool, fingerprint string) error {
+	if ce.auditLog.filename == "" {
+		return nil
+	}
+	entries, err := ce.auditLog.entries()
+	if err != nil {
+		return err
+	}
+	var (
+		known           bool
+		wasVerified     bool
+		prevFingerprint string
+	)
+	for _, e := range entries {
+		if e.MyID != myID || e.Peer != contact {
+			continue
+		}
+		switch e.Type {
+		case AuditKeySeen, AuditKeyChange:
+			known, wasVerified = true, true
+		case AuditVerification:
+			known, wasVerified = true, e.Detail == "verified"
+		default:
+			continue
+		}
+		if e.Fingerprint != "" {
+			prevFingerprint = e.Fingerprint
+		}
+	}
+	switch {
+	case !known && verified:
+		ce.audit(&AuditEntry{
+			Type:        AuditKeySeen,
+			MyID:        myID,
+			Peer:        contact,
+			Fingerprint: fingerprint,
+		})
+	case known && wasVerified != verified:
+		detail := "unverified"
+		if verified {
+			detail = "verified"
+		}
+		ce.audit(&AuditEntry{
+			Type:        AuditVerification,
+			MyID:        myID,
+			Peer:        contact,
+			Fingerprint: fingerprint,
+			Detail:      detail,
+		})
+	}
+	if verified && prevFingerprint != "" && fingerprint != prevFingerprint {
+		log.Warnf("ctrlengine: key of contact %s changed", contact)
+		ce.audit(&AuditEntry{
+			Type:        AuditKeyChange,
+			MyID:        myID,
+			Peer:        contact,
+			Fingerprint: fingerprint,
+			Detail:      "previous key " + prevFingerprint,
+		})
+	}
+	return nil
+}
+
+// auditShow writes all entries of the audit log to w (as JSON, if jsonOutput
+// is set).
+func (ce *CtrlEngine) auditShow(w io.Writer, jsonOutput bool) error {
+	entries, err := ce.auditLog.entries()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		if entries == nil {
+			entries = []*AuditEntry{}
+		}
+		jsn, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return log.Error(err)
+		}
+		fmt.Fprintln(w, string(jsn))
+		return nil
+	}
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			ce.fmtTime(e.Date), e.Type, e.MyID,
+			e.Peer, e.Fingerprint, e.Detail)
+	}
+	return nil
+}
+
+// auditVerify verifies the hash chain of the audit log against the anchor in
+// the msgDB and writes the result to w.
+func (ce *CtrlEngine) auditVerify(w io.Writer) error {
+	anchor, err := ce.msgDB.GetValue(auditHeadKey)
+	if err != nil {
+		return err
+	}
+	n, err := ce.auditLog.verify(anchor)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "audit log intact (%d entries)\n", n)
+	return nil
+}
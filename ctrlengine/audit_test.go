@@ -0,0 +1,158 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditKeyChange(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	// empty audit log
+	if err := te.run("audit show --json", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := strings.TrimSpace(te.output()); out != "[]" {
+		t.Errorf("audit log should be empty: %s", out)
+	}
+	// simulate key change detection
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	for _, fingerprint := range []string{"FP1", "FP1", "FP2"} {
+		if err := te.ce.auditKey(a, b, true, fingerprint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := te.ce.auditKey(a, b, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	te.ce.audit(&AuditEntry{Type: AuditDelivered, MyID: a})
+	if err := te.run("audit show --json", 0); err != nil {
+		t.Fatal(err)
+	}
+	var entries []AuditEntry
+	if err := json.Unmarshal([]byte(te.output()), &entries); err != nil {
+		t.Fatal(err)
+	}
+	types := []string{AuditKeySeen, AuditKeyChange, AuditVerification,
+		AuditDelivered}
+	if len(entries) != len(types) {
+		t.Fatalf("len(entries) == %d != %d: %+v", len(entries), len(types),
+			entries)
+	}
+	for i, e := range entries {
+		if e.Type != types[i] {
+			t.Errorf("entries[%d].Type == %s != %s", i, e.Type, types[i])
+		}
+		if e.Date == 0 {
+			t.Errorf("entries[%d] has no timestamp", i)
+		}
+	}
+	change := entries[1]
+	if change.MyID != a || change.Peer != b || change.Fingerprint != "FP2" ||
+		change.Detail != "previous key FP1" {
+		t.Errorf("wrong key change entry: %+v", change)
+	}
+	if entries[2].Detail != "unverified" {
+		t.Errorf("wrong verification entry: %+v", entries[2])
+	}
+	// plain output
+	if err := te.run("audit show", 0); err != nil {
+		t.Fatal(err)
+	}
+	out := te.output()
+	if !strings.Contains(out, "\tkey-change\talice@mute.berlin\tbob@mute.berlin\tFP2\tprevious key FP1\n") {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+func TestAuditVerify(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("audit verify", 1); err != nil {
+		t.Fatal(err)
+	}
+	if st := te.status(); !strings.Contains(st, "audit log intact (0 entries)") {
+		t.Errorf("unexpected status: %s", st)
+	}
+	for _, detail := range []string{"first", "middle", "last"} {
+		te.ce.audit(&AuditEntry{Type: AuditDelivered, Detail: detail})
+	}
+	if err := te.run("audit verify", 0); err != nil {
+		t.Fatal(err)
+	}
+	if st := te.status(); !strings.Contains(st, "audit log intact (3 entries)") {
+		t.Errorf("unexpected status: %s", st)
+	}
+	// modify middle entry
+	filename := filepath.Join(te.homedir, auditFilename)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte(`"middle"`), []byte(`"edited"`), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("middle entry not found")
+	}
+	if err := ioutil.WriteFile(filename, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = te.run("audit verify", 0)
+	if err == nil {
+		t.Fatal("audit verify should detect modified entry")
+	}
+	if !strings.Contains(err.Error(), "entry 2") {
+		t.Errorf("wrong error: %s", err)
+	}
+}
+
+func TestAuditAnchor(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("audit verify", 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, detail := range []string{"first", "second", "third"} {
+		te.ce.audit(&AuditEntry{Type: AuditDelivered, Detail: detail})
+	}
+	filename := filepath.Join(te.homedir, auditFilename)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// truncated log (consistent hash chain)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if err := ioutil.WriteFile(filename, bytes.Join(lines[:2], nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = te.run("audit verify", 0)
+	if err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("audit verify should detect truncated log: %v", err)
+	}
+	// rewritten log (consistent hash chain)
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	var a auditLog
+	a.filename = filename
+	for _, detail := range []string{"first", "second", "forged"} {
+		if _, _, err := a.append(&AuditEntry{Type: AuditDelivered, Detail: detail}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = te.run("audit verify", 0)
+	if err == nil || !strings.Contains(err.Error(), "does not match anchor") {
+		t.Fatalf("audit verify should detect rewritten log: %v", err)
+	}
+}
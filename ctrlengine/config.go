@@ -0,0 +1,198 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mutecomm/mute/configclient"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/encdb"
+	"github.com/mutecomm/mute/log"
+	"github.com/urfave/cli"
+)
+
+// sources of configuration values
+const (
+	sourceDefault = "default"     // baked-in default (see package def)
+	sourceServer  = "server"      // fetched configuration from the config server
+	sourceMsgDB   = "msgdb"       // stored in the message database
+	sourceEnv     = "environment" // environment variable
+	sourceFlag    = "flag"        // command line flag
+)
+
+// configValue is an effective configuration value.
+type configValue struct {
+	Name   string // name of the setting
+	Value  string // effective value
+	Source string // source of the value (default, server, msgdb, environment, or flag)
+}
+
+// flagSource returns the source of the value of a flag: sourceFlag if it was
+// given on the command line, sourceEnv if it was taken from the environment
+// variable envVar, and "" if it is not set. urfave/cli reports both as set,
+// so a value which equals the (normalized) environment variable is
+// attributed to the environment.
+func flagSource(isSet bool, value, envVar string, normalize func(string) string) string {
+	if !isSet {
+		return ""
+	}
+	if env := os.Getenv(envVar); env != "" && normalize(env) == value {
+		return sourceEnv
+	}
+	return sourceFlag
+}
+
+// normalizers of environment variables for flagSource
+var (
+	normString = func(s string) string { return s }
+	normInt    = func(s string) string {
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return s
+		}
+		return strconv.FormatInt(i, 10)
+	}
+	normBool = func(s string) string {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return s
+		}
+		return strconv.FormatBool(b)
+	}
+	normDuration = func(s string) string {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return s
+		}
+		return d.String()
+	}
+)
+
+// readConfigFile reads the server configuration last written by
+// writeConfigFile. If there is none, nil is returned.
+func readConfigFile(homedir string) (*configclient.Config, error) {
+	netDomain, _, _ := def.ConfigParams()
+	jsn, err := ioutil.ReadFile(filepath.Join(homedir, "config", netDomain))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, log.Error(err)
+	}
+	var config configclient.Config
+	if err := json.Unmarshal(jsn, &config); err != nil {
+		return nil, log.Error(err)
+	}
+	return &config, nil
+}
+
+// effectiveConfig returns the effective configuration values for the command
+// line c, annotated with their source. Every value is resolved in the order
+// flag, environment, msgDB, server, default.
+func effectiveConfig(c *cli.Context) ([]configValue, error) {
+	config, err := readConfigFile(c.GlobalString("homedir"))
+	if err != nil {
+		return nil, err
+	}
+	var values []configValue
+	add := func(name, value, source string) {
+		if source == "" {
+			source = sourceDefault
+		}
+		values = append(values, configValue{name, value, source})
+	}
+	global := func(name, value, envVar string, normalize func(string) string) {
+		add(name, value,
+			flagSource(c.GlobalIsSet(name), value, envVar, normalize))
+	}
+	// mix delays (as used by msg add, see msgDelays)
+	minDelay, maxDelay := msgDelays(c)
+	value := strconv.Itoa(int(minDelay))
+	add("mindelay", value,
+		flagSource(c.IsSet("mindelay"), value, "MUTE_MINDELAY", normInt))
+	value = strconv.Itoa(int(maxDelay))
+	add("maxdelay", value,
+		flagSource(c.IsSet("maxdelay"), value, "MUTE_MAXDELAY", normInt))
+	// fetchconf
+	global("fetchconf-min", c.GlobalDuration("fetchconf-min").String(),
+		"MUTE_FETCHCONF_MIN", normDuration)
+	global("fetchconf-max", c.GlobalDuration("fetchconf-max").String(),
+		"MUTE_FETCHCONF_MAX", normDuration)
+	global("fetchconf-retries", strconv.Itoa(c.GlobalInt("fetchconf-retries")),
+		"MUTE_FETCHCONF_RETRIES", normInt)
+	global("fetchconf-backoff", c.GlobalDuration("fetchconf-backoff").String(),
+		"MUTE_FETCHCONF_BACKOFF", normDuration)
+	// signatures
+	global("defer-signature-check",
+		strconv.FormatBool(c.GlobalBool("defer-signature-check")),
+		"MUTE_DEFER_SIGNATURE_CHECK", normBool)
+	// wallet
+	global("low-balance", strconv.FormatInt(c.GlobalInt64("low-balance"), 10),
+		"MUTE_LOW_BALANCE", normInt)
+	// KDF (of the existing msgDB, no passphrase required)
+	iterations := strconv.Itoa(c.Int("iterations"))
+	source := flagSource(c.IsSet("iterations"), iterations, "", normInt)
+	if source == "" {
+		keyfile := filepath.Join(c.GlobalString("homedir"), "msgs") +
+			encdb.KeySuffix
+		kdf, err := encdb.ReadKDF(keyfile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if kdf != nil {
+			iterations, source = strconv.Itoa(kdf.Iter), sourceMsgDB
+		}
+	}
+	add("iterations", iterations, source)
+	// CA certificate
+	if config != nil && len(config.CACert) > 0 {
+		add("cacert", "configured", sourceServer)
+	} else {
+		add("cacert", "system roots", sourceDefault)
+	}
+	// transport
+	global("transport", c.GlobalString("transport"), "MUTE_TRANSPORT",
+		normString)
+	if c.GlobalString("transport") == "loopback" {
+		mailbox := c.GlobalString("mailbox")
+		if mailbox == "" {
+			mailbox = filepath.Join(c.GlobalString("homedir"), "mailbox")
+		}
+		global("mailbox", mailbox, "MUTE_MAILBOX", normString)
+	}
+	return values, nil
+}
+
+// configShow shows the effective configuration values for the command line
+// c together with their source.
+func (ce *CtrlEngine) configShow(
+	w io.Writer,
+	c *cli.Context,
+	jsonOutput bool,
+) error {
+	values, err := effectiveConfig(c)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		jsn, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return log.Error(err)
+		}
+		fmt.Fprintln(w, string(jsn))
+		return nil
+	}
+	for _, v := range values {
+		fmt.Fprintf(w, "%s\t%s\t(%s)\n", v.Name, v.Value, v.Source)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/def"
+)
+
+func TestConfigShow(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	if err := te.run("--fetchconf-min 1h config show --json", 0); err != nil {
+		t.Fatal(err)
+	}
+	var values []configValue
+	if err := json.Unmarshal([]byte(te.output()), &values); err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[string]configValue)
+	for _, v := range values {
+		sources[v.Name] = v
+	}
+	if v := sources["fetchconf-min"]; v.Value != "1h0m0s" || v.Source != sourceFlag {
+		t.Errorf("wrong fetchconf-min: %+v", v)
+	}
+	if v := sources["fetchconf-max"]; v.Source != sourceDefault {
+		t.Errorf("wrong fetchconf-max: %+v", v)
+	}
+	if v := sources["cacert"]; v.Source != sourceDefault {
+		t.Errorf("wrong cacert: %+v", v)
+	}
+	// plain output
+	if err := te.run("config show --mindelay 200", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); !strings.Contains(out, "mindelay\t200\t(flag)\n") {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+// configSources runs config show --json with te and returns the values by
+// name.
+func configSources(te *testEngine, line string, passphrases int) map[string]configValue {
+	if err := te.run(line+" --json", passphrases); err != nil {
+		te.t.Fatal(err)
+	}
+	var values []configValue
+	if err := json.Unmarshal([]byte(te.output()), &values); err != nil {
+		te.t.Fatal(err)
+	}
+	sources := make(map[string]configValue)
+	for _, v := range values {
+		sources[v.Name] = v
+	}
+	return sources
+}
+
+func TestConfigShowPrecedence(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	// environment
+	if err := os.Setenv("MUTE_MINDELAY", "300"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("MUTE_MINDELAY")
+	// server (the mix delays of the server are not used by msg add)
+	config := testConfig(t)
+	config.Map["mix.MaxDelay"] = "7200"
+	jsn, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netDomain, _, _ := def.ConfigParams()
+	if err := writeConfigFile(te.homedir, netDomain, jsn); err != nil {
+		t.Fatal(err)
+	}
+	sources := configSources(te, "config show", 0)
+	if v := sources["mindelay"]; v.Value != "300" || v.Source != sourceEnv {
+		t.Errorf("wrong mindelay: %+v", v)
+	}
+	maxDelay := strconv.Itoa(int(def.MaxDelay))
+	if v := sources["maxdelay"]; v.Value != maxDelay || v.Source != sourceDefault {
+		t.Errorf("wrong maxdelay: %+v", v)
+	}
+	if v := sources["iterations"]; v.Value != "4096" || v.Source != sourceMsgDB {
+		t.Errorf("wrong iterations: %+v", v)
+	}
+	// flag overrides environment
+	sources = configSources(te, "config show --mindelay 400", 0)
+	if v := sources["mindelay"]; v.Value != "400" || v.Source != sourceFlag {
+		t.Errorf("wrong mindelay: %+v", v)
+	}
+	// priority determines the delays which are not set explicitly
+	sources = configSources(te, "config show --priority high", 0)
+	if v := sources["mindelay"]; v.Value != "300" || v.Source != sourceEnv {
+		t.Errorf("wrong mindelay: %+v", v)
+	}
+	maxDelay = strconv.Itoa(int(def.HighPriorityMaxDelay))
+	if v := sources["maxdelay"]; v.Value != maxDelay || v.Source != sourceDefault {
+		t.Errorf("wrong maxdelay: %+v", v)
+	}
+	if err := te.run("config show --priority urgent", 0); err == nil {
+		t.Error("config show with unknown priority should fail")
+	}
+}
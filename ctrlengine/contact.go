@@ -6,6 +6,8 @@ package ctrlengine
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -18,6 +20,8 @@ import (
 	"github.com/mutecomm/mute/msgdb"
 	"github.com/mutecomm/mute/serviceguard/client"
 	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/mutecomm/mute/util/times"
 	"github.com/urfave/cli"
 )
 
@@ -37,11 +41,7 @@ func mutecryptAddContact(
 	client *client.Client,
 ) error {
 	log.Infof("mutecryptAddContact(): id=%s, domain=%s", id, domain)
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-	}
+	args := mutecryptArgs(c)
 	if host != "" {
 		args = append(args,
 			"--keyhost", host,
@@ -222,16 +222,27 @@ func add(
 	return nil
 }
 
-func get(outfp io.Writer, msgDB *msgdb.MsgDB, id string, blocked bool) error {
+func get(
+	outfp io.Writer,
+	msgDB *msgdb.MsgDB,
+	id string,
+	blocked bool,
+	timeFormat times.Format,
+) error {
 	// get list of mapped contacts
-	contacts, err := msgDB.GetContacts(id, blocked)
+	contacts, err := msgDB.GetContactList(id, blocked)
 	if err != nil {
 		return nil
 	}
 
 	// print list
 	for _, contact := range contacts {
-		fmt.Fprintln(outfp, contact)
+		if contact.LastSeen == 0 {
+			fmt.Fprintln(outfp, contact)
+		} else {
+			fmt.Fprintf(outfp, "%s\tlast seen %s\n", contact,
+				timeFormat.Sprint(contact.LastSeen, times.Now()))
+		}
 	}
 
 	return nil
@@ -240,6 +251,7 @@ func get(outfp io.Writer, msgDB *msgdb.MsgDB, id string, blocked bool) error {
 func (ce *CtrlEngine) contactAdd(
 	id, contact, fullName, host string,
 	contactType msgdb.ContactType,
+	ifNotExists bool,
 	c *cli.Context,
 ) error {
 	log.Infof("contact add --id %s --contact %s", id, contact)
@@ -258,6 +270,12 @@ func (ce *CtrlEngine) contactAdd(
 		return err
 	}
 	if unmappedID != "" {
+		if ifNotExists {
+			log.Info("contact already known -> nothing to do")
+			i18n.Fprintf(ce.fileTable.StatusFP, "contact %s already present\n",
+				contact)
+			return nil
+		}
 		log.Infof("contact already known -> make sure it is white listed")
 		if contactType != msgdb.WhiteList {
 			err = ce.msgDB.AddContact(idMapped, contactMapped, unmappedID, fullName,
@@ -277,7 +295,69 @@ func (ce *CtrlEngine) contactAdd(
 	return add(ce.msgDB, idMapped, contactMapped, fullName, contactType)
 }
 
-func (ce *CtrlEngine) contactEdit(id, contact, fullName string) error {
+// contactAddFile adds the contacts listed in the file filename (one per line
+// in the format "id[,fullname[,host]]") like contactAdd. The result for every
+// line is reported on the status file descriptor and errors do not stop the
+// processing of later lines. Empty lines and comments (starting with #) are
+// ignored.
+func (ce *CtrlEngine) contactAddFile(
+	id, filename, host string,
+	contactType msgdb.ContactType,
+	ifNotExists bool,
+	c *cli.Context,
+) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return log.Error(err)
+	}
+	defer fp.Close()
+	var failed int
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		parts := strings.Split(ln, ",")
+		contact := strings.TrimSpace(parts[0])
+		var fullName string
+		if len(parts) > 1 {
+			fullName = strings.TrimSpace(parts[1])
+		}
+		contactHost := host
+		if len(parts) > 2 {
+			contactHost = strings.TrimSpace(parts[2])
+		}
+		if len(parts) > 3 {
+			err = log.Error("ctrlengine: too many fields")
+		} else {
+			err = ce.contactAdd(id, contact, fullName, contactHost, contactType,
+				ifNotExists, c)
+		}
+		if err != nil {
+			fmt.Fprintf(ce.fileTable.StatusFP, "%s:%d: %s: %s\n", filename, n,
+				contact, err)
+			failed++
+		} else {
+			fmt.Fprintf(ce.fileTable.StatusFP, "%s:%d: %s: ok\n", filename, n,
+				contact)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return log.Error(err)
+	}
+	if failed > 0 {
+		return log.Errorf("ctrlengine: %d contact(s) could not be added", failed)
+	}
+	return nil
+}
+
+// contactEdit sets the full name, the notes, and the alias of contact. Values
+// given as nil are left unchanged.
+func (ce *CtrlEngine) contactEdit(
+	id, contact string,
+	fullName, notes, alias *string,
+) error {
 	idMapped, err := identity.Map(id)
 	if err != nil {
 		return err
@@ -286,18 +366,179 @@ func (ce *CtrlEngine) contactEdit(id, contact, fullName string) error {
 	if err != nil {
 		return err
 	}
-	unmappedID, _, contactType, err := ce.msgDB.GetContact(idMapped, contactMapped)
+	unmappedID, oldFullName, contactType, err := ce.msgDB.GetContact(idMapped,
+		contactMapped)
 	if err != nil {
 		return err
 	}
 	if unmappedID == "" {
 		return log.Errorf("ctrlengine: contact %s unknown", contact)
 	}
-	err = ce.msgDB.AddContact(idMapped, contactMapped, contact, fullName,
+	if fullName == nil {
+		fullName = &oldFullName
+	}
+	err = ce.msgDB.AddContact(idMapped, contactMapped, contact, *fullName,
 		contactType)
 	if err != nil {
 		return err
 	}
+	if notes != nil {
+		err := ce.msgDB.SetContactNotes(idMapped, contactMapped, *notes)
+		if err != nil {
+			return err
+		}
+	}
+	if alias != nil {
+		err := ce.msgDB.SetContactAlias(idMapped, contactMapped, *alias)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutecryptFingerprint returns the fingerprint (SIGKEYHASH) of the UID
+// message of id known to mutecrypt and whether it is verified with the key
+// hash chain. The fingerprint is empty, if mutecrypt knows no UID message for
+// id.
+func mutecryptFingerprint(
+	c *cli.Context,
+	passphrase []byte,
+	id string,
+) (fingerprint string, verified bool, err error) {
+	args := mutecryptArgs(c,
+		"uid", "fingerprint",
+		"--id", id,
+	)
+	cmd := exec.Command("mutecrypt", args...)
+	var outbuf bytes.Buffer
+	cmd.Stdout = &outbuf
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	ppR, ppW, err := os.Pipe()
+	if err != nil {
+		return "", false, log.Error(err)
+	}
+	defer ppR.Close()
+	ppW.Write(passphrase)
+	ppW.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, ppR)
+	if err := cmd.Start(); err != nil {
+		return "", false, log.Error(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return "", false, log.Errorf("%s: %s", err,
+			strings.TrimSpace(errbuf.String()))
+	}
+	result := strings.TrimRight(outbuf.String(), "\n")
+	if parts := strings.Split(result, "\t"); len(parts) == 2 {
+		switch parts[0] {
+		case "VERIFIED":
+			return parts[1], true, nil
+		case "UNVERIFIED":
+			return parts[1], false, nil
+		case "UNKNOWN":
+			return "", false, nil
+		}
+	}
+	return "", false,
+		log.Errorf("ctrlengine: mutecrypt fingerprint output not parsable: %s",
+			result)
+}
+
+// contactDetail contains everything known about a contact.
+type contactDetail struct {
+	Contact     string // the (unmapped) user ID of the contact
+	FullName    string // full name (local)
+	Notes       string // freeform notes (local)
+	Alias       string // short name of contact (local)
+	List        string // white, gray, or black
+	Verified    bool   // the UID of the contact is verified with the hash chain
+	Fingerprint string // SIGKEYHASH of the verified UID
+	LastSeen    int64  // time of the last exchanged message (0: never)
+	Sent        int64  // number of sent messages
+	Received    int64  // number of received messages
+}
+
+// contactShow shows the details of contact (as JSON, if jsonOutput is set).
+func (ce *CtrlEngine) contactShow(
+	c *cli.Context,
+	w io.Writer,
+	id, contact string,
+	jsonOutput bool,
+) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	contactMapped, err := identity.Map(contact)
+	if err != nil {
+		return err
+	}
+	unmappedID, fullName, contactType, err := ce.msgDB.GetContact(idMapped,
+		contactMapped)
+	if err != nil {
+		return err
+	}
+	if unmappedID == "" {
+		return log.Errorf("ctrlengine: contact %s unknown", contact)
+	}
+	d := &contactDetail{
+		Contact:  unmappedID,
+		FullName: fullName,
+	}
+	d.Notes, err = ce.msgDB.GetContactNotes(idMapped, contactMapped)
+	if err != nil {
+		return err
+	}
+	d.Alias, err = ce.msgDB.GetContactAlias(idMapped, contactMapped)
+	if err != nil {
+		return err
+	}
+	switch contactType {
+	case msgdb.WhiteList:
+		d.List = "white"
+	case msgdb.GrayList:
+		d.List = "gray"
+	case msgdb.BlackList:
+		d.List = "black"
+	}
+	d.LastSeen, d.Sent, d.Received, err = ce.msgDB.GetContactStats(idMapped,
+		contactMapped)
+	if err != nil {
+		return err
+	}
+	d.Fingerprint, d.Verified, err = mutecryptFingerprint(c, ce.passphrase,
+		contactMapped)
+	if err != nil {
+		return err
+	}
+	err = ce.auditKey(idMapped, contactMapped, d.Verified, d.Fingerprint)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		jsn, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return log.Error(err)
+		}
+		fmt.Fprintln(w, string(jsn))
+		return nil
+	}
+	lastSeen := "never"
+	if d.LastSeen > 0 {
+		lastSeen = ce.fmtTime(d.LastSeen)
+	}
+	fmt.Fprintf(w, "CONTACT:\t%s\n", d.Contact)
+	fmt.Fprintf(w, "FULLNAME:\t%s\n", d.FullName)
+	fmt.Fprintf(w, "LIST:\t%s\n", d.List)
+	fmt.Fprintf(w, "NOTES:\t%s\n", d.Notes)
+	fmt.Fprintf(w, "ALIAS:\t%s\n", d.Alias)
+	fmt.Fprintf(w, "VERIFIED:\t%t\n", d.Verified)
+	fmt.Fprintf(w, "FINGERPRINT:\t%s\n", d.Fingerprint)
+	fmt.Fprintf(w, "LASTSEEN:\t%s\n", lastSeen)
+	fmt.Fprintf(w, "SENT:\t%d\n", d.Sent)
+	fmt.Fprintf(w, "RECEIVED:\t%d\n", d.Received)
 	return nil
 }
 
@@ -362,7 +603,7 @@ func (ce *CtrlEngine) contactList(outfp io.Writer, id string) error {
 	if err != nil {
 		return err
 	}
-	return get(outfp, ce.msgDB, idMapped, false)
+	return get(outfp, ce.msgDB, idMapped, false, ce.timeFormat)
 }
 
 func (ce *CtrlEngine) contactBlacklist(outfp io.Writer, id string) error {
@@ -370,5 +611,5 @@ func (ce *CtrlEngine) contactBlacklist(outfp io.Writer, id string) error {
 	if err != nil {
 		return err
 	}
-	return get(outfp, ce.msgDB, idMapped, true)
+	return get(outfp, ce.msgDB, idMapped, true, ce.timeFormat)
 }
@@ -0,0 +1,274 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/msgdb"
+)
+
+func TestContactLastSeen(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	if err := te.ce.msgDB.AddContact(a, b, b, "Bob", msgdb.WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	// no messages exchanged yet
+	if err := te.run("contact list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "Bob <"+b+">\n" {
+		t.Errorf("contact list: unexpected output: %q", out)
+	}
+	// send message
+	sent := int64(1500000000)
+	te.queueMessage(a, b, "hi", true, true)
+	if err := te.run("contact list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	exp := "Bob <" + b + ">\tlast seen " + time.Unix(sent, 0).Format(time.RFC3339) + "\n"
+	if out := te.output(); out != exp {
+		t.Errorf("contact list after send: %q != %q", out, exp)
+	}
+	// receive message
+	received := sent + 3600
+	err := te.ce.msgDB.AddMessage(a, b, received, false, "hello", false, 0, 0,
+		msgdb.NormalPriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("contact list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	exp = "Bob <" + b + ">\tlast seen " + time.Unix(received, 0).Format(time.RFC3339) + "\n"
+	if out := te.output(); out != exp {
+		t.Errorf("contact list after receive: %q != %q", out, exp)
+	}
+}
+
+func TestContactAddIfNotExists(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	if err := te.ce.msgDB.AddContact(a, b, b, "Bob", msgdb.GrayList); err != nil {
+		t.Fatal(err)
+	}
+	// existing contact is left alone with --if-not-exists
+	err := te.run("contact add --id "+a+" --contact "+b+" --if-not-exists", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te.status(), "contact "+b+" already present") {
+		t.Error("contact add --if-not-exists status missing")
+	}
+	_, _, contactType, err := te.ce.msgDB.GetContact(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contactType != msgdb.GrayList {
+		t.Error("contact add --if-not-exists changed existing contact")
+	}
+	// without --if-not-exists the existing contact is white listed
+	if err := te.run("contact add --id "+a+" --contact "+b, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, _, contactType, err = te.ce.msgDB.GetContact(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contactType != msgdb.WhiteList {
+		t.Error("contact add did not white list existing contact")
+	}
+}
+
+func TestContactAddFile(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	c := "carol@mute.berlin"
+	te.seedContact(a, b)
+	if err := te.ce.msgDB.AddContact(a, c, c, "Carol", msgdb.GrayList); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(te.homedir, "contacts.txt")
+	contacts := "# contacts\n" +
+		b + "\n" +
+		"\n" +
+		"invalid user ID\n" +
+		c + ",Carol,mute.berlin\n" +
+		"dave@mute.berlin,Dave,mute.berlin,superfluous\n"
+	if err := ioutil.WriteFile(filename, []byte(contacts), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := te.run("contact add --id "+a+" --file "+filename, 0)
+	if err == nil {
+		t.Fatal("contact add --file with invalid lines should fail")
+	}
+	status := te.status()
+	for _, result := range []string{
+		filename + ":2: " + b + ": ok\n",
+		filename + ":4: invalid user ID: ",
+		filename + ":5: " + c + ": ok\n",
+		filename + ":6: dave@mute.berlin: ctrlengine: too many fields\n",
+	} {
+		if !strings.Contains(status, result) {
+			t.Errorf("status does not contain %q: %s", result, status)
+		}
+	}
+	// valid lines have been processed despite errors
+	_, _, contactType, err := te.ce.msgDB.GetContact(a, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contactType != msgdb.WhiteList {
+		t.Error("contact from file not white listed")
+	}
+}
+
+func TestContactNotes(t *testing.T) {
+	defer installEngines(t)()
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	te.seedKeyDB()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	err := te.run("contact edit --id "+a+" --contact "+b+" --full-name Bob", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = te.run("contact edit --id "+a+" --contact "+b+" --notes met@conf", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("contact show --id "+a+" --contact "+b, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); !strings.Contains(out, "NOTES:\tmet@conf\n") {
+		t.Errorf("contact show does not contain notes: %q", out)
+	}
+	// notes persist when contact is edited
+	err = te.run("contact edit --id "+a+" --contact "+b+" --full-name Robert", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes, err := te.ce.msgDB.GetContactNotes(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes != "met@conf" {
+		t.Errorf("contact notes not persisted: %q", notes)
+	}
+	// aliases are unique per user ID
+	err = te.run("contact edit --id "+a+" --contact "+b+" --alias bob", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := "carol@mute.berlin"
+	te.seedContact(a, c)
+	if err := te.run("contact edit --id "+a+" --contact "+c+" --alias bob", 0); err == nil {
+		t.Error("contact edit with used alias should fail")
+	}
+	alias, err := te.ce.msgDB.GetContactAlias(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias != "bob" {
+		t.Errorf("contact alias not persisted: %q", alias)
+	}
+	if err := te.run("contact show --id "+a+" --contact dave@mute.berlin", 0); err == nil {
+		t.Error("contact show for unknown contact should fail")
+	}
+}
+
+func TestContactShow(t *testing.T) {
+	defer installEngines(t)()
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	te.seedKeyDB()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	if err := te.ce.msgDB.AddContact(a, b, b, "Bob", msgdb.GrayList); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.ce.msgDB.SetContactNotes(a, b, "met at conf"); err != nil {
+		t.Fatal(err)
+	}
+	te.queueMessage(a, b, "hi", true, true)
+	received := int64(1500003600)
+	err := te.ce.msgDB.AddMessage(a, b, received, false, "hello", false, 0, 0,
+		msgdb.NormalPriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = te.ce.msgDB.AddMessage(a, b, received-60, false, "hello again",
+		false, 0, 0, msgdb.NormalPriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("contact edit --id "+a+" --contact "+b+" --alias bobby", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("contact show --json --id "+a+" --contact "+b, 0); err != nil {
+		t.Fatal(err)
+	}
+	var d contactDetail
+	if err := json.Unmarshal([]byte(te.output()), &d); err != nil {
+		t.Fatal(err)
+	}
+	exp := contactDetail{
+		Contact:  b,
+		FullName: "Bob",
+		Notes:    "met at conf",
+		Alias:    "bobby",
+		List:     "gray",
+		LastSeen: received,
+		Sent:     1,
+		Received: 2,
+	}
+	// the keyDB knows no UID of b, it is neither verified nor has a fingerprint
+	if d != exp {
+		t.Errorf("contact show: %+v != %+v", d, exp)
+	}
+	// text output
+	if err := te.run("contact show --id "+a+" --contact "+b, 0); err != nil {
+		t.Fatal(err)
+	}
+	out := te.output()
+	for _, line := range []string{
+		"CONTACT:\t" + b + "\n",
+		"FULLNAME:\tBob\n",
+		"LIST:\tgray\n",
+		"NOTES:\tmet at conf\n",
+		"ALIAS:\tbobby\n",
+		"VERIFIED:\tfalse\n",
+		"FINGERPRINT:\t\n",
+		"LASTSEEN:\t" + time.Unix(received, 0).Format(time.RFC3339) + "\n",
+		"SENT:\t1\n",
+		"RECEIVED:\t2\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("contact show does not contain %q: %s", line, out)
+		}
+	}
+}
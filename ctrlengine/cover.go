@@ -0,0 +1,128 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg"
+	"github.com/urfave/cli"
+)
+
+// coverPrefix marks the content of decoy messages (cover traffic). Decoys are
+// sent to oneself and the marker is only visible after the envelope has been
+// decrypted, for observers decoys look exactly like real messages.
+const coverPrefix = "MUTE-COVER\n"
+
+// coverTraffic defines how real messages are sent interspersed with decoys.
+type coverTraffic struct {
+	decoys  int           // number of decoys per real message
+	maxWait time.Duration // maximum random wait before every delivery
+}
+
+// sendSlot is a single delivery in a send schedule.
+type sendSlot struct {
+	decoy bool          // true: decoy, false: real message
+	wait  time.Duration // random wait before the delivery
+}
+
+// randInt returns a uniform random number in [0, n).
+func randInt(n int64) (int64, error) {
+	i, err := rand.Int(cipher.RandReader, big.NewInt(n))
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	return i.Int64(), nil
+}
+
+// schedule returns the randomized send schedule for a single real message.
+// Without cover traffic (ct is nil) the real message is sent immediately.
+func (ct *coverTraffic) schedule() ([]sendSlot, error) {
+	if ct == nil {
+		return []sendSlot{{}}, nil
+	}
+	slots := make([]sendSlot, ct.decoys+1)
+	for i := range slots {
+		slots[i].decoy = true
+		if ct.maxWait > 0 {
+			wait, err := randInt(int64(ct.maxWait) + 1)
+			if err != nil {
+				return nil, err
+			}
+			slots[i].wait = time.Duration(wait)
+		}
+	}
+	r, err := randInt(int64(len(slots)))
+	if err != nil {
+		return nil, err
+	}
+	slots[r].decoy = false
+	return slots, nil
+}
+
+// newCoverMsg returns a new base64 encoded decoy message, which has the same
+// size as a real encrypted message.
+func newCoverMsg() (string, error) {
+	cover := make([]byte, msg.UnencodedMsgSize)
+	copy(cover, coverPrefix)
+	if _, err := io.ReadFull(cipher.RandReader, cover[len(coverPrefix):]); err != nil {
+		return "", log.Error(err)
+	}
+	return base64.Encode(cover), nil
+}
+
+// isCoverMsg returns true, if the base64 encoded message enc is a decoy.
+func isCoverMsg(enc string) bool {
+	if len(enc) != msg.EncodedMsgSize {
+		return false
+	}
+	cover, err := base64.Decode(enc)
+	if err != nil {
+		return false
+	}
+	return bytes.HasPrefix(cover, []byte(coverPrefix))
+}
+
+// sendDecoy sends a decoy message from nym to itself with the given delays.
+// Decoys are paid with tokens like real messages.
+func (ce *CtrlEngine) sendDecoy(
+	c *cli.Context,
+	nym string,
+	minDelay, maxDelay int32,
+) error {
+	nymaddress, err := ce.recvNymAddress(nym, nym)
+	if err != nil {
+		return err
+	}
+	token, err := ce.messageToken(nymaddress)
+	if err != nil {
+		return err
+	}
+	cover, err := newCoverMsg()
+	if err != nil {
+		ce.releaseToken(token, false)
+		return err
+	}
+	env, err := muteprotoCreate(c, cover, minDelay, maxDelay,
+		base64.Encode(token.Token), nymaddress)
+	if err != nil {
+		ce.releaseToken(token, false)
+		return log.Error(err)
+	}
+	ce.releaseToken(token, true)
+	// decoys are never resent
+	if _, err := muteprotoDeliver(c, env); err != nil {
+		return err
+	}
+	log.Debug("decoy sent")
+	return nil
+}
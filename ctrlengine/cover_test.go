@@ -0,0 +1,113 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func TestCoverSchedule(t *testing.T) {
+	// without cover traffic the real message is sent immediately
+	var ct *coverTraffic
+	slots, err := ct.schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 1 || slots[0].decoy || slots[0].wait != 0 {
+		t.Errorf("slots == %v", slots)
+	}
+	// with cover traffic the real message is hidden among decoys
+	ct = &coverTraffic{decoys: 3, maxWait: time.Second}
+	positions := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		slots, err := ct.schedule()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(slots) != 4 {
+			t.Fatalf("len(slots) == %d", len(slots))
+		}
+		real := -1
+		for j, slot := range slots {
+			if !slot.decoy {
+				if real != -1 {
+					t.Fatal("more than one real message in schedule")
+				}
+				real = j
+			}
+			if slot.wait < 0 || slot.wait > time.Second {
+				t.Errorf("wait == %s", slot.wait)
+			}
+		}
+		if real == -1 {
+			t.Fatal("no real message in schedule")
+		}
+		positions[real] = true
+	}
+	if len(positions) < 2 {
+		t.Error("real message is not sent at random positions")
+	}
+}
+
+func TestCoverMsg(t *testing.T) {
+	cover, err := newCoverMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decoys have the size of real messages
+	if len(cover) != msg.EncodedMsgSize {
+		t.Errorf("len(cover) == %d", len(cover))
+	}
+	if !isCoverMsg(cover) {
+		t.Error("decoy not recognized")
+	}
+	other, err := newCoverMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cover == other {
+		t.Error("decoys should differ")
+	}
+	random := make([]byte, msg.UnencodedMsgSize)
+	if _, err := cipher.RandReader.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	if isCoverMsg(base64.Encode(random)) {
+		t.Error("random message recognized as decoy")
+	}
+	// received decoys are discarded
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	if err := te.ce.msgDB.AddInQueueMessage(a, times.Now(), cover); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.ce.procInQueue(nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	_, myID, _, _, _, err := te.ce.msgDB.GetInQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if myID != "" {
+		t.Error("decoy not removed from inqueue")
+	}
+	ids, err := te.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Error("decoy added to messages")
+	}
+}
@@ -6,9 +6,12 @@
 package ctrlengine
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -17,22 +20,29 @@ import (
 	"crypto/ed25519"
 
 	"github.com/frankbraun/codechain/util/bzero"
-	"github.com/frankbraun/codechain/util/home"
 	"github.com/mutecomm/mute/configclient"
 	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/def/version"
 	"github.com/mutecomm/mute/encdb"
 	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
+	mixclient "github.com/mutecomm/mute/mix/client"
+	"github.com/mutecomm/mute/mix/mixaddr"
+	mimeMsg "github.com/mutecomm/mute/msg/mime"
 	"github.com/mutecomm/mute/msgdb"
 	"github.com/mutecomm/mute/release"
 	"github.com/mutecomm/mute/serviceguard/client"
 	"github.com/mutecomm/mute/serviceguard/client/trivial"
 	"github.com/mutecomm/mute/util"
 	"github.com/mutecomm/mute/util/descriptors"
+	"github.com/mutecomm/mute/util/dialer"
+	"github.com/mutecomm/mute/util/engerr"
 	"github.com/mutecomm/mute/util/git"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/mutecomm/mute/util/times"
 	"github.com/peterh/liner"
 	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // possible states
@@ -47,7 +57,7 @@ const (
 )
 
 var (
-	defaultHomeDir = home.AppDataDir("mute", false)
+	defaultHomeDir = util.AppDataDir("mute")
 	defaultLogDir  = filepath.Join(defaultHomeDir, "log")
 	errExit        = errors.New("cryptengine: requests exit")
 )
@@ -63,11 +73,51 @@ type CtrlEngine struct {
 	config     configclient.Config
 	app        *cli.App
 	err        error
+	// effective fetchconf durations (see --fetchconf-min and --fetchconf-max)
+	fetchconfMin time.Duration
+	fetchconfMax time.Duration
+	// retries of failed fetchconf (see --fetchconf-retries and
+	// --fetchconf-backoff)
+	fetchconfRetries int
+	fetchconfBackoff time.Duration
+	ctx              context.Context
+	events           events // events emitted for app mode
+	// legacy home directory (see --migrate-home)
+	legacyHomeDir string
+	// file which accumulates the network usage of the session
+	netstatsFile string
+	// local mailboxes used instead of the mix (see --transport loopback)
+	loopback *mixclient.Loopback
+	// append-only log of security-relevant events (see audit show)
+	auditLog auditLog
+	// token balance below which a warning is shown (see --low-balance)
+	lowBalance int64
+	// signatures of received messages are only verified by msg verify (see
+	// --defer-signature-check)
+	deferSignatureCheck bool
+	// reads lines from the passphrase file descriptor, which stays open to
+	// allow reading further passphrases (see readPassphrase)
+	passphraseScanner *bufio.Scanner
+	// delivers envelopes to the mix (muteprotoDeliver, replaced in tests)
+	deliver func(c *cli.Context, envelope string) (resend bool, err error)
+	// unit price of a token per usage (configTokenPrice, replaced in tests)
+	tokenPrice func(usage string) (int64, error)
+	// round-trip times of received pongs by ping ID (see ping)
+	pongs map[string]time.Duration
+	// exclusive lock of the home directory (released by Close)
+	homeLock *util.LockFile
+	// databases are opened read-only and only commands which do not modify
+	// them are allowed (see --read-only)
+	readOnly bool
+	// format of times printed for the user (see --time-format)
+	timeFormat times.Format
 }
 
+// translateError classifies err (see engerr.Classify) and annotates it with
+// a hint for the user, if possible.
 func (ce *CtrlEngine) translateError(err error) error {
-	switch err {
-	case client.ErrNoUser:
+	e := engerr.Classify(err)
+	if errors.Is(err, client.ErrNoUser) {
 		var walletPubkey string
 		var pk []byte
 		privkey, err := ce.msgDB.GetValue(msgdb.WalletKey)
@@ -77,13 +127,17 @@ func (ce *CtrlEngine) translateError(err error) error {
 		if err == nil {
 			walletPubkey = base64.Encode(pk[32:])
 		}
-		return fmt.Errorf("Unfortunately, you do not have tokens, yet!\n"+
+		e.Hint = i18n.Sprintf("Unfortunately, you do not have tokens, yet!\n"+
 			"Please send your \n"+
 			"WALLETPUBKEY\t%s\n"+
 			"per email to frank@cryptogroup.net and stay tuned!", walletPubkey)
-	default:
-		return err
 	}
+	return e
+}
+
+// fmtTime returns the Unix time t formatted for the user (see --time-format).
+func (ce *CtrlEngine) fmtTime(t int64) string {
+	return ce.timeFormat.Sprint(t, times.Now())
 }
 
 func (ce *CtrlEngine) getConfig(homedir string, offline bool) error {
@@ -101,7 +155,7 @@ func (ce *CtrlEngine) getConfig(homedir string, offline bool) error {
 		err := def.InitMute(&ce.config)
 		if err != nil {
 			// init failed -> update config (which will try init again)
-			fmt.Fprintf(ce.fileTable.StatusFP,
+			i18n.Fprintf(ce.fileTable.StatusFP,
 				"initialization failed, try to update config\n")
 			if offline {
 				return log.Error("ctrlengine: cannot fetch config in " +
@@ -124,9 +178,11 @@ func (ce *CtrlEngine) getConfig(homedir string, offline bool) error {
 					return log.Error(err)
 				}
 				last := time.Now().Sub(time.Unix(t, 0))
-				if last > def.FetchconfMinDuration {
+				fetch, outdated := fetchconfDue(last, ce.fetchconfMin,
+					ce.fetchconfMax)
+				if fetch {
 					if offline {
-						if last > def.FetchconfMaxDuration {
+						if outdated {
 							return log.Error("ctrlengine: configuration is " +
 								"outdated, please run without --offline")
 						}
@@ -151,7 +207,7 @@ func (ce *CtrlEngine) getConfig(homedir string, offline bool) error {
 		if offline {
 			return log.Error("ctrlengine: cannot fetch config in --offline mode")
 		}
-		fmt.Fprintf(ce.fileTable.StatusFP, "no system config found\n")
+		i18n.Fprintf(ce.fileTable.StatusFP, "no system config found\n")
 		err := ce.upkeepFetchconf(ce.msgDB, homedir, false, nil,
 			ce.fileTable.StatusFP)
 		if err != nil {
@@ -161,6 +217,15 @@ func (ce *CtrlEngine) getConfig(homedir string, offline bool) error {
 	return nil
 }
 
+// fetchconfDue determines for a configuration which has been fetched last
+// duration ago, whether it should be fetched again (if it is older than
+// minDuration) and whether it is outdated (if it is older than maxDuration).
+func fetchconfDue(last, minDuration, maxDuration time.Duration) (
+	fetch, outdated bool,
+) {
+	return last > minDuration, last > maxDuration
+}
+
 func (ce *CtrlEngine) checkUpdates() error {
 	commit := ce.config.Map["release.Commit"]
 	log.Info("checkUpdates()")
@@ -233,13 +298,85 @@ func (ce *CtrlEngine) prepare(
 	openMsgDB, checkUpdates bool,
 ) error {
 	if !ce.prepared {
+		// migrate legacy home directory, if necessary (only checked if the
+		// default home directory is used or migration is requested)
+		if !c.GlobalIsSet("homedir") || c.GlobalBool("migrate-home") {
+			err := ce.checkLegacyHome(c.GlobalString("homedir"),
+				c.GlobalBool("migrate-home"))
+			if err != nil {
+				return err
+			}
+		}
+
 		// create the necessary directories if they don't already exist
 		err := util.CreateDirs(c.GlobalString("homedir"), c.GlobalString("logdir"))
 		if err != nil {
 			return err
 		}
+		ce.auditLog.filename = filepath.Join(c.GlobalString("homedir"),
+			auditFilename)
+
+		// make sure no other mutectrl process uses the home directory
+		if ce.homeLock == nil {
+			ce.homeLock, err = util.AcquireLock(filepath.Join(
+				c.GlobalString("homedir"), lockFilename))
+			if err != nil {
+				return err
+			}
+		}
 
 		// initialize logging framework
+		if c.GlobalBool("private-logs") {
+			log.SetPrivate(true)
+			// make sure spawned engines mask identities as well
+			if err := os.Setenv("MUTE_PRIVATE_LOGS", "1"); err != nil {
+				return err
+			}
+		}
+
+		// route all connections through proxy, if necessary
+		if err := dialer.SetProxy(c.GlobalString("proxy")); err != nil {
+			return err
+		}
+		if proxy := c.GlobalString("proxy"); proxy != "" {
+			// make sure spawned engines use the proxy as well
+			if err := os.Setenv("MUTE_PROXY", proxy); err != nil {
+				return err
+			}
+		}
+
+		// reuse connections across server calls
+		dialer.SetMaxIdle(c.GlobalInt("max-idle"))
+		if c.GlobalIsSet("max-idle") {
+			// make sure spawned engines use the same setting
+			err := os.Setenv("MUTE_MAX_IDLE", strconv.Itoa(c.GlobalInt("max-idle")))
+			if err != nil {
+				return err
+			}
+		}
+
+		// account network usage of the session (including spawned engines)
+		if c.GlobalInt64("data-cap") < 0 {
+			return log.Error("--data-cap must not be negative")
+		}
+		dialer.SetDataCap(c.GlobalInt64("data-cap"))
+		if c.GlobalIsSet("data-cap") {
+			// make sure spawned engines enforce the cap as well
+			err := os.Setenv("MUTE_DATA_CAP",
+				strconv.FormatInt(c.GlobalInt64("data-cap"), 10))
+			if err != nil {
+				return err
+			}
+		}
+		if err := removeNetstats(c.GlobalString("homedir")); err != nil {
+			return err
+		}
+		ce.netstatsFile = filepath.Join(c.GlobalString("homedir"),
+			fmt.Sprintf("%s%d", netstatsPrefix, os.Getpid()))
+		dialer.SetStatsFile(ce.netstatsFile)
+		if err := os.Setenv("MUTE_NETSTATS", ce.netstatsFile); err != nil {
+			return err
+		}
 		err = log.Init(c.GlobalString("loglevel"), "ctrl ",
 			c.GlobalString("logdir"), c.GlobalBool("logconsole"))
 		if err != nil {
@@ -252,22 +389,98 @@ func (ce *CtrlEngine) prepare(
 			return err
 		}
 
+		// slow down brute-force attempts against the passphrase
+		delays, err := encdb.ParseDelays(c.GlobalString("passphrase-delays"))
+		if err != nil {
+			return log.Error(err)
+		}
+		encdb.SetLimiter(encdb.NewLimiter(delays))
+
+		// set fetchconf durations
+		ce.fetchconfMin = c.GlobalDuration("fetchconf-min")
+		ce.fetchconfMax = c.GlobalDuration("fetchconf-max")
+		if ce.fetchconfMin > ce.fetchconfMax {
+			return log.Error("ctrlengine: --fetchconf-min must not be larger than --fetchconf-max")
+		}
+		ce.fetchconfRetries = c.GlobalInt("fetchconf-retries")
+		if ce.fetchconfRetries < 0 {
+			return log.Error("--fetchconf-retries must not be negative")
+		}
+		if c.GlobalBool("offline") {
+			ce.fetchconfRetries = 0
+		}
+		ce.fetchconfBackoff = c.GlobalDuration("fetchconf-backoff")
+		ce.lowBalance = c.GlobalInt64("low-balance")
+		if ce.lowBalance < 0 {
+			return log.Error("--low-balance must not be negative")
+		}
+		ce.deferSignatureCheck = c.GlobalBool("defer-signature-check")
+		ce.readOnly = c.GlobalBool("read-only")
+		ce.timeFormat, err = times.ParseFormat(c.GlobalString("time-format"))
+		if err != nil {
+			return log.Error(err)
+		}
+		if err := i18n.SetLang(c.GlobalString("lang")); err != nil {
+			log.Warnf("ctrlengine: %s (using English)", err)
+		}
+
+		// select message transport
+		switch c.GlobalString("transport") {
+		case "mix":
+		case "loopback":
+			mailbox := c.GlobalString("mailbox")
+			if mailbox == "" {
+				mailbox = filepath.Join(c.GlobalString("homedir"), "mailbox")
+			}
+			ce.loopback = &mixclient.Loopback{Dir: mailbox}
+			// create nym addresses for the mix keys of the loopback
+			mixclient.GetMixKeys = func(mixaddress string, cacert []byte) (*mixaddr.AddressStatement, error) {
+				return ce.loopback.MixKeys(mixaddress)
+			}
+			// make sure spawned engines use the loopback as well
+			if err := os.Setenv("MUTE_TRANSPORT", "loopback"); err != nil {
+				return err
+			}
+			if err := os.Setenv("MUTE_MAILBOX", mailbox); err != nil {
+				return err
+			}
+		default:
+			return log.Errorf("unknown --transport: %s",
+				c.GlobalString("transport"))
+		}
+
 		ce.prepared = true
 	}
 
 	log.Infof("prepare(openMsgDB=%s)", strconv.FormatBool(openMsgDB))
 
+	// reject commands which modify databases in --read-only mode
+	if err := ce.checkReadOnly(c); err != nil {
+		return err
+	}
+
 	// open MsgDB, if necessary
 	if openMsgDB {
 		homedir := c.GlobalString("homedir")
-		offline := c.GlobalBool("offline")
+		offline := c.GlobalBool("offline") || ce.readOnly
 
 		// open messsage DB, if necessary
+		if err := ce.requireState(homedir, unlockedDBs); err != nil {
+			return err
+		}
 		if ce.msgDB == nil {
 			err := ce.openMsgDB(homedir)
 			if err != nil {
 				return err
 			}
+			ce.state = unlockedDBs
+		}
+
+		// delete received messages whose time to live expired
+		if !ce.readOnly {
+			if err := ce.msgSweep(); err != nil {
+				return err
+			}
 		}
 
 		// get config
@@ -276,7 +489,7 @@ func (ce *CtrlEngine) prepare(
 		}
 
 		// check for updates, if necessary
-		if checkUpdates {
+		if checkUpdates && !ce.readOnly {
 			if err := ce.checkUpdates(); err != nil {
 				return err
 			}
@@ -346,12 +559,12 @@ func (ce *CtrlEngine) loop(c *cli.Context) {
 		if active == "" {
 			active = "none"
 		}
-		fmt.Fprintf(ce.fileTable.StatusFP, "active user ID: %s\n", active)
+		i18n.Fprintf(ce.fileTable.StatusFP, "active user ID: %s\n", active)
 		fmt.Fprintln(ce.fileTable.StatusFP, "READY.")
 		ln, err := line.Prompt("")
 		if err != nil {
 			if err == liner.ErrPromptAborted {
-				fmt.Fprintf(ce.fileTable.StatusFP, "aborting...\n")
+				i18n.Fprintf(ce.fileTable.StatusFP, "aborting...\n")
 			}
 			log.Info("ctrlengine: stopping (error)")
 			log.Error(err)
@@ -359,40 +572,60 @@ func (ce *CtrlEngine) loop(c *cli.Context) {
 		}
 		line.AppendHistory(ln)
 
-		args := []string{ce.app.Name}
 		if ln == "" {
 			log.Infof("read empty line")
 			continue
 		}
 		log.Infof("read: %s", ln)
-		// in the loop these global variables are reset, therefore we have to
-		// pass them in again
-		args = append(args,
-			"--homedir", c.GlobalString("homedir"),
-			"--logdir", c.GlobalString("logdir"),
-			"--loglevel", c.GlobalString("loglevel"),
-		)
-		args = append(args, strings.Fields(ln)...)
-		if err := ce.app.Run(args); err != nil {
-			// command execution failed -> issue status and continue
-			log.Infof("command execution failed (app): %s", err)
-			fmt.Fprintln(ce.fileTable.StatusFP, err)
-			continue
-		}
-		if ce.err != nil {
-			if ce.err == errExit {
+		if err := ce.dispatch(c, ln); err != nil {
+			if err == errExit {
 				// exit requested -> return
 				log.Info("ctrlengine: stopping (exit requested)")
-				ce.err = nil
 				return
 			}
 			// command execution failed -> issue status and continue
-			fmt.Fprintln(ce.fileTable.StatusFP, ce.translateError(ce.err))
-			ce.err = nil
-		} else {
-			log.Info("command successful")
+			fmt.Fprintln(ce.fileTable.StatusFP, err)
+			continue
+		}
+		log.Info("command successful")
+	}
+}
+
+// dispatch executes the command line ln with the CtrlEngine (after alias
+// expansion). It is used by the interactive loop and `run`.
+func (ce *CtrlEngine) dispatch(c *cli.Context, ln string) error {
+	// the global variables are reset, therefore we have to pass them in again
+	// (the logging level might have been changed with `loglevel set`)
+	args := []string{ce.app.Name,
+		"--homedir", c.GlobalString("homedir"),
+		"--logdir", c.GlobalString("logdir"),
+		"--loglevel", log.Level(),
+	}
+	if c.GlobalBool("offline") {
+		args = append(args, "--offline")
+	}
+	fields := strings.Fields(ln)
+	if ce.msgDB != nil {
+		aliases, err := ce.getAliases()
+		if err != nil {
+			return err
 		}
+		fields, err = expandAlias(aliases, fields)
+		if err != nil {
+			return err
+		}
+	}
+	args = append(args, fields...)
+	if err := ce.app.Run(args); err != nil {
+		log.Infof("command execution failed (app): %s", err)
+		return err
 	}
+	err := ce.err
+	ce.err = nil
+	if err != nil && err != errExit {
+		return ce.translateError(err)
+	}
+	return err
 }
 
 func (ce *CtrlEngine) getID(c *cli.Context) string {
@@ -409,30 +642,83 @@ func (ce *CtrlEngine) getID(c *cli.Context) string {
 
 func checkDelayArgs(c *cli.Context) error {
 	if !c.Bool("nodelaycheck") {
-		if c.Int("mindelay") < def.MinMinDelay {
+		minDelay, maxDelay := msgDelays(c)
+		if minDelay < def.MinMinDelay {
 			return log.Errorf("--mindelay must be at least %d", def.MinMinDelay)
 		}
-		if c.Int("maxdelay") < def.MinMaxDelay {
+		if maxDelay < def.MinMaxDelay {
 			return log.Errorf("--maxdelay must be at least %d", def.MinMaxDelay)
 		}
-		if c.Int("mindelay") >= c.Int("maxdelay") {
+		if minDelay >= maxDelay {
 			return log.Error("--mindelay must be strictly smaller than --maxdelay")
 		}
 	}
 	return nil
 }
 
+// parsePriority parses the message priority given with --priority.
+func parsePriority(priority string) (msgdb.Priority, error) {
+	switch priority {
+	case "", "normal":
+		return msgdb.NormalPriority, nil
+	case "high":
+		return msgdb.HighPriority, nil
+	case "low":
+		return msgdb.LowPriority, nil
+	default:
+		return 0, log.Errorf("unknown priority '%s' (use low, normal, or high)",
+			priority)
+	}
+}
+
+// msgDelays returns the mix delays set with --mindelay and --maxdelay. If
+// they are not set explicitly, the delays are determined by --priority
+// (tighter delays for high priority, longer delays for better anonymity for
+// low priority).
+func msgDelays(c *cli.Context) (minDelay, maxDelay int32) {
+	minDelay = int32(c.Int("mindelay"))
+	maxDelay = int32(c.Int("maxdelay"))
+	priority, _ := parsePriority(c.String("priority"))
+	switch priority {
+	case msgdb.HighPriority:
+		if !c.IsSet("mindelay") {
+			minDelay = def.HighPriorityMinDelay
+		}
+		if !c.IsSet("maxdelay") {
+			maxDelay = def.HighPriorityMaxDelay
+		}
+	case msgdb.LowPriority:
+		if !c.IsSet("mindelay") {
+			minDelay = def.LowPriorityMinDelay
+		}
+		if !c.IsSet("maxdelay") {
+			maxDelay = def.LowPriorityMaxDelay
+		}
+	}
+	return
+}
+
 // New returns a new CtrlEngine.
 func New() *CtrlEngine {
 	var ce CtrlEngine
+	ce.ctx = context.Background()
+	ce.legacyHomeDir = util.LegacyAppDataDir("mute")
+	ce.deliver = muteprotoDeliver
+	ce.tokenPrice = ce.configTokenPrice
 	ce.app = cli.NewApp()
 	ce.app.Usage = "tool that handles message DB, contacts, and tokens."
 	ce.app.Version = version.Number
 	ce.app.Flags = []cli.Flag{
 		cli.StringFlag{
-			Name:  "homedir",
-			Value: defaultHomeDir,
-			Usage: "set home directory",
+			Name:   "homedir",
+			Value:  defaultHomeDir,
+			EnvVar: "MUTE_HOMEDIR",
+			Usage:  "set home directory",
+		},
+		cli.BoolFlag{
+			Name:   "migrate-home",
+			EnvVar: "MUTE_MIGRATE_HOME",
+			Usage:  "move databases from legacy home directory to --homedir",
 		},
 		descriptors.InputFDFlag,
 		descriptors.OutputFDFlag,
@@ -440,29 +726,136 @@ func New() *CtrlEngine {
 		descriptors.PassphraseFDFlag,
 		descriptors.CommandFDFlag,
 		cli.BoolFlag{
-			Name:  "offline",
-			Usage: "use offline mode",
+			Name:   "offline",
+			EnvVar: "MUTE_OFFLINE",
+			Usage:  "use offline mode",
 		},
 		cli.StringFlag{
-			Name:  "loglevel",
-			Value: "info",
-			Usage: "logging level {trace, debug, info, warn, error, critical}",
+			Name:   "loglevel",
+			Value:  "info",
+			EnvVar: "MUTE_LOGLEVEL",
+			Usage:  "logging level {trace, debug, info, warn, error, critical}",
+		},
+		cli.StringFlag{
+			Name:   "logdir",
+			Value:  defaultLogDir,
+			EnvVar: "MUTE_LOGDIR",
+			Usage:  "directory to log output",
+		},
+		cli.BoolFlag{
+			Name:   "logconsole",
+			EnvVar: "MUTE_LOGCONSOLE",
+			Usage:  "enable logging to console",
+		},
+		cli.BoolFlag{
+			Name:   "private-logs",
+			EnvVar: "MUTE_PRIVATE_LOGS",
+			Usage:  "mask identities in log output",
+		},
+		cli.StringFlag{
+			Name:   "proxy",
+			EnvVar: "MUTE_PROXY",
+			Usage:  "SOCKS5 proxy for all connections (e.g., socks5://127.0.0.1:9050 for Tor)",
+		},
+		cli.IntFlag{
+			Name:   "max-idle",
+			Value:  dialer.DefaultMaxIdle,
+			EnvVar: "MUTE_MAX_IDLE",
+			Usage:  "maximum number of idle connections per server kept for reuse (0 disables reuse)",
+		},
+		cli.Int64Flag{
+			Name:   "data-cap",
+			EnvVar: "MUTE_DATA_CAP",
+			Usage:  "maximum number of bytes transferred per session (0 means no cap)",
+		},
+		cli.StringFlag{
+			Name:   "trace-file",
+			EnvVar: "MUTE_TRACE_FILE",
+			Usage:  "write trace log of this command to file (regardless of --loglevel)",
+		},
+		cli.DurationFlag{
+			Name:   "fetchconf-min",
+			Value:  def.FetchconfMinDuration,
+			EnvVar: "MUTE_FETCHCONF_MIN",
+			Usage:  "minimum duration between automatic configuration fetches",
+		},
+		cli.DurationFlag{
+			Name:   "fetchconf-max",
+			Value:  def.FetchconfMaxDuration,
+			EnvVar: "MUTE_FETCHCONF_MAX",
+			Usage:  "maximum duration before configuration is outdated in --offline mode",
+		},
+		cli.IntFlag{
+			Name:   "fetchconf-retries",
+			Value:  def.FetchconfRetries,
+			EnvVar: "MUTE_FETCHCONF_RETRIES",
+			Usage:  "number of retries of a failed configuration fetch",
+		},
+		cli.DurationFlag{
+			Name:   "fetchconf-backoff",
+			Value:  def.FetchconfBackoff,
+			EnvVar: "MUTE_FETCHCONF_BACKOFF",
+			Usage:  "wait before first retry of a failed configuration fetch (doubled for every further retry)",
+		},
+		cli.Int64Flag{
+			Name:   "low-balance",
+			Value:  def.LowBalance,
+			EnvVar: "MUTE_LOW_BALANCE",
+			Usage:  "warn if the token balance of a usage drops below this (0 disables the warning)",
+		},
+		cli.BoolFlag{
+			Name:   "defer-signature-check",
+			EnvVar: "MUTE_DEFER_SIGNATURE_CHECK",
+			Usage:  "do not verify signatures of received messages during fetch (use msg verify)",
 		},
 		cli.StringFlag{
-			Name:  "logdir",
-			Value: defaultLogDir,
-			Usage: "directory to log output",
+			Name:   "passphrase-delays",
+			Value:  encdb.FormatDelays(encdb.DefaultDelays),
+			EnvVar: "MUTE_PASSPHRASE_DELAYS",
+			Usage:  "comma-separated delays before passphrase attempts after consecutive wrong passphrases (empty disables them)",
 		},
 		cli.BoolFlag{
-			Name:  "logconsole",
-			Usage: "enable logging to console",
+			Name:   "read-only",
+			EnvVar: "MUTE_READ_ONLY",
+			Usage:  "open databases read-only and allow only commands which do not modify them (implies --offline)",
+		},
+		cli.StringFlag{
+			Name:   "transport",
+			Value:  "mix",
+			EnvVar: "MUTE_TRANSPORT",
+			Usage:  "message transport {mix, loopback}",
+		},
+		cli.StringFlag{
+			Name:   "mailbox",
+			EnvVar: "MUTE_MAILBOX",
+			Usage:  "mailbox directory of --transport loopback (default: HOMEDIR/mailbox)",
+		},
+		cli.StringFlag{
+			Name:   "time-format",
+			Value:  string(times.RFC3339),
+			EnvVar: "MUTE_TIME_FORMAT",
+			Usage:  "format of printed times {rfc3339, unix, relative}",
+		},
+		cli.StringFlag{
+			Name:   "lang",
+			EnvVar: "MUTE_LANG",
+			Usage:  "language of user-facing messages {" + strings.Join(i18n.Languages(), ", ") + "}",
 		},
 	}
 	ce.app.Before = func(c *cli.Context) error {
-		return ce.prepare(c, false, false)
+		if err := ce.prepare(c, false, false); err != nil {
+			return err
+		}
+		if traceFile := c.GlobalString("trace-file"); traceFile != "" {
+			return log.StartTrace(traceFile)
+		}
+		return nil
 	}
 	ce.app.After = func(c *cli.Context) error {
 		// TODO: close all file descriptors?
+		if c.GlobalString("trace-file") != "" {
+			return log.StopTrace()
+		}
 		return nil
 	}
 	ce.app.Action = func(c *cli.Context) {
@@ -483,6 +876,10 @@ func New() *CtrlEngine {
 		Name:  "contact",
 		Usage: "user ID of contact (peer)",
 	}
+	groupFlag := cli.StringFlag{
+		Name:  "group",
+		Usage: "name of group",
+	}
 	fullNameFlag := cli.StringFlag{
 		Name:  "full-name",
 		Usage: "optional full name for user ID (local)",
@@ -492,14 +889,16 @@ func New() *CtrlEngine {
 		Usage: "alternative hostname",
 	}
 	mindelayFlag := cli.IntFlag{
-		Name:  "mindelay",
-		Value: int(def.MinDelay),
-		Usage: fmt.Sprintf("minimum delay for mix (min. %ds)", def.MinMinDelay),
+		Name:   "mindelay",
+		Value:  int(def.MinDelay),
+		EnvVar: "MUTE_MINDELAY",
+		Usage:  fmt.Sprintf("minimum delay for mix (min. %ds)", def.MinMinDelay),
 	}
 	maxdelayFlag := cli.IntFlag{
-		Name:  "maxdelay",
-		Value: int(def.MaxDelay),
-		Usage: fmt.Sprintf("maximum delay for mix (min. %ds)", def.MinMaxDelay),
+		Name:   "maxdelay",
+		Value:  int(def.MaxDelay),
+		EnvVar: "MUTE_MAXDELAY",
+		Usage:  fmt.Sprintf("maximum delay for mix (min. %ds)", def.MinMaxDelay),
 	}
 	nodelaycheckFlag := cli.BoolFlag{
 		Name:  "nodelaycheck",
@@ -509,6 +908,10 @@ func New() *CtrlEngine {
 		Name:  "msgnum",
 		Usage: "message ID to process",
 	}
+	dryRunFlag := cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only report what would be deleted",
+	}
 	ce.app.Commands = []cli.Command{
 		{
 			Name:  "app",
@@ -524,16 +927,68 @@ func New() *CtrlEngine {
 					Value: "localhost:0",
 					Usage: "HTTP service address (port 0 means random port)",
 				},
+				cli.BoolFlag{
+					Name:  "allow-remote",
+					Usage: "allow non-loopback HTTP service address",
+				},
+				cli.DurationFlag{
+					Name:  "send-interval",
+					Usage: "send queued messages in this interval (e.g., 15m), 0 sends immediately",
+				},
+				cli.BoolFlag{
+					Name:  "send-now",
+					Usage: "send queued messages immediately after login, without waiting for --send-interval",
+				},
+				cli.IntFlag{
+					Name:  "resend-attempts",
+					Value: 5,
+					Usage: "delivery attempts before a message fails permanently (0 disables automatic resend)",
+				},
+				cli.DurationFlag{
+					Name:  "resend-backoff",
+					Value: time.Minute,
+					Usage: "delay before the first automatic resend, doubled for every further attempt",
+				},
+				cli.DurationFlag{
+					Name:  "resend-max-backoff",
+					Value: time.Hour,
+					Usage: "maximum delay between automatic resends",
+				},
 			},
 			Before: func(c *cli.Context) error {
 				if len(c.Args()) > 0 {
 					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 				}
+				if c.Duration("send-interval") < 0 {
+					return log.Error("option --send-interval must not be negative")
+				}
+				if c.Bool("send-now") && c.Duration("send-interval") == 0 {
+					return log.Error("option --send-now requires --send-interval")
+				}
+				if c.Int("resend-attempts") < 0 {
+					return log.Error("option --resend-attempts must not be negative")
+				}
+				if c.Duration("resend-backoff") < time.Second {
+					return log.Error("option --resend-backoff must be at least 1s")
+				}
+				if c.Duration("resend-max-backoff") < c.Duration("resend-backoff") {
+					return log.Error("option --resend-max-backoff must not be smaller than --resend-backoff")
+				}
 				return ce.prepare(c, false, false)
 			},
 			Action: func(c *cli.Context) {
+				var resend *resendPolicy
+				if c.Int("resend-attempts") > 0 {
+					resend = &resendPolicy{
+						backoff:    c.Duration("resend-backoff"),
+						maxBackoff: c.Duration("resend-max-backoff"),
+						attempts:   c.Int("resend-attempts"),
+					}
+				}
 				ce.err = ce.appStart(c, ce.fileTable.StatusFP,
-					c.String("docroot"), c.String("http"))
+					c.GlobalString("homedir"), c.String("docroot"),
+					c.String("http"), c.Bool("allow-remote"),
+					c.Duration("send-interval"), c.Bool("send-now"), resend)
 			},
 		},
 		{
@@ -553,12 +1008,27 @@ func New() *CtrlEngine {
 							Name:  "walletkey",
 							Usage: "use this private wallet key instead of generated one",
 						},
+						cli.StringFlag{
+							Name:  "kdf",
+							Value: encdb.KDFPBKDF2,
+							Usage: "KDF algorithm used for DB creation {pbkdf2, argon2id}",
+						},
+						cli.BoolFlag{
+							Name:  "validate-only",
+							Usage: "only validate DB creation, do not create anything",
+						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
 							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 						}
-						return ce.prepare(c, false, false)
+						if err := ce.prepare(c, false, false); err != nil {
+							return err
+						}
+						if c.Bool("validate-only") {
+							return nil
+						}
+						return ce.requireState(c.GlobalString("homedir"), noDBs)
 					},
 					Action: func(c *cli.Context) {
 						ce.err = ce.dbCreate(ce.fileTable.OutputFP,
@@ -572,19 +1042,69 @@ func New() *CtrlEngine {
 						cli.IntFlag{
 							Name:  "iterations",
 							Value: encdb.KDFIterations,
-							Usage: "number of KDF iterations used for DB rekeying",
+							Usage: "number of KDF iterations used for DB rekeying (PBKDF2 only, Argon2id keeps its parameters)",
 						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
 							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 						}
-						return ce.prepare(c, false, false)
+						if err := ce.prepare(c, false, false); err != nil {
+							return err
+						}
+						return ce.requireState(c.GlobalString("homedir"), lockedDBs)
 					},
 					Action: func(c *cli.Context) {
 						ce.err = ce.dbRekey(ce.fileTable.StatusFP, c)
 					},
 				},
+				{
+					Name:  "reiterate",
+					Usage: "Change number of KDF iterations of databases (keeps passphrase)",
+					Description: `
+Rewraps the keys of the databases with a key derived from the (unchanged)
+passphrase with the given number of KDF iterations. The databases themselves
+are not reencrypted, which makes this much faster than a rekey.
+The KDF algorithm is kept: for Argon2id the number of iterations is the number
+of passes, the other Argon2id parameters are not changed.
+`,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "iterations",
+							Value: encdb.KDFIterations,
+							Usage: "new number of KDF iterations (passes for Argon2id)",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if err := ce.prepare(c, false, false); err != nil {
+							return err
+						}
+						return ce.requireState(c.GlobalString("homedir"), lockedDBs)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.dbReiterate(ce.fileTable.StatusFP, c)
+					},
+				},
+				{
+					Name:  "test-passphrase",
+					Usage: "Check passphrase of databases without opening them for writes",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if err := ce.prepare(c, false, false); err != nil {
+							return err
+						}
+						return ce.requireState(c.GlobalString("homedir"), lockedDBs)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.dbTestPassphrase(c, ce.fileTable.OutputFP,
+							ce.fileTable.StatusFP)
+					},
+				},
 				/*
 					{
 						Name:  "status",
@@ -764,6 +1284,7 @@ Tries to register a new user ID with the corresponding key server.
 							Name:  "force",
 							Usage: "force deletion (do not prompt)",
 						},
+						dryRunFlag,
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
@@ -776,7 +1297,7 @@ Tries to register a new user ID with the corresponding key server.
 					},
 					Action: func(c *cli.Context) {
 						ce.err = ce.uidDelete(c, c.String("id"), c.Bool("force"),
-							ce.fileTable.StatusFP)
+							c.Bool("dry-run"), ce.fileTable.StatusFP)
 					},
 				},
 				{
@@ -792,6 +1313,26 @@ Tries to register a new user ID with the corresponding key server.
 						ce.err = ce.uidList(ce.fileTable.OutputFP)
 					},
 				},
+				{
+					Name:  "status",
+					Usage: "show state of own user IDs across key and message DB",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "output user ID states as JSON",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.uidStatus(c, ce.fileTable.OutputFP,
+							c.Bool("json"))
+					},
+				},
 			},
 		},
 		{
@@ -806,6 +1347,14 @@ Tries to register a new user ID with the corresponding key server.
 						contactFlag,
 						fullNameFlag,
 						hostFlag,
+						cli.BoolFlag{
+							Name:  "if-not-exists",
+							Usage: "do nothing if contact is already present (regardless of list)",
+						},
+						cli.StringFlag{
+							Name:  "file",
+							Usage: "add contacts from file (one \"id[,fullname[,host]]\" per line)",
+						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
@@ -814,15 +1363,25 @@ Tries to register a new user ID with the corresponding key server.
 						if !interactive && !c.IsSet("id") {
 							return log.Error("option --id is mandatory")
 						}
-						if !c.IsSet("contact") {
+						if c.IsSet("file") {
+							if c.IsSet("contact") || c.IsSet("full-name") {
+								return log.Error("options --contact and --full-name cannot be used with --file")
+							}
+						} else if !c.IsSet("contact") {
 							return log.Error("option --contact is mandatory")
 						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
+						if c.IsSet("file") {
+							ce.err = ce.contactAddFile(ce.getID(c), c.String("file"),
+								c.String("host"), msgdb.WhiteList,
+								c.Bool("if-not-exists"), c)
+							return
+						}
 						ce.err = ce.contactAdd(ce.getID(c), c.String("contact"),
 							c.String("full-name"), c.String("host"),
-							msgdb.WhiteList, c)
+							msgdb.WhiteList, c.Bool("if-not-exists"), c)
 					},
 				},
 				{
@@ -832,6 +1391,14 @@ Tries to register a new user ID with the corresponding key server.
 						idFlag,
 						contactFlag,
 						fullNameFlag,
+						cli.StringFlag{
+							Name:  "notes",
+							Usage: "optional notes for contact (local)",
+						},
+						cli.StringFlag{
+							Name:  "alias",
+							Usage: "optional short name for contact (local)",
+						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
@@ -846,8 +1413,51 @@ Tries to register a new user ID with the corresponding key server.
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
+						var fullName, notes, alias *string
+						// editing only the notes or the alias keeps the full name
+						if c.IsSet("full-name") ||
+							(!c.IsSet("notes") && !c.IsSet("alias")) {
+							n := c.String("full-name")
+							fullName = &n
+						}
+						if c.IsSet("notes") {
+							n := c.String("notes")
+							notes = &n
+						}
+						if c.IsSet("alias") {
+							n := c.String("alias")
+							alias = &n
+						}
 						ce.err = ce.contactEdit(ce.getID(c),
-							c.String("contact"), c.String("full-name"))
+							c.String("contact"), fullName, notes, alias)
+					},
+				},
+				{
+					Name:  "show",
+					Usage: "show details of contact of active user ID",
+					Flags: []cli.Flag{
+						idFlag,
+						contactFlag,
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "output contact details as JSON",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						if !c.IsSet("contact") {
+							return log.Error("option --contact is mandatory")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.contactShow(c, ce.fileTable.OutputFP,
+							ce.getID(c), c.String("contact"), c.Bool("json"))
 					},
 				},
 				{
@@ -975,6 +1585,20 @@ Add a new message to outqueue.
 If option --mail-input is set the input is parsed as an email message and the
 'To' field is used as recipient and the optional 'Subject' combined with the
 email body as the actual message.
+If option --group is set the message is added for every member of the group
+(see 'msg group'). The body is encrypted only once with a random group key,
+'msg send' seals the group key to every member individually. Group messages
+cannot have a permanent signature.
+If option --ttl is set the recipient deletes the message after the given
+duration (e.g., 24h). The TTL is sent encrypted as part of the message.
+If option --burn is set the recipient deletes the message after reading it
+once (burn after reading).
+Option --content-type sets the content type of the message (e.g.,
+text/markdown or application/json), which is sent encrypted as part of the
+message and shown by 'msg list' and 'msg read'.
+Messages with --priority high are sent first and use tighter mix delays,
+messages with --priority low are sent last and use longer mix delays for
+better anonymity (unless --mindelay and --maxdelay are set explicitly).
 `,
 					Flags: []cli.Flag{
 						cli.StringFlag{
@@ -985,6 +1609,7 @@ email body as the actual message.
 							Name:  "to",
 							Usage: "user ID to send message to",
 						},
+						groupFlag,
 						cli.StringFlag{
 							Name:  "file",
 							Usage: "read message from file",
@@ -1004,6 +1629,24 @@ email body as the actual message.
 								Usage: "add permanent sign. to message",
 							},
 						*/
+						cli.DurationFlag{
+							Name:  "ttl",
+							Usage: "time to live of message at recipient (e.g., 24h)",
+						},
+						cli.BoolFlag{
+							Name:  "burn",
+							Usage: "recipient deletes message after reading it once",
+						},
+						cli.StringFlag{
+							Name:  "content-type",
+							Value: mimeMsg.DefaultContentType,
+							Usage: "content type of message (e.g., text/markdown)",
+						},
+						cli.StringFlag{
+							Name:  "priority",
+							Value: "normal",
+							Usage: "message priority (low, normal, or high)",
+						},
 						mindelayFlag,
 						maxdelayFlag,
 						nodelaycheckFlag,
@@ -1013,14 +1656,26 @@ email body as the actual message.
 							return log.Errorf("superfluous argument(s): %s",
 								strings.Join(c.Args(), " "))
 						}
-						if !interactive && !c.IsSet("from") {
-							return log.Error("option --from is mandatory")
+						if c.Duration("ttl") < 0 {
+							return log.Error("option --ttl must not be negative")
 						}
-						if !c.IsSet("mail-input") && !c.IsSet("to") {
-							return log.Error("option --to is mandatory")
+						if err := mimeMsg.CheckContentType(c.String("content-type")); err != nil {
+							return err
+						}
+						if _, err := parsePriority(c.String("priority")); err != nil {
+							return err
+						}
+						if !interactive && !c.IsSet("from") {
+							return log.Error("option --from is mandatory")
+						}
+						if !c.IsSet("mail-input") && !c.IsSet("to") && !c.IsSet("group") {
+							return log.Error("option --to or --group is mandatory")
+						}
+						if c.IsSet("to") && c.IsSet("group") {
+							return log.Error("options --to and --group exclude each other")
 						}
-						if c.IsSet("mail-input") && c.IsSet("to") {
-							return log.Error("options --to and --mail-input exclude each other")
+						if c.IsSet("mail-input") && (c.IsSet("to") || c.IsSet("group")) {
+							return log.Error("options --to and --group exclude --mail-input")
 						}
 						if err := checkDelayArgs(c); err != nil {
 							return err
@@ -1028,17 +1683,114 @@ email body as the actual message.
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
+						minDelay, maxDelay := msgDelays(c)
+						priority, _ := parsePriority(c.String("priority"))
 						ce.err = ce.msgAdd(c, ce.getID(c), c.String("to"),
-							c.String("file"), c.Bool("mail-input"),
+							c.String("group"), c.String("file"), c.Bool("mail-input"),
 							c.Bool("permanent-signature"),
-							c.StringSlice("attach"),
-							int32(c.Int("mindelay")), int32(c.Int("maxdelay")),
+							c.StringSlice("attach"), minDelay, maxDelay, priority,
+							&mimeMsg.Options{
+								TTL:         c.Duration("ttl"),
+								Burn:        c.Bool("burn"),
+								ContentType: c.String("content-type"),
+							},
 							line, ce.fileTable.InputFP)
 					},
 				},
+				{
+					Name:  "group",
+					Usage: "commands for group management",
+					Subcommands: []cli.Command{
+						{
+							Name:  "add",
+							Usage: "add contact to group (group is created, if necessary)",
+							Flags: []cli.Flag{
+								idFlag,
+								groupFlag,
+								contactFlag,
+							},
+							Before: func(c *cli.Context) error {
+								if len(c.Args()) > 0 {
+									return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+								}
+								if !interactive && !c.IsSet("id") {
+									return log.Error("option --id is mandatory")
+								}
+								if !c.IsSet("group") {
+									return log.Error("option --group is mandatory")
+								}
+								if !c.IsSet("contact") {
+									return log.Error("option --contact is mandatory")
+								}
+								return ce.prepare(c, true, true)
+							},
+							Action: func(c *cli.Context) {
+								ce.err = ce.groupAdd(ce.getID(c), c.String("group"),
+									c.String("contact"))
+							},
+						},
+						{
+							Name:  "remove",
+							Usage: "remove contact from group",
+							Flags: []cli.Flag{
+								idFlag,
+								groupFlag,
+								contactFlag,
+							},
+							Before: func(c *cli.Context) error {
+								if len(c.Args()) > 0 {
+									return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+								}
+								if !interactive && !c.IsSet("id") {
+									return log.Error("option --id is mandatory")
+								}
+								if !c.IsSet("group") {
+									return log.Error("option --group is mandatory")
+								}
+								if !c.IsSet("contact") {
+									return log.Error("option --contact is mandatory")
+								}
+								return ce.prepare(c, true, true)
+							},
+							Action: func(c *cli.Context) {
+								ce.err = ce.groupRemove(ce.getID(c), c.String("group"),
+									c.String("contact"))
+							},
+						},
+						{
+							Name:  "list",
+							Usage: "list groups (or members of group)",
+							Flags: []cli.Flag{
+								idFlag,
+								groupFlag,
+							},
+							Before: func(c *cli.Context) error {
+								if len(c.Args()) > 0 {
+									return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+								}
+								if !interactive && !c.IsSet("id") {
+									return log.Error("option --id is mandatory")
+								}
+								return ce.prepare(c, true, true)
+							},
+							Action: func(c *cli.Context) {
+								ce.err = ce.groupList(ce.fileTable.OutputFP,
+									ce.getID(c), c.String("group"))
+							},
+						},
+					},
+				},
 				{
 					Name:  "send",
 					Usage: "send messages from out queue",
+					Description: `
+Send messages from out queue.
+To resist timing correlation, option --cover sends the given number of decoy
+messages to yourself for every real message (the real message is sent at a
+random position among them). Decoys look like real messages to observers,
+are paid with tokens, and are discarded on receipt. Option --max-wait waits
+a random time up to the given duration before every delivery.
+`,
 					Flags: []cli.Flag{
 						idFlag,
 						allFlag,
@@ -1046,6 +1798,14 @@ email body as the actual message.
 							Name:  "fail-delivery",
 							Usage: "Fail on first delivery attempt (for testing purposes)",
 						},
+						cli.IntFlag{
+							Name:  "cover",
+							Usage: "number of decoy messages sent per message",
+						},
+						cli.DurationFlag{
+							Name:  "max-wait",
+							Usage: "maximum random wait before every delivery (e.g., 5m)",
+						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
@@ -1054,11 +1814,62 @@ email body as the actual message.
 						if !interactive && !c.IsSet("all") && !c.IsSet("id") {
 							return log.Error("option --id is mandatory")
 						}
+						if c.Int("cover") < 0 {
+							return log.Error("option --cover must not be negative")
+						}
+						if c.Duration("max-wait") < 0 {
+							return log.Error("option --max-wait must not be negative")
+						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
+						var cover *coverTraffic
+						if c.Int("cover") > 0 || c.Duration("max-wait") > 0 {
+							cover = &coverTraffic{
+								decoys:  c.Int("cover"),
+								maxWait: c.Duration("max-wait"),
+							}
+						}
 						ce.err = ce.msgSend(c, ce.getID(c), c.Bool("all"),
-							c.Bool("fail-delivery"))
+							c.Bool("fail-delivery"), cover)
+					},
+				},
+				{
+					Name:  "resend",
+					Usage: "resend messages whose delivery failed",
+					Description: `
+Try again to deliver outgoing messages whose delivery failed (e.g., due to
+network issues). Either all failed messages of the user ID are resent (--all)
+or only the message given with --msgnum. The outcome is reported per message
+(sent, resend, or retract) on output-fd. Retracted messages (expired token)
+are encrypted again by the next 'msg send'.
+`,
+					Flags: []cli.Flag{
+						idFlag,
+						cli.BoolFlag{
+							Name:  "all",
+							Usage: "resend all failed messages",
+						},
+						msgNumFlag,
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						if !c.IsSet("all") && !c.IsSet("msgnum") {
+							return log.Error("option --all or --msgnum is mandatory")
+						}
+						if c.IsSet("all") && c.IsSet("msgnum") {
+							return log.Error("options --all and --msgnum exclude each other")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.msgResend(c, ce.fileTable.OutputFP, ce.getID(c),
+							int64(c.Int("msgnum")))
 					},
 				},
 				{
@@ -1086,6 +1897,11 @@ email body as the actual message.
 				{
 					Name:  "list",
 					Usage: "list messages",
+					Description: `
+List the messages of the given user ID, one per line with the tab separated
+columns direction and status, message number, date, sender, recipient,
+subject, and content type.
+`,
 					Flags: []cli.Flag{
 						idFlag,
 					},
@@ -1102,9 +1918,38 @@ email body as the actual message.
 						ce.err = ce.msgList(ce.fileTable.OutputFP, ce.getID(c))
 					},
 				},
+				{
+					Name:  "queue",
+					Usage: "list queued messages which have not been sent yet",
+					Flags: []cli.Flag{
+						idFlag,
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "output queue as JSON",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.msgQueue(ce.fileTable.OutputFP, ce.getID(c),
+							c.Bool("json"))
+					},
+				},
 				{
 					Name:  "read",
 					Usage: "read message",
+					Description: `
+Write the message with the given --msgnum as an email message to output-fd.
+For received messages the header Delivered-To contains the nym address the
+message was sent to (if known, see 'nym stats').
+`,
 					Flags: []cli.Flag{
 						idFlag,
 						msgNumFlag,
@@ -1127,12 +1972,15 @@ email body as the actual message.
 					},
 				},
 				{
-					Name:  "delete",
-					Usage: "delete a message",
+					Name:  "verify",
+					Usage: "verify permanent signature of received message",
 					Description: `
-Deletes a message.					
-A deleted message is permanently gone. Handle with care!
-					`,
+Verify the permanent signature of a received message against the current
+signature key (SIGKEY) of the sender. Writes VALID or INVALID, the sender,
+and the fingerprint (SIGKEYHASH) of the signature key to output-fd.
+Signatures of messages fetched with --defer-signature-check are only verified
+by this command.
+`,
 					Flags: []cli.Flag{
 						idFlag,
 						msgNumFlag,
@@ -1150,199 +1998,1053 @@ A deleted message is permanently gone. Handle with care!
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.msgDelete(ce.getID(c), int64(c.Int("msgnum")))
+						ce.err = ce.msgVerify(c, ce.fileTable.OutputFP, ce.getID(c),
+							int64(c.Int("msgnum")))
 					},
 				},
-			},
-		},
-		{
-			Name:  "upkeep",
-			Usage: "Commands for upkeep (maintenance)",
-			Subcommands: []cli.Command{
 				{
-					Name:  "all",
-					Usage: "Perform all upkeep tasks for user ID",
+					Name:  "delete",
+					Usage: "delete a message",
+					Description: `
+Deletes a message.					
+A deleted message is permanently gone. Handle with care!
+					`,
 					Flags: []cli.Flag{
 						idFlag,
-						cli.StringFlag{
-							Name:  "period",
-							Usage: "perform task only if last execution was earlier than period",
-						},
+						msgNumFlag,
+						dryRunFlag,
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
-							return log.Errorf("superfluous argument(s): %s",
-								strings.Join(c.Args(), " "))
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 						}
 						if !interactive && !c.IsSet("id") {
 							return log.Error("option --id is mandatory")
 						}
-						if !c.IsSet("period") {
-							return log.Error("option --period is mandatory")
+						if !c.IsSet("msgnum") {
+							return log.Error("option --msgnum is mandatory")
 						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.upkeepAll(c, ce.getID(c),
-							c.String("period"), ce.fileTable.StatusFP)
+						ce.err = ce.msgDelete(ce.fileTable.StatusFP, ce.getID(c),
+							int64(c.Int("msgnum")), c.Bool("dry-run"))
 					},
 				},
 				{
-					Name:  "fetchconf",
-					Usage: "Fetch current Mute system config",
+					Name:  "cancel",
+					Usage: "cancel a queued message",
+					Description: `
+Cancels an outgoing message which has not been sent yet.
+The message is removed from the message DB and the out queue.
+Messages which have been sent already cannot be cancelled.
+					`,
 					Flags: []cli.Flag{
-						cli.BoolFlag{
-							Name:  "show",
-							Usage: "Show config on output-fd",
-						},
+						idFlag,
+						msgNumFlag,
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
-							return log.Errorf("superfluous argument(s): %s",
-								strings.Join(c.Args(), " "))
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						if !c.IsSet("msgnum") {
+							return log.Error("option --msgnum is mandatory")
 						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.upkeepFetchconf(ce.msgDB,
-							c.GlobalString("homedir"), c.Bool("show"),
-							ce.fileTable.OutputFP, ce.fileTable.StatusFP)
+						ce.err = ce.msgCancel(ce.getID(c), int64(c.Int("msgnum")))
 					},
 				},
 				{
-					Name:  "update",
-					Usage: "Update Mute binaries (from source)",
-					// TODO: "Update Mute binaries (from source or download binaries)",
-					/*
-						Flags: []cli.Flag{
-							cli.BoolFlag{
-								Name:  "source",
-								Usage: "Force update from source",
-							},
-							cli.BoolFlag{
-								Name:  "binary",
-								Usage: "Force binary update",
-							},
+					Name:  "export",
+					Usage: "export messages to file",
+					Description: `
+Exports all messages of a user ID to a file, including read status, dates,
+delays, priorities, and signatures. The only supported format is the native
+versioned format mmx, which can be imported with msg import (also into another
+Mute installation). The export file is not encrypted! Unread
+burn-after-reading messages are not exported.
+					`,
+					Flags: []cli.Flag{
+						idFlag,
+						cli.StringFlag{
+							Name:  "format",
+							Value: mmxFormat,
+							Usage: "export format",
 						},
-					*/
+						cli.StringFlag{
+							Name:  "file",
+							Usage: "write export to file",
+						},
+					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
-							return log.Errorf("superfluous argument(s): %s",
-								strings.Join(c.Args(), " "))
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 						}
-						/*
-							if c.Bool("source") && c.Bool("binary") {
-								return log.Error("options --source and --binary exclude each other")
-							}
-						*/
-						return ce.prepare(c, true, false)
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						if !c.IsSet("file") {
+							return log.Error("option --file is mandatory")
+						}
+						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.upkeepUpdate(c.GlobalString("homedir"),
-							/* c.Bool("source"), c.Bool("binary"), */
-							ce.fileTable.OutputFP, ce.fileTable.StatusFP)
+						ce.err = ce.msgExport(ce.fileTable.StatusFP, ce.getID(c),
+							c.String("format"), c.String("file"))
 					},
 				},
 				{
-					Name:  "accounts",
-					Usage: "Renew accounts on server",
+					Name:  "import",
+					Usage: "import messages from file",
+					Description: `
+Imports messages exported with msg export into the messages of a user ID.
+Peers which are not a contact yet are added to the gray list. Messages which
+exist already are skipped, imported outgoing messages are never sent again.
+					`,
 					Flags: []cli.Flag{
 						idFlag,
 						cli.StringFlag{
-							Name:  "period",
-							Usage: "perform task only if last execution was earlier than period",
-						},
-						cli.StringFlag{
-							Name:  "remaining",
-							Value: "2160h",
-							Usage: "renew account only if remaining time is less than remaining",
+							Name:  "file",
+							Usage: "read export from file",
 						},
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
-							return log.Errorf("superfluous argument(s): %s",
-								strings.Join(c.Args(), " "))
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 						}
 						if !interactive && !c.IsSet("id") {
 							return log.Error("option --id is mandatory")
 						}
-						if !c.IsSet("period") {
-							return log.Error("option --period is mandatory")
+						if !c.IsSet("file") {
+							return log.Error("option --file is mandatory")
 						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.upkeepAccounts(ce.getID(c),
-							c.String("period"), c.String("remaining"),
-							ce.fileTable.StatusFP)
+						ce.err = ce.msgImport(ce.fileTable.StatusFP, ce.getID(c),
+							c.String("file"))
 					},
 				},
+			},
+		},
+		{
+			Name:  "nym",
+			Usage: "commands for nym address management",
+			Subcommands: []cli.Command{
 				{
-					Name:  "hashchain",
-					Usage: "Sync and verify hashchain for the given domain.",
+					Name:  "new",
+					Usage: "generate new nym address to receive messages",
 					Flags: []cli.Flag{
-						cli.StringFlag{
-							Name:  "domain",
-							Usage: "key server domain",
+						idFlag,
+						cli.BoolFlag{
+							Name:  "single-use",
+							Usage: "nym address can only be used once",
 						},
-						hostFlag,
 					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
-							return log.Errorf("superfluous argument(s): %s",
-								strings.Join(c.Args(), " "))
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 						}
-						if !c.IsSet("domain") {
-							return log.Error("option --domain is mandatory")
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
 						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.upkeepHashchain(c, c.String("domain"),
-							c.String("host"))
+						ce.err = ce.nymNew(ce.fileTable.OutputFP, ce.getID(c),
+							c.Bool("single-use"))
 					},
 				},
-			},
-		},
-		{
-			Name:  "wallet",
-			Usage: "Commands for wallet management",
-			Subcommands: []cli.Command{
 				{
-					Name:  "pubkey",
-					Usage: "Show public key of wallet",
+					Name:  "rotate",
+					Usage: "replace nym addresses by a new one (old ones retire after grace period)",
+					Flags: []cli.Flag{
+						idFlag,
+						cli.BoolFlag{
+							Name:  "single-use",
+							Usage: "nym address can only be used once",
+						},
+						cli.DurationFlag{
+							Name:  "grace",
+							Value: 72 * time.Hour,
+							Usage: "period in which old nym addresses still receive messages",
+						},
+					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
-							return log.Errorf("superfluous argument(s): %s",
-								strings.Join(c.Args(), " "))
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
 						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.walletPubkey(ce.fileTable.OutputFP)
+						ce.err = ce.nymRotate(ce.fileTable.OutputFP,
+							ce.fileTable.StatusFP, ce.getID(c),
+							c.Bool("single-use"), c.Duration("grace"))
 					},
 				},
 				{
-					Name:  "balance",
-					Usage: "Show balance key of wallet",
+					Name:  "list",
+					Usage: "list active nym addresses",
+					Flags: []cli.Flag{
+						idFlag,
+					},
 					Before: func(c *cli.Context) error {
 						if len(c.Args()) > 0 {
-							return log.Errorf("superfluous argument(s): %s",
-								strings.Join(c.Args(), " "))
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
 						}
 						return ce.prepare(c, true, true)
 					},
 					Action: func(c *cli.Context) {
-						ce.err = ce.walletBalance(ce.fileTable.OutputFP)
+						ce.err = ce.nymList(ce.fileTable.OutputFP, ce.getID(c))
 					},
 				},
-			},
-		},
-		{
-			Name:  "quit",
-			Usage: "End program",
-			Before: func(c *cli.Context) error {
-				if len(c.Args()) > 0 {
+				{
+					Name:  "stats",
+					Usage: "show number of messages received per nym address",
+					Flags: []cli.Flag{
+						idFlag,
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.nymStats(ce.fileTable.OutputFP, ce.getID(c))
+					},
+				},
+			},
+		},
+		{
+			Name:  "ping",
+			Usage: "Measure end-to-end latency to a contact",
+			Description: `
+Send a small signed control message (ping) to a contact, which is not shown in
+the inbox of the contact, and report the used mix delay.
+If the client of the contact supports it, the ping is answered automatically
+with a pong (only for white listed contacts). With option --wait new messages
+are fetched until the pong arrives (or the duration has passed) and the
+round-trip time is reported. Pongs received later are reported by 'msg fetch'
+on status-fd.
+`,
+			Flags: []cli.Flag{
+				idFlag,
+				contactFlag,
+				cli.DurationFlag{
+					Name:  "wait",
+					Usage: "wait for the pong up to this duration (e.g., 30m)",
+				},
+				mindelayFlag,
+				maxdelayFlag,
+				nodelaycheckFlag,
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				if !interactive && !c.IsSet("id") {
+					return log.Error("option --id is mandatory")
+				}
+				if !c.IsSet("contact") {
+					return log.Error("option --contact is mandatory")
+				}
+				if c.Duration("wait") < 0 {
+					return log.Error("option --wait must not be negative")
+				}
+				if err := checkDelayArgs(c); err != nil {
+					return err
+				}
+				return ce.prepare(c, true, true)
+			},
+			Action: func(c *cli.Context) {
+				minDelay, maxDelay := msgDelays(c)
+				ce.err = ce.ping(c, ce.fileTable.OutputFP, ce.getID(c),
+					c.String("contact"), minDelay, maxDelay, c.Duration("wait"))
+			},
+		},
+		{
+			Name:  "lan",
+			Usage: "Commands for direct messaging in the local network",
+			Subcommands: []cli.Command{
+				{
+					Name:  "announce",
+					Usage: "Announce user ID in local network and receive messages",
+					Flags: []cli.Flag{
+						idFlag,
+						cli.IntFlag{
+							Name:  "port",
+							Usage: "TCP port to receive messages on (0 means random port)",
+						},
+						cli.DurationFlag{
+							Name:  "timeout",
+							Value: 30 * time.Second,
+							Usage: "timeout for receiving a message",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						if c.Int("port") < 0 || c.Int("port") > 65535 {
+							return log.Errorf("invalid port: %d", c.Int("port"))
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.lanAnnounce(c, ce.fileTable.StatusFP,
+							ce.getID(c), uint16(c.Int("port")),
+							c.Duration("timeout"))
+					},
+				},
+				{
+					Name:  "discover",
+					Usage: "Discover peers in local network",
+					Flags: []cli.Flag{
+						cli.DurationFlag{
+							Name:  "timeout",
+							Value: 2 * time.Second,
+							Usage: "time to wait for answers of peers",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, false, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.lanDiscover(ce.fileTable.OutputFP,
+							c.Duration("timeout"))
+					},
+				},
+				{
+					Name:  "send",
+					Usage: "Send undelivered messages directly to peers in local network",
+					Flags: []cli.Flag{
+						idFlag,
+						cli.DurationFlag{
+							Name:  "timeout",
+							Value: 2 * time.Second,
+							Usage: "time to wait for answers of peers (and for sending)",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.lanSend(c, ce.fileTable.StatusFP,
+							ce.getID(c), c.Duration("timeout"))
+					},
+				},
+			},
+		},
+		{
+			Name:  "upkeep",
+			Usage: "Commands for upkeep (maintenance)",
+			Subcommands: []cli.Command{
+				{
+					Name:  "all",
+					Usage: "Perform all upkeep tasks for user ID",
+					Flags: []cli.Flag{
+						idFlag,
+						cli.StringFlag{
+							Name:  "period",
+							Usage: "perform task only if last execution was earlier than period",
+						},
+						cli.StringFlag{
+							Name:  "jitter",
+							Value: "0s",
+							Usage: "delay task by a random duration of at most jitter",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						if !c.IsSet("period") {
+							return log.Error("option --period is mandatory")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.upkeepAll(c, ce.getID(c),
+							c.String("period"), c.String("jitter"),
+							ce.fileTable.StatusFP)
+					},
+				},
+				{
+					Name:  "fetchconf",
+					Usage: "Fetch current Mute system config",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "show",
+							Usage: "Show config on output-fd",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.upkeepFetchconf(ce.msgDB,
+							c.GlobalString("homedir"), c.Bool("show"),
+							ce.fileTable.OutputFP, ce.fileTable.StatusFP)
+					},
+				},
+				{
+					Name:  "update",
+					Usage: "Update Mute binaries (from source)",
+					// TODO: "Update Mute binaries (from source or download binaries)",
+					/*
+						Flags: []cli.Flag{
+							cli.BoolFlag{
+								Name:  "source",
+								Usage: "Force update from source",
+							},
+							cli.BoolFlag{
+								Name:  "binary",
+								Usage: "Force binary update",
+							},
+						},
+					*/
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						/*
+							if c.Bool("source") && c.Bool("binary") {
+								return log.Error("options --source and --binary exclude each other")
+							}
+						*/
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.upkeepUpdate(c.GlobalString("homedir"),
+							/* c.Bool("source"), c.Bool("binary"), */
+							ce.fileTable.OutputFP, ce.fileTable.StatusFP)
+					},
+				},
+				{
+					Name:  "accounts",
+					Usage: "Renew accounts on server",
+					Flags: []cli.Flag{
+						idFlag,
+						cli.StringFlag{
+							Name:  "period",
+							Usage: "perform task only if last execution was earlier than period",
+						},
+						cli.StringFlag{
+							Name:  "jitter",
+							Value: "0s",
+							Usage: "delay task by a random duration of at most jitter",
+						},
+						cli.StringFlag{
+							Name:  "remaining",
+							Value: "2160h",
+							Usage: "renew account only if remaining time is less than remaining",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if !interactive && !c.IsSet("id") {
+							return log.Error("option --id is mandatory")
+						}
+						if !c.IsSet("period") {
+							return log.Error("option --period is mandatory")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.upkeepAccounts(ce.getID(c),
+							c.String("period"), c.String("jitter"),
+							c.String("remaining"),
+							ce.fileTable.StatusFP)
+					},
+				},
+				{
+					Name:  "hashchain",
+					Usage: "Sync and verify hashchain for the given domain.",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "domain",
+							Usage: "key server domain",
+						},
+						hostFlag,
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("domain") {
+							return log.Error("option --domain is mandatory")
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.upkeepHashchain(c, c.String("domain"),
+							c.String("host"))
+					},
+				},
+			},
+		},
+		{
+			Name:  "wallet",
+			Usage: "Commands for wallet management",
+			Subcommands: []cli.Command{
+				{
+					Name:  "pubkey",
+					Usage: "Show public key of wallet",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.walletPubkey(ce.fileTable.OutputFP)
+					},
+				},
+				{
+					Name:  "balance",
+					Usage: "Show balance key of wallet",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true, true)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.walletBalance(ce.fileTable.OutputFP,
+							ce.fileTable.StatusFP)
+					},
+				},
+				{
+					Name:  "estimate",
+					Usage: "Estimate token cost of an operation",
+					Description: `
+Computes the token cost of an operation without performing it: the number of
+required tokens per usage times the unit price of the usage, which is defined
+by the server configuration.
+`,
+					Subcommands: []cli.Command{
+						{
+							Name:  "uid-new",
+							Usage: "Estimate token cost of uid new",
+							Before: func(c *cli.Context) error {
+								if len(c.Args()) > 0 {
+									return log.Errorf("superfluous argument(s): %s",
+										strings.Join(c.Args(), " "))
+								}
+								return ce.prepare(c, true, false)
+							},
+							Action: func(c *cli.Context) {
+								ce.err = ce.walletEstimate(ce.fileTable.OutputFP,
+									ce.estimateUIDNew())
+							},
+						},
+						{
+							Name:  "keyinit-add",
+							Usage: "Estimate token cost of adding KeyInit messages",
+							Flags: []cli.Flag{
+								cli.IntFlag{
+									Name:  "count",
+									Value: 1,
+									Usage: "number of KeyInit messages",
+								},
+							},
+							Before: func(c *cli.Context) error {
+								if len(c.Args()) > 0 {
+									return log.Errorf("superfluous argument(s): %s",
+										strings.Join(c.Args(), " "))
+								}
+								if c.Int("count") < 0 {
+									return log.Error("option --count must not be negative")
+								}
+								return ce.prepare(c, true, false)
+							},
+							Action: func(c *cli.Context) {
+								ce.err = ce.walletEstimate(ce.fileTable.OutputFP,
+									ce.estimateKeyInitAdd(int64(c.Int("count"))))
+							},
+						},
+						{
+							Name:  "msg-send",
+							Usage: "Estimate token cost of msg send",
+							Flags: []cli.Flag{
+								idFlag,
+								allFlag,
+							},
+							Before: func(c *cli.Context) error {
+								if len(c.Args()) > 0 {
+									return log.Errorf("superfluous argument(s): %s",
+										strings.Join(c.Args(), " "))
+								}
+								if !interactive && !c.IsSet("all") && !c.IsSet("id") {
+									return log.Error("option --id is mandatory")
+								}
+								return ce.prepare(c, true, false)
+							},
+							Action: func(c *cli.Context) {
+								costs, err := ce.estimateMsgSend(ce.getID(c),
+									c.Bool("all"))
+								if err != nil {
+									ce.err = err
+									return
+								}
+								ce.err = ce.walletEstimate(ce.fileTable.OutputFP,
+									costs)
+							},
+						},
+					},
+				},
+				{
+					Name:  "import",
+					Usage: "Import tokens received out of band",
+					Description: `
+Imports tokens which have been delivered out of band (for example, per email)
+into the wallet. The tokens are base64 encoded and must be owned by the wallet
+(see wallet pubkey). Their signatures are verified against the known verify
+keys before they are added to the wallet.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "token",
+							Usage: "base64 encoded token to import",
+						},
+						cli.StringFlag{
+							Name:  "file",
+							Usage: "read base64 encoded tokens from file (one per line)",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("token") && !c.IsSet("file") {
+							return log.Error("option --token or --file is mandatory")
+						}
+						if c.IsSet("token") && c.IsSet("file") {
+							return log.Error("options --token and --file exclude each other")
+						}
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						tokens := []string{c.String("token")}
+						if c.IsSet("file") {
+							var err error
+							tokens, err = readTokens(c.String("file"))
+							if err != nil {
+								ce.err = err
+								return
+							}
+						}
+						ce.err = ce.walletImport(ce.fileTable.StatusFP, tokens)
+					},
+				},
+				{
+					Name:  "backup",
+					Usage: "Back up wallet key and tokens",
+					Description: `
+Writes the private wallet key and all tokens of the wallet to a backup file,
+encrypted under a backup passphrase. The backup passphrase is read (twice) from
+the passphrase file descriptor.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "file",
+							Usage: "write wallet backup to file",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("file") {
+							return log.Error("option --file is mandatory")
+						}
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.walletBackup(ce.fileTable.StatusFP,
+							c.String("file"))
+					},
+				},
+				{
+					Name:  "restore",
+					Usage: "Restore wallet key and tokens from backup",
+					Description: `
+Restores a wallet backup written by wallet backup. The backup passphrase is read
+from the passphrase file descriptor. If the database contains a different wallet
+key, the restore is refused unless --force is given (even if the replaced
+wallet holds no tokens, the tokens of the replaced wallet become unusable).
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "file",
+							Usage: "read wallet backup from file",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "replace an existing different wallet",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("file") {
+							return log.Error("option --file is mandatory")
+						}
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.walletRestore(ce.fileTable.StatusFP,
+							c.String("file"), c.Bool("force"))
+					},
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "Commands for configuration management",
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "Show effective configuration values and their source",
+					Description: `
+Show the effective configuration values and their source. Every value is
+resolved in the order: command line flag, environment variable, message
+database (KDF iterations of the existing database), server configuration,
+built-in default. The mix delays are the ones msg add uses for the given
+--priority.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "priority",
+							Value: "normal",
+							Usage: "message priority (low, normal, or high) of the shown mix delays",
+						},
+						mindelayFlag,
+						maxdelayFlag,
+						cli.IntFlag{
+							Name:  "iterations",
+							Value: encdb.KDFIterations,
+							Usage: "number of KDF iterations",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "output configuration as JSON",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if _, err := parsePriority(c.String("priority")); err != nil {
+							return err
+						}
+						return ce.prepare(c, false, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.configShow(ce.fileTable.OutputFP, c,
+							c.Bool("json"))
+					},
+				},
+			},
+		},
+		{
+			Name:  "audit",
+			Usage: "Commands for the audit log",
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "Show audit log of security-relevant events",
+					Description: `
+Shows the local audit log, which records security-relevant events like UID
+registrations, first seen and changed keys of contacts, changes of their
+verification status, failed decryptions, and message deliveries. The audit log
+is append-only and separate from the debug log.
+`,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "output audit log as JSON",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, false, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.auditShow(ce.fileTable.OutputFP,
+							c.Bool("json"))
+					},
+				},
+				{
+					Name:  "verify",
+					Usage: "Verify hash chain of audit log",
+					Description: `
+Every entry of the audit log contains a hash which chains it to the previous
+entry. Verifies that the chain is intact, that is, that no entry has been
+modified, removed, or inserted after the fact. The head of the chain is
+anchored in the message database, which detects a truncated or completely
+rewritten audit log as well.
+`,
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.auditVerify(ce.fileTable.StatusFP)
+					},
+				},
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "Show state of databases and the action expected next",
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				return ce.prepare(c, false, false)
+			},
+			Action: func(c *cli.Context) {
+				ce.err = ce.status(ce.fileTable.OutputFP,
+					c.GlobalString("homedir"))
+			},
+		},
+		{
+			Name:  "stats",
+			Usage: "Show statistics of the session",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "network",
+					Usage: "show network usage (bytes sent and received)",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				if !c.Bool("network") {
+					return log.Error("option --network is mandatory")
+				}
+				return ce.prepare(c, false, false)
+			},
+			Action: func(c *cli.Context) {
+				ce.err = ce.statsNetwork(ce.fileTable.OutputFP)
+			},
+		},
+		{
+			Name:  "loglevel",
+			Usage: "Commands for logging level management",
+			Subcommands: []cli.Command{
+				{
+					Name:  "set",
+					Usage: "Set logging level at runtime",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "level",
+							Usage: "logging level {trace, debug, info, warn, error, critical}",
+						},
+					},
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s",
+								strings.Join(c.Args(), " "))
+						}
+						if !c.IsSet("level") {
+							return log.Error("option --level is mandatory")
+						}
+						return ce.prepare(c, false, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.loglevelSet(ce.fileTable.StatusFP,
+							c.String("level"))
+					},
+				},
+			},
+		},
+		{
+			Name:  "alias",
+			Usage: "Commands for command alias management",
+			Subcommands: []cli.Command{
+				{
+					Name:      "set",
+					Usage:     "Define alias for command line (usable in interactive mode)",
+					ArgsUsage: "name command...",
+					// the command of the alias can contain flags
+					SkipFlagParsing: true,
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) < 2 {
+							return log.Error("alias name and command are mandatory")
+						}
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.aliasSet(c.Args().First(), c.Args().Tail())
+					},
+				},
+				{
+					Name:      "remove",
+					Usage:     "Remove alias",
+					ArgsUsage: "name",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) != 1 {
+							return log.Error("exactly one alias name is mandatory")
+						}
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.aliasRemove(c.Args().First())
+					},
+				},
+				{
+					Name:  "list",
+					Usage: "List aliases",
+					Before: func(c *cli.Context) error {
+						if len(c.Args()) > 0 {
+							return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+						}
+						return ce.prepare(c, true, false)
+					},
+					Action: func(c *cli.Context) {
+						ce.err = ce.aliasList(ce.fileTable.OutputFP)
+					},
+				},
+			},
+		},
+		{
+			Name:      "run",
+			Usage:     "Run commands from script file (one per line, # starts comments)",
+			ArgsUsage: "script",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "continue-on-error",
+					Usage: "continue with next command after a failed one",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) != 1 {
+					return log.Error("exactly one script file is mandatory")
+				}
+				return ce.prepare(c, false, false)
+			},
+			Action: func(c *cli.Context) {
+				ce.err = ce.runScript(c, c.Args().First(),
+					c.Bool("continue-on-error"))
+			},
+		},
+		{
+			Name:  "support-bundle",
+			Usage: "Write diagnostics bundle for bug reports",
+			Description: `
+Writes a zip file with the information usually requested for bug reports:
+version and build information, the status and integrity of the databases, the
+effective configuration (see config show), and the end of the log files.
+Passphrases, tokens, and private keys are removed from all contents. Please
+check the bundle before attaching it to an issue anyway.
+`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "write support bundle to zip file",
+				},
+				mindelayFlag,
+				maxdelayFlag,
+				cli.IntFlag{
+					Name:  "iterations",
+					Value: encdb.KDFIterations,
+					Usage: "number of KDF iterations",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				if !c.IsSet("out") {
+					return log.Error("option --out is mandatory")
+				}
+				return ce.prepare(c, true, false)
+			},
+			Action: func(c *cli.Context) {
+				ce.err = ce.supportBundle(c, ce.fileTable.StatusFP,
+					c.String("out"))
+			},
+		},
+		{
+			Name:  "man",
+			Usage: "Generate man page from command descriptions",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "write man page to directory (instead of output-fd)",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
+					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
+				}
+				return ce.prepare(c, false, false)
+			},
+			Action: func(c *cli.Context) {
+				ce.err = ce.man(ce.fileTable.OutputFP, c.String("out"))
+			},
+		},
+		{
+			Name:  "quit",
+			Usage: "End program",
+			Before: func(c *cli.Context) error {
+				if len(c.Args()) > 0 {
 					return log.Errorf("superfluous argument(s): %s", strings.Join(c.Args(), " "))
 				}
 				return ce.prepare(c, false, false)
@@ -1355,11 +3057,21 @@ A deleted message is permanently gone. Handle with care!
 	return &ce
 }
 
-// Start starts the CtrlEngine with the given args.
+// SetContext sets the context ctx which cancels network operations of ce
+// (like retries of configuration fetches).
+func (ce *CtrlEngine) SetContext(ctx context.Context) {
+	ce.ctx = ctx
+}
+
+// Start starts the CtrlEngine with the given args. Errors are returned as
+// *engerr.Error, test for the error classes with errors.Is.
 func (ce *CtrlEngine) Start(args []string) error {
 	ce.app.Name = args[0]
 	if err := ce.app.Run(args); err != nil {
-		return err
+		return engerr.Classify(err)
+	}
+	if ce.err == errExit {
+		return ce.err
 	}
 	if ce.err != nil {
 		return ce.translateError(ce.err)
@@ -1378,17 +3090,41 @@ func decodeWalletKey(p string) (*[ed25519.PrivateKeySize]byte, error) {
 	return &ret, nil
 }
 
+// readPassphrase reads the next passphrase from the passphrase file
+// descriptor (not echoed, if it is a terminal).
+func (ce *CtrlEngine) readPassphrase() ([]byte, error) {
+	fd := int(ce.fileTable.PassphraseFD)
+	if terminal.IsTerminal(fd) {
+		passphrase, err := terminal.ReadPassword(fd)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		return passphrase, nil
+	}
+	if ce.passphraseScanner == nil {
+		ce.passphraseScanner = bufio.NewScanner(ce.fileTable.PassphraseFP)
+	}
+	if !ce.passphraseScanner.Scan() {
+		if err := ce.passphraseScanner.Err(); err != nil {
+			return nil, log.Error(err)
+		}
+		return nil, nil
+	}
+	// copy, the scanner reuses its buffer
+	return append([]byte{}, ce.passphraseScanner.Bytes()...), nil
+}
+
 func (ce *CtrlEngine) openMsgDB(
 	homedir string,
 ) error {
 	// read passphrase, if necessary
 	if ce.passphrase == nil {
-		fmt.Fprintf(ce.fileTable.StatusFP, "read passphrase from fd %d (not echoed)\n",
+		i18n.Fprintf(ce.fileTable.StatusFP, "read passphrase from fd %d (not echoed)\n",
 			ce.fileTable.PassphraseFD)
 		log.Infof("read passphrase from fd %d (not echoed)",
 			ce.fileTable.PassphraseFD)
 		var err error
-		ce.passphrase, err = util.Readline(ce.fileTable.PassphraseFP)
+		ce.passphrase, err = ce.readPassphrase()
 		if err != nil {
 			return err
 		}
@@ -1399,9 +3135,27 @@ func (ce *CtrlEngine) openMsgDB(
 	msgdbname := filepath.Join(homedir, "msgs")
 	log.Infof("open msgDB %s", msgdbname)
 	var err error
-	ce.msgDB, err = msgdb.Open(msgdbname, ce.passphrase)
+	if ce.readOnly {
+		ce.msgDB, err = msgdb.OpenReadOnly(msgdbname, ce.passphrase)
+	} else {
+		ce.msgDB, err = msgdb.Open(msgdbname, ce.passphrase)
+	}
 	if err != nil {
-		return err
+		// do not keep a wrong passphrase
+		bzero.Bytes(ce.passphrase)
+		ce.passphrase = nil
+		switch err {
+		case encdb.ErrWrongPassphrase:
+			i18n.Fprintln(ce.fileTable.StatusFP,
+				"wrong passphrase, your data is intact: please try again")
+		case encdb.ErrCorruptDB:
+			i18n.Fprintln(ce.fileTable.StatusFP,
+				"passphrase correct, but database is damaged: restore from backup")
+		case encdb.ErrNotMuteDB:
+			i18n.Fprintf(ce.fileTable.StatusFP,
+				"%s is not a Mute database: check --homedir\n", msgdbname)
+		}
+		return log.Error(err)
 	}
 	return nil
 }
@@ -1417,4 +3171,11 @@ func (ce *CtrlEngine) Close() {
 		ce.msgDB = nil
 	}
 	bzero.Bytes(ce.passphrase)
+	if ce.netstatsFile != "" {
+		os.Remove(ce.netstatsFile)
+	}
+	if ce.homeLock != nil {
+		ce.homeLock.Release() // ignore error
+		ce.homeLock = nil
+	}
 }
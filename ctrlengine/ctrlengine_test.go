@@ -0,0 +1,145 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/serviceguard/client"
+	"github.com/mutecomm/mute/util/engerr"
+	"github.com/mutecomm/mute/util/testutil"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func TestFetchconfDue(t *testing.T) {
+	last := 2 * time.Hour
+	// default durations -> no fetch necessary
+	fetch, outdated := fetchconfDue(last, def.FetchconfMinDuration,
+		def.FetchconfMaxDuration)
+	if fetch {
+		t.Error("fetch should not be due with default durations")
+	}
+	if outdated {
+		t.Error("config should not be outdated with default durations")
+	}
+	// short override -> fetch necessary
+	fetch, outdated = fetchconfDue(last, time.Hour, def.FetchconfMaxDuration)
+	if !fetch {
+		t.Error("fetch should be due with --fetchconf-min 1h")
+	}
+	if outdated {
+		t.Error("config should not be outdated with default max. duration")
+	}
+	// short override -> config outdated
+	_, outdated = fetchconfDue(last, time.Minute, time.Hour)
+	if !outdated {
+		t.Error("config should be outdated with --fetchconf-max 1h")
+	}
+}
+
+func TestFetchconfFlags(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	ce := te.ce
+	args := []string{"mutectrl",
+		"--homedir", te.homedir,
+		"--logdir", filepath.Join(te.homedir, "log"),
+		"--fetchconf-min", "1h",
+		"--fetchconf-max", "2h",
+	}
+	args = append(args, te.fdArgs()...)
+	err := ce.Start(append(args, "quit"))
+	if err != errExit {
+		t.Fatalf("errExit expected, got: %v", err)
+	}
+	if ce.fetchconfMin != time.Hour {
+		t.Errorf("ce.fetchconfMin = %s, expected 1h", ce.fetchconfMin)
+	}
+	if ce.fetchconfMax != 2*time.Hour {
+		t.Errorf("ce.fetchconfMax = %s, expected 2h", ce.fetchconfMax)
+	}
+}
+
+func TestFetchconfFlagsInvalid(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	ce := te.ce
+	args := []string{"mutectrl",
+		"--homedir", te.homedir,
+		"--logdir", filepath.Join(te.homedir, "log"),
+		"--fetchconf-min", "2h",
+		"--fetchconf-max", "1h",
+	}
+	args = append(args, te.fdArgs()...)
+	err := ce.Start(append(args, "quit"))
+	if err == nil || err == errExit {
+		t.Fatalf("error expected for --fetchconf-min > --fetchconf-max")
+	}
+}
+
+func TestStartNoTokens(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	te.seedContact(a, "bob@mute.berlin")
+	// the wallet is empty (on the wallet server as well)
+	sg, err := testutil.NewServiceGuard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sg.Close()
+	config := testConfig(t)
+	config.CACert = testutil.CACert()
+	config.Map["keylookup.ServiceURL"] = sg.URL()
+	config.Map["walletrpc.ServiceURL"] = sg.URL()
+	config.Map["serviceguard.TrustRoot"] = sg.TrustRoot()
+	jsn, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netDomain, _, _ := def.ConfigParams()
+	if err := te.ce.msgDB.AddValue(netDomain, string(jsn)); err != nil {
+		t.Fatal(err)
+	}
+	te.offline = false
+	// account which has to be renewed
+	_, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk [ed25519.PrivateKeySize]byte
+	copy(pk[:], privkey)
+	var secret [64]byte
+	err = te.ce.msgDB.AddAccount(a, "", &pk, "accounts001.mute.berlin",
+		&secret, def.MinMinDelay, def.MinMaxDelay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.ce.msgDB.SetAccountTime(a, "", times.Now()+3600); err != nil {
+		t.Fatal(err)
+	}
+	err = te.start("upkeep accounts --id "+a+" --period 1s", 1)
+	if !errors.Is(err, engerr.ErrNoTokens) {
+		t.Fatalf("error should be detectable as no tokens: %v", err)
+	}
+	if !errors.Is(err, client.ErrInsufficientFunds) {
+		t.Errorf("original error lost: %v", err)
+	}
+	if errors.Is(err, engerr.ErrNetworkDown) {
+		t.Error("no tokens should not be network down")
+	}
+	var e *engerr.Error
+	if !errors.As(err, &e) {
+		t.Error("Start should return *engerr.Error")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -19,32 +20,52 @@ import (
 
 	"github.com/frankbraun/codechain/util/bzero"
 	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encdb"
 	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/util/i18n"
 	"github.com/urfave/cli"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// mutecryptArgs returns the global options of c which are passed on to every
+// mutecrypt call, followed by args.
+func mutecryptArgs(c *cli.Context, args ...string) []string {
+	global := []string{
+		"--homedir", c.GlobalString("homedir"),
+		"--loglevel", c.GlobalString("loglevel"),
+		"--logdir", c.GlobalString("logdir"),
+		"--passphrase-delays", c.GlobalString("passphrase-delays"),
+	}
+	// user-facing messages of mutecrypt are shown in the same language
+	if lang := c.GlobalString("lang"); lang != "" {
+		global = append(global, "--lang", lang)
+	}
+	// never let mutecrypt modify the keyDB in --read-only mode
+	if c.GlobalBool("read-only") {
+		global = append(global, "--read-only")
+	}
+	return append(global, args...)
+}
+
 func createKeyDB(
 	c *cli.Context,
 	w io.Writer,
 	outputFD uintptr,
 	passphrase []byte,
 ) error {
-	args := []string{
+	args := mutecryptArgs(c,
 		"--output-fd", strconv.Itoa(int(outputFD)),
 		"--passphrase-fd", "stdin",
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-	}
+	)
 	if c.GlobalBool("logconsole") {
 		args = append(args, "--logconsole")
 	}
 	args = append(args,
 		"db", "create",
 		"--iterations", strconv.Itoa(c.Int("iterations")),
+		"--kdf", c.String("kdf"),
 	)
 	cmd := exec.Command("mutecrypt", args...)
 	stdin, err := cmd.StdinPipe()
@@ -74,6 +95,29 @@ func createKeyDB(
 	return nil
 }
 
+// validateCreate checks that a MsgDB and KeyDB could be created in homedir
+// with the given number of KDF iterations, without creating anything.
+func validateCreate(statusfp io.Writer, homedir string, iter int) error {
+	// check that homedir is writable
+	fp, err := ioutil.TempFile(homedir, "validate")
+	if err != nil {
+		return log.Errorf("ctrlengine: homedir not writable: %s", err)
+	}
+	fp.Close()
+	if err := os.Remove(fp.Name()); err != nil {
+		return log.Error(err)
+	}
+	// check that DBs do not exist and iter is valid
+	for _, name := range []string{"msgs", "keys"} {
+		if err := encdb.CheckCreate(filepath.Join(homedir, name), iter); err != nil {
+			return log.Error(err)
+		}
+	}
+	i18n.Fprintf(statusfp, "validation successful\n")
+	log.Info("validation successful")
+	return nil
+}
+
 // create a new MsgDB and KeyDB.
 func (ce *CtrlEngine) dbCreate(
 	w, statusfp io.Writer,
@@ -82,7 +126,7 @@ func (ce *CtrlEngine) dbCreate(
 ) error {
 	msgdbname := filepath.Join(c.GlobalString("homedir"), "msgs")
 	// read passphrase
-	fmt.Fprintf(statusfp, "read passphrase from fd %d (not echoed)\n",
+	i18n.Fprintf(statusfp, "read passphrase from fd %d (not echoed)\n",
 		ce.fileTable.PassphraseFD)
 	log.Infof("read passphrase from fd %d (not echoed)",
 		ce.fileTable.PassphraseFD)
@@ -110,7 +154,7 @@ func (ce *CtrlEngine) dbCreate(
 	}
 	log.Info("done")
 	// read passphrase again
-	fmt.Fprintf(statusfp, "read passphrase from fd %d again (not echoed)\n",
+	i18n.Fprintf(statusfp, "read passphrase from fd %d again (not echoed)\n",
 		ce.fileTable.PassphraseFD)
 	log.Infof("read passphrase from fd %d again (not echoed)",
 		ce.fileTable.PassphraseFD)
@@ -131,9 +175,20 @@ func (ce *CtrlEngine) dbCreate(
 	if !bytes.Equal(passphrase, passphrase2) {
 		return log.Error(ErrPassphrasesDiffer)
 	}
+	if len(passphrase) == 0 {
+		return log.Error(ErrEmptyPassphrase)
+	}
+	kdf, err := encdb.NewKDF(c.String("kdf"), c.Int("iterations"))
+	if err != nil {
+		return log.Error(err)
+	}
+	// only validate?
+	if c.Bool("validate-only") {
+		return validateCreate(statusfp, homedir, c.Int("iterations"))
+	}
 	// create msgDB
 	log.Infof("create msgDB '%s'", msgdbname)
-	if err := msgdb.Create(msgdbname, passphrase, c.Int("iterations")); err != nil {
+	if err := msgdb.CreateKDF(msgdbname, passphrase, kdf); err != nil {
 		return err
 	}
 	// open msgDB
@@ -153,7 +208,7 @@ func (ce *CtrlEngine) dbCreate(
 		return err
 	}
 	// status
-	fmt.Fprintf(statusfp, "database files created\n")
+	i18n.Fprintf(statusfp, "database files created\n")
 	log.Info("database files created")
 	// determine private walletKey
 	walletKey := c.String("walletkey")
@@ -174,13 +229,10 @@ func (ce *CtrlEngine) dbCreate(
 }
 
 func rekeyKeyDB(c *cli.Context, oldPassphrase, newPassphrase []byte) error {
-	cmd := exec.Command("mutecrypt",
+	cmd := exec.Command("mutecrypt", mutecryptArgs(c,
 		"--passphrase-fd", "stdin",
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
 		"db", "rekey",
-		"--iterations", strconv.Itoa(c.Int("iterations")))
+		"--iterations", strconv.Itoa(c.Int("iterations")))...)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return err
@@ -210,11 +262,70 @@ func rekeyKeyDB(c *cli.Context, oldPassphrase, newPassphrase []byte) error {
 	return nil
 }
 
+func reiterateKeyDB(c *cli.Context, passphrase []byte) error {
+	cmd := exec.Command("mutecrypt", mutecryptArgs(c,
+		"--passphrase-fd", "stdin",
+		"db", "reiterate",
+		"--iterations", strconv.Itoa(c.Int("iterations")))...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	buf := make([]byte, len(passphrase)+1)
+	defer bzero.Bytes(buf)
+	copy(buf, passphrase)
+	copy(buf[len(passphrase):], []byte("\n"))
+	if _, err := stdin.Write(buf); err != nil {
+		return err
+	}
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+	return nil
+}
+
+// reiterate MsgDB and KeyDB: change the number of KDF iterations without
+// changing the passphrase (which is read only once).
+func (ce *CtrlEngine) dbReiterate(statusfp io.Writer, c *cli.Context) error {
+	msgdbname := filepath.Join(c.GlobalString("homedir"), "msgs")
+	// read passphrase
+	i18n.Fprintf(statusfp, "read passphrase from fd %d (not echoed)\n",
+		ce.fileTable.PassphraseFD)
+	log.Infof("read passphrase from fd %d (not echoed)",
+		ce.fileTable.PassphraseFD)
+	passphrase, err := ce.readPassphrase()
+	if err != nil {
+		return err
+	}
+	defer bzero.Bytes(passphrase)
+	log.Info("done")
+	// reiterate msgDB
+	log.Infof("reiterate msgDB '%s'", msgdbname)
+	err = msgdb.Reiterate(msgdbname, passphrase, c.Int("iterations"))
+	if err != nil {
+		return log.Error(err)
+	}
+	// reiterate keyDB
+	log.Info("reiterate keyDB")
+	if err := reiterateKeyDB(c, passphrase); err != nil {
+		return log.Error(err)
+	}
+	i18n.Fprintf(statusfp, "databases use %d KDF iterations now\n",
+		c.Int("iterations"))
+	return nil
+}
+
 // rekey MsgDB and KeyDB.
 func (ce *CtrlEngine) dbRekey(statusfp io.Writer, c *cli.Context) error {
 	msgdbname := filepath.Join(c.GlobalString("homedir"), "msgs")
 	// read old passphrase
-	fmt.Fprintf(statusfp, "read old passphrase from fd %d (not echoed)\n",
+	i18n.Fprintf(statusfp, "read old passphrase from fd %d (not echoed)\n",
 		ce.fileTable.PassphraseFD)
 	log.Infof("read old passphrase from fd %d (not echoed)",
 		ce.fileTable.PassphraseFD)
@@ -244,7 +355,7 @@ func (ce *CtrlEngine) dbRekey(statusfp io.Writer, c *cli.Context) error {
 	}
 	log.Info("done")
 	// read new passphrase
-	fmt.Fprintf(statusfp, "read new passphrase from fd %d (not echoed)\n",
+	i18n.Fprintf(statusfp, "read new passphrase from fd %d (not echoed)\n",
 		ce.fileTable.PassphraseFD)
 	log.Infof("read new passphrase from fd %d (not echoed)",
 		ce.fileTable.PassphraseFD)
@@ -262,7 +373,7 @@ func (ce *CtrlEngine) dbRekey(statusfp io.Writer, c *cli.Context) error {
 	}
 	log.Info("done")
 	// read new passphrase again
-	fmt.Fprintf(statusfp, "read new passphrase from fd %d again (not echoed)\n",
+	i18n.Fprintf(statusfp, "read new passphrase from fd %d again (not echoed)\n",
 		ce.fileTable.PassphraseFD)
 	log.Infof("read new passphrase from fd %d again (not echoed)",
 		ce.fileTable.PassphraseFD)
@@ -294,12 +405,7 @@ func (ce *CtrlEngine) dbRekey(statusfp io.Writer, c *cli.Context) error {
 }
 
 func mutecryptDBStatus(c *cli.Context, w io.Writer, passphrase []byte) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-		"db", "status",
-	}
+	args := mutecryptArgs(c, "db", "status")
 	cmd := exec.Command("mutecrypt", args...)
 	cmd.Stdout = w
 	var errbuf bytes.Buffer
@@ -321,6 +427,79 @@ func mutecryptDBStatus(c *cli.Context, w io.Writer, passphrase []byte) error {
 	return nil
 }
 
+// mutecryptTestPassphrase checks the passphrase of the keyDB with mutecrypt
+// and returns whether it is correct.
+func mutecryptTestPassphrase(c *cli.Context, passphrase []byte) (bool, error) {
+	args := mutecryptArgs(c, "db", "test-passphrase")
+	cmd := exec.Command("mutecrypt", args...)
+	var outbuf, errbuf bytes.Buffer
+	cmd.Stdout = &outbuf
+	cmd.Stderr = &errbuf
+	ppR, ppW, err := os.Pipe()
+	if err != nil {
+		return false, err
+	}
+	defer ppR.Close()
+	ppW.Write(passphrase)
+	ppW.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, ppR)
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	err = cmd.Wait()
+	if strings.TrimSpace(outbuf.String()) == "WRONG" {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+	return true, nil
+}
+
+// dbTestPassphrase reads a passphrase and checks it against the key check
+// values of the msgDB and the keyDB without opening them for writes (or
+// loading any data). The result (OK or WRONG) for every database is written
+// to w. If the passphrase is wrong, encdb.ErrWrongPassphrase is returned.
+func (ce *CtrlEngine) dbTestPassphrase(c *cli.Context, w, statusfp io.Writer) error {
+	msgdbname := filepath.Join(c.GlobalString("homedir"), "msgs")
+	// read passphrase
+	i18n.Fprintf(statusfp, "read passphrase from fd %d (not echoed)\n",
+		ce.fileTable.PassphraseFD)
+	log.Infof("read passphrase from fd %d (not echoed)",
+		ce.fileTable.PassphraseFD)
+	passphrase, err := ce.readPassphrase()
+	if err != nil {
+		return err
+	}
+	defer bzero.Bytes(passphrase)
+	log.Info("done")
+	result := func(correct bool) string {
+		if correct {
+			return "OK"
+		}
+		return "WRONG"
+	}
+	// check msgDB
+	log.Infof("check passphrase of msgDB '%s'", msgdbname)
+	err = msgdb.CheckPassphrase(msgdbname, passphrase)
+	if err != nil && err != encdb.ErrWrongPassphrase {
+		return log.Error(err)
+	}
+	msgDBOK := err == nil
+	fmt.Fprintf(w, "msgdb: %s\n", result(msgDBOK))
+	// check keyDB
+	log.Info("check passphrase of keyDB")
+	keyDBOK, err := mutecryptTestPassphrase(c, passphrase)
+	if err != nil {
+		return log.Error(err)
+	}
+	fmt.Fprintf(w, "keydb: %s\n", result(keyDBOK))
+	if !msgDBOK || !keyDBOK {
+		return log.Error(encdb.ErrWrongPassphrase)
+	}
+	return nil
+}
+
 func (ce *CtrlEngine) dbStatus(c *cli.Context, w io.Writer) error {
 	autoVacuum, freelistCount, err := ce.msgDB.Status()
 	if err != nil {
@@ -340,12 +519,7 @@ func mutecryptDBVacuum(
 	passphrase []byte,
 	autoVacuumMode string,
 ) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-		"db", "vacuum",
-	}
+	args := mutecryptArgs(c, "db", "vacuum")
 	if autoVacuumMode != "" {
 		args = append(args, "--auto-vacuum", autoVacuumMode)
 	}
@@ -384,12 +558,7 @@ func mutecryptDBIncremental(
 	passphrase []byte,
 	pages int64,
 ) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-		"db", "incremental",
-	}
+	args := mutecryptArgs(c, "db", "incremental")
 	if pages != 0 {
 		args = append(args, "--pages", strconv.FormatInt(pages, 10))
 	}
@@ -425,12 +594,32 @@ func (ce *CtrlEngine) dbIncremental(c *cli.Context, pagesToRemove int64) error {
 }
 
 func mutecryptDBVersion(c *cli.Context, w io.Writer, passphrase []byte) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-		"db", "version",
+	args := mutecryptArgs(c, "db", "version")
+	cmd := exec.Command("mutecrypt", args...)
+	cmd.Stdout = w
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	ppR, ppW, err := os.Pipe()
+	if err != nil {
+		return err
 	}
+	defer ppR.Close()
+	ppW.Write(passphrase)
+	ppW.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, ppR)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+	return nil
+}
+
+// mutecryptDBIntegrity runs an integrity check on the keyDB with mutecrypt
+// and writes the result to w.
+func mutecryptDBIntegrity(c *cli.Context, w io.Writer, passphrase []byte) error {
+	args := mutecryptArgs(c, "db", "integrity")
 	cmd := exec.Command("mutecrypt", args...)
 	cmd.Stdout = w
 	var errbuf bytes.Buffer
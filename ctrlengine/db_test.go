@@ -0,0 +1,205 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/encdb"
+	"github.com/urfave/cli"
+)
+
+func TestMutecryptArgs(t *testing.T) {
+	var args []string
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "homedir"},
+		cli.StringFlag{Name: "loglevel"},
+		cli.StringFlag{Name: "logdir"},
+		cli.StringFlag{Name: "passphrase-delays"},
+		cli.StringFlag{Name: "lang"},
+		cli.BoolFlag{Name: "read-only"},
+	}
+	app.Action = func(c *cli.Context) error {
+		args = mutecryptArgs(c, "db", "status")
+		return nil
+	}
+	if err := app.Run([]string{"mutectrl", "--lang", "de", "--read-only"}); err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Join(args, " ")
+	if !strings.Contains(line, " --lang de ") {
+		t.Errorf("--lang not passed to mutecrypt: %s", line)
+	}
+	if !strings.HasSuffix(line, " --read-only db status") {
+		t.Errorf("wrong mutecrypt arguments: %s", line)
+	}
+	// --lang is omitted, if not set
+	if err := app.Run([]string{"mutectrl"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.Join(args, " "), "--lang") {
+		t.Errorf("--lang passed to mutecrypt: %s", args)
+	}
+}
+
+func TestDBCreate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	// `db create` fetches the config from the network and calls mutecrypt
+	if _, err := exec.LookPath("mutecrypt"); err != nil {
+		t.Skip("skipping test, mutecrypt not installed.")
+	}
+	te := newTestEngine(t)
+	defer te.close()
+	if err := te.run("db create --iterations 4096", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(te.output(), "WALLETPUBKEY:\t") {
+		t.Error("wallet public key not shown after db create")
+	}
+	if !strings.Contains(te.status(), "database files created") {
+		t.Error("db create status missing")
+	}
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "" {
+		t.Errorf("uid list on new DB should be empty: %q", out)
+	}
+}
+
+func TestDBCreateValidateOnly(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	if err := te.run("db create --validate-only --iterations 4096", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te.status(), "validation successful") {
+		t.Error("db create --validate-only status missing")
+	}
+	if _, err := os.Stat(filepath.Join(te.homedir, "msgs.db")); !os.IsNotExist(err) {
+		t.Error("db create --validate-only must not create msgs.db")
+	}
+	// validate-only on existing DB must report the conflict
+	te.seedDBs()
+	err := te.run("db create --validate-only --iterations 4096", 2)
+	if err == nil || !strings.Contains(err.Error(), "exists already") {
+		t.Fatalf("conflict with existing DB expected, got: %v", err)
+	}
+	for _, name := range []string{"keys.db", "keys.key"} {
+		if _, err := os.Stat(filepath.Join(te.homedir, name)); !os.IsNotExist(err) {
+			t.Errorf("db create --validate-only must not create %s", name)
+		}
+	}
+	// invalid iteration count
+	err = te.run("db create --validate-only --iterations -1", 2)
+	if err == nil {
+		t.Error("db create --validate-only --iterations -1 should fail")
+	}
+}
+
+func TestOpenWrongPassphrase(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	defer encdb.SetLimiter(nil)
+	te.seedDBs()
+	if _, err := te.passW.Write([]byte("wrong\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("uid list", 0); err != encdb.ErrWrongPassphrase {
+		t.Fatalf("encdb.ErrWrongPassphrase expected, got: %v", err)
+	}
+	if !strings.Contains(te.status(), "wrong passphrase") {
+		t.Error("guidance for wrong passphrase missing")
+	}
+}
+
+func TestDBTestPassphrase(t *testing.T) {
+	defer installEngines(t)()
+	te := newTestEngine(t)
+	defer te.close()
+	defer encdb.SetLimiter(nil)
+	te.seedDBs()
+	te.seedKeyDB()
+	dbfile := filepath.Join(te.homedir, "msgs.db")
+	before, err := os.Stat(dbfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// correct passphrase
+	if err := te.run("db test-passphrase", 1); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "msgdb: OK\nkeydb: OK\n" {
+		t.Errorf("output == %q", out)
+	}
+	// wrong passphrase
+	if _, err := te.passW.Write([]byte("wrong\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("db test-passphrase", 0); err != encdb.ErrWrongPassphrase {
+		t.Fatalf("encdb.ErrWrongPassphrase expected, got: %v", err)
+	}
+	if out := te.output(); out != "msgdb: WRONG\nkeydb: WRONG\n" {
+		t.Errorf("output == %q", out)
+	}
+	// database file is left untouched
+	after, err := os.Stat(dbfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size() {
+		t.Error("db test-passphrase modified the database file")
+	}
+}
+
+func TestDBReiterate(t *testing.T) {
+	defer installEngines(t)()
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	te.seedKeyDB()
+	dbfile := filepath.Join(te.homedir, "msgs.db")
+	before, err := ioutil.ReadFile(dbfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("db reiterate --iterations 8192", 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te.status(), "databases use 8192 KDF iterations now") {
+		t.Error("db reiterate status missing")
+	}
+	for _, name := range []string{"msgs", "keys"} {
+		kdf, err := encdb.ReadKDF(filepath.Join(te.homedir, name+encdb.KeySuffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kdf.Iter != 8192 {
+			t.Errorf("%s: kdf.Iter == %d != 8192", name, kdf.Iter)
+		}
+	}
+	// database file is not reencrypted
+	after, err := ioutil.ReadFile(dbfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("db reiterate reencrypted the database file")
+	}
+	// same passphrase still opens the databases
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	te.openKeyDB().Close()
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMsgDeleteDryRun(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	msgID := te.queueMessage(a, b, "message", false, false)
+	cmd := fmt.Sprintf("msg delete --dry-run --id %s --msgnum %d", a, msgID)
+	if err := te.run(cmd, 0); err != nil {
+		t.Fatal(err)
+	}
+	exp := fmt.Sprintf("would delete 1 message(s)\n\t%d\n", msgID)
+	if out := te.status(); !strings.Contains(out, exp) {
+		t.Errorf("unexpected dry-run report: %q", out)
+	}
+	if _, _, _, _, err := te.ce.msgDB.GetMessage(a, msgID); err != nil {
+		t.Error("dry-run must not delete message")
+	}
+	// unknown messages are reported as such
+	cmd = fmt.Sprintf("msg delete --dry-run --id %s --msgnum %d", a, msgID+1)
+	if err := te.run(cmd, 0); err == nil {
+		t.Error("dry-run for unknown message should fail")
+	}
+}
+
+func TestUIDDeleteDryRun(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	te.queueMessage(a, b, "message", false, false)
+	if err := te.run("uid delete --dry-run --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	out := te.status()
+	for _, line := range []string{
+		"would delete 1 user ID(s)\n\t" + a + "\n",
+		"would delete 1 contact(s)\n\t" + b + "\n",
+		"would delete 1 message(s)\n",
+		"would delete 0 account(s)\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("dry-run report does not contain %q: %s", line, out)
+		}
+	}
+	nyms, err := te.ce.msgDB.GetNyms(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nyms) != 1 {
+		t.Error("dry-run must not delete user ID")
+	}
+}
@@ -12,6 +12,10 @@ import (
 // creation or rekey operation differ.
 var ErrPassphrasesDiffer = errors.New("ctrlengine: passphrases differ")
 
+// ErrEmptyPassphrase is raised when the supplied passphrase during a DB
+// creation or a wallet backup operation is empty.
+var ErrEmptyPassphrase = errors.New("ctrlengine: passphrase is empty")
+
 // ErrUserIDOwned is raised during UID message creation, if a user ID is
 // already owned by the same user
 var ErrUserIDOwned = errors.New("user ID already owned")
@@ -23,3 +27,7 @@ var ErrUserIDTaken = errors.New("user ID already taken")
 // ErrDeliveryFailed is raised when the message delivery failed due to option
 // --fail-delivery.
 var ErrDeliveryFailed = errors.New("ctrlengine: delivery failed")
+
+// ErrRecursiveAlias is raised when the expansion of a command alias refers to
+// itself (directly or indirectly).
+var ErrRecursiveAlias = errors.New("ctrlengine: recursive alias")
@@ -0,0 +1,129 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/util/times"
+	"golang.org/x/net/websocket"
+)
+
+// Event types.
+const (
+	EventNewMessage   = "new-message"   // a new message has been received
+	EventSendStatus   = "send-status"   // the send status of a message changed
+	EventConfigUpdate = "config-update" // a new configuration has been fetched
+)
+
+// Send states of EventSendStatus.
+const (
+	SendStatusSent    = "sent"    // message has been delivered to the mix
+	SendStatusResend  = "resend"  // delivery failed, message will be resent
+	SendStatusRetract = "retract" // token expired, message will be reencrypted
+	SendStatusFailed  = "failed"  // delivery failed permanently (see app --resend-attempts)
+)
+
+// Event is a structured event emitted by the CtrlEngine.
+type Event struct {
+	Type   string // the event type (EventNewMessage, ...)
+	Date   int64  // the time the event occurred
+	MyID   string `json:",omitempty"` // the affected user ID
+	Peer   string `json:",omitempty"` // the sender of a new message
+	MsgID  int64  `json:",omitempty"` // the affected message (see msg read)
+	Status string `json:",omitempty"` // the send status (SendStatusSent, ...)
+}
+
+// eventQueueSize is the number of events which are buffered per subscriber.
+// Further events are dropped for slow subscribers.
+const eventQueueSize = 64
+
+// events distributes emitted events to all subscribers.
+type events struct {
+	mutex       sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+// subscribe returns a new channel which receives all emitted events.
+func (e *events) subscribe() chan *Event {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.subscribers == nil {
+		e.subscribers = make(map[chan *Event]struct{})
+	}
+	ch := make(chan *Event, eventQueueSize)
+	e.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes the subscription ch and closes it.
+func (e *events) unsubscribe(ch chan *Event) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.subscribers, ch)
+	close(ch)
+}
+
+// emit sends the event ev to all subscribers without blocking.
+func (e *events) emit(ev *Event) {
+	if ev.Date == 0 {
+		ev.Date = times.Now()
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for ch := range e.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			log.Warnf("ctrlengine: event queue full, drop %s event", ev.Type)
+		}
+	}
+}
+
+// checkOrigin makes sure websocket connections originate from the app itself.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return errors.New("ctrlengine: websocket origin not allowed")
+	}
+	return nil
+}
+
+// newEventsHandler returns a websocket handler which streams all events of ce
+// as JSON objects.
+func newEventsHandler(ce *CtrlEngine) http.Handler {
+	return &websocket.Server{
+		Handshake: checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ch := ce.events.subscribe()
+			defer ce.events.unsubscribe(ch)
+			// detect closed connections (clients do not send anything)
+			closed := make(chan struct{})
+			go func() {
+				var msg []byte
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+				close(closed)
+			}()
+			for {
+				select {
+				case ev := <-ch:
+					if err := websocket.JSON.Send(ws, ev); err != nil {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		},
+	}
+}
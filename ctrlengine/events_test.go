@@ -0,0 +1,80 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// dialEvents connects to the events websocket of srv with the given token.
+func dialEvents(srv *httptest.Server, token string) (*websocket.Conn, error) {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/events"
+	config, err := websocket.NewConfig(url, srv.URL)
+	if err != nil {
+		return nil, err
+	}
+	config.Header.Set(tokenHeader, token)
+	return websocket.DialConfig(config)
+}
+
+// count returns the number of subscribers of e.
+func (e *events) count() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.subscribers)
+}
+
+func TestEvents(t *testing.T) {
+	var ce CtrlEngine
+	setAuthSecret("secret")
+	defer setAuthSecret("")
+	srv := httptest.NewServer(&authHandler{handler: newEventsHandler(&ce)})
+	defer srv.Close()
+	// unauthenticated subscription fails
+	if _, err := dialEvents(srv, "wrong"); err == nil {
+		t.Fatal("dialEvents should fail with wrong token")
+	}
+	// subscribe
+	ws, err := dialEvents(srv, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for i := 0; ce.events.count() == 0; i++ {
+		if i == 100 {
+			t.Fatal("no subscriber")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// simulate new message
+	ce.events.emit(&Event{
+		Type:  EventNewMessage,
+		MyID:  "alice@mute.berlin",
+		Peer:  "bob@mute.berlin",
+		MsgID: 42,
+	})
+	var ev Event
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := websocket.JSON.Receive(ws, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != EventNewMessage || ev.MyID != "alice@mute.berlin" ||
+		ev.Peer != "bob@mute.berlin" || ev.MsgID != 42 || ev.Date == 0 {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	// closing the connection removes the subscription
+	ws.Close()
+	for i := 0; ce.events.count() != 0; i++ {
+		if i == 100 {
+			t.Fatal("subscription not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
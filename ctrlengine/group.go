@@ -0,0 +1,67 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/uid/identity"
+)
+
+func (ce *CtrlEngine) groupAdd(id, group, contact string) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	contactMapped, err := identity.Map(contact)
+	if err != nil {
+		return err
+	}
+	// only white listed contacts can become group members
+	unmappedID, _, contactType, err := ce.msgDB.GetContact(idMapped,
+		contactMapped)
+	if err != nil {
+		return err
+	}
+	if unmappedID == "" || contactType != msgdb.WhiteList {
+		return log.Errorf("contact %s not found (for user ID %s)", contact, id)
+	}
+	return ce.msgDB.AddGroupMember(idMapped, group, contactMapped)
+}
+
+func (ce *CtrlEngine) groupRemove(id, group, contact string) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	contactMapped, err := identity.Map(contact)
+	if err != nil {
+		return err
+	}
+	return ce.msgDB.RemoveGroupMember(idMapped, group, contactMapped)
+}
+
+func (ce *CtrlEngine) groupList(w io.Writer, id, group string) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	var list []string
+	if group != "" {
+		list, err = ce.msgDB.GetGroupMembers(idMapped, group)
+	} else {
+		list, err = ce.msgDB.GetGroups(idMapped)
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range list {
+		fmt.Fprintln(w, entry)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/mutecomm/mute/msgdb"
+)
+
+func TestGroup(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	a := "alice@mute.berlin"
+	if err := te.ce.msgDB.AddNym(a, a, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"bob@mute.berlin", "carol@mute.berlin", "dave@mute.berlin"} {
+		err := te.ce.msgDB.AddContact(a, c, c, "", msgdb.WhiteList)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// manage group membership
+	for _, line := range []string{
+		"msg group add --id " + a + " --group friends --contact bob@mute.berlin",
+		"msg group add --id " + a + " --group friends --contact carol@mute.berlin",
+		"msg group add --id " + a + " --group friends --contact carol@mute.berlin",
+		"msg group add --id " + a + " --group others --contact dave@mute.berlin",
+	} {
+		if err := te.run(line, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := te.run("msg group list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "friends\nothers\n" {
+		t.Errorf("msg group list: unexpected output: %q", out)
+	}
+	if err := te.run("msg group list --id "+a+" --group friends", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "bob@mute.berlin\ncarol@mute.berlin\n" {
+		t.Errorf("msg group list --group: unexpected output: %q", out)
+	}
+	// fan out message to group
+	file := filepath.Join(te.homedir, "msg.txt")
+	if err := ioutil.WriteFile(file, []byte("hello group\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := te.run("msg add --from "+a+" --group friends --file "+file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgIDs, err := te.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var to, bodies []string
+	for _, msgID := range msgIDs {
+		to = append(to, msgID.To)
+		body, err := te.ce.msgDB.GetMessageGroupBody(a, msgID.MsgID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, body)
+	}
+	sort.Strings(to)
+	if len(to) != 2 || to[0] != "bob@mute.berlin" || to[1] != "carol@mute.berlin" {
+		t.Errorf("message should be added for group members only: %v", to)
+	}
+	if len(bodies) != 2 || bodies[0] == "" || bodies[0] != bodies[1] {
+		t.Error("group body should be encrypted only once for all members")
+	}
+	// remove member
+	err = te.run("msg group remove --id "+a+" --group friends --contact bob@mute.berlin", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("msg group list --id "+a+" --group friends", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "carol@mute.berlin\n" {
+		t.Errorf("msg group list --group: unexpected output: %q", out)
+	}
+	// non-contacts cannot be added
+	err = te.run("msg group add --id "+a+" --group friends --contact eve@mute.berlin", 0)
+	if err == nil {
+		t.Error("adding unknown contact to group should fail")
+	}
+}
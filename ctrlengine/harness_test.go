@@ -0,0 +1,232 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"crypto/ed25519"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/configclient"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/release"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// testEngine is a test harness which drives a CtrlEngine against a temporary
+// home directory and captures the output and status file descriptors.
+type testEngine struct {
+	t          *testing.T
+	ce         *CtrlEngine
+	homedir    string
+	passphrase []byte
+	offline    bool
+	outputFile string
+	statusFile string
+	outputFD   int
+	statusFD   int
+	passFD     int      // read end of passphrase pipe
+	passW      *os.File // write end of passphrase pipe
+	commandFD  int      // unused command file descriptor
+	outputOff  int
+	statusOff  int
+}
+
+// newTestEngine creates a new CtrlEngine with a temporary home directory.
+// Output and status file descriptors are redirected to temporary files.
+func newTestEngine(t *testing.T) *testEngine {
+	tmpdir, err := ioutil.TempDir("", "ctrlengine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	te := &testEngine{
+		t:          t,
+		ce:         New(),
+		homedir:    tmpdir,
+		passphrase: []byte(cipher.RandPass(cipher.RandReader)),
+		outputFile: filepath.Join(tmpdir, "output"),
+		statusFile: filepath.Join(tmpdir, "status"),
+	}
+	te.outputFD = te.openFD(te.outputFile)
+	te.statusFD = te.openFD(te.statusFile)
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	te.passFD = fds[0]
+	te.passW = os.NewFile(uintptr(fds[1]), "passphrase")
+	te.commandFD = te.openFD(os.DevNull)
+	return te
+}
+
+// openFD opens filename for writing and returns the raw file descriptor, which
+// is handed over to the CtrlEngine.
+func (te *testEngine) openFD(filename string) int {
+	fd, err := syscall.Open(filename,
+		syscall.O_WRONLY|syscall.O_CREAT|syscall.O_APPEND, 0600)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	return fd
+}
+
+// close closes the CtrlEngine and removes the temporary home directory.
+func (te *testEngine) close() {
+	te.ce.Close()
+	te.passW.Close()
+	os.RemoveAll(te.homedir)
+}
+
+// seedDBs creates the message DB without contacting the configuration server
+// and stores a test configuration in it. Afterwards, commands which do not
+// require network access can be run in --offline mode.
+func (te *testEngine) seedDBs() {
+	msgdbname := filepath.Join(te.homedir, "msgs")
+	if err := msgdb.Create(msgdbname, te.passphrase, 4096); err != nil {
+		te.t.Fatal(err)
+	}
+	msgDB, err := msgdb.Open(msgdbname, te.passphrase)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	defer msgDB.Close()
+	// store test configuration
+	jsn, err := json.Marshal(testConfig(te.t))
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	netDomain, _, _ := def.ConfigParams()
+	if err := msgDB.AddValue(netDomain, string(jsn)); err != nil {
+		te.t.Fatal(err)
+	}
+	err = msgDB.AddValue("time."+netDomain, strconv.FormatInt(times.Now(), 10))
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	// store wallet key
+	_, privateKey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	if err := msgDB.AddValue(msgdb.WalletKey, base64.Encode(privateKey[:])); err != nil {
+		te.t.Fatal(err)
+	}
+	te.offline = true
+}
+
+// testConfig returns a configuration which is sufficient to initialize Mute
+// without contacting any server.
+func testConfig(t *testing.T) *configclient.Config {
+	pubKey, _, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := hex.EncodeToString(pubKey[:])
+	return &configclient.Config{
+		Map: map[string]string{
+			"mixclient.MixAddress":    "mix@mute.berlin",
+			"mixclient.AccountServer": "accounts.mute.berlin",
+			"mixclient.Sender":        "sender@mute.berlin",
+			"walletrpc.ServiceURL":    "https://wallet.mute.berlin",
+			"keylookup.ServiceURL":    "https://keylookup.mute.berlin",
+			"guardrpc.ServiceURL":     "https://guard.mute.berlin",
+			"serviceguard.TrustRoot":  key,
+			"mix.MaxDelay":            "300",
+			"muteaccd.owner":          key,
+			"muteaccd.usage":          "Account",
+			"release.Commit":          release.Commit,
+			"release.Date":            release.Date,
+		},
+	}
+}
+
+// fdArgs returns the global file descriptor options for the CtrlEngine.
+// They have to be set for every run, otherwise the CtrlEngine would take
+// ownership of the default descriptors 3 and 4, which might be in use by the
+// Go runtime.
+func (te *testEngine) fdArgs() []string {
+	return []string{
+		"--output-fd", strconv.Itoa(te.outputFD),
+		"--status-fd", strconv.Itoa(te.statusFD),
+		"--passphrase-fd", strconv.Itoa(te.passFD),
+		"--command-fd", strconv.Itoa(te.commandFD),
+	}
+}
+
+// args writes the given number of passphrases to the passphrase pipe and
+// returns the arguments of the command line.
+func (te *testEngine) args(line string, passphrases int) []string {
+	for i := 0; i < passphrases; i++ {
+		if _, err := te.passW.Write(append(te.passphrase, '\n')); err != nil {
+			te.t.Fatal(err)
+		}
+	}
+	args := []string{"mutectrl",
+		"--homedir", te.homedir,
+		"--logdir", filepath.Join(te.homedir, "log"),
+	}
+	args = append(args, te.fdArgs()...)
+	if te.offline {
+		args = append(args, "--offline")
+	}
+	return append(args, strings.Fields(line)...)
+}
+
+// run executes the given command line with the CtrlEngine. The global
+// options (like --homedir) are set automatically, the passphrase is written
+// passphrases many times to --passphrase-fd beforehand.
+func (te *testEngine) run(line string, passphrases int) error {
+	args := te.args(line, passphrases)
+	te.ce.app.Name = args[0]
+	if err := te.ce.app.Run(args); err != nil {
+		return err
+	}
+	err := te.ce.err
+	te.ce.err = nil
+	return err
+}
+
+// start executes the command line like run, but with Start (which returns
+// typed errors).
+func (te *testEngine) start(line string, passphrases int) error {
+	err := te.ce.Start(te.args(line, passphrases))
+	te.ce.err = nil
+	return err
+}
+
+// read returns the content of filename which has been written since the last
+// call to read with the same offset.
+func (te *testEngine) read(filename string, offset *int) string {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	s := string(buf[*offset:])
+	*offset = len(buf)
+	return s
+}
+
+// output returns everything written to the output file descriptor since the
+// last call.
+func (te *testEngine) output() string {
+	return te.read(te.outputFile, &te.outputOff)
+}
+
+// status returns everything written to the status file descriptor since the
+// last call.
+func (te *testEngine) status() string {
+	return te.read(te.statusFile, &te.statusOff)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/util"
+)
+
+// lockFilename is the name of the lock file in the home directory, which
+// prevents concurrent mutectrl processes on the same home directory.
+const lockFilename = "mutectrl.lock"
+
+// netstatsPrefix is the prefix of the files in the home directory, which
+// accumulate the network usage of a session (see dialer.SetStatsFile).
+const netstatsPrefix = "netstats."
+
+// removeNetstats removes all netstats files from homedir. Since the lock file
+// prevents concurrent mutectrl processes, the netstats files present when the
+// lock has been acquired were left behind by crashed sessions.
+func removeNetstats(homedir string) error {
+	files, err := filepath.Glob(filepath.Join(homedir, netstatsPrefix+"*"))
+	if err != nil {
+		return log.Error(err)
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return log.Error(err)
+		}
+	}
+	return nil
+}
+
+// hasMsgDB returns true, if the directory dir contains a message DB.
+func hasMsgDB(dir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(dir, "msgs.db"))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, log.Error(err)
+}
+
+// checkLegacyHome checks if the legacy home directory contains databases
+// while homedir doesn't. In that case the legacy home directory is moved to
+// homedir, if migrate is true. Otherwise, an error is returned which tells the
+// user to migrate explicitly with --migrate-home.
+func (ce *CtrlEngine) checkLegacyHome(homedir string, migrate bool) error {
+	legacy := ce.legacyHomeDir
+	if legacy == "" || filepath.Clean(legacy) == filepath.Clean(homedir) {
+		return nil
+	}
+	legacyData, err := hasMsgDB(legacy)
+	if err != nil {
+		return err
+	}
+	if !legacyData {
+		return nil
+	}
+	data, err := hasMsgDB(homedir)
+	if err != nil {
+		return err
+	}
+	if data {
+		if migrate {
+			return log.Errorf("ctrlengine: cannot migrate '%s', '%s' contains data already",
+				legacy, homedir)
+		}
+		return nil
+	}
+	if !migrate {
+		return log.Errorf("ctrlengine: found databases in legacy home directory '%s', use --migrate-home to move them to '%s'",
+			legacy, homedir)
+	}
+	return util.MoveDir(legacy, homedir)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testMigrateHome(t *testing.T, migrateArgs ...string) {
+	te := newTestEngine(t)
+	defer te.close()
+	// move seeded DBs to legacy home directory
+	te.seedDBs()
+	legacy, err := ioutil.TempDir("", "ctrlengine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(legacy)
+	for _, name := range []string{"msgs.db", "msgs.key"} {
+		err := os.Rename(filepath.Join(te.homedir, name), filepath.Join(legacy, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	te.ce.legacyHomeDir = legacy
+	// migrate to new home directory
+	homedir := filepath.Join(te.homedir, "xdg", "mute")
+	args := []string{"mutectrl",
+		"--homedir", homedir,
+		"--logdir", filepath.Join(te.homedir, "log"),
+	}
+	args = append(args, migrateArgs...)
+	args = append(args, te.fdArgs()...)
+	err = te.ce.Start(append(args, "quit"))
+	if err != errExit {
+		t.Fatalf("errExit expected, got: %v", err)
+	}
+	for _, name := range []string{"msgs.db", "msgs.key"} {
+		if _, err := os.Stat(filepath.Join(homedir, name)); err != nil {
+			t.Errorf("%s not migrated: %s", name, err)
+		}
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("legacy home directory still exists")
+	}
+}
+
+func TestMigrateHome(t *testing.T) {
+	testMigrateHome(t, "--migrate-home")
+}
+
+func TestMigrateHomeEnv(t *testing.T) {
+	defer os.Unsetenv("MUTE_MIGRATE_HOME")
+	if err := os.Setenv("MUTE_MIGRATE_HOME", "true"); err != nil {
+		t.Fatal(err)
+	}
+	testMigrateHome(t)
+}
+
+func TestMigrateHomeRefuse(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	legacy, err := ioutil.TempDir("", "ctrlengine_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(legacy)
+	data := []byte("legacy")
+	if err := ioutil.WriteFile(filepath.Join(legacy, "msgs.db"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	te.ce.legacyHomeDir = legacy
+	// homedir contains data already -> refuse
+	err = te.ce.checkLegacyHome(te.homedir, true)
+	if err == nil || !strings.Contains(err.Error(), "contains data already") {
+		t.Fatalf("migration should be refused, got: %v", err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(legacy, "msgs.db"))
+	if err != nil || string(buf) != string(data) {
+		t.Error("legacy data modified")
+	}
+	// new homedir without --migrate-home -> detect legacy data
+	err = te.ce.checkLegacyHome(filepath.Join(te.homedir, "new"), false)
+	if err == nil || !strings.Contains(err.Error(), "--migrate-home") {
+		t.Fatalf("legacy data should be detected, got: %v", err)
+	}
+}
+
+func TestHomeLock(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	// second engine on the same home directory
+	te2 := newTestEngine(t)
+	defer te2.close()
+	defer os.RemoveAll(te2.homedir) // holds the output files of te2
+	te2.homedir = te.homedir
+	te2.passphrase = te.passphrase
+	te2.offline = true
+	err := te2.run("uid list", 0)
+	if err == nil {
+		t.Fatal("second engine should not acquire the lock")
+	}
+	if !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("error should name the holding process: %s", err)
+	}
+	// lock is released on close
+	te.ce.Close()
+	if err := te2.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,904 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/cipher/aes256"
+	"github.com/mutecomm/mute/cryptengine"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keydb"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/lan"
+	"github.com/mutecomm/mute/mix/client"
+	"github.com/mutecomm/mute/mix/mixaddr"
+	"github.com/mutecomm/mute/mix/mixcrypt"
+	groupMsg "github.com/mutecomm/mute/msg/group"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/protoengine"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util"
+	"github.com/mutecomm/mute/util/dialer"
+	"github.com/mutecomm/mute/util/jsonclient"
+	"github.com/mutecomm/mute/util/testutil"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// fakeMixEnv is the environment variable which tells muteproto (run by
+// TestMain) the address of the fake mix started by fakeMix.
+const fakeMixEnv = "MUTE_TEST_MIX"
+
+// TestMain allows the test binary to act as mutecrypt and muteproto. The
+// CtrlEngine calls them as separate binaries, installEngines puts links to
+// the test binary with these names in the PATH. This way the integration
+// tests run all engines without having to install them first.
+func TestMain(m *testing.M) {
+	var err error
+	switch filepath.Base(os.Args[0]) {
+	case "mutecrypt":
+		ce := cryptengine.New()
+		err = ce.Start(os.Args)
+		ce.Close()
+	case "muteproto":
+		if addr := os.Getenv(fakeMixEnv); addr != "" {
+			// send account server requests to the fake mix
+			client.DefaultClientFactory = func(URL string, cacert []byte) (*jsonclient.URLClient, error) {
+				u, err := url.Parse(URL)
+				if err != nil {
+					return nil, err
+				}
+				u.Host = addr
+				return jsonclient.New(u.String(), cacert)
+			}
+		}
+		err = protoengine.New().Run(os.Args)
+	default:
+		os.Exit(m.Run())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// installEngines makes the test binary available as mutecrypt and muteproto
+// in the PATH and returns a function which restores the original PATH.
+func installEngines(t *testing.T) func() {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindir, err := ioutil.TempDir("", "ctrlengine_bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, engine := range []string{"mutecrypt", "muteproto"} {
+		if err := os.Symlink(exe, filepath.Join(bindir, engine)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bindir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(bindir)
+	}
+}
+
+// mutecrypt runs mutecrypt with the given arguments on the home directory of
+// te, the passphrase is given on stdin (repeated passphrases many times).
+func (te *testEngine) mutecrypt(passphrases int, args ...string) {
+	cmd := exec.Command("mutecrypt", append([]string{
+		"--homedir", te.homedir,
+		"--logdir", filepath.Join(te.homedir, "log"),
+		"--passphrase-fd", "stdin",
+	}, args...)...)
+	var errbuf bytes.Buffer
+	cmd.Stdin = bytes.NewBufferString(strings.Repeat(string(te.passphrase)+"\n",
+		passphrases))
+	cmd.Stderr = &errbuf
+	if err := cmd.Run(); err != nil {
+		te.t.Fatalf("mutecrypt %s: %s: %s", strings.Join(args, " "), err,
+			strings.TrimSpace(errbuf.String()))
+	}
+}
+
+// seedKeyDB writes a test configuration to file (mutecrypt reads it from
+// there) and creates the KeyDB of te with mutecrypt (see installEngines).
+func (te *testEngine) seedKeyDB() {
+	jsn, err := json.Marshal(testConfig(te.t))
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	netDomain, _, _ := def.ConfigParams()
+	if err := writeConfigFile(te.homedir, netDomain, jsn); err != nil {
+		te.t.Fatal(err)
+	}
+	te.mutecrypt(2, "db", "create", "--iterations", "4096")
+}
+
+// openKeyDB opens the KeyDB of te.
+func (te *testEngine) openKeyDB() *keydb.KeyDB {
+	keyDB, err := keydb.Open(filepath.Join(te.homedir, "keys"), te.passphrase)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	return keyDB
+}
+
+// registeredUID is a UID (together with a KeyInit) as it is published by
+// the key server.
+type registeredUID struct {
+	msg *uid.Message
+	ki  *uid.KeyInit
+}
+
+// registerUID creates a new UID for id (with a KeyInit message) in the KeyDB
+// of te and returns the published parts. This takes the place of
+// `mutecrypt uid generate`, `uid register`, and `keyinit add`, which require a
+// key server.
+func (te *testEngine) registerUID(id string, cacert []byte) *registeredUID {
+	_, domain, err := identity.Split(id)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	uidMsg, err := uid.Create(id, false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	// account at the mix (the nym address in the KeyInit points to it)
+	pubkey, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	var pub [ed25519.PublicKeySize]byte
+	copy(pub[:], pubkey)
+	var priv [ed25519.PrivateKeySize]byte
+	copy(priv[:], privkey)
+	var secret [64]byte
+	if _, err := cipher.RandReader.Read(secret[:]); err != nil {
+		te.t.Fatal(err)
+	}
+	server := "mix.mute.berlin"
+	mixAddress, nymAddress, err := util.NewNymAddress(domain, secret[:],
+		times.ThirtyDaysLater(), false, 0, 0, id, &pub, server, cacert)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	ki, pubKeyHash, privateKey, err := uidMsg.KeyInit(1, now+times.Day,
+		now-times.Day, false, domain, mixAddress, nymAddress, cipher.RandReader)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	keyDB := te.openKeyDB()
+	defer keyDB.Close()
+	if err := keyDB.AddHashChainEntry(domain, 0, hashchain.TestEntry); err != nil {
+		te.t.Fatal(err)
+	}
+	if err := keyDB.AddPrivateUID(uidMsg); err != nil {
+		te.t.Fatal(err)
+	}
+	err = keyDB.AddPrivateKeyInit(ki, pubKeyHash, uidMsg.SigPubKey(),
+		privateKey, "")
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	// add nym and account to message DB
+	msgDB := te.openMsgDB()
+	defer msgDB.Close()
+	if err := msgDB.AddNym(id, id, ""); err != nil {
+		te.t.Fatal(err)
+	}
+	err = msgDB.AddAccount(id, "", &priv, server, &secret, 0, 0)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	return &registeredUID{msg: uidMsg, ki: ki}
+}
+
+// lookupUID adds the published UID of contact to the KeyDB (together with a
+// hash chain entry for it) and the contact list of id. This takes the place of
+// `mutectrl contact add`, which looks up the UID at the key server.
+func (te *testEngine) lookupUID(id string, contact *registeredUID) {
+	keyDB := te.openKeyDB()
+	defer keyDB.Close()
+	_, domain, err := identity.Split(contact.msg.Identity())
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	lastPos, found, err := keyDB.GetLastHashChainPos(domain)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	if !found {
+		te.t.Fatalf("no hash chain for domain %s", domain)
+	}
+	lastEntry, err := keyDB.GetHashChainEntry(domain, lastPos)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	pos := lastPos + 1
+	entry := hashChainEntry(te.t, contact.msg, lastEntry)
+	if err := keyDB.AddHashChainEntry(domain, pos, entry); err != nil {
+		te.t.Fatal(err)
+	}
+	if err := keyDB.AddPublicUID(contact.msg, pos); err != nil {
+		te.t.Fatal(err)
+	}
+	if err := keyDB.AddPublicKeyInit(contact.ki); err != nil {
+		te.t.Fatal(err)
+	}
+	msgDB := te.openMsgDB()
+	defer msgDB.Close()
+	contactID := contact.msg.Identity()
+	err = msgDB.AddContact(id, contactID, contactID, "", msgdb.WhiteList)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+}
+
+// hashChainEntry returns the key hash chain entry which follows lastEntry and
+// commits to the UID message msg, see doc/keyserver.md#key-hashchain-operation
+// (and testutil.KeyServer).
+func hashChainEntry(t *testing.T, msg *uid.Message, lastEntry string) string {
+	lastHash, _, _, _, _, _, err := hashchain.SplitEntry(lastEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := msg.Identity()
+	UIDHash, UIDIndex, _ := msg.Encrypt()
+	nonce := make([]byte, 8)
+	if _, err := cipher.RandReader.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	k1, k2 := cipher.CKDF(nonce)
+	hashID := cipher.SHA256(append(append([]byte{}, k1...), id...))
+	idKey := cipher.SHA256(append(append([]byte{}, k2...), id...))
+	crUID := aes256.CBCEncrypt(idKey, UIDHash, cipher.RandReader)
+	var entry []byte
+	entry = append(entry, hashchain.Type...)
+	entry = append(entry, nonce...)
+	entry = append(entry, hashID...)
+	entry = append(entry, crUID...)
+	entry = append(entry, UIDIndex...)
+	hash := cipher.SHA256(append(append([]byte{}, entry...), lastHash...))
+	return base64.Encode(append(hash, entry...))
+}
+
+// openMsgDB opens the MsgDB of te.
+func (te *testEngine) openMsgDB() *msgdb.MsgDB {
+	msgDB, err := msgdb.Open(filepath.Join(te.homedir, "msgs"), te.passphrase)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	return msgDB
+}
+
+// newIntegrationEngine returns a new test engine with a message DB and a
+// KeyDB (created by mutecrypt) which has a registered UID for id. The
+// configuration trusts the given CA certificate (see fakeMix).
+func newIntegrationEngine(
+	t *testing.T,
+	id string,
+	cacert []byte,
+) (*testEngine, *registeredUID) {
+	te := newTestEngine(t)
+	te.seedDBs()
+	config := testConfig(t)
+	config.CACert = cacert
+	jsn, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netDomain, _, _ := def.ConfigParams()
+	msgDB := te.openMsgDB()
+	err = msgDB.AddValue(netDomain, string(jsn))
+	msgDB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mutecrypt reads the configuration from file
+	if err := writeConfigFile(te.homedir, netDomain, jsn); err != nil {
+		t.Fatal(err)
+	}
+	te.mutecrypt(2, "db", "create", "--iterations", "4096")
+	return te, te.registerUID(id, cacert)
+}
+
+// fakeMix starts a fake mix (see testutil.NewMix) which is used instead of
+// the mix found via the MX record of util.MixAddress and the account server of
+// the nym addresses. It returns the CA certificate of the fake mix and a
+// function which stops it.
+func fakeMix(t *testing.T) ([]byte, func()) {
+	m, err := testutil.NewMix("mix@mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	getMixAddress := client.GetMixAddress
+	client.GetMixAddress = func(string) (string, error) {
+		return m.Addr(), nil
+	}
+	mixAddress := util.MixAddress
+	util.MixAddress = "mix@mute.berlin"
+	os.Setenv(fakeMixEnv, m.Addr())
+	return testutil.CACert(), func() {
+		os.Unsetenv(fakeMixEnv)
+		util.MixAddress = mixAddress
+		client.GetMixAddress = getMixAddress
+		m.Close()
+	}
+}
+
+// loopbackMix creates a mailbox directory for the loopback transport (see
+// client.Loopback) and makes the nym addresses of registered UIDs point to
+// the loopback mix. It returns the mailbox directory and a function which
+// removes it again.
+func loopbackMix(t *testing.T) (string, func()) {
+	mailbox, err := ioutil.TempDir("", "ctrlengine_mailbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := &client.Loopback{Dir: mailbox}
+	getMixKeys := client.GetMixKeys
+	client.GetMixKeys = func(mixaddress string, cacert []byte) (*mixaddr.AddressStatement, error) {
+		return lb.MixKeys(mixaddress)
+	}
+	mixAddress := util.MixAddress
+	util.MixAddress = "mix@mute.berlin"
+	return mailbox, func() {
+		// set by the CtrlEngines for muteproto
+		os.Unsetenv("MUTE_MAILBOX")
+		os.Unsetenv("MUTE_TRANSPORT")
+		util.MixAddress = mixAddress
+		client.GetMixKeys = getMixKeys
+		os.RemoveAll(mailbox)
+	}
+}
+
+// receiver accepts messages sent directly to a peer in the local network (see
+// `mutectrl lan send`) and hands them over to the given function.
+func receiver(t *testing.T, uid string, handle func(msg []byte)) func() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, p, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rconn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := lan.NewResponder(rconn)
+	responder.Announce(uid, uint16(port))
+	go responder.Serve()
+	go lan.Serve(l, time.Second, handle)
+	multicastAddr := lan.MulticastAddr
+	lan.MulticastAddr = rconn.LocalAddr().(*net.UDPAddr)
+	return func() {
+		lan.MulticastAddr = multicastAddr
+		rconn.Close()
+		l.Close()
+	}
+}
+
+// TestIntegrationMessage sends a message from Alice to Bob with mutectrl and
+// mutecrypt (and muteproto to check the mix account) and makes sure Bob can
+// read it.
+func TestIntegrationMessage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	cacert, stop := fakeMix(t)
+	defer stop()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	alice, aliceUID := newIntegrationEngine(t, a, cacert)
+	defer alice.close()
+	bob, bobUID := newIntegrationEngine(t, b, cacert)
+	defer bob.close()
+	// the network usage of this process ends up in the stats file of the
+	// session, which is removed on close (otherwise it leaks into other tests)
+	defer dialer.FlushStats()
+	alice.lookupUID(a, bobUID)
+	bob.lookupUID(b, aliceUID)
+
+	// Alice writes a message to Bob
+	plaintext := "Hello Bob, this is Alice."
+	file := filepath.Join(alice.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte(plaintext), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.run("msg add --from "+a+" --to "+b+" --file "+file, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice encrypts and sends it, Bob receives it
+	received := make(chan []byte, 1)
+	defer receiver(t, b, func(msg []byte) { received <- msg })()
+	if err := alice.run("lan send --id "+a+" --timeout 500ms", 0); err != nil {
+		t.Fatal(err)
+	}
+	var enc []byte
+	select {
+	case enc = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+	if bytes.Contains(enc, []byte(plaintext)) {
+		t.Fatal("message not encrypted")
+	}
+
+	// Bob decrypts and reads it (the first command opens the message DB, the
+	// received message is put into the inqueue like `lan announce` does). A
+	// bogus message in front of it is dropped and doesn't block the inqueue.
+	if err := bob.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.ce.msgDB.AddInQueueMessage(b, times.Now(), "bogus"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.ce.msgDB.AddInQueueMessage(b, times.Now(), string(enc)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.run("msg fetch --id "+b, 0); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := bob.ce.msgDB.GetMsgIDs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("Bob has %d messages, should have 1", len(ids))
+	}
+	if ids[0].From != a {
+		t.Errorf("message from %s, should be from %s", ids[0].From, a)
+	}
+	// the dropped message and the key of Alice are recorded in the audit log
+	entries, err := bob.ce.auditLog.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed, seen bool
+	for _, e := range entries {
+		switch {
+		case e.Type == AuditDecryptFailed && e.MyID == b && e.Detail != "":
+			failed = true
+		case e.Type == AuditKeySeen && e.MyID == b && e.Peer == a &&
+			e.Fingerprint != "":
+			seen = true
+		}
+	}
+	if !failed || !seen {
+		t.Errorf("audit log misses fetch events: %+v", entries)
+	}
+	cmd := fmt.Sprintf("msg read --id %s --msgnum %d", b, ids[0].MsgID)
+	if err := bob.run(cmd, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := bob.output(); !strings.Contains(out, plaintext) {
+		t.Errorf("Bob read %q, should contain %q", out, plaintext)
+	}
+}
+
+// TestIntegrationGroup sends a group message from Alice to Bob and Carol via
+// the local network and makes sure that both members decrypt the same body,
+// which is encrypted only once, while the non-member Dave cannot decrypt it.
+func TestIntegrationGroup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	cacert, stop := fakeMix(t)
+	defer stop()
+	defer dialer.FlushStats()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	c := "carol@mute.berlin"
+	d := "dave@mute.berlin"
+	alice, aliceUID := newIntegrationEngine(t, a, cacert)
+	defer alice.close()
+	engines := make(map[string]*testEngine)
+	for _, id := range []string{b, c, d} {
+		te, registered := newIntegrationEngine(t, id, cacert)
+		defer te.close()
+		te.lookupUID(id, aliceUID)
+		if id != d {
+			alice.lookupUID(a, registered)
+		}
+		engines[id] = te
+	}
+
+	// Alice writes a message to the group of Bob and Carol
+	plaintext := "Hello friends, this is Alice."
+	file := filepath.Join(alice.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte(plaintext), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{b, c} {
+		line := "msg group add --id " + a + " --group friends --contact " + id
+		if err := alice.run(line, 1-i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := alice.run("msg add --from "+a+" --group friends --file "+file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice encrypts and sends it to every member
+	encs := make(map[string]string)
+	for _, id := range []string{b, c} {
+		received := make(chan []byte, 1)
+		stop := receiver(t, id, func(msg []byte) { received <- msg })
+		err := alice.run("lan send --id "+a+" --timeout 500ms", 0)
+		stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case enc := <-received:
+			encs[id] = string(enc)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message for %s not received", id)
+		}
+	}
+	gmB := groupMsg.Parse(encs[b])
+	gmC := groupMsg.Parse(encs[c])
+	if gmB == nil || gmC == nil {
+		t.Fatal("group message expected")
+	}
+	if gmB.CIPHERTEXT != gmC.CIPHERTEXT {
+		t.Error("body should be encrypted only once")
+	}
+	if gmB.SEALEDKEY == gmC.SEALEDKEY {
+		t.Error("group key should be sealed to every member individually")
+	}
+
+	// all members decrypt the same body, the non-member Dave cannot (he gets
+	// the message sent to Bob)
+	encs[d] = encs[b]
+	for _, id := range []string{b, c, d} {
+		te := engines[id]
+		if err := te.run("uid list", 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := te.ce.msgDB.AddInQueueMessage(id, times.Now(), encs[id]); err != nil {
+			t.Fatal(err)
+		}
+		if err := te.run("msg fetch --id "+id, 0); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := te.ce.msgDB.GetMsgIDs(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == d {
+			if len(ids) != 0 {
+				t.Errorf("non-member %s decrypted group message", id)
+			}
+			continue
+		}
+		if len(ids) != 1 {
+			t.Fatalf("%s has %d messages, should have 1", id, len(ids))
+		}
+		if ids[0].From != a {
+			t.Errorf("message from %s, should be from %s", ids[0].From, a)
+		}
+		cmd := fmt.Sprintf("msg read --id %s --msgnum %d", id, ids[0].MsgID)
+		if err := te.run(cmd, 0); err != nil {
+			t.Fatal(err)
+		}
+		if out := te.output(); !strings.Contains(out, plaintext) {
+			t.Errorf("%s read %q, should contain %q", id, out, plaintext)
+		}
+	}
+}
+
+// TestIntegrationLoopback sends a message from Alice to Bob over the loopback
+// transport (local mailboxes instead of the mix) and makes sure Bob can fetch
+// and read it.
+func TestIntegrationLoopback(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	mailbox, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	alice, aliceUID := newIntegrationEngine(t, a, nil)
+	defer alice.close()
+	bob, bobUID := newIntegrationEngine(t, b, nil)
+	defer bob.close()
+	alice.lookupUID(a, bobUID)
+	bob.lookupUID(b, aliceUID)
+	loopback := "--transport loopback --mailbox " + mailbox + " "
+
+	// Alice writes a message to Bob and sends it
+	plaintext := "Hello Bob, this is Alice (via loopback)."
+	file := filepath.Join(alice.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte(plaintext), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := alice.run(loopback+"msg add --from "+a+" --to "+b+" --file "+file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.run(loopback+"msg send --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob fetches and reads it
+	if err := bob.run(loopback+"msg fetch --id "+b, 1); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := bob.ce.msgDB.GetMsgIDs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("Bob has %d messages, should have 1", len(ids))
+	}
+	cmd := fmt.Sprintf("msg read --id %s --msgnum %d", b, ids[0].MsgID)
+	if err := bob.run(loopback+cmd, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := bob.output(); !strings.Contains(out, plaintext) {
+		t.Errorf("Bob read %q, should contain %q", out, plaintext)
+	}
+
+	// Bob looks at the details of Alice
+	if err := bob.run(loopback+"contact show --id "+b+" --contact "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := aliceUID.msg.SigKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bob.output()
+	for _, line := range []string{
+		"VERIFIED:\ttrue\n",
+		"FINGERPRINT:\t" + fingerprint + "\n",
+		"RECEIVED:\t1\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("contact show does not contain %q: %s", line, out)
+		}
+	}
+}
+
+// TestIntegrationPing sends a ping from Alice to Bob over the loopback
+// transport and makes sure that Bob answers it with a pong, without showing
+// the ping in his inbox.
+func TestIntegrationPing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	mailbox, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	alice, aliceUID := newIntegrationEngine(t, a, nil)
+	defer alice.close()
+	bob, bobUID := newIntegrationEngine(t, b, nil)
+	defer bob.close()
+	alice.lookupUID(a, bobUID)
+	bob.lookupUID(b, aliceUID)
+	loopback := "--transport loopback --mailbox " + mailbox + " "
+
+	// Alice pings Bob
+	err := alice.run(loopback+"ping --id "+a+" --contact "+b+" --mindelay 1 "+
+		"--maxdelay 2 --nodelaycheck", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := alice.output(); out != "ping sent to "+b+" (mix delay 1-2s)\n" {
+		t.Errorf("ping output: %q", out)
+	}
+
+	// Bob fetches the ping, which is not shown in his inbox, and answers it
+	if err := bob.run(loopback+"msg fetch --id "+b, 1); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := bob.ce.msgDB.GetMsgIDs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("Bob has %d messages, ping should not be shown", len(ids))
+	}
+
+	// Alice receives the pong
+	if err := alice.run(loopback+"msg fetch --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	if status := alice.status(); !strings.Contains(status, "pong from "+b) {
+		t.Errorf("Alice did not receive pong: %q", status)
+	}
+	if len(alice.ce.pongs) != 1 {
+		t.Error("round-trip time of pong not recorded")
+	}
+	ids, err = alice.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("Alice has %d messages, pong should not be shown", len(ids))
+	}
+}
+
+func TestIntegrationMultiHost(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	mailbox, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	alice, aliceUID := newIntegrationEngine(t, a, nil)
+	defer alice.close()
+	bob, bobUID := newIntegrationEngine(t, b, nil)
+	defer bob.close()
+	alice.lookupUID(a, bobUID)
+	bob.lookupUID(b, aliceUID)
+	loopback := "--transport loopback --mailbox " + mailbox + " "
+
+	// Alice sends a message to Bob
+	file := filepath.Join(alice.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte("Hello Bob!"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := alice.run(loopback+"msg add --from "+a+" --to "+b+" --file "+file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.run(loopback+"msg send --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob has a second account on another host which got the same message
+	msgDB := bob.openMsgDB()
+	privkey, _, secret, _, _, _, err := msgDB.GetAccount(b, "")
+	if err != nil {
+		msgDB.Close()
+		t.Fatal(err)
+	}
+	host2 := "mix2.mute.berlin"
+	err = msgDB.AddAccount(b, a, privkey, host2, secret, 0, 0)
+	msgDB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes, err := filepath.Glob(filepath.Join(mailbox, "mailbox", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boxes) != 1 {
+		t.Fatalf("got %d mailboxes, want 1", len(boxes))
+	}
+	msgs, err := ioutil.ReadDir(boxes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages in mailbox, want 1", len(msgs))
+	}
+	box2 := filepath.Join(mailbox, "mailbox",
+		strings.SplitAfter(filepath.Base(boxes[0]), "@")[0]+
+			host2[:len(host2)-len(mixcrypt.MuteSystemDomain)]+
+			mixcrypt.MuteSystemDomain)
+	if err := os.MkdirAll(box2, 0700); err != nil {
+		t.Fatal(err)
+	}
+	relay, err := ioutil.ReadFile(filepath.Join(boxes[0], msgs[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(box2, msgs[0].Name()), relay, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob fetches from both hosts, but stores the message only once
+	if err := bob.run(loopback+"msg fetch --id "+b, 1); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := bob.ce.msgDB.GetMsgIDs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Errorf("Bob has %d messages, should have 1", len(ids))
+	}
+}
+
+func TestIntegrationUIDStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	_, stop := loopbackMix(t)
+	defer stop()
+
+	// alice is in both DBs, registered, active, and has a KeyInit
+	a := "alice@mute.berlin"
+	te, aliceUID := newIntegrationEngine(t, a, nil)
+	defer te.close()
+	srvKey, err := cipher.Ed25519Generate(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := uid.CreateReply("", "", 0, srvKey)
+	keyDB := te.openKeyDB()
+	err = keyDB.AddPrivateUIDReply(aliceUID.msg, reply)
+	keyDB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// carol has only been generated in the keyDB
+	te.mutecrypt(1, "uid", "generate", "--id", "carol@mute.berlin")
+	// dave is only known to the msgDB
+	msgDB := te.openMsgDB()
+	if err := msgDB.AddValue(msgdb.ActiveUID, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddNym("dave@mute.berlin", "Dave@mute.berlin", ""); err != nil {
+		t.Fatal(err)
+	}
+	msgDB.Close()
+
+	if err := te.run("uid status", 1); err != nil {
+		t.Fatal(err)
+	}
+	exp := "alice@mute.berlin\tgenerated,registered,msgdb,active,keyinit(1)\n" +
+		"carol@mute.berlin\tgenerated\n" +
+		"Dave@mute.berlin\tmsgdb\n"
+	if out := te.output(); out != exp {
+		t.Errorf("uid status:\n%s\nshould be:\n%s", out, exp)
+	}
+	if err := te.run("uid status --json", 0); err != nil {
+		t.Fatal(err)
+	}
+	var states []uidState
+	if err := json.Unmarshal([]byte(te.output()), &states); err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 3 || states[2].ID != "Dave@mute.berlin" ||
+		states[2].Generated || !states[2].MsgDB {
+		t.Errorf("wrong JSON states: %+v", states)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mutecomm/mute/lan"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util/dialer"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/mutecomm/mute/util/times"
+	"github.com/urfave/cli"
+)
+
+// errLANProxy is returned if the local network is used while a proxy is set.
+var errLANProxy = errors.New("ctrlengine: direct messaging in local network not possible with --proxy")
+
+// checkLANProxy makes sure that no proxy is set, because direct connections
+// in the local network would bypass it.
+func checkLANProxy() error {
+	if dialer.Proxy() != "" {
+		return log.Error(errLANProxy)
+	}
+	return nil
+}
+
+// lanAnnounce announces id in the local network and receives messages sent
+// directly to it on the given port, until an error occurs.
+func (ce *CtrlEngine) lanAnnounce(
+	c *cli.Context,
+	statusfp io.Writer,
+	id string,
+	port uint16,
+	timeout time.Duration,
+) error {
+	if err := checkLANProxy(); err != nil {
+		return err
+	}
+	nyms, err := ce.getNyms(id, false)
+	if err != nil {
+		return err
+	}
+	idMapped := nyms[0]
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(port))))
+	if err != nil {
+		return log.Error(err)
+	}
+	defer l.Close()
+	_, p, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return log.Error(err)
+	}
+	listenPort, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return log.Error(err)
+	}
+	conn, err := lan.ListenMulticast()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	responder := lan.NewResponder(conn)
+	responder.Announce(idMapped, uint16(listenPort))
+	i18n.Fprintf(statusfp, "announcing %s on port %d\n", id, listenPort)
+
+	// receive messages
+	var mutex sync.Mutex
+	ch := make(chan error, 2)
+	go func() {
+		ch <- lan.Serve(l, timeout, func(msg []byte) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			err := ce.msgDB.AddInQueueMessage(idMapped, times.Now(), string(msg))
+			if err != nil {
+				log.Errorf("ctrlengine: cannot add message to inqueue: %s", err)
+				return
+			}
+			if err := ce.procInQueue(c, ""); err != nil {
+				log.Errorf("ctrlengine: cannot process inqueue: %s", err)
+			}
+		})
+	}()
+	// answer discovery queries
+	go func() {
+		ch <- responder.Serve()
+	}()
+	return <-ch
+}
+
+// lanDiscover writes the peers discovered in the local network within timeout
+// to w.
+func (ce *CtrlEngine) lanDiscover(w io.Writer, timeout time.Duration) error {
+	if err := checkLANProxy(); err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return log.Error(err)
+	}
+	defer conn.Close()
+	peers, err := lan.Resolve(conn, lan.MulticastAddr, timeout)
+	if err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		fmt.Fprintf(w, "%s\t%s\n", peer.UID, peer.Addr)
+	}
+	return nil
+}
+
+// lanSend sends all undelivered messages of id to contacts discovered in the
+// local network directly (without using the mix network). The messages are
+// end-to-end encrypted exactly like messages sent via the mix network.
+func (ce *CtrlEngine) lanSend(
+	c *cli.Context,
+	statusfp io.Writer,
+	id string,
+	timeout time.Duration,
+) error {
+	if err := checkLANProxy(); err != nil {
+		return err
+	}
+	nyms, err := ce.getNyms(id, false)
+	if err != nil {
+		return err
+	}
+	nym := nyms[0]
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return log.Error(err)
+	}
+	defer conn.Close()
+	peers, err := lan.Resolve(conn, lan.MulticastAddr, timeout)
+	if err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		if peer.UID == nym || identity.IsMapped(peer.UID) != nil {
+			continue
+		}
+		for {
+			msgNum, msg, sign, err := ce.msgDB.GetUndeliveredMessageTo(nym,
+				peer.UID)
+			if err != nil {
+				return err
+			}
+			if msgNum == 0 {
+				break // no more undelivered messages for peer
+			}
+			// determine recipient nymaddress for encryption
+			recvNymAddress, err := ce.recvNymAddress(nym, peer.UID)
+			if err != nil {
+				return err
+			}
+			enc, _, err := ce.encryptMsg(c, nym, peer.UID, msgNum, msg,
+				sign, recvNymAddress)
+			if err != nil {
+				return err
+			}
+			if err := lan.Send(peer.Addr, []byte(enc), timeout); err != nil {
+				return err
+			}
+			if err := ce.msgDB.SetMessageSent(msgNum, times.Now()); err != nil {
+				return err
+			}
+			i18n.Fprintf(statusfp, "message %d sent to %s (%s)\n", msgNum,
+				peer.UID, peer.Addr)
+			ce.events.emit(&Event{
+				Type:   EventSendStatus,
+				MyID:   nym,
+				Peer:   peer.UID,
+				MsgID:  msgNum,
+				Status: SendStatusSent,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/util/i18n"
+)
+
+// loglevelSet changes the logging level of the running CtrlEngine.
+func (ce *CtrlEngine) loglevelSet(statusfp io.Writer, level string) error {
+	if err := log.SetLevel(level); err != nil {
+		return log.Error(err)
+	}
+	i18n.Fprintf(statusfp, "log level set to '%s'\n", level)
+	return nil
+}
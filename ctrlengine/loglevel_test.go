@@ -0,0 +1,139 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/log"
+)
+
+func TestLoglevelSet(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	if err := te.run("loglevel set --level debug", 0); err != nil {
+		t.Fatal(err)
+	}
+	if log.Level() != "debug" {
+		t.Errorf("log.Level() = %s, expected debug", log.Level())
+	}
+	if !strings.Contains(te.status(), "log level set to 'debug'") {
+		t.Error("loglevel set status missing")
+	}
+	if err := te.run("loglevel set --level invalid", 0); err == nil {
+		t.Error("loglevel set --level invalid should fail")
+	}
+	if err := te.run("loglevel set", 0); err == nil {
+		t.Error("loglevel set without --level should fail")
+	}
+}
+
+func TestLoglevelEnv(t *testing.T) {
+	defer os.Unsetenv("MUTE_LOGLEVEL")
+	if err := os.Setenv("MUTE_LOGLEVEL", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	te := newTestEngine(t)
+	defer te.close()
+	if err := te.run("config show", 0); err != nil {
+		t.Fatal(err)
+	}
+	if log.Level() != "debug" {
+		t.Errorf("log.Level() = %s, expected debug", log.Level())
+	}
+	// explicit flag takes precedence
+	te2 := newTestEngine(t)
+	defer te2.close()
+	if err := te2.run("--loglevel warn config show", 0); err != nil {
+		t.Fatal(err)
+	}
+	if log.Level() != "warn" {
+		t.Errorf("log.Level() = %s, expected warn", log.Level())
+	}
+}
+
+func TestTraceFile(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	traceFile := filepath.Join(te.homedir, "trace")
+	if err := te.run("--trace-file "+traceFile+" uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), "prepare(openMsgDB=true)") {
+		t.Error("trace file doesn't contain log of command")
+	}
+	// trace is stopped after command
+	if err := te.run("uid list", 0); err != nil {
+		t.Fatal(err)
+	}
+	buf2, err := ioutil.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf2) != len(buf) {
+		t.Error("trace file written after command")
+	}
+}
+
+func TestTraceFileEnv(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	traceFile := filepath.Join(te.homedir, "trace")
+	defer os.Unsetenv("MUTE_TRACE_FILE")
+	if err := os.Setenv("MUTE_TRACE_FILE", traceFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), "prepare(openMsgDB=true)") {
+		t.Error("trace file doesn't contain log of command")
+	}
+}
+
+func TestPrivateLogs(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	defer os.Unsetenv("MUTE_PRIVATE_LOGS")
+	defer log.SetPrivate(false)
+	te.seedDBs()
+	identity := "bob@mute.berlin"
+	err := te.run("--private-logs uid edit --id "+identity+" --full-name Bob", 1)
+	if err == nil || !strings.Contains(err.Error(), identity) {
+		t.Fatalf("unmasked error for unknown user ID expected, got: %v", err)
+	}
+	log.Flush()
+	files, err := filepath.Glob(filepath.Join(te.homedir, "log", "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("one logfile expected, found %d", len(files))
+	}
+	buf, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), identity) {
+		t.Error("identity not masked in log")
+	}
+	if !strings.Contains(string(buf), log.MaskIdentity(identity)) {
+		t.Error("masked identity missing in log")
+	}
+}
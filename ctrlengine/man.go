@@ -0,0 +1,92 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/urfave/cli"
+)
+
+// roffEscape escapes s for use in roff text.
+func roffEscape(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	s = strings.Replace(s, "-", `\-`, -1)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeManFlags writes the given flags as roff tagged paragraphs.
+func writeManFlags(w io.Writer, flags []cli.Flag) {
+	for _, flag := range flags {
+		// flag.String() has the format "--name value\tusage"
+		parts := strings.SplitN(flag.String(), "\t", 2)
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %s\n", roffEscape(parts[0]))
+		if len(parts) == 2 {
+			fmt.Fprintln(w, roffEscape(parts[1]))
+		}
+	}
+}
+
+// writeManCommands writes the given commands (and their subcommands
+// recursively) as roff subsections.
+func writeManCommands(w io.Writer, commands []cli.Command, prefix string) {
+	for _, cmd := range commands {
+		if cmd.Hidden {
+			continue
+		}
+		name := prefix + cmd.Name
+		fmt.Fprintf(w, ".SS \"%s\"\n", roffEscape(name))
+		fmt.Fprintln(w, roffEscape(cmd.Usage))
+		writeManFlags(w, cmd.Flags)
+		writeManCommands(w, cmd.Subcommands, name+" ")
+	}
+}
+
+// manPage returns the roff man page of app.
+func manPage(app *cli.App) []byte {
+	var b bytes.Buffer
+	name := roffEscape(filepath.Base(app.Name))
+	fmt.Fprintf(&b, ".TH %s 1 \"\" \"%s %s\"\n", strings.ToUpper(name), name,
+		roffEscape(app.Version))
+	fmt.Fprintln(&b, ".SH NAME")
+	fmt.Fprintf(&b, "%s \\- %s\n", name, roffEscape(app.Usage))
+	fmt.Fprintln(&b, ".SH SYNOPSIS")
+	fmt.Fprintf(&b, ".B %s\n", name)
+	fmt.Fprintln(&b, "[global options] command [command options] [arguments...]")
+	fmt.Fprintln(&b, ".SH GLOBAL OPTIONS")
+	writeManFlags(&b, app.Flags)
+	fmt.Fprintln(&b, ".SH COMMANDS")
+	writeManCommands(&b, app.Commands, "")
+	return b.Bytes()
+}
+
+// man writes the man page of the CtrlEngine to w or, if outdir is given, to
+// the file NAME.1 in outdir.
+func (ce *CtrlEngine) man(w io.Writer, outdir string) error {
+	page := manPage(ce.app)
+	if outdir == "" {
+		_, err := w.Write(page)
+		return err
+	}
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		return log.Error(err)
+	}
+	filename := filepath.Join(outdir, filepath.Base(ce.app.Name)+".1")
+	if err := ioutil.WriteFile(filename, page, 0644); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
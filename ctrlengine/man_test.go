@@ -0,0 +1,42 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMan(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	if err := te.run("man", 0); err != nil {
+		t.Fatal(err)
+	}
+	out := te.output()
+	if !strings.HasPrefix(out, ".TH MUTECTRL 1") {
+		t.Errorf("man page has wrong header: %s", out)
+	}
+	if !strings.Contains(out, ".SS \"msg queue\"\nlist queued messages which have not been sent yet\n") {
+		t.Error("man page does not contain usage of `msg queue`")
+	}
+	if !strings.Contains(out, ".B \\-\\-homedir value\n") {
+		t.Error("man page does not contain global option --homedir")
+	}
+	// write to directory
+	outdir := filepath.Join(te.homedir, "man")
+	if err := te.run("man --out "+outdir, 0); err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(outdir, "mutectrl.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(page) != out {
+		t.Error("man pages on output-fd and in directory differ")
+	}
+}
@@ -7,6 +7,7 @@ package ctrlengine
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,22 +17,22 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"crypto/ed25519"
+	"unicode/utf8"
 
 	"github.com/mutecomm/mute/cipher"
 	"github.com/mutecomm/mute/ctrlengine/mail"
-	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/mix/mixcrypt"
 	"github.com/mutecomm/mute/mix/nymaddr"
 	"github.com/mutecomm/mute/msg"
+	groupMsg "github.com/mutecomm/mute/msg/group"
 	mimeMsg "github.com/mutecomm/mute/msg/mime"
 	"github.com/mutecomm/mute/msgdb"
 	"github.com/mutecomm/mute/serviceguard/client"
 	"github.com/mutecomm/mute/uid/identity"
 	"github.com/mutecomm/mute/util"
+	"github.com/mutecomm/mute/util/i18n"
 	"github.com/mutecomm/mute/util/times"
 	"github.com/mutecomm/mute/util/wallet"
 	"github.com/peterh/liner"
@@ -51,15 +52,12 @@ func mutecryptEncrypt(
 	if err := identity.IsMapped(to); err != nil {
 		return "", "", log.Error(err)
 	}
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
+	args := mutecryptArgs(c,
 		"encrypt",
 		"--from", from,
 		"--to", to,
 		"--nymaddress", nymAddress,
-	}
+	)
 	if sign {
 		args = append(args, "--sign")
 	}
@@ -103,12 +101,31 @@ func mutecryptEncrypt(
 	return
 }
 
+// checkMsgLength reports the length of the message content (including option
+// lines) on statusfp and checks that it is not longer than
+// msg.MaxContentLength. The limit is measured in bytes, but the length is
+// reported in characters (runes) as well, because multibyte characters (like
+// emoji) take up to four bytes each.
+func checkMsgLength(statusfp io.Writer, content []byte) error {
+	runes := utf8.RuneCount(content)
+	if over := len(content) - msg.MaxContentLength; over > 0 {
+		return log.Errorf("message too long: %d characters (%d bytes) "+
+			"is %d bytes over the %d-byte limit", runes, len(content), over,
+			msg.MaxContentLength)
+	}
+	i18n.Fprintf(statusfp, "message length: %d characters (%d of %d bytes)\n",
+		runes, len(content), msg.MaxContentLength)
+	return nil
+}
+
 func (ce *CtrlEngine) msgAdd(
 	c *cli.Context,
-	from, to, file string,
+	from, to, group, file string,
 	mailInput, permanentSignature bool,
 	attachments []string,
 	minDelay, maxDelay int32,
+	priority msgdb.Priority,
+	opts *mimeMsg.Options,
 	line *liner.State,
 	r io.Reader,
 ) error {
@@ -134,7 +151,7 @@ func (ce *CtrlEngine) msgAdd(
 		}
 	} else if line != nil {
 		// read message from terminal
-		fmt.Fprintln(ce.fileTable.StatusFP,
+		i18n.Fprintln(ce.fileTable.StatusFP,
 			"type message (end with Ctrl-D on empty line):")
 		var inbuf bytes.Buffer
 		for {
@@ -165,29 +182,74 @@ func (ce *CtrlEngine) msgAdd(
 		msg = []byte(message)
 	}
 
-	toMapped, err := identity.Map(to)
-	if err != nil {
+	// add options like time to live (encrypted for recipient)
+	msg = []byte(mimeMsg.AddOptions(string(msg), opts))
+	if err := checkMsgLength(ce.fileTable.StatusFP, msg); err != nil {
 		return err
 	}
-	prev, _, contactType, err := ce.msgDB.GetContact(fromMapped, toMapped)
-	if err != nil {
-		return err
+
+	// determine recipients
+	var recipients []string
+	if group != "" {
+		// the signature would only cover the sealed group key
+		if permanentSignature {
+			return log.Error("permanent signatures are not supported for group messages")
+		}
+		recipients, err = ce.msgDB.GetGroupMembers(fromMapped, group)
+		if err != nil {
+			return err
+		}
+		if len(recipients) == 0 {
+			return log.Errorf("group %s has no members (for user ID %s)",
+				group, from)
+		}
+	} else {
+		toMapped, err := identity.Map(to)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, toMapped)
 	}
-	if prev == "" || contactType == msgdb.GrayList || contactType == msgdb.BlackList {
-		return log.Errorf("contact %s not found (for user ID %s)", to, from)
+	for _, toMapped := range recipients {
+		prev, _, contactType, err := ce.msgDB.GetContact(fromMapped, toMapped)
+		if err != nil {
+			return err
+		}
+		if prev == "" || contactType == msgdb.GrayList || contactType == msgdb.BlackList {
+			return log.Errorf("contact %s not found (for user ID %s)",
+				toMapped, from)
+		}
 	}
 
-	// store message in message DB
+	// store message in message DB (once per recipient)
 	now := times.Now()
-	err = ce.msgDB.AddMessage(fromMapped, toMapped, now, true, string(msg),
-		permanentSignature, minDelay, maxDelay)
-	if err != nil {
-		return err
+	if group != "" {
+		// the body of a group message is encrypted only once, msgSend seals
+		// the group key to every member (see encryptMsg)
+		body, err := groupMsg.Seal(group, msg, cipher.RandReader)
+		if err != nil {
+			return err
+		}
+		for _, toMapped := range recipients {
+			err = ce.msgDB.AddGroupMessage(fromMapped, toMapped, now,
+				string(msg), string(body.JSON()), minDelay, maxDelay, priority)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, toMapped := range recipients {
+			err = ce.msgDB.AddMessage(fromMapped, toMapped, now, true,
+				string(msg), permanentSignature, minDelay, maxDelay, priority)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	log.Info("message added")
 	if line != nil {
-		fmt.Fprintln(ce.fileTable.StatusFP, "message added")
+		i18n.Fprintln(ce.fileTable.StatusFP, "message added")
 	}
 
 	return nil
@@ -275,6 +337,7 @@ func (ce *CtrlEngine) procOutQueue(
 	c *cli.Context,
 	nym string,
 	failDelivery bool,
+	cover *coverTraffic,
 ) error {
 	log.Debug("procOutQueue()")
 	for {
@@ -287,76 +350,171 @@ func (ce *CtrlEngine) procOutQueue(
 			log.Debug("break")
 			break // no more messages in outqueue
 		}
-		if !envelope {
-			log.Debug("envelope")
-			// parse nymaddress
-			na, err := base64.Decode(nymaddress)
-			if err != nil {
-				return log.Error(na)
-			}
-			addr, err := nymaddr.ParseAddress(na)
-			if err != nil {
-				return err
-			}
-			// get token from wallet
-			var pubkey [32]byte
-			copy(pubkey[:], addr.TokenPubKey)
-			token, err := wallet.GetToken(ce.client, "Message", &pubkey)
-			if err != nil {
-				return err
-			}
-			// `muteproto create`
-			env, err := muteprotoCreate(c, msg, minDelay, maxDelay,
-				base64.Encode(token.Token), nymaddress)
-			if err != nil {
-				return log.Error(err)
-			}
-			// update outqueue
-			if err := ce.msgDB.SetOutQueue(oqIdx, env); err != nil {
-				ce.client.UnlockToken(token.Hash)
-				return err
-			}
-			ce.client.DelToken(token.Hash)
-			msg = env
-		}
-		// `muteproto deliver`
-		if failDelivery {
-			return log.Error(ErrDeliveryFailed)
-		}
-		sendTime := times.Now() + int64(minDelay) // earliest
-		resend, err := muteprotoDeliver(c, msg)
-		if err != nil {
-			// If the message delivery failed because the token expired in the
-			// meantime we retract the message from the outqueue (setting it
-			// back to 'ToSend') and start the delivery process for this
-			// message all over again.
-			// Matching the error message string is not optimal, but the best
-			// available solution since the error results from calling another
-			// binary (muteproto).
-			if strings.HasSuffix(err.Error(), client.ErrFinal.Error()) {
-				log.Debug("retract")
-				if err := ce.msgDB.RetractOutQueue(oqIdx); err != nil {
-					return err
-				}
-				continue
-			}
-			return log.Error(err)
+		_, err = ce.procOutQueueEntry(c, nym, oqIdx, msg, nymaddress,
+			minDelay, maxDelay, envelope, failDelivery, cover)
+		if err != nil {
+			return err
 		}
-		if resend {
-			// set resend status
-			log.Debug("resend")
-			if err := ce.msgDB.SetResendOutQueue(oqIdx); err != nil {
-				return err
-			}
+	}
+	return nil
+}
+
+// procOutQueueEntry puts the message of the outqueue entry oqIdx into an
+// envelope (if necessary) and delivers it. It returns the resulting send
+// status (SendStatusSent, ...).
+func (ce *CtrlEngine) procOutQueueEntry(
+	c *cli.Context,
+	nym string,
+	oqIdx int64,
+	msg, nymaddress string,
+	minDelay, maxDelay int32,
+	envelope bool,
+	failDelivery bool,
+	cover *coverTraffic,
+) (status string, err error) {
+	if !envelope {
+		log.Debug("envelope")
+		token, err := ce.messageToken(nymaddress)
+		if err != nil {
+			return "", err
+		}
+		// `muteproto create`
+		env, err := muteprotoCreate(c, msg, minDelay, maxDelay,
+			base64.Encode(token.Token), nymaddress)
+		if err != nil {
+			return "", log.Error(err)
+		}
+		// update outqueue
+		if err := ce.msgDB.SetOutQueue(oqIdx, env); err != nil {
+			ce.releaseToken(token, false)
+			return "", err
+		}
+		ce.releaseToken(token, true)
+		msg = env
+	}
+	// `muteproto deliver` (interspersed with decoys, if enabled)
+	if failDelivery {
+		return "", log.Error(ErrDeliveryFailed)
+	}
+	slots, err := cover.schedule()
+	if err != nil {
+		return "", err
+	}
+	for _, slot := range slots {
+		time.Sleep(slot.wait)
+		if slot.decoy {
+			err = ce.sendDecoy(c, nym, minDelay, maxDelay)
 		} else {
-			// remove from outqueue
-			log.Debug("remove")
-			if err := ce.msgDB.RemoveOutQueue(oqIdx, sendTime); err != nil {
-				return err
+			status, err = ce.deliverOutQueue(c, nym, oqIdx, msg, minDelay)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return status, nil
+}
+
+// messageToken returns a token from the wallet to pay the mix for a message
+// to nymaddress. The loopback transport requires no payment, the returned
+// token is empty in this case.
+func (ce *CtrlEngine) messageToken(nymaddress string) (*client.TokenEntry, error) {
+	if ce.loopback != nil {
+		return &client.TokenEntry{}, nil
+	}
+	// parse nymaddress
+	na, err := base64.Decode(nymaddress)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	addr, err := nymaddr.ParseAddress(na)
+	if err != nil {
+		return nil, err
+	}
+	// get token from wallet
+	var pubkey [32]byte
+	copy(pubkey[:], addr.TokenPubKey)
+	return wallet.GetToken(ce.client, "Message", &pubkey)
+}
+
+// releaseToken releases a token returned by messageToken. The token is
+// deleted from the wallet, if it was spent, or unlocked otherwise.
+func (ce *CtrlEngine) releaseToken(token *client.TokenEntry, spent bool) {
+	if ce.loopback != nil {
+		return
+	}
+	if spent {
+		ce.client.DelToken(token.Hash)
+		ce.checkLowBalance(ce.fileTable.StatusFP, "Message")
+	} else {
+		ce.client.UnlockToken(token.Hash)
+	}
+}
+
+// deliverOutQueue delivers the envelope msg of the outqueue entry oqIdx and
+// returns the resulting send status (SendStatusSent, ...).
+func (ce *CtrlEngine) deliverOutQueue(
+	c *cli.Context,
+	nym string,
+	oqIdx int64,
+	msg string,
+	minDelay int32,
+) (string, error) {
+	sendTime := times.Now() + int64(minDelay) // earliest
+	msgID, err := ce.msgDB.GetOutQueueMsgID(oqIdx)
+	if err != nil {
+		return "", err
+	}
+	resend, err := ce.deliver(c, msg)
+	if err != nil {
+		// If the message delivery failed because the token expired in the
+		// meantime we retract the message from the outqueue (setting it
+		// back to 'ToSend') and start the delivery process for this
+		// message all over again.
+		// Matching the error message string is not optimal, but the best
+		// available solution since the error results from calling another
+		// binary (muteproto).
+		if strings.HasSuffix(err.Error(), client.ErrFinal.Error()) {
+			log.Debug("retract")
+			if err := ce.msgDB.RetractOutQueue(oqIdx); err != nil {
+				return "", err
 			}
+			ce.events.emit(&Event{
+				Type:   EventSendStatus,
+				MyID:   nym,
+				MsgID:  msgID,
+				Status: SendStatusRetract,
+			})
+			return SendStatusRetract, nil
 		}
+		return "", log.Error(err)
 	}
-	return nil
+	if resend {
+		// set resend status
+		log.Debug("resend")
+		if err := ce.msgDB.SetResendOutQueue(oqIdx); err != nil {
+			return "", err
+		}
+		ce.events.emit(&Event{
+			Type:   EventSendStatus,
+			MyID:   nym,
+			MsgID:  msgID,
+			Status: SendStatusResend,
+		})
+		return SendStatusResend, nil
+	}
+	// remove from outqueue
+	log.Debug("remove")
+	if err := ce.msgDB.RemoveOutQueue(oqIdx, sendTime); err != nil {
+		return "", err
+	}
+	ce.audit(&AuditEntry{Type: AuditDelivered, MyID: nym})
+	ce.events.emit(&Event{
+		Type:   EventSendStatus,
+		MyID:   nym,
+		MsgID:  msgID,
+		Status: SendStatusSent,
+	})
+	return SendStatusSent, nil
 }
 
 func (ce *CtrlEngine) getNyms(id string, all bool) ([]string, error) {
@@ -384,11 +542,87 @@ func (ce *CtrlEngine) getNyms(id string, all bool) ([]string, error) {
 	return nyms, nil
 }
 
+// recvNymAddressMinLife is the minimum remaining lifetime (in seconds) of a
+// nym address which is reused to receive replies (see recvNymAddress).
+const recvNymAddressMinLife = 7 * int64(times.Day)
+
+// recvNymAddress returns a nymaddress to receive replies from peer for nym.
+// The current nym address given to peer is reused, unless it expires within
+// recvNymAddressMinLife or has been retired. New nym addresses are stored in
+// the msgDB, so they are retired by `nym rotate`.
+func (ce *CtrlEngine) recvNymAddress(nym, peer string) (string, error) {
+	nymAddress, err := ce.msgDB.GetPeerNymAddress(nym, peer,
+		times.Now()+recvNymAddressMinLife)
+	if err != nil {
+		return "", err
+	}
+	if nymAddress != "" {
+		return nymAddress, nil
+	}
+	expire := times.ThirtyDaysLater() // TODO: make this settable
+	singleUse := false                // TODO correct?
+	mixAddress, nymAddress, receiverKey, err := ce.newNymAddress(nym, expire,
+		singleUse)
+	if err != nil {
+		return "", err
+	}
+	err = ce.msgDB.AddPeerNymAddress(nym, peer, mixAddress, nymAddress,
+		receiverKey, expire)
+	if err != nil {
+		return "", err
+	}
+	return nymAddress, nil
+}
+
+// encryptMsg encrypts the message msgNum from nym to peer with mutecrypt.
+// The body of a group message has been encrypted once for all members
+// already (see msgAdd), only the key message of the group message body is
+// encrypted for peer in this case.
+func (ce *CtrlEngine) encryptMsg(
+	c *cli.Context,
+	nym, peer string,
+	msgNum int64,
+	msg []byte,
+	sign bool,
+	recvNymAddress string,
+) (enc, nymaddress string, err error) {
+	groupBody, err := ce.msgDB.GetMessageGroupBody(nym, msgNum)
+	if err != nil {
+		return "", "", err
+	}
+	if groupBody == "" {
+		enc, nymaddress, err = mutecryptEncrypt(c, nym, peer, ce.passphrase,
+			msg, sign, recvNymAddress)
+		if err != nil {
+			return "", "", log.Error(err)
+		}
+		return enc, nymaddress, nil
+	}
+	body, err := groupMsg.NewBodyJSON(groupBody)
+	if err != nil {
+		return "", "", err
+	}
+	keyMsg, err := body.KeyMessage()
+	if err != nil {
+		return "", "", err
+	}
+	sealedKey, nymaddress, err := mutecryptEncrypt(c, nym, peer,
+		ce.passphrase, keyMsg, false, recvNymAddress)
+	if err != nil {
+		return "", "", log.Error(err)
+	}
+	enc = base64.Encode(body.Message(sealedKey).JSON())
+	return enc, nymaddress, nil
+}
+
+// msgSend sends all undelivered messages of id (or all user IDs). If cover is
+// not nil, the messages are sent interspersed with decoys at random times.
 func (ce *CtrlEngine) msgSend(
 	c *cli.Context,
 	id string,
 	all bool,
 	failDelivery bool,
+	cover *coverTraffic,
 ) error {
 	nyms, err := ce.getNyms(id, all)
 	if err != nil {
@@ -401,7 +635,7 @@ func (ce *CtrlEngine) msgSend(
 		}
 
 		// process old messages in outqueue
-		if err := ce.procOutQueue(c, nym, failDelivery); err != nil {
+		if err := ce.procOutQueue(c, nym, failDelivery, cover); err != nil {
 			return err
 		}
 
@@ -416,7 +650,6 @@ func (ce *CtrlEngine) msgSend(
 		*/
 
 		// add all undelivered messages to outqueue
-		var recvNymAddress string
 		for {
 			msgID, peer, msg, sign, minDelay, maxDelay, err :=
 				ce.msgDB.GetUndeliveredMessage(nym)
@@ -428,35 +661,17 @@ func (ce *CtrlEngine) msgSend(
 				break // no more undelivered messages
 			}
 
-			// determine recipient nymaddress for encryption, if necessary
-			if recvNymAddress == "" {
-				// TODO! (implement more accounts? delay settings?)
-				privkey, server, secret, minDelay, maxDelay, _, err :=
-					ce.msgDB.GetAccount(nym, "")
-				if err != nil {
-					return err
-				}
-				_, domain, err := identity.Split(nym)
-				if err != nil {
-					return err
-				}
-				expire := times.ThirtyDaysLater() // TODO: make this settable
-				singleUse := false                // TODO correct?
-				var pubkey [ed25519.PublicKeySize]byte
-				copy(pubkey[:], privkey[32:])
-				_, recvNymAddress, err = util.NewNymAddress(domain, secret[:],
-					expire, singleUse, minDelay, maxDelay, nym, &pubkey, server,
-					def.CACert)
-				if err != nil {
-					return err
-				}
+			// determine recipient nymaddress for encryption
+			recvNymAddress, err := ce.recvNymAddress(nym, peer)
+			if err != nil {
+				return err
 			}
 
 			// encrypt
-			enc, nymaddress, err := mutecryptEncrypt(c, nym, peer,
-				ce.passphrase, msg, sign, recvNymAddress)
+			enc, nymaddress, err := ce.encryptMsg(c, nym, peer, msgID, msg,
+				sign, recvNymAddress)
 			if err != nil {
-				return log.Error(err)
+				return err
 			}
 			// add to outqueue
 			log.Debug("add")
@@ -468,13 +683,151 @@ func (ce *CtrlEngine) msgSend(
 		}
 
 		// process new messages in outqueue
-		if err := ce.procOutQueue(c, nym, failDelivery); err != nil {
+		if err := ce.procOutQueue(c, nym, failDelivery, cover); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// msgResend tries again to deliver the messages of id whose delivery failed
+// (all of them, if msgNum is 0) and reports the outcome per message on w.
+// Retracted messages are encrypted again by the next msg send.
+func (ce *CtrlEngine) msgResend(
+	c *cli.Context,
+	w io.Writer,
+	id string,
+	msgNum int64,
+) error {
+	nyms, err := ce.getNyms(id, false)
+	if err != nil {
+		return err
+	}
+	nym := nyms[0]
+	entries, err := ce.msgDB.GetResendOutQueue(nym)
+	if err != nil {
+		return err
+	}
+	var tried, failed int
+	for _, entry := range entries {
+		if msgNum != 0 && entry.MsgID != msgNum {
+			continue
+		}
+		tried++
+		status, err := ce.procOutQueueEntry(c, nym, entry.OQIdx, entry.Msg,
+			entry.NymAddress, entry.MinDelay, entry.MaxDelay, entry.Envelope,
+			false, nil)
+		if err != nil {
+			fmt.Fprintf(w, "message %d: %s\n", entry.MsgID, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "message %d: %s\n", entry.MsgID, status)
+		if status == SendStatusResend {
+			failed++
+		}
+	}
+	if tried == 0 {
+		if msgNum != 0 {
+			return log.Errorf("ctrlengine: message %d has no failed delivery",
+				msgNum)
+		}
+		fmt.Fprintln(w, "no failed messages")
+		return nil
+	}
+	if failed > 0 {
+		return log.Errorf("ctrlengine: %d of %d message(s) could not be resent",
+			failed, tried)
+	}
+	return nil
+}
+
+// autoResend resends the messages of nym whose delivery failed and whose
+// automatic resend is due at time now. The resends follow the exponential
+// backoff schedule of policy, a message whose delivery failed
+// policy.attempts many times is marked as permanently failed.
+func (ce *CtrlEngine) autoResend(
+	c *cli.Context,
+	nym string,
+	policy *resendPolicy,
+	now int64,
+) error {
+	entries, err := ce.msgDB.GetResendOutQueue(nym)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Failed {
+			continue // only resent manually (see msg resend)
+		}
+		if entry.Retry == 0 {
+			// delivery failed since last run -> schedule resend
+			err := ce.scheduleResend(nym, entry.OQIdx, entry.MsgID,
+				entry.Attempts, policy, now)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.Retry > now {
+			continue // not due yet
+		}
+		log.Infof("ctrlengine: resend message %d (attempt %d)", entry.MsgID,
+			entry.Attempts+1)
+		status, err := ce.procOutQueueEntry(c, nym, entry.OQIdx, entry.Msg,
+			entry.NymAddress, entry.MinDelay, entry.MaxDelay, entry.Envelope,
+			false, nil)
+		if err != nil {
+			// count as failed delivery attempt
+			log.Warnf("ctrlengine: resend of message %d failed: %s",
+				entry.MsgID, err)
+			if err := ce.msgDB.SetResendOutQueue(entry.OQIdx); err != nil {
+				return err
+			}
+			status = SendStatusResend
+		}
+		if status == SendStatusResend {
+			err := ce.scheduleResend(nym, entry.OQIdx, entry.MsgID,
+				entry.Attempts+1, policy, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scheduleResend schedules the automatic resend of the outqueue entry oqIdx
+// (for message msgID), whose delivery failed attempts many times. If the
+// maximum number of attempts is reached, the delivery is marked as
+// permanently failed instead.
+func (ce *CtrlEngine) scheduleResend(
+	nym string,
+	oqIdx, msgID int64,
+	attempts int,
+	policy *resendPolicy,
+	now int64,
+) error {
+	if attempts >= policy.attempts {
+		if err := ce.msgDB.SetFailedOutQueue(oqIdx); err != nil {
+			return err
+		}
+		log.Warnf("ctrlengine: delivery of message %d failed permanently "+
+			"after %d attempt(s)", msgID, attempts)
+		ce.events.emit(&Event{
+			Type:   EventSendStatus,
+			MyID:   nym,
+			MsgID:  msgID,
+			Status: SendStatusFailed,
+		})
+		return nil
+	}
+	retry := now + int64(policy.delay(attempts)/time.Second)
+	log.Infof("ctrlengine: resend message %d at %s", msgID,
+		time.Unix(retry, 0).Format(time.RFC3339))
+	return ce.msgDB.SetRetryOutQueue(oqIdx, retry)
+}
+
 func muteprotoFetch(
 	myID, contactID string,
 	msgDB *msgdb.MsgDB,
@@ -630,10 +983,16 @@ func muteprotoFetch(
 
 		stop <- length
 		<-done
-		err = msgDB.AddInQueue(myID, contactID, receiveTime, outbuf.String())
+		// the same message might have been fetched from another host already
+		hash := base64.Encode(cipher.SHA256(outbuf.Bytes()))
+		known, err := msgDB.AddReceivedInQueue(myID, contactID, hash,
+			receiveTime, outbuf.String())
 		if err != nil {
 			return 0, err
 		}
+		if known {
+			log.Infof("ctrlengine: discard duplicate message %s", messageID)
+		}
 		if firstMessage {
 			newMessageTime = receiveTime
 			firstMessage = false
@@ -646,21 +1005,44 @@ func muteprotoFetch(
 	return
 }
 
-func mutecryptDecrypt(
+// decryptError is the error of a message mutecrypt rejected, such a message
+// has to be dropped (see mutecryptDecryptBatch).
+type decryptError struct {
+	reason string
+}
+
+func (e *decryptError) Error() string {
+	return "ctrlengine: cannot decrypt message: " + e.reason
+}
+
+// decryptResult is the result of a single message decryption of
+// mutecryptDecryptBatch. If mutecrypt rejected the message err is a
+// *decryptError.
+type decryptResult struct {
+	senderID  string
+	message   string
+	signature string
+	err       error
+}
+
+// mutecryptDecryptBatch decrypts the messages encs with a single call of
+// mutecrypt, which verifies their signatures concurrently (see 'mutecrypt
+// decrypt --batch'). The result for encs[i] is returned in res[i].
+func mutecryptDecryptBatch(
 	c *cli.Context,
-	passphrase, enc []byte,
+	passphrase []byte,
+	encs []string,
+	deferSignatureCheck bool,
 	statusFP io.Writer,
-) (senderID, message string, err error) {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-		"decrypt",
+) (res []*decryptResult, err error) {
+	args := mutecryptArgs(c, "decrypt", "--batch")
+	if deferSignatureCheck {
+		args = append(args, "--defer-signature-check")
 	}
 	cmd := exec.Command("mutecrypt", args...)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	var outbuf bytes.Buffer
 	cmd.Stdout = &outbuf
@@ -668,48 +1050,160 @@ func mutecryptDecrypt(
 	cmd.Stderr = &errbuf
 	ppR, ppW, err := os.Pipe()
 	if err != nil {
-		return "", "", log.Error(err)
+		return nil, log.Error(err)
 	}
 	defer ppR.Close()
 	ppW.Write(passphrase)
 	ppW.Close()
 	cmd.ExtraFiles = append(cmd.ExtraFiles, ppR)
 	if err := cmd.Start(); err != nil {
-		return "", "", log.Error(err)
+		return nil, log.Error(err)
 	}
-	if _, err := stdin.Write(enc); err != nil {
-		return "", "", log.Error(err)
+	for _, enc := range encs {
+		if _, err := io.WriteString(stdin, enc+"\n"); err != nil {
+			return nil, log.Error(err)
+		}
 	}
 	stdin.Close()
 	if err := cmd.Wait(); err != nil {
-		errstr := strings.TrimSpace(errbuf.String())
-		if strings.HasSuffix(errstr, msg.ErrNoPreHeaderKey.Error()) {
-			log.Warn("could not decrypt pre-header, message dropped")
-			fmt.Fprintf(statusFP,
-				"could not decrypt pre-header, message dropped\n")
-			return "", "", nil
-		}
-		return "", "", log.Errorf("%s: %s", err, errstr)
+		return nil, log.Errorf("%s: %s", err, strings.TrimSpace(errbuf.String()))
 	}
-	// TODO: parse and process signature!
+	// parse status output
+	res = make([]*decryptResult, len(encs))
+	var cur *decryptResult
 	scanner := bufio.NewScanner(&errbuf)
-	if scanner.Scan() {
+	for scanner.Scan() {
 		line := scanner.Text()
-		parts := strings.Split(line, "\t")
-		if len(parts) != 2 || parts[0] != "SENDERIDENTITY:" {
-			return "", "",
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			return nil,
 				log.Errorf("ctrlengine: mutecrypt status output not parsable: %s", line)
 		}
-		senderID = parts[1]
-	} else {
-		return "", "", log.Error("ctrlengine: expecting mutecrypt output")
+		if parts[0] != "MESSAGE:" && cur == nil {
+			return nil,
+				log.Errorf("ctrlengine: mutecrypt status output not parsable: %s", line)
+		}
+		switch parts[0] {
+		case "MESSAGE:":
+			i, err := strconv.Atoi(parts[1])
+			if err != nil || i < 0 || i >= len(res) {
+				return nil,
+					log.Errorf("ctrlengine: mutecrypt status output not parsable: %s", line)
+			}
+			cur = &decryptResult{}
+			res[i] = cur
+		case "SENDERIDENTITY:":
+			cur.senderID = parts[1]
+		case "SIGNATURE:":
+			// optional permanent signature (already verified by mutecrypt,
+			// unless the check has been deferred)
+			cur.signature = parts[1]
+		case "ERROR:":
+			if strings.HasSuffix(parts[1], msg.ErrNoPreHeaderKey.Error()) {
+				log.Warn("could not decrypt pre-header, message dropped")
+				fmt.Fprintf(statusFP,
+					"could not decrypt pre-header, message dropped\n")
+				cur.err = &decryptError{msg.ErrNoPreHeaderKey.Error()}
+			} else {
+				log.Warnf("could not decrypt message, message dropped: %s",
+					parts[1])
+				fmt.Fprintf(statusFP,
+					"could not decrypt message, message dropped\n")
+				cur.err = &decryptError{parts[1]}
+			}
+		}
 	}
 	if err := scanner.Err(); err != nil {
-		return "", "", log.Error(err)
+		return nil, log.Error(err)
+	}
+	// parse decrypted messages
+	scanner = bufio.NewScanner(&outbuf)
+	scanner.Buffer(nil, msg.EncodedMsgSize+1)
+	for i := range res {
+		if res[i] == nil {
+			return nil, log.Errorf("ctrlengine: mutecrypt output misses message %d", i)
+		}
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, log.Error(err)
+			}
+			return nil, log.Error("ctrlengine: expecting mutecrypt output")
+		}
+		if res[i].err != nil {
+			continue
+		}
+		if res[i].senderID == "" {
+			return nil, log.Errorf("ctrlengine: mutecrypt output misses sender of message %d", i)
+		}
+		message, err := base64.Decode(scanner.Text())
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		res[i].message = string(message)
 	}
+	return res, nil
+}
 
-	message = outbuf.String()
-	return
+// openEnvelope decrypts the envelope of a message received from the mix on
+// the account of myID (and contactID). It returns nil, if the message has to
+// be discarded, because it cannot be opened, was not sent to myID, or was sent
+// to a nym address of myID whose grace period after rotation elapsed (see
+// nymRotate). Accepted messages are counted for the nym address they were
+// sent to (see nymStats), that nym address is returned (empty, if the
+// message was not sent to a nym address stored in msgDB).
+func (ce *CtrlEngine) openEnvelope(myID, contactID string, message []byte) (
+	[]byte,
+	string,
+	error,
+) {
+	privkey, server, secret, _, _, _, err := ce.msgDB.GetAccount(myID, contactID)
+	if err != nil {
+		return nil, "", err
+	}
+	receiveTemplate := nymaddr.AddressTemplate{
+		Secret: secret[:],
+	}
+	var pubkey [32]byte
+	copy(pubkey[:], privkey[32:])
+	dec, nym, err := mixcrypt.ReceiveFromMix(receiveTemplate,
+		util.MailboxAddress(&pubkey, server), message)
+	if err != nil {
+		log.Warnf("ctrlengine: cannot open envelope for %s -> discard message: %s",
+			myID, err)
+		ce.audit(&AuditEntry{Type: AuditDecryptFailed, MyID: myID,
+			Detail: "envelope: " + err.Error()})
+		return nil, "", nil
+	}
+	if !bytes.Equal(nym, cipher.SHA256([]byte(myID))) {
+		log.Warnf("ctrlengine: hashed nym does not match %s -> discard message", myID)
+		ce.audit(&AuditEntry{Type: AuditDecryptFailed, MyID: myID,
+			Detail: "envelope: hashed nym does not match"})
+		return nil, "", nil
+	}
+	receiverKey, err := mixcrypt.ReceiverPubKey(message)
+	if err != nil {
+		log.Warnf("ctrlengine: no receiver key for %s -> discard message: %s",
+			myID, err)
+		ce.audit(&AuditEntry{Type: AuditDecryptFailed, MyID: myID,
+			Detail: "envelope: " + err.Error()})
+		return nil, "", nil
+	}
+	now := times.Now()
+	retired, err := ce.msgDB.NymAddressRetired(myID,
+		base64.Encode(receiverKey), now)
+	if err != nil {
+		return nil, "", err
+	}
+	if retired {
+		log.Warnf("ctrlengine: nym address of %s retired -> discard message", myID)
+		return nil, "", nil
+	}
+	nymAddress, err := ce.msgDB.AddNymAddressMessage(myID,
+		base64.Encode(receiverKey), now)
+	if err != nil {
+		return nil, "", err
+	}
+	return dec, nymAddress, nil
 }
 
 func (ce *CtrlEngine) procInQueue(c *cli.Context, host string) error {
@@ -731,75 +1225,192 @@ func (ce *CtrlEngine) procInQueue(c *cli.Context, host string) error {
 			if err != nil {
 				return log.Error(err)
 			}
-			privkey, server, secret, _, _, _, err := ce.msgDB.GetAccount(myID, contactID)
+			dec, nymAddress, err := ce.openEnvelope(myID, contactID, message)
 			if err != nil {
 				return err
 			}
-			receiveTemplate := nymaddr.AddressTemplate{
-				Secret: secret[:],
-			}
-			var pubkey [32]byte
-			copy(pubkey[:], privkey[32:])
-			dec, nym, err := mixcrypt.ReceiveFromMix(receiveTemplate,
-				util.MailboxAddress(&pubkey, server), message)
-			if err != nil {
-				return log.Error(err)
-			}
-			if !bytes.Equal(nym, cipher.SHA256([]byte(myID))) {
+			if dec == nil {
 				// discard message
-				log.Warnf("ctrlengine: hashed nym does not match %s -> discard message", myID)
 				if err := ce.msgDB.DelInQueue(iqIdx); err != nil {
 					return err
 				}
 			} else {
 				log.Info("envelope successfully decrypted")
-				err := ce.msgDB.SetInQueue(iqIdx, base64.Encode(dec))
+				err := ce.msgDB.SetInQueue(iqIdx, base64.Encode(dec), nymAddress)
 				if err != nil {
 					return err
 				}
 			}
 		} else {
-			log.Debugf("decrypt message (iqIdx=%d)", iqIdx)
-			senderID, plainMsg, err := mutecryptDecrypt(c, ce.passphrase,
-				[]byte(msg), ce.fileTable.StatusFP)
-			if err != nil {
+			// decrypt all messages without envelope at once, which allows
+			// mutecrypt to verify their signatures concurrently
+			if err := ce.decryptInQueue(c, host); err != nil {
 				return err
 			}
-			if senderID == "" {
-				// message could not be decrypted, but we do not want to fail
-				if err := ce.msgDB.DelInQueue(iqIdx); err != nil {
-					return err
-				}
-				continue
-			}
-			// check if contact exists
-			contact, _, contactType, err := ce.msgDB.GetContact(myID, senderID)
-			if err != nil {
-				return log.Error(err)
-			}
-			// TODO: we do not have to do request UID message from server
-			// here, but we should use the one contained in the message and
-			// compare it with hash chain entry (doesn't compromise anonymity)
-			var drop bool
-			if contact == "" {
-				err := ce.contactAdd(myID, senderID, "", host, msgdb.GrayList, c)
-				if err != nil {
-					return log.Error(err)
-				}
-			} else if contactType == msgdb.BlackList {
-				// messages from black listed contacts are dropped directly
-				log.Debug("message from black listed contact dropped")
-				drop = true
-			}
-			err = ce.msgDB.RemoveInQueue(iqIdx, plainMsg, senderID, drop)
-			if err != nil {
+		}
+	}
+	return nil
+}
+
+// decryptInQueue decrypts all messages without envelope in the inqueue with
+// a single call of mutecrypt (see mutecryptDecryptBatch) and adds them to
+// msgDB.
+func (ce *CtrlEngine) decryptInQueue(c *cli.Context, host string) error {
+	entries, err := ce.msgDB.GetInQueueMsgs()
+	if err != nil {
+		return err
+	}
+	var (
+		msgs   []*msgdb.InQueueMsg
+		groups []*groupMsg.Message
+		encs   []string
+	)
+	for _, entry := range entries {
+		if isCoverMsg(entry.Msg) {
+			// discard decoy (see msg send --cover)
+			log.Debugf("discard decoy (iqIdx=%d)", entry.IQIdx)
+			if err := ce.msgDB.DelInQueue(entry.IQIdx); err != nil {
 				return err
 			}
+			continue
+		}
+		log.Debugf("decrypt message (iqIdx=%d)", entry.IQIdx)
+		msgs = append(msgs, entry)
+		// only the group key of a group message is encrypted for us
+		gm := groupMsg.Parse(entry.Msg)
+		groups = append(groups, gm)
+		if gm != nil {
+			encs = append(encs, gm.SEALEDKEY)
+		} else {
+			encs = append(encs, entry.Msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	results, err := mutecryptDecryptBatch(c, ce.passphrase, encs,
+		ce.deferSignatureCheck, ce.fileTable.StatusFP)
+	if err != nil {
+		return err
+	}
+	for i, res := range results {
+		if groups[i] != nil && res.err == nil {
+			openGroupMsg(groups[i], res, ce.fileTable.StatusFP)
+		}
+		if err := ce.addInQueueMsg(c, host, msgs[i], res); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// openGroupMsg decrypts the body of the group message gm with the group key
+// contained in the decrypted message of res, which is replaced by the body.
+// If the body cannot be decrypted, the message has to be dropped and res.err
+// is set to a *decryptError.
+func openGroupMsg(gm *groupMsg.Message, res *decryptResult, statusFP io.Writer) {
+	group, body, err := groupMsg.Open([]byte(res.message), gm)
+	if err != nil {
+		log.Warnf("could not decrypt group message, message dropped: %s", err)
+		fmt.Fprintf(statusFP, "could not decrypt group message, message dropped\n")
+		res.err = &decryptError{err.Error()}
+		return
+	}
+	log.Debugf("group message for group %s", group)
+	res.message = string(body)
+	// the permanent signature only covers the group key
+	res.signature = ""
+}
+
+// addInQueueMsg adds the inqueue message entry decrypted to res to msgDB.
+// Messages mutecrypt rejected are deleted from the inqueue.
+func (ce *CtrlEngine) addInQueueMsg(
+	c *cli.Context,
+	host string,
+	entry *msgdb.InQueueMsg,
+	res *decryptResult,
+) error {
+	iqIdx, myID := entry.IQIdx, entry.MyID
+	if derr, ok := res.err.(*decryptError); ok {
+		// message could not be decrypted, but we do not want to fail
+		if err := ce.msgDB.DelInQueue(iqIdx); err != nil {
+			return err
+		}
+		ce.audit(&AuditEntry{Type: AuditDecryptFailed, MyID: myID,
+			Detail: derr.reason})
+		return nil
+	}
+	senderID, plainMsg, sig := res.senderID, res.message, res.signature
+	// detect key changes of the sender (the message has been
+	// decrypted with the key mutecrypt knows for senderID)
+	fingerprint, verified, err := mutecryptFingerprint(c,
+		ce.passphrase, senderID)
+	if err != nil {
+		log.Warnf("ctrlengine: no fingerprint for sender %s: %s",
+			senderID, err)
+	} else if fingerprint != "" {
+		err := ce.auditKey(myID, senderID, verified, fingerprint)
+		if err != nil {
+			return err
+		}
+	}
+	// check if contact exists
+	contact, _, contactType, err := ce.msgDB.GetContact(myID, senderID)
+	if err != nil {
+		return log.Error(err)
+	}
+	// TODO: we do not have to do request UID message from server
+	// here, but we should use the one contained in the message and
+	// compare it with hash chain entry (doesn't compromise anonymity)
+	var drop bool
+	if contact == "" {
+		err := ce.contactAdd(myID, senderID, "", host, msgdb.GrayList,
+			false, c)
+		if err != nil {
+			return log.Error(err)
+		}
+	} else if contactType == msgdb.BlackList {
+		// messages from black listed contacts are dropped directly
+		log.Debug("message from black listed contact dropped")
+		drop = true
+	}
+	// received messages with time to live expire after it
+	// (the message is stored unchanged, the signature covers it)
+	var expire int64
+	opts, _ := mimeMsg.SplitOptions(plainMsg)
+	if opts.TTL > 0 {
+		expire = times.Now() + int64(opts.TTL/time.Second)
+	}
+	// control messages (like pings) are not shown in the inbox
+	if isControlMsg(opts.ContentType) {
+		if !drop {
+			ce.handleControl(c, myID, senderID, opts.ContentType,
+				plainMsg, sig,
+				contact != "" && contactType == msgdb.WhiteList)
+		}
+		drop = true
+	}
+	msgNum, err := ce.msgDB.RemoveInQueue(iqIdx, plainMsg, senderID, sig,
+		expire, opts.Burn, drop)
+	if err != nil {
+		return err
+	}
+	if !drop {
+		ce.events.emit(&Event{
+			Type:  EventNewMessage,
+			MyID:  myID,
+			Peer:  senderID,
+			MsgID: msgNum,
+		})
+	}
+	return nil
+}
+
+// receivedSkew is the maximum clock skew (in seconds) between the hosts a
+// nym fetches messages from, recorded received messages are kept for at
+// least that long after the last fetch (see msgFetch).
+const receivedSkew = 24 * 60 * 60
+
 func (ce *CtrlEngine) msgFetch(
 	c *cli.Context,
 	id string,
@@ -822,6 +1433,8 @@ func (ce *CtrlEngine) msgFetch(
 		if err != nil {
 			return err
 		}
+		// oldest last message time of all accounts of nym
+		var oldest int64 = -1
 		for _, contact := range contacts {
 			privkey, server, _, _, _, lastMessageTime, err := ce.msgDB.GetAccount(nym, contact)
 			if err != nil {
@@ -837,6 +1450,21 @@ func (ce *CtrlEngine) msgFetch(
 				if err != nil {
 					return log.Error(err)
 				}
+				lastMessageTime = newMessageTime
+			}
+			if oldest < 0 || lastMessageTime < oldest {
+				oldest = lastMessageTime
+			}
+		}
+		// messages received before the oldest last message time (minus the
+		// allowed clock skew between hosts) are never fetched again
+		if oldest > receivedSkew {
+			n, err := ce.msgDB.DelReceived(nym, oldest-receivedSkew)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Debugf("ctrlengine: %d received message hash(es) pruned", n)
 			}
 		}
 	}
@@ -845,6 +1473,20 @@ func (ce *CtrlEngine) msgFetch(
 	return ce.procInQueue(c, host)
 }
 
+// msgSweep deletes all received messages whose time to live expired. It is
+// called before every command which uses the msgDB (see prepare) and every
+// request of the app mode, so expired messages are never shown.
+func (ce *CtrlEngine) msgSweep() error {
+	n, err := ce.msgDB.DelExpiredMessages(times.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Infof("ctrlengine: %d expired message(s) deleted", n)
+	}
+	return nil
+}
+
 func (ce *CtrlEngine) msgList(w io.Writer, id string) error {
 	idMapped, err := identity.Map(id)
 	if err != nil {
@@ -874,18 +1516,65 @@ func (ce *CtrlEngine) msgList(w io.Writer, id string) error {
 				status = 'P'
 			}
 		}
-		fmt.Fprintf(w, "%c%c %d\t%s\t%s\t%s\t%s\n",
+		fmt.Fprintf(w, "%c%c %d\t%s\t%s\t%s\t%s\t%s\n",
 			direction,
 			status,
 			id.MsgID,
-			time.Unix(id.Date, 0).Format(time.RFC3339),
+			ce.fmtTime(id.Date),
 			id.From,
 			id.To,
-			id.Subject)
+			id.Subject,
+			id.ContentType)
 	}
 	return nil
 }
 
+func (ce *CtrlEngine) msgQueue(w io.Writer, id string, jsonOutput bool) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	msgs, err := ce.msgDB.GetQueuedMsgs(idMapped)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		if msgs == nil {
+			msgs = []*msgdb.QueuedMsg{}
+		}
+		jsn, err := json.MarshalIndent(msgs, "", "  ")
+		if err != nil {
+			return log.Error(err)
+		}
+		fmt.Fprintln(w, string(jsn))
+		return nil
+	}
+	for _, msg := range msgs {
+		state := "pending"
+		if msg.Failed {
+			state = "failed"
+		} else if msg.Encrypted {
+			state = "encrypted"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%s\n", msg.MsgID, msg.To,
+			msg.Size, msg.MinDelay, msg.MaxDelay, state)
+	}
+	return nil
+}
+
+// contentTypeHeader returns the Content-Type header value for a message with
+// the given contentType. Text messages are always UTF-8 encoded.
+func contentTypeHeader(contentType string) string {
+	mediatype, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "text/plain; charset=UTF-8"
+	}
+	if strings.HasPrefix(mediatype, "text/") && params["charset"] == "" {
+		params["charset"] = "UTF-8"
+	}
+	return mime.FormatMediaType(mediatype, params)
+}
+
 func (ce *CtrlEngine) msgRead(w io.Writer, myID string, msgID int64) error {
 	idMapped, err := identity.Map(myID)
 	if err != nil {
@@ -895,28 +1584,162 @@ func (ce *CtrlEngine) msgRead(w io.Writer, myID string, msgID int64) error {
 	if err != nil {
 		return err
 	}
-	if err := ce.msgDB.ReadMessage(msgID); err != nil {
+	// nym address a received message was sent to (if known)
+	nymAddress, err := ce.msgDB.GetMessageNymAddress(idMapped, msgID)
+	if err != nil {
 		return err
 	}
+	// messages are not marked as read in --read-only mode
+	if !ce.readOnly {
+		if err := ce.msgDB.ReadMessage(msgID); err != nil {
+			return err
+		}
+	}
+	opts, msg := mimeMsg.SplitOptions(msg)
 	subject, message := mimeMsg.SplitMessage(msg)
-	fmt.Fprintf(w, "Date: %s\r\n",
-		time.Unix(date, 0).UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "Date: %s\r\n", ce.fmtTime(date))
 	fmt.Fprintf(w, "From: %s\r\n", from)
 	fmt.Fprintf(w, "To: %s\r\n", to)
+	if nymAddress != "" {
+		fmt.Fprintf(w, "Delivered-To: %s\r\n", nymAddress)
+	}
 	if subject != "" {
 		fmt.Fprintf(w, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
 	}
 	fmt.Fprintf(w, "MIME-Version: 1.0\r\n")
-	fmt.Fprintf(w, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(w, "Content-Type: %s\r\n", contentTypeHeader(opts.ContentType))
 	fmt.Fprintf(w, "\r\n")
 	fmt.Fprintf(w, "%s", message)
+	// messages to burn after reading are deleted after they have been shown
+	if ce.readOnly {
+		return nil
+	}
+	burned, err := ce.msgDB.BurnMessage(idMapped, msgID)
+	if err != nil {
+		return err
+	}
+	if burned {
+		log.Infof("ctrlengine: message %d burned after reading", msgID)
+	}
 	return nil
 }
 
-func (ce *CtrlEngine) msgDelete(myID string, msgID int64) error {
+func mutecryptVerify(
+	c *cli.Context,
+	passphrase []byte,
+	signerID, signature, content string,
+) (string, error) {
+	args := mutecryptArgs(c,
+		"verify",
+		"--id", signerID,
+		"--signature", signature,
+	)
+	cmd := exec.Command("mutecrypt", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	var outbuf bytes.Buffer
+	cmd.Stdout = &outbuf
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	ppR, ppW, err := os.Pipe()
+	if err != nil {
+		return "", log.Error(err)
+	}
+	defer ppR.Close()
+	ppW.Write(passphrase)
+	ppW.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, ppR)
+	if err := cmd.Start(); err != nil {
+		return "", log.Error(err)
+	}
+	if _, err := io.WriteString(stdin, content); err != nil {
+		return "", log.Error(err)
+	}
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return "", log.Errorf("%s: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+	return strings.TrimSpace(outbuf.String()), nil
+}
+
+// msgVerify verifies the permanent signature of the received message msgID
+// against the current signature key of the sender and writes the result
+// (VALID or INVALID), the sender, and the key fingerprint to w. The key of a
+// valid signature is recorded in the audit log with the verification status
+// of the sender's UID.
+func (ce *CtrlEngine) msgVerify(
+	c *cli.Context,
+	w io.Writer,
+	myID string,
+	msgID int64,
+) error {
+	idMapped, err := identity.Map(myID)
+	if err != nil {
+		return err
+	}
+	senderID, signature, err := ce.msgDB.GetMessageSignature(idMapped, msgID)
+	if err != nil {
+		return err
+	}
+	if signature == "" {
+		return log.Errorf("message %d has no permanent signature", msgID)
+	}
+	_, _, msg, _, err := ce.msgDB.GetMessage(idMapped, msgID)
+	if err != nil {
+		return err
+	}
+	result, err := mutecryptVerify(c, ce.passphrase, senderID, signature, msg)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(result, "\t")
+	if len(parts) != 2 || (parts[0] != "VALID" && parts[0] != "INVALID") {
+		return log.Errorf("ctrlengine: mutecrypt verify output not parsable: %s",
+			result)
+	}
+	if parts[0] == "VALID" {
+		// the signature key is only verified, if the UID of the sender is
+		// covered by the hash chain
+		_, verified, err := mutecryptFingerprint(c, ce.passphrase, senderID)
+		if err != nil {
+			return err
+		}
+		if err := ce.auditKey(idMapped, senderID, verified, parts[1]); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\n", parts[0], senderID, parts[1])
+	return nil
+}
+
+func (ce *CtrlEngine) msgDelete(
+	statfp io.Writer,
+	myID string,
+	msgID int64,
+	dryRun bool,
+) error {
 	idMapped, err := identity.Map(myID)
 	if err != nil {
 		return err
 	}
+	if dryRun {
+		_, _, _, _, err := ce.msgDB.GetMessage(idMapped, msgID)
+		if err != nil {
+			return log.Errorf("ctrlengine: unknown msgnum %d for user ID %s",
+				msgID, myID)
+		}
+		util.WouldDelete(statfp, "message(s)", 1, strconv.FormatInt(msgID, 10))
+		return nil
+	}
 	return ce.msgDB.DelMessage(idMapped, msgID)
 }
+
+func (ce *CtrlEngine) msgCancel(myID string, msgID int64) error {
+	idMapped, err := identity.Map(myID)
+	if err != nil {
+		return err
+	}
+	return ce.msgDB.CancelMessage(idMapped, msgID)
+}
@@ -0,0 +1,854 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/util/times"
+	"github.com/urfave/cli"
+)
+
+// seedContact adds the user ID a with contact b to the message DB.
+func (te *testEngine) seedContact(a, b string) {
+	if err := te.run("uid list", 1); err != nil {
+		te.t.Fatal(err)
+	}
+	if err := te.ce.msgDB.AddNym(a, a, ""); err != nil {
+		te.t.Fatal(err)
+	}
+	if err := te.ce.msgDB.AddContact(a, b, b, "", msgdb.WhiteList); err != nil {
+		te.t.Fatal(err)
+	}
+}
+
+// queueMessage adds message from a to b and moves it to the outqueue, if
+// encrypt is true. If send is true the message is marked as sent afterwards.
+func (te *testEngine) queueMessage(a, b, message string, encrypt, send bool) int64 {
+	err := te.ce.msgDB.AddMessage(a, b, 1500000000, true, message, false, 10, 20,
+		msgdb.NormalPriority)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	msgID, _, _, _, _, _, err := te.ce.msgDB.GetUndeliveredMessage(a)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	if !encrypt {
+		return msgID
+	}
+	if err := te.ce.msgDB.AddOutQueue(a, msgID, "enc", "nymaddress", 10, 20); err != nil {
+		te.t.Fatal(err)
+	}
+	if !send {
+		return msgID
+	}
+	oqIdx, _, _, _, _, _, err := te.ce.msgDB.GetOutQueue(a)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	if err := te.ce.msgDB.RemoveOutQueue(oqIdx, 1500000000); err != nil {
+		te.t.Fatal(err)
+	}
+	return msgID
+}
+
+// failMessage adds message from a to b to the outqueue (with envelope) and
+// marks its delivery as failed.
+func (te *testEngine) failMessage(a, b, message string) int64 {
+	msgID := te.queueMessage(a, b, message, true, false)
+	oqIdx, _, _, _, _, _, err := te.ce.msgDB.GetOutQueue(a)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	if err := te.ce.msgDB.SetOutQueue(oqIdx, "envelope "+message); err != nil {
+		te.t.Fatal(err)
+	}
+	if err := te.ce.msgDB.SetResendOutQueue(oqIdx); err != nil {
+		te.t.Fatal(err)
+	}
+	return msgID
+}
+
+func TestMsgResend(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	if err := te.run("msg resend --id "+a+" --all", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "no failed messages\n" {
+		t.Errorf("output == %q", out)
+	}
+	first := te.failMessage(a, b, "first")
+	second := te.failMessage(a, b, "second")
+	te.queueMessage(a, b, "pending", true, false) // not failed
+	// the first message fails again
+	var delivered []string
+	te.ce.deliver = func(c *cli.Context, envelope string) (bool, error) {
+		delivered = append(delivered, envelope)
+		return envelope == "envelope first" && len(delivered) == 1, nil
+	}
+	err := te.run("msg resend --id "+a+" --all", 0)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 message(s)") {
+		t.Errorf("resend should report failed message: %v", err)
+	}
+	out := te.output()
+	want := fmt.Sprintf("message %d: resend\nmessage %d: sent\n", first, second)
+	if out != want {
+		t.Errorf("output == %q != %q", out, want)
+	}
+	// both failed messages are retried, the pending message is not sent
+	if s := strings.Join(delivered, ","); s != "envelope first,envelope second" {
+		t.Errorf("delivered: %s", s)
+	}
+	// retry the remaining failed message
+	if err := te.run("msg resend --id "+a+" --all", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != fmt.Sprintf("message %d: sent\n", first) {
+		t.Errorf("output == %q", out)
+	}
+	ids, err := te.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if sent := id.Subject != "pending"; id.Sent != sent {
+			t.Errorf("message %q: sent == %v", id.Subject, id.Sent)
+		}
+	}
+	if err := te.run("msg resend --id "+a+" --msgnum "+strconv.FormatInt(first, 10), 0); err == nil {
+		t.Error("resend of delivered message should fail")
+	}
+	if err := te.run("msg resend --id "+a, 0); err == nil {
+		t.Error("resend without --all or --msgnum should fail")
+	}
+}
+
+func TestAutoResend(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	first := te.failMessage(a, b, "first")   // always fails
+	second := te.failMessage(a, b, "second") // fails once more
+	failures := map[string]int{"envelope second": 1}
+	delivered := map[string]int{}
+	te.ce.deliver = func(c *cli.Context, envelope string) (bool, error) {
+		delivered[envelope]++
+		if envelope == "envelope first" {
+			return true, nil
+		}
+		if failures[envelope] > 0 {
+			failures[envelope]--
+			return true, nil
+		}
+		return false, nil
+	}
+	events := te.ce.events.subscribe()
+	defer te.ce.events.unsubscribe(events)
+	policy := &resendPolicy{
+		backoff:    time.Minute,
+		maxBackoff: 3 * time.Minute,
+		attempts:   4,
+	}
+	now := int64(1500000000)
+	resend := func(at int64) {
+		if err := te.ce.autoResend(nil, a, policy, now+at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// failed deliveries are scheduled for resend after backoff
+	resend(0)
+	if len(delivered) != 0 {
+		t.Fatal("resend should be scheduled, not performed")
+	}
+	resend(59)
+	if len(delivered) != 0 {
+		t.Fatal("resend before backoff")
+	}
+	// second attempt: both fail again, backoff doubles
+	resend(60)
+	if delivered["envelope first"] != 1 || delivered["envelope second"] != 1 {
+		t.Fatalf("delivered: %v", delivered)
+	}
+	resend(60 + 119)
+	if delivered["envelope first"] != 1 {
+		t.Fatal("resend before doubled backoff")
+	}
+	// third attempt: second message is sent
+	resend(60 + 120)
+	if delivered["envelope first"] != 2 || delivered["envelope second"] != 2 {
+		t.Fatalf("delivered: %v", delivered)
+	}
+	entries, err := te.ce.msgDB.GetResendOutQueue(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].MsgID != first {
+		t.Fatalf("message %d should have been sent", second)
+	}
+	// backoff is capped at maxBackoff
+	if entries[0].Attempts != 3 || entries[0].Retry != now+180+180 {
+		t.Errorf("wrong resend state: %+v", entries[0])
+	}
+	// fourth attempt: first message fails permanently
+	resend(180 + 180)
+	if delivered["envelope first"] != 3 {
+		t.Fatalf("delivered: %v", delivered)
+	}
+	var failed, sent bool
+	for len(events) > 0 {
+		switch ev := <-events; ev.Status {
+		case SendStatusFailed:
+			failed = true
+			if ev.MsgID != first {
+				t.Errorf("failed event for message %d != %d", ev.MsgID, first)
+			}
+		case SendStatusSent:
+			sent = true
+			if ev.MsgID != second {
+				t.Errorf("sent event for message %d != %d", ev.MsgID, second)
+			}
+		}
+	}
+	if !failed {
+		t.Error("permanent failure not signaled")
+	}
+	if !sent {
+		t.Error("delivery not signaled")
+	}
+	msgs, err := te.ce.msgDB.GetQueuedMsgs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].MsgID != first || !msgs[0].Failed {
+		t.Error("message should be marked as permanently failed")
+	}
+	// no further automatic resends, not even after msg send
+	if err := te.ce.msgDB.ClearResendOutQueue(a); err != nil {
+		t.Fatal(err)
+	}
+	resend(100000)
+	if delivered["envelope first"] != 3 {
+		t.Error("permanently failed message resent automatically")
+	}
+	if err := te.run("msg queue --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); !strings.HasSuffix(out, "\tfailed\n") {
+		t.Errorf("msg queue: %q", out)
+	}
+}
+
+func TestMsgQueue(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	te.queueMessage(a, b, "sent", true, true)
+	encrypted := te.queueMessage(a, b, "encrypted", true, false)
+	pending := te.queueMessage(a, b, "pending", false, false)
+	// text output
+	if err := te.run("msg queue --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	exp := "2\tbob@mute.berlin\t9\t10\t20\tencrypted\n" +
+		"3\tbob@mute.berlin\t7\t10\t20\tpending\n"
+	if out := te.output(); out != exp {
+		t.Errorf("msg queue: %q != %q", out, exp)
+	}
+	// JSON output
+	if err := te.run("msg queue --json --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*msgdb.QueuedMsg
+	if err := json.Unmarshal([]byte(te.output()), &msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("len(msgs) == %d != 2", len(msgs))
+	}
+	if msgs[0].MsgID != encrypted || !msgs[0].Encrypted {
+		t.Errorf("unexpected first entry: %+v", msgs[0])
+	}
+	if msgs[1].MsgID != pending || msgs[1].Encrypted || msgs[1].To != b {
+		t.Errorf("unexpected second entry: %+v", msgs[1])
+	}
+}
+
+func TestMsgCancel(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	sent := te.queueMessage(a, b, "sent", true, true)
+	encrypted := te.queueMessage(a, b, "encrypted", true, false)
+	pending := te.queueMessage(a, b, "pending", false, false)
+	// cancel unsent messages
+	for _, msgID := range []int64{encrypted, pending} {
+		err := te.run(fmt.Sprintf("msg cancel --id %s --msgnum %d", a, msgID), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := te.ce.msgDB.GetQueuedMsgs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("len(msgs) == %d != 0", len(msgs))
+	}
+	oqIdx, _, _, _, _, _, err := te.ce.msgDB.GetOutQueue(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oqIdx != 0 {
+		t.Error("outqueue should be empty")
+	}
+	// cancelling a sent message must fail and leave it untouched
+	err = te.run(fmt.Sprintf("msg cancel --id %s --msgnum %d", a, sent), 0)
+	if err != msgdb.ErrMessageSent {
+		t.Errorf("cancelling sent message should fail with ErrMessageSent: %v", err)
+	}
+	if _, _, _, _, err := te.ce.msgDB.GetMessage(a, sent); err != nil {
+		t.Error(err)
+	}
+}
+
+// receiveMessage adds the received message from b to a, which expires at the
+// given time (0: never) or is burned after reading.
+func (te *testEngine) receiveMessage(
+	a, b, message string,
+	expire int64,
+	burn bool,
+) {
+	te.receiveSignedMessage(a, b, message, "", expire, burn)
+}
+
+// receiveSignedMessage adds the received message from b to a with the given
+// permanent signature.
+func (te *testEngine) receiveSignedMessage(
+	a, b, message, signature string,
+	expire int64,
+	burn bool,
+) {
+	if err := te.ce.msgDB.AddInQueue(a, b, times.Now(), "enc"); err != nil {
+		te.t.Fatal(err)
+	}
+	iqIdx, _, _, _, _, err := te.ce.msgDB.GetInQueue()
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	_, err = te.ce.msgDB.RemoveInQueue(iqIdx, message, b, signature, expire,
+		burn, false)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+}
+
+func TestMsgTTL(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	// TTL is part of the message content, but not of the subject
+	file := filepath.Join(te.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte("subject\nbody"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("msg add --from "+a+" --to "+b+" --ttl 24h --file "+file, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, _, msg, _, err := te.ce.msgDB.GetMessage(a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "Mute-TTL: 86400\nsubject\nbody" {
+		t.Errorf("message == %q", msg)
+	}
+	ids, err := te.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0].Subject != "subject" {
+		t.Error("TTL should not be part of subject")
+	}
+	// sweep removes received messages past their TTL
+	te.receiveMessage(a, b, "expired", times.Now()-1, false)
+	te.receiveMessage(a, b, "within TTL", times.Now()+3600, false)
+	te.receiveMessage(a, b, "no TTL", 0, false)
+	if err := te.ce.msgSweep(); err != nil {
+		t.Fatal(err)
+	}
+	ids, err = te.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, id := range ids {
+		subjects = append(subjects, id.Subject)
+	}
+	if s := strings.Join(subjects, ","); s != "subject,within TTL,no TTL" {
+		t.Errorf("messages after sweep: %s", s)
+	}
+	// commands sweep before they access messages
+	te.receiveMessage(a, b, "expired2", times.Now()-1, false)
+	if err := te.run("msg list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(te.output(), "expired2") {
+		t.Error("msg list shows expired message")
+	}
+}
+
+func TestMsgBurn(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	// burn flag is part of the message content
+	file := filepath.Join(te.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte("subject\nbody"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("msg add --from "+a+" --to "+b+" --burn --file "+file, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, _, msg, _, err := te.ce.msgDB.GetMessage(a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "Mute-Burn: yes\nsubject\nbody" {
+		t.Errorf("message == %q", msg)
+	}
+	// sent messages are not burned
+	if err := te.run("msg read --id "+a+" --msgnum 1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := te.ce.msgDB.GetMessage(a, 1); err != nil {
+		t.Error(err)
+	}
+	// received message is deleted after the first read
+	te.receiveMessage(a, b, "burn\nsecret", 0, true)
+	// unread burn messages are stored sealed and are not exported
+	ids, err := te.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[1].Subject != "" {
+		t.Errorf("subject of burn message stored: %+v", ids)
+	}
+	exported, err := te.ce.msgDB.ExportMessages(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 1 {
+		t.Errorf("burn message exported: %d messages", len(exported))
+	}
+	if err := te.run("msg read --id "+a+" --msgnum 2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te.output(), "secret") {
+		t.Error("first read should show message")
+	}
+	if _, _, _, _, err := te.ce.msgDB.GetMessage(a, 2); err == nil {
+		t.Error("message should be burned after reading")
+	}
+	if err := te.run("msg read --id "+a+" --msgnum 2", 0); err == nil {
+		t.Error("second read should fail")
+	}
+}
+
+func TestMsgContentType(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	file := filepath.Join(te.homedir, "message")
+	err := ioutil.WriteFile(file, []byte("subject\n# Markdown\n\n*body*\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = te.run("msg add --from "+a+" --to "+b+" --content-type foo --file "+file, 0)
+	if err == nil {
+		t.Error("invalid content type should fail")
+	}
+	err = te.run("msg add --from "+a+" --to "+b+" --content-type text/markdown --file "+file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// content type is part of the (encrypted) message content
+	_, _, msg, _, err := te.ce.msgDB.GetMessage(a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg, "Mute-Content-Type: text/markdown\nsubject\n") {
+		t.Errorf("message == %q", msg)
+	}
+	// receiver sees the content type
+	te.receiveMessage(a, b, msg, 0, false)
+	te.receiveMessage(a, b, "plain\nbody", 0, false)
+	if err := te.run("msg list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(te.output()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("msg list returned %d lines", len(lines))
+	}
+	if !strings.HasSuffix(lines[1], "\tsubject\ttext/markdown") {
+		t.Errorf("msg list: %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "\tplain\ttext/plain") {
+		t.Errorf("msg list: %q", lines[2])
+	}
+	if err := te.run("msg read --id "+a+" --msgnum 2", 0); err != nil {
+		t.Fatal(err)
+	}
+	out := te.output()
+	if !strings.Contains(out, "Content-Type: text/markdown; charset=UTF-8\r\n") {
+		t.Errorf("msg read: %q", out)
+	}
+	if !strings.HasSuffix(out, "\r\n\r\n# Markdown\n\n*body*\n") {
+		t.Errorf("msg read: %q", out)
+	}
+	if err := te.run("msg read --id "+a+" --msgnum 3", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te.output(), "Content-Type: text/plain; charset=UTF-8\r\n") {
+		t.Error("msg read should default to text/plain")
+	}
+	// the date honors --time-format
+	_, _, _, date, err := te.ce.msgDB.GetMessage(a, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	te.ce.timeFormat = times.Unix // --time-format is parsed on first prepare
+	if err := te.run("msg read --id "+a+" --msgnum 3", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); !strings.HasPrefix(out, fmt.Sprintf("Date: %d\r\n", date)) {
+		t.Errorf("msg read should honor --time-format: %q", out)
+	}
+}
+
+func TestMsgLength(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	// message of exactly msg.MaxContentLength bytes with 4-byte runes
+	emoji := "\U0001F600"
+	n := (msg.MaxContentLength - len("subject\n")) / len(emoji)
+	filler := msg.MaxContentLength - len("subject\n") - n*len(emoji)
+	content := "subject\n" + strings.Repeat("x", filler) +
+		strings.Repeat(emoji, n)
+	if len(content) != msg.MaxContentLength {
+		t.Fatalf("len(content) == %d", len(content))
+	}
+	file := filepath.Join(te.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("msg add --from "+a+" --to "+b+" --file "+file, 0); err != nil {
+		t.Fatal(err)
+	}
+	status := fmt.Sprintf("message length: %d characters (%d of %d bytes)",
+		len("subject\n")+filler+n, msg.MaxContentLength, msg.MaxContentLength)
+	if out := te.status(); !strings.Contains(out, status) {
+		t.Errorf("status == %q", out)
+	}
+	// one more emoji exceeds the limit
+	err := ioutil.WriteFile(file, []byte(content+emoji), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = te.run("msg add --from "+a+" --to "+b+" --file "+file, 0)
+	over := fmt.Sprintf("%d bytes over the %d-byte limit", len(emoji),
+		msg.MaxContentLength)
+	if err == nil || !strings.Contains(err.Error(), over) {
+		t.Errorf("message over limit should fail: %v", err)
+	}
+	// option lines count as well
+	err = ioutil.WriteFile(file, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("msg add --from "+a+" --to "+b+" --burn --file "+file, 0); err == nil {
+		t.Error("message with options over limit should fail")
+	}
+}
+
+func TestMsgPriority(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	file := filepath.Join(te.homedir, "message")
+	for _, priority := range []string{"low", "normal", "high"} {
+		err := ioutil.WriteFile(file, []byte(priority), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = te.run("msg add --from "+a+" --to "+b+" --priority "+priority+
+			" --file "+file, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := te.run("msg add --from "+a+" --to "+b+" --priority urgent --file "+file, 0); err == nil {
+		t.Error("unknown priority should fail")
+	}
+	// high priority message sorts first and uses the tighter delays
+	for _, want := range []struct {
+		msg                string
+		minDelay, maxDelay int32
+	}{
+		{"high", def.HighPriorityMinDelay, def.HighPriorityMaxDelay},
+		{"normal", def.MinDelay, def.MaxDelay},
+		{"low", def.LowPriorityMinDelay, def.LowPriorityMaxDelay},
+	} {
+		msgID, _, msg, _, minDelay, maxDelay, err :=
+			te.ce.msgDB.GetUndeliveredMessage(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != want.msg {
+			t.Fatalf("undelivered message %q != %q", msg, want.msg)
+		}
+		if minDelay != want.minDelay || maxDelay != want.maxDelay {
+			t.Errorf("%s: delays == %d/%d", want.msg, minDelay, maxDelay)
+		}
+		err = te.ce.msgDB.AddOutQueue(a, msgID, "enc", "nymaddress", minDelay,
+			maxDelay)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// explicit delays take precedence
+	err := te.run("msg add --from "+a+" --to "+b+" --priority high --maxdelay 90 --file "+file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, _, minDelay, maxDelay, err := te.ce.msgDB.GetUndeliveredMessage(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minDelay != def.HighPriorityMinDelay || maxDelay != 90 {
+		t.Errorf("delays == %d/%d", minDelay, maxDelay)
+	}
+}
+
+func TestMsgVerify(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	te.receiveMessage(a, b, "unsigned", 0, false)
+	if err := te.run("msg verify --id "+a+" --msgnum 1", 0); err == nil {
+		t.Error("verifying unsigned message should fail")
+	}
+	te.queueMessage(a, b, "sent", false, false)
+	if err := te.run("msg verify --id "+a+" --msgnum 2", 0); err == nil {
+		t.Error("verifying sent message should fail")
+	}
+	if err := te.run("msg verify --id "+a, 0); err == nil {
+		t.Error("msg verify without --msgnum should fail")
+	}
+}
+
+func TestIntegrationMsgVerify(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	_, stop := loopbackMix(t)
+	defer stop()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	alice, aliceUID := newIntegrationEngine(t, a, nil)
+	defer alice.close()
+	bob, _ := newIntegrationEngine(t, b, nil)
+	defer bob.close()
+	bob.lookupUID(b, aliceUID)
+	if err := bob.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	bob.output()
+	seen := func() bool {
+		entries, err := bob.ce.auditLog.entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.MyID == b && e.Peer == a {
+				return true
+			}
+		}
+		return false
+	}
+
+	// forged signature is not recorded in the audit log
+	forged := base64.Encode(make([]byte, 64))
+	bob.receiveSignedMessage(b, a, "forged", forged, 0, false)
+	if err := bob.run("msg verify --id "+b+" --msgnum 1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := bob.output(); !strings.HasPrefix(out, "INVALID\t"+a+"\t") {
+		t.Errorf("msg verify output == %q", out)
+	}
+	if seen() {
+		t.Error("key of invalid signature recorded in audit log")
+	}
+
+	// valid signature is recorded
+	var key cipher.Ed25519Key
+	if err := key.SetPrivateKey(aliceUID.msg.PrivateSigKey64()[:]); err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.Encode(key.Sign(cipher.SHA512([]byte("signed"))))
+	bob.receiveSignedMessage(b, a, "signed", sig, 0, false)
+	if err := bob.run("msg verify --id "+b+" --msgnum 2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := bob.output(); !strings.HasPrefix(out, "VALID\t"+a+"\t") {
+		t.Errorf("msg verify output == %q", out)
+	}
+	if !seen() {
+		t.Error("key of valid signature not recorded in audit log")
+	}
+}
+
+func TestMsgReceived(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	// a message which cannot be queued is not recorded as received
+	_, err := te.ce.msgDB.AddReceivedInQueue(a, "eve@mute.berlin", "hash",
+		1000, "enc")
+	if err == nil {
+		t.Fatal("queueing message from unknown contact should fail")
+	}
+	known, err := te.ce.msgDB.AddReceivedInQueue(a, b, "hash", 1000, "enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known {
+		t.Error("message should not be known")
+	}
+	known, err = te.ce.msgDB.AddReceivedInQueue(a, b, "hash", 2000, "enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !known {
+		t.Error("message should be known")
+	}
+	// only the first message is in the inqueue
+	iqIdx, _, _, _, _, err := te.ce.msgDB.GetInQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.ce.msgDB.DelInQueue(iqIdx); err != nil {
+		t.Fatal(err)
+	}
+	iqIdx, _, _, _, _, err = te.ce.msgDB.GetInQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iqIdx != 0 {
+		t.Error("duplicate message in inqueue")
+	}
+	// prune
+	n, err := te.ce.msgDB.DelReceived(a, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d hashes pruned, should be 0", n)
+	}
+	n, err = te.ce.msgDB.DelReceived(a, 1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d hashes pruned, should be 1", n)
+	}
+}
+
+func TestMsgReadDeliveredTo(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	// message received via a nym address
+	if err := te.ce.msgDB.AddInQueue(a, b, times.Now(), "env"); err != nil {
+		t.Fatal(err)
+	}
+	iqIdx, _, _, _, _, err := te.ce.msgDB.GetInQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.ce.msgDB.SetInQueue(iqIdx, "enc", "nym1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = te.ce.msgDB.RemoveInQueue(iqIdx, "subject\nbody", b, "", 0, false,
+		false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// message with unknown nym address
+	te.receiveMessage(a, b, "subject\nbody", 0, false)
+	if err := te.run("msg read --id "+a+" --msgnum 1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); !strings.Contains(out, "\r\nDelivered-To: nym1\r\n") {
+		t.Errorf("msg read: nym address missing: %q", out)
+	}
+	if err := te.run("msg read --id "+a+" --msgnum 2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); strings.Contains(out, "Delivered-To:") {
+		t.Errorf("msg read: unknown nym address shown: %q", out)
+	}
+}
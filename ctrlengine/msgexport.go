@@ -0,0 +1,122 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util/i18n"
+)
+
+// mmxFormat is the name of the native message export format.
+const mmxFormat = "mmx"
+
+// mmxVersion is the current version of the native message export format.
+const mmxVersion = 1
+
+// mmxFile is the (JSON encoded) format of a native message export file.
+type mmxFile struct {
+	Format   string                   `json:"format"`
+	Version  int                      `json:"version"`
+	ID       string                   `json:"id"`
+	Messages []*msgdb.ExportedMessage `json:"messages"`
+}
+
+// msgExport writes all messages of myID (with their metadata) to filename in
+// the given format.
+func (ce *CtrlEngine) msgExport(
+	statusfp io.Writer,
+	myID, format, filename string,
+) error {
+	if format != mmxFormat {
+		return log.Errorf("ctrlengine: unknown export format '%s'", format)
+	}
+	myID, err := identity.Map(myID)
+	if err != nil {
+		return err
+	}
+	// make sure export does not exist already
+	if _, err := os.Stat(filename); err == nil {
+		return log.Errorf("ctrlengine: export file '%s' exists already",
+			filename)
+	}
+	msgs, err := ce.msgDB.ExportMessages(myID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(&mmxFile{
+		Format:   mmxFormat,
+		Version:  mmxVersion,
+		ID:       myID,
+		Messages: msgs,
+	}, "", "  ")
+	if err != nil {
+		return log.Error(err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		return log.Error(err)
+	}
+	i18n.Fprintf(statusfp, "%d message(s) exported to '%s'\n", len(msgs),
+		filename)
+	return nil
+}
+
+// msgImport imports the messages exported to filename into the messages of
+// myID. Peers which are not a contact of myID yet are added as gray listed
+// contacts. Messages which exist already are skipped.
+func (ce *CtrlEngine) msgImport(statusfp io.Writer, myID, filename string) error {
+	myID, err := identity.Map(myID)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return log.Error(err)
+	}
+	var file mmxFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return log.Errorf("ctrlengine: cannot parse message export: %s", err)
+	}
+	if file.Format != mmxFormat {
+		return log.Errorf("ctrlengine: unknown export format '%s'", file.Format)
+	}
+	if file.Version != mmxVersion {
+		return log.Errorf("ctrlengine: unsupported message export version %d",
+			file.Version)
+	}
+	var imported, skipped int
+	for _, m := range file.Messages {
+		unmappedID, _, _, err := ce.msgDB.GetContact(myID, m.PeerID)
+		if err != nil {
+			return err
+		}
+		if unmappedID == "" {
+			err := ce.msgDB.AddContact(myID, m.PeerID, m.PeerUnmappedID, "",
+				msgdb.GrayList)
+			if err != nil {
+				return err
+			}
+		}
+		ok, err := ce.msgDB.ImportMessage(myID, m)
+		if err != nil {
+			return err
+		}
+		if ok {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+	i18n.Fprintf(statusfp,
+		"%d message(s) imported from '%s' (%d existing skipped)\n",
+		imported, filename, skipped)
+	return nil
+}
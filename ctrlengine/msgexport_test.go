@@ -0,0 +1,109 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/msgdb"
+)
+
+func TestMsgExportImport(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	te.queueMessage(a, b, "sent\nbody", true, true)
+	te.receiveSignedMessage(a, b, "Mute-Content-Type: text/markdown\nread\nbody",
+		"signature", 0, false)
+	te.receiveMessage(a, b, "unread\nbody", 0, false)
+	if err := te.run("msg read --id "+a+" --msgnum 2", 0); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(te.homedir, "export.mmx")
+	if err := te.run("msg export --id "+a+" --format mbox --file "+file, 0); err == nil {
+		t.Error("unknown export format should fail")
+	}
+	// unmapped user IDs are mapped
+	if err := te.run("msg export --id Alice@Mute.Berlin --file "+file, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te.status(), "3 message(s) exported") {
+		t.Errorf("status == %q", te.status())
+	}
+	if err := te.run("msg export --id "+a+" --file "+file, 0); err == nil {
+		t.Error("existing export file should not be overwritten")
+	}
+	exported, err := te.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// import into another installation which knows nothing about bob
+	te2 := newTestEngine(t)
+	defer te2.close()
+	te2.seedDBs()
+	if err := te2.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := te2.ce.msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := te2.run("msg import --id Alice@Mute.Berlin --file "+file, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te2.status(), "3 message(s) imported") {
+		t.Errorf("status == %q", te2.status())
+	}
+	_, _, contactType, err := te2.ce.msgDB.GetContact(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contactType != msgdb.GrayList {
+		t.Error("unknown peer should be added to gray list")
+	}
+	imported, err := te2.ce.msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != len(exported) {
+		t.Fatalf("%d messages imported, %d exported", len(imported),
+			len(exported))
+	}
+	for i, m := range imported {
+		e := exported[i]
+		if m.From != e.From || m.To != e.To || m.Incoming != e.Incoming ||
+			m.Sent != e.Sent || m.Date != e.Date || m.Subject != e.Subject ||
+			m.Read != e.Read || m.ContentType != e.ContentType {
+			t.Errorf("imported message %d differs: %+v != %+v", i+1, m, e)
+		}
+	}
+	senderID, sig, err := te2.ce.msgDB.GetMessageSignature(a, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if senderID != b || sig != "signature" {
+		t.Error("signature not imported")
+	}
+	// imported sent message is not sent again
+	msgID, _, _, _, _, _, err := te2.ce.msgDB.GetUndeliveredMessage(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgID != 0 {
+		t.Error("imported message should not be queued for sending")
+	}
+	// a second import skips existing messages
+	if err := te2.run("msg import --id "+a+" --file "+file, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te2.status(), "0 message(s) imported") {
+		t.Errorf("status == %q", te2.status())
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// newNymAddress generates a new nym address (with its mix address and
+// receiver key) which receives messages for nym on the account of nym.
+func (ce *CtrlEngine) newNymAddress(nym string, expire int64, singleUse bool) (
+	mixAddress, nymAddress, receiverKey string,
+	err error,
+) {
+	// TODO! (implement more accounts? delay settings?)
+	privkey, server, secret, minDelay, maxDelay, _, err :=
+		ce.msgDB.GetAccount(nym, "")
+	if err != nil {
+		return "", "", "", err
+	}
+	_, domain, err := identity.Split(nym)
+	if err != nil {
+		return "", "", "", err
+	}
+	var pubkey [ed25519.PublicKeySize]byte
+	copy(pubkey[:], privkey[32:])
+	return util.NewNymAddressKey(domain, secret[:], expire, singleUse,
+		minDelay, maxDelay, nym, &pubkey, server, def.CACert)
+}
+
+// addNymAddress generates a new nym address for the mapped ID idMapped,
+// stores it in the msgDB, and writes it (together with its mix address) to w.
+// If retire is not zero, all active nym addresses of idMapped are retired at
+// that time, before the new one is stored.
+func (ce *CtrlEngine) addNymAddress(
+	w, statusfp io.Writer,
+	idMapped string,
+	singleUse bool,
+	retire int64,
+) error {
+	expire := times.ThirtyDaysLater()
+	mixAddress, nymAddress, receiverKey, err := ce.newNymAddress(idMapped,
+		expire, singleUse)
+	if err != nil {
+		return err
+	}
+	if retire != 0 {
+		n, err := ce.msgDB.RetireNymAddresses(idMapped, retire)
+		if err != nil {
+			return err
+		}
+		log.Infof("retiring %d nym address(es) of %s until %s", n, idMapped,
+			time.Unix(retire, 0).UTC().Format(time.RFC3339))
+		i18n.Fprintf(statusfp, "retiring %d nym address(es) until %s\n", n,
+			ce.fmtTime(retire))
+	}
+	err = ce.msgDB.AddNymAddress(idMapped, mixAddress, nymAddress,
+		receiverKey, expire)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "MIXADDRESS:\t%s\n", mixAddress)
+	fmt.Fprintf(w, "NYMADDRESS:\t%s\n", nymAddress)
+	fmt.Fprintf(w, "EXPIRE:\t%s\n", ce.fmtTime(expire))
+	return nil
+}
+
+// nymNew generates a new nym address for id, stores it in the msgDB, and
+// writes it (together with its mix address) to w. The addresses can be
+// passed to `mutecrypt keyinit add` as --mixaddress and --nymaddress.
+func (ce *CtrlEngine) nymNew(w io.Writer, id string, singleUse bool) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	return ce.addNymAddress(w, nil, idMapped, singleUse, 0)
+}
+
+// nymRotate generates a new nym address for id (like nymNew) and retires all
+// other nym addresses of id: messages sent to them are still accepted during
+// the given grace period, which allows messages in flight to arrive.
+func (ce *CtrlEngine) nymRotate(
+	w, statusfp io.Writer,
+	id string,
+	singleUse bool,
+	grace time.Duration,
+) error {
+	if grace < 0 {
+		return log.Errorf("ctrlengine: negative grace period %s", grace)
+	}
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	retire := times.Now() + int64(grace/time.Second)
+	return ce.addNymAddress(w, statusfp, idMapped, singleUse, retire)
+}
+
+// nymList writes the nym addresses of id which have not expired (or retired)
+// yet to w.
+func (ce *CtrlEngine) nymList(w io.Writer, id string) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	addrs, err := ce.msgDB.GetNymAddresses(idMapped, times.Now())
+	if err != nil {
+		return err
+	}
+	now := times.Now()
+	for _, addr := range addrs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			ce.fmtTime(addr.Expire), nymState(addr, now, ce.timeFormat),
+			addr.MixAddress, addr.NymAddress)
+	}
+	return nil
+}
+
+// nymState returns the state of nym address addr at time now (times are
+// formatted in timeFormat).
+func nymState(
+	addr *msgdb.NymAddress,
+	now int64,
+	timeFormat times.Format,
+) string {
+	switch {
+	case addr.Retire != 0 && addr.Retire <= now:
+		return "retired"
+	case addr.Expire <= now:
+		return "expired"
+	case addr.Retire != 0:
+		return "retiring until " + timeFormat.Sprint(addr.Retire, now)
+	default:
+		return "active"
+	}
+}
+
+// nymStats writes all nym addresses of id (including expired and retired
+// ones) with the number of messages received via them and the time of the
+// last message to w.
+func (ce *CtrlEngine) nymStats(w io.Writer, id string) error {
+	idMapped, err := identity.Map(id)
+	if err != nil {
+		return err
+	}
+	addrs, err := ce.msgDB.GetNymAddressStats(idMapped)
+	if err != nil {
+		return err
+	}
+	now := times.Now()
+	for _, addr := range addrs {
+		lastMessage := "never"
+		if addr.LastMessage != 0 {
+			lastMessage = ce.fmtTime(addr.LastMessage)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", addr.Messages, lastMessage,
+			nymState(addr, now, ce.timeFormat), addr.NymAddress)
+	}
+	return nil
+}
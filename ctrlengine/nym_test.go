@@ -0,0 +1,376 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/mix/client"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// parseNymAddress returns the mix address and the nym address contained in
+// the output of `nym new` (or `nym rotate`).
+func parseNymAddress(t *testing.T, out string) (mixAddress, nymAddress string) {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "MIXADDRESS:\t") {
+			mixAddress = strings.TrimPrefix(line, "MIXADDRESS:\t")
+		}
+		if strings.HasPrefix(line, "NYMADDRESS:\t") {
+			nymAddress = strings.TrimPrefix(line, "NYMADDRESS:\t")
+		}
+	}
+	if mixAddress == "" || nymAddress == "" {
+		t.Fatalf("no nym address in output: %s", out)
+	}
+	return
+}
+
+func TestIntegrationNym(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	_, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	te, aliceUID := newIntegrationEngine(t, a, nil)
+	defer te.close()
+	if err := te.run("nym new --id "+a, 1); err != nil {
+		t.Fatal(err)
+	}
+	mixAddress, nymAddress := parseNymAddress(t, te.output())
+
+	// the generated nym address is listed
+	if err := te.run("nym list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Split(strings.TrimSpace(te.output()), "\t")
+	if len(fields) != 4 || fields[1] != "active" || fields[2] != mixAddress ||
+		fields[3] != nymAddress {
+		t.Errorf("nym list: unexpected output: %s", te.output())
+	}
+
+	// and can be used in a KeyInit message (like `keyinit add` does)
+	now := uint64(times.Now())
+	ki, _, _, err := aliceUID.msg.KeyInit(0, now+times.Day, now-times.Day,
+		false, "mute.berlin", mixAddress, nymAddress, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigPubKey := aliceUID.msg.SigPubKey()
+	if err := ki.Verify([]string{"mute.berlin"}, sigPubKey); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := ki.SessionAnchor(sigPubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sa.NymAddress() != nymAddress {
+		t.Error("KeyInit contains wrong nym address")
+	}
+}
+
+// receiveVia sends message to nymAddress over the loopback mix (with
+// mailbox directory) and returns it as it is fetched by the account of id.
+func (te *testEngine) receiveVia(mailbox, id, nymAddress, message string) []byte {
+	addr, err := base64.Decode(nymAddress)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	mo := client.MessageInput{
+		NymAddress: addr,
+		Message:    []byte(message),
+	}.Create()
+	if mo.Error != nil {
+		te.t.Fatal(mo.Error)
+	}
+	lb := &client.Loopback{Dir: mailbox}
+	if _, err := lb.Deliver(mo); err != nil {
+		te.t.Fatal(err)
+	}
+	privkey, server, _, _, _, _, err := te.ce.msgDB.GetAccount(id, "")
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	msgs, err := lb.ListMessages(privkey, 0, server)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		te.t.Fatalf("%d messages in mailbox, should be 1", len(msgs))
+	}
+	msg, err := lb.FetchMessage(privkey, msgs[0].MessageID, server)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	// empty mailboxes again
+	if err := os.RemoveAll(filepath.Join(mailbox, "mailbox")); err != nil {
+		te.t.Fatal(err)
+	}
+	return msg
+}
+
+func TestIntegrationNymRotate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	mailbox, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	te, _ := newIntegrationEngine(t, a, nil)
+	defer te.close()
+	if err := te.run("nym new --id "+a, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, oldNym := parseNymAddress(t, te.output())
+	if err := te.run("nym rotate --id "+a+" --grace 72h", 0); err != nil {
+		t.Fatal(err)
+	}
+	_, newNym := parseNymAddress(t, te.output())
+	if status := te.status(); !strings.Contains(status, "retiring 1 nym address(es)") {
+		t.Errorf("nym rotate: unexpected status: %s", status)
+	}
+	if err := te.run("nym list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(te.output()), "\n")
+	if len(lines) != 2 ||
+		!strings.Contains(lines[0], "\tretiring until ") ||
+		!strings.HasSuffix(lines[0], oldNym) ||
+		!strings.Contains(lines[1], "\tactive\t") ||
+		!strings.HasSuffix(lines[1], newNym) {
+		t.Errorf("nym list: unexpected output: %s", te.output())
+	}
+
+	// during the grace period both nym addresses receive
+	msg, err := newCoverMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nym := range []string{oldNym, newNym} {
+		dec, _, err := te.ce.openEnvelope(a, "",
+			te.receiveVia(mailbox, a, nym, msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dec) != msg {
+			t.Error("message to nym address not received")
+		}
+	}
+
+	// after the grace period only the new one does
+	_, err = te.ce.msgDB.DB().Exec("UPDATE NymAddresses SET Retire=? WHERE Retire>0;",
+		times.Now()-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, _, err := te.ce.openEnvelope(a, "",
+		te.receiveVia(mailbox, a, oldNym, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec != nil {
+		t.Error("message to retired nym address should be discarded")
+	}
+	dec, _, err = te.ce.openEnvelope(a, "",
+		te.receiveVia(mailbox, a, newNym, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec == nil {
+		t.Error("message to active nym address should be received")
+	}
+	if err := te.run("nym list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); strings.Count(out, "\n") != 1 ||
+		!strings.Contains(out, newNym) {
+		t.Errorf("nym list: unexpected output: %s", out)
+	}
+}
+
+func TestIntegrationNymStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	mailbox, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	te, _ := newIntegrationEngine(t, a, nil)
+	defer te.close()
+	var nyms []string
+	for i := 0; i < 3; i++ {
+		passphrases := 0
+		if i == 0 {
+			passphrases = 1
+		}
+		if err := te.run("nym new --id "+a, passphrases); err != nil {
+			t.Fatal(err)
+		}
+		_, nym := parseNymAddress(t, te.output())
+		nyms = append(nyms, nym)
+	}
+	msg, err := newCoverMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two messages via the first, one via the second, none via the third
+	for _, nym := range []string{nyms[0], nyms[1], nyms[0]} {
+		dec, nymAddress, err := te.ce.openEnvelope(a, "",
+			te.receiveVia(mailbox, a, nym, msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec == nil {
+			t.Fatal("message to nym address should be received")
+		}
+		if nymAddress != nym {
+			t.Errorf("message attributed to wrong nym address: %s", nymAddress)
+		}
+	}
+	if err := te.run("nym stats --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(te.output()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("nym stats: unexpected output: %s", te.output())
+	}
+	for i, count := range []string{"2", "1", "0"} {
+		fields := strings.Split(lines[i], "\t")
+		if len(fields) != 4 || fields[0] != count || fields[2] != "active" ||
+			fields[3] != nyms[i] {
+			t.Errorf("nym stats: unexpected line: %s", lines[i])
+		}
+	}
+	if !strings.Contains(lines[2], "\tnever\t") {
+		t.Errorf("nym stats: unused nym address has last message: %s", lines[2])
+	}
+}
+
+func TestIntegrationNymRotateReply(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	_, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	te, _ := newIntegrationEngine(t, a, nil)
+	defer te.close()
+
+	// nym addresses generated for replies are recorded
+	if err := te.run("nym list --id "+a, 1); err != nil {
+		t.Fatal(err)
+	}
+	replyNym, err := te.ce.recvNymAddress(a, "bob@mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("nym list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); !strings.Contains(out, "\tactive\t") ||
+		!strings.Contains(out, replyNym) {
+		t.Errorf("nym list: unexpected output: %s", out)
+	}
+
+	// and retired by `nym rotate`
+	if err := te.run("nym rotate --id "+a+" --grace 72h", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("nym list --id "+a, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(te.output()), "\n") {
+		if strings.HasSuffix(line, replyNym) &&
+			!strings.Contains(line, "\tretiring until ") {
+			t.Errorf("nym list: reply nym address not retired: %s", line)
+		}
+	}
+}
+
+func TestIntegrationReplyNymReuse(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	mailbox, stop := loopbackMix(t)
+	defer stop()
+
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	alice, _ := newIntegrationEngine(t, a, nil)
+	defer alice.close()
+	bob, bobUID := newIntegrationEngine(t, b, nil)
+	defer bob.close()
+	alice.lookupUID(a, bobUID)
+	loopback := "--transport loopback --mailbox " + mailbox + " "
+
+	// repeated sends to Bob reuse the nym address for his replies
+	file := filepath.Join(alice.homedir, "message")
+	if err := ioutil.WriteFile(file, []byte("Hello Bob"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var rows int
+	passphrases := 1
+	for i := 0; i < 3; i++ {
+		err := alice.run(loopback+"msg add --from "+a+" --to "+b+" --file "+
+			file, passphrases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		passphrases = 0
+		if err := alice.run(loopback+"msg send --id "+a, 0); err != nil {
+			t.Fatal(err)
+		}
+		addrs, err := alice.ce.msgDB.GetNymAddressStats(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && len(addrs) != rows {
+			t.Errorf("send %d added nym addresses: %d != %d", i, len(addrs),
+				rows)
+		}
+		rows = len(addrs)
+	}
+	replyNym, err := alice.ce.recvNymAddress(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other peers get their own nym address
+	carolNym, err := alice.ce.recvNymAddress(a, "carol@mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carolNym == replyNym {
+		t.Error("nym address shared between peers")
+	}
+
+	// retired nym addresses are not reused
+	if err := alice.run("nym rotate --id "+a+" --grace 72h", 0); err != nil {
+		t.Fatal(err)
+	}
+	newNym, err := alice.ce.recvNymAddress(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newNym == replyNym {
+		t.Error("retired nym address reused")
+	}
+}
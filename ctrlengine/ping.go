@@ -0,0 +1,203 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
+	mimeMsg "github.com/mutecomm/mute/msg/mime"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/uid/identity"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/urfave/cli"
+)
+
+// Content types of control messages. Control messages are handled by the
+// CtrlEngine itself and are not shown in the inbox (see procInQueue).
+const (
+	pingContentType = "application/x-mute-ping"
+	pongContentType = "application/x-mute-pong"
+)
+
+// pingPollInterval is the interval in which ping fetches new messages while
+// it waits for the pong.
+var pingPollInterval = 5 * time.Second
+
+// pingContent is the (JSON encoded) content of ping and pong messages. A pong
+// echoes the content of the ping it answers.
+type pingContent struct {
+	ID   string // random ID of the ping
+	Sent int64  // time the ping was sent (in nanoseconds since the epoch)
+}
+
+// isControlMsg returns true, if contentType denotes a control message.
+func isControlMsg(contentType string) bool {
+	return contentType == pingContentType || contentType == pongContentType
+}
+
+// sendControl sends the control message with the given contentType and
+// content from nym to peer directly via the mix (without storing it in the
+// message DB). The message is signed.
+func (ce *CtrlEngine) sendControl(
+	c *cli.Context,
+	nym, peer string,
+	contentType string,
+	content *pingContent,
+	minDelay, maxDelay int32,
+) error {
+	jsn, err := json.Marshal(content)
+	if err != nil {
+		return log.Error(err)
+	}
+	// the subject line is the name of the control message
+	msg := mimeMsg.AddOptions(contentType+"\n"+string(jsn),
+		&mimeMsg.Options{ContentType: contentType})
+	recvNymAddress, err := ce.recvNymAddress(nym, peer)
+	if err != nil {
+		return err
+	}
+	enc, nymaddress, err := mutecryptEncrypt(c, nym, peer, ce.passphrase,
+		[]byte(msg), true, recvNymAddress)
+	if err != nil {
+		return log.Error(err)
+	}
+	token, err := ce.messageToken(nymaddress)
+	if err != nil {
+		return err
+	}
+	env, err := muteprotoCreate(c, enc, minDelay, maxDelay,
+		base64.Encode(token.Token), nymaddress)
+	if err != nil {
+		ce.releaseToken(token, false)
+		return log.Error(err)
+	}
+	ce.releaseToken(token, true)
+	resend, err := ce.deliver(c, env)
+	if err != nil {
+		return err
+	}
+	if resend {
+		return log.Error("ctrlengine: delivery of control message failed, try again later")
+	}
+	return nil
+}
+
+// ping sends a ping from id to contact and reports the used mix delay on w.
+// If wait is greater than zero, new messages are fetched until the pong of
+// contact arrives (the round-trip time is reported on w) or wait has passed.
+func (ce *CtrlEngine) ping(
+	c *cli.Context,
+	w io.Writer,
+	id, contact string,
+	minDelay, maxDelay int32,
+	wait time.Duration,
+) error {
+	nyms, err := ce.getNyms(id, false)
+	if err != nil {
+		return err
+	}
+	nym := nyms[0]
+	contactMapped, err := identity.Map(contact)
+	if err != nil {
+		return err
+	}
+	prev, _, contactType, err := ce.msgDB.GetContact(nym, contactMapped)
+	if err != nil {
+		return err
+	}
+	if prev == "" || contactType != msgdb.WhiteList {
+		return log.Errorf("contact %s not found (for user ID %s)",
+			contactMapped, nym)
+	}
+	content := &pingContent{
+		ID:   cipher.RandPass(cipher.RandReader),
+		Sent: time.Now().UnixNano(),
+	}
+	err = ce.sendControl(c, nym, contactMapped, pingContentType, content,
+		minDelay, maxDelay)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "ping sent to %s (mix delay %d-%ds)\n", contactMapped,
+		minDelay, maxDelay)
+	if wait <= 0 {
+		return nil
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		if err := ce.msgFetch(c, nym, false, ""); err != nil {
+			return err
+		}
+		if rtt, ok := ce.pongs[content.ID]; ok {
+			delete(ce.pongs, content.ID)
+			i18n.Fprintf(w, "pong from %s: round-trip time %s\n", contactMapped,
+				rtt)
+			return nil
+		}
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			break
+		}
+		if remaining > pingPollInterval {
+			remaining = pingPollInterval
+		}
+		time.Sleep(remaining)
+	}
+	fmt.Fprintf(w, "no pong from %s within %s (client might not answer pings)\n",
+		contactMapped, wait)
+	return nil
+}
+
+// handleControl handles the control message plainMsg with the given
+// contentType, which myID received from senderID. Signed pings of white
+// listed contacts are answered with a pong, the round-trip times of pongs
+// are recorded for ping. Failures are only logged, they must not stop the
+// processing of the inqueue.
+func (ce *CtrlEngine) handleControl(
+	c *cli.Context,
+	myID, senderID string,
+	contentType, plainMsg, sig string,
+	whiteListed bool,
+) {
+	_, msg := mimeMsg.SplitOptions(plainMsg)
+	_, jsn := mimeMsg.SplitMessage(msg)
+	var content pingContent
+	if err := json.Unmarshal([]byte(jsn), &content); err != nil {
+		log.Warnf("ctrlengine: invalid control message from %s: %s",
+			senderID, err)
+		return
+	}
+	switch contentType {
+	case pingContentType:
+		if !whiteListed || sig == "" {
+			log.Infof("ctrlengine: ping from %s ignored", senderID)
+			return
+		}
+		err := ce.sendControl(c, myID, senderID, pongContentType, &content,
+			def.MinDelay, def.MaxDelay)
+		if err != nil {
+			log.Warnf("ctrlengine: cannot answer ping from %s: %s", senderID,
+				err)
+			return
+		}
+		log.Infof("ctrlengine: pong sent to %s", senderID)
+	case pongContentType:
+		rtt := time.Since(time.Unix(0, content.Sent))
+		log.Infof("ctrlengine: pong from %s (round-trip time %s)", senderID, rtt)
+		i18n.Fprintf(ce.fileTable.StatusFP,
+			"pong from %s: round-trip time %s\n", senderID, rtt)
+		if ce.pongs == nil {
+			ce.pongs = make(map[string]time.Duration)
+		}
+		ce.pongs[content.ID] = rtt
+	}
+}
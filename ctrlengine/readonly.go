@@ -0,0 +1,53 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"github.com/mutecomm/mute/log"
+	"github.com/urfave/cli"
+)
+
+// readOnlyCommands contains the (full names of the) commands which are
+// allowed in --read-only mode, because they do not modify the databases.
+var readOnlyCommands = map[string]bool{
+	"db version":         true,
+	"db test-passphrase": true,
+	"uid active":         true,
+	"uid list":           true,
+	"contact show":       true,
+	"contact list":       true,
+	"contact blacklist":  true,
+	"msg list":           true,
+	"msg queue":          true,
+	"msg read":           true,
+	"msg export":         true,
+	"group list":         true,
+	"nym list":           true,
+	"nym stats":          true,
+	"wallet pubkey":      true,
+	"wallet balance":     true,
+	"config show":        true,
+	"audit show":         true,
+	"audit verify":       true,
+	"stats":              true,
+	"status":             true,
+	"alias list":         true,
+	"support-bundle":     true,
+	"quit":               true,
+}
+
+// checkReadOnly returns an error, if the command of context c is not allowed
+// in --read-only mode.
+func (ce *CtrlEngine) checkReadOnly(c *cli.Context) error {
+	// global options are prepared without command
+	if !ce.readOnly || c.Command.Name == "" {
+		return nil
+	}
+	if !readOnlyCommands[c.Command.FullName()] {
+		return log.Errorf("ctrlengine: command '%s' is not allowed in "+
+			"--read-only mode", c.Command.FullName())
+	}
+	return nil
+}
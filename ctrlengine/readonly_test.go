@@ -0,0 +1,123 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/keydb"
+	"github.com/mutecomm/mute/msgdb"
+)
+
+func TestReadOnly(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	te.receiveMessage(a, b, "hello", 0, true) // burn after reading
+	te.ce.Close()
+	// inspect the databases with another engine
+	te2 := newTestEngine(t)
+	defer te2.close()
+	defer os.RemoveAll(te2.homedir) // holds the output files of te2
+	te2.homedir = te.homedir
+	te2.passphrase = te.passphrase
+	te2.offline = true
+	// mutating commands are rejected
+	for _, line := range []string{
+		"contact add --id " + a + " --contact carol@mute.berlin",
+		"msg delete --id " + a + " --msgnum 1",
+		"db rekey",
+	} {
+		err := te2.run("--read-only "+line, 0)
+		if err == nil || !strings.Contains(err.Error(), "--read-only") {
+			t.Errorf("%s: should not be allowed in --read-only mode: %v",
+				line, err)
+		}
+	}
+	// read commands succeed
+	if err := te2.run("--read-only contact list --id "+a, 1); err != nil {
+		t.Fatal(err)
+	}
+	if out := te2.output(); !strings.Contains(out, b) {
+		t.Errorf("contact list output %q does not contain %s", out, b)
+	}
+	// messages are neither marked as read nor burned
+	for i := 0; i < 2; i++ {
+		if err := te2.run("--read-only msg read --id "+a+" --msgnum 1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if out := te2.output(); !strings.Contains(out, "hello") {
+			t.Errorf("msg read output %q does not contain message", out)
+		}
+	}
+	// the database cannot be written
+	if err := te2.ce.msgDB.AddContact(a, "carol@mute.berlin",
+		"carol@mute.berlin", "", msgdb.WhiteList); err == nil {
+		t.Error("database should be read-only")
+	}
+}
+
+func TestReadOnlyKeyDB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	_, stop := loopbackMix(t)
+	defer stop()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	alice, aliceUID := newIntegrationEngine(t, a, nil)
+	defer alice.close()
+	bob, _ := newIntegrationEngine(t, b, nil)
+	defer bob.close()
+	bob.lookupUID(b, aliceUID)
+	if err := bob.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	bob.ce.Close()
+	// inspect the databases of Bob with another engine
+	te := newTestEngine(t)
+	defer te.close()
+	defer os.RemoveAll(te.homedir) // holds the output files of te
+	te.homedir = bob.homedir
+	te.passphrase = bob.passphrase
+	te.offline = true
+	// contact show reads the keyDB, but does not write the audit log
+	err := te.run("--read-only contact show --id "+b+" --contact "+a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); !strings.Contains(out, "VERIFIED:\ttrue\n") {
+		t.Errorf("contact show output %q does not contain verified key", out)
+	}
+	_, err = os.Stat(filepath.Join(bob.homedir, auditFilename))
+	if !os.IsNotExist(err) {
+		t.Errorf("audit log written in --read-only mode: %v", err)
+	}
+	// an outdated keyDB is not upgraded
+	keyDB := bob.openKeyDB()
+	err = keyDB.AddValue(keydb.DBVersion, "1")
+	keyDB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = te.run("--read-only db version", 0)
+	if err == nil || !strings.Contains(err.Error(), "cannot upgrade read-only") {
+		t.Errorf("db version should not upgrade keyDB in --read-only mode: %v",
+			err)
+	}
+	keyDB, err = keydb.OpenReadOnly(filepath.Join(bob.homedir, "keys"),
+		bob.passphrase)
+	if err == nil {
+		keyDB.Close()
+		t.Error("keyDB has been upgraded in --read-only mode")
+	}
+}
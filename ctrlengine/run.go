@@ -0,0 +1,60 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/urfave/cli"
+)
+
+// runScript executes the commands in the file filename line by line.
+// Empty lines and comments (starting with #) are ignored. Execution stops at
+// the first failing command, unless continueOnError is set. Errors are
+// reported with the line number of the failing command.
+func (ce *CtrlEngine) runScript(
+	c *cli.Context,
+	filename string,
+	continueOnError bool,
+) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return log.Error(err)
+	}
+	defer fp.Close()
+	var failed int
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		log.Infof("run: %s:%d: %s", filename, n, ln)
+		err := ce.dispatch(c, ln)
+		if err == errExit {
+			log.Info("ctrlengine: stopping script (exit requested)")
+			return nil
+		}
+		if err != nil {
+			err = fmt.Errorf("%s:%d: %s", filename, n, err)
+			if !continueOnError {
+				return log.Error(err)
+			}
+			fmt.Fprintln(ce.fileTable.StatusFP, err)
+			failed++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return log.Error(err)
+	}
+	if failed > 0 {
+		return log.Errorf("ctrlengine: %d command(s) of script failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeScript writes the given script to the home directory of te and
+// returns its filename.
+func (te *testEngine) writeScript(script string) string {
+	filename := filepath.Join(te.homedir, "script.txt")
+	if err := ioutil.WriteFile(filename, []byte(script), 0600); err != nil {
+		te.t.Fatal(err)
+	}
+	return filename
+}
+
+func TestRunCreate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	// `db create` fetches the config from the network and calls mutecrypt
+	if _, err := exec.LookPath("mutecrypt"); err != nil {
+		t.Skip("skipping test, mutecrypt not installed.")
+	}
+	te := newTestEngine(t)
+	defer te.close()
+	script := te.writeScript(`# create databases
+db create --iterations 4096
+
+uid list
+`)
+	if err := te.run("run "+script, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(te.status(), "database files created") {
+		t.Error("db create status missing")
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	script := te.writeScript(`# list user IDs
+uid list
+
+uid edit --id bob@mute.berlin --full-name Bob
+uid list
+`)
+	err := te.run("run "+script, 1)
+	if err == nil {
+		t.Fatal("script with failing command should fail")
+	}
+	if !strings.HasPrefix(err.Error(), script+":4: ") {
+		t.Errorf("error does not report failing line: %s", err)
+	}
+	// continue on error
+	err = te.run("run --continue-on-error "+script, 0)
+	if err == nil {
+		t.Fatal("script with failing command should fail")
+	}
+	if !strings.Contains(te.status(), script+":4: ") {
+		t.Errorf("status does not report failing line: %s", te.status())
+	}
+	if err := te.run("run", 0); err == nil {
+		t.Error("run without script should fail")
+	}
+}
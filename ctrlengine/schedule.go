@@ -0,0 +1,94 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// sendScheduler flushes the out queue in regular intervals while the app mode
+// is running (see app --send-interval). Outgoing messages are thereby sent in
+// batches instead of immediately, which improves anonymity and reduces the
+// number of connections.
+type sendScheduler struct {
+	interval time.Duration
+	flush    func() error  // sends all queued messages
+	now      chan struct{} // triggers an immediate flush
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newSendScheduler returns a new sendScheduler which calls flush every
+// interval, after start has been called.
+func newSendScheduler(interval time.Duration, flush func() error) *sendScheduler {
+	return &sendScheduler{
+		interval: interval,
+		flush:    flush,
+		now:      make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// start starts flushing the out queue in the background.
+func (s *sendScheduler) start() {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Debug("scheduled send")
+				if err := s.flush(); err != nil {
+					log.Errorf("ctrlengine: scheduled send failed: %s", err)
+				}
+			case <-s.now:
+				log.Debug("immediate send")
+				if err := s.flush(); err != nil {
+					log.Errorf("ctrlengine: immediate send failed: %s", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// flushNow makes the scheduler flush the out queue immediately, without
+// waiting for the interval to elapse (see app --send-now). It does not block.
+func (s *sendScheduler) flushNow() {
+	select {
+	case s.now <- struct{}{}:
+	default: // flush already pending
+	}
+}
+
+// shutdown stops flushing the out queue.
+func (s *sendScheduler) shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// resendPolicy is the exponential backoff schedule for the automatic resend of
+// messages whose delivery failed (see app --resend-attempts).
+type resendPolicy struct {
+	backoff    time.Duration // delay before the first resend
+	maxBackoff time.Duration // maximum delay between resends
+	attempts   int           // delivery attempts before a message fails permanently
+}
+
+// delay returns the delay before the next resend of a message whose delivery
+// failed attempts many times: backoff * 2^(attempts-1), at most maxBackoff.
+func (p *resendPolicy) delay(attempts int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempts && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSendScheduler(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	setAuthSecret("secret")
+	defer setAuthSecret("")
+	ah := newAPIHandler(te.ce, nil)
+	// flush delivers all messages in the out queue at once
+	flushed := make(chan int, 10)
+	ah.scheduler = newSendScheduler(200*time.Millisecond, func() error {
+		ah.mutex.Lock()
+		defer ah.mutex.Unlock()
+		var n int
+		for {
+			oqIdx, msg, _, _, _, _, err := te.ce.msgDB.GetOutQueue(a)
+			if err != nil {
+				return err
+			}
+			if msg == "" {
+				break
+			}
+			if err := te.ce.msgDB.RemoveOutQueue(oqIdx, 1500000000); err != nil {
+				return err
+			}
+			n++
+		}
+		flushed <- n
+		return nil
+	})
+	te.queueMessage(a, b, "first", true, false)
+	te.queueMessage(a, b, "second", true, false)
+	// send requests are held until the interval elapses
+	w := apiRequest(ah, "POST", "/api/send?id="+a, "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /api/send: %d %s", w.Code, w.Body.String())
+	}
+	_, msg, _, _, _, _, err := te.ce.msgDB.GetOutQueue(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg == "" {
+		t.Fatal("messages should be held in out queue")
+	}
+	ah.scheduler.start()
+	defer ah.scheduler.shutdown()
+	select {
+	case n := <-flushed:
+		if n != 2 {
+			t.Errorf("%d messages sent together, should be 2", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("out queue not flushed after interval")
+	}
+	// now=true sends immediately (which fails here, because the queued
+	// message cannot be delivered)
+	te.queueMessage(a, b, "third", true, false)
+	w = apiRequest(ah, "POST", "/api/send?id="+a+"&now=true", "")
+	if w.Code == http.StatusAccepted {
+		t.Error("POST /api/send?now=true should not be held")
+	}
+}
+
+func TestResendPolicy(t *testing.T) {
+	policy := &resendPolicy{
+		backoff:    time.Minute,
+		maxBackoff: 5 * time.Minute,
+		attempts:   5,
+	}
+	for attempts, delay := range []time.Duration{
+		1: time.Minute,
+		2: 2 * time.Minute,
+		3: 4 * time.Minute,
+		4: 5 * time.Minute,
+		5: 5 * time.Minute,
+	} {
+		if attempts == 0 {
+			continue
+		}
+		if d := policy.delay(attempts); d != delay {
+			t.Errorf("delay(%d) == %s != %s", attempts, d, delay)
+		}
+	}
+}
+
+func TestSendSchedulerFlushNow(t *testing.T) {
+	flushed := make(chan struct{}, 10)
+	s := newSendScheduler(time.Hour, func() error {
+		flushed <- struct{}{}
+		return nil
+	})
+	s.start()
+	defer s.shutdown()
+	// --send-now does not wait for the interval to elapse
+	s.flushNow()
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("out queue not flushed immediately")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// stateNames and stateHints describe the states reported by the status
+// command and the action expected next from the user.
+var (
+	stateNames = map[int]string{
+		noDBs:       "no database",
+		lockedDBs:   "locked",
+		emptyDBs:    "empty",
+		unlockedDBs: "ready",
+	}
+	stateHints = map[int]string{
+		noDBs:       "create databases with 'db create'",
+		lockedDBs:   "unlock databases by entering the passphrase (in interactive mode or with any database command)",
+		emptyDBs:    "generate a UID with 'uid new'",
+		unlockedDBs: "none",
+	}
+)
+
+// currentState determines the state of the databases in homedir (noDBs,
+// lockedDBs, or unlockedDBs).
+func (ce *CtrlEngine) currentState(homedir string) (int, error) {
+	if ce.msgDB != nil {
+		return unlockedDBs, nil
+	}
+	exists, err := hasMsgDB(homedir)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return noDBs, nil
+	}
+	return lockedDBs, nil
+}
+
+// requireState makes sure that the databases in homedir are in the state
+// required by a command, otherwise an error which tells the user what to do
+// is returned. The states lockedDBs and unlockedDBs only require existing
+// databases, because prepare unlocks them if necessary.
+func (ce *CtrlEngine) requireState(homedir string, required int) error {
+	state, err := ce.currentState(homedir)
+	if err != nil {
+		return err
+	}
+	ce.state = state
+	switch required {
+	case noDBs:
+		if ce.state != noDBs {
+			return log.Errorf("ctrlengine: databases exist already in %s: "+
+				"use another --homedir to create new ones", homedir)
+		}
+	case lockedDBs, unlockedDBs:
+		if ce.state == noDBs {
+			return log.Errorf("ctrlengine: no databases found in %s: "+
+				"you must create them first with 'db create'", homedir)
+		}
+	}
+	return nil
+}
+
+// status writes the current state of the databases in homedir and the action
+// expected next to w. Unlocked databases without any UIDs are reported as
+// empty.
+func (ce *CtrlEngine) status(w io.Writer, homedir string) error {
+	state, err := ce.currentState(homedir)
+	if err != nil {
+		return err
+	}
+	if state == unlockedDBs {
+		nyms, err := ce.msgDB.GetNyms(false)
+		if err != nil {
+			return err
+		}
+		if len(nyms) == 0 {
+			state = emptyDBs
+		}
+	}
+	fmt.Fprintf(w, "state: %s\n", stateNames[state])
+	fmt.Fprintf(w, "next:  %s\n", stateHints[state])
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRequireState(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	// commands which require databases
+	for _, line := range []string{
+		"uid list",
+		"msg send --id alice@mute.berlin",
+		"db rekey",
+	} {
+		err := te.run(line, 0)
+		if err == nil || !strings.Contains(err.Error(), "'db create'") {
+			t.Errorf("%s: should ask to create databases first: %v", line, err)
+		}
+		if te.ce.state != noDBs {
+			t.Errorf("%s: state == %d != noDBs", line, te.ce.state)
+		}
+	}
+	te.seedDBs()
+	// databases must not be created twice
+	err := te.run("db create", 0)
+	if err == nil || !strings.Contains(err.Error(), "exist already") {
+		t.Errorf("db create should refuse existing databases: %v", err)
+	}
+	if te.ce.state != lockedDBs {
+		t.Errorf("state == %d != lockedDBs", te.ce.state)
+	}
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	if te.ce.state != unlockedDBs {
+		t.Errorf("state == %d != unlockedDBs", te.ce.state)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	for _, tc := range []struct {
+		name, hint string
+	}{
+		{"no database", "'db create'"},
+		{"locked", "passphrase"},
+		{"empty", "'uid new'"},
+	} {
+		switch tc.name {
+		case "locked":
+			te.seedDBs()
+		case "empty":
+			if err := te.run("uid list", 1); err != nil {
+				t.Fatal(err)
+			}
+			te.output()
+		}
+		if err := te.run("status", 0); err != nil {
+			t.Fatal(err)
+		}
+		out := te.output()
+		if !strings.Contains(out, "state: "+tc.name+"\n") {
+			t.Errorf("%s: wrong state: %s", tc.name, out)
+		}
+		if !strings.Contains(out, tc.hint) {
+			t.Errorf("%s: hint missing: %s", tc.name, out)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mutecomm/mute/util/dialer"
+)
+
+// statsNetwork shows the network usage of the session (including the usage
+// of spawned engines) and the data cap.
+func (ce *CtrlEngine) statsNetwork(w io.Writer) error {
+	sent, received, err := dialer.Stats()
+	if err != nil {
+		return err
+	}
+	dataCap := dialer.DataCap()
+	fmt.Fprintf(w, "sent:     %d bytes\n", sent)
+	fmt.Fprintf(w, "received: %d bytes\n", received)
+	if dataCap > 0 {
+		fmt.Fprintf(w, "data cap: %d bytes (%d bytes left)\n", dataCap,
+			max64(dataCap-sent-received, 0))
+	} else {
+		fmt.Fprintf(w, "data cap: none\n")
+	}
+	return nil
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
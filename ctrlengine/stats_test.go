@@ -0,0 +1,64 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/util/dialer"
+)
+
+func TestStatsNetwork(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	defer os.Unsetenv("MUTE_DATA_CAP")
+	defer dialer.SetDataCap(0)
+	// other tests of this process might have used the network already
+	sent, received, err := dialer.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("--data-cap 1000000 stats --network", 0); err != nil {
+		t.Fatal(err)
+	}
+	out := te.output()
+	if !strings.Contains(out, fmt.Sprintf("sent:     %d bytes", sent)) {
+		t.Errorf("unexpected output: %s", out)
+	}
+	left := 1000000 - sent - received
+	if !strings.Contains(out, fmt.Sprintf("data cap: 1000000 bytes (%d bytes left)", left)) {
+		t.Errorf("unexpected output: %s", out)
+	}
+	if os.Getenv("MUTE_DATA_CAP") != "1000000" {
+		t.Error("data cap not passed to spawned engines")
+	}
+	if err := te.run("stats", 0); err == nil {
+		t.Error("stats without --network should fail")
+	}
+}
+
+func TestStaleNetstats(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	// netstats file left behind by a crashed session
+	stale := filepath.Join(te.homedir, netstatsPrefix+"1")
+	if err := ioutil.WriteFile(stale, []byte("1000 1000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("stats --network", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("stale netstats file not removed")
+	}
+	if out := te.output(); strings.Contains(out, "sent:     1000") {
+		t.Errorf("usage of crashed session counted: %s", out)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/mutecomm/mute/def/version"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/util/i18n"
+	"github.com/urfave/cli"
+)
+
+// logTailSize is the maximum number of bytes included from the end of every
+// log file in a support bundle.
+const logTailSize = 1 << 20
+
+// redacted replaces secrets in support bundles.
+const redacted = "[REDACTED]"
+
+var (
+	// secretValueRegexp matches key=value (or key: value) pairs of settings
+	// which contain secrets. The value is replaced.
+	secretValueRegexp = regexp.MustCompile(`(?i)((?:passphrase|password|secret|token|privkey|private[ _-]?key)[^\s=:]*\s*[=:]\s*)\S+`)
+	// secretBlobRegexp matches long base64 or hex strings (like keys, tokens,
+	// and signatures), which are replaced completely.
+	secretBlobRegexp = regexp.MustCompile(`[A-Za-z0-9+/_-]{32,}={0,2}`)
+)
+
+// redact removes passphrases, tokens, and private keys from text.
+func redact(text []byte) []byte {
+	text = secretValueRegexp.ReplaceAll(text, []byte("${1}"+redacted))
+	return secretBlobRegexp.ReplaceAll(text, []byte(redacted))
+}
+
+// readLogTail returns the last logTailSize bytes of the log file filename.
+func readLogTail(filename string) ([]byte, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	if fi.Size() > logTailSize {
+		if _, err := fp.Seek(-logTailSize, io.SeekEnd); err != nil {
+			return nil, log.Error(err)
+		}
+	}
+	data, err := ioutil.ReadAll(fp)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	return data, nil
+}
+
+// supportVersion writes the version and build information to w.
+func (ce *CtrlEngine) supportVersion(w io.Writer) {
+	fmt.Fprintf(w, "mutectrl=%s\n", version.Number)
+	fmt.Fprintf(w, "go=%s %s\n", runtime.Compiler, runtime.Version())
+	fmt.Fprintf(w, "platform=%s/%s\n", runtime.GOOS, runtime.GOARCH)
+	msgDBVersion, err := ce.msgDB.Version()
+	if err != nil {
+		fmt.Fprintf(w, "msgdb=error: %s\n", err)
+	} else {
+		fmt.Fprintf(w, "msgdb=%s\n", msgDBVersion)
+	}
+}
+
+// supportDB writes the status and the integrity check results of the
+// databases to w. Errors are written to w instead of being returned, the
+// bundle should be created in any case.
+func (ce *CtrlEngine) supportDB(c *cli.Context, w io.Writer) {
+	fmt.Fprintf(w, "msgdb:\n")
+	autoVacuum, freelistCount, err := ce.msgDB.Status()
+	if err != nil {
+		fmt.Fprintf(w, "status=error: %s\n", err)
+	} else {
+		fmt.Fprintf(w, "auto_vacuum=%s\n", autoVacuum)
+		fmt.Fprintf(w, "freelist_count=%d\n", freelistCount)
+	}
+	integrity, err := ce.msgDB.Integrity()
+	if err != nil {
+		fmt.Fprintf(w, "integrity=error: %s\n", err)
+	} else {
+		fmt.Fprintf(w, "integrity=%s\n", strings.Replace(integrity, "\n", "; ", -1))
+	}
+	// keyDB status and integrity are reported by mutecrypt (as "keydb:"
+	// followed by key=value lines)
+	fmt.Fprintf(w, "keydb:\n")
+	var buf bytes.Buffer
+	if err := mutecryptDBStatus(c, &buf, ce.passphrase); err != nil {
+		fmt.Fprintf(w, "status=error: %s\n", err)
+	} else {
+		w.Write(bytes.TrimPrefix(buf.Bytes(), []byte("keydb:\n")))
+	}
+	buf.Reset()
+	if err := mutecryptDBIntegrity(c, &buf, ce.passphrase); err != nil {
+		fmt.Fprintf(w, "integrity=error: %s\n", err)
+	} else {
+		w.Write(bytes.TrimPrefix(buf.Bytes(), []byte("keydb:\n")))
+	}
+}
+
+// supportBundle writes a zip file out with the information usually requested
+// for bug reports: version and build information, database status and
+// integrity, the effective configuration, and the end of the log files. All
+// contents are redacted of passphrases, tokens, and private keys.
+func (ce *CtrlEngine) supportBundle(
+	c *cli.Context,
+	statusfp io.Writer,
+	out string,
+) error {
+	fp, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return log.Error(err)
+	}
+	defer fp.Close()
+	zw := zip.NewWriter(fp)
+	add := func(name string, data []byte) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return log.Error(err)
+		}
+		if _, err := f.Write(redact(data)); err != nil {
+			return log.Error(err)
+		}
+		return nil
+	}
+	// version and build information
+	var buf bytes.Buffer
+	ce.supportVersion(&buf)
+	if err := add("version.txt", buf.Bytes()); err != nil {
+		return err
+	}
+	// database status and integrity
+	buf.Reset()
+	ce.supportDB(c, &buf)
+	if err := add("db.txt", buf.Bytes()); err != nil {
+		return err
+	}
+	// effective configuration (contains no server keys)
+	values, err := effectiveConfig(c)
+	if err != nil {
+		return err
+	}
+	jsn, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return log.Error(err)
+	}
+	if err := add("config.json", jsn); err != nil {
+		return err
+	}
+	// end of log files
+	logs, err := filepath.Glob(filepath.Join(c.GlobalString("logdir"), "*.log*"))
+	if err != nil {
+		return log.Error(err)
+	}
+	for _, filename := range logs {
+		data, err := readLogTail(filename)
+		if err != nil {
+			return err
+		}
+		if err := add("logs/"+filepath.Base(filename), data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return log.Error(err)
+	}
+	i18n.Fprintf(statusfp, "support bundle with %d log file(s) written to '%s'\n",
+		len(logs), out)
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/def/version"
+	"github.com/mutecomm/mute/msgdb"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"passphrase=hunter2", "passphrase=" + redacted},
+		{"Token: abc", "Token: " + redacted},
+		{"privkey = 1234", "privkey = " + redacted},
+		{"key " + strings.Repeat("Ab1+", 11) + "==.", "key " + redacted + "."},
+		{"user alice@mute.berlin sent 3 messages", "user alice@mute.berlin sent 3 messages"},
+	}
+	for _, test := range tests {
+		if out := string(redact([]byte(test.in))); out != test.out {
+			t.Errorf("redact(%q) == %q != %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestSupportBundle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+	defer installEngines(t)()
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	te.seedKeyDB()
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	walletKey, err := te.ce.msgDB.GetValue(msgdb.WalletKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// log file with secrets
+	secrets := []string{string(te.passphrase), walletKey, "t0k3n"}
+	logfile := filepath.Join(te.homedir, "log", "mutectrl.log")
+	content := "read passphrase: " + secrets[0] + "\n" +
+		"loaded wallet key " + secrets[1] + "\n" +
+		"spent token=" + secrets[2] + "\n" +
+		"fetch config done\n"
+	if err := ioutil.WriteFile(logfile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(te.homedir, "bundle.zip")
+	if err := te.run("support-bundle --out "+out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("support-bundle --out "+out, 0); err == nil {
+		t.Error("existing bundle should not be overwritten")
+	}
+	zr, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(data)
+	}
+	for _, name := range []string{"version.txt", "db.txt", "config.json", "logs/mutectrl.log"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle does not contain %s", name)
+		}
+	}
+	if !strings.Contains(files["version.txt"], "mutectrl="+version.Number) {
+		t.Errorf("version.txt == %q", files["version.txt"])
+	}
+	// integrity results for both databases
+	db := files["db.txt"]
+	i := strings.Index(db, "keydb:\n")
+	if i < 0 || !strings.Contains(db[:i], "integrity=ok\n") ||
+		!strings.Contains(db[i:], "freelist_count=") ||
+		!strings.Contains(db[i:], "integrity=ok\n") {
+		t.Errorf("db.txt == %q", db)
+	}
+	if !strings.Contains(files["logs/mutectrl.log"], "fetch config done") {
+		t.Errorf("log == %q", files["logs/mutectrl.log"])
+	}
+	for name, data := range files {
+		for _, secret := range secrets {
+			if strings.Contains(data, secret) {
+				t.Errorf("%s contains secret %q", name, secret)
+			}
+		}
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 
 	"github.com/frankbraun/codechain/util/bzero"
@@ -49,11 +50,7 @@ func mutecryptNewUID(
 	client *client.Client,
 ) error {
 	log.Infof("mutecryptNewUID(): id=%s, domain=%s", id, domain)
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-	}
+	args := mutecryptArgs(c)
 	if host != "" {
 		args = append(args,
 			"--keyhost", host,
@@ -271,11 +268,7 @@ func mutecryptHashchainSearch(
 	id, host string,
 	passphrase []byte,
 ) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-	}
+	args := mutecryptArgs(c)
 	if host != "" {
 		args = append(args,
 			"--keyhost", host,
@@ -303,6 +296,40 @@ func mutecryptHashchainSearch(
 	return nil
 }
 
+// pendingAccountPrefix is the prefix of the msgDB keys which store the keys
+// of accounts whose UID registration has not been completed yet.
+const pendingAccountPrefix = "pending-account."
+
+// pendingAccountKey returns the private key of the account for the UID id
+// which is being registered. The key is kept in msgDB until the registration
+// has been completed, so that a retry pays the same account with the same
+// reserved token.
+func (ce *CtrlEngine) pendingAccountKey(id string) (*[ed25519.PrivateKeySize]byte, error) {
+	var privkey [ed25519.PrivateKeySize]byte
+	pk, err := ce.msgDB.GetValue(pendingAccountPrefix + id)
+	if err != nil {
+		return nil, err
+	}
+	if pk != "" {
+		k, err := base64.Decode(pk)
+		if err != nil {
+			return nil, err
+		}
+		copy(privkey[:], k)
+		return &privkey, nil
+	}
+	_, sk, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	copy(privkey[:], sk)
+	err = ce.msgDB.AddValue(pendingAccountPrefix+id, base64.Encode(privkey[:]))
+	if err != nil {
+		return nil, err
+	}
+	return &privkey, nil
+}
+
 func (ce *CtrlEngine) uidNew(
 	c *cli.Context,
 	minDelay, maxDelay int32,
@@ -337,25 +364,28 @@ func (ce *CtrlEngine) uidNew(
 		return log.Error(ErrUserIDTaken)
 	}
 
-	// get token from wallet
-	token, err := wallet.GetToken(ce.client, def.AccdUsage, def.AccdOwner)
+	// get token from wallet (a failed previous attempt reserved it already)
+	spendKey := accountSpendKey(id, "")
+	token, err := wallet.GetTokenOnce(ce.client, ce.msgDB, spendKey,
+		def.AccdUsage, def.AccdOwner)
 	if err != nil {
 		return err
 	}
 
 	// register account for  UID
-	_, sk, err := ed25519.GenerateKey(cipher.RandReader)
+	privkey, err := ce.pendingAccountKey(id)
 	if err != nil {
-		return log.Error(err)
+		return err
 	}
-	var privkey [ed25519.PrivateKeySize]byte
-	copy(privkey[:], sk)
-	server, err := mixclient.PayAccount(&privkey, token.Token, "", def.CACert)
+	server, err := mixclient.PayAccount(privkey, token.Token, "", def.CACert)
 	if err != nil {
-		ce.client.UnlockToken(token.Hash)
+		// keep token reserved, we do not know whether it was consumed
 		return log.Error(err)
 	}
-	ce.client.DelToken(token.Hash)
+	err = wallet.EndSpend(ce.client, ce.msgDB, spendKey, token, true)
+	if err != nil {
+		return err
+	}
 
 	// generate secret for account
 	var secret [64]byte
@@ -368,8 +398,9 @@ func (ce *CtrlEngine) uidNew(
 	singleUse := false                // TODO correct?
 	var pubkey [ed25519.PublicKeySize]byte
 	copy(pubkey[:], privkey[32:])
-	mixaddress, nymaddress, err := util.NewNymAddress(domain, secret[:], expire,
-		singleUse, minDelay, maxDelay, id, &pubkey, server, def.CACert)
+	mixaddress, nymaddress, receiverKey, err := util.NewNymAddressKey(domain,
+		secret[:], expire, singleUse, minDelay, maxDelay, id, &pubkey, server,
+		def.CACert)
 	if err != nil {
 		return err
 	}
@@ -387,11 +418,21 @@ func (ce *CtrlEngine) uidNew(
 	}
 
 	// register account for UID
-	err = ce.msgDB.AddAccount(id, "", &privkey, server, &secret,
+	err = ce.msgDB.AddAccount(id, "", privkey, server, &secret,
 		minDelay, maxDelay)
 	if err != nil {
 		return err
 	}
+	if err := ce.msgDB.DelValue(pendingAccountPrefix + id); err != nil {
+		return err
+	}
+
+	// store nym address of KeyInit message (retired by `nym rotate`)
+	err = ce.msgDB.AddNymAddress(id, mixaddress, nymaddress, receiverKey,
+		expire)
+	if err != nil {
+		return err
+	}
 
 	// set active UID, if this was the first UID
 	active, err := ce.msgDB.GetValue(msgdb.ActiveUID)
@@ -403,6 +444,8 @@ func (ce *CtrlEngine) uidNew(
 			return err
 		}
 	}
+	ce.audit(&AuditEntry{Type: AuditUIDRegistered, MyID: id})
+	ce.checkLowBalance(ce.fileTable.StatusFP, def.AccdUsage, "UID", "Message")
 	return nil
 }
 
@@ -454,14 +497,11 @@ func (ce *CtrlEngine) uidSwitch(unmappedID string) error {
 }
 
 func mutecryptDeleteUID(c *cli.Context, id string, passphrase []byte) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
+	args := mutecryptArgs(c,
 		"uid", "delete",
 		"--id", id,
 		"--force",
-	}
+	)
 	cmd := exec.Command("mutecrypt", args...)
 	var errbuf bytes.Buffer
 	cmd.Stderr = &errbuf
@@ -482,7 +522,7 @@ func mutecryptDeleteUID(c *cli.Context, id string, passphrase []byte) error {
 func (ce *CtrlEngine) uidDelete(
 	c *cli.Context,
 	unmappedID string,
-	force bool,
+	force, dryRun bool,
 	statfp io.Writer,
 ) error {
 	mappedID, err := identity.Map(unmappedID)
@@ -499,6 +539,11 @@ func (ce *CtrlEngine) uidDelete(
 		return log.Errorf("ctrlengine: user ID '%s' unknown", unmappedID)
 	}
 
+	// only report what would be deleted
+	if dryRun {
+		return ce.uidDeleteDryRun(mappedID, prev, statfp)
+	}
+
 	// ask for manual confirmation
 	if !force {
 		fmt.Fprintf(statfp, "ctrlengine: delete user ID %s and all contacts and messages? ",
@@ -551,6 +596,35 @@ func (ce *CtrlEngine) uidDelete(
 	return nil
 }
 
+// uidDeleteDryRun reports on statfp what uidDelete would delete for the user
+// ID mappedID.
+func (ce *CtrlEngine) uidDeleteDryRun(
+	mappedID, unmappedID string,
+	statfp io.Writer,
+) error {
+	util.WouldDelete(statfp, "user ID(s)", 1, unmappedID)
+	var contacts []string
+	for _, blocked := range []bool{false, true} {
+		list, err := ce.msgDB.GetContacts(mappedID, blocked)
+		if err != nil {
+			return err
+		}
+		contacts = append(contacts, list...)
+	}
+	util.WouldDelete(statfp, "contact(s)", int64(len(contacts)), contacts...)
+	msgIDs, err := ce.msgDB.GetMsgIDs(mappedID)
+	if err != nil {
+		return err
+	}
+	util.WouldDelete(statfp, "message(s)", int64(len(msgIDs)))
+	accounts, err := ce.msgDB.GetAccounts(mappedID)
+	if err != nil {
+		return err
+	}
+	util.WouldDelete(statfp, "account(s)", int64(len(accounts)))
+	return nil
+}
+
 func (ce *CtrlEngine) uidList(outfp io.Writer) error {
 	nyms, err := ce.msgDB.GetNyms(false)
 	if err != nil {
@@ -562,3 +636,148 @@ func (ce *CtrlEngine) uidList(outfp io.Writer) error {
 	}
 	return nil
 }
+
+// keyDBUIDState is the state of an own user ID in the keyDB, as reported by
+// `mutecrypt uid status`.
+type keyDBUIDState struct {
+	ID         string // the mapped user ID
+	Registered bool   // the current UID message has been registered
+	KeyInits   int    // number of currently valid KeyInit messages
+}
+
+func mutecryptUIDStatus(
+	c *cli.Context,
+	passphrase []byte,
+) ([]keyDBUIDState, error) {
+	args := mutecryptArgs(c, "uid", "status")
+	cmd := exec.Command("mutecrypt", args...)
+	var outbuf bytes.Buffer
+	cmd.Stdout = &outbuf
+	var errbuf bytes.Buffer
+	cmd.Stderr = &errbuf
+	ppR, ppW, err := os.Pipe()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer ppR.Close()
+	ppW.Write(passphrase)
+	ppW.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, ppR)
+	if err := cmd.Run(); err != nil {
+		return nil, log.Errorf("%s: %s", err, strings.TrimSpace(errbuf.String()))
+	}
+	var states []keyDBUIDState
+	if err := json.Unmarshal(outbuf.Bytes(), &states); err != nil {
+		return nil, log.Error(err)
+	}
+	return states, nil
+}
+
+// uidState is the reconciled state of an own user ID across keyDB and msgDB.
+type uidState struct {
+	ID         string // unmapped user ID (mapped, if only known to keyDB)
+	Generated  bool   // the user ID has private key material in the keyDB
+	Registered bool   // the user ID has been registered with the key server
+	MsgDB      bool   // the user ID is known to the msgDB
+	Active     bool   // the user ID is the active one
+	KeyInits   int    // number of currently valid KeyInit messages
+}
+
+// states returns the list of states s is in.
+func (s *uidState) states() []string {
+	var states []string
+	if s.Generated {
+		states = append(states, "generated")
+	}
+	if s.Registered {
+		states = append(states, "registered")
+	}
+	if s.MsgDB {
+		states = append(states, "msgdb")
+	}
+	if s.Active {
+		states = append(states, "active")
+	}
+	if s.KeyInits > 0 {
+		states = append(states, fmt.Sprintf("keyinit(%d)", s.KeyInits))
+	}
+	return states
+}
+
+// reconcileUIDs cross-references the keyDB states with the nyms in msgDB and
+// returns the state of every own user ID known to either of them.
+func (ce *CtrlEngine) reconcileUIDs(keyDBStates []keyDBUIDState) ([]uidState, error) {
+	active, err := ce.msgDB.GetValue(msgdb.ActiveUID)
+	if err != nil {
+		return nil, err
+	}
+	if active != "" {
+		active, err = identity.Map(active)
+		if err != nil {
+			return nil, err
+		}
+	}
+	nyms, err := ce.msgDB.GetNyms(true)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]*uidState)
+	var mappedIDs []string
+	for _, nym := range nyms {
+		unmapped, _, err := ce.msgDB.GetNym(nym)
+		if err != nil {
+			return nil, err
+		}
+		states[nym] = &uidState{
+			ID:     unmapped,
+			MsgDB:  true,
+			Active: nym == active,
+		}
+		mappedIDs = append(mappedIDs, nym)
+	}
+	for _, ks := range keyDBStates {
+		s, ok := states[ks.ID]
+		if !ok {
+			s = &uidState{ID: ks.ID}
+			states[ks.ID] = s
+			mappedIDs = append(mappedIDs, ks.ID)
+		}
+		s.Generated = true
+		s.Registered = ks.Registered
+		s.KeyInits = ks.KeyInits
+	}
+	sort.Strings(mappedIDs)
+	list := make([]uidState, 0, len(mappedIDs))
+	for _, id := range mappedIDs {
+		list = append(list, *states[id])
+	}
+	return list, nil
+}
+
+// uidStatus shows the state of all own user IDs across keyDB and msgDB.
+func (ce *CtrlEngine) uidStatus(
+	c *cli.Context,
+	w io.Writer,
+	jsonOutput bool,
+) error {
+	keyDBStates, err := mutecryptUIDStatus(c, ce.passphrase)
+	if err != nil {
+		return err
+	}
+	states, err := ce.reconcileUIDs(keyDBStates)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		jsn, err := json.MarshalIndent(states, "", "  ")
+		if err != nil {
+			return log.Error(err)
+		}
+		fmt.Fprintln(w, string(jsn))
+		return nil
+	}
+	for _, s := range states {
+		fmt.Fprintf(w, "%s\t%s\n", s.ID, strings.Join(s.states(), ","))
+	}
+	return nil
+}
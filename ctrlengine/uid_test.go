@@ -0,0 +1,31 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"testing"
+)
+
+func TestUIDList(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("uid list", 1); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "" {
+		t.Errorf("uid list on new DB should be empty: %q", out)
+	}
+	a := "alice@mute.berlin"
+	if err := te.ce.msgDB.AddNym(a, a, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("uid list", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te.output(); out != "Alice <"+a+">\n" {
+		t.Errorf("uid list: unexpected output: %q", out)
+	}
+}
@@ -6,6 +6,8 @@ package ctrlengine
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -18,6 +20,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/configclient"
 	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/log"
 	mixclient "github.com/mutecomm/mute/mix/client"
@@ -33,14 +37,45 @@ import (
 
 type getPastExecution func(mappedID string) (int64, error)
 
+// nextExecution returns the time of the next execution of mappedID after the
+// past one for the given period, delayed by a jitter of at most maxJitter
+// (with second granularity). The jitter spreads the load of clients which
+// share the same schedule on the server. It is derived from a hash of
+// mappedID and the past execution, so it stays the same for all checks
+// within a period (otherwise the task would run as soon as one of the
+// repeated checks drew a small jitter).
+func nextExecution(
+	mappedID string,
+	past int64,
+	period, maxJitter time.Duration,
+) time.Time {
+	next := time.Unix(past, 0).Add(period)
+	if maxJitter > 0 {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(past))
+		h := cipher.SHA256(append([]byte(mappedID), buf[:]...))
+		n := uint64(maxJitter/time.Second) + 1
+		jitter := binary.BigEndian.Uint64(h[:8]) % n
+		next = next.Add(time.Duration(jitter) * time.Second)
+	}
+	return next
+}
+
 func checkExecution(
-	mappedID, period string,
+	mappedID, period, jitter string,
 	getPast getPastExecution,
 ) (bool, int64, error) {
 	duration, err := time.ParseDuration(period)
 	if err != nil {
 		return false, 0, err
 	}
+	maxJitter, err := time.ParseDuration(jitter)
+	if err != nil {
+		return false, 0, err
+	}
+	if maxJitter < 0 {
+		return false, 0, log.Error("ctrlengine: jitter must not be negative")
+	}
 	now := time.Now().UTC()
 	if duration == 0 {
 		// always execution for 0 duration
@@ -51,8 +86,8 @@ func checkExecution(
 		return false, 0, err
 	}
 	if past != 0 {
-		if time.Unix(past, 0).Add(duration).After(now) {
-			return false, 0, err
+		if nextExecution(mappedID, past, duration, maxJitter).After(now) {
+			return false, 0, nil
 		}
 	}
 	return true, now.Unix(), nil
@@ -61,7 +96,8 @@ func checkExecution(
 func (ce *CtrlEngine) upkeepAll(
 	c *cli.Context,
 	unmappedID,
-	period string,
+	period,
+	jitter string,
 	statfp io.Writer,
 ) error {
 	mappedID, err := identity.Map(unmappedID)
@@ -69,7 +105,7 @@ func (ce *CtrlEngine) upkeepAll(
 		return err
 	}
 
-	exec, now, err := checkExecution(mappedID, period,
+	exec, now, err := checkExecution(mappedID, period, jitter,
 		func(mappedID string) (int64, error) {
 			return ce.msgDB.GetUpkeepAll(mappedID)
 		})
@@ -82,8 +118,8 @@ func (ce *CtrlEngine) upkeepAll(
 		return nil
 	}
 
-	// `upkeep accounts`
-	if err := ce.upkeepAccounts(unmappedID, period, "2160h", statfp); err != nil {
+	// `upkeep accounts` (already delayed by jitter above)
+	if err := ce.upkeepAccounts(unmappedID, period, "0s", "2160h", statfp); err != nil {
 		return err
 	}
 
@@ -106,6 +142,35 @@ func writeConfigFile(homedir, domain string, config []byte) error {
 	return os.Rename(tmpfile, filepath.Join(configdir, domain))
 }
 
+// fetchConfig updates config. A failed update is retried up to retries many
+// times, the first retry after backoff and every further retry after twice
+// the previous duration. Waiting is aborted, if ctx is cancelled.
+func fetchConfig(
+	ctx context.Context,
+	config *configclient.Config,
+	retries int,
+	backoff time.Duration,
+	statfp io.Writer,
+) error {
+	for i := 0; ; i++ {
+		err := config.Update()
+		if err == nil {
+			return nil
+		}
+		if i >= retries {
+			return err
+		}
+		log.Warnf("fetch config failed (%s), retry in %s", err, backoff)
+		fmt.Fprintf(statfp, "fetch config failed, retry in %s\n", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
 func (ce *CtrlEngine) upkeepFetchconf(
 	msgDB *msgdb.MsgDB,
 	homedir string,
@@ -122,7 +187,9 @@ func (ce *CtrlEngine) upkeepFetchconf(
 	ce.config.PublicKey = publicKey
 	ce.config.URLList = "10," + configURL
 	ce.config.Timeout = 0 // use default timeout
-	if err := ce.config.Update(); err != nil {
+	err = fetchConfig(ce.ctx, &ce.config, ce.fetchconfRetries,
+		ce.fetchconfBackoff, statfp)
+	if err != nil {
 		return log.Error(err)
 	}
 	jsn, err := json.Marshal(ce.config)
@@ -149,6 +216,7 @@ func (ce *CtrlEngine) upkeepFetchconf(
 	if err := writeConfigFile(homedir, netDomain, jsn); err != nil {
 		return err
 	}
+	ce.events.emit(&Event{Type: EventConfigUpdate})
 	// show new configuration
 	if show {
 		fmt.Fprintf(outfp, string(jsn)+"\n")
@@ -322,7 +390,7 @@ func (ce *CtrlEngine) upkeepUpdate(
 }
 
 func (ce *CtrlEngine) upkeepAccounts(
-	unmappedID, period, remaining string,
+	unmappedID, period, jitter, remaining string,
 	statfp io.Writer,
 ) error {
 	mappedID, err := identity.Map(unmappedID)
@@ -330,7 +398,7 @@ func (ce *CtrlEngine) upkeepAccounts(
 		return err
 	}
 
-	exec, now, err := checkExecution(mappedID, period,
+	exec, now, err := checkExecution(mappedID, period, jitter,
 		func(mappedID string) (int64, error) {
 			return ce.msgDB.GetUpkeepAccounts(mappedID)
 		})
@@ -373,16 +441,22 @@ func (ce *CtrlEngine) upkeepAccounts(
 			}
 		}
 		if times.Now()+int64(remain.Seconds()) >= last {
-			token, err := wallet.GetToken(ce.client, def.AccdUsage, def.AccdOwner)
+			spendKey := accountSpendKey(mappedID, contact)
+			token, err := wallet.GetTokenOnce(ce.client, ce.msgDB, spendKey,
+				def.AccdUsage, def.AccdOwner)
 			if err != nil {
 				return err
 			}
 			_, err = mixclient.PayAccount(privkey, token.Token, server, def.CACert)
 			if err != nil {
-				ce.client.UnlockToken(token.Hash)
+				// keep token reserved, we do not know whether it was consumed
 				return log.Error(err)
 			}
-			ce.client.DelToken(token.Hash)
+			err = wallet.EndSpend(ce.client, ce.msgDB, spendKey, token, true)
+			if err != nil {
+				return err
+			}
+			ce.checkLowBalance(ce.fileTable.StatusFP, def.AccdUsage)
 			last, err = mixclient.AccountStat(privkey, server, def.CACert)
 			if err != nil {
 				return err
@@ -403,11 +477,7 @@ func mutecryptHashchainSync(
 	domain, host string,
 	passphrase []byte,
 ) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-	}
+	args := mutecryptArgs(c)
 	if host != "" {
 		args = append(args,
 			"--keyhost", host,
@@ -439,11 +509,7 @@ func mutecryptHashchainValidate(
 	domain, host string,
 	passphrase []byte,
 ) error {
-	args := []string{
-		"--homedir", c.GlobalString("homedir"),
-		"--loglevel", c.GlobalString("loglevel"),
-		"--logdir", c.GlobalString("logdir"),
-	}
+	args := mutecryptArgs(c)
 	if host != "" {
 		args = append(args,
 			"--keyhost", host,
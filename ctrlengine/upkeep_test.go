@@ -0,0 +1,160 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutecomm/mute/configclient"
+	"github.com/mutecomm/mute/configclient/sortedmap"
+)
+
+// fakeConfigServer returns a config server which fails for the first failures
+// many requests and serves a signed configuration afterwards.
+func fakeConfigServer(t *testing.T, failures int) (*httptest.Server, []byte, *int) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var privKey [ed25519.PrivateKeySize]byte
+	copy(privKey[:], privateKey)
+	cert, err := sortedmap.StringMap{"key": "value"}.GenerateCertificate(&privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(cert)
+	}))
+	return srv, publicKey, &requests
+}
+
+func TestFetchConfigRetry(t *testing.T) {
+	srv, publicKey, requests := fakeConfigServer(t, 2)
+	defer srv.Close()
+	config := configclient.Config{
+		PublicKey: publicKey,
+		URLList:   "10," + strings.TrimPrefix(srv.URL, "http://"),
+	}
+	err := fetchConfig(context.Background(), &config, 3, time.Millisecond,
+		ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 3 {
+		t.Errorf("requests == %d != 3", *requests)
+	}
+	if config.Map["key"] != "value" {
+		t.Error("config not updated")
+	}
+}
+
+func TestFetchConfigNoRetry(t *testing.T) {
+	srv, publicKey, requests := fakeConfigServer(t, 2)
+	defer srv.Close()
+	config := configclient.Config{
+		PublicKey: publicKey,
+		URLList:   "10," + strings.TrimPrefix(srv.URL, "http://"),
+	}
+	// without retries (as in --offline mode) the first failure is final
+	err := fetchConfig(context.Background(), &config, 0, time.Millisecond,
+		ioutil.Discard)
+	if err == nil {
+		t.Fatal("fetchConfig should fail")
+	}
+	if *requests != 1 {
+		t.Errorf("requests == %d != 1", *requests)
+	}
+}
+
+func TestFetchConfigCancel(t *testing.T) {
+	srv, publicKey, requests := fakeConfigServer(t, 2)
+	defer srv.Close()
+	config := configclient.Config{
+		PublicKey: publicKey,
+		URLList:   "10," + strings.TrimPrefix(srv.URL, "http://"),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := fetchConfig(ctx, &config, 3, time.Hour, ioutil.Discard)
+	if err != context.Canceled {
+		t.Fatalf("fetchConfig should be canceled: %v", err)
+	}
+	if *requests != 1 {
+		t.Errorf("requests == %d != 1", *requests)
+	}
+}
+
+func TestNextExecution(t *testing.T) {
+	past := int64(1500000000)
+	period := time.Hour
+	earliest := time.Unix(past, 0).Add(period)
+	// without jitter the next execution is exactly one period later
+	next := nextExecution("alice@mute.berlin", past, period, 0)
+	if !next.Equal(earliest) {
+		t.Errorf("next == %s != %s", next, earliest)
+	}
+	// with jitter it is delayed by at most jitter
+	jitter := 10 * time.Minute
+	latest := earliest.Add(jitter)
+	var delayed bool
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("user%d@mute.berlin", i)
+		next := nextExecution(id, past, period, jitter)
+		if next.Before(earliest) || next.After(latest) {
+			t.Fatalf("next == %s not in [%s, %s]", next, earliest, latest)
+		}
+		if next.After(earliest) {
+			delayed = true
+		}
+		// the jitter does not change between checks
+		if again := nextExecution(id, past, period, jitter); !again.Equal(next) {
+			t.Errorf("next == %s != %s", again, next)
+		}
+	}
+	if !delayed {
+		t.Error("jitter never delayed the next execution")
+	}
+}
+
+func TestCheckExecutionJitter(t *testing.T) {
+	lastRun := func(past int64) getPastExecution {
+		return func(string) (int64, error) { return past, nil }
+	}
+	now := time.Now().Unix()
+	// due: last execution is more than period plus jitter ago
+	exec, _, err := checkExecution("", "1h", "10m", lastRun(now-4200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exec {
+		t.Error("execution should be due")
+	}
+	// not due: last execution is less than period ago
+	exec, _, err = checkExecution("", "1h", "10m", lastRun(now-3000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec {
+		t.Error("execution should not be due")
+	}
+	if _, _, err := checkExecution("", "1h", "-1m", lastRun(now)); err == nil {
+		t.Error("negative jitter should fail")
+	}
+}
@@ -7,9 +7,15 @@ package ctrlengine
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
 
+	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/util/i18n"
 )
 
 func printWalletKey(w io.Writer, privkey string) error {
@@ -29,7 +35,7 @@ func (ce *CtrlEngine) walletPubkey(w io.Writer) error {
 	return printWalletKey(w, privkey)
 }
 
-func (ce *CtrlEngine) walletBalance(w io.Writer) error {
+func (ce *CtrlEngine) walletBalance(w, statusfp io.Writer) error {
 	msgSelf := ce.client.GetBalanceOwn("Message")
 	msgNonSelf := ce.client.GetBalance("Message", nil)
 	uidSelf := ce.client.GetBalanceOwn("UID")
@@ -39,5 +45,205 @@ func (ce *CtrlEngine) walletBalance(w io.Writer) error {
 	fmt.Fprintf(w, "Message: self:%8d; non-self:%8d; total=%8d\n", msgSelf, msgNonSelf, msgSelf+msgNonSelf)
 	fmt.Fprintf(w, "UID:     self:%8d; non-self:%8d; total=%8d\n", uidSelf, uidNonSelf, uidSelf+uidNonSelf)
 	fmt.Fprintf(w, "Account: self:%8d; non-self:%8d; total=%8d\n", accSelf, accNonSelf, accSelf+accNonSelf)
+	ce.warnLowBalance(statusfp, []tokenBalance{
+		{"Message", msgSelf + msgNonSelf},
+		{"UID", uidSelf + uidNonSelf},
+		{"Account", accSelf + accNonSelf},
+	})
+	return nil
+}
+
+// tokenBalance is the number of available tokens of one usage.
+type tokenBalance struct {
+	Usage  string // token usage (Message, UID, ...)
+	Tokens int64  // number of available tokens
+}
+
+// warnLowBalance writes a warning to statusfp for every balance which is
+// below the low-balance threshold (see --low-balance).
+func (ce *CtrlEngine) warnLowBalance(statusfp io.Writer, balances []tokenBalance) {
+	if ce.lowBalance == 0 {
+		return
+	}
+	for _, b := range balances {
+		if b.Tokens < ce.lowBalance {
+			log.Warnf("ctrlengine: low balance of %s tokens: %d", b.Usage,
+				b.Tokens)
+			i18n.Fprintf(statusfp, "warning: only %d %s token(s) left, please "+
+				"top up your wallet\n", b.Tokens, b.Usage)
+		}
+	}
+}
+
+// checkLowBalance checks the wallet balance of the given usages after tokens
+// have been spent and warns about low balances (see warnLowBalance).
+func (ce *CtrlEngine) checkLowBalance(statusfp io.Writer, usages ...string) {
+	if ce.client == nil {
+		return
+	}
+	var balances []tokenBalance
+	for _, usage := range usages {
+		balances = append(balances, tokenBalance{usage,
+			ce.client.GetBalanceOwn(usage) + ce.client.GetBalance(usage, nil)})
+	}
+	ce.warnLowBalance(statusfp, balances)
+}
+
+// tokenCost is the number of tokens of one usage required by an operation.
+type tokenCost struct {
+	Usage  string // token usage (Message, UID, ...)
+	Tokens int64  // number of required tokens
+}
+
+// pricePrefix is the prefix of the configuration values which define the unit
+// price of a token per usage (e.g., "serviceguard.Price.UID").
+const pricePrefix = "serviceguard.Price."
+
+// configTokenPrice returns the unit price of a token with the given usage from
+// the server configuration.
+func (ce *CtrlEngine) configTokenPrice(usage string) (int64, error) {
+	p, ok := ce.config.Map[pricePrefix+usage]
+	if !ok {
+		return 0, log.Errorf("ctrlengine: unknown price of %s tokens", usage)
+	}
+	price, err := strconv.ParseInt(p, 10, 64)
+	if err != nil || price < 0 {
+		return 0, log.Errorf("ctrlengine: cannot parse config.Map[\"%s\"]",
+			pricePrefix+usage)
+	}
+	return price, nil
+}
+
+// estimateUIDNew returns the tokens required by uid new: one for the mix
+// account, one for the UID registration, and one for the KeyInit message.
+func (ce *CtrlEngine) estimateUIDNew() []tokenCost {
+	return []tokenCost{
+		{Usage: def.AccdUsage, Tokens: 1},
+		{Usage: "UID", Tokens: 1},
+		{Usage: "Message", Tokens: 1},
+	}
+}
+
+// estimateKeyInitAdd returns the tokens required to add count KeyInit
+// messages (one token each).
+func (ce *CtrlEngine) estimateKeyInitAdd(count int64) []tokenCost {
+	return []tokenCost{{Usage: "Message", Tokens: count}}
+}
+
+// estimateMsgSend returns the tokens required by msg send for id (or all user
+// IDs): one for every queued message. The loopback transport requires no
+// payment.
+func (ce *CtrlEngine) estimateMsgSend(id string, all bool) ([]tokenCost, error) {
+	nyms, err := ce.getNyms(id, all)
+	if err != nil {
+		return nil, err
+	}
+	var n int64
+	if ce.loopback == nil {
+		for _, nym := range nyms {
+			msgs, err := ce.msgDB.GetQueuedMsgs(nym)
+			if err != nil {
+				return nil, err
+			}
+			n += int64(len(msgs))
+		}
+	}
+	return []tokenCost{{Usage: "Message", Tokens: n}}, nil
+}
+
+// walletEstimate writes the costs of the required tokens to w, one line per
+// usage (number of tokens times unit price) followed by the total.
+func (ce *CtrlEngine) walletEstimate(w io.Writer, costs []tokenCost) error {
+	var total int64
+	for _, cost := range costs {
+		price, err := ce.tokenPrice(cost.Usage)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s:\t%d x %d = %d\n", cost.Usage, cost.Tokens, price,
+			cost.Tokens*price)
+		total += cost.Tokens * price
+	}
+	fmt.Fprintf(w, "TOTAL:\t%d\n", total)
+	return nil
+}
+
+// accountSpendKey returns the idempotency key for payments of the account of
+// myID for contact (see wallet.GetTokenOnce).
+func accountSpendKey(myID, contact string) string {
+	return "account." + myID + "." + contact
+}
+
+// readTokens returns the base64 encoded tokens contained in filename, one
+// token per line. Empty lines are ignored.
+func readTokens(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			tokens = append(tokens, line)
+		}
+	}
+	return tokens, nil
+}
+
+// clientError returns the description of the wallet client error err,
+// including the underlying cause (if any).
+func (ce *CtrlEngine) clientError(err error) string {
+	if ce.client.LastError != nil {
+		return err.Error() + ": " + ce.client.LastError.Error()
+	}
+	return err.Error()
+}
+
+// importToken verifies the base64 encoded token tkn against the known verify
+// keys and adds it to the wallet. It returns the usage of the token.
+func (ce *CtrlEngine) importToken(tkn string) (string, error) {
+	raw, err := base64.Decode(tkn)
+	if err != nil {
+		return "", err
+	}
+	ce.client.LastError = nil
+	entry, err := ce.client.Verify(raw)
+	if err != nil {
+		return "", log.Errorf("ctrlengine: invalid token: %s",
+			ce.clientError(err))
+	}
+	if entry.OwnerPubKey == nil {
+		return "", log.Error("ctrlengine: token has no owner")
+	}
+	if err := ce.client.ReceiveToken(entry.Usage, raw); err != nil {
+		return "", log.Errorf("ctrlengine: cannot import token: %s",
+			ce.clientError(err))
+	}
+	return entry.Usage, nil
+}
+
+// walletImport imports the base64 encoded tokens which have been delivered
+// out of band into the wallet. Tokens which cannot be imported are reported
+// on statusfp and do not prevent the import of the remaining ones.
+func (ce *CtrlEngine) walletImport(statusfp io.Writer, tokens []string) error {
+	// startWallet only loads the verify keys if we are online, use the
+	// stored ones otherwise
+	if !ce.client.IsOnline() {
+		ce.client.GetVerifyKeys()
+	}
+	var failed int
+	for i, tkn := range tokens {
+		usage, err := ce.importToken(tkn)
+		if err != nil {
+			fmt.Fprintf(statusfp, "token %d: %s\n", i+1, err)
+			failed++
+			continue
+		}
+		i18n.Fprintf(statusfp, "token %d: imported %s token\n", i+1, usage)
+	}
+	if failed > 0 {
+		return log.Errorf("ctrlengine: %d of %d token(s) could not be imported",
+			failed, len(tokens))
+	}
 	return nil
 }
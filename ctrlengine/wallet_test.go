@@ -0,0 +1,328 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/serviceguard/client"
+	"github.com/mutecomm/mute/serviceguard/client/packetproto"
+	"github.com/mutecomm/mute/serviceguard/client/walletstore"
+	"github.com/mutecomm/mute/serviceguard/common/keypool"
+	"github.com/mutecomm/mute/serviceguard/common/keypool/keydb"
+	"github.com/mutecomm/mute/serviceguard/common/signkeys"
+	"github.com/mutecomm/mute/serviceguard/common/token"
+	"github.com/mutecomm/mute/util/times"
+	"github.com/mutecomm/mute/util/wallet"
+	"github.com/ronperry/cryptoedge/jjm"
+)
+
+func TestWalletEstimate(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	te.seedContact(a, b)
+	te.queueMessage(a, b, "sent", true, true)
+	te.queueMessage(a, b, "encrypted", true, false)
+	te.queueMessage(a, b, "pending", false, false)
+	te.queueMessage(a, b, "pending", false, false)
+	// mock unit prices
+	prices := map[string]int64{def.AccdUsage: 2, "UID": 5, "Message": 3}
+	te.ce.tokenPrice = func(usage string) (int64, error) {
+		price, ok := prices[usage]
+		if !ok {
+			return 0, log.Errorf("unknown price of %s tokens", usage)
+		}
+		return price, nil
+	}
+	testCases := []struct {
+		line string
+		exp  string
+	}{
+		{"wallet estimate msg-send --id " + a,
+			"Message:\t3 x 3 = 9\nTOTAL:\t9\n"},
+		{"wallet estimate keyinit-add --count 4",
+			"Message:\t4 x 3 = 12\nTOTAL:\t12\n"},
+		{"wallet estimate uid-new",
+			"Account:\t1 x 2 = 2\nUID:\t1 x 5 = 5\nMessage:\t1 x 3 = 3\nTOTAL:\t10\n"},
+	}
+	for _, tc := range testCases {
+		if err := te.run(tc.line, 0); err != nil {
+			t.Fatal(err)
+		}
+		if out := te.output(); out != tc.exp {
+			t.Errorf("%s: unexpected output:\n%s", tc.line, out)
+		}
+	}
+	// unknown price
+	delete(prices, "UID")
+	if err := te.run("wallet estimate uid-new", 0); err == nil {
+		t.Error("wallet estimate should fail with unknown price")
+	}
+}
+
+func TestConfigTokenPrice(t *testing.T) {
+	var ce CtrlEngine
+	ce.config.Map = map[string]string{
+		pricePrefix + "Message": "3",
+		pricePrefix + "UID":     "x",
+	}
+	if price, err := ce.configTokenPrice("Message"); err != nil {
+		t.Error(err)
+	} else if price != 3 {
+		t.Errorf("price of Message tokens %d != 3", price)
+	}
+	if _, err := ce.configTokenPrice("UID"); err == nil {
+		t.Error("configTokenPrice should fail with unparsable price")
+	}
+	if _, err := ce.configTokenPrice(def.AccdUsage); err == nil {
+		t.Error("configTokenPrice should fail with unknown price")
+	}
+}
+
+func TestLowBalanceWarning(t *testing.T) {
+	var ce CtrlEngine
+	ce.lowBalance = 5
+	var status bytes.Buffer
+	// above (or at) threshold
+	ce.warnLowBalance(&status, []tokenBalance{{"Message", 5}, {"UID", 10}})
+	if status.Len() > 0 {
+		t.Errorf("unexpected warning: %s", status.String())
+	}
+	// below threshold
+	ce.warnLowBalance(&status, []tokenBalance{{"Message", 4}, {"UID", 10}})
+	if st := status.String(); !strings.Contains(st, "only 4 Message token(s) left") ||
+		strings.Contains(st, "UID") {
+		t.Errorf("unexpected warning: %s", st)
+	}
+	// disabled
+	status.Reset()
+	ce.lowBalance = 0
+	ce.warnLowBalance(&status, []tokenBalance{{"Message", 0}})
+	if status.Len() > 0 {
+		t.Errorf("unexpected warning: %s", status.String())
+	}
+
+	// the (empty) wallet is below the threshold
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("--low-balance 1 wallet balance", 1); err != nil {
+		t.Fatal(err)
+	}
+	if st := te.status(); !strings.Contains(st, "only 0 Account token(s) left") {
+		t.Errorf("missing warning: %s", st)
+	}
+}
+
+func TestGetTokenOnce(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("wallet balance", 1); err != nil {
+		t.Fatal(err)
+	}
+	// fill wallet with two account tokens
+	ws, err := walletstore.New(te.ce.msgDB.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tkn := range []string{"token1", "token2"} {
+		err := ws.SetToken(client.TokenEntry{
+			Hash:        cipher.SHA256([]byte(tkn)),
+			Token:       []byte(tkn),
+			OwnerPubKey: def.AccdOwner,
+			Usage:       def.AccdUsage,
+			Expire:      times.Now() + 3600,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	balance := func() int64 {
+		return te.ce.client.GetBalance(def.AccdUsage, def.AccdOwner)
+	}
+	if b := balance(); b != 2 {
+		t.Fatalf("balance == %d != 2", b)
+	}
+	key := accountSpendKey("alice@mute.berlin", "")
+	token, err := wallet.GetTokenOnce(te.ce.client, te.ce.msgDB, key,
+		def.AccdUsage, def.AccdOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// spend fails midway (the token is kept reserved), retry reuses token
+	retry, err := wallet.GetTokenOnce(te.ce.client, te.ce.msgDB, key,
+		def.AccdUsage, def.AccdOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(retry.Hash, token.Hash) {
+		t.Error("retry should reuse reserved token")
+	}
+	if b := balance(); b != 1 {
+		t.Errorf("balance == %d != 1 during retry", b)
+	}
+	// retry succeeds
+	err = wallet.EndSpend(te.ce.client, te.ce.msgDB, key, retry, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(); b != 1 {
+		t.Errorf("balance == %d != 1, exactly one token should be consumed", b)
+	}
+	if _, err := te.ce.client.GetReservedToken(token.Hash); err == nil {
+		t.Error("spent token should be deleted")
+	}
+	// the next operation gets the remaining token
+	next, err := wallet.GetTokenOnce(te.ce.client, te.ce.msgDB, key,
+		def.AccdUsage, def.AccdOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(next.Hash, token.Hash) {
+		t.Error("next operation should get a new token")
+	}
+	err = wallet.EndSpend(te.ce.client, te.ce.msgDB, key, next, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(); b != 1 {
+		t.Errorf("balance == %d != 1 after unlock", b)
+	}
+}
+
+// issueToken returns a Message token owned by owner, signed by
+// a new signing key (stored in db) which is itself signed by the verify key.
+func issueToken(
+	t *testing.T,
+	owner *[ed25519.PublicKeySize]byte,
+	verifyPub ed25519.PublicKey,
+	verifyPriv ed25519.PrivateKey,
+	db interface{},
+) *token.Token {
+	gen := signkeys.New(packetproto.Curve, packetproto.Rand,
+		packetproto.HashFunc)
+	gen.Usage = "Message"
+	gen.PublicKey = new([ed25519.PublicKeySize]byte)
+	gen.PrivateKey = new([ed25519.PrivateKeySize]byte)
+	copy(gen.PublicKey[:], verifyPub)
+	copy(gen.PrivateKey[:], verifyPriv)
+	signKey, err := gen.GenKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// store signing key where the wallet looks it up
+	kp := keypool.New(gen)
+	if err := keydb.Add(kp, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := kp.WriteKey(&signKey.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	// blind signature
+	tkn := token.New(&signKey.PublicKey.KeyID, owner)
+	pubkey := &signKey.PublicKey.PublicKey
+	server := jjm.NewGenericBlindingServer(signKey.PrivateKey, pubkey, gen.Curve)
+	blindClient := jjm.NewGenericBlindingClient(pubkey, gen.Curve)
+	clientParams, serverParams, err := server.GetParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clearMessage := jjm.NewClearMessage(tkn.Hash())
+	factors, blindMessage, err := blindClient.Blind(clientParams, clearMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blindSig, err := server.Sign(serverParams, blindMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _, err := blindClient.Unblind(factors, clearMessage, blindSig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clearSig := sig.(jjm.ClearSignature)
+	tkn.AddSignature(&clearSig)
+	return tkn
+}
+
+func TestWalletImport(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("wallet balance", 1); err != nil {
+		t.Fatal(err)
+	}
+	// make verify key known to the wallet
+	verifyPub, verifyPriv, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := walletstore.New(te.ce.msgDB.DB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vk [ed25519.PublicKeySize]byte
+	copy(vk[:], verifyPub)
+	ws.SetVerifyKeys([][ed25519.PublicKeySize]byte{vk})
+	// issue token for wallet
+	wk, err := te.ce.msgDB.GetValue(msgdb.WalletKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walletKey, err := decodeWalletKey(wk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owner [ed25519.PublicKeySize]byte
+	copy(owner[:], walletKey[32:])
+	tkn := issueToken(t, &owner, verifyPub, verifyPriv, te.ce.msgDB.DB())
+	// tampered token is rejected
+	tampered := *tkn
+	tampered.Nonce = append([]byte{}, tkn.Nonce...)
+	tampered.Nonce[0] ^= 0xff
+	m, err := tampered.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("wallet import --token "+base64.Encode(m), 0); err == nil {
+		t.Error("tampered token should be rejected")
+	}
+	if !strings.Contains(te.status(), "invalid token") {
+		t.Errorf("unexpected status: %q", te.status())
+	}
+	if b := te.ce.client.GetBalance("Message", nil); b != 0 {
+		t.Errorf("balance == %d != 0", b)
+	}
+	// valid token is imported
+	m, err = tkn.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := te.run("wallet import --token "+base64.Encode(m), 0); err != nil {
+		t.Fatal(err)
+	}
+	if b := te.ce.client.GetBalance("Message", nil); b != 1 {
+		t.Errorf("balance == %d != 1", b)
+	}
+	if !strings.Contains(te.status(), "imported Message token") {
+		t.Errorf("unexpected status: %q", te.status())
+	}
+	// the same token cannot be imported twice
+	if err := te.run("wallet import --token "+base64.Encode(m), 0); err == nil {
+		t.Error("known token should be rejected")
+	}
+}
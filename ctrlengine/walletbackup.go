@@ -0,0 +1,234 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/frankbraun/codechain/util/bzero"
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/cipher/aes256"
+	"github.com/mutecomm/mute/encdb"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msgdb"
+	"github.com/mutecomm/mute/serviceguard/client"
+	"github.com/mutecomm/mute/util/i18n"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// walletBackupVersion is the current version of wallet backups.
+const walletBackupVersion = 1
+
+// walletBackupFile is the (JSON encoded) format of a wallet backup file. Data
+// contains the AES-256 encrypted walletContent (in CTR mode, with prepended
+// IV), MAC the HMAC of Data. Both keys are derived from the backup passphrase
+// with PBKDF2.
+type walletBackupFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Data       []byte `json:"data"`
+	MAC        []byte `json:"mac"`
+}
+
+// walletContent is the content of a wallet backup.
+type walletContent struct {
+	WalletKey string               // private wallet key (base64 encoded)
+	Tokens    []*client.TokenEntry // all tokens of the wallet
+}
+
+// deriveBackupKeys derives the encryption and the MAC key of a wallet backup
+// from passphrase.
+func deriveBackupKeys(passphrase, salt []byte, iter int) (encKey, macKey []byte) {
+	dk := pbkdf2.Key(passphrase, salt, iter, 64, sha256.New)
+	return dk[:32], dk[32:]
+}
+
+// encryptWalletBackup encrypts backup under passphrase.
+func encryptWalletBackup(backup *walletContent, passphrase []byte) ([]byte, error) {
+	jsn, err := json.Marshal(backup)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer bzero.Bytes(jsn)
+	file := &walletBackupFile{
+		Version:    walletBackupVersion,
+		Iterations: encdb.KDFIterations,
+		Salt:       make([]byte, 32),
+	}
+	if _, err := io.ReadFull(cipher.RandReader, file.Salt); err != nil {
+		return nil, log.Error(err)
+	}
+	encKey, macKey := deriveBackupKeys(passphrase, file.Salt, file.Iterations)
+	file.Data = aes256.CTREncrypt(encKey, jsn, cipher.RandReader)
+	file.MAC = cipher.HMAC(macKey, file.Data)
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// decryptWalletBackup decrypts the wallet backup data with passphrase.
+func decryptWalletBackup(data, passphrase []byte) (*walletContent, error) {
+	var file walletBackupFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, log.Errorf("ctrlengine: cannot parse wallet backup: %s", err)
+	}
+	if file.Version != walletBackupVersion {
+		return nil, log.Errorf("ctrlengine: unsupported wallet backup version %d",
+			file.Version)
+	}
+	if file.Iterations < 1 {
+		return nil, log.Error("ctrlengine: invalid wallet backup iterations")
+	}
+	encKey, macKey := deriveBackupKeys(passphrase, file.Salt, file.Iterations)
+	if !hmac.Equal(file.MAC, cipher.HMAC(macKey, file.Data)) {
+		return nil, log.Error("ctrlengine: wrong passphrase or corrupt wallet backup")
+	}
+	jsn := aes256.CTRDecrypt(encKey, file.Data)
+	defer bzero.Bytes(jsn)
+	var backup walletContent
+	if err := json.Unmarshal(jsn, &backup); err != nil {
+		return nil, log.Errorf("ctrlengine: cannot parse wallet backup: %s", err)
+	}
+	return &backup, nil
+}
+
+// readBackupPassphrase reads the wallet backup passphrase from the passphrase
+// file descriptor. If confirm is set, the passphrase is read twice.
+func (ce *CtrlEngine) readBackupPassphrase(statusfp io.Writer, confirm bool) ([]byte, error) {
+	i18n.Fprintf(statusfp, "read backup passphrase from fd %d (not echoed)\n",
+		ce.fileTable.PassphraseFD)
+	log.Infof("read backup passphrase from fd %d (not echoed)",
+		ce.fileTable.PassphraseFD)
+	passphrase, err := ce.readPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("done")
+	if confirm {
+		i18n.Fprintf(statusfp,
+			"read backup passphrase from fd %d again (not echoed)\n",
+			ce.fileTable.PassphraseFD)
+		log.Infof("read backup passphrase from fd %d again (not echoed)",
+			ce.fileTable.PassphraseFD)
+		passphrase2, err := ce.readPassphrase()
+		if err != nil {
+			bzero.Bytes(passphrase)
+			return nil, err
+		}
+		defer bzero.Bytes(passphrase2)
+		log.Info("done")
+		if !bytes.Equal(passphrase, passphrase2) {
+			bzero.Bytes(passphrase)
+			return nil, log.Error(ErrPassphrasesDiffer)
+		}
+	}
+	if len(passphrase) == 0 {
+		return nil, log.Error(ErrEmptyPassphrase)
+	}
+	return passphrase, nil
+}
+
+// walletBackup writes the private wallet key and all tokens of the wallet to
+// filename, encrypted under a backup passphrase.
+func (ce *CtrlEngine) walletBackup(statusfp io.Writer, filename string) error {
+	// make sure backup does not exist already
+	if _, err := os.Stat(filename); err == nil {
+		return log.Errorf("ctrlengine: wallet backup '%s' exists already",
+			filename)
+	}
+	walletKey, err := ce.msgDB.GetValue(msgdb.WalletKey)
+	if err != nil {
+		return err
+	}
+	tokens, err := ce.client.Tokens()
+	if err != nil {
+		return log.Error(err)
+	}
+	passphrase, err := ce.readBackupPassphrase(statusfp, true)
+	if err != nil {
+		return err
+	}
+	defer bzero.Bytes(passphrase)
+	data, err := encryptWalletBackup(&walletContent{
+		WalletKey: walletKey,
+		Tokens:    tokens,
+	}, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		return log.Error(err)
+	}
+	i18n.Fprintf(statusfp, "wallet with %d token(s) backed up to '%s'\n",
+		len(tokens), filename)
+	return nil
+}
+
+// walletRestore restores the wallet backup filename. An existing different
+// wallet which still contains tokens is only replaced, if force is set.
+func (ce *CtrlEngine) walletRestore(
+	statusfp io.Writer,
+	filename string,
+	force bool,
+) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return log.Error(err)
+	}
+	passphrase, err := ce.readBackupPassphrase(statusfp, false)
+	if err != nil {
+		return err
+	}
+	defer bzero.Bytes(passphrase)
+	backup, err := decryptWalletBackup(data, passphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := decodeWalletKey(backup.WalletKey); err != nil {
+		return log.Errorf("ctrlengine: invalid wallet key in backup: %s", err)
+	}
+	// check for different wallet
+	walletKey, err := ce.msgDB.GetValue(msgdb.WalletKey)
+	if err != nil {
+		return err
+	}
+	if walletKey != backup.WalletKey {
+		// the wallet key might be in use even if the wallet holds no tokens
+		// (for example, tokens bought by it are still pending)
+		if walletKey != "" && !force {
+			tokens, err := ce.client.Tokens()
+			if err != nil {
+				return log.Error(err)
+			}
+			return log.Errorf("ctrlengine: database contains a different "+
+				"wallet with %d token(s), use --force to replace it",
+				len(tokens))
+		}
+		// switch to restored wallet key
+		if err := ce.msgDB.AddValue(msgdb.WalletKey, backup.WalletKey); err != nil {
+			return err
+		}
+		online := ce.client.IsOnline()
+		ce.client.GoOffline()
+		ce.client, err = startWallet(ce.msgDB, !online)
+		if err != nil {
+			return err
+		}
+	}
+	// restore tokens
+	for _, tokenEntry := range backup.Tokens {
+		if err := ce.client.AddToken(tokenEntry); err != nil {
+			return log.Error(err)
+		}
+	}
+	i18n.Fprintf(statusfp, "wallet with %d token(s) restored from '%s'\n",
+		len(backup.Tokens), filename)
+	return nil
+}
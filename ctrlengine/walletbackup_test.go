@@ -0,0 +1,136 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ctrlengine
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/serviceguard/client"
+	"github.com/mutecomm/mute/serviceguard/client/walletstore"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// addTokens adds n dummy tokens with the given usage, which are owned by
+// the wallet itself.
+func (te *testEngine) addTokens(usage string, n int) {
+	ws, err := walletstore.New(te.ce.msgDB.DB())
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		pub, priv, err := ed25519.GenerateKey(cipher.RandReader)
+		if err != nil {
+			te.t.Fatal(err)
+		}
+		var tokenEntry client.TokenEntry
+		tokenEntry.Token = []byte(cipher.RandPass(cipher.RandReader))
+		tokenEntry.Hash = cipher.SHA256(tokenEntry.Token)
+		tokenEntry.OwnerPubKey = new([ed25519.PublicKeySize]byte)
+		tokenEntry.OwnerPrivKey = new([ed25519.PrivateKeySize]byte)
+		copy(tokenEntry.OwnerPubKey[:], pub)
+		copy(tokenEntry.OwnerPrivKey[:], priv)
+		tokenEntry.Usage = usage
+		tokenEntry.Expire = times.Now() + 3600
+		if err := ws.SetToken(tokenEntry); err != nil {
+			te.t.Fatal(err)
+		}
+	}
+}
+
+func TestWalletBackupRestore(t *testing.T) {
+	te := newTestEngine(t)
+	defer te.close()
+	te.seedDBs()
+	if err := te.run("wallet pubkey", 1); err != nil {
+		t.Fatal(err)
+	}
+	pubkey := te.output()
+	te.addTokens("Message", 2)
+	te.addTokens(def.AccdUsage, 1)
+	filename := filepath.Join(te.homedir, "wallet.bak")
+	if err := te.run("wallet backup --file "+filename, 2); err != nil {
+		t.Fatal(err)
+	}
+	// an existing backup is not overwritten
+	if err := te.run("wallet backup --file "+filename, 0); err == nil {
+		t.Error("existing wallet backup should not be overwritten")
+	}
+
+	// restore into a new DB with an empty, but different wallet
+	te3 := newTestEngine(t)
+	defer te3.close()
+	te3.passphrase = te.passphrase
+	te3.seedDBs()
+	err := te3.run("wallet restore --file "+filename, 2)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("restore should refuse to replace empty wallet: %v", err)
+	}
+	if err := te3.run("wallet restore --force --file "+filename, 1); err != nil {
+		t.Fatal(err)
+	}
+	if b := te3.ce.client.GetBalanceOwn("Message"); b != 2 {
+		t.Errorf("Message balance == %d != 2", b)
+	}
+
+	// restore into a different DB which holds tokens of a different wallet
+	te2 := newTestEngine(t)
+	defer te2.close()
+	te2.passphrase = te.passphrase
+	te2.seedDBs()
+	if err := te2.run("wallet balance", 1); err != nil {
+		t.Fatal(err)
+	}
+	te2.output()
+	te2.addTokens("UID", 1)
+	err = te2.run("wallet restore --file "+filename, 1)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("restore should refuse to replace different wallet: %v", err)
+	}
+	if err := te2.run("wallet restore --force --file "+filename, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := te2.run("wallet pubkey", 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := te2.output(); out != pubkey {
+		t.Errorf("restored wallet pubkey %q != %q", out, pubkey)
+	}
+	if b := te2.ce.client.GetBalanceOwn("Message"); b != 2 {
+		t.Errorf("Message balance == %d != 2", b)
+	}
+	if b := te2.ce.client.GetBalanceOwn(def.AccdUsage); b != 1 {
+		t.Errorf("%s balance == %d != 1", def.AccdUsage, b)
+	}
+	// restoring the same wallet again merges the tokens
+	if err := te2.run("wallet restore --file "+filename, 1); err != nil {
+		t.Fatal(err)
+	}
+	if b := te2.ce.client.GetBalanceOwn("Message"); b != 2 {
+		t.Errorf("Message balance == %d != 2", b)
+	}
+}
+
+func TestWalletBackupWrongPassphrase(t *testing.T) {
+	data, err := encryptWalletBackup(&walletContent{WalletKey: "key"},
+		[]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptWalletBackup(data, []byte("wrong")); err == nil {
+		t.Error("decryption with wrong passphrase should fail")
+	}
+	backup, err := decryptWalletBackup(data, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.WalletKey != "key" {
+		t.Errorf("backup.WalletKey == %q", backup.WalletKey)
+	}
+}
@@ -179,6 +179,22 @@ const (
 	// mix.
 	MinMaxDelay = 61
 
+	// HighPriorityMinDelay defines the minimum delay setting for high priority
+	// messages to mix.
+	HighPriorityMinDelay = int32(MinMinDelay)
+
+	// HighPriorityMaxDelay defines the maximum delay setting for high priority
+	// messages to mix.
+	HighPriorityMaxDelay = int32(120)
+
+	// LowPriorityMinDelay defines the minimum delay setting for low priority
+	// messages to mix.
+	LowPriorityMinDelay = int32(300)
+
+	// LowPriorityMaxDelay defines the maximum delay setting for low priority
+	// messages to mix.
+	LowPriorityMaxDelay = int32(900)
+
 	// FetchconfMinDuration defines the minimum duration between automatic
 	// configuration fetches.
 	FetchconfMinDuration = 24 * time.Hour // 24h
@@ -187,9 +203,29 @@ const (
 	// configuration fetches.
 	FetchconfMaxDuration = 7 * 24 * time.Hour // 7d
 
+	// FetchconfRetries defines the default number of retries of a failed
+	// configuration fetch.
+	FetchconfRetries = 3
+
+	// FetchconfBackoff defines the default duration to wait before the first
+	// retry of a failed configuration fetch (doubled for every further retry).
+	FetchconfBackoff = 2 * time.Second
+
+	// KeyServerCacheSize defines the default maximum number of key servers
+	// cached by mutecrypt.
+	KeyServerCacheSize = 100
+
+	// KeyServerCacheTTL defines the default duration after which cached key
+	// server capabilities expire in mutecrypt.
+	KeyServerCacheTTL = 24 * time.Hour // 24h
+
 	// UpdateDuration defines the maximum duration before an enforced update.
 	UpdateDuration = 14 * 24 * time.Hour // 14d
 
+	// LowBalance defines the default token balance per usage below which a
+	// warning is shown (0 disables the warning).
+	LowBalance = 3
+
 	// WalletGetTokenMaxDuration defines the maximum duration before the
 	// acquisition of a token from the wallet is aborted.
 	WalletGetTokenMaxDuration = 5 * time.Minute // 5m
@@ -29,10 +29,24 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/mutecomm/go-sqlcipher/v4"
 )
 
+// readOnlyDriver is the name of the SQL driver used by OpenReadOnly. It
+// rejects all writes of its connections.
+const readOnlyDriver = "sqlite3_readonly"
+
+func init() {
+	sql.Register(readOnlyDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			_, err := conn.Exec("PRAGMA query_only = ON;", nil)
+			return err
+		},
+	})
+}
+
 // DBSuffix defines the suffix for database files.
 const DBSuffix = ".db"
 
@@ -62,25 +76,21 @@ func createTables(db *sql.DB, createStmts []string) error {
 // In case of error (for example, the database files do exist already or
 // cannot be created) an error is returned.
 func Create(dbname string, passphrase []byte, iter int, createStmts []string) error {
+	return CreateKDF(dbname, passphrase, PBKDF2(iter), createStmts)
+}
+
+// CreateKDF is like Create, but uses the given kdf to derive the key for the
+// keyfile from the passphrase. The KDF and its parameters are recorded in the
+// keyfile, Open uses them automatically.
+func CreateKDF(dbname string, passphrase []byte, kdf *KDF, createStmts []string) error {
 	dbfile := dbname + DBSuffix
 	keyfile := dbname + KeySuffix
 	// make sure files do not exist already
-	exists, err := fileExists(dbfile)
-	if err != nil {
+	if err := checkFiles(dbfile, keyfile); err != nil {
 		return err
 	}
-	if exists {
-		return fmt.Errorf("encdb: dbfile '%s' exists already", dbfile)
-	}
-	exists, err = fileExists(keyfile)
-	if err != nil {
-		return err
-	}
-	if exists {
-		return fmt.Errorf("encdb: keyfile '%s' exists already", keyfile)
-	}
 	// create keyfile
-	key, err := generateKeyfile(keyfile, passphrase, iter)
+	key, err := generateKeyfile(keyfile, passphrase, kdf)
 	if err != nil {
 		return err
 	}
@@ -117,6 +127,36 @@ func Create(dbname string, passphrase []byte, iter int, createStmts []string) er
 	return nil
 }
 
+// checkFiles makes sure that dbfile and keyfile do not exist already.
+func checkFiles(dbfile, keyfile string) error {
+	exists, err := fileExists(dbfile)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("encdb: dbfile '%s' exists already", dbfile)
+	}
+	exists, err = fileExists(keyfile)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("encdb: keyfile '%s' exists already", keyfile)
+	}
+	return nil
+}
+
+// CheckCreate checks if an encrypted database with the given dbname and iter
+// many KDF iterations could be created with Create, without creating
+// anything. That is, the database files must not exist already and iter must
+// be in the valid range.
+func CheckCreate(dbname string, iter int) error {
+	if err := checkIter(iter); err != nil {
+		return err
+	}
+	return checkFiles(dbname+DBSuffix, dbname+KeySuffix)
+}
+
 // Open tries to open an encrypted database with the given passphrase.
 // Thereby, dbname is the prefix of the following two database files (which
 // must already exist):
@@ -125,8 +165,71 @@ func Create(dbname string, passphrase []byte, iter int, createStmts []string) er
 //  dbname.key
 //
 // In case of error (for example, the database files do not exist or the
-// passphrase is wrong) an error is returned.
+// passphrase is wrong) an error is returned. A wrong passphrase results in
+// ErrWrongPassphrase, damaged database files in ErrCorruptDB, and files which
+// are not an encrypted Mute database in ErrNotMuteDB. If a Limiter has been
+// set with SetLimiter, Open is delayed after consecutive wrong passphrases.
 func Open(dbname string, passphrase []byte) (*sql.DB, error) {
+	if limiter != nil {
+		limiter.wait(dbname)
+	}
+	db, err := open(dbname, passphrase, "sqlite3")
+	if limiter != nil {
+		limiter.record(dbname, err)
+	}
+	return db, err
+}
+
+// OpenReadOnly is like Open, but all writes to the returned database fail.
+func OpenReadOnly(dbname string, passphrase []byte) (*sql.DB, error) {
+	if limiter != nil {
+		limiter.wait(dbname)
+	}
+	db, err := open(dbname, passphrase, readOnlyDriver)
+	if limiter != nil {
+		limiter.record(dbname, err)
+	}
+	return db, err
+}
+
+// CheckPassphrase checks whether passphrase is the correct passphrase of the
+// encrypted database dbname without opening it for writes. If the keyfile
+// contains a key check value, only the keyfile is read. Otherwise, the
+// database is opened read-only to test the key and closed again. A wrong
+// passphrase results in ErrWrongPassphrase (and is delayed by the Limiter,
+// like Open).
+func CheckPassphrase(dbname string, passphrase []byte) error {
+	if limiter != nil {
+		limiter.wait(dbname)
+	}
+	err := checkPassphrase(dbname, passphrase)
+	if limiter != nil {
+		limiter.record(dbname, err)
+	}
+	return err
+}
+
+func checkPassphrase(dbname string, passphrase []byte) error {
+	keyfile := dbname + KeySuffix
+	if _, err := os.Stat(dbname + DBSuffix); err != nil {
+		return err
+	}
+	_, checked, err := readKeyfile(keyfile, passphrase)
+	if err != nil {
+		return err
+	}
+	if checked {
+		return nil
+	}
+	// old keyfile without key check value
+	db, err := open(dbname, passphrase, readOnlyDriver)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func open(dbname string, passphrase []byte, driver string) (*sql.DB, error) {
 	dbfile := dbname + DBSuffix
 	keyfile := dbname + KeySuffix
 	// make sure files exists
@@ -142,10 +245,10 @@ func Open(dbname string, passphrase []byte) (*sql.DB, error) {
 		return nil, err
 	}
 	if !encrypted {
-		return nil, fmt.Errorf("encdb: dbfile '%s' is not encrypted", dbfile)
+		return nil, ErrNotMuteDB
 	}
 	// get key from keyfile
-	key, err := ReadKeyfile(keyfile, passphrase)
+	key, checked, err := readKeyfile(keyfile, passphrase)
 	if err != nil {
 		return nil, err
 	}
@@ -154,14 +257,21 @@ func Open(dbname string, passphrase []byte) (*sql.DB, error) {
 		hex.EncodeToString(key))
 	// enable foreign key support
 	dbfile += "&_foreign_keys=1"
-	db, err := sql.Open("sqlite3", dbfile)
+	db, err := sql.Open(driver, dbfile)
 	if err != nil {
 		return nil, err
 	}
 	// test key
 	_, err = db.Exec("SELECT count(*) FROM sqlite_master;")
 	if err != nil {
-		return nil, err
+		db.Close()
+		if checked {
+			// key is correct -> database file must be damaged
+			return nil, ErrCorruptDB
+		}
+		// old keyfile without key check value, wrong passphrase is the most
+		// likely explanation
+		return nil, ErrWrongPassphrase
 	}
 	return db, nil
 }
@@ -175,7 +285,9 @@ func Open(dbname string, passphrase []byte) (*sql.DB, error) {
 //  dbname.key
 //
 // Rekey replaces the dbname.key file and leaves the dbname.db file unmodified,
-// allowing for very fast rekey operations. In case of error (for example, the
+// allowing for very fast rekey operations. The new keyfile keeps the KDF of
+// the old one: PBKDF2 uses newIter many iterations, Argon2id keeps its
+// parameters (newIter is ignored). In case of error (for example, the
 // database files do not exist or the oldPassphrase is wrong) an error is
 // returned.
 func Rekey(dbname string, oldPassphrase, newPassphrase []byte, newIter int) error {
@@ -185,7 +297,38 @@ func Rekey(dbname string, oldPassphrase, newPassphrase []byte, newIter int) erro
 	}
 	defer encdb.Close()
 	keyfile := dbname + KeySuffix
-	return replaceKeyfile(keyfile, oldPassphrase, newPassphrase, newIter)
+	kdf, err := ReadKDF(keyfile)
+	if err != nil {
+		return err
+	}
+	if kdf.Algorithm == KDFPBKDF2 {
+		kdf = PBKDF2(newIter)
+	}
+	return replaceKeyfile(keyfile, oldPassphrase, newPassphrase, kdf)
+}
+
+// Reiterate changes the number of KDF iterations of the encrypted database
+// dbname to newIter without changing the passphrase, which must be correct.
+// For Argon2id newIter is the number of passes, the other parameters are
+// kept. The KDF algorithm itself is never changed. Like Rekey, it only
+// replaces the dbname.key file (the generated key is rewrapped, the dbname.db
+// file is not reencrypted).
+func Reiterate(dbname string, passphrase []byte, newIter int) error {
+	keyfile := dbname + KeySuffix
+	kdf, err := ReadKDF(keyfile)
+	if err != nil {
+		return err
+	}
+	kdf.Iter = newIter
+	if err := kdf.check(); err != nil {
+		return err
+	}
+	encdb, err := Open(dbname, passphrase)
+	if err != nil {
+		return err
+	}
+	defer encdb.Close()
+	return replaceKeyfile(keyfile, passphrase, passphrase, kdf)
 }
 
 var autoVacuumModes = []string{
@@ -209,6 +352,28 @@ func Status(db *sql.DB) (autoVacuum string, freelistCount int64, err error) {
 	return
 }
 
+// Integrity runs an integrity check on db and returns the result, which is
+// "ok" if no problems were found.
+func Integrity(db *sql.DB) (string, error) {
+	rows, err := db.Query("PRAGMA integrity_check;")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var results []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return "", err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(results, "\n"), nil
+}
+
 // Vacuum executes VACUUM command in db. If autoVacuumMode is not nil and
 // different from the current one, the auto_vacuum mode is changed before
 // VACUUM is executed.
@@ -34,6 +34,40 @@ func TestCreateOpenClose(t *testing.T) {
 	}
 }
 
+func TestOpenReadOnly(t *testing.T) {
+	sqls := []string{
+		"CREATE TABLE Test (ID INTEGER PRIMARY KEY, Test TEXT);",
+		"INSERT INTO Test (Test) VALUES ('test');",
+	}
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err = Create(dbname, passphrase, iter, sqls); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenReadOnly(dbname, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("wrong passphrase should fail: %v", err)
+	}
+	encdb, err := OpenReadOnly(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encdb.Close()
+	var test string
+	if err := encdb.QueryRow("SELECT Test FROM Test;").Scan(&test); err != nil {
+		t.Fatal(err)
+	}
+	if test != "test" {
+		t.Errorf("test == %q", test)
+	}
+	if _, err := encdb.Exec("INSERT INTO Test (Test) VALUES ('x');"); err == nil {
+		t.Error("write to read-only database should fail")
+	}
+}
+
 func TestCreateRekey(t *testing.T) {
 	sqls := []string{
 		"CREATE TABLE Test (ID INTEGER PRIMARY KEY, Test TEXT);",
@@ -173,6 +207,33 @@ func TestMultipleCreates(t *testing.T) {
 	}
 }
 
+func TestCheckCreate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err := CheckCreate(dbname, iter); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCreate(dbname, -1); err == nil {
+		t.Fatal("check create should fail (invalid iter)")
+	}
+	if _, err := os.Stat(dbname + DBSuffix); !os.IsNotExist(err) {
+		t.Fatal("check create must not create dbfile")
+	}
+	if _, err := os.Stat(dbname + KeySuffix); !os.IsNotExist(err) {
+		t.Fatal("check create must not create keyfile")
+	}
+	if err = Create(dbname, passphrase, iter, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCreate(dbname, iter); err == nil {
+		t.Fatal("check create should fail (DB exists)")
+	}
+}
+
 func TestMissingDBFile(t *testing.T) {
 	tmpdir, err := ioutil.TempDir("", "encdb_test")
 	if err != nil {
@@ -255,6 +316,13 @@ func TestUpkeep(t *testing.T) {
 	if freelistCount != 0 {
 		t.Error("freelistCount != 0")
 	}
+	integrity, err := Integrity(encdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if integrity != "ok" {
+		t.Errorf("integrity == %q", integrity)
+	}
 	if err := Incremental(encdb, 0); err == nil {
 		t.Error("should fail")
 	}
@@ -272,3 +340,138 @@ func TestUpkeep(t *testing.T) {
 	}
 	encdb.Close()
 }
+
+func TestOpenErrors(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	err = Create(dbname, passphrase, iter, []string{
+		"CREATE TABLE Test (ID INTEGER PRIMARY KEY, Test TEXT);",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wrong passphrase
+	if _, err := Open(dbname, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("ErrWrongPassphrase expected, got: %v", err)
+	}
+	// truncated dbfile
+	if err := os.Truncate(dbname+DBSuffix, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dbname, passphrase); err != ErrCorruptDB {
+		t.Errorf("ErrCorruptDB expected, got: %v", err)
+	}
+	// truncated keyfile
+	if err := os.Truncate(dbname+KeySuffix, 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dbname, passphrase); err != ErrCorruptDB {
+		t.Errorf("ErrCorruptDB expected, got: %v", err)
+	}
+	// unencrypted dbfile
+	plainname := filepath.Join(tmpdir, "plain")
+	if err := Create(plainname, passphrase, iter, nil); err != nil {
+		t.Fatal(err)
+	}
+	header := []byte("SQLite format 3\x00")
+	if err := ioutil.WriteFile(plainname+DBSuffix, header, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(plainname, passphrase); err != ErrNotMuteDB {
+		t.Errorf("ErrNotMuteDB expected, got: %v", err)
+	}
+}
+
+func TestOpenWrongPassphraseOldKeyfile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err := Create(dbname, passphrase, iter, nil); err != nil {
+		t.Fatal(err)
+	}
+	// remove key check value to simulate old keyfile
+	if err := os.Truncate(dbname+KeySuffix, 8+32+16+32); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := Open(dbname, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("ErrWrongPassphrase expected, got: %v", err)
+	}
+}
+
+func TestCheckPassphrase(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err := CheckPassphrase(dbname, passphrase); err == nil {
+		t.Error("missing database should fail")
+	}
+	if err = Create(dbname, passphrase, iter, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPassphrase(dbname, passphrase); err != nil {
+		t.Error(err)
+	}
+	if err := CheckPassphrase(dbname, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("wrong passphrase should fail: %v", err)
+	}
+	// remove key check value to simulate old keyfile
+	if err := os.Truncate(dbname+KeySuffix, 8+32+16+32); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPassphrase(dbname, passphrase); err != nil {
+		t.Error(err)
+	}
+	if err := CheckPassphrase(dbname, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Errorf("wrong passphrase (old keyfile) should fail: %v", err)
+	}
+}
+
+func TestReiterate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err = Create(dbname, passphrase, iter, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reiterate(dbname, []byte("wrong"), 2*iter); err != ErrWrongPassphrase {
+		t.Errorf("wrong passphrase should fail: %v", err)
+	}
+	if err := Reiterate(dbname, passphrase, -1); err == nil {
+		t.Error("invalid iteration count should fail")
+	}
+	if err := Reiterate(dbname, passphrase, 2*iter); err != nil {
+		t.Fatal(err)
+	}
+	kdf, err := ReadKDF(dbname + KeySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kdf.Algorithm != KDFPBKDF2 || kdf.Iter != 2*iter {
+		t.Errorf("wrong KDF after reiterate: %+v", kdf)
+	}
+	encdb, err := Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encdb.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdb
+
+import (
+	"errors"
+)
+
+// ErrWrongPassphrase is returned by Open and ReadKeyfile if the supplied
+// passphrase is wrong (the key check value doesn't match).
+var ErrWrongPassphrase = errors.New("encdb: wrong passphrase")
+
+// ErrCorruptDB is returned by Open and ReadKeyfile if the database files are
+// structurally damaged (e.g., truncated), although the passphrase is correct.
+var ErrCorruptDB = errors.New("encdb: database is corrupt")
+
+// ErrNotMuteDB is returned by Open and ReadKeyfile if the database files are
+// not files of an encrypted Mute database.
+var ErrNotMuteDB = errors.New("encdb: not an encrypted Mute database")
@@ -0,0 +1,140 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// exportMagic is the magic string at the beginning of an export.
+var exportMagic = []byte("MUTEENCDB1")
+
+// maxExportSize is the maximum size of a single file in an export.
+const maxExportSize = 1 << 40
+
+// ExportEncrypted writes a snapshot of the encrypted database with the given
+// dbname to w. The snapshot contains the still encrypted key file and the
+// raw encrypted pages of the database file, it is never decrypted. That is,
+// the export doesn't expose any cleartext and can only be imported and opened
+// again with the passphrase of the database. The database must not be
+// modified during the export.
+func ExportEncrypted(dbname string, w io.Writer) error {
+	if _, err := w.Write(exportMagic); err != nil {
+		return err
+	}
+	for _, filename := range []string{dbname + KeySuffix, dbname + DBSuffix} {
+		if err := exportFile(filename, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportFile writes the size of filename as an uint64 (big-endian) and the
+// content of filename to w.
+func exportFile(filename string, w io.Writer) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if err := binary.Write(w, binary.BigEndian, uint64(size)); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, fp)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("encdb: file '%s' changed during export", filename)
+	}
+	return nil
+}
+
+// ImportEncrypted reads a snapshot written by ExportEncrypted from r and
+// creates the encrypted database with the given dbname from it. The database
+// files must not exist already. The files are only moved into place after the
+// whole snapshot has been read successfully.
+func ImportEncrypted(dbname string, r io.Reader) error {
+	dbfile := dbname + DBSuffix
+	keyfile := dbname + KeySuffix
+	if err := checkFiles(dbfile, keyfile); err != nil {
+		return err
+	}
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, exportMagic) {
+		return fmt.Errorf("encdb: import has wrong format")
+	}
+	dir := filepath.Dir(dbname)
+	tmpKeyfile, err := importFile(dir, r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpKeyfile)
+	tmpDBfile, err := importFile(dir, r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpDBfile)
+	// make sure the imported database file is encrypted
+	encrypted, err := sqlite3.IsEncrypted(tmpDBfile)
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		return fmt.Errorf("encdb: imported dbfile is not encrypted")
+	}
+	// move files into place
+	if err := os.Rename(tmpKeyfile, keyfile); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDBfile, dbfile); err != nil {
+		os.Remove(keyfile)
+		return err
+	}
+	return nil
+}
+
+// importFile reads a file written by exportFile from r and stores it in a
+// temporary file in dir. The name of the temporary file is returned.
+func importFile(dir string, r io.Reader) (string, error) {
+	var size uint64
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return "", err
+	}
+	if size > maxExportSize {
+		return "", fmt.Errorf("encdb: import file size too large")
+	}
+	fp, err := ioutil.TempFile(dir, "encdb-import")
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	if err := fp.Chmod(0600); err != nil {
+		os.Remove(fp.Name())
+		return "", err
+	}
+	if _, err := io.CopyN(fp, r, int64(size)); err != nil {
+		os.Remove(fp.Name())
+		return "", err
+	}
+	return fp.Name(), nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImportEncrypted(t *testing.T) {
+	sqls := []string{
+		"CREATE TABLE Test (ID INTEGER PRIMARY KEY, Test TEXT);",
+	}
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err = Create(dbname, passphrase, iter, sqls); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := "cleartext secret"
+	if _, err := db.Exec("INSERT INTO Test (Test) VALUES (?);", secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// export
+	var buf bytes.Buffer
+	if err := ExportEncrypted(dbname, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(secret)) {
+		t.Error("export contains cleartext")
+	}
+	// import into fresh store
+	importname := filepath.Join(tmpdir, "encdb_import")
+	if err := ImportEncrypted(importname, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(importname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var test string
+	if err := db.QueryRow("SELECT Test FROM Test WHERE ID=1;").Scan(&test); err != nil {
+		t.Fatal(err)
+	}
+	if test != secret {
+		t.Errorf("imported content differs: %q", test)
+	}
+	// import must not overwrite existing store
+	if err := ImportEncrypted(importname, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("import should fail for existing store")
+	}
+	// import of truncated export must fail and not create anything
+	truncname := filepath.Join(tmpdir, "encdb_trunc")
+	trunc := buf.Bytes()[:buf.Len()/2]
+	if err := ImportEncrypted(truncname, bytes.NewReader(trunc)); err == nil {
+		t.Error("import of truncated export should fail")
+	}
+	if _, err := os.Stat(truncname + KeySuffix); !os.IsNotExist(err) {
+		t.Error("failed import created keyfile")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Supported KDF algorithms.
+const (
+	KDFPBKDF2   = "pbkdf2"
+	KDFArgon2id = "argon2id"
+)
+
+// maxArgon2idMemory is the maximum memory in KiB (1 GiB) Argon2id may use.
+// It prevents corrupt or hostile keyfiles from forcing huge allocations.
+const maxArgon2idMemory = 1024 * 1024
+
+// kdfArgon2idID identifies Argon2id in the first byte of a keyfile header.
+// PBKDF2 keyfiles have a first byte of zero (the iteration count is smaller
+// than 2^31), which keeps existing keyfiles readable.
+const kdfArgon2idID = 0x01
+
+// KDF defines the key derivation function (and its parameters) used to derive
+// the AES-256 key for a keyfile from a passphrase.
+type KDF struct {
+	Algorithm string // KDFPBKDF2 or KDFArgon2id
+	Iter      int    // number of iterations (PBKDF2) or passes (Argon2id)
+	Memory    uint32 // memory in KiB (Argon2id only)
+	Threads   uint8  // number of threads (Argon2id only)
+}
+
+// PBKDF2 returns a PBKDF2 KDF with iter many iterations.
+func PBKDF2(iter int) *KDF {
+	return &KDF{Algorithm: KDFPBKDF2, Iter: iter}
+}
+
+// Argon2id returns an Argon2id KDF with the recommended default parameters.
+func Argon2id() *KDF {
+	return &KDF{Algorithm: KDFArgon2id, Iter: 1, Memory: 64 * 1024, Threads: 4}
+}
+
+// NewKDF returns the KDF for the given algorithm. The number of iterations
+// iter is only used for PBKDF2, Argon2id uses its default parameters.
+func NewKDF(algorithm string, iter int) (*KDF, error) {
+	switch algorithm {
+	case KDFPBKDF2:
+		return PBKDF2(iter), nil
+	case KDFArgon2id:
+		return Argon2id(), nil
+	default:
+		return nil, fmt.Errorf("encdb: unknown KDF algorithm '%s'", algorithm)
+	}
+}
+
+// check checks that the KDF parameters are valid.
+func (kdf *KDF) check() error {
+	switch kdf.Algorithm {
+	case KDFPBKDF2:
+		return checkIter(kdf.Iter)
+	case KDFArgon2id:
+		if kdf.Iter < 1 || kdf.Iter > 65535 {
+			return fmt.Errorf("encdb: invalid Argon2id passes")
+		}
+		if kdf.Memory < 8*uint32(kdf.Threads) || kdf.Memory > maxArgon2idMemory {
+			return fmt.Errorf("encdb: invalid Argon2id memory")
+		}
+		if kdf.Threads < 1 {
+			return fmt.Errorf("encdb: invalid Argon2id threads")
+		}
+		return nil
+	default:
+		return fmt.Errorf("encdb: unknown KDF algorithm '%s'", kdf.Algorithm)
+	}
+}
+
+// header returns the 8 byte keyfile header which encodes the KDF.
+func (kdf *KDF) header() []byte {
+	header := make([]byte, 8)
+	switch kdf.Algorithm {
+	case KDFPBKDF2:
+		binary.BigEndian.PutUint64(header, uint64(kdf.Iter))
+	case KDFArgon2id:
+		header[0] = kdfArgon2idID
+		binary.BigEndian.PutUint16(header[1:3], uint16(kdf.Iter))
+		binary.BigEndian.PutUint32(header[3:7], kdf.Memory)
+		header[7] = kdf.Threads
+	}
+	return header
+}
+
+// parseHeader parses the 8 byte keyfile header and returns the encoded KDF.
+func parseHeader(header []byte) (*KDF, error) {
+	var kdf *KDF
+	switch header[0] {
+	case 0:
+		iter := binary.BigEndian.Uint64(header)
+		if iter > 2147483647 {
+			return nil, fmt.Errorf("encdb: ReadKeyfile: invalid iter value")
+		}
+		kdf = PBKDF2(int(iter))
+	case kdfArgon2idID:
+		kdf = &KDF{
+			Algorithm: KDFArgon2id,
+			Iter:      int(binary.BigEndian.Uint16(header[1:3])),
+			Memory:    binary.BigEndian.Uint32(header[3:7]),
+			Threads:   header[7],
+		}
+	default:
+		return nil, fmt.Errorf("encdb: ReadKeyfile: unknown KDF")
+	}
+	if err := kdf.check(); err != nil {
+		return nil, err
+	}
+	return kdf, nil
+}
+
+// deriveKey derives a 32 byte key from passphrase and salt.
+func (kdf *KDF) deriveKey(passphrase, salt []byte) []byte {
+	if kdf.Algorithm == KDFArgon2id {
+		return argon2.IDKey(passphrase, salt, uint32(kdf.Iter), kdf.Memory,
+			kdf.Threads, 32)
+	}
+	return pbkdf2.Key(passphrase, salt, kdf.Iter, 32, sha256.New)
+}
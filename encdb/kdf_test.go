@@ -0,0 +1,126 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateArgon2id(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	err = CreateKDF(dbname, passphrase, Argon2id(), []string{
+		"CREATE TABLE Test (ID INTEGER PRIMARY KEY, Test TEXT);",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encdb, err := Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encdb.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := Open(dbname, []byte("wrong")); err == nil {
+		t.Error("open with wrong passphrase should fail")
+	}
+}
+
+func TestKDFHeader(t *testing.T) {
+	for _, kdf := range []*KDF{PBKDF2(iter), Argon2id()} {
+		parsed, err := parseHeader(kdf.header())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *parsed != *kdf {
+			t.Errorf("parsed KDF differs: %+v != %+v", parsed, kdf)
+		}
+	}
+	if _, err := parseHeader([]byte{0x7f, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Error("parse of unknown KDF should fail")
+	}
+	huge := Argon2id()
+	huge.Memory = maxArgon2idMemory + 1
+	if _, err := parseHeader(huge.header()); err == nil {
+		t.Error("parse of Argon2id header with huge memory should fail")
+	}
+	if _, err := NewKDF("md5", iter); err == nil {
+		t.Error("NewKDF() should fail for unknown algorithm")
+	}
+}
+
+func TestRekeyArgon2id(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err := CreateKDF(dbname, passphrase, Argon2id(), nil); err != nil {
+		t.Fatal(err)
+	}
+	newPassphrase := []byte("newpass")
+	if err := Rekey(dbname, passphrase, newPassphrase, iter); err != nil {
+		t.Fatal(err)
+	}
+	// rekey must not downgrade the KDF
+	kdf, err := ReadKDF(dbname + KeySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *kdf != *Argon2id() {
+		t.Errorf("KDF changed by rekey: %+v", kdf)
+	}
+	encdb, err := Open(dbname, newPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encdb.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReiterateArgon2id(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err := CreateKDF(dbname, passphrase, Argon2id(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reiterate(dbname, passphrase, 65536); err == nil {
+		t.Error("invalid number of Argon2id passes should fail")
+	}
+	if err := Reiterate(dbname, passphrase, 2); err != nil {
+		t.Fatal(err)
+	}
+	// only the number of passes changes
+	kdf, err := ReadKDF(dbname + KeySuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Argon2id()
+	want.Iter = 2
+	if *kdf != *want {
+		t.Errorf("wrong KDF after reiterate: %+v != %+v", kdf, want)
+	}
+	encdb, err := Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encdb.Close(); err != nil {
+		t.Error(err)
+	}
+}
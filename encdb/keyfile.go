@@ -5,6 +5,7 @@
 package encdb
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
@@ -12,8 +13,6 @@ import (
 	"os"
 
 	"github.com/mutecomm/mute/cipher/aes256"
-	"github.com/mutecomm/mute/encode"
-	"golang.org/x/crypto/pbkdf2"
 )
 
 /*
@@ -25,7 +24,7 @@ Format of keyfile:
  0         1         2         3         4         5         6
  0123456789012345678901234567890123456789012345678901234567890123
 +----------------------------------------------------------------+
-|                  number of iterations for PBKDF2               |
+|           number of iterations for PBKDF2 (or KDF header)      |
 +----------------------------------------------------------------+
 |                                                                |
 |                        salt for PBKDF2                         |
@@ -40,11 +39,31 @@ Format of keyfile:
 |                           encrypted                            |
 |                          AES-256 key                           |
 +----------------------------------------------------------------+
+|                                                                |
+|                      HMAC-SHA256 key check                     |
+|                     (missing in old keyfiles)                  |
+|                                                                |
++----------------------------------------------------------------+
+
+If the first byte of the keyfile is not zero, the first 8 bytes contain a KDF
+header instead of the number of iterations for PBKDF2. For Argon2id the first
+byte is 0x01, followed by the number of passes (2 bytes), the memory in KiB (4
+bytes), and the number of threads (1 byte), all in big-endian. The salt is
+used for the selected KDF.
 */
 
+// checkIter checks that the number of KDF iterations iter is in the valid
+// range.
+func checkIter(iter int) error {
+	if iter < 0 || iter > 2147483647 {
+		return fmt.Errorf("encdb: invalid iter value")
+	}
+	return nil
+}
+
 // writeKeyFile writes a key file with the given filename that contains the
 // supplied key in AES-256 encrypted form.
-func writeKeyfile(filename string, passphrase []byte, iter int, key []byte) error {
+func writeKeyfile(filename string, passphrase []byte, kdf *KDF, key []byte) error {
 	// make sure keyfile does not exist already
 	exists, err := fileExists(filename)
 	if err != nil {
@@ -53,12 +72,10 @@ func writeKeyfile(filename string, passphrase []byte, iter int, key []byte) erro
 	if exists {
 		return fmt.Errorf("encdb: keyfile '%s' exists already", filename)
 	}
-	// convert iter to uint64
-	var uiter uint64
-	if iter < 0 || iter > 2147483647 {
-		return fmt.Errorf("encdb: writeKeyfile: invalid iter value")
+	// check KDF parameters
+	if err := kdf.check(); err != nil {
+		return err
 	}
-	uiter = uint64(iter)
 	// check keylength
 	if len(key) != 32 {
 		return fmt.Errorf("encdb: writeKeyfile: len(key) != 32")
@@ -75,11 +92,11 @@ func writeKeyfile(filename string, passphrase []byte, iter int, key []byte) erro
 		return err
 	}
 	// compute derived key from passphrase
-	dk := pbkdf2.Key(passphrase, salt, iter, 32, sha256.New)
+	dk := kdf.deriveKey(passphrase, salt)
 	// compute AES-256 encrypted key (with IV)
 	encKey := aes256.CBCEncrypt([]byte(dk), key, rand.Reader)
-	// write number of iterations
-	if _, err := keyfile.Write(encode.ToByte8(uiter)); err != nil {
+	// write number of iterations (or KDF header)
+	if _, err := keyfile.Write(kdf.header()); err != nil {
 		return err
 	}
 	// write salt
@@ -90,21 +107,25 @@ func writeKeyfile(filename string, passphrase []byte, iter int, key []byte) erro
 	if _, err := keyfile.Write(encKey); err != nil {
 		return err
 	}
+	// write key check value
+	if _, err := keyfile.Write(keyCheck(dk, key)); err != nil {
+		return err
+	}
 	return nil
 }
 
 // generateKeyFile generates a key file with the given filename that contains a
 // randomly generated and encrypted AES-256 key.
-// The generated key is protected by a passphrase, which is processed by PBKDF2
-// with iter many iterations to derive the AES-256 key to encrypt the generated
-// key. The function returns the generated key in unencrypted form.
-func generateKeyfile(filename string, passphrase []byte, iter int) (key []byte, err error) {
+// The generated key is protected by a passphrase, which is processed by the
+// given kdf to derive the AES-256 key to encrypt the generated key.
+// The function returns the generated key in unencrypted form.
+func generateKeyfile(filename string, passphrase []byte, kdf *KDF) (key []byte, err error) {
 	// generate raw key
 	var rawKey = make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, rawKey); err != nil {
 		return nil, err
 	}
-	if err := writeKeyfile(filename, passphrase, iter, rawKey); err != nil {
+	if err := writeKeyfile(filename, passphrase, kdf, rawKey); err != nil {
 		return nil, err
 	}
 	return rawKey, nil
@@ -112,49 +133,100 @@ func generateKeyfile(filename string, passphrase []byte, iter int) (key []byte,
 
 // ReadKeyfile reads a randomly generated and encrypted AES-256 key from the
 // file with the given filename and returns it in unencrypted form.
-// The key is protected by a passphrase, which is processed by PBKDF2 to
-// derive the AES-256 key to decrypt the generated key.
+// The key is protected by a passphrase, which is processed by the KDF
+// recorded in the keyfile (PBKDF2 for old keyfiles) to derive the AES-256 key
+// to decrypt the generated key.
+// If the keyfile contains a key check value and the passphrase is wrong,
+// ErrWrongPassphrase is returned.
 func ReadKeyfile(filename string, passphrase []byte) (key []byte, err error) {
-	// open keyfile
+	key, _, err = readKeyfile(filename, passphrase)
+	return key, err
+}
+
+// ReadKDF returns the KDF (and its parameters) recorded in the keyfile with the
+// given filename. No passphrase is required.
+func ReadKDF(filename string) (*KDF, error) {
 	keyfile, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer keyfile.Close()
-	// read iter and convert to int
-	var biter = make([]byte, 8)
-	if _, err := keyfile.Read(biter); err != nil {
-		return nil, err
+	var header = make([]byte, 8)
+	if _, err := io.ReadFull(keyfile, header); err != nil {
+		return nil, ErrNotMuteDB
 	}
-	uiter := encode.ToUint64(biter)
-	if uiter > 2147483647 {
-		return nil, fmt.Errorf("encdb: ReadKeyfile: invalid iter value")
+	kdf, err := parseHeader(header)
+	if err != nil {
+		return nil, ErrNotMuteDB
+	}
+	return kdf, nil
+}
+
+// readKeyfile is like ReadKeyfile, but additionally returns whether the key
+// has been verified with a key check value (old keyfiles do not have one).
+func readKeyfile(filename string, passphrase []byte) (key []byte, checked bool, err error) {
+	// open keyfile
+	keyfile, err := os.Open(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	defer keyfile.Close()
+	// read iter (or KDF header) and parse it
+	var header = make([]byte, 8)
+	if _, err := io.ReadFull(keyfile, header); err != nil {
+		return nil, false, ErrNotMuteDB
+	}
+	kdf, err := parseHeader(header)
+	if err != nil {
+		return nil, false, ErrNotMuteDB
 	}
-	iter := int(uiter)
 	// read salt
 	var salt = make([]byte, 32)
-	if _, err := keyfile.Read(salt); err != nil {
-		return nil, err
+	if _, err := io.ReadFull(keyfile, salt); err != nil {
+		return nil, false, ErrCorruptDB
 	}
 	// read encrypted key
 	var encKey = make([]byte, 16+32)
-	if _, err := keyfile.Read(encKey); err != nil {
-		return nil, err
+	if _, err := io.ReadFull(keyfile, encKey); err != nil {
+		return nil, false, ErrCorruptDB
+	}
+	// read optional key check value
+	var check = make([]byte, sha256.Size)
+	n, err := io.ReadFull(keyfile, check)
+	if err != nil && n != 0 {
+		return nil, false, ErrCorruptDB
 	}
 	// compute derived key from passphrase
-	dk := pbkdf2.Key([]byte(passphrase), salt, iter, 32, sha256.New)
+	dk := kdf.deriveKey(passphrase, salt)
 	// decrypt key
-	return aes256.CBCDecrypt([]byte(dk), encKey), nil
+	key = aes256.CBCDecrypt([]byte(dk), encKey)
+	if n == 0 {
+		return key, false, nil
+	}
+	if !hmac.Equal(check, keyCheck(dk, key)) {
+		return nil, false, ErrWrongPassphrase
+	}
+	return key, true, nil
+}
+
+// keyCheck computes the key check value for the derived key dk and key.
+func keyCheck(dk, key []byte) []byte {
+	mac := hmac.New(sha256.New, dk)
+	mac.Write([]byte("encdb key check"))
+	mac.Write(key)
+	return mac.Sum(nil)
 }
 
-func replaceKeyfile(filename string, oldPassphrase, newPassphrase []byte, newIter int) error {
+// replaceKeyfile replaces the keyfile with the given filename by a new one,
+// which protects the same key with newPassphrase processed by kdf.
+func replaceKeyfile(filename string, oldPassphrase, newPassphrase []byte, kdf *KDF) error {
 	key, err := ReadKeyfile(filename, oldPassphrase)
 	if err != nil {
 		return err
 	}
 	tmpfile := filename + ".new"
 	os.Remove(tmpfile) // ignore error
-	if err := writeKeyfile(tmpfile, newPassphrase, newIter, key); err != nil {
+	if err := writeKeyfile(tmpfile, newPassphrase, kdf, key); err != nil {
 		return err
 	}
 	return os.Rename(tmpfile, filename)
@@ -20,7 +20,7 @@ func TestGenerateRead(t *testing.T) {
 	defer os.RemoveAll(tmpdir)
 	keyfile := filepath.Join(tmpdir, "keyfile_test.key")
 	// generate keyfile
-	gkey, err := generateKeyfile(keyfile, passphrase, iter)
+	gkey, err := generateKeyfile(keyfile, passphrase, PBKDF2(iter))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -42,10 +42,10 @@ func TestMultipleGenerates(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpdir)
 	keyfile := filepath.Join(tmpdir, "keyfile_test.key")
-	if _, err := generateKeyfile(keyfile, passphrase, iter); err != nil {
+	if _, err := generateKeyfile(keyfile, passphrase, PBKDF2(iter)); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := generateKeyfile(keyfile, passphrase, iter); err == nil {
+	if _, err := generateKeyfile(keyfile, passphrase, PBKDF2(iter)); err == nil {
 		t.Fatalf("second generate should fail")
 	}
 }
@@ -127,7 +127,7 @@ func TestInvalidIterGenerate(t *testing.T) {
 	defer os.RemoveAll(tmpdir)
 	keyfile := filepath.Join(tmpdir, "keyfile_test.key")
 	// generate keyfile
-	if _, err := generateKeyfile(keyfile, passphrase, -1); err == nil {
+	if _, err := generateKeyfile(keyfile, passphrase, PBKDF2(-1)); err == nil {
 		t.Fatalf("generate should fail")
 	}
 }
@@ -0,0 +1,138 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimitSuffix defines the suffix for the files which record the number of
+// consecutive wrong passphrases of a database (next to the key file).
+const LimitSuffix = ".limit"
+
+// DefaultDelays defines the default delay schedule of a Limiter.
+var DefaultDelays = []time.Duration{
+	1 * time.Second,
+	2 * time.Second,
+	4 * time.Second,
+	8 * time.Second,
+	16 * time.Second,
+	30 * time.Second,
+}
+
+// ParseDelays parses a comma-separated delay schedule like "1s,2s,4s" (see
+// time.ParseDuration). An empty schedule disables the delays.
+func ParseDelays(schedule string) ([]time.Duration, error) {
+	if schedule == "" {
+		return nil, nil
+	}
+	var delays []time.Duration
+	for _, s := range strings.Split(schedule, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("encdb: negative delay: %s", s)
+		}
+		delays = append(delays, d)
+	}
+	return delays, nil
+}
+
+// FormatDelays formats the delay schedule delays in the format parsed by
+// ParseDelays.
+func FormatDelays(delays []time.Duration) string {
+	s := make([]string, len(delays))
+	for i, d := range delays {
+		s[i] = d.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// A Limiter slows down brute-force attempts against the passphrase of an
+// encrypted database by enforcing an increasing delay after consecutive
+// wrong passphrases (see ErrWrongPassphrase). A successful open resets the
+// delay. The number of consecutive failures of database dbname is recorded
+// in the file dbname.limit, so restarting the process does not reset it.
+type Limiter struct {
+	mutex  sync.Mutex
+	delays []time.Duration     // delay schedule
+	sleep  func(time.Duration) // sleeper (time.Sleep if not testing)
+}
+
+// NewLimiter returns a new Limiter with the given delay schedule. After n
+// consecutive failures the next attempt is delayed by delays[n-1] (the last
+// entry is used for all further failures).
+func NewLimiter(delays []time.Duration) *Limiter {
+	return &Limiter{
+		delays: delays,
+		sleep:  time.Sleep,
+	}
+}
+
+// failures returns the number of consecutive failures recorded for dbname.
+func failures(dbname string) int {
+	buf, err := ioutil.ReadFile(dbname + LimitSuffix)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// delay returns the delay for the next attempt after n consecutive failures.
+func (l *Limiter) delay(n int) time.Duration {
+	if n == 0 || len(l.delays) == 0 {
+		return 0
+	}
+	if n > len(l.delays) {
+		return l.delays[len(l.delays)-1]
+	}
+	return l.delays[n-1]
+}
+
+// wait waits for the delay of the next attempt to open dbname.
+func (l *Limiter) wait(dbname string) {
+	l.mutex.Lock()
+	delay := l.delay(failures(dbname))
+	l.mutex.Unlock()
+	if delay > 0 {
+		l.sleep(delay)
+	}
+}
+
+// record records the result err of an attempt to open dbname. Errors while
+// writing the dbname.limit file are ignored (for example, if the directory
+// is read-only), in which case the delay is not increased.
+func (l *Limiter) record(dbname string, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	filename := dbname + LimitSuffix
+	if err == ErrWrongPassphrase {
+		n := strconv.Itoa(failures(dbname) + 1)
+		ioutil.WriteFile(filename, []byte(n+"\n"), 0600)
+	} else if err == nil {
+		os.Remove(filename)
+	}
+}
+
+// limiter is the Limiter used by Open (nil, if disabled).
+var limiter *Limiter
+
+// SetLimiter sets the Limiter used by Open to enforce delays after wrong
+// passphrases. A nil limiter disables the delays (the default).
+func SetLimiter(l *Limiter) {
+	limiter = l
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "encdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "encdb_test")
+	if err := Create(dbname, passphrase, iter, nil); err != nil {
+		t.Fatal(err)
+	}
+	// install limiter with fake sleeper
+	var delays []time.Duration
+	newLimiter := func() {
+		l := NewLimiter([]time.Duration{time.Second, 2 * time.Second})
+		l.sleep = func(d time.Duration) { delays = append(delays, d) }
+		SetLimiter(l)
+	}
+	newLimiter()
+	defer SetLimiter(nil)
+	// repeated failures incur increasing delays
+	for i := 0; i < 3; i++ {
+		if _, err := Open(dbname, []byte("wrong")); err != ErrWrongPassphrase {
+			t.Fatalf("ErrWrongPassphrase expected, got: %v", err)
+		}
+	}
+	// failures are recorded next to the keyfile and survive a restart
+	if n := failures(dbname); n != 3 {
+		t.Errorf("failures == %d != 3", n)
+	}
+	newLimiter()
+	// success resets delay
+	db, err := Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := os.Stat(dbname + LimitSuffix); !os.IsNotExist(err) {
+		t.Error("limit file should be removed after success")
+	}
+	if _, err := Open(dbname, []byte("wrong")); err != ErrWrongPassphrase {
+		t.Fatalf("ErrWrongPassphrase expected, got: %v", err)
+	}
+	expected := []time.Duration{
+		time.Second, 2 * time.Second, // failures
+		2 * time.Second, // success after restart
+	}
+	if !reflect.DeepEqual(delays, expected) {
+		t.Errorf("delays = %v, expected %v", delays, expected)
+	}
+}
+
+func TestParseDelays(t *testing.T) {
+	delays, err := ParseDelays(FormatDelays(DefaultDelays))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(delays, DefaultDelays) {
+		t.Errorf("delays = %v, expected %v", delays, DefaultDelays)
+	}
+	if delays, err := ParseDelays(""); err != nil || delays != nil {
+		t.Errorf("empty schedule: delays = %v, err = %v", delays, err)
+	}
+	if _, err := ParseDelays("1s,x"); err == nil {
+		t.Error("invalid delay should fail")
+	}
+	if _, err := ParseDelays("1s,-1s"); err == nil {
+		t.Error("negative delay should fail")
+	}
+}
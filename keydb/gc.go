@@ -0,0 +1,201 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keydb
+
+import (
+	"database/sql"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// Orphans describes the records in keyDB which are not reachable from any
+// UID anymore (see FindOrphans).
+type Orphans struct {
+	KeyInits      []string // PUBKEYHASHes of private KeyInits without own UID
+	SessionStates []string // session state keys without pair of UIDs
+	SessionKeys   []string // hashes of expired and unreferenced session keys
+	MessageKeys   int64    // number of message keys without session
+}
+
+// Count returns the total number of orphaned records.
+func (o *Orphans) Count() int64 {
+	return int64(len(o.KeyInits)+len(o.SessionStates)+len(o.SessionKeys)) +
+		o.MessageKeys
+}
+
+// getUIDMessages returns all UID messages selected by stmt.
+func getUIDMessages(stmt *sql.Stmt) ([]*uid.Message, error) {
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer rows.Close()
+	var msgs []*uid.Message
+	for rows.Next() {
+		var uidJSON string
+		if err := rows.Scan(&uidJSON); err != nil {
+			return nil, log.Error(err)
+		}
+		msg, err := uid.NewJSON(uidJSON)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return msgs, nil
+}
+
+// FindOrphans scans keyDB for records which are not reachable from the
+// private and public UID messages stored in keyDB:
+//
+//   - private KeyInits, which have not been signed by any own UID,
+//   - session states, which do not belong to any pair of own and contact UID,
+//   - session keys past their cleanup time, which are not referenced by any
+//     remaining session state, and
+//   - message keys, whose session does not exist anymore.
+//
+// All versions of the UID messages are considered, not only the current ones.
+func (keyDB *KeyDB) FindOrphans() (*Orphans, error) {
+	var orphans Orphans
+	privMsgs, err := getUIDMessages(keyDB.getPrivateUIDsQuery)
+	if err != nil {
+		return nil, err
+	}
+	pubMsgs, err := getUIDMessages(keyDB.getPublicUIDsQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	// KeyInits
+	sigKeyHashes := make(map[string]bool)
+	for _, msg := range privMsgs {
+		sigKeyHash, err := msg.SigKeyHash()
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		sigKeyHashes[sigKeyHash] = true
+	}
+	rows, err := keyDB.getKeyInitHashesQuery.Query()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sigKeyHash, pubKeyHash string
+		if err := rows.Scan(&sigKeyHash, &pubKeyHash); err != nil {
+			return nil, log.Error(err)
+		}
+		if !sigKeyHashes[sigKeyHash] {
+			orphans.KeyInits = append(orphans.KeyInits, pubKeyHash)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+
+	// session states
+	stateKeys := make(map[string]bool)
+	for _, privMsg := range privMsgs {
+		for _, pubMsg := range pubMsgs {
+			key := session.CalcStateKey(privMsg.PubKey().PublicKey32(),
+				pubMsg.PubKey().PublicKey32())
+			stateKeys[key] = true
+		}
+	}
+	infos, err := keyDB.GetSessionStates()
+	if err != nil {
+		return nil, err
+	}
+	keyHashes := make(map[string]bool)
+	for _, info := range infos {
+		if !stateKeys[info.SessionStateKey] {
+			orphans.SessionStates = append(orphans.SessionStates,
+				info.SessionStateKey)
+			continue
+		}
+		ss, err := keyDB.GetSessionState(info.SessionStateKey)
+		if err != nil {
+			return nil, err
+		}
+		keyHashes[ss.RecipientTemp.HASH] = true
+		keyHashes[ss.SenderSessionPub.HASH] = true
+		if ss.NextSenderSessionPub != nil {
+			keyHashes[ss.NextSenderSessionPub.HASH] = true
+		}
+		if ss.NextRecipientSessionPubSeen != nil {
+			keyHashes[ss.NextRecipientSessionPubSeen.HASH] = true
+		}
+	}
+
+	// session keys
+	keyRows, err := keyDB.getOldSessionKeysQuery.Query(times.Now())
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer keyRows.Close()
+	for keyRows.Next() {
+		var hash string
+		if err := keyRows.Scan(&hash); err != nil {
+			return nil, log.Error(err)
+		}
+		if !keyHashes[hash] {
+			orphans.SessionKeys = append(orphans.SessionKeys, hash)
+		}
+	}
+	if err := keyRows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+
+	// message keys
+	err = keyDB.countOrphanMsgKeysQuery.QueryRow().Scan(&orphans.MessageKeys)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	return &orphans, nil
+}
+
+// DelOrphans deletes the given orphaned records (see FindOrphans) from keyDB.
+func (keyDB *KeyDB) DelOrphans(orphans *Orphans) error {
+	tx, err := keyDB.encDB.Begin()
+	if err != nil {
+		return log.Error(err)
+	}
+	del := func(stmt *sql.Stmt, keys []string) error {
+		for _, key := range keys {
+			if _, err := tx.Stmt(stmt).Exec(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := del(keyDB.delPrivateKeyInitQuery, orphans.KeyInits); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if err := del(keyDB.delSessionStateQuery, orphans.SessionStates); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if err := del(keyDB.delSessionKeyQuery, orphans.SessionKeys); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if orphans.MessageKeys > 0 {
+		if _, err := tx.Stmt(keyDB.delOrphanMsgKeysQuery).Exec(); err != nil {
+			tx.Rollback()
+			return log.Error(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keydb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func TestOrphans(t *testing.T) {
+	tmpdir, keyDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer keyDB.Close()
+	now := uint64(times.Now())
+	// own UID with KeyInit (referenced)
+	alice, err := uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPrivateUID(alice); err != nil {
+		t.Fatal(err)
+	}
+	ki, aliceHash, privateKey, err := alice.KeyInit(1, now+times.Day,
+		now-times.Day, false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyDB.AddPrivateKeyInit(ki, aliceHash, alice.SigPubKey(), privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// KeyInit of deleted UID (orphaned)
+	gone, err := uid.Create("gone@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ki, goneHash, privateKey, err := gone.KeyInit(1, now+times.Day,
+		now-times.Day, false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyDB.AddPrivateKeyInit(ki, goneHash, gone.SigPubKey(), privateKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// session state with contact (referenced) and of deleted UID (orphaned)
+	bob, err := uid.Create("bob@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.AddPublicUID(bob, 0); err != nil {
+		t.Fatal(err)
+	}
+	var rt, ssp, old uid.KeyEntry
+	for _, ke := range []*uid.KeyEntry{&rt, &ssp, &old} {
+		if err := ke.InitDHKey(cipher.RandReader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ss := &session.State{
+		RecipientTemp:    rt,
+		SenderSessionPub: ssp,
+		NymAddress:       "NYMADDRESS",
+	}
+	liveKey := session.CalcStateKey(alice.PubKey().PublicKey32(),
+		bob.PubKey().PublicKey32())
+	goneKey := session.CalcStateKey(gone.PubKey().PublicKey32(),
+		bob.PubKey().PublicKey32())
+	for _, key := range []string{liveKey, goneKey} {
+		if err := keyDB.SetSessionState(key, ss); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// expired session keys, one referenced by the session state
+	for _, ke := range []*uid.KeyEntry{&ssp, &old} {
+		err := keyDB.AddSessionKey(ke.HASH, string(ke.JSON()), ke.PrivateKey(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// message key without session (orphaned)
+	_, err = keyDB.encDB.Exec("INSERT INTO MessageKeys (SessionID, Number, Key, Direction) VALUES (?, ?, ?, ?);",
+		42, 0, base64.Encode(cipher.SHA256([]byte("key"))), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := keyDB.FindOrphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans.KeyInits) != 1 || orphans.KeyInits[0] != goneHash {
+		t.Errorf("wrong orphaned KeyInits: %v", orphans.KeyInits)
+	}
+	if len(orphans.SessionStates) != 1 || orphans.SessionStates[0] != goneKey {
+		t.Errorf("wrong orphaned session states: %v", orphans.SessionStates)
+	}
+	if len(orphans.SessionKeys) != 1 || orphans.SessionKeys[0] != old.HASH {
+		t.Errorf("wrong orphaned session keys: %v", orphans.SessionKeys)
+	}
+	if orphans.MessageKeys != 1 {
+		t.Errorf("orphans.MessageKeys == %d != 1", orphans.MessageKeys)
+	}
+	if n := orphans.Count(); n != 4 {
+		t.Errorf("orphans.Count() == %d != 4", n)
+	}
+
+	// remove orphans
+	if err := keyDB.DelOrphans(orphans); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = keyDB.FindOrphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := orphans.Count(); n != 0 {
+		t.Errorf("%d orphans left", n)
+	}
+	if _, _, _, err := keyDB.GetPrivateKeyInit(aliceHash); err != nil {
+		t.Error("referenced KeyInit should be kept")
+	}
+	if _, _, _, err := keyDB.GetPrivateKeyInit(goneHash); err == nil {
+		t.Error("orphaned KeyInit should be removed")
+	}
+	if ss, err := keyDB.GetSessionState(liveKey); err != nil || ss == nil {
+		t.Error("referenced session state should be kept")
+	}
+	if ss, err := keyDB.GetSessionState(goneKey); err != nil || ss != nil {
+		t.Error("orphaned session state should be removed")
+	}
+	if _, _, err := keyDB.GetSessionKey(ssp.HASH); err != nil {
+		t.Error("referenced session key should be kept")
+	}
+	if _, _, err := keyDB.GetSessionKey(old.HASH); err == nil {
+		t.Error("orphaned session key should be removed")
+	}
+}
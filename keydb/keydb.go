@@ -16,7 +16,7 @@ import (
 )
 
 // Version is the current keydb version.
-const Version = "1"
+const Version = "2"
 
 // Entries in KeyValueTable.
 const (
@@ -68,8 +68,9 @@ CREATE TABLE Sessions (
   SessionID   INTEGER PRIMARY KEY,
   SessionKey  TEXT    NOT NULL,
   RootKeyHash TEXT    NOT NULL,
-  ChainKey    TEXT    NOT NULL,
-  NumOfKeys   INTEGER NOT NULL
+  ChainKey     TEXT    NOT NULL,
+  NumOfKeys    INTEGER NOT NULL,
+  LastActivity INTEGER NOT NULL DEFAULT 0 -- Unix time
 );`
 	createQueryMessageKeys = `
 CREATE TABLE MessageKeys (
@@ -100,7 +101,8 @@ CREATE TABLE SessionStates (
   NextSenderSessionPub        TEXT,
   NextRecipientSessionPubSeen TEXT,
   NymAddress                  TEXT    NOT NULL,
-  KeyInitSession              INTEGER NOT NULL
+  KeyInitSession              INTEGER NOT NULL,
+  LastActivity                INTEGER NOT NULL DEFAULT 0 -- Unix time
 );`
 	createQuerySessionKeys = `
 CREATE TABLE SessionKeys (
@@ -120,14 +122,20 @@ CREATE TABLE SessionKeys (
 	getPrivateUIDQuery        = "SELECT UIDMessage, SIGPRIVKEY, ENCPRIVKEY, UIDMessageReply FROM PrivateUIDs WHERE IDENTITY=? ORDER BY MSGCOUNT DESC;"
 	addPrivateKeyInitQuery    = "INSERT INTO PrivateKeyInits (SIGKEYHASH, PUBKEYHASH, KeyInit, SigPubKey, PRIVKEY, ServerSignature) VALUES (?, ?, ?, ?, ?, ?);"
 	getPrivateKeyInitQuery    = "SELECT KeyInit, SigPubKey, PRIVKEY FROM PrivateKeyInits WHERE PUBKEYHASH=?;"
+	getPrivateKeyInitsQuery   = "SELECT KeyInit FROM PrivateKeyInits WHERE SIGKEYHASH=?;"
+	getPrivateKeyInitSigQuery = "SELECT ServerSignature FROM PrivateKeyInits WHERE PUBKEYHASH=?;"
 	addPublicKeyInitQuery     = "INSERT INTO PublicKeyInits (SIGKEYHASH, KeyInit) VALUES (?, ?);"
-	getPublicKeyInitQuery     = "SELECT KeyInit FROM PublicKeyInits WHERE SIGKEYHASH=?;"
+	getPublicKeyInitQuery     = "SELECT KeyInit FROM PublicKeyInits WHERE SIGKEYHASH=? ORDER BY ID DESC;"
 	addPublicUIDQuery         = "INSERT INTO PublicUIDs (IDENTITY, MSGCOUNT, POSITION, UIDMessage) VALUES (?, ?, ?, ?);"
 	getPublicUIDQuery         = "SELECT UIDMessage, POSITION FROM PublicUIDs WHERE IDENTITY=? and POSITION<=? ORDER BY POSITION DESC;"
+	getPublicIdentitiesQuery  = "SELECT DISTINCT IDENTITY FROM PublicUIDs;"
 	getSessionQuery           = "SELECT RootKeyHash, ChainKey, NumOfKeys FROM Sessions WHERE SessionKey=?;"
 	getSessionIDQuery         = "SELECT SessionID FROM Sessions WHERE SessionKey=?;"
-	updateSessionQuery        = "UPDATE Sessions SET ChainKey=?, NumOfKeys=? WHERE SessionKey=?;"
-	insertSessionQuery        = "INSERT INTO Sessions(SessionKey, RootKeyHash, ChainKey, NumOfKeys) VALUES (?, ?, ?, ?);"
+	updateSessionQuery        = "UPDATE Sessions SET ChainKey=?, NumOfKeys=?, LastActivity=? WHERE SessionKey=?;"
+	insertSessionQuery        = "INSERT INTO Sessions(SessionKey, RootKeyHash, ChainKey, NumOfKeys, LastActivity) VALUES (?, ?, ?, ?, ?);"
+	touchSessionQuery         = "UPDATE Sessions SET LastActivity=? WHERE SessionID=?;"
+	delOldSessionsQuery       = "DELETE FROM Sessions WHERE LastActivity<?;"
+	delOldMessageKeysQuery    = "DELETE FROM MessageKeys WHERE SessionID IN (SELECT SessionID FROM Sessions WHERE LastActivity<?);"
 	addMessageKeyQuery        = "INSERT INTO MessageKeys(SessionID, Number, Key, Direction) VALUES (?, ?, ?, ?);"
 	delMessageKeyQuery        = "DELETE FROM MessageKeys WHERE SessionID=? AND Number=? AND Direction=?;"
 	getMessageKeyQuery        = "SELECT Key FROM MessageKeys WHERE SessionID=? AND Number=? AND Direction=?;"
@@ -137,17 +145,39 @@ CREATE TABLE SessionKeys (
 	delHashChainQuery         = "DELETE FROM Hashchains WHERE Domain=?;"
 	updateSessionStateQuery   = "UPDATE SessionStates SET SenderSessionCount=?, SenderMessageCount=?, " +
 		"MaxRecipientCount=?, RecipientTemp=?, SenderSessionPub=?, NextSenderSessionPub=?, " +
-		"NextRecipientSessionPubSeen=?, NymAddress=?, KeyInitSession=? WHERE SessionStateKey=?;"
+		"NextRecipientSessionPubSeen=?, NymAddress=?, KeyInitSession=?, LastActivity=? WHERE SessionStateKey=?;"
 	insertSessionStateQuery = "INSERT INTO SessionStates (SessionStateKey, SenderSessionCount, " +
 		"SenderMessageCount, MaxRecipientCount, RecipientTemp, SenderSessionPub, " +
-		"NextSenderSessionPub, NextRecipientSessionPubSeen, NymAddress, KeyInitSession) VALUES " +
-		"(?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+		"NextSenderSessionPub, NextRecipientSessionPubSeen, NymAddress, KeyInitSession, LastActivity) VALUES " +
+		"(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
 	getSessionStateQuery = "SELECT SenderSessionCount, SenderMessageCount, MaxRecipientCount, " +
 		"RecipientTemp, SenderSessionPub, NextSenderSessionPub, NextRecipientSessionPubSeen, " +
 		"NymAddress, KeyInitSession FROM SessionStates WHERE SessionStateKey=?;"
-	updateSessionKeyQuery = "UPDATE SessionKeys SET PrivKey=? WHERE Hash=?;"
-	insertSessionKeyQuery = "INSERT INTO SessionKeys (Hash, Json, PrivKey, CleanupTime) VALUES (?, ?, ?, ?);"
-	getSessionKeyQuery    = "SELECT Json, PrivKey FROM SessionKeys WHERE Hash=?;"
+	getSessionStatesQuery    = "SELECT SessionStateKey, LastActivity FROM SessionStates ORDER BY LastActivity DESC;"
+	delOldSessionStatesQuery = "DELETE FROM SessionStates WHERE LastActivity<?;"
+	updateSessionKeyQuery    = "UPDATE SessionKeys SET PrivKey=? WHERE Hash=?;"
+	insertSessionKeyQuery    = "INSERT INTO SessionKeys (Hash, Json, PrivKey, CleanupTime) VALUES (?, ?, ?, ?);"
+	getSessionKeyQuery       = "SELECT Json, PrivKey FROM SessionKeys WHERE Hash=?;"
+	cleanupSessionKeysQuery  = "DELETE FROM SessionKeys WHERE CleanupTime<?;"
+
+	// garbage collection (see FindOrphans)
+	getPrivateUIDsQuery     = "SELECT UIDMessage FROM PrivateUIDs;"
+	getPublicUIDsQuery      = "SELECT UIDMessage FROM PublicUIDs;"
+	getKeyInitHashesQuery   = "SELECT SIGKEYHASH, PUBKEYHASH FROM PrivateKeyInits;"
+	delPrivateKeyInitQuery  = "DELETE FROM PrivateKeyInits WHERE PUBKEYHASH=?;"
+	delSessionStateQuery    = "DELETE FROM SessionStates WHERE SessionStateKey=?;"
+	getOldSessionKeysQuery  = "SELECT Hash FROM SessionKeys WHERE CleanupTime<?;"
+	delSessionKeyQuery      = "DELETE FROM SessionKeys WHERE Hash=?;"
+	countOrphanMsgKeysQuery = "SELECT COUNT(*) FROM MessageKeys WHERE SessionID NOT IN (SELECT SessionID FROM Sessions);"
+	delOrphanMsgKeysQuery   = "DELETE FROM MessageKeys WHERE SessionID NOT IN (SELECT SessionID FROM Sessions);"
+	// session consistency (see CheckSessions)
+	getSessionsQuery        = "SELECT SessionID, SessionKey, NumOfKeys FROM Sessions ORDER BY SessionID;"
+	countExcessMsgKeysQuery = "SELECT COUNT(*) FROM MessageKeys WHERE SessionID=? AND Number>=?;"
+	delExcessMsgKeysQuery   = "DELETE FROM MessageKeys WHERE SessionID=? AND Number>=?;"
+	countDupMsgKeysQuery    = "SELECT COUNT(*) FROM MessageKeys WHERE SessionID=? AND Number<? AND ID NOT IN " +
+		"(SELECT MIN(ID) FROM MessageKeys WHERE SessionID=? GROUP BY Number, Direction);"
+	delDupMsgKeysQuery = "DELETE FROM MessageKeys WHERE SessionID=? AND Number<? AND ID NOT IN " +
+		"(SELECT MIN(ID) FROM MessageKeys WHERE SessionID=? GROUP BY Number, Direction);"
 )
 
 // KeyDB is a handle for an encrypted database used to store mute keys.
@@ -163,14 +193,20 @@ type KeyDB struct {
 	getPrivateUIDQuery        *sql.Stmt
 	addPrivateKeyInitQuery    *sql.Stmt
 	getPrivateKeyInitQuery    *sql.Stmt
+	getPrivateKeyInitsQuery   *sql.Stmt
+	getPrivateKeyInitSigQuery *sql.Stmt
 	addPublicKeyInitQuery     *sql.Stmt
 	getPublicKeyInitQuery     *sql.Stmt
 	addPublicUIDQuery         *sql.Stmt
 	getPublicUIDQuery         *sql.Stmt
+	getPublicIdentitiesQuery  *sql.Stmt
 	getSessionQuery           *sql.Stmt
 	getSessionIDQuery         *sql.Stmt
 	updateSessionQuery        *sql.Stmt
 	insertSessionQuery        *sql.Stmt
+	touchSessionQuery         *sql.Stmt
+	delOldSessionsQuery       *sql.Stmt
+	delOldMessageKeysQuery    *sql.Stmt
 	addMessageKeyQuery        *sql.Stmt
 	delMessageKeyQuery        *sql.Stmt
 	getMessageKeyQuery        *sql.Stmt
@@ -181,15 +217,38 @@ type KeyDB struct {
 	updateSessionStateQuery   *sql.Stmt
 	insertSessionStateQuery   *sql.Stmt
 	getSessionStateQuery      *sql.Stmt
+	getSessionStatesQuery     *sql.Stmt
+	delOldSessionStatesQuery  *sql.Stmt
 	updateSessionKeyQuery     *sql.Stmt
 	insertSessionKeyQuery     *sql.Stmt
 	getSessionKeyQuery        *sql.Stmt
+	cleanupSessionKeysQuery   *sql.Stmt
+	getPrivateUIDsQuery       *sql.Stmt
+	getPublicUIDsQuery        *sql.Stmt
+	getKeyInitHashesQuery     *sql.Stmt
+	delPrivateKeyInitQuery    *sql.Stmt
+	delSessionStateQuery      *sql.Stmt
+	getOldSessionKeysQuery    *sql.Stmt
+	delSessionKeyQuery        *sql.Stmt
+	countOrphanMsgKeysQuery   *sql.Stmt
+	delOrphanMsgKeysQuery     *sql.Stmt
+	getSessionsQuery          *sql.Stmt
+	countExcessMsgKeysQuery   *sql.Stmt
+	delExcessMsgKeysQuery     *sql.Stmt
+	countDupMsgKeysQuery      *sql.Stmt
+	delDupMsgKeysQuery        *sql.Stmt
 }
 
 // Create returns a new KEY database with the given dbname.
 // It is encrypted by passphrase (processed by a KDF with iter many iterations).
 func Create(dbname string, passphrase []byte, iter int) error {
-	err := encdb.Create(dbname, passphrase, iter, []string{
+	return CreateKDF(dbname, passphrase, encdb.PBKDF2(iter))
+}
+
+// CreateKDF is like Create, but uses the given kdf to derive the key for the
+// keyDB keyfile from the passphrase.
+func CreateKDF(dbname string, passphrase []byte, kdf *encdb.KDF) error {
+	err := encdb.CreateKDF(dbname, passphrase, kdf, []string{
 		createQueryKeyValue,
 		createQueryPrivateUIDs,
 		createQueryPublicUIDs,
@@ -223,11 +282,30 @@ func (keyDB *KeyDB) Version() (string, error) {
 
 // Open opens the key database with dbname and passphrase.
 func Open(dbname string, passphrase []byte) (*KeyDB, error) {
+	encDB, err := encdb.Open(dbname, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return open(encDB, false)
+}
+
+// OpenReadOnly opens the key database with dbname and passphrase read-only.
+// All methods which modify the database fail.
+func OpenReadOnly(dbname string, passphrase []byte) (*KeyDB, error) {
+	encDB, err := encdb.OpenReadOnly(dbname, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return open(encDB, true)
+}
+
+func open(encDB *sql.DB, readOnly bool) (*KeyDB, error) {
 	var keyDB KeyDB
 	var err error
-	// open database
-	keyDB.encDB, err = encdb.Open(dbname, passphrase)
-	if err != nil {
+	keyDB.encDB = encDB
+	// upgrade database, if necessary
+	if err := upgrade(keyDB.encDB, readOnly); err != nil {
+		keyDB.encDB.Close()
 		return nil, err
 	}
 	// prepare statements
@@ -271,6 +349,14 @@ func Open(dbname string, passphrase []byte) (*KeyDB, error) {
 		keyDB.encDB.Close()
 		return nil, err
 	}
+	if keyDB.getPrivateKeyInitsQuery, err = keyDB.encDB.Prepare(getPrivateKeyInitsQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.getPrivateKeyInitSigQuery, err = keyDB.encDB.Prepare(getPrivateKeyInitSigQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
 	if keyDB.addPublicKeyInitQuery, err = keyDB.encDB.Prepare(addPublicKeyInitQuery); err != nil {
 		keyDB.encDB.Close()
 		return nil, err
@@ -287,6 +373,10 @@ func Open(dbname string, passphrase []byte) (*KeyDB, error) {
 		keyDB.encDB.Close()
 		return nil, err
 	}
+	if keyDB.getPublicIdentitiesQuery, err = keyDB.encDB.Prepare(getPublicIdentitiesQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
 	if keyDB.getSessionQuery, err = keyDB.encDB.Prepare(getSessionQuery); err != nil {
 		keyDB.encDB.Close()
 		return nil, err
@@ -303,6 +393,18 @@ func Open(dbname string, passphrase []byte) (*KeyDB, error) {
 		keyDB.encDB.Close()
 		return nil, err
 	}
+	if keyDB.touchSessionQuery, err = keyDB.encDB.Prepare(touchSessionQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delOldSessionsQuery, err = keyDB.encDB.Prepare(delOldSessionsQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delOldMessageKeysQuery, err = keyDB.encDB.Prepare(delOldMessageKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
 	if keyDB.addMessageKeyQuery, err = keyDB.encDB.Prepare(addMessageKeyQuery); err != nil {
 		keyDB.encDB.Close()
 		return nil, err
@@ -343,6 +445,14 @@ func Open(dbname string, passphrase []byte) (*KeyDB, error) {
 		keyDB.encDB.Close()
 		return nil, err
 	}
+	if keyDB.getSessionStatesQuery, err = keyDB.encDB.Prepare(getSessionStatesQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delOldSessionStatesQuery, err = keyDB.encDB.Prepare(delOldSessionStatesQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
 	if keyDB.updateSessionKeyQuery, err = keyDB.encDB.Prepare(updateSessionKeyQuery); err != nil {
 		keyDB.encDB.Close()
 		return nil, err
@@ -355,6 +465,66 @@ func Open(dbname string, passphrase []byte) (*KeyDB, error) {
 		keyDB.encDB.Close()
 		return nil, err
 	}
+	if keyDB.cleanupSessionKeysQuery, err = keyDB.encDB.Prepare(cleanupSessionKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.getPrivateUIDsQuery, err = keyDB.encDB.Prepare(getPrivateUIDsQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.getPublicUIDsQuery, err = keyDB.encDB.Prepare(getPublicUIDsQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.getKeyInitHashesQuery, err = keyDB.encDB.Prepare(getKeyInitHashesQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delPrivateKeyInitQuery, err = keyDB.encDB.Prepare(delPrivateKeyInitQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delSessionStateQuery, err = keyDB.encDB.Prepare(delSessionStateQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.getOldSessionKeysQuery, err = keyDB.encDB.Prepare(getOldSessionKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delSessionKeyQuery, err = keyDB.encDB.Prepare(delSessionKeyQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.countOrphanMsgKeysQuery, err = keyDB.encDB.Prepare(countOrphanMsgKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delOrphanMsgKeysQuery, err = keyDB.encDB.Prepare(delOrphanMsgKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.getSessionsQuery, err = keyDB.encDB.Prepare(getSessionsQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.countExcessMsgKeysQuery, err = keyDB.encDB.Prepare(countExcessMsgKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delExcessMsgKeysQuery, err = keyDB.encDB.Prepare(delExcessMsgKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.countDupMsgKeysQuery, err = keyDB.encDB.Prepare(countDupMsgKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
+	if keyDB.delDupMsgKeysQuery, err = keyDB.encDB.Prepare(delDupMsgKeysQuery); err != nil {
+		keyDB.encDB.Close()
+		return nil, err
+	}
 	return &keyDB, nil
 }
 
@@ -370,6 +540,20 @@ func Rekey(dbname string, oldPassphrase, newPassphrase []byte, newIter int) erro
 	return encdb.Rekey(dbname, oldPassphrase, newPassphrase, newIter)
 }
 
+// Reiterate changes the number of KDF iterations of the key database dbname
+// to newIter without changing the passphrase, which must be correct.
+// The KDF algorithm is kept (see encdb.Reiterate).
+func Reiterate(dbname string, passphrase []byte, newIter int) error {
+	return encdb.Reiterate(dbname, passphrase, newIter)
+}
+
+// CheckPassphrase checks whether passphrase is the correct passphrase of the
+// key database dbname without opening it for writes. A wrong passphrase
+// results in encdb.ErrWrongPassphrase.
+func CheckPassphrase(dbname string, passphrase []byte) error {
+	return encdb.CheckPassphrase(dbname, passphrase)
+}
+
 // Status returns the autoVacuum mode and freelistCount of keyDB.
 func (keyDB *KeyDB) Status() (
 	autoVacuum string,
@@ -379,6 +563,12 @@ func (keyDB *KeyDB) Status() (
 	return encdb.Status(keyDB.encDB)
 }
 
+// Integrity runs an integrity check on keyDB and returns the result, which is
+// "ok" if no problems were found.
+func (keyDB *KeyDB) Integrity() (string, error) {
+	return encdb.Integrity(keyDB.encDB)
+}
+
 // Vacuum executes VACUUM command in keyDB. If autoVacuumMode is not nil and
 // different from the current one, the auto_vacuum mode is changed before
 // VACUUM is executed.
@@ -556,6 +746,48 @@ func (keyDB *KeyDB) GetPrivateKeyInit(
 	}
 }
 
+// GetPrivateKeyInits returns all private KeyInit messages for the given
+// sigKeyHash (the SIGKEYHASH of the corresponding UID message).
+func (keyDB *KeyDB) GetPrivateKeyInits(sigKeyHash string) ([]*uid.KeyInit, error) {
+	var kis []*uid.KeyInit
+	rows, err := keyDB.getPrivateKeyInitsQuery.Query(sigKeyHash)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var json string
+		if err := rows.Scan(&json); err != nil {
+			return nil, log.Error(err)
+		}
+		ki, err := uid.NewJSONKeyInit([]byte(json))
+		if err != nil {
+			return nil, err
+		}
+		kis = append(kis, ki)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return kis, nil
+}
+
+// GetPrivateKeyInitSig returns the server signature of the private KeyInit
+// for the given pubKeyHash (as returned by the key server when the KeyInit was
+// added). If no such KeyInit could be found, sql.ErrNoRows is returned.
+func (keyDB *KeyDB) GetPrivateKeyInitSig(pubKeyHash string) (string, error) {
+	var serverSignature string
+	err := keyDB.getPrivateKeyInitSigQuery.QueryRow(pubKeyHash).Scan(&serverSignature)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", sql.ErrNoRows
+	case err != nil:
+		return "", log.Error(err)
+	default:
+		return serverSignature, nil
+	}
+}
+
 // AddPublicKeyInit adds a public KeyInit message to keyDB.
 func (keyDB *KeyDB) AddPublicKeyInit(ki *uid.KeyInit) error {
 	_, err := keyDB.addPublicKeyInitQuery.Exec(ki.SigKeyHash(), ki.JSON())
@@ -565,12 +797,14 @@ func (keyDB *KeyDB) AddPublicKeyInit(ki *uid.KeyInit) error {
 	return nil
 }
 
-// GetPublicKeyInit gets a public key init from keydb.
+// GetPublicKeyInit gets the most recently added public key init from keydb.
 // If no such KeyInit could be found, sql.ErrNoRows is returned.
 func (keyDB *KeyDB) GetPublicKeyInit(sigKeyHash string) (*uid.KeyInit, error) {
 	var json string
 	err := keyDB.getPublicKeyInitQuery.QueryRow(sigKeyHash).Scan(&json)
 	switch {
+	case err == sql.ErrNoRows:
+		return nil, sql.ErrNoRows
 	case err != nil:
 		return nil, log.Error(err)
 	default:
@@ -620,6 +854,27 @@ func (keyDB *KeyDB) GetPublicUID(
 	}
 }
 
+// GetPublicIdentities returns all public identities from keyDB.
+func (keyDB *KeyDB) GetPublicIdentities() ([]string, error) {
+	var identities []string
+	rows, err := keyDB.getPublicIdentitiesQuery.Query()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identity string
+		if err := rows.Scan(&identity); err != nil {
+			return nil, log.Error(err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return identities, nil
+}
+
 // AddHashChainEntry adds the hash chain entry at position for the given
 // domain to keyDB.
 func (keyDB *KeyDB) AddHashChainEntry(
@@ -6,6 +6,7 @@ package keydb
 
 import (
 	"bytes"
+	"database/sql"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -250,6 +251,16 @@ func TestPrivateKeyInit(t *testing.T) {
 	if rPrivKey != privateKey {
 		t.Error("PrivKeys differ")
 	}
+	sig, err := keyDB.GetPrivateKeyInitSig(pubKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig != "/63l/c3XB5yimoGKv6GS9TjuiM3PKVH/H/dlhnQixeIRsFRkWRl8fjXmKyQl5bk4N7DjkBPg/1GQVndhG+HWAg==" {
+		t.Errorf("server signature == %q", sig)
+	}
+	if _, err := keyDB.GetPrivateKeyInitSig("unknown"); err != sql.ErrNoRows {
+		t.Errorf("sql.ErrNoRows expected, got: %v", err)
+	}
 }
 
 func TestPublicKeyInit(t *testing.T) {
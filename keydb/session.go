@@ -8,6 +8,8 @@ import (
 	"database/sql"
 
 	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/util/times"
 )
 
 // AddSession adds a session for the given sessionKey. A session
@@ -42,7 +44,7 @@ func (keyDB *KeyDB) AddSession(
 	case err == sql.ErrNoRows:
 		// store new session
 		res, err = tx.Stmt(keyDB.insertSessionQuery).Exec(sessionKey,
-			rootKeyHash, chainKey, len(send))
+			rootKeyHash, chainKey, len(send), times.Now())
 		if err != nil {
 			tx.Rollback()
 			return log.Error(err)
@@ -53,7 +55,7 @@ func (keyDB *KeyDB) AddSession(
 	default:
 		// update session
 		res, err = tx.Stmt(keyDB.updateSessionQuery).Exec(chainKey,
-			offset+uint64(len(send)), sessionKey)
+			offset+uint64(len(send)), times.Now(), sessionKey)
 		if err != nil {
 			tx.Rollback()
 			return log.Error(err)
@@ -112,6 +114,9 @@ func (keyDB *KeyDB) GetSession(sessionKey string) (
 }
 
 // GetMessageKey returns the message key for the given sessionKey.
+// If the message key has been deleted with DelMessageKey already,
+// session.ErrMessageKeyUsed is returned. If the session or the message key
+// does not exist, sql.ErrNoRows is returned.
 func (keyDB *KeyDB) GetMessageKey(
 	sessionKey string,
 	sender bool,
@@ -128,13 +133,25 @@ func (keyDB *KeyDB) GetMessageKey(
 	}
 	var key string
 	err = keyDB.getMessageKeyQuery.QueryRow(sessionID, msgIndex, d).Scan(&key)
-	if err != nil {
+	switch {
+	case err == sql.ErrNoRows:
+		// message keys within the session which are missing have been used
+		_, _, numOfKeys, err := keyDB.GetSession(sessionKey)
+		if err != nil {
+			return "", err
+		}
+		if msgIndex < numOfKeys {
+			return "", log.Error(session.ErrMessageKeyUsed)
+		}
+		return "", sql.ErrNoRows
+	case err != nil:
 		return "", err
 	}
 	return key, nil
 }
 
 // DelMessageKey deletes the message key for the given sessionKey.
+// Deleting a message key which has been deleted already is not an error.
 func (keyDB *KeyDB) DelMessageKey(
 	sessionKey string,
 	sender bool,
@@ -153,5 +170,118 @@ func (keyDB *KeyDB) DelMessageKey(
 	if err != nil {
 		return err
 	}
+	// using up a message key counts as session activity
+	_, err = keyDB.touchSessionQuery.Exec(times.Now(), sessionID)
+	if err != nil {
+		return err
+	}
 	return nil
 }
+
+// PruneSessions deletes all sessions, their message keys, and all session
+// states without any activity since t (Unix time). It returns the number of
+// deleted session states.
+func (keyDB *KeyDB) PruneSessions(t int64) (int64, error) {
+	tx, err := keyDB.encDB.Begin()
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	if _, err := tx.Stmt(keyDB.delOldMessageKeysQuery).Exec(t); err != nil {
+		tx.Rollback()
+		return 0, log.Error(err)
+	}
+	if _, err := tx.Stmt(keyDB.delOldSessionsQuery).Exec(t); err != nil {
+		tx.Rollback()
+		return 0, log.Error(err)
+	}
+	res, err := tx.Stmt(keyDB.delOldSessionStatesQuery).Exec(t)
+	if err != nil {
+		tx.Rollback()
+		return 0, log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, log.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, log.Error(err)
+	}
+	return n, nil
+}
+
+// SessionCheck describes the inconsistencies of a single session found by
+// CheckSessions.
+type SessionCheck struct {
+	SessionKey string // key of the session
+	NumOfKeys  int64  // declared number of message keys per direction
+	Excess     int64  // message keys with an index beyond NumOfKeys
+	Duplicates int64  // message keys stored more than once for an index
+}
+
+// CheckSessions verifies for all sessions in keyDB that the stored message
+// keys match the declared number of keys. It returns the sessions with
+// inconsistencies. If repair is true, excess message keys are removed and
+// of duplicate keys only the first stored one (the one in use) is kept.
+func (keyDB *KeyDB) CheckSessions(repair bool) ([]*SessionCheck, error) {
+	rows, err := keyDB.getSessionsQuery.Query()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer rows.Close()
+	var (
+		ids    []int64
+		checks []*SessionCheck
+	)
+	for rows.Next() {
+		var (
+			id    int64
+			check SessionCheck
+		)
+		if err := rows.Scan(&id, &check.SessionKey, &check.NumOfKeys); err != nil {
+			return nil, log.Error(err)
+		}
+		err := keyDB.countExcessMsgKeysQuery.QueryRow(id,
+			check.NumOfKeys).Scan(&check.Excess)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		err = keyDB.countDupMsgKeysQuery.QueryRow(id, check.NumOfKeys,
+			id).Scan(&check.Duplicates)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		if check.Excess > 0 || check.Duplicates > 0 {
+			ids = append(ids, id)
+			checks = append(checks, &check)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	if !repair || len(checks) == 0 {
+		return checks, nil
+	}
+	tx, err := keyDB.encDB.Begin()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	for i, check := range checks {
+		_, err := tx.Stmt(keyDB.delExcessMsgKeysQuery).Exec(ids[i],
+			check.NumOfKeys)
+		if err != nil {
+			tx.Rollback()
+			return nil, log.Error(err)
+		}
+		_, err = tx.Stmt(keyDB.delDupMsgKeysQuery).Exec(ids[i],
+			check.NumOfKeys, ids[i])
+		if err != nil {
+			tx.Rollback()
+			return nil, log.Error(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, log.Error(err)
+	}
+	return checks, nil
+}
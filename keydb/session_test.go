@@ -8,12 +8,17 @@ import (
 	"crypto/sha512"
 	"database/sql"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/mutecomm/mute/cipher"
 	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/times"
 	"golang.org/x/crypto/hkdf"
 )
 
@@ -111,3 +116,214 @@ func TestSessions(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDelMessageKey(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "keydb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	dbname := filepath.Join(tmpdir, "keydb")
+	passphrase := []byte(cipher.RandPass(cipher.RandReader))
+	if err := Create(dbname, passphrase, 64000); err != nil {
+		t.Fatal(err)
+	}
+	keyDB, err := Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionKey := base64.Encode(cipher.SHA512([]byte("key")))
+	err = keyDB.AddSession(sessionKey, "rootKeyHash", "chainKey",
+		[]string{"send0", "send1"}, []string{"recv0", "recv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := keyDB.GetMessageKey(sessionKey, false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "recv1" {
+		t.Errorf("key == %s != recv1", key)
+	}
+	if err := keyDB.DelMessageKey(sessionKey, false, 1); err != nil {
+		t.Fatal(err)
+	}
+	// deletion is idempotent
+	if err := keyDB.DelMessageKey(sessionKey, false, 1); err != nil {
+		t.Error(err)
+	}
+	if _, err := keyDB.GetMessageKey(sessionKey, false, 1); err != session.ErrMessageKeyUsed {
+		t.Errorf("should fail with session.ErrMessageKeyUsed: %v", err)
+	}
+	// the sender key with the same index is still available
+	key, err = keyDB.GetMessageKey(sessionKey, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "send1" {
+		t.Errorf("key == %s != send1", key)
+	}
+	// keys beyond the session and of unknown sessions do not exist
+	if _, err := keyDB.GetMessageKey(sessionKey, false, 2); err != sql.ErrNoRows {
+		t.Errorf("should fail with sql.ErrNoRows: %v", err)
+	}
+	unknown := base64.Encode(cipher.SHA512([]byte("unknown")))
+	if _, err := keyDB.GetMessageKey(unknown, false, 0); err != sql.ErrNoRows {
+		t.Errorf("should fail with sql.ErrNoRows: %v", err)
+	}
+	// deletion survives a restart
+	if err := keyDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	keyDB, err = Open(dbname, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyDB.Close()
+	if _, err := keyDB.GetMessageKey(sessionKey, false, 1); err != session.ErrMessageKeyUsed {
+		t.Errorf("should fail with session.ErrMessageKeyUsed after reopen: %v", err)
+	}
+	if _, err := keyDB.GetMessageKey(sessionKey, false, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPruneSessions(t *testing.T) {
+	tmpdir, keyDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer keyDB.Close()
+	var rt, ssp uid.KeyEntry
+	if err := rt.InitDHKey(cipher.RandReader); err != nil {
+		t.Fatal(err)
+	}
+	if err := ssp.InitDHKey(cipher.RandReader); err != nil {
+		t.Fatal(err)
+	}
+	ss := &session.State{
+		RecipientTemp:    rt,
+		SenderSessionPub: ssp,
+		NymAddress:       "NYMADDRESS",
+	}
+	oldKey := base64.Encode(cipher.SHA512([]byte("old")))
+	newKey := base64.Encode(cipher.SHA512([]byte("new")))
+	for _, key := range []string{oldKey, newKey} {
+		if err := keyDB.SetSessionState(key, ss); err != nil {
+			t.Fatal(err)
+		}
+		err := keyDB.AddSession(key, "rootKeyHash", "chainKey",
+			[]string{"send"}, []string{"recv"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// age old session
+	old := times.Now() - 100*int64(times.Day)
+	_, err = keyDB.encDB.Exec("UPDATE SessionStates SET LastActivity=? WHERE SessionStateKey=?;",
+		old, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = keyDB.encDB.Exec("UPDATE Sessions SET LastActivity=? WHERE SessionKey=?;",
+		old, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// prune sessions older than 90 days
+	n, err := keyDB.PruneSessions(times.Now() - 90*int64(times.Day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("n == %d != 1", n)
+	}
+	infos, err := keyDB.GetSessionStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].SessionStateKey != newKey {
+		t.Error("recent session state should be kept")
+	}
+	if ss, err := keyDB.GetSessionState(oldKey); err != nil || ss != nil {
+		t.Error("old session state should be pruned")
+	}
+	if _, _, _, err := keyDB.GetSession(oldKey); err != sql.ErrNoRows {
+		t.Error("old session should be pruned")
+	}
+	if _, err := keyDB.GetMessageKey(oldKey, true, 0); err != sql.ErrNoRows {
+		t.Error("message keys of old session should be pruned")
+	}
+	if _, err := keyDB.GetMessageKey(newKey, true, 0); err != nil {
+		t.Error("message keys of recent session should be kept")
+	}
+}
+
+func TestCheckSessions(t *testing.T) {
+	tmpdir, keyDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer keyDB.Close()
+	okKey := base64.Encode(cipher.SHA512([]byte("ok")))
+	badKey := base64.Encode(cipher.SHA512([]byte("bad")))
+	for _, key := range []string{okKey, badKey} {
+		err := keyDB.AddSession(key, "rootKeyHash", "chainKey",
+			[]string{"send0", "send1"}, []string{"recv0", "recv1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// seed mismatch: one key beyond NumOfKeys and a duplicate key
+	var sessionID int64
+	err = keyDB.getSessionIDQuery.QueryRow(badKey).Scan(&sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{2, 0} {
+		_, err = keyDB.encDB.Exec("INSERT INTO MessageKeys (SessionID, Number, Key, Direction) VALUES (?, ?, ?, ?);",
+			sessionID, n, "bogus", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// check only
+	checks, err := keyDB.CheckSessions(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 1 {
+		t.Fatalf("len(checks) == %d != 1", len(checks))
+	}
+	c := checks[0]
+	if c.SessionKey != badKey || c.NumOfKeys != 2 || c.Excess != 1 ||
+		c.Duplicates != 1 {
+		t.Errorf("wrong session check: %+v", c)
+	}
+	// repair
+	if _, err := keyDB.CheckSessions(true); err != nil {
+		t.Fatal(err)
+	}
+	checks, err = keyDB.CheckSessions(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 0 {
+		t.Errorf("%d inconsistent session(s) left", len(checks))
+	}
+	key, err := keyDB.GetMessageKey(badKey, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "send0" {
+		t.Errorf("key == %s != send0", key)
+	}
+	if _, err := keyDB.GetMessageKey(badKey, true, 2); err != sql.ErrNoRows {
+		t.Error("excess message key should be removed")
+	}
+	if _, err := keyDB.GetMessageKey(okKey, false, 1); err != nil {
+		t.Error("message keys of consistent session should be kept")
+	}
+}
@@ -61,3 +61,13 @@ func (keyDB *KeyDB) DelPrivSessionKey(hash string) error {
 	}
 	return nil
 }
+
+// CleanupSessionKeys deletes all session keys from keyDB which have a cleanup
+// time before t.
+func (keyDB *KeyDB) CleanupSessionKeys(t uint64) error {
+	_, err := keyDB.cleanupSessionKeysQuery.Exec(t)
+	if err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
@@ -96,3 +96,46 @@ func TestSessionKeys(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCleanupSessionKeys(t *testing.T) {
+	tmpdir, keyDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer keyDB.Close()
+	var (
+		old   uid.KeyEntry
+		fresh uid.KeyEntry
+	)
+	if err := old.InitDHKey(cipher.RandReader); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.InitDHKey(cipher.RandReader); err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	err = keyDB.AddSessionKey(old.HASH, string(old.JSON()), old.PrivateKey(),
+		now-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyDB.AddSessionKey(fresh.HASH, string(fresh.JSON()),
+		fresh.PrivateKey(), now+msg.CleanupTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyDB.CleanupSessionKeys(now); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keyDB.GetSessionKey(old.HASH); err != sql.ErrNoRows {
+		t.Error("old session key should be deleted")
+	}
+	if _, _, err := keyDB.GetSessionKey(fresh.HASH); err != nil {
+		t.Error(err)
+	}
+	// cleaning up again should not fail
+	if err := keyDB.CleanupSessionKeys(now); err != nil {
+		t.Error(err)
+	}
+}
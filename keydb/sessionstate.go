@@ -10,9 +10,12 @@ import (
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/msg/session"
 	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/times"
 )
 
 // GetSessionState retrieves the session state for sessionStateKey from keyDB.
+// If no session state exists for sessionStateKey, nil is returned (without an
+// error).
 func (keyDB *KeyDB) GetSessionState(sessionStateKey string) (
 	*session.State,
 	error,
@@ -112,7 +115,7 @@ func (keyDB *KeyDB) SetSessionState(
 			sessionState.SenderMessageCount, sessionState.MaxRecipientCount,
 			sessionState.RecipientTemp.JSON(),
 			sessionState.SenderSessionPub.JSON(), nssp, nrsps,
-			sessionState.NymAddress, kis, sessionStateKey)
+			sessionState.NymAddress, kis, times.Now(), sessionStateKey)
 	if err != nil {
 		return log.Error(err)
 	}
@@ -125,10 +128,38 @@ func (keyDB *KeyDB) SetSessionState(
 			sessionState.SenderSessionCount, sessionState.SenderMessageCount,
 			sessionState.MaxRecipientCount, sessionState.RecipientTemp.JSON(),
 			sessionState.SenderSessionPub.JSON(), nssp, nrsps,
-			sessionState.NymAddress, kis)
+			sessionState.NymAddress, kis, times.Now())
 		if err != nil {
 			return log.Error(err)
 		}
 	}
 	return nil
 }
+
+// SessionStateInfo describes a session state stored in keyDB.
+type SessionStateInfo struct {
+	SessionStateKey string
+	LastActivity    int64 // Unix time of the last update
+}
+
+// GetSessionStates returns all session states from keyDB, the most recently
+// active first.
+func (keyDB *KeyDB) GetSessionStates() ([]*SessionStateInfo, error) {
+	rows, err := keyDB.getSessionStatesQuery.Query()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer rows.Close()
+	var infos []*SessionStateInfo
+	for rows.Next() {
+		var info SessionStateInfo
+		if err := rows.Scan(&info.SessionStateKey, &info.LastActivity); err != nil {
+			return nil, log.Error(err)
+		}
+		infos = append(infos, &info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return infos, nil
+}
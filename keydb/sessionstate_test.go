@@ -41,6 +41,15 @@ func TestSessionStates(t *testing.T) {
 	}
 	sessionStateKey1 := base64.Encode(cipher.SHA512([]byte("key1")))
 	sessionStateKey2 := base64.Encode(cipher.SHA512([]byte("key2")))
+	sessionStateKey3 := base64.Encode(cipher.SHA512([]byte("key3")))
+	// no session state exists yet
+	ss, err := keyDB.GetSessionState(sessionStateKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss != nil {
+		t.Error("missing session state should be nil")
+	}
 	ss1 := &session.State{
 		SenderSessionCount:          1,
 		SenderMessageCount:          2,
@@ -87,4 +96,23 @@ func TestSessionStates(t *testing.T) {
 	if !session.StateEqual(ss2, ss1db) {
 		t.Error("ss2 and ss1db differ")
 	}
+	// only one of the optional session pubs is set
+	ss3 := &session.State{
+		RecipientTemp:        rt,
+		SenderSessionPub:     ssp,
+		NextSenderSessionPub: &nssp,
+	}
+	if err := keyDB.SetSessionState(sessionStateKey3, ss3); err != nil {
+		t.Fatal(err)
+	}
+	ss3db, err := keyDB.GetSessionState(sessionStateKey3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.StateEqual(ss3, ss3db) {
+		t.Error("ss3 and ss3db differ")
+	}
+	if ss3db.NextRecipientSessionPubSeen != nil {
+		t.Error("ss3db.NextRecipientSessionPubSeen should be nil")
+	}
 }
@@ -0,0 +1,85 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package keydb
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// upgradeQueries contains the statements to upgrade a keyDB from the
+// version given as key to the next version. The parameter of each query (if
+// any) is set to the current time.
+var upgradeQueries = map[string][]string{
+	"1": {
+		"ALTER TABLE Sessions ADD COLUMN LastActivity INTEGER NOT NULL DEFAULT 0;",
+		"ALTER TABLE SessionStates ADD COLUMN LastActivity INTEGER NOT NULL DEFAULT 0;",
+		"UPDATE Sessions SET LastActivity=?;",
+		"UPDATE SessionStates SET LastActivity=?;",
+	},
+}
+
+// upgrade brings an existing keyDB to the current Version. Existing sessions
+// are treated as if they were active at the time of the upgrade. Read-only
+// databases cannot be upgraded.
+func upgrade(encDB *sql.DB, readOnly bool) error {
+	var version string
+	err := encDB.QueryRow(getValueQuery, DBVersion).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		// database is just being created, Create sets the version
+		return nil
+	case err != nil:
+		return log.Error(err)
+	}
+	if version == Version {
+		return nil
+	}
+	if readOnly {
+		return log.Errorf("keydb: cannot upgrade read-only database from "+
+			"version %s to %s", version, Version)
+	}
+	log.Infof("keydb: upgrade from version %s to %s", version, Version)
+	tx, err := encDB.Begin()
+	if err != nil {
+		return log.Error(err)
+	}
+	now := times.Now()
+	for version != Version {
+		queries, ok := upgradeQueries[version]
+		if !ok {
+			tx.Rollback()
+			return log.Errorf("keydb: cannot upgrade from version %s", version)
+		}
+		for _, query := range queries {
+			var args []interface{}
+			if strings.Contains(query, "?") {
+				args = append(args, now)
+			}
+			if _, err := tx.Exec(query, args...); err != nil {
+				tx.Rollback()
+				return log.Error(err)
+			}
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			tx.Rollback()
+			return log.Error(err)
+		}
+		version = strconv.Itoa(v + 1)
+	}
+	if _, err := tx.Exec(updateValueQuery, Version, DBVersion); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
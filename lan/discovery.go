@@ -0,0 +1,282 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lan implements the discovery of Mute peers in the local network via
+// mDNS and the direct transport of (end-to-end encrypted) messages between
+// them, without using the mix network.
+package lan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mutecomm/mute/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ServiceName is the mDNS service name of Mute peers.
+const ServiceName = "_mute._tcp.local."
+
+// txtPrefix is the prefix of the TXT record which contains the UID of a peer.
+const txtPrefix = "uid="
+
+// ttl is the time to live of announced records (in seconds).
+const ttl = 120
+
+// MulticastAddr is the mDNS multicast address.
+var MulticastAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// ListenMulticast returns a connection which receives mDNS queries sent to
+// the MulticastAddr (to be used with a Responder).
+func ListenMulticast() (net.PacketConn, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, MulticastAddr)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	return conn, nil
+}
+
+// Peer is a Mute peer discovered in the local network.
+type Peer struct {
+	UID  string // the mapped user ID of the peer
+	Addr string // the address (host:port) of the peer's direct transport
+}
+
+// instanceName returns the mDNS instance name for the given uid. The UID is
+// hashed, because it can contain characters which are not allowed in labels.
+func instanceName(uid string) string {
+	h := sha256.Sum256([]byte(uid))
+	return hex.EncodeToString(h[:8]) + "." + ServiceName
+}
+
+// Responder answers mDNS queries for announced UIDs.
+type Responder struct {
+	conn  net.PacketConn
+	mutex sync.Mutex
+	ports map[string]uint16 // UID -> port of direct transport
+}
+
+// NewResponder returns a new Responder which answers queries received on
+// conn.
+func NewResponder(conn net.PacketConn) *Responder {
+	return &Responder{
+		conn:  conn,
+		ports: make(map[string]uint16),
+	}
+}
+
+// Announce announces the presence of uid, which receives messages on the
+// given port.
+func (r *Responder) Announce(uid string, port uint16) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ports[uid] = port
+}
+
+// Withdraw stops announcing the presence of uid.
+func (r *Responder) Withdraw(uid string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.ports, uid)
+}
+
+// response returns the mDNS response for all announced UIDs.
+func (r *Responder) response(id uint16) ([]byte, error) {
+	service, err := dnsmessage.NewName(ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	target, err := dnsmessage.NewName("mute.local.")
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:            id,
+			Response:      true,
+			Authoritative: true,
+		},
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for uid, port := range r.ports {
+		instance, err := dnsmessage.NewName(instanceName(uid))
+		if err != nil {
+			return nil, err
+		}
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{
+				Name:  service,
+				Class: dnsmessage.ClassINET,
+				TTL:   ttl,
+			},
+			Body: &dnsmessage.PTRResource{PTR: instance},
+		})
+		msg.Additionals = append(msg.Additionals,
+			dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{
+					Name:  instance,
+					Class: dnsmessage.ClassINET,
+					TTL:   ttl,
+				},
+				Body: &dnsmessage.SRVResource{Port: port, Target: target},
+			},
+			dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{
+					Name:  instance,
+					Class: dnsmessage.ClassINET,
+					TTL:   ttl,
+				},
+				Body: &dnsmessage.TXTResource{TXT: []string{txtPrefix + uid}},
+			},
+		)
+	}
+	if len(msg.Answers) == 0 {
+		return nil, nil
+	}
+	return msg.Pack()
+}
+
+// Serve answers queries for ServiceName until the connection is closed.
+// Responses are sent directly to the querier (unicast).
+func (r *Responder) Serve() error {
+	buf := make([]byte, 9000)
+	for {
+		n, addr, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil {
+			log.Debugf("lan: cannot parse mDNS packet: %s", err)
+			continue
+		}
+		if query.Header.Response || !asksForService(query.Questions) {
+			continue
+		}
+		resp, err := r.response(query.Header.ID)
+		if err != nil {
+			return log.Error(err)
+		}
+		if resp == nil {
+			continue // nothing to announce
+		}
+		if _, err := r.conn.WriteTo(resp, addr); err != nil {
+			log.Warnf("lan: cannot send mDNS response: %s", err)
+		}
+	}
+}
+
+// asksForService returns true, if questions contain a PTR query for
+// ServiceName.
+func asksForService(questions []dnsmessage.Question) bool {
+	for _, q := range questions {
+		if q.Type == dnsmessage.TypePTR &&
+			strings.EqualFold(q.Name.String(), ServiceName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve queries for Mute peers by sending an mDNS query from conn to dst
+// and collects all responses received within timeout.
+func Resolve(conn net.PacketConn, dst net.Addr, timeout time.Duration) ([]*Peer, error) {
+	service, err := dnsmessage.NewName(ServiceName)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{
+				Name:  service,
+				Type:  dnsmessage.TypePTR,
+				Class: dnsmessage.ClassINET | 1<<15, // request unicast response
+			},
+		},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	if _, err := conn.WriteTo(packet, dst); err != nil {
+		return nil, log.Error(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, log.Error(err)
+	}
+	var peers []*Peer
+	seen := make(map[string]bool)
+	buf := make([]byte, 9000)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				break
+			}
+			return nil, log.Error(err)
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil || !resp.Header.Response {
+			continue
+		}
+		for _, peer := range parsePeers(&resp, udpAddr.IP) {
+			if !seen[peer.UID] {
+				seen[peer.UID] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+	return peers, nil
+}
+
+// parsePeers returns the peers contained in the mDNS response resp, which was
+// sent from ip.
+func parsePeers(resp *dnsmessage.Message, ip net.IP) []*Peer {
+	ports := make(map[string]uint16)
+	uids := make(map[string]string)
+	for _, r := range append(resp.Answers, resp.Additionals...) {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			ports[name] = body.Port
+		case *dnsmessage.TXTResource:
+			for _, txt := range body.TXT {
+				if strings.HasPrefix(txt, txtPrefix) {
+					uids[name] = strings.TrimPrefix(txt, txtPrefix)
+				}
+			}
+		}
+	}
+	var peers []*Peer
+	for _, r := range resp.Answers {
+		ptr, ok := r.Body.(*dnsmessage.PTRResource)
+		if !ok || !strings.EqualFold(r.Header.Name.String(), ServiceName) {
+			continue
+		}
+		name := strings.ToLower(ptr.PTR.String())
+		port, ok := ports[name]
+		if !ok {
+			continue
+		}
+		uid, ok := uids[name]
+		if !ok || instanceName(uid) != name {
+			continue // UID does not match instance name
+		}
+		peers = append(peers, &Peer{
+			UID:  uid,
+			Addr: net.JoinHostPort(ip.String(), strconv.Itoa(int(port))),
+		})
+	}
+	return peers
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lan
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAnnounceResolve(t *testing.T) {
+	// responder on loopback interface
+	rconn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rconn.Close()
+	responder := NewResponder(rconn)
+	responder.Announce("alice@mute.berlin", 4711)
+	responder.Announce("bob@mute.berlin", 4712)
+	responder.Announce("carol@mute.berlin", 4713)
+	responder.Withdraw("carol@mute.berlin")
+	go responder.Serve()
+	// resolve peers
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peers, err := Resolve(conn, rconn.LocalAddr(), 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].UID < peers[j].UID })
+	if len(peers) != 2 {
+		t.Fatalf("len(peers) == %d != 2", len(peers))
+	}
+	if peers[0].UID != "alice@mute.berlin" || peers[0].Addr != "127.0.0.1:4711" {
+		t.Errorf("unexpected peer: %+v", peers[0])
+	}
+	if peers[1].UID != "bob@mute.berlin" || peers[1].Addr != "127.0.0.1:4712" {
+		t.Errorf("unexpected peer: %+v", peers[1])
+	}
+}
+
+func TestResolveNothing(t *testing.T) {
+	rconn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rconn.Close()
+	go NewResponder(rconn).Serve()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peers, err := Resolve(conn, rconn.LocalAddr(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("len(peers) == %d != 0", len(peers))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []byte, 1)
+	go Serve(l, time.Second, func(msg []byte) {
+		received <- msg
+	})
+	msg := []byte("encrypted message")
+	if err := Send(l.Addr().String(), msg, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-received:
+		if !bytes.Equal(m, msg) {
+			t.Errorf("received %q != %q", m, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+	if err := Send(l.Addr().String(), make([]byte, MaxMessageSize+1), time.Second); err != ErrMessageTooLarge {
+		t.Errorf("Send should fail with ErrMessageTooLarge: %v", err)
+	}
+}
+
+func TestServeConcurrent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan []byte, 1)
+	go Serve(l, 10*time.Second, func(msg []byte) {
+		received <- msg
+	})
+	// a stalled connection must not block other senders
+	stalled, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	msg := []byte("encrypted message")
+	if err := Send(l.Addr().String(), msg, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-received:
+		if !bytes.Equal(m, msg) {
+			t.Errorf("received %q != %q", m, msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message blocked by stalled connection")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lan
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// MaxMessageSize is the maximum size of a message sent via direct transport.
+const MaxMessageSize = 1 << 20 // 1 MB
+
+// MaxConnections is the maximum number of connections Serve handles
+// concurrently. Further connections wait until one of them is done.
+const MaxConnections = 16
+
+// ErrMessageTooLarge is returned if a message exceeds MaxMessageSize.
+var ErrMessageTooLarge = errors.New("lan: message too large")
+
+// Send sends the (encrypted) message msg directly to the peer listening on
+// addr.
+func Send(addr string, msg []byte, timeout time.Duration) error {
+	if len(msg) > MaxMessageSize {
+		return log.Error(ErrMessageTooLarge)
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return log.Error(err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return log.Error(err)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// Serve accepts connections on l and calls handler for every received
+// message, until l is closed. Up to MaxConnections connections are handled
+// concurrently, every one of them must deliver its message within timeout.
+// Messages larger than MaxMessageSize are discarded. The handler can be
+// called concurrently.
+func Serve(l net.Listener, timeout time.Duration, handler func(msg []byte)) error {
+	sem := make(chan struct{}, MaxConnections)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			msg, err := receive(conn, timeout)
+			if err != nil {
+				log.Warnf("lan: cannot receive message from %s: %s",
+					conn.RemoteAddr(), err)
+				return
+			}
+			handler(msg)
+		}()
+	}
+}
+
+// receive reads a single message from conn and closes it.
+func receive(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	msg, err := ioutil.ReadAll(io.LimitReader(conn, MaxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	return msg, nil
+}
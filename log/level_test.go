@@ -0,0 +1,150 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// readLog flushes the logger and returns the content of the logfile in dir.
+func readLog(t *testing.T, dir string) string {
+	log.Flush()
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("one logfile expected, found %d", len(files))
+	}
+	buf, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestSetLevel(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer log.Init("info", "log  ", "", true)
+	if err := log.Init("info", "log  ", tmpdir, false); err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("first debug message")
+	if err := log.SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	if log.Level() != "debug" {
+		t.Errorf("log.Level() = %s, expected debug", log.Level())
+	}
+	log.Debug("second debug message")
+	if err := log.SetLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("third debug message")
+	content := readLog(t, tmpdir)
+	if strings.Contains(content, "first debug message") {
+		t.Error("debug message logged with level info")
+	}
+	if !strings.Contains(content, "second debug message") {
+		t.Error("debug message not logged with level debug")
+	}
+	if strings.Contains(content, "third debug message") {
+		t.Error("debug message logged with level warn")
+	}
+	if err := log.SetLevel("invalid"); err == nil {
+		t.Error("log.SetLevel() should fail for invalid level")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer log.Init("info", "log  ", "", true)
+	logdir := filepath.Join(tmpdir, "log")
+	if err := os.Mkdir(logdir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	traceFile := filepath.Join(tmpdir, "trace")
+	if err := log.Init("info", "log  ", logdir, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.StartTrace(traceFile); err != nil {
+		t.Fatal(err)
+	}
+	log.Trace("traced message")
+	log.Info("info message")
+	if err := log.StopTrace(); err != nil {
+		t.Fatal(err)
+	}
+	log.Trace("untraced message")
+	mainLog := readLog(t, logdir)
+	buf, err := ioutil.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := string(buf)
+	if !strings.Contains(trace, "traced message") {
+		t.Error("trace message missing in trace file")
+	}
+	if !strings.Contains(trace, "info message") {
+		t.Error("info message missing in trace file")
+	}
+	if strings.Contains(trace, "untraced message") {
+		t.Error("trace message after StopTrace() in trace file")
+	}
+	if strings.Contains(mainLog, "traced message") {
+		t.Error("trace message in main log")
+	}
+	if !strings.Contains(mainLog, "info message") {
+		t.Error("info message missing in main log")
+	}
+	if log.Level() != "info" {
+		t.Errorf("log.Level() = %s, expected info", log.Level())
+	}
+}
+
+func TestSetPrivate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "log_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer log.Init("info", "log  ", "", true)
+	defer log.SetPrivate(false)
+	if err := log.Init("info", "log  ", tmpdir, false); err != nil {
+		t.Fatal(err)
+	}
+	identity := "alice@mute.berlin"
+	log.SetPrivate(true)
+	log.Infof("active user ID: %s", identity)
+	err = log.Errorf("user ID %s unknown", identity)
+	if err.Error() != "user ID alice@mute.berlin unknown" {
+		t.Errorf("returned error must not be masked: %s", err)
+	}
+	content := readLog(t, tmpdir)
+	if strings.Contains(content, identity) {
+		t.Error("identity not masked in log")
+	}
+	token := log.MaskIdentity(identity)
+	if strings.Count(content, token) != 2 {
+		t.Errorf("masked token %s not found twice in log", token)
+	}
+	if log.MaskIdentity("bob@mute.berlin") == token {
+		t.Error("different identities must have different tokens")
+	}
+}
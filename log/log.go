@@ -23,6 +23,16 @@ func init() {
 	logger = seelog.Disabled
 }
 
+// parameters of last Init call, used by SetLevel
+var (
+	initialized  bool
+	initPrefix   string
+	initLogDir   string
+	initConsole  bool
+	currentLevel string
+	traceFile    string
+)
+
 // Init initializes the Mute logging framework to the given logging level.
 // If logDir is not nil logging is done to a logfile in the directory.
 // If logToConsole is true the console logging is activated.
@@ -40,6 +50,101 @@ func Init(logLevel, cmdPrefix, logDir string, logToConsole bool) error {
 		return fmt.Errorf("len(cmdPrefix) must be 5: %q", cmdPrefix)
 	}
 	// create logger
+	logger, err := newLogger(logLevel, cmdPrefix, logDir, logToConsole, "")
+	if err != nil {
+		return err
+	}
+	// replace logger
+	UseLogger(logger)
+	initialized = true
+	initPrefix = cmdPrefix
+	initLogDir = logDir
+	initConsole = logToConsole
+	currentLevel = logLevel
+	// log info about running binary
+	Infof("%s started (built with %s %s for %s/%s)", os.Args[0], runtime.Compiler, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// SetLevel changes the logging level of the logging framework initialized
+// with Init to the given logging level. All other parameters of the Init call
+// stay the same. If the given level is invalid or Init hasn't been called
+// before, an error is returned.
+func SetLevel(logLevel string) error {
+	_, found := seelog.LogLevelFromString(logLevel)
+	if !found {
+		return fmt.Errorf("log: level '%s' is invalid", logLevel)
+	}
+	if !initialized {
+		return errors.New("log: not initialized")
+	}
+	if err := replaceLogger(logLevel, traceFile); err != nil {
+		return err
+	}
+	currentLevel = logLevel
+	Infof("log level set to '%s'", logLevel)
+	return nil
+}
+
+// StartTrace starts writing all log messages up to the trace level to the
+// file filename, regardless of the current logging level. The other log
+// outputs are not affected. Tracing is stopped with StopTrace.
+// If Init hasn't been called before, an error is returned.
+func StartTrace(filename string) error {
+	if !initialized {
+		return errors.New("log: not initialized")
+	}
+	if filename == "" {
+		return errors.New("log: trace filename is empty")
+	}
+	if err := replaceLogger(currentLevel, filename); err != nil {
+		return err
+	}
+	traceFile = filename
+	Infof("trace to file '%s' started", filename)
+	return nil
+}
+
+// StopTrace stops writing trace messages to the file given to StartTrace and
+// restores the previous logging configuration. It is a no-op, if no trace is
+// active.
+func StopTrace() error {
+	if traceFile == "" {
+		return nil
+	}
+	Infof("trace to file '%s' stopped", traceFile)
+	traceFile = ""
+	return replaceLogger(currentLevel, "")
+}
+
+// replaceLogger replaces the current logger with a new one with the given
+// logging level and traceFile (the other parameters are taken from Init).
+func replaceLogger(logLevel, traceFile string) error {
+	// close old logger first, to make sure everything is written to the
+	// logfiles before the new logger opens them
+	logger.Close()
+	l, err := newLogger(logLevel, initPrefix, initLogDir, initConsole, traceFile)
+	if err != nil {
+		logger = seelog.Disabled
+		return err
+	}
+	UseLogger(l)
+	return nil
+}
+
+// Level returns the current logging level set with Init or SetLevel.
+func Level() string {
+	return currentLevel
+}
+
+// newLogger creates a new seelog logger (see Init for parameter description).
+// If traceFile is not empty, all messages up to the trace level are
+// additionally logged to traceFile.
+func newLogger(
+	logLevel, cmdPrefix, logDir string,
+	logToConsole bool,
+	traceFile string,
+) (seelog.LoggerInterface, error) {
 	console := "<console />"
 	if !logToConsole {
 		console = ""
@@ -50,28 +155,48 @@ func Init(logLevel, cmdPrefix, logDir string, logToConsole bool) error {
 		file = fmt.Sprintf("<rollingfile type=\"size\" filename=%q maxsize=\"10485760\" maxrolls=\"3\" />",
 			filepath.Join(logDir, execBase+".log"))
 	}
+	minLevel := logLevel
+	outputs := console + file
+	if traceFile != "" {
+		// filter the normal outputs and log everything to traceFile
+		minLevel = "trace"
+		levels := levelsFrom(logLevel)
+		if outputs != "" && levels != "" {
+			outputs = fmt.Sprintf("<filter levels=%q>%s</filter>", levels, outputs)
+		} else {
+			outputs = ""
+		}
+		outputs += fmt.Sprintf("<file path=%q />", traceFile)
+	}
 	config := `
 <seelog type="adaptive" mininterval="2000000" maxinterval="100000000"
 	critmsgcount="500" minlevel="%s">
 	<outputs formatid="all">
 		%s
-		%s
 	</outputs>
 	<formats>
 		<format id="all" format="%%UTCDate %%UTCTime [%s] [%%LEV] %%Msg%%n" />
 	</formats>
 </seelog>`
-	config = fmt.Sprintf(config, logLevel, console, file, cmdPrefix)
+	config = fmt.Sprintf(config, minLevel, outputs, cmdPrefix)
 	logger, err := seelog.LoggerFromConfigAsString(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logger.SetAdditionalStackDepth(1)
-	// replace logger
-	UseLogger(logger)
-	// log info about running binary
-	Infof("%s started (built with %s %s for %s/%s)", os.Args[0], runtime.Compiler, runtime.Version(), runtime.GOOS, runtime.GOARCH)
-	return nil
+	return logger, nil
+}
+
+// levelsFrom returns a comma separated list of all logging levels starting at
+// logLevel (which must be valid).
+func levelsFrom(logLevel string) string {
+	levels := []string{"trace", "debug", "info", "warn", "error", "critical"}
+	for i, level := range levels {
+		if level == logLevel {
+			return strings.Join(levels[i:], ",")
+		}
+	}
+	return ""
 }
 
 // Flush flushes all the messages in the logger.
@@ -86,16 +211,30 @@ func Critical(v ...interface{}) error {
 	if len(v) == 1 {
 		err, ok := v[0].(error)
 		if ok {
-			logger.Critical(err)
+			if private {
+				logger.Critical(mask(err.Error()))
+			} else {
+				logger.Critical(err)
+			}
 			return err
 		}
 	}
+	if private {
+		msg := fmt.Sprint(v...)
+		logger.Critical(mask(msg))
+		return errors.New(msg)
+	}
 	return logger.Critical(v...)
 }
 
 // Criticalf formats message according to format specifier and writes to
 // default logger with log level = Critical.
 func Criticalf(format string, params ...interface{}) error {
+	if private {
+		msg := fmt.Sprintf(format, params...)
+		logger.Critical(mask(msg))
+		return errors.New(msg)
+	}
 	return logger.Criticalf(format, params...)
 }
 
@@ -105,16 +244,30 @@ func Error(v ...interface{}) error {
 	if len(v) == 1 {
 		err, ok := v[0].(error)
 		if ok {
-			logger.Error(err)
+			if private {
+				logger.Error(mask(err.Error()))
+			} else {
+				logger.Error(err)
+			}
 			return err
 		}
 	}
+	if private {
+		msg := fmt.Sprint(v...)
+		logger.Error(mask(msg))
+		return errors.New(msg)
+	}
 	return logger.Error(v...)
 }
 
 // Errorf formats message according to format specifier and writes to default
 // logger with log level = Error.
 func Errorf(format string, params ...interface{}) error {
+	if private {
+		msg := fmt.Sprintf(format, params...)
+		logger.Error(mask(msg))
+		return errors.New(msg)
+	}
 	return logger.Errorf(format, params...)
 }
 
@@ -124,52 +277,90 @@ func Warn(v ...interface{}) error {
 	if len(v) == 1 {
 		err, ok := v[0].(error)
 		if ok {
-			logger.Warn(err)
+			if private {
+				logger.Warn(mask(err.Error()))
+			} else {
+				logger.Warn(err)
+			}
 			return err
 		}
 	}
+	if private {
+		msg := fmt.Sprint(v...)
+		logger.Warn(mask(msg))
+		return errors.New(msg)
+	}
 	return logger.Warn(v...)
 }
 
 // Warnf formats message according to format specifier and writes to default
 // logger with log level = Warn.
 func Warnf(format string, params ...interface{}) error {
+	if private {
+		msg := fmt.Sprintf(format, params...)
+		logger.Warn(mask(msg))
+		return errors.New(msg)
+	}
 	return logger.Warnf(format, params...)
 }
 
 // Info formats message using the default formats for its operands and writes
 // to default logger with log level = Info.
 func Info(v ...interface{}) {
+	if private {
+		logger.Info(mask(fmt.Sprint(v...)))
+		return
+	}
 	logger.Info(v...)
 }
 
 // Infof formats message according to format specifier and writes to default
 // logger with log level = Info.
 func Infof(format string, params ...interface{}) {
+	if private {
+		logger.Info(mask(fmt.Sprintf(format, params...)))
+		return
+	}
 	logger.Infof(format, params...)
 }
 
 // Debug formats message using the default formats for its operands and writes
 // to default logger with log level = Debug.
 func Debug(v ...interface{}) {
+	if private {
+		logger.Debug(mask(fmt.Sprint(v...)))
+		return
+	}
 	logger.Debug(v...)
 }
 
 // Debugf formats message according to format specifier and writes to default
 // logger with log level = Debug.
 func Debugf(format string, params ...interface{}) {
+	if private {
+		logger.Debug(mask(fmt.Sprintf(format, params...)))
+		return
+	}
 	logger.Debugf(format, params...)
 }
 
 // Trace formats message using the default formats for its operands and writes
 // to default logger with log level = Trace.
 func Trace(v ...interface{}) {
+	if private {
+		logger.Trace(mask(fmt.Sprint(v...)))
+		return
+	}
 	logger.Trace(v...)
 }
 
 // Tracef formats message according to format specifier and writes to default
 // logger with log level = Trace.
 func Tracef(format string, params ...interface{}) {
+	if private {
+		logger.Trace(mask(fmt.Sprintf(format, params...)))
+		return
+	}
 	logger.Tracef(format, params...)
 }
 
@@ -0,0 +1,36 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+// private is true, if identities are masked in log output.
+var private bool
+
+// identityRegexp matches identities (like alice@mute.berlin) in log messages.
+var identityRegexp = regexp.MustCompile(`[a-zA-Z0-9._+-]+@[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)+`)
+
+// SetPrivate enables (or disables) the masking of identities in log output.
+// A masked identity is replaced by a token with a stable short prefix of
+// the hash of the identity, which allows to correlate log messages without
+// revealing the identity. Returned errors are not masked.
+func SetPrivate(enable bool) {
+	private = enable
+}
+
+// MaskIdentity returns the masked token for the given identity.
+func MaskIdentity(identity string) string {
+	h := sha256.Sum256([]byte(identity))
+	return "id:" + hex.EncodeToString(h[:4])
+}
+
+// mask replaces all identities in msg with masked tokens.
+func mask(msg string) string {
+	return identityRegexp.ReplaceAllStringFunc(msg, MaskIdentity)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mutecomm/mute/mix/mixaddr"
+	"github.com/mutecomm/mute/mix/mixcrypt"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// LoopbackKeyDuration is the time a mix key of a Loopback is valid. It is
+// longer than the lifetime of nym addresses (thirty days).
+var LoopbackKeyDuration = 60 * int64(times.Day)
+
+// ErrBadServer is returned if a server name is too short to be part of a
+// mailbox address.
+var ErrBadServer = errors.New("mixclient: server name too short")
+
+// Loopback is a local replacement for the mix and the account servers, which
+// is backed by the directory Dir. It can be used for development and demos
+// without any network access: Deliver processes messages exactly like a mix
+// and puts them into the mailbox of the receiving account (a subdirectory of
+// Dir), ListMessages and FetchMessage read them from there.
+// Nym addresses must be created with the mix keys returned by MixKeys.
+type Loopback struct {
+	Dir string
+}
+
+// keyList loads the mix keys of the loopback (or creates them, if
+// necessary). New keys are added if the existing ones expire too soon.
+func (lb *Loopback) keyList(mixaddress string) (*mixaddr.KeyList, error) {
+	safedir := filepath.Join(lb.Dir, "mix")
+	if err := os.MkdirAll(safedir, 0700); err != nil {
+		return nil, err
+	}
+	// signature key of the mix
+	sigKeyFile := filepath.Join(safedir, "sigkey")
+	var sigKey [ed25519.PrivateKeySize]byte
+	key, err := ioutil.ReadFile(sigKeyFile)
+	if os.IsNotExist(err) {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(sigKeyFile, key, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	copy(sigKey[:], key)
+	kl := mixaddr.New(&sigKey, mixaddress, LoopbackKeyDuration,
+		LoopbackKeyDuration, safedir)
+	// load last saved key list
+	files, err := filepath.Glob(filepath.Join(safedir, "keys.*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		sort.Strings(files)
+		d, err := ioutil.ReadFile(files[len(files)-1])
+		if err != nil {
+			return nil, err
+		}
+		if err := kl.Unmarshal(d); err != nil {
+			return nil, err
+		}
+	}
+	return kl, nil
+}
+
+// MixKeys returns the key statement of the mix with the given mix address,
+// like GetMixKeys does for a real mix.
+func (lb *Loopback) MixKeys(mixaddress string) (*mixaddr.AddressStatement, error) {
+	kl, err := lb.keyList(mixaddress)
+	if err != nil {
+		return nil, err
+	}
+	// nym addresses must not outlive the mix keys used for them
+	_, last := kl.GetBoundaryTime()
+	if last < times.ThirtyDaysLater()+int64(times.Day) {
+		kl.AddKey()
+	}
+	return kl.GetStatement(), nil
+}
+
+// mailbox returns the mailbox directory for the given mailbox address.
+func (lb *Loopback) mailbox(address string) string {
+	return filepath.Join(lb.Dir, "mailbox", address)
+}
+
+// mailboxAddress returns the address the mix delivers messages to for the
+// account with the given pubkey on server (see util.MailboxAddress).
+func mailboxAddress(pubkey *[ed25519.PublicKeySize]byte, server string) (string, error) {
+	if len(server) < len(mixcrypt.MuteSystemDomain) {
+		return "", ErrBadServer
+	}
+	return hex.EncodeToString(pubkey[:]) + "@" +
+		server[:len(server)-len(mixcrypt.MuteSystemDomain)] +
+		mixcrypt.MuteSystemDomain, nil
+}
+
+// Deliver delivers the message contained in mo (as returned by
+// MessageInput.Create) to the mailbox of the recipient. A failed delivery is
+// never worth a resend.
+func (lb *Loopback) Deliver(mo *MessageOutput) (resend bool, err error) {
+	return false, lb.deliver(mo)
+}
+
+func (lb *Loopback) deliver(mo *MessageOutput) error {
+	if mo == nil {
+		return ErrNIL
+	}
+	if mo.Message == nil {
+		return ErrAlreadySent
+	}
+	msg, err := ReadMail(mo.Message)
+	if err != nil {
+		return err
+	}
+	kl, err := lb.keyList(mo.To)
+	if err != nil {
+		return err
+	}
+	rs, err := mixcrypt.ReceiveMessage(kl.GetPrivateKey, msg)
+	if err != nil {
+		return err
+	}
+	relay, address, err := rs.Send()
+	if err != nil {
+		return err
+	}
+	mailbox := lb.mailbox(address)
+	if err := os.MkdirAll(mailbox, 0700); err != nil {
+		return err
+	}
+	messageID := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, messageID); err != nil {
+		return err
+	}
+	// write message atomically, it might be fetched concurrently
+	filename := filepath.Join(mailbox, hex.EncodeToString(messageID))
+	if err := ioutil.WriteFile(filename+".tmp", relay, 0600); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// ListMessages lists the messages for the account identified by privkey on
+// server which have been received since lastMessageTime, newest first.
+func (lb *Loopback) ListMessages(
+	privkey *[ed25519.PrivateKeySize]byte,
+	lastMessageTime int64,
+	server string,
+) ([]MessageMeta, error) {
+	pubkey := splitKey(privkey)
+	address, err := mailboxAddress(pubkey, server)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(lb.mailbox(address))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var messages []MessageMeta
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".tmp") {
+			continue
+		}
+		messageID, err := hex.DecodeString(info.Name())
+		if err != nil {
+			continue // not a message
+		}
+		receiveTime := info.ModTime().Unix()
+		if receiveTime < lastMessageTime {
+			continue
+		}
+		messages = append(messages, MessageMeta{
+			MessageID:       messageID,
+			ReceiveTime:     receiveTime,
+			ReceiveTimeNano: info.ModTime().UnixNano(),
+			UserKey:         *pubkey,
+		})
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].ReceiveTimeNano > messages[j].ReceiveTimeNano
+	})
+	return messages, nil
+}
+
+// FetchMessage fetches the message with messageID from the account
+// identified by privkey on server.
+func (lb *Loopback) FetchMessage(
+	privkey *[ed25519.PrivateKeySize]byte,
+	messageID []byte,
+	server string,
+) ([]byte, error) {
+	address, err := mailboxAddress(splitKey(privkey), server)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(lb.mailbox(address),
+		hex.EncodeToString(messageID)))
+}
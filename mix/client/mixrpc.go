@@ -5,8 +5,6 @@
 package client
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -17,6 +15,7 @@ import (
 
 	"github.com/mutecomm/mute/mix/mixaddr"
 	"github.com/mutecomm/mute/mix/smtpclient"
+	"github.com/mutecomm/mute/util/dialer"
 )
 
 // GetMixAddress is used to get the address of a mix rpc. It should only be
@@ -33,51 +32,62 @@ func getMixAddressReal(mixaddress string) (string, error) {
 	return address, nil
 }
 
-func getHTTPClient(cacert []byte) *http.Client {
-	tr := &http.Transport{}
-	if cacert != nil {
-		tr.TLSClientConfig = &tls.Config{RootCAs: x509.NewCertPool()}
-		tr.TLSClientConfig.RootCAs.AppendCertsFromPEM(cacert)
+// getHTTPClient returns a client which reuses the connections of previous
+// calls (see dialer.PooledTransport).
+func getHTTPClient(cacert []byte) (*http.Client, error) {
+	tr, err := dialer.PooledTransport(cacert)
+	if err != nil {
+		return nil, err
 	}
 	client := &http.Client{
 		Transport: tr,
 		Timeout:   time.Second * time.Duration(DefaultTimeOut),
 	}
-	return client
+	return client, nil
 }
 
 // HTTPSGet executes a get call over HTTPs.
 func HTTPSGet(getURL string, cacert []byte) ([]byte, error) {
-	client := getHTTPClient(cacert)
+	client, err := getHTTPClient(cacert)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := client.Get(getURL)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
 	return body, nil
 }
 
 // HTTPSPost executes a post call over HTTPs.
 func HTTPSPost(postValues url.Values, postURL string, cacert []byte) ([]byte, error) {
-	client := getHTTPClient(cacert)
+	client, err := getHTTPClient(cacert)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := client.PostForm(postURL, postValues)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
 	return body, nil
 }
 
-// GetMixKeys gets the keys for the mix.
-func GetMixKeys(mixaddress string, cacert []byte) (*mixaddr.AddressStatement, error) {
+// GetMixKeys gets the keys for the mix. It should only be changed for
+// debugging purposes or if the mix is replaced (see Loopback).
+var GetMixKeys = getMixKeysReal
+
+// getMixKeysReal gets the keys for the mix from the mix.
+func getMixKeysReal(mixaddress string, cacert []byte) (*mixaddr.AddressStatement, error) {
 	address, err := GetMixAddress(mixaddress)
 	if err != nil {
 		return nil, err
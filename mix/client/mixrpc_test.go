@@ -4,5 +4,92 @@
 
 package client
 
-// All tests removed since they touch internal code.
-// Tests located in mixserver package.
+import (
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mutecomm/mute/util/dialer"
+)
+
+// newMessageServer returns a TLS server which answers every request with a
+// message and counts the established connections in conns. The returned
+// cacert verifies the server.
+func newMessageServer(conns *int64) (srv *httptest.Server, cacert []byte) {
+	srv = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "MESSAGE")
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(conns, 1)
+		}
+	}
+	srv.StartTLS()
+	cacert = pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	})
+	return
+}
+
+func fetchMessages(n int, postURL string, cacert []byte) error {
+	for i := 0; i < n; i++ {
+		body, err := HTTPSPost(url.Values{"messageid": {"00"}}, postURL, cacert)
+		if err != nil {
+			return err
+		}
+		if string(body) != "MESSAGE" {
+			return fmt.Errorf("unexpected body: %s", body)
+		}
+	}
+	return nil
+}
+
+func TestHTTPSPostReuse(t *testing.T) {
+	var conns int64
+	srv, cacert := newMessageServer(&conns)
+	defer srv.Close()
+	defer dialer.SetMaxIdle(dialer.DefaultMaxIdle)
+	// connections are reused
+	if err := fetchMessages(10, srv.URL+"/message", cacert); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&conns); n != 1 {
+		t.Errorf("connections == %d (should be 1)", n)
+	}
+	// disable reuse
+	dialer.SetMaxIdle(0)
+	atomic.StoreInt64(&conns, 0)
+	if err := fetchMessages(10, srv.URL+"/message", cacert); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&conns); n != 10 {
+		t.Errorf("connections == %d (should be 10)", n)
+	}
+}
+
+func benchmarkFetch(b *testing.B, maxIdle int) {
+	var conns int64
+	srv, cacert := newMessageServer(&conns)
+	defer srv.Close()
+	dialer.SetMaxIdle(maxIdle)
+	defer dialer.SetMaxIdle(dialer.DefaultMaxIdle)
+	b.ResetTimer()
+	if err := fetchMessages(b.N, srv.URL+"/message", cacert); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&conns))/float64(b.N), "conns/op")
+}
+
+func BenchmarkFetchPooled(b *testing.B) {
+	benchmarkFetch(b, dialer.DefaultMaxIdle)
+}
+
+func BenchmarkFetchUnpooled(b *testing.B) {
+	benchmarkFetch(b, 0)
+}
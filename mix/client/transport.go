@@ -0,0 +1,67 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/ed25519"
+)
+
+// Transport is a message transport. It delivers messages created with
+// MessageInput.Create and retrieves messages from the account of the
+// recipient. Mix is the default transport, Loopback a local one.
+type Transport interface {
+	// Deliver delivers the message contained in mo. If the delivery failed
+	// and might succeed later, resend is true.
+	Deliver(mo *MessageOutput) (resend bool, err error)
+	// ListMessages lists the messages for the account identified by privkey
+	// on server which have been received since lastMessageTime.
+	ListMessages(
+		privkey *[ed25519.PrivateKeySize]byte,
+		lastMessageTime int64,
+		server string,
+	) ([]MessageMeta, error)
+	// FetchMessage fetches the message with messageID from the account
+	// identified by privkey on server.
+	FetchMessage(
+		privkey *[ed25519.PrivateKeySize]byte,
+		messageID []byte,
+		server string,
+	) ([]byte, error)
+}
+
+// Mix is the Transport which delivers messages to the mix (via SMTP) and
+// retrieves them from the account servers.
+type Mix struct {
+	CACert []byte // CA certificate for TLS verification of account servers
+}
+
+// Deliver delivers the message contained in mo to the mix.
+func (m *Mix) Deliver(mo *MessageOutput) (resend bool, err error) {
+	messageOut, err := mo.Deliver()
+	if err != nil {
+		return messageOut != nil && messageOut.Resend, err
+	}
+	return false, nil
+}
+
+// ListMessages lists the messages for the account identified by privkey on
+// server (see ListMessages).
+func (m *Mix) ListMessages(
+	privkey *[ed25519.PrivateKeySize]byte,
+	lastMessageTime int64,
+	server string,
+) ([]MessageMeta, error) {
+	return ListMessages(privkey, lastMessageTime, server, m.CACert)
+}
+
+// FetchMessage fetches the message with messageID from the account
+// identified by privkey on server (see FetchMessage).
+func (m *Mix) FetchMessage(
+	privkey *[ed25519.PrivateKeySize]byte,
+	messageID []byte,
+	server string,
+) ([]byte, error) {
+	return FetchMessage(privkey, messageID, server, m.CACert)
+}
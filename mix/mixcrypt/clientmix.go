@@ -39,10 +39,10 @@ type UniquenessData struct {
 
 // Marshal a ClientMixHeader
 func (cl ClientMixHeader) Marshal() []byte {
-	if cl.Token == nil {
+	if len(cl.Token) == 0 {
 		cl.Token = []byte{0x00}
 	}
-	if cl.RevokeID == nil || cl.MessageType == MessageTypeForward {
+	if len(cl.RevokeID) == 0 || cl.MessageType == MessageTypeForward {
 		cl.RevokeID = []byte{0x00}
 	}
 	d, err := asn1.Marshal(cl)
@@ -43,16 +43,35 @@ func (rs ReceiveStruct) sendRelay() ([]byte, string, error) {
 	return append(header, message...), address, nil
 }
 
-// ReceiveFromMix decrypts a message received from the mix
-func ReceiveFromMix(receiveTemplate nymaddr.AddressTemplate, MailboxAddress, msg []byte) (decMessage, Nym []byte, err error) {
+// relayHeader returns the header of a message received from the mix.
+func relayHeader(msg []byte) ([]byte, error) {
 	if len(msg) < 2 {
-		return nil, nil, ErrTooShort
+		return nil, ErrTooShort
 	}
 	headerLen := int(binary.BigEndian.Uint16(msg[0:2]))
 	if len(msg) < 2+headerLen {
-		return nil, nil, ErrTooShort
+		return nil, ErrTooShort
+	}
+	return msg[2 : 2+headerLen], nil
+}
+
+// ReceiverPubKey returns the pubkey of the receiver of a message received
+// from the mix, which identifies the nymaddress the message was sent to.
+func ReceiverPubKey(msg []byte) ([]byte, error) {
+	headerContent, err := relayHeader(msg)
+	if err != nil {
+		return nil, err
+	}
+	return nymaddr.ReceiverPubKey(headerContent)
+}
+
+// ReceiveFromMix decrypts a message received from the mix
+func ReceiveFromMix(receiveTemplate nymaddr.AddressTemplate, MailboxAddress, msg []byte) (decMessage, Nym []byte, err error) {
+	headerContent, err := relayHeader(msg)
+	if err != nil {
+		return nil, nil, err
 	}
-	headerContent := msg[2 : 2+headerLen]
+	headerLen := len(headerContent)
 	nym, secret, err := receiveTemplate.GetPrivate(headerContent, MailboxAddress)
 	if err != nil {
 		return nil, nil, err
@@ -207,24 +207,46 @@ func (tmp AddressTemplate) GetPrivate(header, MailboxAddress []byte) (nym, secre
 	return nym, sharedSecret[:], nil
 }
 
+// ReceiverPubKey returns the pubkey of the receiver contained in the given
+// relay header. It identifies the nymaddress the relay message was sent to
+// (see NewAddressKey).
+func ReceiverPubKey(header []byte) ([]byte, error) {
+	rh := new(RelayHeader)
+	if _, err := asn1.Unmarshal(header, rh); err != nil {
+		return nil, err
+	}
+	return rh.ReceiverPubKey, nil
+}
+
 // NewAddress generates a new nymaddress for nym/address from AddressTemplate.
 // Only the first KeySize bytes of Nym are used, so use a hash of the true nym
 // here.
 func (tmp AddressTemplate) NewAddress(MailboxAddress, Nym []byte) ([]byte, error) {
+	address, _, err := tmp.NewAddressKey(MailboxAddress, Nym)
+	return address, err
+}
+
+// NewAddressKey is like NewAddress, but additionally returns the pubkey of
+// the receiver, which is contained in all relay headers of messages sent to
+// the new nymaddress (see ReceiverPubKey).
+func (tmp AddressTemplate) NewAddressKey(MailboxAddress, Nym []byte) (
+	address, receiverPubKey []byte,
+	err error,
+) {
 	xnym := make([]byte, KeySize)
 	copy(xnym, Nym)
 	Nym = xnym
 	mixAddress := tmp.MixCandidates.Expire(tmp.Expire).Rand() // Select a random mix
 	if mixAddress == nil {
-		return nil, ErrNoMix
+		return nil, nil, ErrNoMix
 	}
 	pubKeyRand, privKeyRand, err := genKeyRandom()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	nonce, err := genNonce()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	nymaddr := new(Address)
@@ -257,7 +279,7 @@ func (tmp AddressTemplate) NewAddress(MailboxAddress, Nym []byte) ([]byte, error
 
 	privmarshal, err := asn1.Marshal(*nymprivate)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	mixPubKey := new([KeySize]byte)
 	sharedSecret := new([KeySize]byte)
@@ -265,14 +287,18 @@ func (tmp AddressTemplate) NewAddress(MailboxAddress, Nym []byte) ([]byte, error
 	curve25519.ScalarMult(sharedSecret, privKeyRand, mixPubKey)
 	cr, err := lioness.New(sharedSecret[:]) // saves some bytes and is safe against tagging
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	privmarshalEnc, err := cr.Encrypt(privmarshal)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	nymaddr.PrivateData = privmarshalEnc
-	return asn1.Marshal(*nymaddr)
+	address, err = asn1.Marshal(*nymaddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return address, nymprivate.ReceiverPubKey, nil
 }
 
 func calcHmac(key []byte, data ...[]byte) []byte {
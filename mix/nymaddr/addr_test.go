@@ -71,6 +71,45 @@ func TestNewAddr(t *testing.T) {
 	}
 }
 
+func TestReceiverPubKey(t *testing.T) {
+	var pkey [KeySize]byte
+	copy(pkey[:], privkey)
+	nymIn := []byte("Nym45678901234567890123456789012")
+	var keys [][]byte
+	for i := 0; i < 2; i++ {
+		nymaddr, recvKey, err := testTemplate.NewAddressKey([]byte("mailbox"),
+			nymIn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := ParseAddress(nymaddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv, err := addr.GetMixData(func(*[KeySize]byte) *[KeySize]byte {
+			return &pkey
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _, err := priv.GetHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ReceiverPubKey(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, recvKey) {
+			t.Error("receiver pubkey of header does not match nymaddress")
+		}
+		keys = append(keys, key)
+	}
+	if bytes.Equal(keys[0], keys[1]) {
+		t.Error("nymaddresses should have different receiver pubkeys")
+	}
+}
+
 func TestNymEncrypt(t *testing.T) {
 	nym := []byte("TestNym")
 	key := sha256.Sum256([]byte("TestKey"))
@@ -16,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/mutecomm/mute/util/dialer"
 	"github.com/mutecomm/mute/util/times"
 )
 
@@ -32,11 +33,16 @@ var (
 	ErrRetry = errors.New("smtpclient: retry")
 )
 
-// LookupMX returns the primary MX for a domain
+// LookupMX returns the primary MX for a domain. If a proxy is set (see
+// package dialer), MX records are not looked up (DNS requests would bypass the
+// proxy) and the domain itself is returned, to be resolved by the proxy.
 func LookupMX(domain string) string {
 	if domain == "" {
 		return ""
 	}
+	if dialer.Proxy() != "" {
+		return domain
+	}
 	mx, err := net.LookupMX(domain)
 	if err != nil {
 		return domain
@@ -119,8 +125,14 @@ func (mc *MailClient) SendMail(to, from string, mail []byte) error {
 		return ErrFinal
 	}
 	address := host + ":" + strconv.Itoa(mc.Port)
-	client, err := smtp.Dial(address)
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		mc.parseError(err)
+		return ErrRetry
+	}
+	client, err := smtp.NewClient(conn, host)
 	if err != nil {
+		conn.Close()
 		mc.parseError(err)
 		return ErrRetry
 	}
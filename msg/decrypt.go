@@ -90,8 +90,16 @@ type DecryptArgs struct {
 	PreHeader  []byte         // preHeader read with ReadFirstOuterHeader()
 	Reader     io.Reader      // data to decrypt is read here (not base64 encoded)
 	NumOfKeys  uint64         // number of generated sessions keys (default: NumOfFutureKeys)
+	KeyWindow  uint64         // number of retained old message keys (default: MessageKeyWindow)
 	Rand       io.Reader      // random source
 	KeyStore   session.Store  // for managing session keys
+
+	// DeferSignatureCheck leaves the signature verification to the caller:
+	// the signature of a signed message is returned without being verified
+	// and SignatureCheck is set. The message content has to be kept, if the
+	// signature is verified later on.
+	DeferSignatureCheck bool
+	SignatureCheck      *SignatureCheck // set by Decrypt for deferred checks
 }
 
 // Decrypt decrypts a message with the argument given in args.
@@ -99,6 +107,8 @@ type DecryptArgs struct {
 // If the message was signed and the signature could be verified successfully
 // the base64 encoded signature is returned. If the message was signed and the
 // signature could not be verfied an error is returned.
+// If args.DeferSignatureCheck is set, the signature is returned unverified
+// (see DecryptArgs).
 func Decrypt(args *DecryptArgs) (senderID, sig string, err error) {
 	log.Debug("msg.Decrypt()")
 
@@ -106,6 +116,9 @@ func Decrypt(args *DecryptArgs) (senderID, sig string, err error) {
 	if args.NumOfKeys == 0 {
 		args.NumOfKeys = NumOfFutureKeys
 	}
+	if args.KeyWindow == 0 {
+		args.KeyWindow = MessageKeyWindow
+	}
 
 	// read pre-header
 	ph, err := readPreHeader(bytes.NewBuffer(args.PreHeader))
@@ -542,9 +555,17 @@ func Decrypt(args *DecryptArgs) (senderID, sig string, err error) {
 	}
 
 	// verify signature, if necessary
+	args.SignatureCheck = nil
 	if contentHash != nil {
-		if !ed25519.Verify(uidRes.msg.PublicSigKey32()[:], contentHash, sigBuf[:]) {
-			return "", "", log.Error(ErrInvalidSignature)
+		check := &SignatureCheck{
+			PublicKey:   uidRes.msg.PublicSigKey32(),
+			ContentHash: contentHash,
+			Signature:   sigBuf[:],
+		}
+		if args.DeferSignatureCheck {
+			args.SignatureCheck = check
+		} else if err := check.Verify(); err != nil {
+			return "", "", err
 		}
 		// encode signature to base64 as return value
 		sig = base64.Encode(sigBuf[:])
@@ -578,5 +599,12 @@ func Decrypt(args *DecryptArgs) (senderID, sig string, err error) {
 		return "", "", err
 	}
 
+	// delete old message keys
+	err = pruneMessageKeys(args.KeyStore, sessionStateKey, sessionKey,
+		h.SenderSessionPub.HASH, h.SenderMessageCount, args.KeyWindow)
+	if err != nil {
+		return "", "", err
+	}
+
 	return
 }
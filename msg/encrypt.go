@@ -154,45 +154,12 @@ func Encrypt(args *EncryptArgs) (nymAddress string, err error) {
 	if ss == nil {
 		// no session found -> start first session
 		log.Debug("no session found -> start first session")
-		var recipientTemp *uid.KeyEntry
-		recipientTemp, nymAddress, err = args.KeyStore.GetPublicKeyEntry(args.To)
-		if err != nil {
-			return "", err
-		}
-		// create session key
-		var senderSession uid.KeyEntry
-		if err := senderSession.InitDHKey(args.Rand); err != nil {
-			return "", err
-		}
-		// store session key
-		if err := addSessionKey(args.KeyStore, &senderSession); err != nil {
-			return "", err
-		}
-		// root key agreement
-		err = rootKeyAgreementSender(senderHeaderKey.PublicKey(),
-			args.From.Identity(), args.To.Identity(), &senderSession,
-			args.From.PubKey(), recipientTemp, args.To.PubKey(), nil,
-			args.NumOfKeys, args.KeyStore)
-		if err != nil {
-			return "", err
-		}
-		// set session state
-		ss = &session.State{
-			SenderSessionCount:          0,
-			SenderMessageCount:          0,
-			MaxRecipientCount:           0,
-			RecipientTemp:               *recipientTemp,
-			SenderSessionPub:            senderSession,
-			NextSenderSessionPub:        nil,
-			NextRecipientSessionPubSeen: nil,
-			NymAddress:                  nymAddress,
-			KeyInitSession:              true,
-		}
-		log.Debugf("set session: %s", ss.SenderSessionPub.HASH)
-		err = args.KeyStore.SetSessionState(sessionStateKey, ss)
+		ss, err = startSession(args.From, args.To, senderHeaderKey.PublicKey(),
+			sessionStateKey, args.NumOfKeys, args.KeyStore, args.Rand)
 		if err != nil {
 			return "", err
 		}
+		nymAddress = ss.NymAddress
 	} else if args.StatusCode != StatusError { // do not update sessions for StatusError messages
 		log.Debug("session found")
 		log.Debugf("got session: %s", ss.SenderSessionPub.HASH)
@@ -58,3 +58,6 @@ var ErrReflection = errors.New("msg: reflection attack detected")
 
 // ErrStatusError is raised when a decryption operation lead to a StatusCode StatusError.
 var ErrStatusError = errors.New("msg: StatusCode == StatusError")
+
+// ErrNoSession is raised when a session to refresh does not exist.
+var ErrNoSession = errors.New("msg: no session found")
@@ -0,0 +1,294 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package group implements group messages in Mute via sender-key fanout.
+//
+// The body of a group message is encrypted only once with a random symmetric
+// group key (XSalsa20 and Poly1305). The group key is then sealed to every
+// member individually with a normal Mute message (see package msg), which
+// uses the established session with the member. Every member receives the
+// same body ciphertext and its own sealed key.
+package group
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/uid"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// ErrNotMember is raised when a group message could not be decrypted, because
+// the group key has not been sealed to any of the given identities.
+var ErrNotMember = errors.New("group: not a member (cannot unseal group key)")
+
+// ErrWrongCiphertext is raised when the body ciphertext of a group message
+// does not belong to the sealed group key.
+var ErrWrongCiphertext = errors.New("group: ciphertext doesn't match group key")
+
+// keyMessage is the content of the Mute message which seals the group key to
+// a single member.
+type keyMessage struct {
+	GROUP string // name of the group
+	KEY   string // base64 encoded group key
+	HASH  string // base64 encoded SHA512 hash of the body ciphertext
+}
+
+// Message is a group message for a single member.
+type Message struct {
+	SEALEDKEY  string // Mute message (base64) containing the group key
+	CIPHERTEXT string // base64 encoded body ciphertext, same for all members
+}
+
+// Envelope is a group message ready for delivery to a single member.
+type Envelope struct {
+	To         string   // identity of the member
+	NymAddress string   // nym address the message should be delivered to
+	Message    *Message // group message for the member
+}
+
+// NewJSON returns a new group message from the given JSON encoding.
+func NewJSON(jsn string) (*Message, error) {
+	var m Message
+	if err := json.Unmarshal([]byte(jsn), &m); err != nil {
+		return nil, log.Error(err)
+	}
+	return &m, nil
+}
+
+// JSON encodes the group message as JSON.
+func (m *Message) JSON() []byte {
+	jsn, err := json.Marshal(m)
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	return jsn
+}
+
+// Parse returns the group message contained in the base64 encoded message enc
+// (the JSON encoding of the group message is base64 encoded for transport,
+// like normal Mute messages). It returns nil, if enc is not a group message.
+func Parse(enc string) *Message {
+	jsn, err := base64.Decode(enc)
+	if err != nil || !bytes.HasPrefix(jsn, []byte("{")) {
+		return nil
+	}
+	var m Message
+	if err := json.Unmarshal(jsn, &m); err != nil || m.SEALEDKEY == "" {
+		return nil
+	}
+	return &m
+}
+
+// Body is the body of a group message encrypted once with a random group
+// key. The key message contains the group key and has to be sealed to every
+// member individually (see Seal and Open).
+type Body struct {
+	KEYMESSAGE string // base64 encoded key message (unsealed)
+	CIPHERTEXT string // base64 encoded body ciphertext
+}
+
+// NewBodyJSON returns a new group message body from the given JSON encoding.
+func NewBodyJSON(jsn string) (*Body, error) {
+	var b Body
+	if err := json.Unmarshal([]byte(jsn), &b); err != nil {
+		return nil, log.Error(err)
+	}
+	return &b, nil
+}
+
+// JSON encodes the group message body as JSON.
+func (b *Body) JSON() []byte {
+	jsn, err := json.Marshal(b)
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	return jsn
+}
+
+// KeyMessage returns the decoded key message of b, which has to be sealed to
+// every member.
+func (b *Body) KeyMessage() ([]byte, error) {
+	return base64.Decode(b.KEYMESSAGE)
+}
+
+// Message returns the group message for a single member with the given
+// sealedKey (the key message of b encrypted for the member).
+func (b *Body) Message(sealedKey string) *Message {
+	return &Message{
+		SEALEDKEY:  sealedKey,
+		CIPHERTEXT: b.CIPHERTEXT,
+	}
+}
+
+// Seal encrypts body for the given group with a fresh random group key
+// (XSalsa20 and Poly1305).
+func Seal(group string, body []byte, rand io.Reader) (*Body, error) {
+	var key [32]byte
+	if _, err := io.ReadFull(rand, key[:]); err != nil {
+		return nil, log.Error(err)
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand, nonce[:]); err != nil {
+		return nil, log.Error(err)
+	}
+	ciphertext := secretbox.Seal(nonce[:], body, &nonce, &key)
+	km := &keyMessage{
+		GROUP: group,
+		KEY:   base64.Encode(key[:]),
+		HASH:  base64.Encode(cipher.SHA512(ciphertext)),
+	}
+	kmJSON, err := json.Marshal(km)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	return &Body{
+		KEYMESSAGE: base64.Encode(kmJSON),
+		CIPHERTEXT: base64.Encode(ciphertext),
+	}, nil
+}
+
+// Open decrypts the body ciphertext of the group message m with the group key
+// contained in the unsealed key message keyMsg. The name of the group and the
+// body are returned.
+func Open(keyMsg []byte, m *Message) (group string, body []byte, err error) {
+	var km keyMessage
+	if err := json.Unmarshal(keyMsg, &km); err != nil {
+		return "", nil, log.Error(err)
+	}
+	ciphertext, err := base64.Decode(m.CIPHERTEXT)
+	if err != nil {
+		return "", nil, err
+	}
+	if km.HASH != base64.Encode(cipher.SHA512(ciphertext)) {
+		return "", nil, log.Error(ErrWrongCiphertext)
+	}
+	k, err := base64.Decode(km.KEY)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(k) != 32 {
+		return "", nil, log.Errorf("group: key has wrong length %d", len(k))
+	}
+	var key [32]byte
+	copy(key[:], k)
+	if len(ciphertext) < 24+secretbox.Overhead {
+		return "", nil, log.Error(ErrWrongCiphertext)
+	}
+	var nonce [24]byte
+	copy(nonce[:], ciphertext[:24])
+	body, ok := secretbox.Open(nil, ciphertext[24:], &nonce, &key)
+	if !ok {
+		return "", nil, log.Error(ErrWrongCiphertext)
+	}
+	return km.GROUP, body, nil
+}
+
+// EncryptArgs contains all arguments for a group message encryption.
+type EncryptArgs struct {
+	Group                  string         // name of the group
+	From                   *uid.Message   // sender UID
+	Members                []*uid.Message // UIDs of all members (except the sender)
+	NymAddress             string         // address to receive future messages at
+	SenderLastKeychainHash string         // last hash chain entry known to the sender
+	Reader                 io.Reader      // body to encrypt is read here
+	Rand                   io.Reader      // random source
+	KeyStore               session.Store  // for managing session keys
+}
+
+// Encrypt encrypts the body read from args.Reader once and seals the group
+// key to every member in args.Members. It returns an envelope per member.
+func Encrypt(args *EncryptArgs) ([]*Envelope, error) {
+	if len(args.Members) == 0 {
+		return nil, log.Error("group: no members")
+	}
+	body, err := ioutil.ReadAll(args.Reader)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	b, err := Seal(args.Group, body, args.Rand)
+	if err != nil {
+		return nil, err
+	}
+	kmJSON, err := b.KeyMessage()
+	if err != nil {
+		return nil, err
+	}
+	envelopes := make([]*Envelope, 0, len(args.Members))
+	for _, member := range args.Members {
+		var sealedKey bytes.Buffer
+		nymAddress, err := msg.Encrypt(&msg.EncryptArgs{
+			Writer:                 &sealedKey,
+			From:                   args.From,
+			To:                     member,
+			NymAddress:             args.NymAddress,
+			SenderLastKeychainHash: args.SenderLastKeychainHash,
+			Reader:                 bytes.NewBuffer(kmJSON),
+			Rand:                   args.Rand,
+			KeyStore:               args.KeyStore,
+		})
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, &Envelope{
+			To:         member.Identity(),
+			NymAddress: nymAddress,
+			Message:    b.Message(sealedKey.String()),
+		})
+	}
+	return envelopes, nil
+}
+
+// DecryptArgs contains all arguments for a group message decryption.
+type DecryptArgs struct {
+	Writer     io.Writer      // decrypted body is written here
+	Identities []*uid.Message // list of recipient UID messages
+	Message    *Message       // group message to decrypt
+	Rand       io.Reader      // random source
+	KeyStore   session.Store  // for managing session keys
+}
+
+// Decrypt unseals the group key of args.Message and decrypts the body with
+// it. The senderID and the name of the group are returned.
+func Decrypt(args *DecryptArgs) (senderID, group string, err error) {
+	input := base64.NewDecoder(bytes.NewBufferString(args.Message.SEALEDKEY))
+	version, preHeader, err := msg.ReadFirstOuterHeader(input)
+	if err != nil {
+		return "", "", err
+	}
+	if version != msg.Version {
+		return "", "", log.Errorf("group: wrong message version %d", version)
+	}
+	var kmJSON bytes.Buffer
+	senderID, _, err = msg.Decrypt(&msg.DecryptArgs{
+		Writer:     &kmJSON,
+		Identities: args.Identities,
+		PreHeader:  preHeader,
+		Reader:     input,
+		Rand:       args.Rand,
+		KeyStore:   args.KeyStore,
+	})
+	if err != nil {
+		if err == msg.ErrNoPreHeaderKey {
+			return "", "", log.Error(ErrNotMember)
+		}
+		return "", "", err
+	}
+	group, body, err := Open(kmJSON.Bytes(), args.Message)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := args.Writer.Write(body); err != nil {
+		return "", "", log.Error(err)
+	}
+	return senderID, group, nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package group
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg/session/memstore"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/msgs"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func init() {
+	if err := log.Init("info", "group", "", true); err != nil {
+		panic(err)
+	}
+}
+
+type member struct {
+	uid *uid.Message
+	ms  *memstore.MemStore
+}
+
+// newMember creates a UID and a KeyInit for identity and stores the private
+// KeyInit key in the member's own key store. The public KeyInit key is added
+// to the key store of the sender.
+func newMember(t *testing.T, identity string, sender *memstore.MemStore) *member {
+	msg, err := uid.Create(identity, false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	ki, _, privateKey, err := msg.KeyInit(1, now+times.Day, now-times.Day,
+		false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ki.KeyEntryECDHE25519(msg.SigPubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.AddPublicKeyEntry(msg.Identity(), pub)
+	priv, err := ki.KeyEntryECDHE25519(msg.SigPubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := priv.SetPrivateKey(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	ms := memstore.New()
+	ms.AddPrivateKeyEntry(priv)
+	return &member{uid: msg, ms: ms}
+}
+
+func TestGroupMessage(t *testing.T) {
+	alice, err := uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderStore := memstore.New()
+	bob := newMember(t, "bob@mute.berlin", senderStore)
+	carol := newMember(t, "carol@mute.berlin", senderStore)
+	dave := newMember(t, "dave@mute.berlin", memstore.New())
+	envelopes, err := Encrypt(&EncryptArgs{
+		Group:                  "friends",
+		From:                   alice,
+		Members:                []*uid.Message{bob.uid, carol.uid},
+		SenderLastKeychainHash: hashchain.TestEntry,
+		Reader:                 bytes.NewBufferString(msgs.Message1),
+		Rand:                   cipher.RandReader,
+		KeyStore:               senderStore,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelopes) != 2 {
+		t.Fatalf("len(envelopes) == %d != 2", len(envelopes))
+	}
+	if envelopes[0].Message.CIPHERTEXT != envelopes[1].Message.CIPHERTEXT {
+		t.Error("body should be encrypted only once")
+	}
+	// all members decrypt the same body
+	for i, m := range []*member{bob, carol} {
+		if envelopes[i].To != m.uid.Identity() {
+			t.Fatalf("envelope for %s expected", m.uid.Identity())
+		}
+		gm, err := NewJSON(string(envelopes[i].Message.JSON()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body bytes.Buffer
+		senderID, group, err := Decrypt(&DecryptArgs{
+			Writer:     &body,
+			Identities: []*uid.Message{m.uid},
+			Message:    gm,
+			Rand:       cipher.RandReader,
+			KeyStore:   m.ms,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if senderID != alice.Identity() {
+			t.Errorf("senderID == %s != %s", senderID, alice.Identity())
+		}
+		if group != "friends" {
+			t.Errorf("group == %s != friends", group)
+		}
+		if body.String() != msgs.Message1 {
+			t.Error("bodies differ")
+		}
+	}
+	// a non-member cannot decrypt
+	var body bytes.Buffer
+	_, _, err = Decrypt(&DecryptArgs{
+		Writer:     &body,
+		Identities: []*uid.Message{dave.uid},
+		Message:    envelopes[0].Message,
+		Rand:       cipher.RandReader,
+		KeyStore:   dave.ms,
+	})
+	if err != ErrNotMember {
+		t.Errorf("non-member should get ErrNotMember, got %v", err)
+	}
+	if body.Len() != 0 {
+		t.Error("non-member should not get any body")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	b1, err := Seal("friends", []byte(msgs.Message1), cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := Seal("friends", []byte(msgs.Message2), cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	km1, err := b1.KeyMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := b1.Message("sealedkey")
+	if p := Parse(base64.Encode(m.JSON())); p == nil || *p != *m {
+		t.Error("group message not parsed")
+	}
+	if Parse(base64.Encode([]byte(msgs.Message1))) != nil {
+		t.Error("normal message parsed as group message")
+	}
+	group, body, err := Open(km1, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group != "friends" || string(body) != msgs.Message1 {
+		t.Error("group message not opened correctly")
+	}
+	// the key message of one body doesn't open another one
+	if _, _, err := Open(km1, b2.Message("sealedkey")); err != ErrWrongCiphertext {
+		t.Errorf("wrong ciphertext should fail with ErrWrongCiphertext, got %v",
+			err)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
@@ -123,6 +124,97 @@ func multipartMIME(
 	return nil
 }
 
+// Options are the optional header lines of a Mute message which precede the
+// subject line. They are part of the (encrypted) message content and
+// therefore not visible to servers.
+type Options struct {
+	TTL         time.Duration // recipient deletes the message after the time to live
+	Burn        bool          // recipient deletes the message after reading it once
+	ContentType string        // content type of the message body (see DefaultContentType)
+}
+
+// DefaultContentType is the content type of messages without a content type
+// option.
+const DefaultContentType = "text/plain"
+
+const (
+	ttlPrefix         = "Mute-TTL: "          // followed by time to live in seconds
+	burnLine          = "Mute-Burn: yes"      // burn after reading
+	contentTypePrefix = "Mute-Content-Type: " // followed by content type
+)
+
+// validContentType returns true, if contentType is a media type of the form
+// type/subtype with optional parameters.
+func validContentType(contentType string) bool {
+	if strings.ContainsAny(contentType, "\r\n") {
+		return false
+	}
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(mediatype, "/")
+	return len(parts) == 2 && parts[0] != "" && parts[1] != ""
+}
+
+// CheckContentType checks that contentType is a valid media type (e.g.,
+// "text/markdown") which can be used as a content type option.
+func CheckContentType(contentType string) error {
+	if !validContentType(contentType) {
+		return log.Errorf("mime: invalid content type %q", contentType)
+	}
+	return nil
+}
+
+// AddOptions returns the Mute message msg with the header lines for the given
+// options prepended. A ContentType equal to DefaultContentType is omitted.
+func AddOptions(msg string, opts *Options) string {
+	if opts.ContentType != "" && opts.ContentType != DefaultContentType {
+		msg = contentTypePrefix + opts.ContentType + "\n" + msg
+	}
+	if opts.Burn {
+		msg = burnLine + "\n" + msg
+	}
+	if seconds := int64(opts.TTL / time.Second); seconds > 0 {
+		msg = ttlPrefix + strconv.FormatInt(seconds, 10) + "\n" + msg
+	}
+	return msg
+}
+
+// SplitOptions splits a given Mute message msg into the options and the
+// actual message (see AddOptions). Invalid header lines are not removed.
+// Without a content type line opts.ContentType is DefaultContentType.
+func SplitOptions(msg string) (opts *Options, message string) {
+	opts = &Options{ContentType: DefaultContentType}
+	for {
+		parts := strings.SplitN(msg, "\n", 2)
+		line := strings.TrimRight(parts[0], "\r")
+		switch {
+		case line == burnLine:
+			opts.Burn = true
+		case strings.HasPrefix(line, ttlPrefix):
+			seconds, err := strconv.ParseInt(strings.TrimPrefix(line, ttlPrefix),
+				10, 64)
+			if err != nil || seconds <= 0 {
+				return opts, msg // not a TTL line
+			}
+			opts.TTL = time.Duration(seconds) * time.Second
+		case strings.HasPrefix(line, contentTypePrefix):
+			contentType := strings.TrimPrefix(line, contentTypePrefix)
+			if !validContentType(contentType) {
+				return opts, msg // not a content type line
+			}
+			opts.ContentType = contentType
+		default:
+			return opts, msg
+		}
+		if len(parts) == 1 {
+			return opts, ""
+		}
+		msg = parts[1]
+	}
+}
+
 // SplitMessage splits a given Mute message msg into subject line (before the
 // first newline) and actual message (after the first newline).
 func SplitMessage(msg string) (subject, message string) {
@@ -12,6 +12,7 @@ import (
 	"net/mail"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/mutecomm/mute/cipher"
 	"github.com/mutecomm/mute/msg/msgid"
@@ -295,3 +296,66 @@ func TestChunks(t *testing.T) {
 		t.Error("att2 should not be inline")
 	}
 }
+
+func TestOptions(t *testing.T) {
+	msg := AddOptions("subject\nbody", &Options{TTL: 24 * time.Hour})
+	if msg != "Mute-TTL: 86400\nsubject\nbody" {
+		t.Errorf("AddOptions() == %q", msg)
+	}
+	opts, message := SplitOptions(msg)
+	if opts.TTL != 24*time.Hour || opts.Burn {
+		t.Errorf("opts == %+v", opts)
+	}
+	if message != "subject\nbody" {
+		t.Errorf("message == %q", message)
+	}
+	// burn after reading
+	msg = AddOptions("subject\nbody", &Options{TTL: time.Hour, Burn: true})
+	if msg != "Mute-TTL: 3600\nMute-Burn: yes\nsubject\nbody" {
+		t.Errorf("AddOptions() == %q", msg)
+	}
+	opts, message = SplitOptions(msg)
+	if opts.TTL != time.Hour || !opts.Burn {
+		t.Errorf("opts == %+v", opts)
+	}
+	if message != "subject\nbody" {
+		t.Errorf("message == %q", message)
+	}
+	// no options
+	if AddOptions("subject", &Options{}) != "subject" {
+		t.Error("AddOptions() should not add empty options")
+	}
+	opts, message = SplitOptions("Mute-TTL: invalid\nbody")
+	if opts.TTL != 0 || message != "Mute-TTL: invalid\nbody" {
+		t.Error("SplitOptions() should ignore invalid TTL line")
+	}
+	// content type
+	msg = AddOptions("subject\nbody", &Options{Burn: true,
+		ContentType: "text/markdown"})
+	if msg != "Mute-Burn: yes\nMute-Content-Type: text/markdown\nsubject\nbody" {
+		t.Errorf("AddOptions() == %q", msg)
+	}
+	opts, message = SplitOptions(msg)
+	if !opts.Burn || opts.ContentType != "text/markdown" {
+		t.Errorf("opts == %+v", opts)
+	}
+	if message != "subject\nbody" {
+		t.Errorf("message == %q", message)
+	}
+	if AddOptions("subject", &Options{ContentType: DefaultContentType}) != "subject" {
+		t.Error("AddOptions() should not add default content type")
+	}
+	opts, _ = SplitOptions("subject")
+	if opts.ContentType != DefaultContentType {
+		t.Errorf("opts.ContentType == %q", opts.ContentType)
+	}
+	if err := CheckContentType("text/markdown; charset=UTF-8"); err != nil {
+		t.Error(err)
+	}
+	if err := CheckContentType("text/plain\nsubject"); err == nil {
+		t.Error("CheckContentType() should reject newlines")
+	}
+	if err := CheckContentType("text"); err == nil {
+		t.Error("CheckContentType() should reject missing subtype")
+	}
+}
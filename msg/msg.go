@@ -16,6 +16,12 @@ const DefaultCiphersuite = "CURVE25519 XSALSA20 POLY1305"
 // are precomputed.
 const NumOfFutureKeys = 50
 
+// MessageKeyWindow defines the default number of recipient message keys
+// which are retained below the highest message count received from the
+// sender (MaxRecipientCount). Older recipient message keys are deleted,
+// because the sender has clearly moved past them.
+const MessageKeyWindow = 2 * NumOfFutureKeys
+
 // AverageSessionSize defines the average session size. That is, the number of
 // keys used in a session before a new session is started.
 // For every encrypted message there is the probability of
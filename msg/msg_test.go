@@ -128,7 +128,7 @@ func decrypt(sender, recipient *uid.Message, r io.Reader, recipientTemp *uid.Key
 		}
 		var sigBuf [ed25519.SignatureSize]byte
 		copy(sigBuf[:], decSig)
-		if !ed25519.Verify(sender.PublicSigKey32(), contentHash, &sigBuf) {
+		if !ed25519.Verify(sender.PublicSigKey32()[:], contentHash, sigBuf[:]) {
 			return errors.New("signature verification failed")
 		}
 	}
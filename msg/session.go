@@ -7,6 +7,8 @@ package msg
 import (
 	"io"
 
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/msg/session"
 	"github.com/mutecomm/mute/uid"
 	"github.com/mutecomm/mute/util/times"
@@ -57,3 +59,145 @@ func setNextSenderSessionPub(
 	}
 	return &nextSenderSession, nil
 }
+
+// pruneMessageKeys updates MaxRecipientCount of the session state with
+// sessionStateKey, if msgCount is larger and the message was sent in the
+// current session (senderSessionHash). All recipient message keys of the
+// session with sessionKey which are more than window below the new
+// MaxRecipientCount are deleted.
+func pruneMessageKeys(
+	keyStore session.Store,
+	sessionStateKey, sessionKey, senderSessionHash string,
+	msgCount, window uint64,
+) error {
+	ss, err := keyStore.GetSessionState(sessionStateKey)
+	if err != nil {
+		return err
+	}
+	if ss == nil || ss.RecipientTemp.HASH != senderSessionHash ||
+		msgCount <= ss.MaxRecipientCount {
+		return nil
+	}
+	lower := func(count uint64) uint64 {
+		if count > window {
+			return count - window
+		}
+		return 0
+	}
+	for i := lower(ss.MaxRecipientCount); i < lower(msgCount); i++ {
+		if err := keyStore.DelMessageKey(sessionKey, false, i); err != nil {
+			return err
+		}
+	}
+	ss.MaxRecipientCount = msgCount
+	return keyStore.SetSessionState(sessionStateKey, ss)
+}
+
+// RatchetSession forces a session ratchet step for the session with the given
+// sessionStateKey: A new NextSenderSessionPub is generated, which is sent with
+// the next message and replaces the current SenderSessionPub as soon as the
+// other party has seen it. If the session is already being refreshed, the
+// pending NextSenderSessionPub is returned. If no session exists, ErrNoSession
+// is returned.
+func RatchetSession(
+	keyStore session.Store,
+	sessionStateKey string,
+	rand io.Reader,
+) (*uid.KeyEntry, error) {
+	ss, err := keyStore.GetSessionState(sessionStateKey)
+	if err != nil {
+		return nil, err
+	}
+	if ss == nil {
+		return nil, ErrNoSession
+	}
+	if ss.NextSenderSessionPub != nil {
+		return ss.NextSenderSessionPub, nil
+	}
+	return setNextSenderSessionPub(keyStore, ss, sessionStateKey, rand)
+}
+
+// startSession starts the first session from -> to with the KeyInit of the
+// recipient in keyStore and stores the resulting session state under
+// sessionStateKey.
+func startSession(
+	from, to *uid.Message,
+	senderHeaderPub *[32]byte,
+	sessionStateKey string,
+	numOfKeys uint64,
+	keyStore session.Store,
+	rand io.Reader,
+) (*session.State, error) {
+	recipientTemp, nymAddress, err := keyStore.GetPublicKeyEntry(to)
+	if err != nil {
+		return nil, err
+	}
+	// create session key
+	var senderSession uid.KeyEntry
+	if err := senderSession.InitDHKey(rand); err != nil {
+		return nil, err
+	}
+	// store session key
+	if err := addSessionKey(keyStore, &senderSession); err != nil {
+		return nil, err
+	}
+	// root key agreement
+	err = rootKeyAgreementSender(senderHeaderPub, from.Identity(),
+		to.Identity(), &senderSession, from.PubKey(), recipientTemp,
+		to.PubKey(), nil, numOfKeys, keyStore)
+	if err != nil {
+		return nil, err
+	}
+	// set session state
+	ss := &session.State{
+		SenderSessionCount:          0,
+		SenderMessageCount:          0,
+		MaxRecipientCount:           0,
+		RecipientTemp:               *recipientTemp,
+		SenderSessionPub:            senderSession,
+		NextSenderSessionPub:        nil,
+		NextRecipientSessionPubSeen: nil,
+		NymAddress:                  nymAddress,
+		KeyInitSession:              true,
+	}
+	log.Debugf("set session: %s", ss.SenderSessionPub.HASH)
+	if err := keyStore.SetSessionState(sessionStateKey, ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// PrewarmSession establishes the initial session state from -> to with the
+// KeyInit of the recipient in keyStore, so that the first message to the
+// recipient can be encrypted without fetching a KeyInit. If a session already
+// exists, nothing is done and false is returned.
+func PrewarmSession(
+	from, to *uid.Message,
+	numOfKeys uint64,
+	keyStore session.Store,
+	rand io.Reader,
+) (bool, error) {
+	if numOfKeys == 0 {
+		numOfKeys = NumOfFutureKeys
+	}
+	sessionStateKey := session.CalcStateKey(from.PubKey().PublicKey32(),
+		to.PubKey().PublicKey32())
+	ss, err := keyStore.GetSessionState(sessionStateKey)
+	if err != nil {
+		return false, err
+	}
+	if ss != nil {
+		return false, nil
+	}
+	// the sender header key is only used for the first message header
+	senderHeaderKey, err := cipher.Curve25519Generate(rand)
+	if err != nil {
+		return false, log.Error(err)
+	}
+	_, err = startSession(from, to, senderHeaderKey.PublicKey(),
+		sessionStateKey, numOfKeys, keyStore, rand)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -86,6 +86,7 @@ type Store interface {
 	GetPublicKeyEntry(uidMsg *uid.Message) (*uid.KeyEntry, string, error)
 	// GetMessageKey returns the message key with index msgIndex. If sender is
 	// true the sender key is returned, otherwise the recipient key.
+	// If the key has been deleted already, ErrMessageKeyUsed is returned.
 	GetMessageKey(sessionKey string, sender bool,
 		msgIndex uint64) (*[64]byte, error)
 	// NumMessageKeys returns the number of precomputed messages keys.
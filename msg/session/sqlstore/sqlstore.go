@@ -0,0 +1,228 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sqlstore implements a key store on top of the encrypted keyDB.
+package sqlstore
+
+import (
+	"database/sql"
+
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keydb"
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/uid"
+)
+
+// SQLStore implements the KeyStore interface on top of keyDB.
+type SQLStore struct {
+	keyDB *keydb.KeyDB
+}
+
+// New returns a new SQLStore which uses the given keyDB.
+func New(keyDB *keydb.KeyDB) *SQLStore {
+	return &SQLStore{keyDB: keyDB}
+}
+
+// GetSessionState implemented on top of keyDB.
+func (ss *SQLStore) GetSessionState(sessionStateKey string) (
+	*session.State,
+	error,
+) {
+	state, err := ss.keyDB.GetSessionState(sessionStateKey)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SetSessionState implemented on top of keyDB.
+func (ss *SQLStore) SetSessionState(
+	sessionStateKey string,
+	sessionState *session.State,
+) error {
+	return ss.keyDB.SetSessionState(sessionStateKey, sessionState)
+}
+
+// StoreSession implemented on top of keyDB.
+func (ss *SQLStore) StoreSession(
+	sessionKey, rootKeyHash, chainKey string,
+	send, recv []string,
+) error {
+	return ss.keyDB.AddSession(sessionKey, rootKeyHash, chainKey, send, recv)
+}
+
+// HasSession implemented on top of keyDB.
+func (ss *SQLStore) HasSession(sessionKey string) bool {
+	_, _, _, err := ss.keyDB.GetSession(sessionKey)
+	switch {
+	case err == sql.ErrNoRows:
+		return false
+	case err != nil:
+		// TODO: handle this without panic!
+		panic(log.Critical(err))
+	}
+	return true
+}
+
+// GetPrivateKeyEntry implemented on top of keyDB.
+func (ss *SQLStore) GetPrivateKeyEntry(pubKeyHash string) (*uid.KeyEntry, error) {
+	log.Debugf("sqlstore.GetPrivateKeyEntry: pubKeyHash=%s", pubKeyHash)
+	ki, sigPubKey, privateKey, err := ss.keyDB.GetPrivateKeyInit(pubKeyHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, session.ErrNoKeyEntry
+		}
+		return nil, err
+	}
+	// decrypt KeyEntry
+	ke, err := ki.KeyEntryECDHE25519(sigPubKey)
+	if err != nil {
+		return nil, err
+	}
+	// set private key
+	if err := ke.SetPrivateKey(privateKey); err != nil {
+		return nil, err
+	}
+	return ke, nil
+}
+
+// GetPublicKeyEntry implemented on top of keyDB.
+func (ss *SQLStore) GetPublicKeyEntry(uidMsg *uid.Message) (*uid.KeyEntry, string, error) {
+	log.Debugf("sqlstore.GetPublicKeyEntry: uidMsg.Identity()=%s", uidMsg.Identity())
+	// get KeyInit
+	sigKeyHash, err := uidMsg.SigKeyHash()
+	if err != nil {
+		return nil, "", err
+	}
+	ki, err := ss.keyDB.GetPublicKeyInit(sigKeyHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", session.ErrNoKeyEntry
+		}
+		return nil, "", err
+	}
+	// decrypt SessionAnchor
+	sa, err := ki.SessionAnchor(uidMsg.SigPubKey())
+	if err != nil {
+		return nil, "", err
+	}
+	// get KeyEntry message from SessionAnchor
+	ke, err := sa.KeyEntry("ECDHE25519")
+	if err != nil {
+		return nil, "", err
+	}
+	return ke, sa.NymAddress(), nil
+}
+
+// GetMessageKey implemented on top of keyDB.
+func (ss *SQLStore) GetMessageKey(
+	sessionKey string,
+	sender bool,
+	msgIndex uint64,
+) (*[64]byte, error) {
+	key, err := ss.keyDB.GetMessageKey(sessionKey, sender, msgIndex)
+	if err != nil {
+		return nil, err
+	}
+	// decode key
+	var messageKey [64]byte
+	k, err := base64.Decode(key)
+	if err != nil {
+		return nil,
+			log.Errorf("sqlstore: cannot decode key for %s", sessionKey)
+	}
+	if copy(messageKey[:], k) != 64 {
+		return nil,
+			log.Errorf("sqlstore: key for %s has wrong length", sessionKey)
+	}
+	return &messageKey, nil
+}
+
+// NumMessageKeys implemented on top of keyDB.
+func (ss *SQLStore) NumMessageKeys(sessionKey string) (uint64, error) {
+	_, _, n, err := ss.keyDB.GetSession(sessionKey)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// GetRootKeyHash implemented on top of keyDB.
+func (ss *SQLStore) GetRootKeyHash(sessionKey string) (*[64]byte, error) {
+	rootKeyHash, _, _, err := ss.keyDB.GetSession(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	// decode root key hash
+	var hash [64]byte
+	k, err := base64.Decode(rootKeyHash)
+	if err != nil {
+		return nil, log.Error("sqlstore: cannot decode root key hash")
+	}
+	if copy(hash[:], k) != 64 {
+		return nil, log.Errorf("sqlstore: root key hash has wrong length")
+	}
+	return &hash, nil
+}
+
+// GetChainKey implemented on top of keyDB.
+func (ss *SQLStore) GetChainKey(sessionKey string) (*[32]byte, error) {
+	_, chainKey, _, err := ss.keyDB.GetSession(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	// decode chain key
+	var key [32]byte
+	k, err := base64.Decode(chainKey)
+	if err != nil {
+		return nil, log.Error("sqlstore: cannot decode chain key")
+	}
+	if copy(key[:], k) != 32 {
+		return nil, log.Errorf("sqlstore: chain key has wrong length")
+	}
+	return &key, nil
+}
+
+// DelMessageKey implemented on top of keyDB.
+func (ss *SQLStore) DelMessageKey(
+	sessionKey string,
+	sender bool,
+	msgIndex uint64,
+) error {
+	return ss.keyDB.DelMessageKey(sessionKey, sender, msgIndex)
+}
+
+// AddSessionKey implemented on top of keyDB.
+func (ss *SQLStore) AddSessionKey(
+	hash, json, privKey string,
+	cleanupTime uint64,
+) error {
+	return ss.keyDB.AddSessionKey(hash, json, privKey, cleanupTime)
+}
+
+// GetSessionKey implemented on top of keyDB.
+func (ss *SQLStore) GetSessionKey(hash string) (
+	json, privKey string,
+	err error,
+) {
+	json, privKey, err = ss.keyDB.GetSessionKey(hash)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", "", log.Error(session.ErrNoKeyEntry)
+	case err != nil:
+		return "", "", err
+	}
+	return
+}
+
+// DelPrivSessionKey implemented on top of keyDB.
+func (ss *SQLStore) DelPrivSessionKey(hash string) error {
+	return ss.keyDB.DelPrivSessionKey(hash)
+}
+
+// CleanupSessionKeys implemented on top of keyDB.
+func (ss *SQLStore) CleanupSessionKeys(t uint64) error {
+	return ss.keyDB.CleanupSessionKeys(t)
+}
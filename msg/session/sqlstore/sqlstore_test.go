@@ -0,0 +1,170 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keydb"
+	"github.com/mutecomm/mute/msg/session"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/times"
+)
+
+func createStore() (tmpdir string, keyDB *keydb.KeyDB, err error) {
+	tmpdir, err = ioutil.TempDir("", "sqlstore_test")
+	if err != nil {
+		return "", nil, err
+	}
+	dbname := filepath.Join(tmpdir, "keydb")
+	passphrase := []byte(cipher.RandPass(cipher.RandReader))
+	if err := keydb.Create(dbname, passphrase, 64000); err != nil {
+		return "", nil, err
+	}
+	keyDB, err = keydb.Open(dbname, passphrase)
+	if err != nil {
+		return "", nil, err
+	}
+	return
+}
+
+func genMessageKey() (*[64]byte, error) {
+	var messageKey [64]byte
+	if _, err := io.ReadFull(cipher.RandReader, messageKey[:]); err != nil {
+		return nil, err
+	}
+	return &messageKey, nil
+}
+
+func TestSessionStore(t *testing.T) {
+	tmpdir, keyDB, err := createStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer keyDB.Close()
+	ss := New(keyDB)
+	sendKey, err := genMessageKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvKey, err := genMessageKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionKey := base64.Encode(cipher.SHA512([]byte("sessionkey")))
+	rootKeyHash := cipher.SHA512([]byte("rootkey"))
+	if ss.HasSession(sessionKey) {
+		t.Error("HasSession() should fail")
+	}
+	err = ss.StoreSession(sessionKey,
+		base64.Encode(rootKeyHash),
+		base64.Encode(cipher.SHA256([]byte("chainkey"))),
+		[]string{base64.Encode(sendKey[:])},
+		[]string{base64.Encode(recvKey[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ss.HasSession(sessionKey) {
+		t.Error("HasSession() should succeed")
+	}
+	// test root key hash
+	h, err := ss.GetRootKeyHash(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h[:], rootKeyHash[:]) {
+		t.Error("root key hashes are not equal")
+	}
+	// test sender key
+	key, err := ss.GetMessageKey(sessionKey, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key[:], sendKey[:]) {
+		t.Error("send key differs")
+	}
+	if err := ss.DelMessageKey(sessionKey, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ss.GetMessageKey(sessionKey, true, 0)
+	if err != session.ErrMessageKeyUsed {
+		t.Error("should fail with session.ErrMessageKeyUsed")
+	}
+	// test receiver key
+	key, err = ss.GetMessageKey(sessionKey, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key[:], recvKey[:]) {
+		t.Error("recv key differs")
+	}
+}
+
+func TestSessionKeys(t *testing.T) {
+	tmpdir, keyDB, err := createStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer keyDB.Close()
+	ss := New(keyDB)
+	var (
+		old   uid.KeyEntry
+		fresh uid.KeyEntry
+	)
+	if err := old.InitDHKey(cipher.RandReader); err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.InitDHKey(cipher.RandReader); err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	err = ss.AddSessionKey(old.HASH, string(old.JSON()), old.PrivateKey(),
+		now-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ss.AddSessionKey(fresh.HASH, string(fresh.JSON()),
+		fresh.PrivateKey(), now+times.Day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// undefined key
+	if _, _, err := ss.GetSessionKey("undefined"); err != session.ErrNoKeyEntry {
+		t.Error("should fail with session.ErrNoKeyEntry")
+	}
+	// delete only the private half
+	if err := ss.DelPrivSessionKey(fresh.HASH); err != nil {
+		t.Fatal(err)
+	}
+	jsn, privKey, err := ss.GetSessionKey(fresh.HASH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jsn != string(fresh.JSON()) {
+		t.Error("json differs")
+	}
+	if privKey != "" {
+		t.Error("privKey should be empty")
+	}
+	// cleanup old keys
+	if err := ss.CleanupSessionKeys(now); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ss.GetSessionKey(old.HASH); err != session.ErrNoKeyEntry {
+		t.Error("old session key should be deleted")
+	}
+	if _, _, err := ss.GetSessionKey(fresh.HASH); err != nil {
+		t.Error(err)
+	}
+}
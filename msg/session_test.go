@@ -480,3 +480,176 @@ func TestSimultaneousSessions(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+type testParty struct {
+	uid    *uid.Message
+	ms     *memstore.MemStore
+	window uint64
+}
+
+func (p *testParty) encrypt(t *testing.T, to *testParty, content string) string {
+	var w bytes.Buffer
+	args := &msg.EncryptArgs{
+		Writer:                 &w,
+		From:                   p.uid,
+		To:                     to.uid,
+		NymAddress:             "nymaddress",
+		SenderLastKeychainHash: hashchain.TestEntry,
+		Reader:                 bytes.NewBufferString(content),
+		Rand:                   cipher.RandReader,
+		KeyStore:               p.ms,
+	}
+	if _, err := msg.Encrypt(args); err != nil {
+		t.Fatal(err)
+	}
+	return w.String()
+}
+
+func (p *testParty) tryDecrypt(enc string) (string, error) {
+	var res bytes.Buffer
+	input := base64.NewDecoder(bytes.NewBufferString(enc))
+	_, preHeader, err := msg.ReadFirstOuterHeader(input)
+	if err != nil {
+		return "", err
+	}
+	args := &msg.DecryptArgs{
+		Writer:     &res,
+		Identities: []*uid.Message{p.uid},
+		PreHeader:  preHeader,
+		Reader:     input,
+		KeyWindow:  p.window,
+		Rand:       cipher.RandReader,
+		KeyStore:   p.ms,
+	}
+	if _, _, err := msg.Decrypt(args); err != nil {
+		return "", err
+	}
+	return res.String(), nil
+}
+
+func (p *testParty) decrypt(t *testing.T, enc string) string {
+	res, err := p.tryDecrypt(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func (p *testParty) state(t *testing.T, other *testParty) *session.State {
+	key := session.CalcStateKey(p.uid.PubKey().PublicKey32(),
+		other.uid.PubKey().PublicKey32())
+	ss, err := p.ms.GetSessionState(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss == nil {
+		t.Fatal("no session state")
+	}
+	return ss
+}
+
+func newTestParties(t *testing.T) (alice, bob *testParty) {
+	alice = &testParty{ms: memstore.New()}
+	bob = &testParty{ms: memstore.New()}
+	var err error
+	alice.uid, err = uid.Create("alice@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob.uid, err = uid.Create("bob@mute.berlin", false, "", "", uid.Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(times.Now())
+	bobKI, _, privateKey, err := bob.uid.KeyInit(1, now+times.Day,
+		now-times.Day, false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobTemp, err := bobKI.KeyEntryECDHE25519(bob.uid.SigPubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.ms.AddPublicKeyEntry(bob.uid.Identity(), bobTemp)
+	if err := bobTemp.SetPrivateKey(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	bob.ms.AddPrivateKeyEntry(bobTemp)
+	return
+}
+
+func TestSessionRatchet(t *testing.T) {
+	alice, bob := newTestParties(t)
+	// ratchet without session
+	key := session.CalcStateKey(alice.uid.PubKey().PublicKey32(),
+		bob.uid.PubKey().PublicKey32())
+	if _, err := msg.RatchetSession(alice.ms, key, cipher.RandReader); err != msg.ErrNoSession {
+		t.Errorf("RatchetSession() without session: %v", err)
+	}
+	// establish session (until no refresh is pending anymore)
+	for i := 0; i < 10; i++ {
+		bob.decrypt(t, alice.encrypt(t, bob, "hello bob"))
+		alice.decrypt(t, bob.encrypt(t, alice, "hello alice"))
+		if alice.state(t, bob).NextSenderSessionPub == nil {
+			break
+		}
+	}
+	old := alice.state(t, bob)
+	if old.NextSenderSessionPub != nil {
+		t.Fatal("session refresh still pending")
+	}
+	// ratchet
+	next, err := msg.RatchetSession(alice.ms, key, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.HASH == old.SenderSessionPub.HASH {
+		t.Fatal("ratchet didn't generate a new session key")
+	}
+	again, err := msg.RatchetSession(alice.ms, key, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.HASH != next.HASH {
+		t.Error("pending ratchet step should be reused")
+	}
+	// the next message announces the new session key, after bob confirmed
+	// it alice uses the new session key
+	bob.decrypt(t, alice.encrypt(t, bob, "ratchet"))
+	alice.decrypt(t, bob.encrypt(t, alice, "ack"))
+	if s := bob.decrypt(t, alice.encrypt(t, bob, "new session")); s != "new session" {
+		t.Errorf("decrypted %q", s)
+	}
+	if alice.state(t, bob).SenderSessionPub.HASH != next.HASH {
+		t.Error("alice doesn't use new session key")
+	}
+}
+
+func TestPruneMessageKeys(t *testing.T) {
+	alice, bob := newTestParties(t)
+	bob.window = 3
+	bob.decrypt(t, alice.encrypt(t, bob, "msg 0"))
+	var msgs []string
+	for i := 1; i < 10; i++ {
+		msgs = append(msgs, alice.encrypt(t, bob, "msg"))
+	}
+	// receive the last message first
+	bob.decrypt(t, msgs[8])
+	if n := bob.state(t, alice).MaxRecipientCount; n != 9 {
+		t.Errorf("MaxRecipientCount == %d, should be 9", n)
+	}
+	// late messages within the window can still be decrypted
+	bob.decrypt(t, msgs[7])
+	bob.decrypt(t, msgs[5])
+	// the keys of older messages have been pruned
+	for _, i := range []int{0, 3, 4} {
+		if _, err := bob.tryDecrypt(msgs[i]); err == nil {
+			t.Errorf("message %d decrypted after its key was pruned", i+1)
+		}
+	}
+	if n := bob.state(t, alice).MaxRecipientCount; n != 9 {
+		t.Errorf("MaxRecipientCount == %d, should be 9", n)
+	}
+}
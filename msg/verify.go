@@ -0,0 +1,104 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msg
+
+import (
+	"crypto/ed25519"
+	"runtime"
+	"sync"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// SignatureCheck contains everything required to verify the signature of a
+// decrypted message.
+type SignatureCheck struct {
+	PublicKey   *[32]byte // public signature key of the sender
+	ContentHash []byte    // SHA-512 hash of the message content
+	Signature   []byte    // signature of ContentHash
+}
+
+// Verify verifies the signature and returns ErrInvalidSignature, if the
+// signature could not be verified.
+func (c *SignatureCheck) Verify() error {
+	if len(c.Signature) != ed25519.SignatureSize {
+		return log.Error(ErrWrongSignatureLength)
+	}
+	if !ed25519.Verify(c.PublicKey[:], c.ContentHash, c.Signature) {
+		return log.Error(ErrInvalidSignature)
+	}
+	return nil
+}
+
+// VerifySignatures verifies the given signature checks concurrently with a
+// pool of workers (default: runtime.NumCPU()). The result for checks[i] is
+// returned in errs[i], nil entries in checks (unsigned messages) always
+// verify.
+func VerifySignatures(checks []*SignatureCheck, workers int) (errs []error) {
+	errs = make([]error, len(checks))
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(checks) {
+		workers = len(checks)
+	}
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				errs[i] = checks[i].Verify()
+			}
+		}()
+	}
+	for i, check := range checks {
+		if check != nil {
+			idx <- i
+		}
+	}
+	close(idx)
+	wg.Wait()
+	return errs
+}
+
+// DecryptResult is the result of a single message decryption of
+// DecryptBatch (see Decrypt for details).
+type DecryptResult struct {
+	SenderID string
+	Sig      string
+	Err      error
+}
+
+// DecryptBatch decrypts the messages given in args. The messages are
+// decrypted one after another, because they share the session state in their
+// key stores, but the signatures are verified concurrently afterwards with a
+// pool of workers (see VerifySignatures). The result for args[i] is returned
+// in res[i]. Signatures of messages with DeferSignatureCheck set are not
+// verified.
+//
+// In contrast to Decrypt the session keys of a message with an invalid
+// signature are consumed, which is harmless, because the message has
+// already been authenticated by its HMAC.
+func DecryptBatch(args []*DecryptArgs, workers int) (res []DecryptResult) {
+	res = make([]DecryptResult, len(args))
+	checks := make([]*SignatureCheck, len(args))
+	for i, arg := range args {
+		deferred := arg.DeferSignatureCheck
+		arg.DeferSignatureCheck = true
+		res[i].SenderID, res[i].Sig, res[i].Err = Decrypt(arg)
+		arg.DeferSignatureCheck = deferred
+		if res[i].Err == nil && !deferred {
+			checks[i] = arg.SignatureCheck
+		}
+	}
+	for i, err := range VerifySignatures(checks, workers) {
+		if err != nil {
+			res[i] = DecryptResult{Err: err}
+		}
+	}
+	return res
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msg
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/msg/session/memstore"
+	"github.com/mutecomm/mute/uid"
+)
+
+// signatureChecks returns n signature checks of signed random messages. Every
+// invalid-th signature is invalid (if invalid > 0).
+func signatureChecks(n, invalid int) ([]*SignatureCheck, error) {
+	pub, priv, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		return nil, err
+	}
+	var pubKey [32]byte
+	copy(pubKey[:], pub)
+	checks := make([]*SignatureCheck, n)
+	for i := range checks {
+		content := make([]byte, 1024)
+		if _, err := cipher.RandReader.Read(content); err != nil {
+			return nil, err
+		}
+		contentHash := cipher.SHA512(content)
+		sig := ed25519.Sign(priv, contentHash)
+		if invalid > 0 && i%invalid == 0 {
+			sig[0] ^= 0xff
+		}
+		checks[i] = &SignatureCheck{
+			PublicKey:   &pubKey,
+			ContentHash: contentHash,
+			Signature:   sig,
+		}
+	}
+	return checks, nil
+}
+
+func TestVerifySignatures(t *testing.T) {
+	checks, err := signatureChecks(64, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks[7] = nil // unsigned message
+	for _, workers := range []int{0, 1, 3, 100} {
+		errs := VerifySignatures(checks, workers)
+		if len(errs) != len(checks) {
+			t.Fatalf("len(errs) == %d != %d", len(errs), len(checks))
+		}
+		for i, check := range checks {
+			var err error
+			if check != nil {
+				err = check.Verify()
+			}
+			if errs[i] != err {
+				t.Errorf("workers=%d: result %d differs from sequential "+
+					"verification: %v != %v", workers, i, errs[i], err)
+			}
+		}
+	}
+}
+
+func TestDecryptBatch(t *testing.T) {
+	var (
+		batch      []*DecryptArgs
+		sequential []*DecryptArgs
+		batchRes   []*bytes.Buffer
+		seqRes     []*bytes.Buffer
+	)
+	for i := 0; i < 6; i++ {
+		sign := i%2 == 0
+		_, recipient, w, recipientTemp, privateKey, err := encrypt(sign, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// decrypt every message twice, in the batch and sequentially
+		for _, dec := range []struct {
+			args *[]*DecryptArgs
+			res  *[]*bytes.Buffer
+		}{
+			{&batch, &batchRes},
+			{&sequential, &seqRes},
+		} {
+			input := base64.NewDecoder(bytes.NewBuffer(w.Bytes()))
+			_, preHeader, err := ReadFirstOuterHeader(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ke := *recipientTemp
+			if err := ke.SetPrivateKey(privateKey); err != nil {
+				t.Fatal(err)
+			}
+			ms := memstore.New()
+			ms.AddPrivateKeyEntry(&ke)
+			var res bytes.Buffer
+			*dec.args = append(*dec.args, &DecryptArgs{
+				Writer:     &res,
+				Identities: []*uid.Message{recipient},
+				PreHeader:  preHeader,
+				Reader:     input,
+				Rand:       cipher.RandReader,
+				KeyStore:   ms,
+			})
+			*dec.res = append(*dec.res, &res)
+		}
+	}
+	results := DecryptBatch(batch, 0)
+	for i, args := range sequential {
+		senderID, sig, err := Decrypt(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[i].Err != nil {
+			t.Fatal(results[i].Err)
+		}
+		if results[i].SenderID != senderID {
+			t.Errorf("message %d: sender %s != %s", i, results[i].SenderID,
+				senderID)
+		}
+		if results[i].Sig != sig {
+			t.Errorf("message %d: signature differs", i)
+		}
+		if (sig != "") != (i%2 == 0) {
+			t.Errorf("message %d: unexpected signature %q", i, sig)
+		}
+		if batchRes[i].String() != seqRes[i].String() {
+			t.Errorf("message %d: content differs", i)
+		}
+	}
+}
+
+func TestDeferSignatureCheck(t *testing.T) {
+	sender, recipient, w, recipientTemp, privateKey, err := encrypt(true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.NewDecoder(&w)
+	_, preHeader, err := ReadFirstOuterHeader(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recipientTemp.SetPrivateKey(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	ms := memstore.New()
+	ms.AddPrivateKeyEntry(recipientTemp)
+	var res bytes.Buffer
+	args := &DecryptArgs{
+		Writer:              &res,
+		Identities:          []*uid.Message{recipient},
+		PreHeader:           preHeader,
+		Reader:              input,
+		Rand:                cipher.RandReader,
+		KeyStore:            ms,
+		DeferSignatureCheck: true,
+	}
+	_, sig, err := Decrypt(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := args.SignatureCheck
+	if check == nil {
+		t.Fatal("deferred signature check not set")
+	}
+	if *check.PublicKey != *sender.PublicSigKey32() {
+		t.Error("wrong public key in signature check")
+	}
+	if !bytes.Equal(check.ContentHash, cipher.SHA512(res.Bytes())) {
+		t.Error("wrong content hash in signature check")
+	}
+	if base64.Encode(check.Signature) != sig {
+		t.Error("wrong signature in signature check")
+	}
+	// verify later
+	if err := check.Verify(); err != nil {
+		t.Error(err)
+	}
+	// the returned signature and the content suffice as well
+	decSig, err := base64.Decode(sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := &SignatureCheck{
+		PublicKey:   sender.PublicSigKey32(),
+		ContentHash: cipher.SHA512(res.Bytes()),
+		Signature:   decSig,
+	}
+	if err := later.Verify(); err != nil {
+		t.Error(err)
+	}
+	later.ContentHash = cipher.SHA512(append(res.Bytes(), 'x'))
+	if err := later.Verify(); err != ErrInvalidSignature {
+		t.Errorf("modified content should not verify: %v", err)
+	}
+}
+
+func benchmarkVerifySignatures(b *testing.B, workers int) {
+	checks, err := signatureChecks(256, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, err := range VerifySignatures(checks, workers) {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkVerifySignatures(b *testing.B) {
+	for _, workers := range []int{1, 0} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkVerifySignatures(b, workers)
+		})
+	}
+}
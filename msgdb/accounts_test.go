@@ -32,14 +32,17 @@ func TestAccount(t *testing.T) {
 		t.Fatal(err)
 	}
 	// add acounts
-	_, privkey1, err := ed25519.GenerateKey(cipher.RandReader)
+	_, key1, err := ed25519.GenerateKey(cipher.RandReader)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, privkey2, err := ed25519.GenerateKey(cipher.RandReader)
+	_, key2, err := ed25519.GenerateKey(cipher.RandReader)
 	if err != nil {
 		t.Fatal(err)
 	}
+	var privkey1, privkey2 [ed25519.PrivateKeySize]byte
+	copy(privkey1[:], key1)
+	copy(privkey2[:], key2)
 	server1 := "accounts001.mute.berlin"
 	server2 := "accounts002.mute.berlin"
 	var secret1 [64]byte
@@ -50,7 +53,7 @@ func TestAccount(t *testing.T) {
 	if _, err := io.ReadFull(cipher.RandReader, secret2[:]); err != nil {
 		t.Fatal(err)
 	}
-	err = msgDB.AddAccount(a, "", privkey1, server1, &secret1,
+	err = msgDB.AddAccount(a, "", &privkey1, server1, &secret1,
 		def.MinMinDelay, def.MinMaxDelay)
 	if err != nil {
 		t.Fatal(err)
@@ -66,7 +69,7 @@ func TestAccount(t *testing.T) {
 			t.Error("contacts[0] != \"\"")
 		}
 	}
-	err = msgDB.AddAccount(a, b, privkey2, server2, &secret2,
+	err = msgDB.AddAccount(a, b, &privkey2, server2, &secret2,
 		def.MinMinDelay, def.MinMaxDelay)
 	if err != nil {
 		t.Fatal(err)
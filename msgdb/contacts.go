@@ -111,9 +111,173 @@ func (msgDB *MsgDB) GetContact(myID, contactID string) (
 	return
 }
 
-// GetContacts retrieves all the contacts list (or blacklist, if blocked
-// equals true) for the given ownID user ID.
-func (msgDB *MsgDB) GetContacts(myID string, blocked bool) ([]string, error) {
+// SetContactNotes sets the (local) notes for the contact contactID of myID.
+func (msgDB *MsgDB) SetContactNotes(myID, contactID, notes string) error {
+	if err := identity.IsMapped(myID); err != nil {
+		return log.Error(err)
+	}
+	if err := identity.IsMapped(contactID); err != nil {
+		return log.Error(err)
+	}
+	// get MyID
+	var uid int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&uid); err != nil {
+		return log.Error(err)
+	}
+	res, err := msgDB.setContactNotesQuery.Exec(notes, uid, contactID)
+	if err != nil {
+		return log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return log.Error(err)
+	}
+	if n == 0 {
+		return log.Errorf("msgdb: unknown contact %s", contactID)
+	}
+	return nil
+}
+
+// GetContactNotes returns the (local) notes for the contact contactID of
+// myID.
+func (msgDB *MsgDB) GetContactNotes(myID, contactID string) (string, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return "", log.Error(err)
+	}
+	if err := identity.IsMapped(contactID); err != nil {
+		return "", log.Error(err)
+	}
+	// get MyID
+	var uid int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&uid); err != nil {
+		return "", log.Error(err)
+	}
+	var notes string
+	err := msgDB.getContactNotesQuery.QueryRow(uid, contactID).Scan(&notes)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", log.Errorf("msgdb: unknown contact %s", contactID)
+	case err != nil:
+		return "", log.Error(err)
+	}
+	return notes, nil
+}
+
+// SetContactAlias sets the (local) alias for the contact contactID of myID.
+// An alias can only be used for one contact of myID, an empty alias removes
+// it.
+func (msgDB *MsgDB) SetContactAlias(myID, contactID, alias string) error {
+	if err := identity.IsMapped(myID); err != nil {
+		return log.Error(err)
+	}
+	if err := identity.IsMapped(contactID); err != nil {
+		return log.Error(err)
+	}
+	// get MyID
+	var uid int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&uid); err != nil {
+		return log.Error(err)
+	}
+	if alias != "" {
+		var other string
+		err := msgDB.getContactByAliasQuery.QueryRow(uid, alias).Scan(&other)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return log.Error(err)
+		case other != contactID:
+			return log.Errorf("msgdb: alias %s already used for contact %s",
+				alias, other)
+		}
+	}
+	res, err := msgDB.setContactAliasQuery.Exec(alias, uid, contactID)
+	if err != nil {
+		return log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return log.Error(err)
+	}
+	if n == 0 {
+		return log.Errorf("msgdb: unknown contact %s", contactID)
+	}
+	return nil
+}
+
+// GetContactAlias returns the (local) alias for the contact contactID of
+// myID.
+func (msgDB *MsgDB) GetContactAlias(myID, contactID string) (string, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return "", log.Error(err)
+	}
+	if err := identity.IsMapped(contactID); err != nil {
+		return "", log.Error(err)
+	}
+	// get MyID
+	var uid int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&uid); err != nil {
+		return "", log.Error(err)
+	}
+	var alias string
+	err := msgDB.getContactAliasQuery.QueryRow(uid, contactID).Scan(&alias)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", log.Errorf("msgdb: unknown contact %s", contactID)
+	case err != nil:
+		return "", log.Error(err)
+	}
+	return alias, nil
+}
+
+// GetContactStats returns the time of the last exchanged message (0: never)
+// and the number of sent and received messages for the contact contactID of
+// myID.
+func (msgDB *MsgDB) GetContactStats(myID, contactID string) (
+	lastSeen, sent, received int64,
+	err error,
+) {
+	if err := identity.IsMapped(myID); err != nil {
+		return 0, 0, 0, log.Error(err)
+	}
+	if err := identity.IsMapped(contactID); err != nil {
+		return 0, 0, 0, log.Error(err)
+	}
+	// get MyID
+	var uid int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&uid); err != nil {
+		return 0, 0, 0, log.Error(err)
+	}
+	err = msgDB.getContactStatsQuery.QueryRow(uid, contactID).Scan(&lastSeen,
+		&sent, &received)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, 0, 0, log.Errorf("msgdb: unknown contact %s", contactID)
+	case err != nil:
+		return 0, 0, 0, log.Error(err)
+	}
+	return
+}
+
+// Contact is an entry of a contact list.
+type Contact struct {
+	MappedID   string
+	UnmappedID string
+	FullName   string
+	LastSeen   int64 // time of the last exchanged message (0: never)
+}
+
+// String returns the contact in the form "FullName <UnmappedID>" (or just
+// "UnmappedID", if the contact has no full name).
+func (c *Contact) String() string {
+	if c.FullName == "" {
+		return c.UnmappedID
+	}
+	return c.FullName + " <" + c.UnmappedID + ">"
+}
+
+// GetContactList retrieves all entries of the contacts list (or blacklist, if
+// blocked equals true) for the given myID user ID.
+func (msgDB *MsgDB) GetContactList(myID string, blocked bool) ([]*Contact, error) {
 	if err := identity.IsMapped(myID); err != nil {
 		return nil, log.Error(err)
 	}
@@ -131,18 +295,15 @@ func (msgDB *MsgDB) GetContacts(myID string, blocked bool) ([]string, error) {
 	if err != nil {
 		return nil, log.Error(err)
 	}
-	var contacts []string
+	var contacts []*Contact
 	defer rows.Close()
 	for rows.Next() {
-		var unmappedID, fullName string
-		if err := rows.Scan(&unmappedID, &fullName); err != nil {
+		var c Contact
+		err := rows.Scan(&c.MappedID, &c.UnmappedID, &c.FullName, &c.LastSeen)
+		if err != nil {
 			return nil, log.Error(err)
 		}
-		if fullName == "" {
-			contacts = append(contacts, unmappedID)
-		} else {
-			contacts = append(contacts, fullName+" <"+unmappedID+">")
-		}
+		contacts = append(contacts, &c)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, log.Error(err)
@@ -150,6 +311,20 @@ func (msgDB *MsgDB) GetContacts(myID string, blocked bool) ([]string, error) {
 	return contacts, nil
 }
 
+// GetContacts retrieves all the contacts list (or blacklist, if blocked
+// equals true) for the given ownID user ID.
+func (msgDB *MsgDB) GetContacts(myID string, blocked bool) ([]string, error) {
+	list, err := msgDB.GetContactList(myID, blocked)
+	if err != nil {
+		return nil, err
+	}
+	var contacts []string
+	for _, c := range list {
+		contacts = append(contacts, c.String())
+	}
+	return contacts, nil
+}
+
 // RemoveContact removes a contact between myID and contactID (normal or
 // blocked) from the msgDB.
 func (msgDB *MsgDB) RemoveContact(myID, contactID string) error {
@@ -10,3 +10,7 @@ import (
 
 // ErrNilMessageID is returned if the messageID argument is nil.
 var ErrNilMessageID = errors.New("msgdb: messageID nil")
+
+// ErrMessageSent is returned if a message which has been sent already is
+// cancelled.
+var ErrMessageSent = errors.New("msgdb: message has been sent already")
@@ -0,0 +1,121 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msgdb
+
+import (
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/uid/identity"
+)
+
+// getGroupIDs checks the arguments of a group membership change and returns
+// the database IDs for myID and contactID.
+func (msgDB *MsgDB) getGroupIDs(myID, group, contactID string) (
+	mID, cID int,
+	err error,
+) {
+	if err := identity.IsMapped(myID); err != nil {
+		return 0, 0, log.Error(err)
+	}
+	if group == "" {
+		return 0, 0, log.Error("msgdb: group must be defined")
+	}
+	if err := identity.IsMapped(contactID); err != nil {
+		return 0, 0, log.Error(err)
+	}
+	// get MyID
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return 0, 0, log.Error(err)
+	}
+	// get ContactID
+	err = msgDB.getContactUIDQuery.QueryRow(mID, contactID).Scan(&cID)
+	if err != nil {
+		return 0, 0, log.Error(err)
+	}
+	return
+}
+
+// AddGroupMember adds contactID as a member to the given group of myID.
+// Adding an existing member is not an error.
+func (msgDB *MsgDB) AddGroupMember(myID, group, contactID string) error {
+	mID, cID, err := msgDB.getGroupIDs(myID, group, contactID)
+	if err != nil {
+		return err
+	}
+	if _, err := msgDB.addGroupMemberQuery.Exec(mID, group, cID); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// RemoveGroupMember removes contactID from the given group of myID.
+func (msgDB *MsgDB) RemoveGroupMember(myID, group, contactID string) error {
+	mID, cID, err := msgDB.getGroupIDs(myID, group, contactID)
+	if err != nil {
+		return err
+	}
+	if _, err := msgDB.delGroupMemberQuery.Exec(mID, group, cID); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// GetGroupMembers returns the (mapped) IDs of all members of the given group
+// of myID.
+func (msgDB *MsgDB) GetGroupMembers(myID, group string) ([]string, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return nil, log.Error(err)
+	}
+	// get MyID
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return nil, log.Error(err)
+	}
+	rows, err := msgDB.getGroupMembersQuery.Query(mID, group)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var members []string
+	defer rows.Close()
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, log.Error(err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return members, nil
+}
+
+// GetGroups returns the names of all groups of myID.
+func (msgDB *MsgDB) GetGroups(myID string) ([]string, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return nil, log.Error(err)
+	}
+	// get MyID
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return nil, log.Error(err)
+	}
+	rows, err := msgDB.getGroupsQuery.Query(mID)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var groups []string
+	defer rows.Close()
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			return nil, log.Error(err)
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return groups, nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msgdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGroupMembers(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	c := "carol@mute.berlin"
+	if err := msgDB.AddNym(a, a, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "Bob", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, c, c, "Carol", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddGroupMember(a, "friends", c); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddGroupMember(a, "friends", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddGroupMember(a, "friends", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddGroupMember(a, "family", c); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddGroupMember(a, "friends", "eve@mute.berlin"); err == nil {
+		t.Error("adding unknown contact should fail")
+	}
+	groups, err := msgDB.GetGroups(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0] != "family" || groups[1] != "friends" {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+	members, err := msgDB.GetGroupMembers(a, "friends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0] != b || members[1] != c {
+		t.Errorf("unexpected members: %v", members)
+	}
+	if err := msgDB.RemoveGroupMember(a, "friends", b); err != nil {
+		t.Fatal(err)
+	}
+	members, err = msgDB.GetGroupMembers(a, "friends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != c {
+		t.Errorf("unexpected members: %v", members)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg/mime"
 	"github.com/mutecomm/mute/uid/identity"
 )
 
@@ -39,6 +40,22 @@ func (msgDB *MsgDB) AddInQueue(myID, contactID string, date int64, msg string) e
 	return nil
 }
 
+// AddInQueueMessage adds the encrypted message msg for myID, which has been
+// received directly (without envelope), to the inqueue.
+func (msgDB *MsgDB) AddInQueueMessage(myID string, date int64, msg string) error {
+	if err := identity.IsMapped(myID); err != nil {
+		return log.Error(err)
+	}
+	var mID int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return log.Error(err)
+	}
+	if _, err := msgDB.addInQueueMsgQuery.Exec(mID, date, msg); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
 // GetInQueue returns the first entry in the inqueue.
 func (msgDB *MsgDB) GetInQueue() (
 	iqIdx int64,
@@ -72,64 +89,153 @@ func (msgDB *MsgDB) GetInQueue() (
 	return
 }
 
+// InQueueMsg is an entry in the inqueue without envelope (see
+// GetInQueueMsgs).
+type InQueueMsg struct {
+	IQIdx int64  // index of the inqueue entry
+	MyID  string // mapped ID the message was received for
+	Msg   string // encrypted message
+}
+
+// GetInQueueMsgs returns all entries in the inqueue without envelope (see
+// AddInQueueMessage and SetInQueue), the oldest first.
+func (msgDB *MsgDB) GetInQueueMsgs() ([]*InQueueMsg, error) {
+	rows, err := msgDB.getInQueueMsgsQuery.Query()
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var (
+		entries []*InQueueMsg
+		mIDs    []int64
+	)
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			entry InQueueMsg
+			mID   int64
+		)
+		if err := rows.Scan(&entry.IQIdx, &mID, &entry.Msg); err != nil {
+			return nil, log.Error(err)
+		}
+		entries = append(entries, &entry)
+		mIDs = append(mIDs, mID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	for i, entry := range entries {
+		err := msgDB.getNymMappedQuery.QueryRow(mIDs[i]).Scan(&entry.MyID)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+	}
+	return entries, nil
+}
+
 // SetInQueue replaces the encrypted message corresponding to iqIdx with the
-// encrypted message msg.
-func (msgDB *MsgDB) SetInQueue(iqIdx int64, msg string) error {
-	if _, err := msgDB.setInQueueQuery.Exec(msg, iqIdx); err != nil {
+// encrypted message msg (with the envelope removed). nymAddress is the nym
+// address the message was sent to (empty, if unknown), it is stored with the
+// message by RemoveInQueue.
+func (msgDB *MsgDB) SetInQueue(iqIdx int64, msg, nymAddress string) error {
+	if _, err := msgDB.setInQueueQuery.Exec(msg, nymAddress, iqIdx); err != nil {
 		return log.Error(err)
 	}
 	return nil
 }
 
 // RemoveInQueue remove the entry with index iqIdx from inqueue and adds the
-// descrypted message plainMsg to msgDB (if drop is not true).
+// descrypted message plainMsg to msgDB (if drop is not true). The permanent
+// signature of the message is stored, if it has one (see
+// GetMessageSignature). If expire is greater than zero, the message is
+// deleted at that time (see DelExpiredMessages). If burn is true, the message
+// is deleted after reading it once (see BurnMessage). Until then it is stored
+// sealed with a random key and without subject, the plaintext is not kept in
+// msgDB. The nym address the message was sent to (see SetInQueue) is stored
+// with the message (see GetMessageNymAddress). The message number of the
+// added message is returned (0, if drop is true).
 func (msgDB *MsgDB) RemoveInQueue(
-	iqIdx int64, plainMsg, fromID string,
+	iqIdx int64, plainMsg, fromID, signature string,
+	expire int64,
+	burn bool,
 	drop bool,
-) error {
+) (int64, error) {
 	if err := identity.IsMapped(fromID); err != nil {
-		return log.Error(err)
+		return 0, log.Error(err)
 	}
 	var mID int64
 	var cID int64
 	var date int64
-	err := msgDB.getInQueueIDsQuery.QueryRow(iqIdx).Scan(&mID, &cID, &date)
+	var nymAddress string
+	err := msgDB.getInQueueIDsQuery.QueryRow(iqIdx).Scan(&mID, &cID, &date,
+		&nymAddress)
 	if err != nil {
-		return log.Error(err)
+		return 0, log.Error(err)
 	}
 	err = msgDB.getContactUIDQuery.QueryRow(mID, fromID).Scan(&cID)
 	if err != nil {
-		return log.Error(err)
+		return 0, log.Error(err)
 	}
 	tx, err := msgDB.encDB.Begin()
 	if err != nil {
-		return log.Error(err)
+		return 0, log.Error(err)
 	}
 	var to string
 	if err := tx.Stmt(msgDB.getNymMappedQuery).QueryRow(mID).Scan(&to); err != nil {
 		tx.Rollback()
-		return log.Error(err)
+		return 0, log.Error(err)
 	}
-	// TODO: handle signatures
-	parts := strings.SplitN(plainMsg, "\n", 2)
+	opts, body := mime.SplitOptions(plainMsg) // subject follows option lines
+	parts := strings.SplitN(body, "\n", 2)
 	subject := parts[0]
+	var sign int64
+	if signature != "" {
+		sign = 1
+	}
+	var burnKey string
+	if burn && !drop {
+		plainMsg, burnKey, err = sealBurn(plainMsg)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		subject = ""
+	}
+	var msgNum int64
 	if !drop {
-		_, err = tx.Stmt(msgDB.addMsgQuery).Exec(mID, cID, 0, 0, 0, fromID,
-			to, date, subject, plainMsg, 0, 0, 0)
+		res, err := tx.Stmt(msgDB.addMsgQuery).Exec(mID, cID, 0, 0, 0, fromID,
+			to, date, subject, plainMsg, sign, 0, 0, NormalPriority,
+			opts.ContentType, "")
 		if err != nil {
 			tx.Rollback()
-			return log.Error(err)
+			return 0, log.Error(err)
+		}
+		msgNum, err = res.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return 0, log.Error(err)
+		}
+		if expire > 0 || burn || signature != "" || nymAddress != "" {
+			var b int
+			if burn {
+				b = 1
+			}
+			_, err = tx.Stmt(msgDB.setMsgOptionsQuery).Exec(expire, b,
+				signature, burnKey, nymAddress, msgNum)
+			if err != nil {
+				tx.Rollback()
+				return 0, log.Error(err)
+			}
 		}
 	}
 	if _, err := tx.Stmt(msgDB.removeInQueueQuery).Exec(iqIdx); err != nil {
 		tx.Rollback()
-		return log.Error(err)
+		return 0, log.Error(err)
 	}
 	if err := tx.Commit(); err != nil {
 		tx.Rollback()
-		return log.Error(err)
+		return 0, log.Error(err)
 	}
-	return nil
+	return msgNum, nil
 }
 
 // DelInQueue deletes the entry  with index iqIdx from inqueue.
@@ -52,12 +52,27 @@ func TestInQueue(t *testing.T) {
 	if !env {
 		t.Error("!env")
 	}
-	if err := msgDB.SetInQueue(iqIdx, "encrypted1"); err != nil {
+	if err := msgDB.SetInQueue(iqIdx, "encrypted1", "nym1"); err != nil {
 		t.Fatal(err)
 	}
-	if err := msgDB.RemoveInQueue(iqIdx, "plaintext1", b, false); err != nil {
+	if _, err := msgDB.RemoveInQueue(iqIdx, "plaintext1", b, "", 0, false, false); err != nil {
 		t.Fatal(err)
 	}
+	// the nym address is stored with the message
+	ids, err := msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0].NymAddress != "nym1" {
+		t.Error("nym address of message not stored")
+	}
+	nymAddress, err := msgDB.GetMessageNymAddress(a, ids[0].MsgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nymAddress != "nym1" {
+		t.Errorf("nymAddress == %q != \"nym1\"", nymAddress)
+	}
 	iqIdx, myID, contactID, msg2, env, err := msgDB.GetInQueue()
 	if err != nil {
 		t.Fatal(err)
@@ -77,7 +92,24 @@ func TestInQueue(t *testing.T) {
 	if !env {
 		t.Error("!env")
 	}
-	if err := msgDB.SetInQueue(iqIdx, "encrypted2"); err != nil {
+	if err := msgDB.SetInQueue(iqIdx, "encrypted2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddInQueue(a, b, now, "envelope3"); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := msgDB.GetInQueueMsgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("len(msgs) == %d != 1", len(msgs))
+	}
+	if msgs[0].IQIdx != iqIdx || msgs[0].MyID != a ||
+		msgs[0].Msg != "encrypted2" {
+		t.Errorf("unexpected inqueue message: %+v", msgs[0])
+	}
+	if err := msgDB.DelInQueue(msgs[0].IQIdx + 1); err != nil {
 		t.Fatal(err)
 	}
 	if err := msgDB.DelInQueue(iqIdx); err != nil {
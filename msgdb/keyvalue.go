@@ -51,3 +51,14 @@ func (msgDB *MsgDB) GetValue(key string) (string, error) {
 		return value, nil
 	}
 }
+
+// DelValue deletes the value for the given key from msgDB.
+func (msgDB *MsgDB) DelValue(key string) error {
+	if key == "" {
+		return log.Error("msgdb: key must be defined")
+	}
+	if _, err := msgDB.delValueQuery.Exec(key); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
@@ -5,15 +5,35 @@
 package msgdb
 
 import (
+	"context"
 	"database/sql"
+	"io"
 	"strings"
 
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/cipher/aes256"
+	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/msg/mime"
 	"github.com/mutecomm/mute/uid/identity"
 )
 
+// Priority represents the priority of a message to send. Messages with
+// higher priority are sent first.
+type Priority int64
+
+const (
+	// LowPriority represents a message which is sent last.
+	LowPriority Priority = iota - 1
+	// NormalPriority represents a normal message.
+	NormalPriority
+	// HighPriority represents a message which is sent first.
+	HighPriority
+)
+
 // AddMessage adds message between selfID and peerID to msgDB. If sent is
 // true, it is a sent message. Otherwise a received message.
+// Sent messages are delivered in the order of their priority.
 func (msgDB *MsgDB) AddMessage(
 	selfID, peerID string,
 	date int64,
@@ -21,6 +41,35 @@ func (msgDB *MsgDB) AddMessage(
 	message string,
 	sign bool,
 	minDelay, maxDelay int32,
+	priority Priority,
+) error {
+	return msgDB.addMessage(selfID, peerID, date, sent, message, sign,
+		minDelay, maxDelay, priority, "")
+}
+
+// AddGroupMessage adds the message sent from selfID to the group member
+// peerID to msgDB. groupBody is the body of the group message encrypted once
+// for all members (see GetMessageGroupBody).
+func (msgDB *MsgDB) AddGroupMessage(
+	selfID, peerID string,
+	date int64,
+	message, groupBody string,
+	minDelay, maxDelay int32,
+	priority Priority,
+) error {
+	return msgDB.addMessage(selfID, peerID, date, true, message, false,
+		minDelay, maxDelay, priority, groupBody)
+}
+
+func (msgDB *MsgDB) addMessage(
+	selfID, peerID string,
+	date int64,
+	sent bool,
+	message string,
+	sign bool,
+	minDelay, maxDelay int32,
+	priority Priority,
+	groupBody string,
 ) error {
 	if err := identity.IsMapped(selfID); err != nil {
 		return log.Error(err)
@@ -57,16 +106,58 @@ func (msgDB *MsgDB) AddMessage(
 		from = peerID
 		to = selfID
 	}
-	parts := strings.SplitN(message, "\n", 2)
+	opts, body := mime.SplitOptions(message) // subject follows option lines
+	parts := strings.SplitN(body, "\n", 2)
 	subject := parts[0]
 	_, err = msgDB.addMsgQuery.Exec(self, peer, d, d, 0, from, to, date,
-		subject, message, s, minDelay, maxDelay)
+		subject, message, s, minDelay, maxDelay, priority, opts.ContentType,
+		groupBody)
 	if err != nil {
 		return log.Error(err)
 	}
+	// a received message counts as interaction with the peer
+	if !sent {
+		_, err = msgDB.setContactLastSeenQuery.Exec(date, peer, date)
+		if err != nil {
+			return log.Error(err)
+		}
+	}
 	return nil
 }
 
+// sealBurn encrypts the burn-after-reading message msg with a fresh random
+// key. The message is stored sealed, so that wiping the key (see BurnMessage)
+// is enough to destroy it, even if copies of the sealed message remain in the
+// database file.
+func sealBurn(msg string) (sealed, key string, err error) {
+	k := make([]byte, 32)
+	if _, err := io.ReadFull(cipher.RandReader, k); err != nil {
+		return "", "", log.Error(err)
+	}
+	enc, err := aes256.GCMEncrypt(k, []byte(msg), nil, cipher.RandReader)
+	if err != nil {
+		return "", "", log.Error(err)
+	}
+	return base64.Encode(enc), base64.Encode(k), nil
+}
+
+// openBurn decrypts the sealed burn-after-reading message with key.
+func openBurn(sealed, key string) (string, error) {
+	k, err := base64.Decode(key)
+	if err != nil {
+		return "", log.Error(err)
+	}
+	enc, err := base64.Decode(sealed)
+	if err != nil {
+		return "", log.Error(err)
+	}
+	msg, err := aes256.GCMDecrypt(k, enc, nil)
+	if err != nil {
+		return "", log.Error(err)
+	}
+	return string(msg), nil
+}
+
 // GetMessage returns the message from user myID with the given msgNum.
 func (msgDB *MsgDB) GetMessage(
 	myID string,
@@ -79,9 +170,10 @@ func (msgDB *MsgDB) GetMessage(
 		self      int64
 		peer      int64
 		direction int64
+		burnKey   string
 	)
 	err = msgDB.getMsgQuery.QueryRow(msgNum).Scan(&self, &peer, &direction,
-		&date, &msg)
+		&date, &msg, &burnKey)
 	if err != nil {
 		return "", "", "", 0, err
 	}
@@ -93,6 +185,12 @@ func (msgDB *MsgDB) GetMessage(
 	if myID != selfID {
 		return "", "", "", 0, log.Error("msgdb: unknown message")
 	}
+	if burnKey != "" {
+		msg, err = openBurn(msg, burnKey)
+		if err != nil {
+			return "", "", "", 0, err
+		}
+	}
 	var peerID string
 	err = msgDB.getContactMappedQuery.QueryRow(self, peer).Scan(&peerID)
 	if err != nil {
@@ -173,16 +271,159 @@ func (msgDB *MsgDB) DelMessage(myID string, msgNum int64) error {
 	return nil
 }
 
+// GetMessageSignature returns the sender and the permanent signature of the
+// received message from user myID with the given msgNum. The signature is
+// empty, if the message was not signed.
+func (msgDB *MsgDB) GetMessageSignature(myID string, msgNum int64) (
+	senderID, signature string,
+	err error,
+) {
+	if err := identity.IsMapped(myID); err != nil {
+		return "", "", log.Error(err)
+	}
+	var self int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&self); err != nil {
+		return "", "", log.Error(err)
+	}
+	var peer int64
+	err = msgDB.getMsgSignatureQuery.QueryRow(msgNum, self).Scan(&peer,
+		&signature)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", "", log.Errorf("msgdb: unknown received msgnum %d for user ID %s",
+			msgNum, myID)
+	case err != nil:
+		return "", "", log.Error(err)
+	}
+	err = msgDB.getContactMappedQuery.QueryRow(self, peer).Scan(&senderID)
+	if err != nil {
+		return "", "", log.Error(err)
+	}
+	return senderID, signature, nil
+}
+
+// GetMessageNymAddress returns the nym address the received message from
+// user myID with the given msgNum was sent to. It is empty, if the nym address
+// is unknown (e.g., the message was not received via a nym address stored in
+// msgDB) or if the message is not a received one.
+func (msgDB *MsgDB) GetMessageNymAddress(myID string, msgNum int64) (
+	string,
+	error,
+) {
+	if err := identity.IsMapped(myID); err != nil {
+		return "", log.Error(err)
+	}
+	var self int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&self); err != nil {
+		return "", log.Error(err)
+	}
+	var nymAddress string
+	err := msgDB.getMsgNymAddressQuery.QueryRow(msgNum, self).Scan(&nymAddress)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", nil
+	case err != nil:
+		return "", log.Error(err)
+	}
+	return nymAddress, nil
+}
+
+// GetMessageGroupBody returns the body of the group message msgNum sent by
+// myID (see AddGroupMessage). It returns an empty string for other messages.
+func (msgDB *MsgDB) GetMessageGroupBody(myID string, msgNum int64) (
+	string,
+	error,
+) {
+	if err := identity.IsMapped(myID); err != nil {
+		return "", log.Error(err)
+	}
+	var self int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&self); err != nil {
+		return "", log.Error(err)
+	}
+	var groupBody string
+	err := msgDB.getMsgGroupBodyQuery.QueryRow(msgNum, self).Scan(&groupBody)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", nil
+	case err != nil:
+		return "", log.Error(err)
+	}
+	return groupBody, nil
+}
+
+// BurnMessage deletes the received message from user myID with the given
+// msgNum, if it is a message which has to be deleted after reading it once.
+// The deleted content (including the key of the sealed message) is
+// overwritten in the database file.
+// It returns true, if the message was deleted.
+func (msgDB *MsgDB) BurnMessage(myID string, msgNum int64) (bool, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return false, log.Error(err)
+	}
+	var self int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&self); err != nil {
+		return false, log.Error(err)
+	}
+	// secure_delete is a setting of the connection: use a dedicated one and
+	// reset it afterwards, before the connection is returned to the pool
+	ctx := context.Background()
+	conn, err := msgDB.encDB.Conn(ctx)
+	if err != nil {
+		return false, log.Error(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "PRAGMA secure_delete = ON;"); err != nil {
+		return false, log.Error(err)
+	}
+	defer conn.ExecContext(ctx, "PRAGMA secure_delete = OFF;")
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, log.Error(err)
+	}
+	res, err := tx.Stmt(msgDB.burnMsgQuery).Exec(msgNum, self)
+	if err != nil {
+		tx.Rollback()
+		return false, log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, log.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return false, log.Error(err)
+	}
+	return n > 0, nil
+}
+
+// DelExpiredMessages deletes all received messages which expired before or
+// at time now and returns the number of deleted messages.
+func (msgDB *MsgDB) DelExpiredMessages(now int64) (int64, error) {
+	res, err := msgDB.delExpiredMsgsQuery.Exec(now)
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	return n, nil
+}
+
 // MsgID is the info type that is returned by GetMsgIDs.
 type MsgID struct {
-	MsgID    int64  // the message ID
-	From     string // sender
-	To       string // recipient
-	Incoming bool   // an incoming message, outgoing otherwise
-	Sent     bool   // outgoing message has been sent
-	Date     int64
-	Subject  string
-	Read     bool
+	MsgID       int64  // the message ID
+	From        string // sender
+	To          string // recipient
+	Incoming    bool   // an incoming message, outgoing otherwise
+	Sent        bool   // outgoing message has been sent
+	Date        int64
+	Subject     string
+	Read        bool
+	ContentType string // content type of the message body
+	NymAddress  string // nym address a received message was sent to ('': unknown)
 }
 
 // GetMsgIDs returns all message IDs (sqlite row IDs) for the user ID myID.
@@ -202,16 +443,19 @@ func (msgDB *MsgDB) GetMsgIDs(myID string) ([]*MsgID, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var (
-			id      int64
-			from    string
-			to      string
-			d       int64
-			s       int64
-			date    int64
-			subject string
-			r       int64
+			id          int64
+			from        string
+			to          string
+			d           int64
+			s           int64
+			date        int64
+			subject     string
+			r           int64
+			contentType string
+			nymAddress  string
 		)
-		err = rows.Scan(&id, &from, &to, &d, &s, &date, &subject, &r)
+		err = rows.Scan(&id, &from, &to, &d, &s, &date, &subject, &r,
+			&contentType, &nymAddress)
 		if err != nil {
 			return nil, log.Error(err)
 		}
@@ -229,15 +473,20 @@ func (msgDB *MsgDB) GetMsgIDs(myID string) ([]*MsgID, error) {
 		if r > 0 {
 			read = true
 		}
+		if contentType == "" {
+			contentType = mime.DefaultContentType // message of older msgDB
+		}
 		msgIDs = append(msgIDs, &MsgID{
-			MsgID:    id,
-			From:     from,
-			To:       to,
-			Incoming: incoming,
-			Sent:     sent,
-			Date:     date,
-			Subject:  subject,
-			Read:     read,
+			MsgID:       id,
+			From:        from,
+			To:          to,
+			Incoming:    incoming,
+			Sent:        sent,
+			Date:        date,
+			Subject:     subject,
+			Read:        read,
+			ContentType: contentType,
+			NymAddress:  nymAddress,
 		})
 	}
 	if err := rows.Err(); err != nil {
@@ -246,8 +495,60 @@ func (msgDB *MsgDB) GetMsgIDs(myID string) ([]*MsgID, error) {
 	return msgIDs, nil
 }
 
-// GetUndeliveredMessage returns the oldest undelivered message for myID from
-// msgDB.
+// QueuedMsg describes an outgoing message which has not been sent yet.
+type QueuedMsg struct {
+	MsgID     int64  // the message ID
+	To        string // recipient
+	Size      int64  // size of the message in bytes (unencrypted)
+	MinDelay  int32  // minimum delay of message
+	MaxDelay  int32  // maximum delay of message
+	Encrypted bool   // message has been encrypted and waits in the outqueue
+	Failed    bool   // delivery failed permanently (see SetFailedOutQueue)
+}
+
+// GetQueuedMsgs returns all outgoing messages of myID which have not been
+// sent yet, the oldest first.
+func (msgDB *MsgDB) GetQueuedMsgs(myID string) ([]*QueuedMsg, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return nil, log.Error(err)
+	}
+	var uid int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&uid); err != nil {
+		return nil, log.Error(err)
+	}
+	rows, err := msgDB.getQueuedMsgsQuery.Query(uid)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var msgs []*QueuedMsg
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			qm     QueuedMsg
+			toSend int64
+			failed int64
+		)
+		err := rows.Scan(&qm.MsgID, &qm.To, &qm.Size, &qm.MinDelay,
+			&qm.MaxDelay, &toSend, &failed)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		if toSend == 0 {
+			qm.Encrypted = true
+		}
+		if failed > 0 {
+			qm.Failed = true
+		}
+		msgs = append(msgs, &qm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return msgs, nil
+}
+
+// GetUndeliveredMessage returns the oldest undelivered message with the
+// highest priority for myID from msgDB.
 func (msgDB *MsgDB) GetUndeliveredMessage(myID string) (
 	msgNum int64,
 	contactID string,
@@ -283,6 +584,201 @@ func (msgDB *MsgDB) GetUndeliveredMessage(myID string) (
 	return
 }
 
+// GetUndeliveredMessageTo returns the oldest undelivered message from myID to
+// contactID. If there is no such message, msgNum is 0.
+func (msgDB *MsgDB) GetUndeliveredMessageTo(myID, contactID string) (
+	msgNum int64,
+	msg []byte,
+	sign bool,
+	err error,
+) {
+	if err := identity.IsMapped(myID); err != nil {
+		return 0, nil, false, log.Error(err)
+	}
+	if err := identity.IsMapped(contactID); err != nil {
+		return 0, nil, false, log.Error(err)
+	}
+	var mID int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return 0, nil, false, log.Error(err)
+	}
+	var cID int64
+	err = msgDB.getContactUIDQuery.QueryRow(mID, contactID).Scan(&cID)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil, false, nil
+	case err != nil:
+		return 0, nil, false, log.Error(err)
+	}
+	var s int64
+	err = msgDB.getUndeliveredMsgToQuery.QueryRow(mID, cID).Scan(&msgNum, &msg,
+		&s)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil, false, nil
+	case err != nil:
+		return 0, nil, false, log.Error(err)
+	}
+	if s > 0 {
+		sign = true
+	}
+	return
+}
+
+// SetMessageSent marks the undelivered message msgNum as sent at the given
+// date, without going through the outqueue (used for direct transports).
+func (msgDB *MsgDB) SetMessageSent(msgNum, date int64) error {
+	tx, err := msgDB.encDB.Begin()
+	if err != nil {
+		return log.Error(err)
+	}
+	if _, err := tx.Stmt(msgDB.updateDeliveryMsgQuery).Exec(0, msgNum); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if _, err := tx.Stmt(msgDB.updateMsgDateQuery).Exec(date, msgNum); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	// a sent message counts as interaction with the peer
+	_, err = tx.Stmt(msgDB.setMsgPeerLastSeenQuery).Exec(date, msgNum, date)
+	if err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	return nil
+}
+
+// ExportedMessage is a message with all its metadata, as exported by
+// ExportMessages and imported by ImportMessage.
+type ExportedMessage struct {
+	PeerID         string   // mapped ID of peer
+	PeerUnmappedID string   // unmapped ID of peer
+	Sent           bool     // sent (true) or received message (false)
+	Delivered      bool     // sent message has been delivered to mix
+	From           string   // sender nym
+	To             string   // recipient nym(s)
+	Date           int64    // date of message (Unix time)
+	Message        string   // message body (with subject line)
+	Sign           bool     // permanent signature requested
+	MinDelay       int32    // minimum delay of message
+	MaxDelay       int32    // maximum delay of message
+	Read           bool     // message has been read
+	Star           bool     // message is starred
+	Expire         int64    // received message is deleted at this time (0: never)
+	Burn           bool     // received message is deleted after reading it
+	Priority       Priority // priority of message
+	Signature      string   // permanent signature of received message
+	ContentType    string   // content type of message body
+}
+
+// ExportMessages returns all messages of myID with all their metadata,
+// oldest first. Burn-after-reading messages are not exported, they must not
+// be persisted outside of msgDB.
+func (msgDB *MsgDB) ExportMessages(myID string) ([]*ExportedMessage, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return nil, log.Error(err)
+	}
+	var self int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&self); err != nil {
+		return nil, log.Error(err)
+	}
+	rows, err := msgDB.exportMsgsQuery.Query(self)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	defer rows.Close()
+	var msgs []*ExportedMessage
+	for rows.Next() {
+		var (
+			m                               ExportedMessage
+			d, sent, sign, read, star, burn int64
+		)
+		err := rows.Scan(&m.PeerID, &m.PeerUnmappedID, &d, &sent, &m.From,
+			&m.To, &m.Date, &m.Message, &sign, &m.MinDelay, &m.MaxDelay, &read,
+			&star, &m.Expire, &burn, &m.Priority, &m.Signature, &m.ContentType)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		m.Sent = d > 0
+		m.Delivered = sent > 0
+		m.Sign = sign > 0
+		m.Read = read > 0
+		m.Star = star > 0
+		m.Burn = burn > 0
+		msgs = append(msgs, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return msgs, nil
+}
+
+// ImportMessage adds the exported message m to the messages of myID. The peer
+// of the message must be a contact of myID already. Sent messages are never
+// queued for sending again. If an identical message exists already (same peer,
+// direction, date, and body), nothing is added and imported is false.
+func (msgDB *MsgDB) ImportMessage(
+	myID string,
+	m *ExportedMessage,
+) (imported bool, err error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return false, log.Error(err)
+	}
+	if err := identity.IsMapped(m.PeerID); err != nil {
+		return false, log.Error(err)
+	}
+	var self int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&self); err != nil {
+		return false, log.Error(err)
+	}
+	var peer int64
+	err = msgDB.getContactUIDQuery.QueryRow(self, m.PeerID).Scan(&peer)
+	if err != nil {
+		return false, log.Error(err)
+	}
+	b := func(v bool) int64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	var n int64
+	err = msgDB.hasMsgQuery.QueryRow(self, peer, b(m.Sent), m.Date,
+		m.Message).Scan(&n)
+	if err != nil {
+		return false, log.Error(err)
+	}
+	if n > 0 {
+		return false, nil
+	}
+	_, body := mime.SplitOptions(m.Message) // subject follows option lines
+	parts := strings.SplitN(body, "\n", 2)
+	subject := parts[0]
+	message := m.Message
+	var burnKey string
+	if m.Burn {
+		// burn-after-reading messages are stored sealed (see RemoveInQueue)
+		message, burnKey, err = sealBurn(m.Message)
+		if err != nil {
+			return false, err
+		}
+		subject = ""
+	}
+	_, err = msgDB.importMsgQuery.Exec(self, peer, b(m.Sent), b(m.Delivered),
+		m.From, m.To, m.Date, subject, message, b(m.Sign), m.MinDelay,
+		m.MaxDelay, b(m.Read), b(m.Star), m.Expire, b(m.Burn), m.Priority,
+		m.Signature, m.ContentType, burnKey)
+	if err != nil {
+		return false, log.Error(err)
+	}
+	return true, nil
+}
+
 // numberOfMessages returns the number of messages in msgDB.
 func (msgDB *MsgDB) numberOfMessages() (int64, error) {
 	var num int64
@@ -40,12 +40,12 @@ func TestMessages(t *testing.T) {
 	}
 	now := times.Now()
 	err = msgDB.AddMessage(a, b, now, true, "ping", false,
-		def.MinDelay, def.MaxDelay)
+		def.MinDelay, def.MaxDelay, NormalPriority)
 	if err != nil {
 		t.Fatal(err)
 	}
 	err = msgDB.AddMessage(a, b, now, false, "pong", false,
-		def.MinDelay, def.MaxDelay)
+		def.MinDelay, def.MaxDelay, NormalPriority)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -120,3 +120,341 @@ func TestMessages(t *testing.T) {
 		t.Fatal("should fail")
 	}
 }
+
+func TestUndeliveredMessageTo(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	c := "carol@mute.berlin"
+	if err := msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	err = msgDB.AddMessage(a, b, times.Now(), true, "ping", true,
+		def.MinDelay, def.MaxDelay, NormalPriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unknown contact
+	msgNum, _, _, err := msgDB.GetUndeliveredMessageTo(a, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgNum != 0 {
+		t.Errorf("msgNum != 0 == %d", msgNum)
+	}
+	msgNum, msg, sign, err := msgDB.GetUndeliveredMessageTo(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgNum != 1 {
+		t.Fatalf("msgNum != 1 == %d", msgNum)
+	}
+	if string(msg) != "ping" {
+		t.Error("msg != \"ping\"")
+	}
+	if !sign {
+		t.Error("!sign")
+	}
+	if err := msgDB.SetMessageSent(msgNum, 1500000000); err != nil {
+		t.Fatal(err)
+	}
+	msgNum, _, _, err = msgDB.GetUndeliveredMessageTo(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgNum != 0 {
+		t.Errorf("msgNum != 0 == %d", msgNum)
+	}
+	_, _, _, date, err := msgDB.GetMessage(a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if date != 1500000000 {
+		t.Errorf("date != 1500000000 == %d", date)
+	}
+}
+
+func TestGroupMessage(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	if err := msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	now := times.Now()
+	err = msgDB.AddMessage(a, b, now, true, "single", false,
+		def.MinDelay, def.MaxDelay, NormalPriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = msgDB.AddGroupMessage(a, b, now, "group", "groupbody",
+		def.MinDelay, def.MaxDelay, NormalPriority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for msgNum, body := range map[int64]string{1: "", 2: "groupbody"} {
+		groupBody, err := msgDB.GetMessageGroupBody(a, msgNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if groupBody != body {
+			t.Errorf("message %d: group body %q != %q", msgNum, groupBody,
+				body)
+		}
+	}
+	// the group message is sent like other messages
+	msgNum, _, msg, sign, _, _, err := msgDB.GetUndeliveredMessage(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgNum != 1 || string(msg) != "single" || sign {
+		t.Errorf("unexpected undelivered message %d: %q", msgNum, msg)
+	}
+}
+
+func TestDelExpiredMessages(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	if err := msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	for _, expire := range []int64{100, 200, 0} {
+		if err := msgDB.AddInQueue(a, b, 50, "enc"); err != nil {
+			t.Fatal(err)
+		}
+		iqIdx, _, _, _, _, err := msgDB.GetInQueue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := msgDB.RemoveInQueue(iqIdx, "msg", b, "", expire, false, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := msgDB.DelExpiredMessages(150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("n != 1 == %d", n)
+	}
+	num, err := msgDB.numberOfMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 2 {
+		t.Errorf("num != 2 == %d", num)
+	}
+}
+
+func TestBurnMessage(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	if err := msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	for _, burn := range []bool{true, false} {
+		if err := msgDB.AddInQueue(a, b, 50, "enc"); err != nil {
+			t.Fatal(err)
+		}
+		iqIdx, _, _, _, _, err := msgDB.GetInQueue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := msgDB.RemoveInQueue(iqIdx, "msg", b, "", 0, burn, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	burned, err := msgDB.BurnMessage(a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !burned {
+		t.Error("message 1 should be burned")
+	}
+	burned, err = msgDB.BurnMessage(a, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if burned {
+		t.Error("message 2 should not be burned")
+	}
+	if _, _, _, _, err := msgDB.GetMessage(a, 1); err == nil {
+		t.Error("burned message should not exist")
+	}
+	if _, _, _, _, err := msgDB.GetMessage(a, 2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMessageSignature(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	if err := msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "Bob", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	for _, sig := range []string{"signature", ""} {
+		if err := msgDB.AddInQueue(a, b, 50, "enc"); err != nil {
+			t.Fatal(err)
+		}
+		iqIdx, _, _, _, _, err := msgDB.GetInQueue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = msgDB.RemoveInQueue(iqIdx, "Mute-TTL: 60\nsubject\nbody", b, sig,
+			0, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	senderID, sig, err := msgDB.GetMessageSignature(a, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if senderID != b || sig != "signature" {
+		t.Errorf("GetMessageSignature() == %s, %s", senderID, sig)
+	}
+	_, sig, err = msgDB.GetMessageSignature(a, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig != "" {
+		t.Error("message 2 should not have a signature")
+	}
+	if _, _, err := msgDB.GetMessageSignature(a, 3); err == nil {
+		t.Error("unknown message should fail")
+	}
+	// subject follows option lines
+	ids, err := msgDB.GetMsgIDs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0].Subject != "subject" {
+		t.Error("options should not be part of subject")
+	}
+}
+
+func TestExportImportMessages(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	c := "carol@mute.berlin"
+	if err := msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddNym(c, c, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddMessage(a, b, 10, true, "sent", true, 1, 2, HighPriority); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddMessage(a, b, 20, false, "received", false, 0, 0, NormalPriority); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.ReadMessage(2); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := msgDB.ExportMessages(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("len(msgs) == %d != 2", len(msgs))
+	}
+	if !msgs[0].Sent || msgs[0].Delivered || !msgs[0].Sign ||
+		msgs[0].Priority != HighPriority || msgs[0].Date != 10 {
+		t.Error("sent message metadata not exported")
+	}
+	if msgs[1].Sent || !msgs[1].Read || msgs[1].PeerUnmappedID != b {
+		t.Error("received message metadata not exported")
+	}
+	// import into another nym, peer must be a contact
+	if _, err := msgDB.ImportMessage(c, msgs[0]); err == nil {
+		t.Error("import without contact should fail")
+	}
+	if err := msgDB.AddContact(c, b, b, "", GrayList); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range msgs {
+		imported, err := msgDB.ImportMessage(c, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !imported {
+			t.Error("message should be imported")
+		}
+	}
+	// importing again does not duplicate messages
+	imported, err := msgDB.ImportMessage(c, msgs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported {
+		t.Error("duplicate message should not be imported")
+	}
+	ids, err := msgDB.GetMsgIDs(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || !ids[1].Read || ids[0].Read || ids[1].Date != 20 {
+		t.Error("imported messages differ")
+	}
+	// imported sent message is not queued again
+	msgNum, _, _, _, _, _, err := msgDB.GetUndeliveredMessage(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgNum != 0 {
+		t.Error("imported message should not be queued for sending")
+	}
+}
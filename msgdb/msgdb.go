@@ -12,13 +12,14 @@ import (
 )
 
 // Version is the current msgdb version.
-const Version = "1"
+const Version = "19"
 
 // Entries in KeyValueTable.
 const (
 	DBVersion = "Version"   // version string of msgdb
 	WalletKey = "WalletKey" // 64-byte private Ed25519 wallet key, base64 encoded
 	ActiveUID = "ActiveUID" // the active UID
+	Aliases   = "Aliases"   // command aliases, JSON encoded map
 )
 
 const (
@@ -49,6 +50,9 @@ CREATE TABLE Contacts (
   UnmappedID TEXT NOT NULL,
   FullName   TEXT,
   Blocked    INTEGER,          -- 0: white list, 1: gray list, 2: black list
+  LastSeen   INTEGER NOT NULL DEFAULT 0, -- time of the last exchanged message
+  Notes      TEXT    NOT NULL DEFAULT '', -- freeform notes (local)
+  Alias      TEXT    NOT NULL DEFAULT '', -- short name of contact (local, '': none)
   UNIQUE     (MyID, MappedID), -- the combination of nym and contact must be unique
   FOREIGN KEY(MyID) REFERENCES Nyms(UID) ON DELETE CASCADE
 );`
@@ -96,6 +100,14 @@ CREATE TABLE Messages (
   MaxDelay    INTEGER NOT NULL, -- maximum delay of message
   Read        INTEGER NOT NULL, -- 0: message is new, 1: message read
   Star        INTEGER NOT NULL,
+  Expire      INTEGER NOT NULL DEFAULT 0, -- received message is deleted at this time (0: never)
+  Burn        INTEGER NOT NULL DEFAULT 0, -- 1: received message is deleted after reading it once
+  Priority    INTEGER NOT NULL DEFAULT 0, -- -1: low, 0: normal, 1: high (sent first)
+  Signature   TEXT    NOT NULL DEFAULT '', -- permanent signature of received message (base64)
+  ContentType TEXT    NOT NULL DEFAULT '', -- content type of message body ('': text/plain)
+  BurnKey     TEXT    NOT NULL DEFAULT '', -- key of sealed burn-after-reading message (base64)
+  NymAddress  TEXT    NOT NULL DEFAULT '', -- nym address a received message was sent to ('': unknown)
+  GroupBody   TEXT    NOT NULL DEFAULT '', -- body of sent group message encrypted once for all members (JSON)
   FOREIGN KEY(Self) REFERENCES Nyms(UID) ON DELETE CASCADE,
   FOREIGN KEY(Peer) REFERENCES Contacts(UID)
 );`
@@ -130,18 +142,22 @@ CREATE TABLE Chunks (
   MinDelay   INTEGER NOT NULL, -- minimum delay of message
   MaxDelay   INTEGER NOT NULL, -- maximum delay of message
   Envelope   INTEGER NOT NULL, -- 0: basic encrypted message, 1: with envelope and ready to send
-  Resend     INTEGER NOT NULL, -- 0: process message normally, 1: message needs resend
+  Resend     INTEGER NOT NULL, -- 0: process message normally, 1: message needs resend,
+                               -- 2: delivery failed permanently
+  Attempts   INTEGER NOT NULL DEFAULT 0, -- number of failed delivery attempts
+  Retry      INTEGER NOT NULL DEFAULT 0, -- time of next automatic resend (0: not scheduled)
   FOREIGN KEY(Self) REFERENCES Nyms(UID) ON DELETE CASCADE
   FOREIGN KEY(MsgID) REFERENCES Messages(MsgID) ON DELETE CASCADE
 );`
 	createQueryInQueue = `
 CREATE TABLE InQueue (
-  IQIdx     INTEGER PRIMARY KEY,
-  MyID      INTEGER NOT NULL, -- the user ID of this account
-  ContactID INTEGER NOT NULL, -- optional contact ID of this account (0 == undefined)
-  Date      INTEGER NOT NULL, -- time when the message was received from muteaccd
-  Msg       TEXT    NOT NULL, -- encrypted message in the inqueue
-  Envelope  INTEGER NOT NULL, -- 0: basic encrypted message, 1: with envelope (from mix)
+  IQIdx      INTEGER PRIMARY KEY,
+  MyID       INTEGER NOT NULL, -- the user ID of this account
+  ContactID  INTEGER NOT NULL, -- optional contact ID of this account (0 == undefined)
+  Date       INTEGER NOT NULL, -- time when the message was received from muteaccd
+  Msg        TEXT    NOT NULL, -- encrypted message in the inqueue
+  Envelope   INTEGER NOT NULL, -- 0: basic encrypted message, 1: with envelope (from mix)
+  NymAddress TEXT    NOT NULL DEFAULT '', -- nym address the message was sent to ('': unknown)
   FOREIGN KEY(MyID) REFERENCES Nyms(UID) ON DELETE CASCADE
 );`
 	createMessageIDCache = `
@@ -151,10 +167,44 @@ CREATE TABLE MessageIDCache(
   ContactID INTEGER NOT NULL, -- optional contact ID of this account (0 == undefined)
   MessageID TEXT    NOT NULL, -- server messageID (from muteaccd)
   FOREIGN KEY(MyID) REFERENCES Nyms(UID) ON DELETE CASCADE
+);`
+	createQueryReceived = `
+CREATE TABLE Received(
+  Entry INTEGER PRIMARY KEY,
+  MyID  INTEGER NOT NULL, -- the user ID the message was received for
+  Hash  TEXT    NOT NULL, -- hash of the received message (from any account)
+  Date  INTEGER NOT NULL, -- receive time
+  UNIQUE(MyID, Hash),
+  FOREIGN KEY(MyID) REFERENCES Nyms(UID) ON DELETE CASCADE
+);`
+	createQueryNymAddresses = `
+CREATE TABLE NymAddresses (
+  Entry       INTEGER PRIMARY KEY,
+  MyID        INTEGER NOT NULL, -- the user ID the nym address receives messages for
+  MixAddress  TEXT    NOT NULL, -- mix address of the nym address
+  NymAddress  TEXT    NOT NULL, -- the nym address (base64)
+  Expire      INTEGER NOT NULL, -- time when the nym address expires
+  ReceiverKey TEXT    NOT NULL DEFAULT '', -- identifies the nym address in received messages
+  Retire      INTEGER NOT NULL DEFAULT 0, -- no messages accepted after this time (0: active)
+  Messages    INTEGER NOT NULL DEFAULT 0, -- number of messages received via the nym address
+  LastMessage INTEGER NOT NULL DEFAULT 0, -- time of the last received message (0: never)
+  Peer        TEXT    NOT NULL DEFAULT '', -- peer the nym address was given to for replies ('': published)
+  FOREIGN KEY(MyID) REFERENCES Nyms(UID) ON DELETE CASCADE
+);`
+	createQueryGroupMembers = `
+CREATE TABLE GroupMembers (
+  Entry     INTEGER PRIMARY KEY,
+  MyID      INTEGER NOT NULL,          -- the user ID owning the group
+  Name      TEXT    NOT NULL,          -- name of the group
+  ContactID INTEGER NOT NULL,          -- the member (foreign key to Contacts table)
+  UNIQUE    (MyID, Name, ContactID), -- a contact can be member of a group only once
+  FOREIGN KEY(MyID) REFERENCES Nyms(UID) ON DELETE CASCADE,
+  FOREIGN KEY(ContactID) REFERENCES Contacts(UID) ON DELETE CASCADE
 );`
 	updateValueQuery            = "UPDATE KeyValueStore SET ValueEntry=? WHERE KeyEntry=?;"
 	insertValueQuery            = "INSERT INTO KeyValueStore (KeyEntry, ValueEntry) VALUES (?, ?);"
 	getValueQuery               = "SELECT ValueEntry FROM KeyValueStore WHERE KeyEntry=?;"
+	delValueQuery               = "DELETE FROM KeyValueStore WHERE KeyEntry=?;"
 	updateNymQuery              = "UPDATE Nyms SET UnmappedID=?, FullName=? WHERE MappedID=?;"
 	insertNymQuery              = "INSERT INTO Nyms (MappedID, UnmappedID, FullName) VALUES (?, ?, ?);"
 	getNymQuery                 = "SELECT UnmappedID, FullName from Nyms WHERE MappedID=?;"
@@ -165,7 +215,15 @@ CREATE TABLE MessageIDCache(
 	getContactQuery             = "SELECT UnmappedID, FullName, Blocked FROM Contacts WHERE MyID=? AND MappedID=?;"
 	getContactMappedQuery       = "SELECT MappedID FROM Contacts WHERE MyID=? AND UID=?;"
 	getContactUIDQuery          = "SELECT UID FROM Contacts WHERE MyID=? AND MappedID=?;"
-	getContactsQuery            = "SELECT UnmappedID, FullName FROM Contacts WHERE MyID=? AND Blocked=?;"
+	getContactsQuery            = "SELECT MappedID, UnmappedID, FullName, LastSeen FROM Contacts WHERE MyID=? AND Blocked=?;"
+	setContactLastSeenQuery     = "UPDATE Contacts SET LastSeen=? WHERE UID=? AND LastSeen<?;"
+	setMsgPeerLastSeenQuery     = "UPDATE Contacts SET LastSeen=? WHERE UID=(SELECT Peer FROM Messages WHERE MsgID=?) AND LastSeen<?;"
+	getContactNotesQuery        = "SELECT Notes FROM Contacts WHERE MyID=? AND MappedID=?;"
+	setContactNotesQuery        = "UPDATE Contacts SET Notes=? WHERE MyID=? AND MappedID=?;"
+	getContactAliasQuery        = "SELECT Alias FROM Contacts WHERE MyID=? AND MappedID=?;"
+	setContactAliasQuery        = "UPDATE Contacts SET Alias=? WHERE MyID=? AND MappedID=?;"
+	getContactByAliasQuery      = "SELECT MappedID FROM Contacts WHERE MyID=? AND Alias=?;"
+	getContactStatsQuery        = "SELECT Contacts.LastSeen, COUNT(CASE WHEN Messages.Direction=1 THEN 1 END), COUNT(CASE WHEN Messages.Direction=0 THEN 1 END) FROM Contacts LEFT JOIN Messages ON Messages.Peer=Contacts.UID AND Messages.Self=Contacts.MyID WHERE Contacts.MyID=? AND Contacts.MappedID=? GROUP BY Contacts.UID;"
 	updateContactQuery          = "UPDATE Contacts SET UnmappedID=?, FullName=?, Blocked=? WHERE MyID=? AND MappedID=?;"
 	insertContactQuery          = "INSERT INTO Contacts (MyID, MappedID, UnmappedID, FullName, Blocked) VALUES (?, ?, ?, ?, ?);"
 	delContactQuery             = "UPDATE Contacts SET Blocked=1 WHERE MyID=? AND MappedID=?;"
@@ -175,12 +233,21 @@ CREATE TABLE MessageIDCache(
 	getAccountQuery             = "SELECT PrivKey, Server, Secret, MinDelay, MaxDelay, LastMsgTime FROM Accounts WHERE MyID=? AND ContactID=?;"
 	getAccountsQuery            = "SELECT ContactID FROM Accounts WHERE MyID=?;"
 	getAccountTimeQuery         = "SELECT LoadTime FROM Accounts WHERE MyID=? AND ContactID=?;"
-	addMsgQuery                 = "INSERT INTO Messages (Self, Peer, Direction, ToSend, Sent, \"From\", \"To\", Date, Subject, Message, Sign, MinDelay, MaxDelay, Read, Star) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0);"
+	addMsgQuery                 = "INSERT INTO Messages (Self, Peer, Direction, ToSend, Sent, \"From\", \"To\", Date, Subject, Message, Sign, MinDelay, MaxDelay, Read, Star, Priority, ContentType, GroupBody) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, ?, ?, ?);"
 	delMsgQuery                 = "DELETE FROM Messages WHERE MsgID=? AND Self=?;"
-	getMsgQuery                 = "SELECT Self, Peer, Direction, Date, Message FROM Messages WHERE MsgID=?;"
+	setMsgOptionsQuery          = "UPDATE Messages SET Expire=?, Burn=?, Signature=?, BurnKey=?, NymAddress=? WHERE MsgID=?;"
+	burnMsgQuery                = "DELETE FROM Messages WHERE MsgID=? AND Self=? AND Direction=0 AND Burn=1;"
+	delExpiredMsgsQuery         = "DELETE FROM Messages WHERE Direction=0 AND Expire>0 AND Expire<=?;"
+	getMsgQuery                 = "SELECT Self, Peer, Direction, Date, Message, BurnKey FROM Messages WHERE MsgID=?;"
+	getMsgSignatureQuery        = "SELECT Peer, Signature FROM Messages WHERE MsgID=? AND Self=? AND Direction=0;"
+	getMsgNymAddressQuery       = "SELECT NymAddress FROM Messages WHERE MsgID=? AND Self=? AND Direction=0;"
+	getMsgGroupBodyQuery        = "SELECT GroupBody FROM Messages WHERE MsgID=? AND Self=? AND Direction=1;"
+	getMsgStatusQuery           = "SELECT Direction, Sent FROM Messages WHERE MsgID=? AND Self=?;"
 	readMsgQuery                = "UPDATE Messages SET Read=1 WHERE MsgID=?;"
-	getMsgsQuery                = "SELECT MsgID, \"From\", \"To\", Direction, Sent, Date, Subject, Read FROM Messages WHERE Self=?;"
-	getUndeliveredMsgQuery      = "SELECT MsgID, Peer, Message, Sign, MinDelay, MaxDelay FROM Messages WHERE Self=? AND ToSend=1 ORDER BY MsgID ASC LIMIT 1;"
+	getMsgsQuery                = "SELECT MsgID, \"From\", \"To\", Direction, Sent, Date, Subject, Read, ContentType, NymAddress FROM Messages WHERE Self=?;"
+	getQueuedMsgsQuery          = "SELECT MsgID, \"To\", length(CAST(Message AS BLOB)), MinDelay, MaxDelay, ToSend, EXISTS (SELECT 1 FROM OutQueue WHERE OutQueue.MsgID=Messages.MsgID AND Resend=2) FROM Messages WHERE Self=? AND Direction=1 AND Sent=0 ORDER BY MsgID ASC;"
+	getUndeliveredMsgQuery      = "SELECT MsgID, Peer, Message, Sign, MinDelay, MaxDelay FROM Messages WHERE Self=? AND ToSend=1 ORDER BY Priority DESC, MsgID ASC LIMIT 1;"
+	getUndeliveredMsgToQuery    = "SELECT MsgID, Message, Sign FROM Messages WHERE Self=? AND Peer=? AND ToSend=1 ORDER BY MsgID ASC LIMIT 1;"
 	updateDeliveryMsgQuery      = "UPDATE Messages SET ToSend=? WHERE MsgID=?;"
 	updateMsgDateQuery          = "UPDATE Messages SET Date=?, Sent=1 WHERE MsgID=?;"
 	getUpkeepAllQuery           = "SELECT UpkeepAll FROM Nyms WHERE MappedID=?;"
@@ -188,21 +255,43 @@ CREATE TABLE MessageIDCache(
 	getUpkeepAccountsQuery      = "SELECT UpkeepAccounts FROM Nyms WHERE MappedID=?;"
 	setUpkeepAccountsQuery      = "UPDATE Nyms SET UpkeepAccounts=? WHERE MappedID=?;"
 	addOutQueueQuery            = "INSERT INTO OutQueue (Self, MsgID, Msg, NymAddress, MinDelay, MaxDelay, Envelope, Resend) VALUES (?, ?, ?, ?, ?, ?, 0, 0);"
-	getOutQueueQuery            = "SELECT OQIdx, Msg, NymAddress, MinDelay, MaxDelay, Envelope FROM OutQueue WHERE Self=? AND Resend=0 ORDER BY OQIdx ASC LIMIT 1;"
+	getOutQueueQuery            = "SELECT OQIdx, OutQueue.Msg, OutQueue.NymAddress, OutQueue.MinDelay, OutQueue.MaxDelay, Envelope FROM OutQueue JOIN Messages USING (MsgID) WHERE OutQueue.Self=? AND Resend=0 ORDER BY Priority DESC, OQIdx ASC LIMIT 1;"
 	getOutQueueMsgIDQuery       = "SELECT MsgID FROM OutQueue WHERE OQIdx=?;"
 	setOutQueueQuery            = "UPDATE OutQueue SET Msg=?, Envelope=1 WHERE OQIdx=?;"
 	removeOutQueueQuery         = "DELETE FROM OutQueue WHERE OQIdx=?;"
-	setResendOutQueueQuery      = "UPDATE OutQueue SET Resend=1 WHERE OQIdx=?;"
+	setResendOutQueueQuery      = "UPDATE OutQueue SET Resend=1, Attempts=Attempts+1, Retry=0 WHERE OQIdx=?;"
+	setRetryOutQueueQuery       = "UPDATE OutQueue SET Retry=? WHERE OQIdx=?;"
+	setFailedOutQueueQuery      = "UPDATE OutQueue SET Resend=2 WHERE OQIdx=?;"
 	clearResendOutQueueQuery    = "UPDATE OutQueue SET Resend=0 WHERE Self=? AND Resend=1;"
+	getResendOutQueueQuery      = "SELECT OQIdx, MsgID, Msg, NymAddress, MinDelay, MaxDelay, Envelope, Resend, Attempts, Retry FROM OutQueue WHERE Self=? AND Resend>0 ORDER BY OQIdx ASC;"
 	addInQueueQuery             = "INSERT INTO InQueue (MyID, ContactID, Date, Msg, Envelope) VALUES (?, ?, ?, ?, 1);"
+	addInQueueMsgQuery          = "INSERT INTO InQueue (MyID, ContactID, Date, Msg, Envelope) VALUES (?, 0, ?, ?, 0);"
 	getInQueueQuery             = "SELECT IQIdx, MyID, ContactID, Msg, Envelope FROM InQueue ORDER BY IQIdx ASC LIMIT 1;"
-	getInQueueIDsQuery          = "SELECT MyID, ContactID, Date FROM InQueue WHERE IQIdx=?;"
-	setInQueueQuery             = "UPDATE InQueue SET Msg=?, Envelope=0 WHERE IQIdx=?;"
+	getInQueueMsgsQuery         = "SELECT IQIdx, MyID, Msg FROM InQueue WHERE Envelope=0 ORDER BY IQIdx ASC;"
+	getInQueueIDsQuery          = "SELECT MyID, ContactID, Date, NymAddress FROM InQueue WHERE IQIdx=?;"
+	setInQueueQuery             = "UPDATE InQueue SET Msg=?, Envelope=0, NymAddress=? WHERE IQIdx=?;"
 	removeInQueueQuery          = "DELETE FROM InQueue WHERE IQIdx=?;"
 	addMessageIDCacheQuery      = "INSERT INTO MessageIDCache (MyID, ContactID, MessageID) VALUES (?, ?, ?);"
 	getMessageIDCacheQuery      = "SELECT MessageID FROM MessageIDCache WHERE MyID=? AND ContactID=?;"
 	getMessageIDCacheEntryQuery = "SELECT Entry FROM MessageIDCache WHERE MyID=? AND ContactID=? AND MessageID=?;"
 	removeMessageIDCacheQuery   = "DELETE FROM MessageIDCache WHERE MyID=? AND ContactID=? AND Entry<?;"
+	addReceivedQuery            = "INSERT OR IGNORE INTO Received (MyID, Hash, Date) VALUES (?, ?, ?);"
+	delReceivedQuery            = "DELETE FROM Received WHERE MyID=? AND Date<?;"
+	addGroupMemberQuery         = "INSERT OR IGNORE INTO GroupMembers (MyID, Name, ContactID) VALUES (?, ?, ?);"
+	delGroupMemberQuery         = "DELETE FROM GroupMembers WHERE MyID=? AND Name=? AND ContactID=?;"
+	getGroupMembersQuery        = "SELECT Contacts.MappedID FROM GroupMembers JOIN Contacts ON GroupMembers.ContactID=Contacts.UID WHERE GroupMembers.MyID=? AND GroupMembers.Name=? ORDER BY Contacts.MappedID;"
+	getGroupsQuery              = "SELECT DISTINCT Name FROM GroupMembers WHERE MyID=? ORDER BY Name;"
+	addNymAddressQuery          = "INSERT INTO NymAddresses (MyID, MixAddress, NymAddress, Expire, ReceiverKey, Peer) VALUES (?, ?, ?, ?, ?, ?);"
+	getPeerNymAddressQuery      = "SELECT NymAddress FROM NymAddresses WHERE MyID=? AND Peer=? AND Expire>? AND Retire=0 ORDER BY Entry DESC LIMIT 1;"
+	getNymAddressesQuery        = "SELECT MixAddress, NymAddress, Expire, ReceiverKey, Retire FROM NymAddresses WHERE MyID=? AND Expire>? AND (Retire=0 OR Retire>?) ORDER BY Entry;"
+	retireNymAddressesQuery     = "UPDATE NymAddresses SET Retire=? WHERE MyID=? AND Retire=0;"
+	getNymAddressRetiredQuery   = "SELECT COUNT(*) FROM NymAddresses WHERE MyID=? AND ReceiverKey=? AND Retire>0 AND Retire<=?;"
+	getNymAddressByKeyQuery     = "SELECT NymAddress FROM NymAddresses WHERE MyID=? AND ReceiverKey=?;"
+	addNymAddressMsgQuery       = "UPDATE NymAddresses SET Messages=Messages+1, LastMessage=? WHERE MyID=? AND ReceiverKey=?;"
+	getNymAddressStatsQuery     = "SELECT MixAddress, NymAddress, Expire, ReceiverKey, Retire, Messages, LastMessage FROM NymAddresses WHERE MyID=? ORDER BY Entry;"
+	exportMsgsQuery             = "SELECT Contacts.MappedID, Contacts.UnmappedID, Direction, Sent, \"From\", \"To\", Date, Message, Sign, MinDelay, MaxDelay, Read, Star, Expire, Burn, Priority, Signature, ContentType FROM Messages JOIN Contacts ON Messages.Peer=Contacts.UID WHERE Messages.Self=? AND Burn=0 ORDER BY MsgID ASC;"
+	importMsgQuery              = "INSERT INTO Messages (Self, Peer, Direction, ToSend, Sent, \"From\", \"To\", Date, Subject, Message, Sign, MinDelay, MaxDelay, Read, Star, Expire, Burn, Priority, Signature, ContentType, BurnKey) VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	hasMsgQuery                 = "SELECT COUNT(*) FROM Messages WHERE Self=? AND Peer=? AND Direction=? AND Date=? AND Message=?;"
 )
 
 // MsgDB is a handle for an encrypted database to store messsages and tokens.
@@ -211,6 +300,7 @@ type MsgDB struct {
 	updateValueQuery            *sql.Stmt
 	insertValueQuery            *sql.Stmt
 	getValueQuery               *sql.Stmt
+	delValueQuery               *sql.Stmt
 	updateNymQuery              *sql.Stmt
 	insertNymQuery              *sql.Stmt
 	getNymQuery                 *sql.Stmt
@@ -222,6 +312,14 @@ type MsgDB struct {
 	getContactMappedQuery       *sql.Stmt
 	getContactUIDQuery          *sql.Stmt
 	getContactsQuery            *sql.Stmt
+	setContactLastSeenQuery     *sql.Stmt
+	setMsgPeerLastSeenQuery     *sql.Stmt
+	getContactNotesQuery        *sql.Stmt
+	setContactNotesQuery        *sql.Stmt
+	getContactAliasQuery        *sql.Stmt
+	setContactAliasQuery        *sql.Stmt
+	getContactByAliasQuery      *sql.Stmt
+	getContactStatsQuery        *sql.Stmt
 	updateContactQuery          *sql.Stmt
 	insertContactQuery          *sql.Stmt
 	delContactQuery             *sql.Stmt
@@ -233,10 +331,19 @@ type MsgDB struct {
 	getAccountTimeQuery         *sql.Stmt
 	addMsgQuery                 *sql.Stmt
 	delMsgQuery                 *sql.Stmt
+	setMsgOptionsQuery          *sql.Stmt
+	burnMsgQuery                *sql.Stmt
+	delExpiredMsgsQuery         *sql.Stmt
 	getMsgQuery                 *sql.Stmt
+	getMsgSignatureQuery        *sql.Stmt
+	getMsgNymAddressQuery       *sql.Stmt
+	getMsgGroupBodyQuery        *sql.Stmt
+	getMsgStatusQuery           *sql.Stmt
 	readMsgQuery                *sql.Stmt
 	getMsgsQuery                *sql.Stmt
+	getQueuedMsgsQuery          *sql.Stmt
 	getUndeliveredMsgQuery      *sql.Stmt
+	getUndeliveredMsgToQuery    *sql.Stmt
 	updateDeliveryMsgQuery      *sql.Stmt
 	updateMsgDateQuery          *sql.Stmt
 	getUpkeepAllQuery           *sql.Stmt
@@ -250,8 +357,13 @@ type MsgDB struct {
 	removeOutQueueQuery         *sql.Stmt
 	setResendOutQueueQuery      *sql.Stmt
 	clearResendOutQueueQuery    *sql.Stmt
+	getResendOutQueueQuery      *sql.Stmt
+	setRetryOutQueueQuery       *sql.Stmt
+	setFailedOutQueueQuery      *sql.Stmt
 	addInQueueQuery             *sql.Stmt
+	addInQueueMsgQuery          *sql.Stmt
 	getInQueueQuery             *sql.Stmt
+	getInQueueMsgsQuery         *sql.Stmt
 	getInQueueIDsQuery          *sql.Stmt
 	setInQueueQuery             *sql.Stmt
 	removeInQueueQuery          *sql.Stmt
@@ -259,12 +371,35 @@ type MsgDB struct {
 	getMessageIDCacheQuery      *sql.Stmt
 	getMessageIDCacheEntryQuery *sql.Stmt
 	removeMessageIDCacheQuery   *sql.Stmt
+	addReceivedQuery            *sql.Stmt
+	delReceivedQuery            *sql.Stmt
+	addGroupMemberQuery         *sql.Stmt
+	delGroupMemberQuery         *sql.Stmt
+	getGroupMembersQuery        *sql.Stmt
+	getGroupsQuery              *sql.Stmt
+	addNymAddressQuery          *sql.Stmt
+	getPeerNymAddressQuery      *sql.Stmt
+	getNymAddressesQuery        *sql.Stmt
+	retireNymAddressesQuery     *sql.Stmt
+	getNymAddressRetiredQuery   *sql.Stmt
+	getNymAddressByKeyQuery     *sql.Stmt
+	addNymAddressMsgQuery       *sql.Stmt
+	getNymAddressStatsQuery     *sql.Stmt
+	exportMsgsQuery             *sql.Stmt
+	importMsgQuery              *sql.Stmt
+	hasMsgQuery                 *sql.Stmt
 }
 
 // Create returns a new message database with the given dbname.
 // It is encrypted by passphrase (processed by a KDF with iter many iterations).
 func Create(dbname string, passphrase []byte, iter int) error {
-	err := encdb.Create(dbname, passphrase, iter, []string{
+	return CreateKDF(dbname, passphrase, encdb.PBKDF2(iter))
+}
+
+// CreateKDF is like Create, but uses the given kdf to derive the key for the
+// msgDB keyfile from the passphrase.
+func CreateKDF(dbname string, passphrase []byte, kdf *encdb.KDF) error {
+	err := encdb.CreateKDF(dbname, passphrase, kdf, []string{
 		createQueryKeyValue,
 		createQueryNyms,
 		createQueryContacts,
@@ -275,6 +410,9 @@ func Create(dbname string, passphrase []byte, iter int) error {
 		createQueryOutQueue,
 		createQueryInQueue,
 		createMessageIDCache,
+		createQueryGroupMembers,
+		createQueryReceived,
+		createQueryNymAddresses,
 	})
 	if err != nil {
 		return err
@@ -298,11 +436,30 @@ func (msgDB *MsgDB) Version() (string, error) {
 
 // Open opens the message database with dbname and passphrase.
 func Open(dbname string, passphrase []byte) (*MsgDB, error) {
+	encDB, err := encdb.Open(dbname, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return open(encDB, false)
+}
+
+// OpenReadOnly opens the message database with dbname and passphrase
+// read-only. All methods which modify the database fail.
+func OpenReadOnly(dbname string, passphrase []byte) (*MsgDB, error) {
+	encDB, err := encdb.OpenReadOnly(dbname, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return open(encDB, true)
+}
+
+func open(encDB *sql.DB, readOnly bool) (*MsgDB, error) {
 	var msgDB MsgDB
 	var err error
-	// open database
-	msgDB.encDB, err = encdb.Open(dbname, passphrase)
-	if err != nil {
+	msgDB.encDB = encDB
+	// upgrade database, if necessary
+	if err := upgrade(msgDB.encDB, readOnly); err != nil {
+		msgDB.encDB.Close()
 		return nil, err
 	}
 	// prepare statements
@@ -318,6 +475,10 @@ func Open(dbname string, passphrase []byte) (*MsgDB, error) {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.delValueQuery, err = msgDB.encDB.Prepare(delValueQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.updateNymQuery, err = msgDB.encDB.Prepare(updateNymQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
@@ -362,6 +523,38 @@ func Open(dbname string, passphrase []byte) (*MsgDB, error) {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.setContactLastSeenQuery, err = msgDB.encDB.Prepare(setContactLastSeenQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.setMsgPeerLastSeenQuery, err = msgDB.encDB.Prepare(setMsgPeerLastSeenQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getContactNotesQuery, err = msgDB.encDB.Prepare(getContactNotesQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.setContactNotesQuery, err = msgDB.encDB.Prepare(setContactNotesQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getContactAliasQuery, err = msgDB.encDB.Prepare(getContactAliasQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.setContactAliasQuery, err = msgDB.encDB.Prepare(setContactAliasQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getContactByAliasQuery, err = msgDB.encDB.Prepare(getContactByAliasQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getContactStatsQuery, err = msgDB.encDB.Prepare(getContactStatsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.updateContactQuery, err = msgDB.encDB.Prepare(updateContactQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
@@ -406,10 +599,38 @@ func Open(dbname string, passphrase []byte) (*MsgDB, error) {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.setMsgOptionsQuery, err = msgDB.encDB.Prepare(setMsgOptionsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.burnMsgQuery, err = msgDB.encDB.Prepare(burnMsgQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.delExpiredMsgsQuery, err = msgDB.encDB.Prepare(delExpiredMsgsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.getMsgQuery, err = msgDB.encDB.Prepare(getMsgQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.getMsgSignatureQuery, err = msgDB.encDB.Prepare(getMsgSignatureQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getMsgNymAddressQuery, err = msgDB.encDB.Prepare(getMsgNymAddressQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getMsgGroupBodyQuery, err = msgDB.encDB.Prepare(getMsgGroupBodyQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getMsgStatusQuery, err = msgDB.encDB.Prepare(getMsgStatusQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.readMsgQuery, err = msgDB.encDB.Prepare(readMsgQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
@@ -418,10 +639,18 @@ func Open(dbname string, passphrase []byte) (*MsgDB, error) {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.getQueuedMsgsQuery, err = msgDB.encDB.Prepare(getQueuedMsgsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.getUndeliveredMsgQuery, err = msgDB.encDB.Prepare(getUndeliveredMsgQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.getUndeliveredMsgToQuery, err = msgDB.encDB.Prepare(getUndeliveredMsgToQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.updateDeliveryMsgQuery, err = msgDB.encDB.Prepare(updateDeliveryMsgQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
@@ -474,14 +703,34 @@ func Open(dbname string, passphrase []byte) (*MsgDB, error) {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.getResendOutQueueQuery, err = msgDB.encDB.Prepare(getResendOutQueueQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.setRetryOutQueueQuery, err = msgDB.encDB.Prepare(setRetryOutQueueQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.setFailedOutQueueQuery, err = msgDB.encDB.Prepare(setFailedOutQueueQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.addInQueueQuery, err = msgDB.encDB.Prepare(addInQueueQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.addInQueueMsgQuery, err = msgDB.encDB.Prepare(addInQueueMsgQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.getInQueueQuery, err = msgDB.encDB.Prepare(getInQueueQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.getInQueueMsgsQuery, err = msgDB.encDB.Prepare(getInQueueMsgsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	if msgDB.getInQueueIDsQuery, err = msgDB.encDB.Prepare(getInQueueIDsQuery); err != nil {
 		msgDB.encDB.Close()
 		return nil, err
@@ -510,6 +759,74 @@ func Open(dbname string, passphrase []byte) (*MsgDB, error) {
 		msgDB.encDB.Close()
 		return nil, err
 	}
+	if msgDB.addReceivedQuery, err = msgDB.encDB.Prepare(addReceivedQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.delReceivedQuery, err = msgDB.encDB.Prepare(delReceivedQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.addGroupMemberQuery, err = msgDB.encDB.Prepare(addGroupMemberQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.delGroupMemberQuery, err = msgDB.encDB.Prepare(delGroupMemberQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getGroupMembersQuery, err = msgDB.encDB.Prepare(getGroupMembersQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getGroupsQuery, err = msgDB.encDB.Prepare(getGroupsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.addNymAddressQuery, err = msgDB.encDB.Prepare(addNymAddressQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getPeerNymAddressQuery, err = msgDB.encDB.Prepare(getPeerNymAddressQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getNymAddressesQuery, err = msgDB.encDB.Prepare(getNymAddressesQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.retireNymAddressesQuery, err = msgDB.encDB.Prepare(retireNymAddressesQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getNymAddressRetiredQuery, err = msgDB.encDB.Prepare(getNymAddressRetiredQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getNymAddressByKeyQuery, err = msgDB.encDB.Prepare(getNymAddressByKeyQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.addNymAddressMsgQuery, err = msgDB.encDB.Prepare(addNymAddressMsgQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.getNymAddressStatsQuery, err = msgDB.encDB.Prepare(getNymAddressStatsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.exportMsgsQuery, err = msgDB.encDB.Prepare(exportMsgsQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.importMsgQuery, err = msgDB.encDB.Prepare(importMsgQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
+	if msgDB.hasMsgQuery, err = msgDB.encDB.Prepare(hasMsgQuery); err != nil {
+		msgDB.encDB.Close()
+		return nil, err
+	}
 	return &msgDB, nil
 }
 
@@ -531,6 +848,20 @@ func Rekey(dbname string, oldPassphrase, newPassphrase []byte, newIter int) erro
 	return encdb.Rekey(dbname, oldPassphrase, newPassphrase, newIter)
 }
 
+// Reiterate changes the number of KDF iterations of the message database
+// dbname to newIter without changing the passphrase, which must be correct.
+// The KDF algorithm is kept (see encdb.Reiterate).
+func Reiterate(dbname string, passphrase []byte, newIter int) error {
+	return encdb.Reiterate(dbname, passphrase, newIter)
+}
+
+// CheckPassphrase checks whether passphrase is the correct passphrase of the
+// message database dbname without opening it for writes. A wrong passphrase
+// results in encdb.ErrWrongPassphrase.
+func CheckPassphrase(dbname string, passphrase []byte) error {
+	return encdb.CheckPassphrase(dbname, passphrase)
+}
+
 // Status returns the autoVacuum mode and freelistCount of msgDB.
 func (msgDB *MsgDB) Status() (
 	autoVacuum string,
@@ -540,6 +871,12 @@ func (msgDB *MsgDB) Status() (
 	return encdb.Status(msgDB.encDB)
 }
 
+// Integrity runs an integrity check on msgDB and returns the result, which is
+// "ok" if no problems were found.
+func (msgDB *MsgDB) Integrity() (string, error) {
+	return encdb.Integrity(msgDB.encDB)
+}
+
 // Vacuum executes VACUUM command in msgDB. If autoVacuumMode is not nil and
 // different from the current one, the auto_vacuum mode is changed before
 // VACUUM is executed.
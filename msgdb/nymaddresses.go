@@ -0,0 +1,224 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msgdb
+
+import (
+	"database/sql"
+
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/uid/identity"
+)
+
+// NymAddress is a nym address generated to receive messages for a user ID.
+type NymAddress struct {
+	MixAddress  string // mix address of the nym address
+	NymAddress  string // the nym address (base64)
+	Expire      int64  // expiration time (Unix time)
+	ReceiverKey string // identifies the nym address in received messages
+	Retire      int64  // end of grace period of retiring address (0: active)
+	Messages    int64  // number of received messages (see GetNymAddressStats)
+	LastMessage int64  // time of the last received message (0: never)
+}
+
+// AddNymAddress stores the nymAddress (with the given mixAddress, receiverKey,
+// and expire time) generated for myID.
+func (msgDB *MsgDB) AddNymAddress(
+	myID, mixAddress, nymAddress, receiverKey string,
+	expire int64,
+) error {
+	return msgDB.AddPeerNymAddress(myID, "", mixAddress, nymAddress,
+		receiverKey, expire)
+}
+
+// AddPeerNymAddress stores the nymAddress (with the given mixAddress,
+// receiverKey, and expire time) generated for myID to receive replies from
+// peer (see GetPeerNymAddress).
+func (msgDB *MsgDB) AddPeerNymAddress(
+	myID, peer, mixAddress, nymAddress, receiverKey string,
+	expire int64,
+) error {
+	if err := identity.IsMapped(myID); err != nil {
+		return log.Error(err)
+	}
+	if mixAddress == "" {
+		return log.Error("msgdb: mixAddress must be defined")
+	}
+	if nymAddress == "" {
+		return log.Error("msgdb: nymAddress must be defined")
+	}
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return log.Error(err)
+	}
+	_, err := msgDB.addNymAddressQuery.Exec(mID, mixAddress, nymAddress, expire,
+		receiverKey, peer)
+	if err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// GetPeerNymAddress returns the most recent active (not retired) nym address
+// of myID given to peer which expires after the given time (Unix time). If
+// there is no such nym address, an empty string is returned.
+func (msgDB *MsgDB) GetPeerNymAddress(
+	myID, peer string,
+	expire int64,
+) (string, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return "", log.Error(err)
+	}
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return "", log.Error(err)
+	}
+	var nymAddress string
+	err := msgDB.getPeerNymAddressQuery.QueryRow(mID, peer, expire).Scan(&nymAddress)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", nil
+	case err != nil:
+		return "", log.Error(err)
+	}
+	return nymAddress, nil
+}
+
+// GetNymAddresses returns all nym addresses of myID which have not expired
+// (or retired) at time now (Unix time), oldest first.
+func (msgDB *MsgDB) GetNymAddresses(myID string, now int64) ([]*NymAddress, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return nil, log.Error(err)
+	}
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return nil, log.Error(err)
+	}
+	rows, err := msgDB.getNymAddressesQuery.Query(mID, now, now)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var addrs []*NymAddress
+	defer rows.Close()
+	for rows.Next() {
+		var addr NymAddress
+		err := rows.Scan(&addr.MixAddress, &addr.NymAddress, &addr.Expire,
+			&addr.ReceiverKey, &addr.Retire)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		addrs = append(addrs, &addr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return addrs, nil
+}
+
+// RetireNymAddresses marks all active nym addresses of myID as retiring:
+// messages sent to them are accepted until the given retire time (Unix time).
+// It returns the number of retiring nym addresses.
+func (msgDB *MsgDB) RetireNymAddresses(myID string, retire int64) (int64, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return 0, log.Error(err)
+	}
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return 0, log.Error(err)
+	}
+	res, err := msgDB.retireNymAddressesQuery.Exec(retire, mID)
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	return n, nil
+}
+
+// NymAddressRetired returns true, if the nym address of myID identified by
+// receiverKey has been retired and its grace period elapsed at time now.
+// Unknown nym addresses are not retired.
+func (msgDB *MsgDB) NymAddressRetired(
+	myID, receiverKey string,
+	now int64,
+) (bool, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return false, log.Error(err)
+	}
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return false, log.Error(err)
+	}
+	var n int64
+	err := msgDB.getNymAddressRetiredQuery.QueryRow(mID, receiverKey,
+		now).Scan(&n)
+	if err != nil {
+		return false, log.Error(err)
+	}
+	return n > 0, nil
+}
+
+// AddNymAddressMessage records the receipt of a message (at time now) sent to
+// the nym address of myID identified by receiverKey and returns that nym
+// address. Messages to unknown nym addresses are not recorded (the returned
+// nym address is empty).
+func (msgDB *MsgDB) AddNymAddressMessage(
+	myID, receiverKey string,
+	now int64,
+) (string, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return "", log.Error(err)
+	}
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return "", log.Error(err)
+	}
+	var nymAddress string
+	err := msgDB.getNymAddressByKeyQuery.QueryRow(mID, receiverKey).Scan(&nymAddress)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", nil
+	case err != nil:
+		return "", log.Error(err)
+	}
+	_, err = msgDB.addNymAddressMsgQuery.Exec(now, mID, receiverKey)
+	if err != nil {
+		return "", log.Error(err)
+	}
+	return nymAddress, nil
+}
+
+// GetNymAddressStats returns all nym addresses of myID (including expired and
+// retired ones) together with the number of messages received via them,
+// oldest first.
+func (msgDB *MsgDB) GetNymAddressStats(myID string) ([]*NymAddress, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return nil, log.Error(err)
+	}
+	var mID int
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return nil, log.Error(err)
+	}
+	rows, err := msgDB.getNymAddressStatsQuery.Query(mID)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var addrs []*NymAddress
+	defer rows.Close()
+	for rows.Next() {
+		var addr NymAddress
+		err := rows.Scan(&addr.MixAddress, &addr.NymAddress, &addr.Expire,
+			&addr.ReceiverKey, &addr.Retire, &addr.Messages, &addr.LastMessage)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		addrs = append(addrs, &addr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return addrs, nil
+}
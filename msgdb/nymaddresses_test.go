@@ -0,0 +1,199 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msgdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNymAddresses(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	if err := msgDB.AddNym(a, a, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddNymAddress(a, "mix", "expired", "k1", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddNymAddress(a, "mix", "old", "k2", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddNymAddress(a, "mix", "", "k3", 100); err == nil {
+		t.Error("adding empty nym address should fail")
+	}
+	if err := msgDB.AddNymAddress("eve@mute.berlin", "mix", "nym", "k3", 100); err == nil {
+		t.Error("adding nym address for unknown nym should fail")
+	}
+	addrs, err := msgDB.GetNymAddresses(a, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0].NymAddress != "old" ||
+		addrs[0].MixAddress != "mix" || addrs[0].Expire != 100 ||
+		addrs[0].ReceiverKey != "k2" || addrs[0].Retire != 0 {
+		t.Errorf("unexpected nym addresses: %v", addrs)
+	}
+
+	// rotate
+	n, err := msgDB.RetireNymAddresses(a, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("n == %d != 2", n)
+	}
+	if err := msgDB.AddNymAddress(a, "mix", "new", "k4", 100); err != nil {
+		t.Fatal(err)
+	}
+	// in grace period
+	addrs, err = msgDB.GetNymAddresses(a, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0].Retire != 50 || addrs[1].Retire != 0 {
+		t.Errorf("unexpected nym addresses: %v", addrs)
+	}
+	for _, key := range []string{"k2", "k4", "unknown"} {
+		retired, err := msgDB.NymAddressRetired(a, key, 40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retired {
+			t.Errorf("nym address %s should not be retired yet", key)
+		}
+	}
+	// after grace period
+	addrs, err = msgDB.GetNymAddresses(a, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0].NymAddress != "new" {
+		t.Errorf("unexpected nym addresses: %v", addrs)
+	}
+	retired, err := msgDB.NymAddressRetired(a, "k2", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !retired {
+		t.Error("nym address k2 should be retired")
+	}
+}
+
+func TestNymAddressStats(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	if err := msgDB.AddNym(a, a, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddNymAddress(a, "mix", "nym1", "k1", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddNymAddress(a, "mix", "nym2", "k2", 100); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"k1", "k2", "k1", "unknown"} {
+		nymAddress, err := msgDB.AddNymAddressMessage(a, key, int64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "unknown" && nymAddress != "nym"+key[1:] {
+			t.Errorf("wrong nym address for %s: %s", key, nymAddress)
+		}
+		if key == "unknown" && nymAddress != "" {
+			t.Errorf("nym address for unknown key: %s", nymAddress)
+		}
+	}
+	addrs, err := msgDB.GetNymAddressStats(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("len(addrs) == %d != 2", len(addrs))
+	}
+	if addrs[0].NymAddress != "nym1" || addrs[0].Messages != 2 ||
+		addrs[0].LastMessage != 3 {
+		t.Errorf("wrong stats for nym1: %+v", addrs[0])
+	}
+	if addrs[1].NymAddress != "nym2" || addrs[1].Messages != 1 ||
+		addrs[1].LastMessage != 2 {
+		t.Errorf("wrong stats for nym2: %+v", addrs[1])
+	}
+}
+
+func TestPeerNymAddress(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	if err := msgDB.AddNym(a, a, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	// published nym addresses are not given to peers
+	if err := msgDB.AddNymAddress(a, "mix", "published", "k1", 100); err != nil {
+		t.Fatal(err)
+	}
+	nymAddress, err := msgDB.GetPeerNymAddress(a, b, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nymAddress != "" {
+		t.Errorf("unexpected nym address for %s: %s", b, nymAddress)
+	}
+	// most recent nym address of peer
+	for i, nym := range []string{"old", "new"} {
+		err := msgDB.AddPeerNymAddress(a, b, "mix", nym, "k"+nym, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			err := msgDB.AddPeerNymAddress(a, "carol@mute.berlin", "mix",
+				"carol", "kcarol", 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	nymAddress, err = msgDB.GetPeerNymAddress(a, b, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nymAddress != "new" {
+		t.Errorf("nym address for %s: %s != new", b, nymAddress)
+	}
+	// nym address expires too early
+	nymAddress, err = msgDB.GetPeerNymAddress(a, b, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nymAddress != "" {
+		t.Errorf("expiring nym address returned: %s", nymAddress)
+	}
+	// retired nym addresses are not returned
+	if _, err := msgDB.RetireNymAddresses(a, 50); err != nil {
+		t.Fatal(err)
+	}
+	nymAddress, err = msgDB.GetPeerNymAddress(a, b, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nymAddress != "" {
+		t.Errorf("retired nym address returned: %s", nymAddress)
+	}
+}
@@ -127,12 +127,12 @@ func TestDeleteNym(t *testing.T) {
 	}
 	now := times.Now()
 	err = msgDB.AddMessage(a, b, now, true, "ping", false,
-		def.MinDelay, def.MaxDelay)
+		def.MinDelay, def.MaxDelay, NormalPriority)
 	if err != nil {
 		t.Fatal(err)
 	}
 	err = msgDB.AddMessage(a, b, now, false, "pong", false,
-		def.MinDelay, def.MaxDelay)
+		def.MinDelay, def.MaxDelay, NormalPriority)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -231,16 +231,18 @@ func TestNymUpdate(t *testing.T) {
 	if uid != 1 {
 		t.Error("uid != 1")
 	}
-	_, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	_, key, err := ed25519.GenerateKey(cipher.RandReader)
 	if err != nil {
 		t.Fatal(err)
 	}
+	var privkey [ed25519.PrivateKeySize]byte
+	copy(privkey[:], key)
 	server := "accounts001.mute.berlin"
 	var secret [64]byte
 	if _, err := io.ReadFull(cipher.RandReader, secret[:]); err != nil {
 		t.Fatal(err)
 	}
-	err = msgDB.AddAccount(a, "", privkey, server, &secret,
+	err = msgDB.AddAccount(a, "", &privkey, server, &secret,
 		def.MinMinDelay, def.MinMaxDelay)
 	if err != nil {
 		t.Fatal(err)
@@ -47,8 +47,8 @@ func (msgDB *MsgDB) AddOutQueue(
 	return nil
 }
 
-// GetOutQueue returns the first entry in the outqueue for myID.
-// Entries which need to be resend are ignored.
+// GetOutQueue returns the first entry with the highest priority in the
+// outqueue for myID. Entries which need to be resend are ignored.
 func (msgDB *MsgDB) GetOutQueue(myID string) (
 	oqIdx int64,
 	msg, nymaddress string,
@@ -87,8 +87,20 @@ func (msgDB *MsgDB) SetOutQueue(oqIdx int64, envMsg string) error {
 	return nil
 }
 
+// GetOutQueueMsgID returns the message number of the message corresponding to
+// oqIdx.
+func (msgDB *MsgDB) GetOutQueueMsgID(oqIdx int64) (int64, error) {
+	var msgID int64
+	err := msgDB.getOutQueueMsgIDQuery.QueryRow(oqIdx).Scan(&msgID)
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	return msgID, nil
+}
+
 // RemoveOutQueue remove the message corresponding to oqIdx from the outqueue
-// and sets the send time of the corresponding message to date.
+// and sets the send time of the corresponding message to date. The last seen
+// time of the recipient is updated accordingly.
 func (msgDB *MsgDB) RemoveOutQueue(oqIdx, date int64) error {
 	tx, err := msgDB.encDB.Begin()
 	if err != nil {
@@ -107,6 +119,12 @@ func (msgDB *MsgDB) RemoveOutQueue(oqIdx, date int64) error {
 		tx.Rollback()
 		return log.Error(err)
 	}
+	// a sent message counts as interaction with the peer
+	_, err = tx.Stmt(msgDB.setMsgPeerLastSeenQuery).Exec(date, msgID, date)
+	if err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
 	// remove entry from outqueue
 	if _, err := tx.Stmt(msgDB.removeOutQueueQuery).Exec(oqIdx); err != nil {
 		tx.Rollback()
@@ -151,7 +169,60 @@ func (msgDB *MsgDB) RetractOutQueue(oqIdx int64) error {
 	return nil
 }
 
+// CancelMessage removes the outgoing message with the given msgNum from user
+// myID, which has not been sent yet. The corresponding outqueue entry (if the
+// message has been encrypted already) is removed as well. If the message has
+// been sent already ErrMessageSent is returned.
+func (msgDB *MsgDB) CancelMessage(myID string, msgNum int64) error {
+	if err := identity.IsMapped(myID); err != nil {
+		return log.Error(err)
+	}
+	var self int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&self); err != nil {
+		return log.Error(err)
+	}
+	tx, err := msgDB.encDB.Begin()
+	if err != nil {
+		return log.Error(err)
+	}
+	var (
+		direction int64
+		sent      int64
+	)
+	err = tx.Stmt(msgDB.getMsgStatusQuery).QueryRow(msgNum, self).Scan(&direction,
+		&sent)
+	switch {
+	case err == sql.ErrNoRows:
+		tx.Rollback()
+		return log.Errorf("msgdb: unknown msgnum %d for user ID %s",
+			msgNum, myID)
+	case err != nil:
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if direction != 1 {
+		tx.Rollback()
+		return log.Errorf("msgdb: msgnum %d is not an outgoing message", msgNum)
+	}
+	if sent != 0 {
+		tx.Rollback()
+		return log.Error(ErrMessageSent)
+	}
+	// outqueue entry is removed via ON DELETE CASCADE
+	if _, err := tx.Stmt(msgDB.delMsgQuery).Exec(msgNum, self); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	return nil
+}
+
 // SetResendOutQueue sets the message in outqueue with index oqIdx to resend.
+// The number of failed delivery attempts is incremented and a scheduled
+// automatic resend is canceled (see SetRetryOutQueue).
 func (msgDB *MsgDB) SetResendOutQueue(oqIdx int64) error {
 	if _, err := msgDB.setResendOutQueueQuery.Exec(oqIdx); err != nil {
 		return log.Error(err)
@@ -173,3 +244,80 @@ func (msgDB *MsgDB) ClearResendOutQueue(myID string) error {
 	}
 	return nil
 }
+
+// SetRetryOutQueue schedules the automatic resend of the message in outqueue
+// with index oqIdx at time retry.
+func (msgDB *MsgDB) SetRetryOutQueue(oqIdx, retry int64) error {
+	if _, err := msgDB.setRetryOutQueueQuery.Exec(retry, oqIdx); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// SetFailedOutQueue marks the delivery of the message in outqueue with index
+// oqIdx as permanently failed. It is not resent automatically anymore and
+// ClearResendOutQueue does not touch it, but it can still be resent manually.
+func (msgDB *MsgDB) SetFailedOutQueue(oqIdx int64) error {
+	if _, err := msgDB.setFailedOutQueueQuery.Exec(oqIdx); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
+
+// ResendEntry is an outqueue entry which needs to be resend (see
+// GetResendOutQueue).
+type ResendEntry struct {
+	OQIdx      int64  // index of the outqueue entry
+	MsgID      int64  // message ID of the corresponding plain text message
+	Msg        string // encrypted message (or envelope, if Envelope is set)
+	NymAddress string // nymaddress to send message to
+	MinDelay   int32  // minimum delay of message
+	MaxDelay   int32  // maximum delay of message
+	Envelope   bool   // message has an envelope and is ready to send
+	Failed     bool   // delivery failed permanently (see SetFailedOutQueue)
+	Attempts   int    // number of failed delivery attempts
+	Retry      int64  // time of next automatic resend (0: not scheduled)
+}
+
+// GetResendOutQueue returns all entries in the outqueue for myID which need
+// to be resend (because their delivery failed), the oldest first. Entries
+// whose delivery failed permanently are included.
+func (msgDB *MsgDB) GetResendOutQueue(myID string) ([]*ResendEntry, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return nil, log.Error(err)
+	}
+	var mID int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return nil, log.Error(err)
+	}
+	rows, err := msgDB.getResendOutQueueQuery.Query(mID)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	var entries []*ResendEntry
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			entry  ResendEntry
+			e      int64
+			resend int64
+		)
+		err := rows.Scan(&entry.OQIdx, &entry.MsgID, &entry.Msg,
+			&entry.NymAddress, &entry.MinDelay, &entry.MaxDelay, &e, &resend,
+			&entry.Attempts, &entry.Retry)
+		if err != nil {
+			return nil, log.Error(err)
+		}
+		if e > 0 {
+			entry.Envelope = true
+		}
+		if resend == 2 {
+			entry.Failed = true
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, log.Error(err)
+	}
+	return entries, nil
+}
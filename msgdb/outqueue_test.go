@@ -29,7 +29,7 @@ func TestOutQueue(t *testing.T) {
 	}
 	now := times.Now()
 	err = msgDB.AddMessage(a, b, now, true, "ping", false,
-		def.MinDelay, def.MaxDelay)
+		def.MinDelay, def.MaxDelay, NormalPriority)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -139,6 +139,31 @@ func TestOutQueue(t *testing.T) {
 	if env != "" {
 		t.Error("envelope should be empty")
 	}
+	// get entries to resend
+	entries, err := msgDB.GetResendOutQueue(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) == %d != 1", len(entries))
+	}
+	if entries[0].OQIdx != oqIdx || entries[0].Msg != "envelope" ||
+		!entries[0].Envelope {
+		t.Errorf("wrong resend entry: %+v", entries[0])
+	}
+	if entries[0].Attempts != 1 || entries[0].Retry != 0 || entries[0].Failed {
+		t.Errorf("wrong resend state: %+v", entries[0])
+	}
+	if err := msgDB.SetRetryOutQueue(oqIdx, now+60); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = msgDB.GetResendOutQueue(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Retry != now+60 {
+		t.Error("retry not scheduled")
+	}
 	// clear resend status
 	if err := msgDB.ClearResendOutQueue(a); err != nil {
 		t.Fatal(err)
@@ -164,3 +189,82 @@ func TestOutQueue(t *testing.T) {
 		t.Error("envelope should be empty")
 	}
 }
+
+func TestOutQueuePriority(t *testing.T) {
+	tmpdir, msgDB, err := createDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	defer msgDB.Close()
+	a := "alice@mute.berlin"
+	b := "bob@mute.berlin"
+	if err := msgDB.AddNym(a, a, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgDB.AddContact(a, b, b, "Bob", WhiteList); err != nil {
+		t.Fatal(err)
+	}
+	now := times.Now()
+	for _, p := range []struct {
+		msg      string
+		priority Priority
+	}{
+		{"low", LowPriority},
+		{"normal", NormalPriority},
+		{"high", HighPriority},
+	} {
+		err = msgDB.AddMessage(a, b, now, true, p.msg, false,
+			def.MinDelay, def.MaxDelay, p.priority)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// undelivered messages are returned in the order of their priority
+	for _, want := range []string{"high", "normal", "low"} {
+		msgID, _, msg, _, _, _, err := msgDB.GetUndeliveredMessage(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != want {
+			t.Errorf("undelivered message %q != %q", msg, want)
+		}
+		err = msgDB.AddOutQueue(a, msgID, "enc "+want, "nymaddress",
+			def.MinDelay, def.MaxDelay)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// retracted high priority message is sent first again
+	oqIdx, enc, _, _, _, _, err := msgDB.GetOutQueue(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc != "enc high" {
+		t.Fatalf("head of outqueue == %q", enc)
+	}
+	if err := msgDB.RetractOutQueue(oqIdx); err != nil {
+		t.Fatal(err)
+	}
+	msgID, _, _, _, _, _, err := msgDB.GetUndeliveredMessage(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = msgDB.AddOutQueue(a, msgID, "enc high", "nymaddress", def.MinDelay,
+		def.MaxDelay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"enc high", "enc normal", "enc low"} {
+		oqIdx, enc, _, _, _, _, err := msgDB.GetOutQueue(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc != want {
+			t.Errorf("head of outqueue %q != %q", enc, want)
+		}
+		if err := msgDB.RemoveOutQueue(oqIdx, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msgdb
+
+import (
+	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/uid/identity"
+)
+
+// AddReceivedInQueue records that a message with the given hash has been
+// received for myID at date and adds the encrypted message msg for
+// myID/contactID to the inqueue in the same transaction. Messages for a nym
+// can be fetched from multiple accounts (on different hosts), if the same
+// message has been recorded before known is true and the message is not
+// added to the inqueue.
+func (msgDB *MsgDB) AddReceivedInQueue(
+	myID, contactID, hash string,
+	date int64,
+	msg string,
+) (known bool, err error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return false, log.Error(err)
+	}
+	if contactID != "" {
+		if err := identity.IsMapped(contactID); err != nil {
+			return false, log.Error(err)
+		}
+	}
+	if hash == "" {
+		return false, log.Error(ErrNilMessageID)
+	}
+	var mID int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return false, log.Error(err)
+	}
+	var cID int64
+	if contactID != "" {
+		if err := msgDB.getContactUIDQuery.QueryRow(mID, contactID).Scan(&cID); err != nil {
+			return false, log.Error(err)
+		}
+	}
+	tx, err := msgDB.encDB.Begin()
+	if err != nil {
+		return false, log.Error(err)
+	}
+	// add hash, ignored if it exists already
+	res, err := tx.Stmt(msgDB.addReceivedQuery).Exec(mID, hash, date)
+	if err != nil {
+		tx.Rollback()
+		return false, log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, log.Error(err)
+	}
+	if n == 0 {
+		tx.Rollback()
+		return true, nil
+	}
+	if _, err := tx.Stmt(msgDB.addInQueueQuery).Exec(mID, cID, date, msg); err != nil {
+		tx.Rollback()
+		return false, log.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return false, log.Error(err)
+	}
+	return false, nil
+}
+
+// DelReceived deletes all received message hashes for myID which have been
+// received before the given date and returns the number of deleted entries.
+func (msgDB *MsgDB) DelReceived(myID string, before int64) (int64, error) {
+	if err := identity.IsMapped(myID); err != nil {
+		return 0, log.Error(err)
+	}
+	var mID int64
+	if err := msgDB.getNymUIDQuery.QueryRow(myID).Scan(&mID); err != nil {
+		return 0, log.Error(err)
+	}
+	res, err := msgDB.delReceivedQuery.Exec(mID, before)
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, log.Error(err)
+	}
+	return n, nil
+}
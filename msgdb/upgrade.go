@@ -0,0 +1,130 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package msgdb
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// upgradeQueries contains the statements to upgrade a msgDB from the
+// version given as key to the next version.
+var upgradeQueries = map[string][]string{
+	"1": {
+		createQueryGroupMembers,
+	},
+	"2": {
+		"ALTER TABLE Contacts ADD COLUMN LastSeen INTEGER NOT NULL DEFAULT 0;",
+	},
+	"3": {
+		"ALTER TABLE Messages ADD COLUMN Expire INTEGER NOT NULL DEFAULT 0;",
+	},
+	"4": {
+		"ALTER TABLE Messages ADD COLUMN Burn INTEGER NOT NULL DEFAULT 0;",
+	},
+	"5": {
+		"ALTER TABLE Messages ADD COLUMN Priority INTEGER NOT NULL DEFAULT 0;",
+	},
+	"6": {
+		"ALTER TABLE Messages ADD COLUMN Signature TEXT NOT NULL DEFAULT '';",
+	},
+	"7": {
+		createQueryReceived,
+	},
+	"8": {
+		"ALTER TABLE Contacts ADD COLUMN Notes TEXT NOT NULL DEFAULT '';",
+	},
+	"9": {
+		"ALTER TABLE Messages ADD COLUMN ContentType TEXT NOT NULL DEFAULT '';",
+	},
+	"10": {
+		"ALTER TABLE OutQueue ADD COLUMN Attempts INTEGER NOT NULL DEFAULT 0;",
+		"ALTER TABLE OutQueue ADD COLUMN Retry INTEGER NOT NULL DEFAULT 0;",
+	},
+	"11": {
+		"CREATE TABLE NymAddresses (Entry INTEGER PRIMARY KEY, MyID INTEGER NOT NULL, " +
+			"MixAddress TEXT NOT NULL, NymAddress TEXT NOT NULL, Expire INTEGER NOT NULL, " +
+			"FOREIGN KEY(MyID) REFERENCES Nyms(UID) ON DELETE CASCADE);",
+	},
+	"12": {
+		"ALTER TABLE NymAddresses ADD COLUMN ReceiverKey TEXT NOT NULL DEFAULT '';",
+		"ALTER TABLE NymAddresses ADD COLUMN Retire INTEGER NOT NULL DEFAULT 0;",
+	},
+	"13": {
+		"ALTER TABLE NymAddresses ADD COLUMN Messages INTEGER NOT NULL DEFAULT 0;",
+		"ALTER TABLE NymAddresses ADD COLUMN LastMessage INTEGER NOT NULL DEFAULT 0;",
+	},
+	"14": {
+		"ALTER TABLE Messages ADD COLUMN BurnKey TEXT NOT NULL DEFAULT '';",
+	},
+	"15": {
+		"ALTER TABLE InQueue ADD COLUMN NymAddress TEXT NOT NULL DEFAULT '';",
+		"ALTER TABLE Messages ADD COLUMN NymAddress TEXT NOT NULL DEFAULT '';",
+	},
+	"16": {
+		"ALTER TABLE Contacts ADD COLUMN Alias TEXT NOT NULL DEFAULT '';",
+	},
+	"17": {
+		"ALTER TABLE Messages ADD COLUMN GroupBody TEXT NOT NULL DEFAULT '';",
+	},
+	"18": {
+		"ALTER TABLE NymAddresses ADD COLUMN Peer TEXT NOT NULL DEFAULT '';",
+	},
+}
+
+// upgrade brings an existing msgDB to the current Version. Read-only
+// databases cannot be upgraded.
+func upgrade(encDB *sql.DB, readOnly bool) error {
+	var version string
+	err := encDB.QueryRow(getValueQuery, DBVersion).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		// database is just being created, Create sets the version
+		return nil
+	case err != nil:
+		return log.Error(err)
+	}
+	if version == Version {
+		return nil
+	}
+	if readOnly {
+		return log.Errorf("msgdb: cannot upgrade read-only database from "+
+			"version %s to %s", version, Version)
+	}
+	log.Infof("msgdb: upgrade from version %s to %s", version, Version)
+	tx, err := encDB.Begin()
+	if err != nil {
+		return log.Error(err)
+	}
+	for version != Version {
+		queries, ok := upgradeQueries[version]
+		if !ok {
+			tx.Rollback()
+			return log.Errorf("msgdb: cannot upgrade from version %s", version)
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(query); err != nil {
+				tx.Rollback()
+				return log.Error(err)
+			}
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			tx.Rollback()
+			return log.Error(err)
+		}
+		version = strconv.Itoa(v + 1)
+	}
+	if _, err := tx.Exec(updateValueQuery, Version, DBVersion); err != nil {
+		tx.Rollback()
+		return log.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
@@ -24,10 +24,10 @@ func (pe *ProtoEngine) deliver(statusfp io.Writer, r io.Reader) error {
 	if err != nil {
 		return log.Error(err)
 	}
-	messageOut, err := mm.Unmarshal().Deliver()
+	resend, err := pe.transport.Deliver(mm.Unmarshal())
 	if err != nil {
-		if messageOut.Resend {
-			log.Info("write: RESEND:\t%s", err.Error())
+		if resend {
+			log.Infof("write: RESEND:\t%s", err.Error())
 			fmt.Fprintf(statusfp, "RESEND:\t%s\n", err.Error())
 			return nil
 		}
@@ -11,10 +11,8 @@ import (
 	"os"
 
 	"crypto/ed25519"
-	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
-	"github.com/mutecomm/mute/mix/client"
 	"github.com/mutecomm/mute/util"
 )
 
@@ -39,8 +37,8 @@ func (pe *ProtoEngine) fetch(
 	var privkey [ed25519.PrivateKeySize]byte
 	copy(privkey[:], pk)
 	log.Debugf("lastMessageTime=%d", lastMessageTime)
-	messages, err := client.ListMessages(&privkey, lastMessageTime, server,
-		def.CACert)
+	messages, err := pe.transport.ListMessages(&privkey, lastMessageTime,
+		server)
 	if err != nil {
 		// TODO: handle this better
 		if err.Error() == "accountdb: Nothing found" {
@@ -60,8 +58,8 @@ func (pe *ProtoEngine) fetch(
 	*/
 	scanner := bufio.NewScanner(command)
 	for _, message := range messages {
-		msg, err := client.FetchMessage(&privkey, message.MessageID, server,
-			def.CACert)
+		msg, err := pe.transport.FetchMessage(&privkey, message.MessageID,
+			server)
 		if err != nil {
 			return log.Error(err)
 		}
@@ -7,15 +7,17 @@ package protoengine
 
 import (
 	"errors"
+	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/frankbraun/codechain/util/home"
 	"github.com/mutecomm/mute/def"
 	"github.com/mutecomm/mute/def/version"
 	"github.com/mutecomm/mute/log"
+	"github.com/mutecomm/mute/mix/client"
 	"github.com/mutecomm/mute/util"
 	"github.com/mutecomm/mute/util/descriptors"
+	"github.com/mutecomm/mute/util/dialer"
 	"github.com/urfave/cli"
 )
 
@@ -26,7 +28,7 @@ const (
 )
 
 var (
-	defaultHomeDir = home.AppDataDir("mute", false)
+	defaultHomeDir = util.AppDataDir("mute")
 	defaultLogDir  = filepath.Join(defaultHomeDir, "log")
 	errExit        = errors.New("cryptengine: requests exit")
 )
@@ -37,6 +39,7 @@ type ProtoEngine struct {
 	accdHost  string
 	accdPort  string
 	homedir   string
+	transport client.Transport
 	app       *cli.App
 	err       error
 }
@@ -53,12 +56,28 @@ func (pe *ProtoEngine) prepare(c *cli.Context) error {
 	}
 
 	// initialize logging framework
+	log.SetPrivate(c.GlobalBool("private-logs"))
 	err = log.Init(c.GlobalString("loglevel"), "proto",
 		c.GlobalString("logdir"), c.GlobalBool("logconsole"))
 	if err != nil {
 		return err
 	}
 
+	// route all connections through proxy, if necessary
+	if err := dialer.SetProxy(c.GlobalString("proxy")); err != nil {
+		return err
+	}
+
+	// reuse connections across server calls
+	dialer.SetMaxIdle(c.GlobalInt("max-idle"))
+
+	// account network usage of the session (see mutectrl)
+	if c.GlobalInt64("data-cap") < 0 {
+		return log.Error("--data-cap must not be negative")
+	}
+	dialer.SetDataCap(c.GlobalInt64("data-cap"))
+	dialer.SetStatsFile(os.Getenv("MUTE_NETSTATS"))
+
 	// initialize file descriptors
 	pe.fileTable, err = descriptors.NewTable(c)
 	if err != nil {
@@ -66,7 +85,24 @@ func (pe *ProtoEngine) prepare(c *cli.Context) error {
 	}
 
 	// configure
-	return def.InitMuteFromFile(pe.homedir)
+	if err := def.InitMuteFromFile(pe.homedir); err != nil {
+		return err
+	}
+
+	// select message transport
+	switch c.GlobalString("transport") {
+	case "mix":
+		pe.transport = &client.Mix{CACert: def.CACert}
+	case "loopback":
+		mailbox := c.GlobalString("mailbox")
+		if mailbox == "" {
+			mailbox = filepath.Join(pe.homedir, "mailbox")
+		}
+		pe.transport = &client.Loopback{Dir: mailbox}
+	default:
+		return log.Errorf("unknown --transport: %s", c.GlobalString("transport"))
+	}
+	return nil
 }
 
 // New returns a new Mute proto engine.
@@ -77,13 +113,15 @@ func New() *ProtoEngine {
 	pe.app.Version = version.Number
 	pe.app.Flags = []cli.Flag{
 		cli.StringFlag{
-			Name:  "homedir",
-			Value: defaultHomeDir,
-			Usage: "set home directory",
+			Name:   "homedir",
+			Value:  defaultHomeDir,
+			EnvVar: "MUTE_HOMEDIR",
+			Usage:  "set home directory",
 		},
 		cli.StringFlag{
-			Name:  "acchost",
-			Usage: "alternative hostname for account server",
+			Name:   "acchost",
+			EnvVar: "MUTE_ACCHOST",
+			Usage:  "alternative hostname for account server",
 		},
 		descriptors.InputFDFlag,
 		descriptors.OutputFDFlag,
@@ -91,18 +129,53 @@ func New() *ProtoEngine {
 		descriptors.PassphraseFDFlag,
 		descriptors.CommandFDFlag,
 		cli.StringFlag{
-			Name:  "loglevel",
-			Value: "info",
-			Usage: "logging level {trace, debug, info, warn, error, critical}",
+			Name:   "loglevel",
+			Value:  "info",
+			EnvVar: "MUTE_LOGLEVEL",
+			Usage:  "logging level {trace, debug, info, warn, error, critical}",
 		},
 		cli.StringFlag{
-			Name:  "logdir",
-			Value: defaultLogDir,
-			Usage: "directory to log output",
+			Name:   "logdir",
+			Value:  defaultLogDir,
+			EnvVar: "MUTE_LOGDIR",
+			Usage:  "directory to log output",
 		},
 		cli.BoolFlag{
-			Name:  "logconsole",
-			Usage: "enable logging to console",
+			Name:   "logconsole",
+			EnvVar: "MUTE_LOGCONSOLE",
+			Usage:  "enable logging to console",
+		},
+		cli.BoolFlag{
+			Name:   "private-logs",
+			EnvVar: "MUTE_PRIVATE_LOGS",
+			Usage:  "mask identities in log output",
+		},
+		cli.StringFlag{
+			Name:   "proxy",
+			EnvVar: "MUTE_PROXY",
+			Usage:  "SOCKS5 proxy for all connections (e.g., socks5://127.0.0.1:9050 for Tor)",
+		},
+		cli.IntFlag{
+			Name:   "max-idle",
+			Value:  dialer.DefaultMaxIdle,
+			EnvVar: "MUTE_MAX_IDLE",
+			Usage:  "maximum number of idle connections per server kept for reuse (0 disables reuse)",
+		},
+		cli.Int64Flag{
+			Name:   "data-cap",
+			EnvVar: "MUTE_DATA_CAP",
+			Usage:  "maximum number of bytes transferred per session (0 means no cap)",
+		},
+		cli.StringFlag{
+			Name:   "transport",
+			Value:  "mix",
+			EnvVar: "MUTE_TRANSPORT",
+			Usage:  "message transport {mix, loopback}",
+		},
+		cli.StringFlag{
+			Name:   "mailbox",
+			EnvVar: "MUTE_MAILBOX",
+			Usage:  "mailbox directory of --transport loopback (default: HOMEDIR/mailbox)",
 		},
 	}
 	pe.app.Before = func(c *cli.Context) error {
@@ -198,6 +271,7 @@ func New() *ProtoEngine {
 
 // Run runs the proto engine with the given args.
 func (pe *ProtoEngine) Run(args []string) error {
+	defer dialer.FlushStats()
 	pe.app.Name = args[0]
 	if err := pe.app.Run(args); err != nil {
 		return err
@@ -0,0 +1,138 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package protoengine
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/mix/client"
+	"github.com/mutecomm/mute/util/descriptors"
+)
+
+// fakeTransport is an in-memory client.Transport.
+type fakeTransport struct {
+	delivered [][]byte
+	resend    error // returned as resend error by Deliver, if set
+	accounts  map[[ed25519.PublicKeySize]byte]map[string][]byte
+}
+
+func (ft *fakeTransport) Deliver(mo *client.MessageOutput) (bool, error) {
+	if ft.resend != nil {
+		return true, ft.resend
+	}
+	ft.delivered = append(ft.delivered, mo.Message)
+	return false, nil
+}
+
+func pubKey(privkey *[ed25519.PrivateKeySize]byte) [ed25519.PublicKeySize]byte {
+	var pubkey [ed25519.PublicKeySize]byte
+	copy(pubkey[:], privkey[32:])
+	return pubkey
+}
+
+func (ft *fakeTransport) ListMessages(
+	privkey *[ed25519.PrivateKeySize]byte,
+	lastMessageTime int64,
+	server string,
+) ([]client.MessageMeta, error) {
+	var messages []client.MessageMeta
+	for id := range ft.accounts[pubKey(privkey)] {
+		messages = append(messages, client.MessageMeta{
+			MessageID:   []byte(id),
+			ReceiveTime: 42,
+		})
+	}
+	return messages, nil
+}
+
+func (ft *fakeTransport) FetchMessage(
+	privkey *[ed25519.PrivateKeySize]byte,
+	messageID []byte,
+	server string,
+) ([]byte, error) {
+	msg, ok := ft.accounts[pubKey(privkey)][string(messageID)]
+	if !ok {
+		return nil, errors.New("message not found")
+	}
+	return msg, nil
+}
+
+func TestDeliverTransport(t *testing.T) {
+	ft := &fakeTransport{}
+	pe := New()
+	pe.transport = ft
+	mo := &client.MessageOutput{
+		Message: []byte("envelope"),
+		To:      "mix@mute.berlin",
+		Resend:  true,
+	}
+	envelope := base64.Encode(mo.Marshal())
+	var status bytes.Buffer
+	if err := pe.deliver(&status, strings.NewReader(envelope)); err != nil {
+		t.Fatal(err)
+	}
+	if len(ft.delivered) != 1 || string(ft.delivered[0]) != "envelope" {
+		t.Errorf("wrong delivered messages: %q", ft.delivered)
+	}
+	// failed delivery
+	ft.resend = errors.New("try again")
+	if err := pe.deliver(&status, strings.NewReader(envelope)); err != nil {
+		t.Fatal(err)
+	}
+	if status.String() != "RESEND:\ttry again\n" {
+		t.Errorf("wrong status output: %q", status.String())
+	}
+}
+
+func TestFetchTransport(t *testing.T) {
+	_, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var priv [ed25519.PrivateKeySize]byte
+	copy(priv[:], privkey)
+	ft := &fakeTransport{
+		accounts: map[[ed25519.PublicKeySize]byte]map[string][]byte{
+			pubKey(&priv): {"id": []byte("message")},
+		},
+	}
+	pe := New()
+	pe.transport = ft
+	// passphrase file descriptor contains the private key of the account
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(base64.Encode(priv[:]) + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	pe.fileTable = &descriptors.Table{PassphraseFP: r}
+	var output, status bytes.Buffer
+	err = pe.fetch(&output, &status, "mix.mute.berlin", 0,
+		strings.NewReader("NEXT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.Encode([]byte("message"))
+	if output.String() != enc {
+		t.Errorf("wrong output: %q", output.String())
+	}
+	expected := "MESSAGEID:\t" + base64.Encode([]byte("id")) + "\n" +
+		"LENGTH:\t" + strconv.Itoa(len(enc)) + "\n" +
+		"RECEIVETIME:\t42\n" +
+		"NONE\n"
+	if status.String() != expected {
+		t.Errorf("wrong status output: %q", status.String())
+	}
+}
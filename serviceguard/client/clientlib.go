@@ -113,6 +113,36 @@ func (c *Client) UnlockToken(tokenHash []byte) {
 	c.walletStore.UnlockToken(tokenHash)
 }
 
+// GetReservedToken returns the token identified by tokenHash regardless of its
+// lock. It allows to retry an operation with the token it reserved before.
+func (c *Client) GetReservedToken(tokenHash []byte) (*TokenEntry, error) {
+	return c.walletStore.GetToken(tokenHash, -1)
+}
+
+// Tokens returns all tokens of the wallet, including locked tokens and tokens
+// in reissue. It allows to back up the wallet.
+func (c *Client) Tokens() ([]*TokenEntry, error) {
+	tokenHashes, err := c.walletStore.ListTokens()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*TokenEntry, 0, len(tokenHashes))
+	for _, tokenHash := range tokenHashes {
+		tokenEntry, err := c.walletStore.GetToken(tokenHash, -1)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tokenEntry)
+	}
+	return tokens, nil
+}
+
+// AddToken adds tokenEntry to the wallet as is. It allows to restore the
+// tokens of a wallet backup.
+func (c *Client) AddToken(tokenEntry *TokenEntry) error {
+	return c.walletStore.SetToken(*tokenEntry)
+}
+
 // splitKey splits the wallet private key into public and private key.
 func splitKey(privkey *[ed25519.PrivateKeySize]byte) (*[ed25519.PublicKeySize]byte, *[ed25519.PrivateKeySize]byte) {
 	var pubkey [ed25519.PublicKeySize]byte
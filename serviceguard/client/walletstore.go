@@ -25,6 +25,7 @@ type WalletStore interface {
 	GetInReissue() (tokenHash []byte)                                                      // Get next token with interrupted reissue
 	GetBalanceOwn(usage string) int64                                                      // Get the number of tokens for usage owned by self
 	GetBalance(usage string, owner *[ed25519.PublicKeySize]byte) int64                     // Get the number of tokens for usage owner by owner, or by anybody but myself if owner==nil
+	ListTokens() ([][]byte, error)                                                         // Return the hashes of all tokens in store
 	ExpireUnusable() bool                                                                  // Expire unusable tokens, returns true if it should be called again
 }
 
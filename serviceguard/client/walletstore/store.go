@@ -68,6 +68,7 @@ CREATE TABLE IF NOT EXISTS walletState (
 	countOwnerQuery     = `SELECT COUNT(*) FROM walletTokens WHERE LockID=0 AND HasState=0 AND OwnedSelf=0 AND UsageStr=? AND OwnerPubKey=?;`
 	countAnyQuery       = `SELECT COUNT(*) FROM walletTokens WHERE LockID=0 AND HasState=0 AND OwnedSelf=0 AND UsageStr=?;`
 	finalExpireQuery    = `SELECT Hash FROM walletTokens WHERE Expire<? LIMIT 10;`
+	listTokensQuery     = `SELECT Hash FROM walletTokens ORDER BY Expire ASC;`
 )
 
 // MaxLockAge is the maximum time a lock may persist
@@ -99,6 +100,7 @@ type Storage struct {
 	countOwnerQuery     *sql.Stmt
 	countAnyQuery       *sql.Stmt
 	finalExpireQuery    *sql.Stmt
+	listTokensQuery     *sql.Stmt
 	cacheMutex          *sync.RWMutex
 	cache               *CacheData
 }
@@ -201,6 +203,9 @@ func (ws *Storage) initDB() (err error) {
 	if ws.finalExpireQuery, err = ws.DB.Prepare(finalExpireQuery); err != nil {
 		return err
 	}
+	if ws.listTokensQuery, err = ws.DB.Prepare(listTokensQuery); err != nil {
+		return err
+	}
 	ws.CleanLocks(false)
 	return nil
 }
@@ -321,10 +326,10 @@ func (ws *Storage) writeCache() error {
 
 // readCache reads the cache from the database
 func (ws *Storage) readCache() {
-	var data string
+	var tmp, data string
 	ws.cacheMutex.Lock()
 	defer ws.cacheMutex.Unlock()
-	err := ws.getStateQuery.QueryRow("CONFIGCACHE").Scan(&data)
+	err := ws.getStateQuery.QueryRow("CONFIGCACHE").Scan(&tmp, &data)
 	if err != nil {
 		return
 	}
@@ -472,6 +477,32 @@ func (ws *Storage) GetInReissue() []byte {
 	return tokenHash
 }
 
+// ListTokens returns the hashes of all tokens in the walletstore, including
+// locked tokens and tokens in reissue
+func (ws *Storage) ListTokens() ([][]byte, error) {
+	rows, err := ws.listTokensQuery.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokenHashes [][]byte
+	for rows.Next() {
+		var hashS string
+		if err := rows.Scan(&hashS); err != nil {
+			return nil, err
+		}
+		tokenHash, err := hex.DecodeString(hashS)
+		if err != nil {
+			return nil, err
+		}
+		tokenHashes = append(tokenHashes, tokenHash)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokenHashes, nil
+}
+
 // GetBalanceOwn returns the number of usable tokens available for usage that are owned by self
 func (ws *Storage) GetBalanceOwn(usage string) int64 {
 	var count int64
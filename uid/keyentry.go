@@ -25,6 +25,12 @@ import (
 // All valid ciphersuite strings are predefined and contain only upper-case letters.
 const DefaultCiphersuite string = "NACL HKDF AES256-CTR SHA512-HMAC ED25519 ECDHE25519"
 
+// Ciphersuites returns all ciphersuites supported by this implementation.
+// The DefaultCiphersuite is always the first entry.
+func Ciphersuites() []string {
+	return []string{DefaultCiphersuite}
+}
+
 // A KeyEntry describes a key in Mute.
 type KeyEntry struct {
 	CIPHERSUITE   string // ciphersuite for which the key may be used. Example: "NACL HKDF AES-CTR256 SHA512-HMAC ED25519 ECDHE25519"
@@ -41,8 +41,8 @@ type KeyInit struct {
 	SIGNATURE string // signature of contents by UIDMessage.UIDContent.SIGKEY
 }
 
-// MaxNotAfter defines the number of seconds the NOTAFTER field of a KeyInit
-// message can be in the future.
+// MaxNotAfter defines the default number of seconds the NOTAFTER field of a
+// KeyInit message can be in the future (see KeyInitMax).
 const MaxNotAfter = uint64(90 * 24 * 60 * 60) // 90 days
 
 // NewJSONKeyInit returns a new KeyInit message initialized with the parameters
@@ -265,11 +265,24 @@ func (msg *Message) sessionAnchor(
 // fallback determines if the key may serve as a fallback key.
 // repoURI is URI of the corresponding KeyInit repository.
 // Necessary randomness is read from rand.
+// notafter can be at most MaxNotAfter seconds in the future.
 func (msg *Message) KeyInit(
 	msgcount, notafter, notbefore uint64,
 	fallback bool,
 	repoURI, mixaddress, nymaddress string,
 	rand io.Reader,
+) (ki *KeyInit, pubKeyHash, privateKey string, err error) {
+	return msg.KeyInitMax(msgcount, notafter, notbefore, MaxNotAfter, fallback,
+		repoURI, mixaddress, nymaddress, rand)
+}
+
+// KeyInitMax is like KeyInit, but notafter can be at most maxNotAfter seconds
+// (instead of MaxNotAfter) in the future.
+func (msg *Message) KeyInitMax(
+	msgcount, notafter, notbefore, maxNotAfter uint64,
+	fallback bool,
+	repoURI, mixaddress, nymaddress string,
+	rand io.Reader,
 ) (ki *KeyInit, pubKeyHash, privateKey string, err error) {
 	var keyInit KeyInit
 	// time checks
@@ -281,7 +294,7 @@ func (msg *Message) KeyInit(
 		log.Error(ErrExpired)
 		return nil, "", "", ErrExpired
 	}
-	if notafter > uint64(times.Now())+MaxNotAfter {
+	if notafter > uint64(times.Now())+maxNotAfter {
 		log.Error(ErrFuture)
 		return nil, "", "", ErrFuture
 	}
@@ -126,6 +126,35 @@ func TestKeyInitFailure(t *testing.T) {
 	}
 }
 
+func TestKeyInitMax(t *testing.T) {
+	msg, err := Create("test@mute.berlin", false, "", "", Strict,
+		hashchain.TestEntry, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// shorter maximum than default
+	_, _, _, err = msg.KeyInitMax(0, uint64(times.ThirtyDaysLater()), 0,
+		7*times.Day, false, "mute.berlin", "", "", cipher.RandReader)
+	if err != ErrFuture {
+		t.Error("should fail")
+	}
+	_, _, _, err = msg.KeyInitMax(0, uint64(times.Now())+7*times.Day, 0,
+		7*times.Day, false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Error(err)
+	}
+	// longer maximum than default
+	ki, _, _, err := msg.KeyInitMax(0, uint64(times.OneYearLater()), 0,
+		400*times.Day, false, "mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// verification only checks expiry
+	if err := ki.Verify([]string{"mute.berlin"}, msg.UIDContent.SIGKEY.PUBKEY); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestVerifyFailure(t *testing.T) {
 	msg, err := Create("test@mute.berlin", false, "", "", Strict,
 		hashchain.TestEntry, cipher.RandReader)
@@ -0,0 +1,75 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/frankbraun/codechain/util/home"
+	"github.com/mutecomm/mute/log"
+)
+
+// appDataDir returns the application data directory for the operating system
+// goos. See AppDataDir for details.
+func appDataDir(goos, appName string) string {
+	if goos != "linux" || appName == "" || appName == "." {
+		return home.AppDataDir(appName, false)
+	}
+	appName = strings.ToLower(strings.TrimPrefix(appName, "."))
+	// honor XDG base directory specification
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, appName)
+	}
+	homeDir := os.Getenv("HOME")
+	if homeDir == "" {
+		return home.AppDataDir(appName, false)
+	}
+	return filepath.Join(homeDir, ".local", "share", appName)
+}
+
+// AppDataDir returns the default directory for storing application data for
+// the application appName. On Linux it honors the XDG base directory
+// specification: $XDG_DATA_HOME/appName, if XDG_DATA_HOME is set, and
+// ~/.local/share/appName otherwise. On all other operating systems the
+// platform specific directory is returned (see home.AppDataDir).
+func AppDataDir(appName string) string {
+	return appDataDir(runtime.GOOS, appName)
+}
+
+// LegacyAppDataDir returns the directory which was used for storing
+// application data for the application appName before AppDataDir honored the
+// XDG base directory specification.
+func LegacyAppDataDir(appName string) string {
+	return home.AppDataDir(appName, false)
+}
+
+// MoveDir atomically moves the directory from to the directory to. The
+// directory to must either not exist or be empty, otherwise MoveDir refuses
+// to move anything. Both directories must reside on the same file system.
+func MoveDir(from, to string) error {
+	entries, err := ioutil.ReadDir(to)
+	if err != nil && !os.IsNotExist(err) {
+		return log.Error(err)
+	}
+	if len(entries) > 0 {
+		return log.Errorf("util: directory '%s' is not empty", to)
+	}
+	if err == nil {
+		// remove empty target directory, otherwise rename fails
+		if err := os.Remove(to); err != nil {
+			return log.Error(err)
+		}
+	} else if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+		return log.Error(err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return log.Errorf("util: cannot move '%s' to '%s': %s", from, to, err)
+	}
+	return nil
+}
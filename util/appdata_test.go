@@ -0,0 +1,99 @@
+// Copyright (c) 2015 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/frankbraun/codechain/util/home"
+)
+
+func TestAppDataDir(t *testing.T) {
+	xdg, xdgSet := os.LookupEnv("XDG_DATA_HOME")
+	homeDir, homeSet := os.LookupEnv("HOME")
+	defer func() {
+		if xdgSet {
+			os.Setenv("XDG_DATA_HOME", xdg)
+		} else {
+			os.Unsetenv("XDG_DATA_HOME")
+		}
+		if homeSet {
+			os.Setenv("HOME", homeDir)
+		} else {
+			os.Unsetenv("HOME")
+		}
+	}()
+	os.Setenv("HOME", "/home/alice")
+	// XDG_DATA_HOME set
+	os.Setenv("XDG_DATA_HOME", "/tmp/xdg")
+	if dir := appDataDir("linux", "mute"); dir != filepath.Join("/tmp/xdg", "mute") {
+		t.Errorf("appDataDir() = %s, expected /tmp/xdg/mute", dir)
+	}
+	// relative XDG_DATA_HOME is ignored (as required by the spec)
+	os.Setenv("XDG_DATA_HOME", "xdg")
+	fallback := filepath.Join("/home/alice", ".local", "share", "mute")
+	if dir := appDataDir("linux", "mute"); dir != fallback {
+		t.Errorf("appDataDir() = %s, expected %s", dir, fallback)
+	}
+	// XDG_DATA_HOME unset
+	os.Unsetenv("XDG_DATA_HOME")
+	if dir := appDataDir("linux", "mute"); dir != fallback {
+		t.Errorf("appDataDir() = %s, expected %s", dir, fallback)
+	}
+	// XDG_DATA_HOME is ignored on other operating systems
+	os.Setenv("XDG_DATA_HOME", "/tmp/xdg")
+	if dir := appDataDir("darwin", "mute"); dir != home.AppDataDir("mute", false) {
+		t.Errorf("appDataDir() = %s, expected %s", dir,
+			home.AppDataDir("mute", false))
+	}
+}
+
+func TestMoveDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "util_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	from := filepath.Join(tmpdir, "from")
+	to := filepath.Join(tmpdir, "share", "to")
+	if err := os.Mkdir(from, 0700); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("data")
+	if err := ioutil.WriteFile(filepath.Join(from, "file"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// refuse to move into non-empty directory
+	if err := os.MkdirAll(to, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(to, "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveDir(from, to); err == nil {
+		t.Fatal("MoveDir() should refuse to move into non-empty directory")
+	}
+	// move into empty directory
+	if err := os.Remove(filepath.Join(to, "file")); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveDir(from, to); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(to, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Error("moved file differs")
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Error("source directory still exists")
+	}
+}
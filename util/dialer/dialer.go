@@ -0,0 +1,88 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dialer implements the dialer used for all outgoing network
+// connections of Mute. If a SOCKS5 proxy (like Tor) is set, all connections
+// are made through the proxy. Connections fail closed: if the proxy is not
+// reachable, dialing fails instead of falling back to a direct connection.
+package dialer
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/mutecomm/mute/log"
+	"golang.org/x/net/proxy"
+)
+
+// ErrProxyScheme is returned if the proxy URL does not use the socks5 scheme.
+var ErrProxyScheme = errors.New("dialer: proxy must be a socks5:// URL")
+
+var (
+	mutex    sync.RWMutex
+	proxyURL *url.URL
+)
+
+// SetProxy sets the SOCKS5 proxy for all connections (for example,
+// socks5://127.0.0.1:9050 for Tor). An empty proxyAddr disables the proxy.
+func SetProxy(proxyAddr string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	// do not reuse connections made with the previous setting
+	pool.Lock()
+	resetPool()
+	pool.Unlock()
+	if proxyAddr == "" {
+		proxyURL = nil
+		return nil
+	}
+	u, err := url.Parse(proxyAddr)
+	if err != nil {
+		return log.Error(err)
+	}
+	if (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Host == "" {
+		return log.Error(ErrProxyScheme)
+	}
+	proxyURL = u
+	return nil
+}
+
+// Proxy returns the SOCKS5 proxy set with SetProxy (empty if not set).
+func Proxy() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if proxyURL == nil {
+		return ""
+	}
+	return proxyURL.String()
+}
+
+// Dial connects to the address addr on the named network, through the proxy
+// if one is set. Host names are resolved by the proxy.
+// The transferred bytes are counted (see Stats) and the data cap is enforced
+// (see SetDataCap).
+func Dial(network, addr string) (net.Conn, error) {
+	return newCountingConn(func() (net.Conn, error) {
+		mutex.RLock()
+		u := proxyURL
+		mutex.RUnlock()
+		if u == nil {
+			return net.Dial(network, addr)
+		}
+		d, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return d.Dial(network, addr)
+	})
+}
+
+// Transport returns a new HTTP transport which makes all connections with
+// Dial. Proxy settings from the environment (HTTP_PROXY etc.) are ignored.
+func Transport() *http.Transport {
+	return &http.Transport{Dial: Dial}
+}
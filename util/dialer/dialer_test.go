@@ -0,0 +1,170 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dialer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// fakeSOCKS5 is a minimal SOCKS5 server which forwards all connections to
+// backend and records the requested addresses.
+type fakeSOCKS5 struct {
+	l         net.Listener
+	backend   string
+	requested chan string
+}
+
+func newFakeSOCKS5(t *testing.T, backend string) *fakeSOCKS5 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSOCKS5{l: l, backend: backend, requested: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSOCKS5) handle(conn net.Conn) {
+	defer conn.Close()
+	// greeting: VER NMETHODS METHODS
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0}) // no authentication
+	// request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return
+		}
+		host = string(buf[:n])
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+	s.requested <- net.JoinHostPort(host, strconv.Itoa(int(port)))
+	backend, err := net.Dial("tcp", s.backend)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}) // connection refused
+		return
+	}
+	defer backend.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) // succeeded
+	go io.Copy(backend, conn)
+	io.Copy(conn, backend)
+}
+
+func TestDialProxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer srv.Close()
+	socks := newFakeSOCKS5(t, srv.Listener.Addr().String())
+	defer socks.l.Close()
+	if err := SetProxy("socks5://" + socks.l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer SetProxy("")
+	// host names must be resolved by the proxy
+	client := &http.Client{Transport: Transport()}
+	resp, err := client.Get("http://keyserver.mute.invalid:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("body == %q", body)
+	}
+	if addr := <-socks.requested; addr != "keyserver.mute.invalid:8080" {
+		t.Errorf("requested address == %s", addr)
+	}
+}
+
+func TestDialProxyUnreachable(t *testing.T) {
+	// target which could be reached directly
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// address of proxy which is not running
+	p, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyAddr := p.Addr().String()
+	p.Close()
+	if err := SetProxy("socks5://" + proxyAddr); err != nil {
+		t.Fatal(err)
+	}
+	defer SetProxy("")
+	conn, err := Dial("tcp", l.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Fatal("dial should fail if proxy is unreachable")
+	}
+}
+
+func TestSetProxy(t *testing.T) {
+	defer SetProxy("")
+	if err := SetProxy("http://127.0.0.1:8080"); err != ErrProxyScheme {
+		t.Error("should fail with ErrProxyScheme")
+	}
+	if err := SetProxy("socks5://"); err != ErrProxyScheme {
+		t.Error("should fail with ErrProxyScheme")
+	}
+	if err := SetProxy("socks5://127.0.0.1:9050"); err != nil {
+		t.Fatal(err)
+	}
+	if Proxy() != "socks5://127.0.0.1:9050" {
+		t.Errorf("Proxy() == %s", Proxy())
+	}
+	if err := SetProxy(""); err != nil {
+		t.Fatal(err)
+	}
+	if Proxy() != "" {
+		t.Errorf("Proxy() == %s", Proxy())
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dialer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxIdle is the default maximum number of idle (keep-alive)
+// connections per host kept by pooled transports.
+const DefaultMaxIdle = 2
+
+// ErrCertLoad is returned if a CA certificate could not be loaded.
+var ErrCertLoad = errors.New("dialer: certificate load failed")
+
+// pool contains the transports shared by all server calls of the running
+// engine, one for every CA certificate.
+var pool = struct {
+	sync.Mutex
+	maxIdle    int
+	transports map[string]*http.Transport
+}{
+	maxIdle:    DefaultMaxIdle,
+	transports: make(map[string]*http.Transport),
+}
+
+// SetMaxIdle sets the maximum number of idle connections per host kept by
+// pooled transports. A maxIdle of 0 disables keep-alive connections.
+func SetMaxIdle(maxIdle int) {
+	pool.Lock()
+	defer pool.Unlock()
+	pool.maxIdle = maxIdle
+	resetPool()
+}
+
+// resetPool closes all idle connections and empties the pool.
+// pool must be locked.
+func resetPool() {
+	for _, tr := range pool.transports {
+		tr.CloseIdleConnections()
+	}
+	pool.transports = make(map[string]*http.Transport)
+}
+
+// PooledTransport returns the transport which is shared by all calls to
+// servers verified with the CA certificate cacert (the system roots, if
+// cacert is empty). Connections are made with Dial and reused across calls.
+func PooledTransport(cacert []byte) (*http.Transport, error) {
+	pool.Lock()
+	defer pool.Unlock()
+	if tr, ok := pool.transports[string(cacert)]; ok {
+		return tr, nil
+	}
+	tr := Transport()
+	if len(cacert) > 0 {
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(cacert) {
+			return nil, ErrCertLoad
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+	if pool.maxIdle <= 0 {
+		tr.DisableKeepAlives = true
+	} else {
+		tr.MaxIdleConnsPerHost = pool.maxIdle
+	}
+	pool.transports[string(cacert)] = tr
+	return tr, nil
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dialer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// ErrDataCap is returned if the data cap set with SetDataCap is reached.
+var ErrDataCap = errors.New("dialer: data cap reached, transfer aborted")
+
+// stats contains the network usage of the session.
+var stats struct {
+	sync.Mutex
+	filename string // usage of all processes of the session
+	sent     int64  // bytes sent by this process (not added to filename yet)
+	received int64  // bytes received by this process (not added to filename yet)
+	dataCap  int64  // maximum number of bytes per session (0 = no cap)
+}
+
+// SetDataCap sets the maximum number of bytes which can be transferred
+// (sent and received) in a session. Afterwards all transfers are aborted with
+// ErrDataCap. A dataCap of 0 disables the cap.
+func SetDataCap(dataCap int64) {
+	stats.Lock()
+	defer stats.Unlock()
+	stats.dataCap = dataCap
+}
+
+// DataCap returns the data cap set with SetDataCap.
+func DataCap() int64 {
+	stats.Lock()
+	defer stats.Unlock()
+	return stats.dataCap
+}
+
+// SetStatsFile sets the file which accumulates the network usage of all
+// processes (engines) of a session. See FlushStats.
+func SetStatsFile(filename string) {
+	stats.Lock()
+	defer stats.Unlock()
+	stats.filename = filename
+}
+
+// readStatsFile returns the sum of all entries in the stats file filename.
+func readStatsFile(filename string) (sent, received int64, err error) {
+	if filename == "" {
+		return 0, 0, nil
+	}
+	fp, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, log.Error(err)
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		var s, r int64
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d", &s, &r); err != nil {
+			return 0, 0, log.Error(err)
+		}
+		sent += s
+		received += r
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, log.Error(err)
+	}
+	return
+}
+
+// Stats returns the number of bytes sent and received in the session so far.
+func Stats() (sent, received int64, err error) {
+	stats.Lock()
+	defer stats.Unlock()
+	sent, received, err = readStatsFile(stats.filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	return sent + stats.sent, received + stats.received, nil
+}
+
+// FlushStats adds the network usage of this process to the stats file of
+// the session (if set). Has to be called before a spawned engine exits.
+func FlushStats() error {
+	stats.Lock()
+	defer stats.Unlock()
+	if stats.filename == "" || (stats.sent == 0 && stats.received == 0) {
+		return nil
+	}
+	fp, err := os.OpenFile(stats.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0600)
+	if err != nil {
+		return log.Error(err)
+	}
+	defer fp.Close()
+	_, err = fmt.Fprintf(fp, "%d %d\n", stats.sent, stats.received)
+	if err != nil {
+		return log.Error(err)
+	}
+	stats.sent = 0
+	stats.received = 0
+	return nil
+}
+
+// usage returns the bytes transferred by other processes of the session
+// and the data cap.
+func usage() (other, dataCap int64, err error) {
+	stats.Lock()
+	filename := stats.filename
+	dataCap = stats.dataCap
+	stats.Unlock()
+	if dataCap == 0 {
+		return 0, 0, nil
+	}
+	sent, received, err := readStatsFile(filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	return sent + received, dataCap, nil
+}
+
+// checkDataCap returns ErrDataCap, if the data cap is reached by this process
+// together with the usage other of other processes.
+func checkDataCap(other, dataCap int64) error {
+	stats.Lock()
+	defer stats.Unlock()
+	if dataCap > 0 && other+stats.sent+stats.received >= dataCap {
+		return log.Error(ErrDataCap)
+	}
+	return nil
+}
+
+// account adds the given number of bytes to the usage of this process.
+func account(sent, received int64) {
+	stats.Lock()
+	defer stats.Unlock()
+	stats.sent += sent
+	stats.received += received
+}
+
+// countingConn counts the bytes transferred over a connection and aborts
+// further transfers once the data cap is reached.
+type countingConn struct {
+	net.Conn
+	other   int64 // usage of other processes when connection was made
+	dataCap int64
+}
+
+// newCountingConn returns a countingConn for the connection made by dial,
+// which is not called if the data cap is already reached.
+func newCountingConn(dial func() (net.Conn, error)) (net.Conn, error) {
+	other, dataCap, err := usage()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDataCap(other, dataCap); err != nil {
+		return nil, err
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, other: other, dataCap: dataCap}, nil
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	if err := checkDataCap(c.other, c.dataCap); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	account(0, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	if err := checkDataCap(c.other, c.dataCap); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(b)
+	account(int64(n), 0)
+	return n, err
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dialer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func resetStats() {
+	stats.Lock()
+	defer stats.Unlock()
+	stats.filename = ""
+	stats.sent = 0
+	stats.received = 0
+	stats.dataCap = 0
+}
+
+func newMessageServer(size int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("m", size)))
+	}))
+}
+
+func fetch(URL string) ([]byte, error) {
+	client := &http.Client{Transport: Transport()}
+	resp, err := client.Get(URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func TestStats(t *testing.T) {
+	resetStats()
+	defer resetStats()
+	srv := newMessageServer(4096)
+	defer srv.Close()
+	body, err := fetch(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, received, err := Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent == 0 {
+		t.Error("sent == 0")
+	}
+	if received < int64(len(body)) {
+		t.Errorf("received == %d < %d", received, len(body))
+	}
+	// add usage of this process to stats file and simulate a second process
+	tmpdir, err := ioutil.TempDir("", "dialer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	SetStatsFile(filepath.Join(tmpdir, "netstats"))
+	if err := FlushStats(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetch(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := FlushStats(); err != nil {
+		t.Fatal(err)
+	}
+	s, r, err := Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s <= sent || r < received+int64(len(body)) {
+		t.Errorf("stats not accumulated: sent=%d, received=%d", s, r)
+	}
+}
+
+func TestDataCap(t *testing.T) {
+	resetStats()
+	defer resetStats()
+	srv := newMessageServer(64 * 1024)
+	defer srv.Close()
+	SetDataCap(16 * 1024)
+	if _, err := fetch(srv.URL); err == nil {
+		t.Fatal("fetch should be aborted by data cap")
+	}
+	if _, err := Dial("tcp", srv.Listener.Addr().String()); err != ErrDataCap {
+		t.Errorf("Dial should fail with ErrDataCap: %v", err)
+	}
+	// disable cap
+	SetDataCap(0)
+	if _, err := fetch(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package engerr defines the typed errors returned by the Start methods of
+// the Mute engines. Programs embedding an engine can test for the error
+// classes with errors.Is.
+package engerr
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/mutecomm/go-sqlcipher/v4"
+	"github.com/mutecomm/mute/serviceguard/client"
+)
+
+// ErrNoTokens is the class of errors caused by a wallet without (usable)
+// tokens.
+var ErrNoTokens = errors.New("engerr: no tokens")
+
+// ErrDBLocked is the class of errors caused by a database which is locked by
+// another process.
+var ErrDBLocked = errors.New("engerr: database locked")
+
+// ErrNetworkDown is the class of errors caused by an unreachable network or
+// server.
+var ErrNetworkDown = errors.New("engerr: network down")
+
+// Error is an error returned by the Start method of an engine. It keeps the
+// original error Err, optionally annotated with a Hint for the user.
+// errors.Is(err, Kind) and errors.Is(err, Err) both hold.
+type Error struct {
+	Kind error  // ErrNoTokens, ErrDBLocked, ErrNetworkDown, or nil
+	Err  error  // original error
+	Hint string // annotation for the user (optional)
+}
+
+// Error returns the message of the original error, followed by the hint.
+func (e *Error) Error() string {
+	if e.Hint == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + "\n" + e.Hint
+}
+
+// Is reports whether target is the class of e.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// Unwrap returns the original error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns err as an *Error with its class determined from the
+// original error. If err is nil, nil is returned.
+func Classify(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Kind: kind(err), Err: err}
+}
+
+// kind returns the class of err (or nil, if err does not belong to a class).
+func kind(err error) error {
+	if errors.Is(err, client.ErrNoToken) || errors.Is(err, client.ErrNoUser) ||
+		errors.Is(err, client.ErrInsufficientFunds) {
+		return ErrNoTokens
+	}
+	var sqlErr sqlite3.Error
+	if errors.As(err, &sqlErr) &&
+		(sqlErr.Code == sqlite3.ErrBusy || sqlErr.Code == sqlite3.ErrLocked) {
+		return ErrDBLocked
+	}
+	// errors formatted with log.Errorf lose their type
+	if strings.Contains(err.Error(), "database is locked") {
+		return ErrDBLocked
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrNetworkDown
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engerr
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/mutecomm/go-sqlcipher/v4"
+	"github.com/mutecomm/mute/serviceguard/client"
+)
+
+func TestClassify(t *testing.T) {
+	if Classify(nil) != nil {
+		t.Error("Classify(nil) != nil")
+	}
+	other := errors.New("other")
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{client.ErrNoToken, ErrNoTokens},
+		{client.ErrNoUser, ErrNoTokens},
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, ErrDBLocked},
+		{errors.New("encdb: database is locked"), ErrDBLocked},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			ErrNetworkDown},
+		{other, nil},
+	}
+	for _, test := range tests {
+		err := Classify(test.err)
+		if err.Kind != test.kind {
+			t.Errorf("Classify(%v).Kind == %v != %v", test.err, err.Kind,
+				test.kind)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("errors.Is(%v, %v) failed", err, test.err)
+		}
+		if test.kind != nil && !errors.Is(err, test.kind) {
+			t.Errorf("errors.Is(%v, %v) failed", err, test.kind)
+		}
+		if errors.Is(err, ErrNetworkDown) != (test.kind == ErrNetworkDown) {
+			t.Errorf("%v classified wrongly", err)
+		}
+		// classifying twice changes nothing
+		if Classify(err) != err {
+			t.Error("Classify(err) != err")
+		}
+	}
+	err := &Error{Err: other, Hint: "hint"}
+	if err.Error() != "other\nhint" {
+		t.Errorf("err.Error() == %q", err.Error())
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package i18n
+
+// german is the German message catalog.
+var german = Catalog{
+	// mutectrl
+	"%d message(s) exported to '%s'\n":                         "%d Nachricht(en) nach '%s' exportiert\n",
+	"%d message(s) imported from '%s' (%d existing skipped)\n": "%d Nachricht(en) aus '%s' importiert (%d vorhandene übersprungen)\n",
+	"%s is not a Mute database: check --homedir\n":             "%s ist keine Mute-Datenbank: --homedir prüfen\n",
+	"Unfortunately, you do not have tokens, yet!\n" +
+		"Please send your \n" +
+		"WALLETPUBKEY\t%s\n" +
+		"per email to frank@cryptogroup.net and stay tuned!": "Leider haben Sie noch keine Token!\n" +
+		"Bitte senden Sie Ihren\n" +
+		"WALLETPUBKEY\t%s\n" +
+		"per E-Mail an frank@cryptogroup.net und bleiben Sie dran!",
+	"aborting...\n":                            "Abbruch...\n",
+	"active user ID: %s\n":                     "aktive Benutzer-ID: %s\n",
+	"announcing %s on port %d\n":               "%s wird auf Port %d angekündigt\n",
+	"contact %s already present\n":             "Kontakt %s bereits vorhanden\n",
+	"could not open browser for address: %s\n": "Browser konnte für Adresse nicht geöffnet werden: %s\n",
+	"databases use %d KDF iterations now\n":    "Datenbanken verwenden jetzt %d KDF-Iterationen\n",
+	"database files created\n":                 "Datenbankdateien erstellt\n",
+	"failed messages are resent automatically (%d delivery attempt(s) at most)\n": "fehlgeschlagene Nachrichten werden automatisch erneut gesendet (höchstens %d Zustellversuch(e))\n",
+	"initialization failed, try to update config\n":                               "Initialisierung fehlgeschlagen, versuche Konfiguration zu aktualisieren\n",
+	"log level set to '%s'\n":                                                     "Log-Level auf '%s' gesetzt\n",
+	"message %d sent to %s (%s)\n":                                                "Nachricht %d an %s gesendet (%s)\n",
+	"message added":                                                               "Nachricht hinzugefügt",
+	"message length: %d characters (%d of %d bytes)\n":                            "Nachrichtenlänge: %d Zeichen (%d von %d Bytes)\n",
+	"no system config found\n":                                                    "keine Systemkonfiguration gefunden\n",
+	"open browser for address: %s\n":                                              "Browser für Adresse öffnen: %s\n",
+	"passphrase correct, but database is damaged: restore from backup":            "Passphrase korrekt, aber Datenbank beschädigt: aus Backup wiederherstellen",
+	"pong from %s: round-trip time %s\n":                                          "Pong von %s: Umlaufzeit %s\n",
+	"read backup passphrase from fd %d (not echoed)\n":                            "Backup-Passphrase von FD %d lesen (keine Anzeige)\n",
+	"read backup passphrase from fd %d again (not echoed)\n":                      "Backup-Passphrase erneut von FD %d lesen (keine Anzeige)\n",
+	"read new passphrase from fd %d (not echoed)\n":                               "neue Passphrase von FD %d lesen (keine Anzeige)\n",
+	"read new passphrase from fd %d again (not echoed)\n":                         "neue Passphrase erneut von FD %d lesen (keine Anzeige)\n",
+	"read old passphrase from fd %d (not echoed)\n":                               "alte Passphrase von FD %d lesen (keine Anzeige)\n",
+	"read passphrase from fd %d (not echoed)\n":                                   "Passphrase von FD %d lesen (keine Anzeige)\n",
+	"read passphrase from fd %d again (not echoed)\n":                             "Passphrase erneut von FD %d lesen (keine Anzeige)\n",
+	"remote access allowed, requests require token\n":                             "Fernzugriff erlaubt, Anfragen benötigen Token\n",
+	"retiring %d nym address(es) until %s\n":                                      "%d Nym-Adresse(n) werden bis %s stillgelegt\n",
+	"sending messages every %s\n":                                                 "Nachrichten werden alle %s gesendet\n",
+	"support bundle with %d log file(s) written to '%s'\n":                        "Support-Paket mit %d Logdatei(en) nach '%s' geschrieben\n",
+	"token %d: imported %s token\n":                                               "Token %d: %s-Token importiert\n",
+	"type message (end with Ctrl-D on empty line):":                               "Nachricht eingeben (mit Strg-D in leerer Zeile beenden):",
+	"validation successful\n":                                                     "Validierung erfolgreich\n",
+	"wallet with %d token(s) backed up to '%s'\n":                                 "Wallet mit %d Token(s) in '%s' gesichert\n",
+	"wallet with %d token(s) restored from '%s'\n":                                "Wallet mit %d Token(s) aus '%s' wiederhergestellt\n",
+	"warning: only %d %s token(s) left, please top up your wallet\n":              "Warnung: nur noch %d %s-Token(s) übrig, bitte Wallet aufladen\n",
+	"wrong passphrase, your data is intact: please try again":                     "falsche Passphrase, Ihre Daten sind intakt: bitte erneut versuchen",
+
+	// mutecrypt
+	"%d inconsistent session(s)\n":    "%d inkonsistente Sitzung(en)\n",
+	"orphaned KeyInits: %d\n":         "verwaiste KeyInits: %d\n",
+	"orphaned message keys: %d\n":     "verwaiste Nachrichtenschlüssel: %d\n",
+	"orphaned session keys: %d\n":     "verwaiste Sitzungsschlüssel: %d\n",
+	"orphaned session states: %d\n":   "verwaiste Sitzungszustände: %d\n",
+	"pruned %d session(s)\n":          "%d Sitzung(en) bereinigt\n",
+	"removed %d orphaned record(s)\n": "%d verwaiste Datensätze entfernt\n",
+	"repaired %d session(s)\n":        "%d Sitzung(en) repariert\n",
+	"session %s -> %s established\n":  "Sitzung %s -> %s aufgebaut\n",
+	"session %s -> %s exists\n":       "Sitzung %s -> %s existiert\n",
+}
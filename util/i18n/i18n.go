@@ -0,0 +1,96 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package i18n translates user-facing messages of Mute.
+//
+// Messages are looked up by their English text (usually a format string) in
+// the message catalog of the selected language. Messages which are missing
+// from the catalog are printed in English.
+package i18n
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Catalog maps English messages to their translation.
+type Catalog map[string]string
+
+var (
+	mutex    sync.RWMutex
+	catalogs = map[string]Catalog{
+		"de": german,
+	}
+	current Catalog // nil for English
+)
+
+// Languages returns the supported languages (including English).
+func Languages() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	langs := []string{"en"}
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs[1:])
+	return langs
+}
+
+// normalize reduces the locale name lang (like "de_DE.UTF-8") to the
+// language code ("de").
+func normalize(lang string) string {
+	if i := strings.IndexAny(lang, "_.@-"); i >= 0 {
+		lang = lang[:i]
+	}
+	return strings.ToLower(lang)
+}
+
+// SetLang selects the language lang for all following translations. The empty
+// language and the locales "C" and "POSIX" select English. If lang is not
+// supported, English is selected and an error is returned.
+func SetLang(lang string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	current = nil
+	switch lang = normalize(lang); lang {
+	case "", "en", "c", "posix":
+		return nil
+	}
+	catalog, ok := catalogs[lang]
+	if !ok {
+		return fmt.Errorf("i18n: unsupported language '%s'", lang)
+	}
+	current = catalog
+	return nil
+}
+
+// T returns the translation of the English message msg in the selected
+// language, or msg itself if no translation exists.
+func T(msg string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if translation, ok := current[msg]; ok {
+		return translation
+	}
+	return msg
+}
+
+// Sprintf formats according to the translation of format (see T).
+func Sprintf(format string, a ...interface{}) string {
+	return fmt.Sprintf(T(format), a...)
+}
+
+// Fprintf formats according to the translation of format (see T) and writes
+// to w.
+func Fprintf(w io.Writer, format string, a ...interface{}) (int, error) {
+	return fmt.Fprintf(w, T(format), a...)
+}
+
+// Fprintln writes the translation of msg (see T) followed by a newline to w.
+func Fprintln(w io.Writer, msg string) (int, error) {
+	return fmt.Fprintln(w, T(msg))
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package i18n
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	catalogs["xx"] = Catalog{
+		"message added":            "xx added",
+		"%d message(s) exported\n": "%d xx exported\n",
+	}
+	defer delete(catalogs, "xx")
+	defer SetLang("")
+	if err := SetLang("xx_XX.UTF-8"); err != nil {
+		t.Fatal(err)
+	}
+	// translated
+	if msg := T("message added"); msg != "xx added" {
+		t.Errorf("T() == %q", msg)
+	}
+	var buf bytes.Buffer
+	Fprintf(&buf, "%d message(s) exported\n", 3)
+	if buf.String() != "3 xx exported\n" {
+		t.Errorf("Fprintf() wrote %q", buf.String())
+	}
+	// fallback to English
+	if msg := Sprintf("%d contact(s)", 2); msg != "2 contact(s)" {
+		t.Errorf("Sprintf() == %q", msg)
+	}
+	// English
+	if err := SetLang("C"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := T("message added"); msg != "message added" {
+		t.Errorf("T() == %q", msg)
+	}
+	// unsupported languages fall back to English
+	if err := SetLang("xx"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetLang("yy"); err == nil {
+		t.Error("SetLang() should fail for unsupported language")
+	}
+	if msg := T("message added"); msg != "message added" {
+		t.Errorf("T() == %q", msg)
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	langs := Languages()
+	if len(langs) != 2 || langs[0] != "en" || langs[1] != "de" {
+		t.Errorf("Languages() == %v", langs)
+	}
+}
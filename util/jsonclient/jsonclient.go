@@ -7,13 +7,14 @@ package jsonclient
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 
 	"github.com/gorilla/rpc/v2/json2"
+	"github.com/mutecomm/mute/util/dialer"
 )
 
 var (
@@ -31,18 +32,24 @@ type URLClient struct {
 // certificate file to communicate with the server if the scheme of the URL is
 // https.
 func New(URL string, cert []byte) (*URLClient, error) {
-	var pool *x509.CertPool
-	transport := new(http.Transport)
 	urlparsed, err := url.Parse(URL)
 	if err != nil {
 		return nil, err
 	}
+	var cacert []byte
 	if urlparsed.Scheme == "https" {
-		pool = x509.NewCertPool()
-		if !pool.AppendCertsFromPEM(cert) {
+		if len(cert) == 0 {
 			return nil, ErrCertLoad
 		}
-		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		cacert = cert
+	}
+	// connections are reused across clients (see dialer.PooledTransport)
+	transport, err := dialer.PooledTransport(cacert)
+	if err != nil {
+		if err == dialer.ErrCertLoad {
+			return nil, ErrCertLoad
+		}
+		return nil, err
 	}
 	return &URLClient{transport: transport, curl: URL}, nil
 }
@@ -72,11 +79,13 @@ func (c *URLClient) JSONRPCRequest(method string, args interface{}) (map[string]
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	reply := make(map[string]interface{})
 	err = json2.DecodeClientResponse(resp.Body, &reply)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
+	// read remaining body to allow reuse of the connection
+	io.Copy(ioutil.Discard, resp.Body)
 	return reply, nil
 }
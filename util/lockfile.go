@@ -0,0 +1,90 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mutecomm/mute/log"
+)
+
+// LockFile is an exclusive lock file which records the PID of the process
+// holding it. The lock itself is an advisory lock of the operating system
+// (flock(2) on Unix, LockFileEx on Windows) on the opened file, which is
+// released automatically when the holding process terminates. Therefore, a
+// lock file left behind by a crashed process is stale and can be acquired
+// again.
+type LockFile struct {
+	filename string
+	fp       *os.File
+}
+
+// readLockPID returns the PID recorded in the lock file filename (0, if
+// none is recorded).
+func readLockPID(filename string) int {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// AcquireLock acquires the exclusive lock file filename. If the lock is
+// held by another process, an error naming the process (if recorded) is
+// returned.
+func AcquireLock(filename string) (*LockFile, error) {
+	fp, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, log.Error(err)
+	}
+	if err := lockFile(fp); err != nil {
+		fp.Close()
+		if err != errLocked {
+			return nil, log.Error(err)
+		}
+		pid := readLockPID(filename)
+		if pid == 0 {
+			return nil, log.Errorf("util: locked by lock file '%s'", filename)
+		}
+		return nil, log.Errorf("util: locked by process %d (lock file '%s')",
+			pid, filename)
+	}
+	// record our PID (overwriting a stale one)
+	if err := fp.Truncate(0); err != nil {
+		unlockFile(fp)
+		fp.Close()
+		return nil, log.Error(err)
+	}
+	if _, err := fmt.Fprintf(fp, "%d\n", os.Getpid()); err != nil {
+		unlockFile(fp)
+		fp.Close()
+		return nil, log.Error(err)
+	}
+	return &LockFile{filename: filename, fp: fp}, nil
+}
+
+// Release releases the lock file. The file itself is kept (with the PID
+// removed), deleting it could race with another process opening it.
+func (l *LockFile) Release() error {
+	if err := l.fp.Truncate(0); err != nil {
+		log.Warn(err) // ignore error, the PID is not authoritative
+	}
+	if err := unlockFile(l.fp); err != nil {
+		l.fp.Close()
+		return log.Error(err)
+	}
+	if err := l.fp.Close(); err != nil {
+		return log.Error(err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "util_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := filepath.Join(tmpdir, "lock")
+	lock, err := AcquireLock(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lock is held by this process
+	_, err = AcquireLock(filename)
+	if err == nil {
+		t.Fatal("lock should not be acquired twice")
+	}
+	if !strings.Contains(err.Error(), strconv.Itoa(os.Getpid())) {
+		t.Errorf("error should name the holding process: %s", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = AcquireLock(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+	// stale lock file (not locked by the OS), even if the recorded
+	// process exists
+	err = ioutil.WriteFile(filename, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err = AcquireLock(filename)
+	if err != nil {
+		t.Fatalf("stale lock should be acquired: %s", err)
+	}
+	lock.Release()
+}
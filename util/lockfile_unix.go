@@ -0,0 +1,31 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var errLocked = errors.New("util: file is locked")
+
+// lockFile places a non-blocking exclusive flock(2) on fp.
+func lockFile(fp *os.File) error {
+	err := unix.Flock(int(fp.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFile removes the flock(2) from fp.
+func unlockFile(fp *os.File) error {
+	return unix.Flock(int(fp.Fd()), unix.LOCK_UN)
+}
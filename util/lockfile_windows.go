@@ -0,0 +1,53 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+	errorLockViolation      = syscall.Errno(33)
+)
+
+var (
+	errLocked = errors.New("util: file is locked")
+
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+// lockFile places a non-blocking exclusive LockFileEx lock on the first
+// byte of fp.
+func lockFile(fp *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(fp.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+			return errLocked
+		}
+		return err
+	}
+	return nil
+}
+
+// unlockFile removes the LockFileEx lock from fp.
+func unlockFile(fp *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(fp.Fd(), 0, 1, 0,
+		uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
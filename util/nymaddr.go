@@ -40,15 +40,34 @@ func NewNymAddress(
 	server string,
 	caCert []byte,
 ) (mixaddress, nymaddress string, err error) {
+	mixaddress, nymaddress, _, err = NewNymAddressKey(domain, secret, expire,
+		singleUse, minDelay, maxDelay, id, pubkey, server, caCert)
+	return
+}
+
+// NewNymAddressKey is like NewNymAddress, but additionally returns the
+// (base64 encoded) receiver key, which identifies the nym address in received
+// messages (see mixcrypt.ReceiverPubKey).
+func NewNymAddressKey(
+	domain string,
+	secret []byte,
+	expire int64,
+	singleUse bool,
+	minDelay, maxDelay int32,
+	id string,
+	pubkey *[ed25519.PublicKeySize]byte,
+	server string,
+	caCert []byte,
+) (mixaddress, nymaddress, receiverKey string, err error) {
 	if err := identity.IsMapped(id); err != nil {
-		return "", "", log.Error(err)
+		return "", "", "", log.Error(err)
 	}
 	if MixAddress == "" {
-		return "", "", log.Error("util: MixAddress undefined")
+		return "", "", "", log.Error("util: MixAddress undefined")
 	}
 	mixAddresses, err := client.GetMixKeys(MixAddress, caCert)
 	if err != nil {
-		return "", "", log.Error(err)
+		return "", "", "", log.Error(err)
 	}
 	tmp := nymaddr.AddressTemplate{
 		Secret:        secret,
@@ -59,14 +78,15 @@ func NewNymAddress(
 		MinDelay:      minDelay,
 		MaxDelay:      maxDelay,
 	}
-	nymAddress, err := tmp.NewAddress(MailboxAddress(pubkey, server),
+	nymAddress, recvKey, err := tmp.NewAddressKey(MailboxAddress(pubkey, server),
 		cipher.SHA256([]byte(id)))
 	if err != nil {
-		return "", "", log.Error(err)
+		return "", "", "", log.Error(err)
 	}
 	addr, err := nymaddr.ParseAddress(nymAddress)
 	if err != nil {
-		return "", "", log.Error(err)
+		return "", "", "", log.Error(err)
 	}
-	return string(addr.MixAddress), base64.Encode(nymAddress), nil
+	return string(addr.MixAddress), base64.Encode(nymAddress),
+		base64.Encode(recvKey), nil
 }
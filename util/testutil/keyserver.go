@@ -0,0 +1,335 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/cipher/aes256"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keyserver/capabilities"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/uid"
+)
+
+// keyServerMethods are the methods implemented by the fake key server.
+var keyServerMethods = []string{
+	"KeyRepository.Capabilities",
+	"KeyRepository.CreateUID",
+	"KeyRepository.UpdateUID",
+	"KeyRepository.FetchUID",
+	"KeyHashchain.FetchHashChain",
+	"KeyHashchain.FetchLastHashChain",
+	"KeyHashchain.LookupUID",
+	"KeyInitRepository.AddKeyInit",
+	"KeyInitRepository.FetchKeyInit",
+	"KeyInitRepository.FlushKeyInit",
+}
+
+// A KeyServer is a fake key server for a single domain. It implements the
+// JSON-RPC API described in doc/keyserver.md (without payment tokens, which
+// are ignored) and keeps the key hashchain, the UIDs, and the KeyInit
+// messages in memory. The first hashchain entry is the UID of the key server
+// itself (keyserver@domain).
+type KeyServer struct {
+	domain    string
+	srv       *httptest.Server
+	uidMsg    *uid.Message      // UID of key server
+	sigKey    cipher.Ed25519Key // signature key of key server
+	tknPubKey string            // public wallet key (base64)
+	mutex     sync.Mutex
+	entries   []string                     // hashchain entries
+	lastHash  []byte                       // hash of last hashchain entry
+	positions map[string][]uint64          // identity -> hashchain positions
+	uids      map[string]*uid.Message      // identity -> last UID message
+	replies   map[string]*uid.MessageReply // UIDIndex -> UID message reply
+	keyInits  map[string][]*uid.KeyInit    // SIGKEYHASH -> KeyInit messages
+}
+
+// NewKeyServer starts a new fake key server for the given domain. Use URL to
+// configure it as "keyserver."+domain in the configuration map and CACert as
+// the CA certificate.
+func NewKeyServer(domain string) (*KeyServer, error) {
+	ks := &KeyServer{
+		domain:    domain,
+		lastHash:  make([]byte, sha256.Size),
+		positions: make(map[string][]uint64),
+		uids:      make(map[string]*uid.Message),
+		replies:   make(map[string]*uid.MessageReply),
+		keyInits:  make(map[string][]*uid.KeyInit),
+	}
+	// create UID of key server
+	msg, err := uid.Create("keyserver@"+domain, false, "", "", uid.Strict, "",
+		cipher.RandReader)
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.sigKey.SetPrivateKey(msg.PrivateSigKey64()[:]); err != nil {
+		return nil, err
+	}
+	ks.uidMsg = msg
+	var tknPubKey [32]byte
+	if _, err := io.ReadFull(cipher.RandReader, tknPubKey[:]); err != nil {
+		return nil, err
+	}
+	ks.tknPubKey = base64.Encode(tknPubKey[:])
+	ks.addUID(msg)
+	// start server
+	ks.srv = httptest.NewTLSServer(&rpcHandler{
+		mutex: &ks.mutex,
+		methods: map[string]rpcMethod{
+			"KeyRepository.Capabilities":      ks.capabilities,
+			"KeyRepository.CreateUID":         ks.createUID,
+			"KeyRepository.UpdateUID":         ks.updateUID,
+			"KeyRepository.FetchUID":          ks.fetchUID,
+			"KeyHashchain.FetchHashChain":     ks.fetchHashChain,
+			"KeyHashchain.FetchLastHashChain": ks.fetchLastHashChain,
+			"KeyHashchain.LookupUID":          ks.lookupUID,
+			"KeyInitRepository.AddKeyInit":    ks.addKeyInit,
+			"KeyInitRepository.FetchKeyInit":  ks.fetchKeyInit,
+			"KeyInitRepository.FlushKeyInit":  ks.flushKeyInit,
+		},
+	})
+	return ks, nil
+}
+
+// URL returns the URL of the key server.
+func (ks *KeyServer) URL() string {
+	return ks.srv.URL + "/"
+}
+
+// Close shuts down the key server.
+func (ks *KeyServer) Close() {
+	ks.srv.Close()
+}
+
+// addUID adds the UID message msg to the key repository and the hashchain
+// and returns the signed reply. Must be called with ks.mutex held (or before
+// the server is started).
+func (ks *KeyServer) addUID(msg *uid.Message) *uid.MessageReply {
+	id := msg.Identity()
+	UIDHash, UIDIndex, UIDMessageEncrypted := msg.Encrypt()
+	// create hashchain entry, see doc/keyserver.md#key-hashchain-operation
+	nonce := make([]byte, 8)
+	if _, err := io.ReadFull(cipher.RandReader, nonce); err != nil {
+		panic(err) // cipher.RandReader does not fail
+	}
+	k1, k2 := cipher.CKDF(nonce)
+	hashID := cipher.SHA256(append(append([]byte{}, k1...), id...))
+	idKey := cipher.SHA256(append(append([]byte{}, k2...), id...))
+	crUID := aes256.CBCEncrypt(idKey, UIDHash, cipher.RandReader)
+	var entry []byte
+	entry = append(entry, hashchain.Type...)
+	entry = append(entry, nonce...)
+	entry = append(entry, hashID...)
+	entry = append(entry, crUID...)
+	entry = append(entry, UIDIndex...)
+	hash := cipher.SHA256(append(append([]byte{}, entry...), ks.lastHash...))
+	hcEntry := base64.Encode(append(hash, entry...))
+	pos := uint64(len(ks.entries))
+	ks.entries = append(ks.entries, hcEntry)
+	ks.lastHash = hash
+	// store UID message
+	reply := uid.CreateReply(UIDMessageEncrypted, hcEntry, pos, &ks.sigKey)
+	ks.positions[id] = append(ks.positions[id], pos)
+	ks.uids[id] = msg
+	ks.replies[base64.Encode(UIDIndex)] = reply
+	return reply
+}
+
+func (ks *KeyServer) capabilities(params json.RawMessage) (interface{}, error) {
+	caps := &capabilities.Capabilities{
+		METHODS:               keyServerMethods,
+		DOMAINS:               []string{ks.domain},
+		KEYREPOSITORYURIS:     []string{ks.domain},
+		KEYINITREPOSITORYURIS: []string{ks.domain},
+		KEYHASHCHAINURIS:      []string{ks.domain},
+		KEYHASHCHAINENTRY:     ks.entries[len(ks.entries)-1],
+		TKNPUBKEY:             ks.tknPubKey,
+		SIGPUBKEYS:            []string{ks.uidMsg.SigPubKey()},
+	}
+	return map[string]interface{}{"CAPABILITIES": caps}, nil
+}
+
+type uidArgs struct {
+	UIDMessage *uid.Message
+	Token      string
+}
+
+// checkUID checks the UID message in params for CreateUID and UpdateUID.
+func (ks *KeyServer) checkUID(params json.RawMessage) (*uid.Message, error) {
+	var args uidArgs
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	msg := args.UIDMessage
+	if msg == nil {
+		return nil, errors.New("keyserver: UIDMessage missing")
+	}
+	if err := msg.Check(); err != nil {
+		return nil, err
+	}
+	if msg.Domain() != ks.domain {
+		return nil, errors.New("keyserver: wrong domain")
+	}
+	if err := msg.VerifySelfSig(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (ks *KeyServer) createUID(params json.RawMessage) (interface{}, error) {
+	msg, err := ks.checkUID(params)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ks.uids[msg.Identity()]; ok {
+		return nil, errors.New("keyserver: identity already registered")
+	}
+	reply := ks.addUID(msg)
+	return map[string]interface{}{"UIDMessageReply": reply}, nil
+}
+
+func (ks *KeyServer) updateUID(params json.RawMessage) (interface{}, error) {
+	msg, err := ks.checkUID(params)
+	if err != nil {
+		return nil, err
+	}
+	preMsg, ok := ks.uids[msg.Identity()]
+	if !ok {
+		return nil, errors.New("keyserver: identity not registered")
+	}
+	if err := msg.VerifyUserSig(preMsg); err != nil {
+		return nil, err
+	}
+	reply := ks.addUID(msg)
+	return map[string]interface{}{"UIDMessageReply": reply}, nil
+}
+
+func (ks *KeyServer) fetchUID(params json.RawMessage) (interface{}, error) {
+	var args struct{ UIDIndex string }
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	reply, ok := ks.replies[args.UIDIndex]
+	if !ok {
+		return nil, errors.New("keyserver: UID not found")
+	}
+	return map[string]interface{}{"UIDMessageReply": reply}, nil
+}
+
+func (ks *KeyServer) fetchHashChain(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		StartPosition uint64
+		EndPosition   uint64
+	}
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	last := uint64(len(ks.entries) - 1)
+	start := args.StartPosition
+	if start > last {
+		start = last
+	}
+	end := args.EndPosition
+	if end < start {
+		end = start
+	}
+	if end > last {
+		end = last
+	}
+	return map[string]interface{}{
+		"HCEntries":  ks.entries[start : end+1],
+		"HCFirstPos": start,
+	}, nil
+}
+
+func (ks *KeyServer) fetchLastHashChain(params json.RawMessage) (interface{}, error) {
+	pos := len(ks.entries) - 1
+	return map[string]interface{}{
+		"HCEntry": ks.entries[pos],
+		"HCPos":   pos,
+	}, nil
+}
+
+func (ks *KeyServer) lookupUID(params json.RawMessage) (interface{}, error) {
+	var args struct{ Identity string }
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	positions, ok := ks.positions[args.Identity]
+	if !ok {
+		return nil, errors.New("keyserver: identity not found")
+	}
+	return map[string]interface{}{"HCPositions": positions}, nil
+}
+
+func (ks *KeyServer) addKeyInit(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		SigPubKey string
+		KeyInits  []*uid.KeyInit
+		Tokens    []string
+	}
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	var sigs []string
+	for _, ki := range args.KeyInits {
+		// also checks that SIGKEYHASH matches SigPubKey
+		if err := ki.Verify([]string{ks.domain}, args.SigPubKey); err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, ki.Sign(&ks.sigKey))
+	}
+	for _, ki := range args.KeyInits {
+		ks.keyInits[ki.SigKeyHash()] = append(ks.keyInits[ki.SigKeyHash()], ki)
+	}
+	return map[string]interface{}{"Signatures": sigs}, nil
+}
+
+func (ks *KeyServer) fetchKeyInit(params json.RawMessage) (interface{}, error) {
+	var args struct{ SigKeyHash string }
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	kis := ks.keyInits[args.SigKeyHash]
+	if len(kis) == 0 {
+		return nil, errors.New("keyserver: no KeyInit found")
+	}
+	// KeyInit messages are used only once (unless they are fallbacks)
+	ki := kis[0]
+	if !ki.Contents.FALLBACK {
+		ks.keyInits[args.SigKeyHash] = kis[1:]
+	}
+	return map[string]interface{}{"KeyInit": string(ki.JSON())}, nil
+}
+
+func (ks *KeyServer) flushKeyInit(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		SigPubKey string
+		Nonce     uint64
+		Signature string
+	}
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	if err := uid.VerifyNonce(args.SigPubKey, args.Nonce, args.Signature); err != nil {
+		return nil, err
+	}
+	// SIGKEYHASH = SHA512(SHA512(SigPubKey))
+	pubKey, err := base64.Decode(args.SigPubKey)
+	if err != nil {
+		return nil, err
+	}
+	sigKeyHash := base64.Encode(cipher.SHA512(cipher.SHA512(pubKey)))
+	delete(ks.keyInits, sigKeyHash)
+	return map[string]interface{}{"Result": true}, nil
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/mix/client"
+	"github.com/mutecomm/mute/mix/mixaddr"
+	"github.com/mutecomm/mute/serviceguard/common/walletauth"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// errNothingFound is returned by ListMessages if there are no (new) messages,
+// like the real account server does.
+var errNothingFound = errors.New("accountdb: Nothing found")
+
+// mixMessage is a message stored in an account of the fake mix.
+type mixMessage struct {
+	id              []byte
+	receiveTime     int64
+	receiveTimeNano int64
+	readTime        int64
+	body            []byte
+}
+
+// A Mix is a fake mix together with its account server. It serves the mix
+// keys (/keys), the account server JSON-RPC API (/account), and message
+// downloads (/message) and keeps the accounts in memory. Messages are not
+// routed through the mix, they are put directly into an account with
+// Deliver.
+type Mix struct {
+	address  string
+	srv      *httptest.Server
+	keys     []byte // JSON encoded mixaddr.AddressStatement
+	mutex    sync.Mutex
+	accounts map[[ed25519.PublicKeySize]byte][]*mixMessage
+}
+
+// NewMix starts a new fake mix with the given mix address (for example,
+// "mix@mute.berlin"). To use it with mix/client, set client.GetMixAddress to
+// return Addr and redirect client.DefaultClientFactory to Addr.
+func NewMix(address string) (*Mix, error) {
+	m := &Mix{
+		address:  address,
+		accounts: make(map[[ed25519.PublicKeySize]byte][]*mixMessage),
+	}
+	_, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		return nil, err
+	}
+	var priv [ed25519.PrivateKeySize]byte
+	copy(priv[:], privkey)
+	var pubkey [32]byte
+	if _, err := io.ReadFull(cipher.RandReader, pubkey[:]); err != nil {
+		return nil, err
+	}
+	// mix keys must be valid longer than the nym addresses created for them
+	addresses := mixaddr.AddressList{{
+		Pubkey:  pubkey[:],
+		Expire:  times.Now() + 60*int64(times.Day),
+		Address: address,
+	}}
+	m.keys, err = json.Marshal(addresses.Statement(&priv))
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(m.keys)
+	})
+	mux.Handle("/account", &rpcHandler{
+		mutex: &m.mutex,
+		methods: map[string]rpcMethod{
+			"AccountServer.AccountStat":  m.accountStat,
+			"AccountServer.ListMessages": m.listMessages,
+		},
+	})
+	mux.HandleFunc("/message", m.fetchMessage)
+	m.srv = httptest.NewTLSServer(mux)
+	return m, nil
+}
+
+// Addr returns the network address (host:port) of the mix.
+func (m *Mix) Addr() string {
+	return m.srv.Listener.Addr().String()
+}
+
+// Close shuts down the mix.
+func (m *Mix) Close() {
+	m.srv.Close()
+}
+
+// Deliver puts the message msg into the account identified by pubkey.
+func (m *Mix) Deliver(pubkey *[ed25519.PublicKeySize]byte, msg []byte) error {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(cipher.RandReader, id); err != nil {
+		return err
+	}
+	now := times.NowNano()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.accounts[*pubkey] = append(m.accounts[*pubkey], &mixMessage{
+		id:              id,
+		receiveTime:     now / 1000000000,
+		receiveTimeNano: now,
+		body:            msg,
+	})
+	return nil
+}
+
+// checkToken decodes and checks the base64 encoded walletauth token and
+// returns the public key of the account.
+func checkToken(authToken string) (*[ed25519.PublicKeySize]byte, error) {
+	token, err := base64.StdEncoding.DecodeString(authToken)
+	if err != nil {
+		return nil, err
+	}
+	pubkey, _, _, err := walletauth.AuthToken(token).CheckToken()
+	if err != nil {
+		return nil, err
+	}
+	return pubkey, nil
+}
+
+func (m *Mix) accountStat(params json.RawMessage) (interface{}, error) {
+	var args struct{ AuthToken string }
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	if _, err := checkToken(args.AuthToken); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"LoadTime": times.Now() + 30*int64(times.Day),
+	}, nil
+}
+
+func (m *Mix) listMessages(params json.RawMessage) (interface{}, error) {
+	var args struct {
+		AuthToken       string
+		LastReceiveTime int64
+	}
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+	pubkey, err := checkToken(args.AuthToken)
+	if err != nil {
+		return nil, err
+	}
+	var messages []map[string]interface{}
+	for _, msg := range m.accounts[*pubkey] {
+		if msg.receiveTime <= args.LastReceiveTime {
+			continue
+		}
+		messages = append(messages, map[string]interface{}{
+			"MessageID":       hex.EncodeToString(msg.id),
+			"ReceiveTime":     msg.receiveTime,
+			"ReceiveTimeNano": msg.receiveTimeNano,
+			"ReadTime":        msg.readTime,
+			"UserKey":         hex.EncodeToString(pubkey[:]),
+		})
+	}
+	if len(messages) == 0 {
+		return nil, errNothingFound
+	}
+	return map[string]interface{}{"Messages": messages}, nil
+}
+
+func (m *Mix) fetchMessage(w http.ResponseWriter, r *http.Request) {
+	pubkey, err := checkToken(r.FormValue("authtoken"))
+	if err != nil {
+		io.WriteString(w, "ERROR: "+err.Error())
+		return
+	}
+	id, err := hex.DecodeString(r.FormValue("messageid"))
+	if err != nil {
+		io.WriteString(w, "ERROR: "+err.Error())
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, msg := range m.accounts[*pubkey] {
+		if bytes.Equal(msg.id, id) {
+			msg.readTime = times.Now()
+			w.Write(client.WriteMail(m.address, hex.EncodeToString(pubkey[:]),
+				msg.body))
+			return
+		}
+	}
+	io.WriteString(w, "ERROR: message not found")
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/mutecomm/mute/cipher"
+)
+
+// errInsufficientFunds is returned by the wallet server of the fake service
+// guard for every token request, like the real wallet server does for an
+// empty wallet.
+var errInsufficientFunds = errors.New("walletserver: insufficient funds")
+
+// A ServiceGuard is a fake service guard for an empty wallet. It implements
+// the key lookup service (PublicService.VerifyKeys, signed by the key
+// returned by TrustRoot) and the wallet server, which reports a zero balance
+// and answers every token request with "insufficient funds". Use URL as
+// "keylookup.ServiceURL" and "walletrpc.ServiceURL" and TrustRoot as
+// "serviceguard.TrustRoot" in the configuration map and CACert as the CA
+// certificate.
+type ServiceGuard struct {
+	srv       *httptest.Server
+	trustRoot ed25519.PublicKey
+	keyList   string // list of issuer keys (hex)
+	signature []byte // signature of keyList by trust root
+}
+
+// NewServiceGuard starts a new fake service guard.
+func NewServiceGuard() (*ServiceGuard, error) {
+	trustRoot, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		return nil, err
+	}
+	issuer, _, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		return nil, err
+	}
+	sg := &ServiceGuard{
+		trustRoot: trustRoot,
+		keyList:   hex.EncodeToString(issuer),
+	}
+	sg.signature = ed25519.Sign(privkey, []byte(sg.keyList))
+	sg.srv = httptest.NewTLSServer(&rpcHandler{
+		mutex: new(sync.Mutex),
+		methods: map[string]rpcMethod{
+			"PublicService.VerifyKeys": sg.verifyKeys,
+			"WalletServer.GetBalance":  sg.getBalance,
+			"WalletServer.GetToken":    sg.getToken,
+		},
+	})
+	return sg, nil
+}
+
+// URL returns the URL of the service guard.
+func (sg *ServiceGuard) URL() string {
+	return sg.srv.URL + "/"
+}
+
+// TrustRoot returns the signature key of the key lookup service (hex).
+func (sg *ServiceGuard) TrustRoot() string {
+	return hex.EncodeToString(sg.trustRoot)
+}
+
+// Close shuts down the service guard.
+func (sg *ServiceGuard) Close() {
+	sg.srv.Close()
+}
+
+func (sg *ServiceGuard) verifyKeys(params json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"PublicKey": sg.TrustRoot(),
+		"Signature": hex.EncodeToString(sg.signature),
+		"KeyList":   sg.keyList,
+	}, nil
+}
+
+func (sg *ServiceGuard) getBalance(params json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"SubscriptionTokens": 0,
+		"PrepayTokens":       0,
+		"LastSubscribeLoad":  0,
+	}, nil
+}
+
+func (sg *ServiceGuard) getToken(params json.RawMessage) (interface{}, error) {
+	return nil, errInsufficientFunds
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package testutil implements in-process fakes of the Mute servers (key
+// server, mix, and service guard) for tests. The fakes keep their state in memory and are
+// served via HTTPS with net/http/httptest. All fakes use the same TLS
+// certificate, which is returned by CACert.
+package testutil
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// CACert returns the PEM encoded CA certificate of the TLS servers started
+// by the fakes (which has to be used as def.CACert).
+func CACert() []byte {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	})
+}
+
+// rpcMethod is a JSON-RPC method which decodes its arguments from params.
+type rpcMethod func(params json.RawMessage) (interface{}, error)
+
+// rpcHandler is a minimal JSON-RPC 2.0 server (as spoken by
+// util/jsonclient) which dispatches requests to the registered methods.
+type rpcHandler struct {
+	mutex   *sync.Mutex // serializes all method calls
+	methods map[string]rpcMethod
+}
+
+type rpcRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	ID     interface{}     `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	Version string      `json:"jsonrpc"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *rpcError   `json:"error,omitempty"`
+	ID      interface{} `json:"id"`
+}
+
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := rpcResponse{Version: "2.0", ID: req.ID}
+	method, ok := h.methods[req.Method]
+	if !ok {
+		resp.Error = &rpcError{
+			Code:    -32601,
+			Message: "rpc: can't find method " + req.Method,
+		}
+	} else {
+		h.mutex.Lock()
+		result, err := method(req.Params)
+		h.mutex.Unlock()
+		if err != nil {
+			resp.Error = &rpcError{Code: -32000, Message: err.Error()}
+		} else {
+			resp.Result = result
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/mutecomm/mute/cipher"
+	"github.com/mutecomm/mute/cipher/aes256"
+	"github.com/mutecomm/mute/encode/base64"
+	"github.com/mutecomm/mute/keyserver/hashchain"
+	"github.com/mutecomm/mute/mix/client"
+	"github.com/mutecomm/mute/serviceguard/client/keylookup"
+	"github.com/mutecomm/mute/serviceguard/client/walletrpc"
+	"github.com/mutecomm/mute/uid"
+	"github.com/mutecomm/mute/util/jsonclient"
+	"github.com/mutecomm/mute/util/times"
+)
+
+// decodeReply converts the JSON-RPC result value into v.
+func decodeReply(t *testing.T, value interface{}, v interface{}) {
+	jsn, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(jsn, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyServer(t *testing.T) {
+	ks, err := NewKeyServer("mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+	c, err := jsonclient.New(ks.URL(), CACert())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := c.JSONRPCRequest("KeyRepository.Capabilities", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caps struct {
+		CAPABILITIES struct {
+			KEYHASHCHAINENTRY string
+			SIGPUBKEYS        []string
+		}
+	}
+	decodeReply(t, reply, &caps)
+	srvPubKey := caps.CAPABILITIES.SIGPUBKEYS[0]
+
+	// register
+	a := "alice@mute.berlin"
+	msg, err := uid.Create(a, false, "", "", uid.Strict,
+		caps.CAPABILITIES.KEYHASHCHAINENTRY, cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err = c.JSONRPCRequest("KeyRepository.CreateUID",
+		map[string]interface{}{"UIDMessage": msg, "Token": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct{ UIDMessageReply *uid.MessageReply }
+	decodeReply(t, reply, &created)
+	if err := created.UIDMessageReply.VerifySrvSig(msg, srvPubKey); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.JSONRPCRequest("KeyRepository.CreateUID",
+		map[string]interface{}{"UIDMessage": msg, "Token": ""})
+	if err == nil {
+		t.Error("registering the same identity twice should fail")
+	}
+	now := uint64(times.Now())
+	ki, _, _, err := msg.KeyInit(1, now+times.Day, now-times.Day, false,
+		"mute.berlin", "", "", cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err = c.JSONRPCRequest("KeyInitRepository.AddKeyInit",
+		map[string]interface{}{
+			"SigPubKey": msg.SigPubKey(),
+			"KeyInits":  []*uid.KeyInit{ki},
+			"Tokens":    []string{""},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added struct{ Signatures []string }
+	decodeReply(t, reply, &added)
+	if len(added.Signatures) != 1 {
+		t.Fatalf("got %d KeyInit signatures, want 1", len(added.Signatures))
+	}
+	if err := ki.VerifySrvSig(added.Signatures[0], srvPubKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// lookup
+	reply, err = c.JSONRPCRequest("KeyHashchain.LookupUID",
+		map[string]interface{}{"Identity": a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var positions struct{ HCPositions []uint64 }
+	decodeReply(t, reply, &positions)
+	if len(positions.HCPositions) != 1 || positions.HCPositions[0] != 1 {
+		t.Fatalf("wrong hashchain positions: %v", positions.HCPositions)
+	}
+	reply, err = c.JSONRPCRequest("KeyHashchain.FetchHashChain",
+		map[string]interface{}{"StartPosition": 0, "EndPosition": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chain struct{ HCEntries []string }
+	decodeReply(t, reply, &chain)
+	if len(chain.HCEntries) != 2 {
+		t.Fatalf("got %d hashchain entries, want 2", len(chain.HCEntries))
+	}
+	if chain.HCEntries[0] != caps.CAPABILITIES.KEYHASHCHAINENTRY {
+		t.Error("first hashchain entry differs from capabilities")
+	}
+	_, _, nonce, _, crUID, uidIndex, err := hashchain.SplitEntry(chain.HCEntries[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, k2 := cipher.CKDF(nonce)
+	idKey := cipher.SHA256(append(append([]byte{}, k2...), a...))
+	reply, err = c.JSONRPCRequest("KeyRepository.FetchUID",
+		map[string]interface{}{"UIDIndex": base64.Encode(uidIndex)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetched struct{ UIDMessageReply *uid.MessageReply }
+	decodeReply(t, reply, &fetched)
+	index, lookedUp, err := fetched.UIDMessageReply.Decrypt(aes256.CBCDecrypt(idKey, crUID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(index, uidIndex) {
+		t.Error("UIDIndex mismatch")
+	}
+	if lookedUp.SigPubKey() != msg.SigPubKey() {
+		t.Error("looked up UID differs from registered one")
+	}
+	sigKeyHash, err := lookedUp.SigKeyHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err = c.JSONRPCRequest("KeyInitRepository.FetchKeyInit",
+		map[string]interface{}{"SigKeyHash": sigKeyHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyInit struct{ KeyInit string }
+	decodeReply(t, reply, &keyInit)
+	if keyInit.KeyInit != string(ki.JSON()) {
+		t.Error("fetched KeyInit differs from added one")
+	}
+}
+
+func TestMix(t *testing.T) {
+	m, err := NewMix("mix@mute.berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	host, port, err := net.SplitHostPort(m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcPort := client.RPCPort
+	client.RPCPort = port
+	defer func() { client.RPCPort = rpcPort }()
+	cacert := CACert()
+
+	pubkey, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub [ed25519.PublicKeySize]byte
+	copy(pub[:], pubkey)
+	var priv [ed25519.PrivateKeySize]byte
+	copy(priv[:], privkey)
+	if _, err := client.ListMessages(&priv, 0, host, cacert); err == nil {
+		t.Error("listing an empty account should fail")
+	}
+	msg := []byte("a mix message")
+	if err := m.Deliver(&pub, msg); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := client.ListMessages(&priv, 0, host, cacert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	body, err := client.FetchMessage(&priv, messages[0].MessageID, host, cacert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, msg) {
+		t.Errorf("fetched message %q, want %q", body, msg)
+	}
+}
+
+func TestServiceGuard(t *testing.T) {
+	sg, err := NewServiceGuard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sg.Close()
+	cacert := CACert()
+	lookupURL := keylookup.ServiceURL
+	walletURL := walletrpc.ServiceURL
+	keylookup.ServiceURL = sg.URL()
+	walletrpc.ServiceURL = sg.URL()
+	defer func() {
+		keylookup.ServiceURL = lookupURL
+		walletrpc.ServiceURL = walletURL
+	}()
+
+	trustRoot, err := hex.DecodeString(sg.TrustRoot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk [ed25519.PublicKeySize]byte
+	copy(pk[:], trustRoot)
+	keys, err := keylookup.New(nil, cacert, &pk).GetVerifyList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("got %d verify keys, want 1", len(keys))
+	}
+
+	pubkey, privkey, err := ed25519.GenerateKey(cipher.RandReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub [ed25519.PublicKeySize]byte
+	copy(pub[:], pubkey)
+	var priv [ed25519.PrivateKeySize]byte
+	copy(priv[:], privkey)
+	wc := walletrpc.New(&pub, &priv, cacert)
+	sub, prepay, _, err := wc.GetBalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub != 0 || prepay != 0 {
+		t.Errorf("wallet not empty: %d/%d", sub, prepay)
+	}
+	_, _, _, err = wc.GetToken("Message")
+	if err == nil || err.Error() != errInsufficientFunds.Error() {
+		t.Errorf("GetToken should fail with insufficient funds: %v", err)
+	}
+}
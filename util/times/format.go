@@ -0,0 +1,78 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package times
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Format defines how times are printed for the user.
+type Format string
+
+const (
+	// RFC3339 prints times in RFC 3339 format in UTC (the default).
+	RFC3339 Format = "rfc3339"
+	// Unix prints times as the number of seconds since January 1, 1970 UTC.
+	Unix Format = "unix"
+	// Relative prints times relative to now (e.g., "3h ago" or "in 2d").
+	Relative Format = "relative"
+)
+
+// Formats lists all supported time formats.
+var Formats = []Format{RFC3339, Unix, Relative}
+
+// ParseFormat returns the time format with the given name.
+func ParseFormat(name string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("times: unknown time format '%s'", name)
+}
+
+// Sprint returns the Unix time t formatted in format f. Relative times are
+// computed with respect to the Unix time now. The empty format is treated as
+// RFC3339.
+func (f Format) Sprint(t, now int64) string {
+	switch f {
+	case Unix:
+		return strconv.FormatInt(t, 10)
+	case Relative:
+		return relative(t, now)
+	default:
+		return time.Unix(t, 0).UTC().Format(time.RFC3339)
+	}
+}
+
+// relative returns the duration between t and now in the largest unit which
+// fits (truncated), like "3h ago" for past and "in 3h" for future times.
+func relative(t, now int64) string {
+	d := now - t
+	if d == 0 {
+		return "now"
+	}
+	future := d < 0
+	if future {
+		d = -d
+	}
+	var s string
+	switch {
+	case d < 60:
+		s = fmt.Sprintf("%ds", d)
+	case d < 60*60:
+		s = fmt.Sprintf("%dm", d/60)
+	case d < int64(Day):
+		s = fmt.Sprintf("%dh", d/(60*60))
+	default:
+		s = fmt.Sprintf("%dd", d/int64(Day))
+	}
+	if future {
+		return "in " + s
+	}
+	return s + " ago"
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2016 Mute Communications Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package times
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	const ts = 1451606400 // 2016-01-01T00:00:00Z
+	tests := []struct {
+		format Format
+		t, now int64
+		out    string
+	}{
+		{RFC3339, ts, ts, "2016-01-01T00:00:00Z"},
+		{"", ts, ts, "2016-01-01T00:00:00Z"},
+		{Unix, ts, ts, "1451606400"},
+		{Relative, ts, ts, "now"},
+		{Relative, ts, ts + 42, "42s ago"},
+		{Relative, ts, ts + 5*60 + 59, "5m ago"},
+		{Relative, ts, ts + 3*60*60 + 1, "3h ago"},
+		{Relative, ts, ts + 2*int64(Day), "2d ago"},
+		{Relative, ts + 90*60, ts, "in 1h"},
+	}
+	for _, test := range tests {
+		out := test.format.Sprint(test.t, test.now)
+		if out != test.out {
+			t.Errorf("%q.Sprint(%d, %d) == %q != %q", test.format, test.t,
+				test.now, out, test.out)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range Formats {
+		p, err := ParseFormat(string(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != f {
+			t.Errorf("ParseFormat(%q) == %q", f, p)
+		}
+	}
+	if _, err := ParseFormat("iso"); err == nil {
+		t.Error("ParseFormat should fail for unknown format")
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/mutecomm/mute/log"
 	"golang.org/x/crypto/ssh/terminal"
@@ -65,3 +67,28 @@ func ContainsString(sa []string, s string) bool {
 	}
 	return false
 }
+
+// WouldDelete reports on w what a destructive command run with --dry-run
+// would delete: n records of the given kind (like "message(s)"), identified
+// by ids. ids can be empty, if the records have no meaningful identifiers.
+func WouldDelete(w io.Writer, kind string, n int64, ids ...string) {
+	fmt.Fprintf(w, "would delete %d %s\n", n, kind)
+	for _, id := range ids {
+		fmt.Fprintf(w, "\t%s\n", id)
+	}
+}
+
+// ConfirmPhrase asks on w to type the given phrase (which should echo the
+// scope of a bulk destructive operation, like "delete 342 messages") and reads
+// the answer line from r. It returns true, if the answer matches phrase.
+func ConfirmPhrase(r io.Reader, w io.Writer, phrase string) (bool, error) {
+	fmt.Fprintf(w, "type '%s' to confirm: ", phrase)
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return false, log.Error(err)
+		}
+		return false, nil
+	}
+	return strings.TrimSpace(scanner.Text()) == phrase, nil
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/jpillora/backoff"
 	"github.com/mutecomm/mute/def"
+	"github.com/mutecomm/mute/encode/base64"
 	"github.com/mutecomm/mute/log"
 	"github.com/mutecomm/mute/serviceguard/client"
 )
@@ -53,3 +54,72 @@ func GetToken(
 	}
 	return token, nil
 }
+
+// SpendStore records the tokens of in-flight spends (see GetTokenOnce).
+type SpendStore interface {
+	AddValue(key, value string) error
+	GetValue(key string) (string, error)
+	DelValue(key string) error
+}
+
+// spendPrefix is the prefix of the SpendStore keys of in-flight spends.
+const spendPrefix = "spend."
+
+// GetTokenOnce returns a token for the given usage and owner from
+// walletClient to be spent by the operation identified by the idempotency
+// key. The token is recorded as in-flight in spends until EndSpend is called.
+// If a previous attempt of the operation failed without knowing whether the
+// token was consumed, the token reserved back then is returned again instead
+// of a new one.
+func GetTokenOnce(
+	walletClient *client.Client,
+	spends SpendStore,
+	key, usage string,
+	owner *[ed25519.PublicKeySize]byte,
+) (*client.TokenEntry, error) {
+	hash, err := spends.GetValue(spendPrefix + key)
+	if err != nil {
+		return nil, err
+	}
+	if hash != "" {
+		tokenHash, err := base64.Decode(hash)
+		if err != nil {
+			return nil, err
+		}
+		token, err := walletClient.GetReservedToken(tokenHash)
+		if err == nil {
+			log.Infof("WalletGetTokenOnce(): reuse reserved token for %s", key)
+			return token, nil
+		}
+		// token is gone -> reserve a new one
+		log.Warnf("WalletGetTokenOnce(): reserved token for %s gone: %s", key,
+			err)
+	}
+	token, err := GetToken(walletClient, usage, owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := spends.AddValue(spendPrefix+key, base64.Encode(token.Hash)); err != nil {
+		walletClient.UnlockToken(token.Hash)
+		return nil, err
+	}
+	return token, nil
+}
+
+// EndSpend ends the in-flight spend of token by the operation identified by
+// the idempotency key (see GetTokenOnce). The token is deleted from
+// walletClient, if it was spent, or unlocked otherwise.
+func EndSpend(
+	walletClient *client.Client,
+	spends SpendStore,
+	key string,
+	token *client.TokenEntry,
+	spent bool,
+) error {
+	if spent {
+		walletClient.DelToken(token.Hash)
+	} else {
+		walletClient.UnlockToken(token.Hash)
+	}
+	return spends.DelValue(spendPrefix + key)
+}